@@ -0,0 +1,208 @@
+// Package capture records audio from a Source. The browser source records
+// what a web page plays: it creates a PulseAudio null sink of its own, opens
+// the page in Firefox with its audio going to the sink, and reads the sink's
+// monitor with parec. Other sources record a PulseAudio or ALSA device, read
+// a file or generate a tone, and more can be registered.
+//
+//	source, err := capture.Open(ctx, "browser", capture.Options{Input: "https://example.com/"})
+//	if err != nil {
+//		return err
+//	}
+//	defer source.Close()
+//	io.Copy(w, source.Audio()) // Big-endian 16-bit PCM
+package capture
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	sinkSettle  = 2 * time.Second // How long a new sink is given to initialize before it is used
+	stopTimeout = 3 * time.Second // How long a process has to exit after SIGTERM before it is killed
+)
+
+// Options configure a source.
+type Options struct {
+	Input      string // What to capture, which depends on the source, e.g. the URL of the page to play
+	SampleRate int    // Of the audio; 48000 by default
+	Channels   int    // 1 by default
+	Log        *slog.Logger
+
+	// PulseServer is the PulseAudio server the browser's sink is created on
+	// and the pulse source records from, e.g. tcp:192.0.2.7:4713 for one on
+	// another machine or in a container; see ValidatePulseServer. When empty
+	// it is the one PULSE_SERVER names, or the local daemon.
+	PulseServer string
+
+	// PinVolume keeps the browser's streams on its sink at 100% and
+	// unmuted, setting them again whenever the page turns them down. The
+	// sink itself is always set to 100% and unmuted.
+	PinVolume bool
+}
+
+// Session is a page playing into a sink of its own and the parec process
+// recording it, from NewSession.
+type Session struct {
+	sink       string
+	module     string // Index of the sink's PulseAudio module
+	profileDir string
+	firefox    *exec.Cmd
+	parec      *exec.Cmd
+	audio      io.ReadCloser
+	cancel     context.CancelFunc // Stops firefox and parec
+	server     string             // PulseAudio server the sink is on; the default one when empty
+
+	pulseLog, firefoxLog *slog.Logger
+}
+
+// NewSession creates the sink, launches Firefox on the page at opts.Input
+// and starts recording. When it fails, whatever it had set up is removed
+// again. Firefox and parec are stopped when ctx is done, which ends the
+// audio; Close still has to be called to remove the sink and the profile.
+func NewSession(ctx context.Context, opts Options) (_ *Session, err error) {
+	opts = opts.withDefaults()
+	ctx, cancel := context.WithCancel(ctx)
+	s := &Session{
+		cancel:     cancel,
+		sink:       fmt.Sprintf("rtp-stream-%d", rand.Intn(100000)),
+		server:     opts.PulseServer,
+		pulseLog:   opts.Log.With("component", "pulse"),
+		firefoxLog: opts.Log.With("component", "firefox"),
+	}
+	if s.server != "" {
+		s.pulseLog = s.pulseLog.With("server", s.server)
+	}
+	defer func() {
+		if err != nil {
+			s.Close()
+		}
+	}()
+
+	// Create a unique virtual PulseAudio sink for this session
+	s.pulseLog.Info("🎧 Creating PulseAudio sink", "sink", s.sink)
+	moduleIndex, err := pactl(s.server, "load-module", "module-null-sink", fmt.Sprintf("sink_name=%s", s.sink))
+	if err != nil {
+		if s.server != "" {
+			return nil, fmt.Errorf("creating PulseAudio sink on %s failed; make sure it is reachable and lets this client in: %w", s.server, err)
+		}
+		return nil, fmt.Errorf("creating PulseAudio sink failed; make sure PulseAudio is running: %w", err)
+	}
+	s.module = strings.TrimSpace(string(moduleIndex))
+
+	// TODO: Mmmm Si creo un profile nuevo, Firefox no arranca youtube...
+
+	// Create a temporary Firefox profile in the user's home directory to avoid Snap confinement issues.
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return nil, fmt.Errorf("getting the home directory failed: %w", err)
+	}
+	if s.profileDir, err = os.MkdirTemp(homeDir, "firefox-profile-*"); err != nil {
+		return nil, fmt.Errorf("creating a temporary profile directory failed: %w", err)
+	}
+	s.firefoxLog.Info("🦊 Created temporary Firefox profile", "dir", s.profileDir)
+
+	// Add a delay to allow the sink to initialize fully before use.
+	s.pulseLog.Info("⏳ Waiting for PulseAudio sink to initialize")
+	select {
+	case <-time.After(sinkSettle):
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	s.setFullVolume()
+
+	// Launch Firefox in a new, isolated instance, directing its audio to our sink
+	s.firefoxLog.Info("🚀 Launching isolated Firefox instance", "url", opts.Input)
+	//	firefoxCmd := exec.Command("firefox", "--new-instance", "--profile", profileDir, "--new-window", url)
+	firefox := command(ctx, "firefox", "--new-instance", "--new-window", opts.Input)
+	firefox.Env = append(os.Environ(), fmt.Sprintf("PULSE_SINK=%s", s.sink))
+	if s.server != "" {
+		firefox.Env = append(firefox.Env, "PULSE_SERVER="+s.server)
+	}
+	if err := firefox.Start(); err != nil {
+		return nil, fmt.Errorf("starting Firefox failed: %w", err)
+	}
+	s.firefox = firefox
+	go s.watchStreams(ctx, opts.PinVolume)
+
+	// Start audio capture from the new sink's monitor
+	device := s.sink + ".monitor"
+	s.pulseLog.Info("🎤 Starting audio capture", "source", device)
+	parec := command(ctx, "parec", pulseArgs(s.server, "--format=s16be", fmt.Sprintf("--rate=%d", opts.SampleRate), fmt.Sprintf("--channels=%d", opts.Channels), fmt.Sprintf("--device=%s", device))...)
+	stdout, err := parec.StdoutPipe()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get stdout pipe from parec: %w", err)
+	}
+	stderr, err := parec.StderrPipe()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get stderr pipe from parec: %w", err)
+	}
+	if err := parec.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start parec: %w", err)
+	}
+	s.parec, s.audio = parec, stdout
+
+	// Goroutine to log any errors from parec
+	go func() {
+		scanner := bufio.NewScanner(stderr)
+		for scanner.Scan() {
+			s.pulseLog.Warn("parec", "stderr", scanner.Text())
+		}
+	}()
+	return s, nil
+}
+
+// Audio returns the recorded audio, big-endian 16-bit PCM at the rate and
+// channels of the options. It ends when the session is closed.
+func (s *Session) Audio() io.Reader { return s.audio }
+
+// Close stops Firefox and parec and removes the sink and the profile.
+func (s *Session) Close() error {
+	s.cancel()
+	if s.firefox != nil {
+		s.firefoxLog.Info("🔥 Terminating Firefox")
+		s.firefox.Wait()
+	}
+	if s.parec != nil {
+		s.pulseLog.Info("🔥 Terminating PulseAudio recorder (parec)")
+		s.parec.Wait()
+	}
+
+	var err error
+	if s.module != "" {
+		s.pulseLog.Info("🎧 Unloading PulseAudio module", "module", s.module)
+		if _, convErr := strconv.Atoi(s.module); convErr == nil {
+			if _, err = pactl(s.server, "unload-module", s.module); err != nil {
+				s.pulseLog.Warn("Unloading PulseAudio module failed", "module", s.module, "err", err)
+			}
+		}
+	}
+	if s.profileDir != "" {
+		s.firefoxLog.Info("🦊 Removing temporary Firefox profile", "dir", s.profileDir)
+		if rmErr := os.RemoveAll(s.profileDir); rmErr != nil {
+			s.firefoxLog.Warn("Removing the profile directory failed", "dir", s.profileDir, "err", rmErr)
+			err = rmErr
+		}
+	}
+	return err
+}
+
+// command returns a command for name that is stopped when ctx is done: it is
+// sent SIGTERM, so it can exit cleanly, and killed if it hasn't exited after
+// stopTimeout.
+func command(ctx context.Context, name string, args ...string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Cancel = func() error { return cmd.Process.Signal(syscall.SIGTERM) }
+	cmd.WaitDelay = stopTimeout
+	return cmd
+}
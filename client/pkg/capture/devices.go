@@ -0,0 +1,178 @@
+package capture
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// alsaProbeTimeout bounds how long arecord may hold a device open to report
+// its hardware parameters.
+const alsaProbeTimeout = 3 * time.Second
+
+// Device is a device a source can record, as listed by Devices.
+type Device struct {
+	Source      string // The source recording it: pulse or alsa
+	Name        string // The input to give the source
+	Kind        string // source, monitor (of a sink) or sink for pulse, capture for alsa
+	Description string
+	Format      string // Its sample format, rate and channels, or the ranges the hardware supports; empty if unknown
+	State       string // For pulse: running, idle or suspended
+}
+
+// Devices lists the PulseAudio (or PipeWire) sources, monitors and sinks,
+// and the ALSA capture devices of the host. A sink can't be recorded itself:
+// its Name is that of its monitor, which records what it plays. A sound
+// system that isn't available is skipped; the error says why when neither
+// is.
+func Devices() ([]Device, error) {
+	pulse, pulseErr := pulseDevices()
+	alsa, alsaErr := alsaDevices()
+	if pulseErr != nil && alsaErr != nil {
+		return nil, fmt.Errorf("listing PulseAudio sources failed: %v; listing ALSA devices failed: %v", pulseErr, alsaErr)
+	}
+	return append(pulse, alsa...), nil
+}
+
+// SoundServer returns the name and version of the PulseAudio server pactl
+// talks to, e.g. "PulseAudio (on PipeWire 1.0.5)" when PipeWire serves it.
+func SoundServer() (string, error) {
+	out, err := pactl("", "info")
+	if err != nil {
+		return "", err
+	}
+	var name, version string
+	for _, line := range strings.Split(string(out), "\n") {
+		key, value, _ := strings.Cut(line, ":")
+		switch strings.TrimSpace(key) {
+		case "Server Name":
+			name = strings.TrimSpace(value)
+		case "Server Version":
+			version = strings.TrimSpace(value)
+		}
+	}
+	if strings.Contains(name, "PipeWire") || version == "" {
+		return name, nil
+	}
+	return name + " " + version, nil
+}
+
+// pactl runs pactl with args on server, the default one when empty, in the
+// C locale, as its long listings are translated otherwise.
+func pactl(server string, args ...string) ([]byte, error) {
+	cmd := exec.Command("pactl", pulseArgs(server, args...)...)
+	cmd.Env = append(os.Environ(), "LC_ALL=C")
+	return cmd.Output()
+}
+
+// pulseDevices lists the sources, monitors included, and then the sinks
+// from the long listings of pactl, blocks of "Key: value" lines starting
+// with "Source #1" or "Sink #1".
+func pulseDevices() ([]Device, error) {
+	out, err := pactl("", "list", "sources")
+	if err != nil {
+		return nil, err
+	}
+	var devices []Device
+	for _, block := range pactlBlocks(out) {
+		d := Device{Source: "pulse", Name: block["Name"], Kind: "source", Description: block["Description"],
+			Format: block["Sample Specification"], State: strings.ToLower(block["State"])}
+		if sink := block["Monitor of Sink"]; sink != "" && sink != "n/a" {
+			d.Kind = "monitor"
+		}
+		devices = append(devices, d)
+	}
+	// Sinks are only listed when pactl can list them too
+	if out, err = pactl("", "list", "sinks"); err != nil {
+		return devices, nil
+	}
+	for _, block := range pactlBlocks(out) {
+		if block["Monitor Source"] == "" {
+			continue
+		}
+		devices = append(devices, Device{Source: "pulse", Name: block["Monitor Source"], Kind: "sink", Description: block["Description"],
+			Format: block["Sample Specification"], State: strings.ToLower(block["State"])})
+	}
+	return devices, nil
+}
+
+// pactlBlocks splits a long pactl listing into its blocks, keeping the
+// values of the top-level keys. Nested lists such as the properties are
+// indented further and left out.
+func pactlBlocks(out []byte) []map[string]string {
+	var blocks []map[string]string
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line != "" && line[0] != '\t' && line[0] != ' ' {
+			blocks = append(blocks, make(map[string]string))
+			continue
+		}
+		if len(blocks) == 0 || !strings.HasPrefix(line, "\t") || strings.HasPrefix(line, "\t\t") {
+			continue
+		}
+		if key, value, ok := strings.Cut(strings.TrimSpace(line), ":"); ok {
+			blocks[len(blocks)-1][key] = strings.TrimSpace(value)
+		}
+	}
+	return blocks
+}
+
+// alsaDevices lists the devices of /proc/asound/pcm that can capture, lines
+// like "01-00: USB Audio : USB Audio : playback 1 : capture 1", with the
+// formats arecord reports for them.
+func alsaDevices() ([]Device, error) {
+	data, err := os.ReadFile("/proc/asound/pcm")
+	if err != nil {
+		return nil, err
+	}
+	var devices []Device
+	for _, line := range strings.Split(string(data), "\n") {
+		id, rest, ok := strings.Cut(line, ":")
+		if !ok || !strings.Contains(rest, "capture") {
+			continue
+		}
+		var card, device int
+		if _, err := fmt.Sscanf(strings.TrimSpace(id), "%d-%d", &card, &device); err != nil {
+			continue
+		}
+		name := fmt.Sprintf("hw:%d,%d", card, device)
+		description := strings.TrimSpace(strings.Split(rest, ":")[0])
+		devices = append(devices, Device{Source: "alsa", Name: name, Kind: "capture", Description: description, Format: alsaFormat(name)})
+	}
+	return devices, nil
+}
+
+// alsaFormat returns the sample formats, channels and rates the hardware of
+// an ALSA device supports, from the parameters arecord dumps when it opens
+// it, e.g. "S16_LE S32_LE, 2ch, 44100-48000Hz". It returns "" when arecord
+// is missing or the device is busy.
+func alsaFormat(device string) string {
+	ctx, cancel := context.WithTimeout(context.Background(), alsaProbeTimeout)
+	defer cancel()
+	// One sample is enough to have the parameters dumped
+	cmd := exec.CommandContext(ctx, "arecord", "-D", device, "--dump-hw-params", "-s", "1", "-t", "raw", "-q", os.DevNull)
+	var dump bytes.Buffer
+	cmd.Stderr = &dump
+	cmd.Run()
+	params := make(map[string]string)
+	for _, line := range strings.Split(dump.String(), "\n") {
+		if key, value, ok := strings.Cut(line, ":"); ok {
+			params[strings.TrimSpace(key)] = strings.Trim(strings.TrimSpace(value), "[]()")
+		}
+	}
+	if params["FORMAT"] == "" {
+		return ""
+	}
+	return fmt.Sprintf("%s, %sch, %sHz", params["FORMAT"], alsaRange(params["CHANNELS"]), alsaRange(params["RATE"]))
+}
+
+// alsaRange turns a range arecord dumps, "44100 48000", into 44100-48000.
+func alsaRange(r string) string {
+	return strings.Join(strings.Fields(r), "-")
+}
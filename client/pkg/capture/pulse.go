@@ -0,0 +1,74 @@
+package capture
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// ValidatePulseServer checks a PulseAudio server string as PULSE_SERVER
+// takes it: one address, or several to try in turn separated by spaces, each
+// unix:/path/to/socket, tcp:host:port (tcp4: and tcp6: for one family; the
+// port is 4713 when left out), a bare host or a socket path, optionally
+// preceded by the {machine-id} it is only for.
+func ValidatePulseServer(server string) error {
+	addrs := strings.Fields(server)
+	if len(addrs) == 0 {
+		return fmt.Errorf("invalid PulseAudio server %q", server)
+	}
+	for _, addr := range addrs {
+		if strings.HasPrefix(addr, "{") {
+			_, rest, ok := strings.Cut(addr, "}")
+			if !ok {
+				return fmt.Errorf("invalid PulseAudio server %q: unterminated machine ID", addr)
+			}
+			addr = rest
+		}
+		if err := validatePulseAddr(addr); err != nil {
+			return fmt.Errorf("invalid PulseAudio server %q: %w", addr, err)
+		}
+	}
+	return nil
+}
+
+func validatePulseAddr(addr string) error {
+	if path, ok := strings.CutPrefix(addr, "unix:"); ok {
+		if !filepath.IsAbs(path) {
+			return errors.New("the socket must be an absolute path, e.g. unix:/run/user/1000/pulse/native")
+		}
+		return nil
+	}
+	if strings.HasPrefix(addr, "/") {
+		return nil
+	}
+	host := addr
+	for _, prefix := range []string{"tcp:", "tcp4:", "tcp6:"} {
+		if rest, ok := strings.CutPrefix(addr, prefix); ok {
+			host = rest
+			break
+		}
+	}
+	if h, port, err := net.SplitHostPort(host); err == nil {
+		if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+			return fmt.Errorf("invalid port %q", port)
+		}
+		host = h
+	}
+	if host == "" || strings.ContainsAny(host, "/[]") {
+		return errors.New("use tcp:host:port or unix:/path, e.g. tcp:192.0.2.7:4713")
+	}
+	return nil
+}
+
+// pulseArgs prepends the --server option of pactl and parec to args when a
+// server is given, which otherwise talk to the one PULSE_SERVER names or the
+// local daemon.
+func pulseArgs(server string, args ...string) []string {
+	if server == "" {
+		return args
+	}
+	return append([]string{"--server=" + server}, args...)
+}
@@ -0,0 +1,158 @@
+package capture
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const watchInterval = 2 * time.Second // How often the streams of the browser are checked
+
+// sinkInput is a stream playing into a sink, from pactl list sink-inputs.
+type sinkInput struct {
+	index string
+	sink  string // Index of the sink it plays into
+	pid   int    // Of the process playing it; 0 if unknown
+	host  string // The process runs on; empty if unknown
+}
+
+// watchStreams keeps the browser's streams on the sink until ctx is done,
+// checking every watchInterval. PULSE_SINK only reaches the processes that
+// inherit Firefox's environment and honor it; a browser's audio or service
+// process may open its stream on the default sink instead, so any sink input
+// of a process of the browser's tree is moved to the sink. With pin, the
+// streams on the sink are kept at 100% and unmuted too.
+func (s *Session) watchStreams(ctx context.Context, pin bool) {
+	ticker := time.NewTicker(watchInterval)
+	defer ticker.Stop()
+	host, _ := os.Hostname()
+	pinned := make(map[string]bool)
+	warned := make(map[string]bool) // Inputs that couldn't be moved, warned of once
+	for {
+		if sink := s.sinkIndex(); sink != "" {
+			tree := processTree(s.firefox.Process.Pid)
+			for _, input := range s.sinkInputs() {
+				if input.sink != sink && tree[input.pid] && (input.host == "" || input.host == host) {
+					if _, err := pactl(s.server, "move-sink-input", input.index, s.sink); err != nil {
+						if !warned[input.index] {
+							warned[input.index] = true
+							s.pulseLog.Warn("Moving a stream of the browser to the sink failed", "sink_input", input.index, "pid", input.pid, "err", err)
+						}
+						continue
+					}
+					s.pulseLog.Info("🔀 Moved a stream of the browser to the sink", "sink_input", input.index, "pid", input.pid)
+					input.sink = sink
+				}
+				if pin && input.sink == sink {
+					s.pinVolume(input.index, pinned)
+				}
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// sinkIndex returns the index of the sink, from the short listing of pactl,
+// "index name ..." lines, or "" when it can't be found.
+func (s *Session) sinkIndex() string {
+	out, err := pactl(s.server, "list", "short", "sinks")
+	if err != nil {
+		return ""
+	}
+	for _, line := range strings.Split(string(out), "\n") {
+		if fields := strings.Fields(line); len(fields) >= 2 && fields[1] == s.sink {
+			return fields[0]
+		}
+	}
+	return ""
+}
+
+// sinkInputs lists the sink inputs of the server, from the long listing of
+// pactl: blocks starting with "Sink Input #12", the sink as "Sink: 3" and the
+// process in the properties indented further, as
+// application.process.id = "1234".
+func (s *Session) sinkInputs() []sinkInput {
+	out, err := pactl(s.server, "list", "sink-inputs")
+	if err != nil {
+		return nil
+	}
+	var inputs []sinkInput
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if index, ok := strings.CutPrefix(line, "Sink Input #"); ok {
+			inputs = append(inputs, sinkInput{index: strings.TrimSpace(index)})
+			continue
+		}
+		if len(inputs) == 0 {
+			continue
+		}
+		input := &inputs[len(inputs)-1]
+		key, value, ok := strings.Cut(strings.TrimSpace(line), "=")
+		if !ok {
+			if sink, ok := strings.CutPrefix(line, "\tSink:"); ok {
+				input.sink = strings.TrimSpace(sink)
+			}
+			continue
+		}
+		value = strings.Trim(strings.TrimSpace(value), `"`)
+		switch strings.TrimSpace(key) {
+		case "application.process.id":
+			input.pid, _ = strconv.Atoi(value)
+		case "application.process.host":
+			input.host = value
+		}
+	}
+	return inputs
+}
+
+// processTree returns root and its descendants, from the parents in
+// /proc/<pid>/stat. Where there is no /proc it is root alone.
+func processTree(root int) map[int]bool {
+	tree := map[int]bool{root: true}
+	paths, _ := filepath.Glob("/proc/[0-9]*/stat")
+	children := make(map[int][]int)
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		// pid (comm) state ppid ..., where comm may hold spaces and parentheses
+		i := bytes.LastIndexByte(data, ')')
+		if i < 0 {
+			continue
+		}
+		fields := strings.Fields(string(data[i+1:]))
+		if len(fields) < 2 {
+			continue
+		}
+		pid, err := strconv.Atoi(filepath.Base(filepath.Dir(path)))
+		if err != nil {
+			continue
+		}
+		if ppid, err := strconv.Atoi(fields[1]); err == nil {
+			children[ppid] = append(children[ppid], pid)
+		}
+	}
+	queue := []int{root}
+	for len(queue) > 0 {
+		pid := queue[0]
+		queue = queue[1:]
+		for _, child := range children[pid] {
+			if !tree[child] {
+				tree[child] = true
+				queue = append(queue, child)
+			}
+		}
+	}
+	return tree
+}
@@ -0,0 +1,150 @@
+package capture
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+)
+
+const (
+	soundServerStart = 5 * time.Second // How long a started sound server has to answer pactl
+	soundServerPoll  = 100 * time.Millisecond
+)
+
+// SoundDaemon is a sound server StartSoundDaemon started, which the client
+// owns: it is stopped with Close or when the context it was started with is
+// done.
+type SoundDaemon struct {
+	name       string
+	cancel     context.CancelFunc // Stops its processes
+	wg         sync.WaitGroup     // Their Waits
+	exited     chan struct{}      // Closed once the first of them exited
+	exitOnce   sync.Once
+	runtimeDir string // Created for it when XDG_RUNTIME_DIR wasn't set; removed on Close
+	log        *slog.Logger
+}
+
+// StartSoundDaemon starts a sound server when pactl finds none, so the
+// browser and pulse sources work on a headless host or in a container:
+// pulseaudio, or where it isn't installed a minimal PipeWire session of
+// pipewire, wireplumber and pipewire-pulse. It returns nil when a server is
+// running already. Without XDG_RUNTIME_DIR, which PipeWire needs and where
+// the tools look for the server's socket, it is set to a directory of the
+// server's own for the process and the ones it starts.
+func StartSoundDaemon(ctx context.Context, log *slog.Logger) (*SoundDaemon, error) {
+	if name, err := SoundServer(); err == nil {
+		log.Info("🔊 Using the running sound server", "server", name)
+		return nil, nil
+	}
+	if server := os.Getenv("PULSE_SERVER"); server != "" {
+		return nil, fmt.Errorf("PULSE_SERVER names %s, which doesn't answer; unset it to start a sound server here", server)
+	}
+	var commands [][]string
+	switch {
+	case lookPath("pulseaudio"):
+		commands = [][]string{{"pulseaudio", "--daemonize=no", "--exit-idle-time=-1", "--disallow-exit", "--log-level=error"}}
+	case lookPath("pipewire") && lookPath("pipewire-pulse"):
+		commands = [][]string{{"pipewire"}}
+		if lookPath("wireplumber") {
+			// Links the browser's stream to the sink it asks for
+			commands = append(commands, []string{"wireplumber"})
+		} else {
+			log.Warn("wireplumber isn't installed, so PipeWire may not link the browser to its sink")
+		}
+		commands = append(commands, []string{"pipewire-pulse"})
+	default:
+		return nil, errors.New("no sound server is running, and neither pulseaudio nor pipewire and pipewire-pulse are installed to start one")
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	p := &SoundDaemon{name: commands[0][0], cancel: cancel, exited: make(chan struct{}), log: log}
+	if os.Getenv("XDG_RUNTIME_DIR") == "" {
+		dir, err := os.MkdirTemp("", "audio-capture-runtime-*")
+		if err != nil {
+			cancel()
+			return nil, fmt.Errorf("creating a runtime directory for the sound server failed: %w", err)
+		}
+		p.runtimeDir = dir
+		os.Setenv("XDG_RUNTIME_DIR", dir)
+	}
+	for _, args := range commands {
+		if err := p.start(ctx, args); err != nil {
+			p.Close()
+			return nil, err
+		}
+	}
+
+	deadline := time.NewTimer(soundServerStart)
+	defer deadline.Stop()
+	for {
+		if name, err := SoundServer(); err == nil {
+			log.Info("🔊 Started a sound server of the client's own", "server", name, "process", p.name)
+			return p, nil
+		}
+		select {
+		case <-time.After(soundServerPoll):
+			continue
+		case <-p.exited:
+			p.Close()
+			return nil, fmt.Errorf("%s exited before it answered", p.name)
+		case <-deadline.C:
+			p.Close()
+			return nil, fmt.Errorf("%s didn't answer within %s", p.name, soundServerStart)
+		case <-ctx.Done():
+			p.Close()
+			return nil, ctx.Err()
+		}
+	}
+}
+
+func lookPath(name string) bool {
+	_, err := exec.LookPath(name)
+	return err == nil
+}
+
+// start starts one of the server's processes, logging what it writes to
+// stderr.
+func (p *SoundDaemon) start(ctx context.Context, args []string) error {
+	cmd := command(ctx, args[0], args[1:]...)
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return fmt.Errorf("failed to get stderr pipe from %s: %w", args[0], err)
+	}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to start %s: %w", args[0], err)
+	}
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		scanner := bufio.NewScanner(stderr)
+		for scanner.Scan() {
+			p.log.Warn(args[0], "stderr", scanner.Text())
+		}
+		cmd.Wait()
+		p.exitOnce.Do(func() { close(p.exited) })
+	}()
+	return nil
+}
+
+// Close stops the server and removes its runtime directory. It does nothing
+// for a nil server, which StartSoundDaemon returns when one was running
+// already.
+func (p *SoundDaemon) Close() error {
+	if p == nil {
+		return nil
+	}
+	p.log.Info("🔥 Stopping the sound server", "process", p.name)
+	p.cancel()
+	p.wg.Wait()
+	if p.runtimeDir != "" {
+		os.Unsetenv("XDG_RUNTIME_DIR")
+		return os.RemoveAll(p.runtimeDir)
+	}
+	return nil
+}
@@ -0,0 +1,324 @@
+package capture
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Source is where audio comes from: a web page, a sound card, a file or a
+// generator.
+type Source interface {
+	// Audio returns the audio as big-endian 16-bit PCM at the rate and
+	// channels of the options. It ends when the source is closed or runs
+	// out.
+	Audio() io.Reader
+	// Close stops capturing and releases what the source set up.
+	Close() error
+}
+
+// Opener opens a source with the options. The source stops when ctx is
+// done, ending its audio.
+type Opener func(ctx context.Context, opts Options) (Source, error)
+
+var (
+	sourcesMu sync.Mutex
+	sources   = map[string]Opener{
+		"browser": func(ctx context.Context, opts Options) (Source, error) { return NewSession(ctx, opts) },
+		"pulse":   openPulse,
+		"alsa":    openALSA,
+		"file":    openFile,
+		"tone":    openTone,
+		"fake":    openFake,
+	}
+)
+
+// Register makes a source available to Open under name, replacing any
+// source of that name.
+func Register(name string, open Opener) {
+	sourcesMu.Lock()
+	defer sourcesMu.Unlock()
+	sources[name] = open
+}
+
+// Sources returns the names of the registered sources, sorted.
+func Sources() []string {
+	sourcesMu.Lock()
+	defer sourcesMu.Unlock()
+	names := make([]string, 0, len(sources))
+	for name := range sources {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Open opens the source registered under name:
+//
+//   - browser plays the page at opts.Input in Firefox and records it, see
+//     NewSession
+//   - pulse records the PulseAudio source opts.Input, or the default one
+//   - alsa records the ALSA device opts.Input, or the default one
+//   - file reads big-endian 16-bit PCM from the file opts.Input, in real
+//     time
+//   - tone generates a sine wave of opts.Input Hz, 440 by default
+//   - fake generates the deterministic audio of openFake, for tests
+//
+// The source stops when ctx is done, and its audio ends; Close still has to
+// be called to release what it set up.
+func Open(ctx context.Context, name string, opts Options) (Source, error) {
+	sourcesMu.Lock()
+	open, ok := sources[name]
+	sourcesMu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown source %q", name)
+	}
+	return open(ctx, opts.withDefaults())
+}
+
+// withDefaults fills in what the options leave out.
+func (opts Options) withDefaults() Options {
+	if opts.SampleRate == 0 {
+		opts.SampleRate = 48000
+	}
+	if opts.Channels == 0 {
+		opts.Channels = 1
+	}
+	if opts.Log == nil {
+		opts.Log = slog.Default()
+	}
+	return opts
+}
+
+// processSource records the standard output of a recorder process such as
+// parec or arecord.
+type processSource struct {
+	cmd    *exec.Cmd
+	audio  io.ReadCloser
+	cancel context.CancelFunc // Stops the process
+	log    *slog.Logger
+}
+
+func openPulse(ctx context.Context, opts Options) (Source, error) {
+	args := pulseArgs(opts.PulseServer, "--format=s16be", fmt.Sprintf("--rate=%d", opts.SampleRate), fmt.Sprintf("--channels=%d", opts.Channels))
+	if opts.Input != "" {
+		args = append(args, "--device="+opts.Input)
+	}
+	return startProcess(ctx, "parec", args, opts.Input, opts.Log.With("component", "pulse"))
+}
+
+func openALSA(ctx context.Context, opts Options) (Source, error) {
+	args := []string{"-q", "-t", "raw", "-f", "S16_BE", "-r", strconv.Itoa(opts.SampleRate), "-c", strconv.Itoa(opts.Channels)}
+	if opts.Input != "" {
+		args = append(args, "-D", opts.Input)
+	}
+	return startProcess(ctx, "arecord", args, opts.Input, opts.Log.With("component", "alsa"))
+}
+
+func startProcess(ctx context.Context, name string, args []string, device string, log *slog.Logger) (*processSource, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	cmd := command(ctx, name, args...)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		cancel()
+		return nil, fmt.Errorf("failed to get stdout pipe from %s: %w", name, err)
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		cancel()
+		return nil, fmt.Errorf("failed to get stderr pipe from %s: %w", name, err)
+	}
+	if device == "" {
+		device = "default"
+	}
+	log.Info("🎤 Starting audio capture", "source", device)
+	if err := cmd.Start(); err != nil {
+		cancel()
+		return nil, fmt.Errorf("failed to start %s: %w", name, err)
+	}
+	go func() {
+		scanner := bufio.NewScanner(stderr)
+		for scanner.Scan() {
+			log.Warn(name, "stderr", scanner.Text())
+		}
+	}()
+	return &processSource{cmd: cmd, audio: stdout, cancel: cancel, log: log}, nil
+}
+
+func (p *processSource) Audio() io.Reader { return p.audio }
+
+func (p *processSource) Close() error {
+	p.log.Info("🔥 Terminating recorder", "process", p.cmd.Args[0])
+	p.cancel()
+	p.cmd.Wait()
+	return nil
+}
+
+// fileSource reads a file of PCM at the pace it would be captured.
+type fileSource struct {
+	f     *os.File
+	audio io.Reader
+}
+
+func openFile(ctx context.Context, opts Options) (Source, error) {
+	f, err := os.Open(opts.Input)
+	if err != nil {
+		return nil, err
+	}
+	opts.Log.Info("📂 Reading audio from a file", "component", "file", "file", opts.Input)
+	return &fileSource{f: f, audio: newPacer(ctx, f, opts)}, nil
+}
+
+func (s *fileSource) Audio() io.Reader { return s.audio }
+func (s *fileSource) Close() error     { return s.f.Close() }
+
+// generatedSource plays audio generated by a reader, such as a sine wave, in
+// real time.
+type generatedSource struct {
+	audio  io.Reader
+	cancel context.CancelFunc // Ends the audio
+}
+
+func openTone(ctx context.Context, opts Options) (Source, error) {
+	freq := 440.0
+	if opts.Input != "" {
+		var err error
+		if freq, err = strconv.ParseFloat(opts.Input, 64); err != nil || freq <= 0 || freq >= float64(opts.SampleRate)/2 {
+			return nil, fmt.Errorf("invalid tone frequency %q", opts.Input)
+		}
+	}
+	opts.Log.Info("🎵 Generating a tone", "component", "tone", "hz", freq)
+	ctx, cancel := context.WithCancel(ctx)
+	sine := &sine{step: 2 * math.Pi * freq / float64(opts.SampleRate), channels: opts.Channels}
+	return &generatedSource{audio: newPacer(ctx, sine, opts), cancel: cancel}, nil
+}
+
+// openFake generates audio that is the same on every run, so tests can check
+// what arrives sample by sample, without PulseAudio or a browser. opts.Input
+// is the pattern, optionally with how long to play it, after which the audio
+// ends, e.g. ramp:10s:
+//
+//   - ramp, the default, counts the frames: frame n holds n+c on channel c,
+//     wrapping around at 16 bits
+//   - sine is a 1 kHz sine wave at -16 dBFS, starting at phase 0
+//   - silence is all zeros
+func openFake(ctx context.Context, opts Options) (Source, error) {
+	pattern, length, _ := strings.Cut(opts.Input, ":")
+	var r io.Reader
+	switch pattern {
+	case "", "ramp":
+		pattern, r = "ramp", &ramp{channels: opts.Channels}
+	case "sine":
+		r = &sine{step: 2 * math.Pi * 1000 / float64(opts.SampleRate), channels: opts.Channels}
+	case "silence":
+		r = zeros{}
+	default:
+		return nil, fmt.Errorf("unknown fake pattern %q (use ramp, sine or silence)", pattern)
+	}
+	var duration time.Duration
+	if length != "" {
+		var err error
+		if duration, err = time.ParseDuration(length); err != nil || duration <= 0 {
+			return nil, fmt.Errorf("invalid fake duration %q", length)
+		}
+		frames := int64(duration.Seconds() * float64(opts.SampleRate))
+		r = io.LimitReader(r, frames*int64(opts.Channels)*2)
+	}
+	opts.Log.Info("🧪 Generating fake audio", "component", "fake", "pattern", pattern, "duration", duration)
+	ctx, cancel := context.WithCancel(ctx)
+	return &generatedSource{audio: newPacer(ctx, r, opts), cancel: cancel}, nil
+}
+
+func (s *generatedSource) Audio() io.Reader { return s.audio }
+
+func (s *generatedSource) Close() error {
+	s.cancel()
+	return nil
+}
+
+// sine reads as an endless sine wave.
+type sine struct {
+	phase, step float64
+	channels    int
+}
+
+func (s *sine) Read(p []byte) (int, error) {
+	frame := 2 * s.channels
+	n := len(p) / frame * frame
+	for i := 0; i < n; i += frame {
+		v := uint16(int16(math.Sin(s.phase) * math.MaxInt16 / 6))
+		for c := 0; c < s.channels; c++ {
+			binary.BigEndian.PutUint16(p[i+2*c:], v)
+		}
+		s.phase = math.Mod(s.phase+s.step, 2*math.Pi)
+	}
+	return n, nil
+}
+
+// ramp reads as frames counting up, as described for openFake.
+type ramp struct {
+	frame    uint16
+	channels int
+}
+
+func (r *ramp) Read(p []byte) (int, error) {
+	frame := 2 * r.channels
+	n := len(p) / frame * frame
+	for i := 0; i < n; i += frame {
+		for c := 0; c < r.channels; c++ {
+			binary.BigEndian.PutUint16(p[i+2*c:], r.frame+uint16(c))
+		}
+		r.frame++
+	}
+	return n, nil
+}
+
+// zeros reads as endless silence.
+type zeros struct{}
+
+func (zeros) Read(p []byte) (int, error) {
+	clear(p)
+	return len(p), nil
+}
+
+// pacer reads no faster than audio of the options plays, so sources that
+// could be read at once are sent in real time. It ends when ctx is done.
+type pacer struct {
+	ctx         context.Context
+	r           io.Reader
+	bytesPerSec float64
+	start       time.Time
+	read        int64
+}
+
+func newPacer(ctx context.Context, r io.Reader, opts Options) *pacer {
+	return &pacer{ctx: ctx, r: r, bytesPerSec: float64(opts.SampleRate * opts.Channels * 2)}
+}
+
+func (p *pacer) Read(b []byte) (int, error) {
+	if p.start.IsZero() {
+		p.start = time.Now()
+	}
+	due := p.start.Add(time.Duration(float64(p.read) / p.bytesPerSec * float64(time.Second)))
+	timer := time.NewTimer(time.Until(due))
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-p.ctx.Done():
+		return 0, io.EOF
+	}
+	n, err := p.r.Read(b)
+	p.read += int64(n)
+	return n, err
+}
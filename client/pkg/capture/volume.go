@@ -0,0 +1,37 @@
+package capture
+
+import (
+	"strings"
+)
+
+// setFullVolume sets the sink and its monitor to 100% (0 dB) and unmutes
+// them, as a new null sink takes the volume PulseAudio restores for its name
+// or the default, which may be low or muted. Failing is warned of: the
+// capture still works, if quieter.
+func (s *Session) setFullVolume() {
+	monitor := s.sink + ".monitor"
+	for _, args := range [][]string{
+		{"set-sink-volume", s.sink, "100%"},
+		{"set-sink-mute", s.sink, "0"},
+		{"set-source-volume", monitor, "100%"},
+		{"set-source-mute", monitor, "0"},
+	} {
+		if _, err := pactl(s.server, args...); err != nil {
+			s.pulseLog.Warn("Setting the sink's volume failed", "command", strings.Join(args, " "), "err", err)
+			return
+		}
+	}
+	s.pulseLog.Info("🔊 Set the sink to 100% and unmuted", "sink", s.sink)
+}
+
+// pinVolume sets a stream playing into the sink, one of the browser's sink
+// inputs, to 100% and unmuted, in case the page turned it down; pinned
+// remembers the ones already logged.
+func (s *Session) pinVolume(input string, pinned map[string]bool) {
+	_, errVolume := pactl(s.server, "set-sink-input-volume", input, "100%")
+	_, errMute := pactl(s.server, "set-sink-input-mute", input, "0")
+	if errVolume == nil && errMute == nil && !pinned[input] {
+		pinned[input] = true
+		s.pulseLog.Info("📌 Pinned the volume of a stream on the sink", "sink_input", input)
+	}
+}
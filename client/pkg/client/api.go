@@ -0,0 +1,336 @@
+package client
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fcerini/audio-capture-client/pkg/capture"
+	"github.com/fcerini/audio-capture-client/pkg/rtpstream"
+)
+
+const (
+	defaultAPIAddr = "127.0.0.1:8090" // Of -daemon
+	maxGainDB      = 40
+)
+
+// sessionAPI controls the client's sessions over HTTP: the one of the
+// command line, or with -daemon the sessions started through it, each with a
+// context of its own until the API's is done.
+type sessionAPI struct {
+	ctx    context.Context
+	cfg    *Config
+	log    *slog.Logger
+	daemon bool // Sessions can be started
+
+	mu       sync.Mutex
+	sessions map[string]*session // By ID, ended ones included until deleted; guarded by mu
+}
+
+// apiSession is a session as returned by the API.
+type apiSession struct {
+	ID string `json:"id"`
+	SessionParams
+	State    string             `json:"state"`              // streaming, paused, or ended once stopped or out of audio
+	Receiver string             `json:"receiver,omitempty"` // up, down or unknown, with -keepalive
+	GainDB   float64            `json:"gain_db"`
+	Started  time.Time          `json:"started"`
+	LastSent time.Time          `json:"last_sent,omitzero"`
+	Counters rtpstream.Counters `json:"counters"`
+}
+
+// runDaemon serves the session API on -api-addr, and -grpc-addr if set,
+// until ctx is done, then stops the sessions left.
+func runDaemon(ctx context.Context, cfg *Config) error {
+	api := newSessionAPI(ctx, cfg, true)
+	if cfg.grpcAddr != "" {
+		go func() {
+			if err := api.serveGRPC(cfg.grpcAddr); err != nil {
+				api.log.Error("gRPC server failed", "err", err)
+			}
+		}()
+	}
+	err := api.serve(cfg.apiAddr)
+	api.stopAll()
+	return err
+}
+
+func newSessionAPI(ctx context.Context, cfg *Config, daemon bool) *sessionAPI {
+	return &sessionAPI{ctx: ctx, cfg: cfg, log: slog.With("component", "api"), daemon: daemon, sessions: make(map[string]*session)}
+}
+
+// serve serves the API on addr until the API's context is done. Requests
+// need the token from API_TOKEN as a bearer token if set, like the
+// server's:
+//
+//	GET    /api/sessions                    all sessions
+//	POST   /api/sessions                    start a session, from SessionParams as JSON; -daemon only
+//	GET    /api/sessions/<id>               one session
+//	DELETE /api/sessions/<id>               stop a session and forget it
+//	POST   /api/sessions/<id>/stop          stop a session, sending what was captured
+//	POST   /api/sessions/<id>/pause         stop sending the audio for now
+//	POST   /api/sessions/<id>/resume        send it again
+//	POST   /api/sessions/<id>/destination   send to {"destination": "host:port"} instead
+//	POST   /api/sessions/<id>/gain          change the volume by {"gain_db": -6}
+func (a *sessionAPI) serve(addr string) error {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/sessions", a.handleSessions)
+	mux.HandleFunc("/api/sessions/", a.handleSessions)
+	a.log.Info("🎛️ Serving the session API", "url", "http://"+addr+"/api/sessions")
+	if err := serveHTTP(a.ctx, addr, requireToken(mux)); err != nil {
+		return fmt.Errorf("serving the session API failed: %w", err)
+	}
+	return nil
+}
+
+// add makes s available through the API.
+func (a *sessionAPI) add(s *session) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.sessions[s.id] = s
+}
+
+func (a *sessionAPI) handleSessions(w http.ResponseWriter, r *http.Request) {
+	id, action, _ := strings.Cut(strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/sessions"), "/"), "/")
+	switch {
+	case id == "" && r.Method == http.MethodGet:
+		writeJSON(w, http.StatusOK, a.list())
+	case id == "" && r.Method == http.MethodPost && a.daemon:
+		a.create(w, r)
+	case id == "" && r.Method == http.MethodPost:
+		http.Error(w, "sessions can only be started with -daemon", http.StatusMethodNotAllowed)
+	case id != "" && action == "" && r.Method == http.MethodGet:
+		s := a.get(id)
+		if s == nil {
+			http.Error(w, "no such session", http.StatusNotFound)
+			return
+		}
+		writeJSON(w, http.StatusOK, s.status())
+	case id != "" && action == "" && r.Method == http.MethodDelete:
+		a.mu.Lock()
+		s := a.sessions[id]
+		delete(a.sessions, id)
+		a.mu.Unlock()
+		if s == nil {
+			http.Error(w, "no such session", http.StatusNotFound)
+			return
+		}
+		s.stop()
+		a.log.Info("🛑 Stopped session", "session", id)
+		writeJSON(w, http.StatusOK, s.status())
+	case id != "" && action != "" && r.Method == http.MethodPost:
+		s := a.get(id)
+		if s == nil {
+			http.Error(w, "no such session", http.StatusNotFound)
+			return
+		}
+		a.control(w, r, s, action)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// control changes a running session as action asks.
+func (a *sessionAPI) control(w http.ResponseWriter, r *http.Request, s *session, action string) {
+	log := a.log.With("session", s.id)
+	switch action {
+	case "stop":
+		s.stop()
+		log.Info("🛑 Stopped session")
+	case "pause":
+		s.stream.Pause()
+		log.Info("⏸️ Paused session")
+	case "resume":
+		s.stream.Resume()
+		log.Info("▶️ Resumed session")
+	case "destination":
+		var body struct {
+			Destination string `json:"destination"`
+		}
+		if err := decodeJSON(w, r, &body); err != nil || body.Destination == "" {
+			http.Error(w, `a {"destination": "host:port"} body is required`, http.StatusBadRequest)
+			return
+		}
+		if err := s.stream.Redirect(body.Destination); err != nil {
+			log.Error("Changing the destination failed", "destination", body.Destination, "err", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	case "gain":
+		var body struct {
+			GainDB *float64 `json:"gain_db"`
+		}
+		if err := decodeJSON(w, r, &body); err != nil || body.GainDB == nil {
+			http.Error(w, `a {"gain_db": decibels} body is required`, http.StatusBadRequest)
+			return
+		}
+		if *body.GainDB < -maxGainDB || *body.GainDB > maxGainDB {
+			http.Error(w, fmt.Sprintf("the gain must be between -%d and %d dB", maxGainDB, maxGainDB), http.StatusBadRequest)
+			return
+		}
+		s.stream.SetGain(*body.GainDB)
+		log.Info("🔊 Changed the gain", "gain_db", *body.GainDB)
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	writeJSON(w, http.StatusOK, s.status())
+}
+
+// create starts the session of the request's SessionParams, answering once
+// it streams.
+func (a *sessionAPI) create(w http.ResponseWriter, r *http.Request) {
+	var p SessionParams
+	if err := decodeJSON(w, r, &p); err != nil {
+		http.Error(w, "invalid session: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := a.validate(a.cfg.withDefaults(p)); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	s, err := a.start(p)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusCreated, s.status())
+}
+
+// start starts a session of the API, once validated.
+func (a *sessionAPI) start(p SessionParams) (*session, error) {
+	id := newSessionID()
+	stats := rtpstream.NewStats(a.cfg.alertLoss, a.cfg.alertJitter)
+	s, err := startSession(a.ctx, a.cfg, id, p, stats, nil, slog.With("session", id))
+	if err != nil {
+		a.log.Error("Starting a session failed", "input", p.Input, "destination", p.Destination, "err", err)
+		return nil, err
+	}
+	a.add(s)
+	a.log.Info("▶️ Started session", "session", s.id, "input", s.params.Input, "destination", s.params.Destination)
+	go func() {
+		// Release the capture as soon as the audio ends; the session stays
+		// listed as ended until deleted
+		<-s.stream.Done()
+		s.stop()
+	}()
+	return s, nil
+}
+
+// validate checks what a session asks for before starting it, so mistakes
+// are told apart from captures that fail.
+func (a *sessionAPI) validate(p SessionParams) error {
+	switch {
+	case p.Input == "" || p.Destination == "":
+		return errors.New("an input and a destination are required")
+	case !slices.Contains(capture.Sources(), p.Source):
+		return fmt.Errorf("unknown source %q", p.Source)
+	case !slices.Contains(rtpstream.Encoders(), p.Encoding):
+		return fmt.Errorf("unknown encoding %q", p.Encoding)
+	case !slices.Contains(rtpstream.Transports(), p.Transport):
+		return fmt.Errorf("unknown transport %q", p.Transport)
+	case p.SampleRate < 1 || p.Channels < 1:
+		return errors.New("the rate and the channels must be at least 1")
+	}
+	return nil
+}
+
+func (a *sessionAPI) get(id string) *session {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.sessions[id]
+}
+
+// all returns the sessions, the oldest first.
+func (a *sessionAPI) all() []*session {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	list := make([]*session, 0, len(a.sessions))
+	for _, s := range a.sessions {
+		list = append(list, s)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].started.Before(list[j].started) })
+	return list
+}
+
+// list returns the sessions as the API does, the oldest first.
+func (a *sessionAPI) list() []apiSession {
+	list := []apiSession{}
+	for _, s := range a.all() {
+		list = append(list, s.status())
+	}
+	return list
+}
+
+// stopAll stops every session, all at once.
+func (a *sessionAPI) stopAll() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	var wg sync.WaitGroup
+	for _, s := range a.sessions {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.stop()
+		}()
+	}
+	wg.Wait()
+}
+
+func (s *session) status() apiSession {
+	st := apiSession{
+		ID:            s.id,
+		SessionParams: s.params,
+		State:         "streaming",
+		Receiver:      s.stream.ReceiverState(),
+		GainDB:        s.stream.Gain(),
+		Started:       s.started,
+		LastSent:      s.stats.LastSent(),
+		Counters:      s.stats.Counters(),
+	}
+	st.Destination = s.stream.Destination()
+	switch {
+	case s.ended():
+		st.State = "ended"
+	case s.stream.Paused():
+		st.State = "paused"
+	}
+	return st
+}
+
+// requireToken guards the API with API_TOKEN as a bearer token, when it is
+// set.
+func requireToken(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := os.Getenv("API_TOKEN")
+		got, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if token != "" && subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="audio-capture-client"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func decodeJSON(w http.ResponseWriter, r *http.Request, v any) error {
+	return json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<16)).Decode(v)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.Error("Encoding response failed", "component", "api", "err", err)
+	}
+}
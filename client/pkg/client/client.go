@@ -0,0 +1,409 @@
+// Package client is the audio-capture client as a library: ParseConfig reads
+// its command line and Run captures from a source and streams the audio to a
+// receiver, as the client binary and the capture subcommand of audio-capture
+// do. With -daemon, Run serves an API for starting and stopping captures
+// instead; see runDaemon.
+//
+//	cfg, err := client.ParseConfig([]string{"-source=tone", "1000", "127.0.0.1:6001"})
+//	if err != nil {
+//		return err
+//	}
+//	return client.Run(ctx, cfg)
+package client
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"net/netip"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/fcerini/audio-capture-client/pkg/capture"
+	"github.com/fcerini/audio-capture-client/pkg/rtpstream"
+	"github.com/fcerini/audio-capture-shared/pkg/config"
+	"github.com/fcerini/audio-capture-shared/pkg/dscp"
+	"github.com/fcerini/audio-capture-shared/pkg/pcap"
+	"github.com/fcerini/audio-capture-shared/pkg/plugin"
+)
+
+const readyWindow = 2 * time.Second // /readyz fails when no packet was sent for this long
+
+// Config is the client's configuration, from ParseConfig.
+type Config struct {
+	Input       string // Of the source, e.g. the URL of the page to play
+	Destination string // host:port of the receiver
+	LogLevel    slog.Level
+	LogFormat   string // logText or logJSON
+
+	daemon         bool
+	apiAddr        string
+	grpcAddr       string
+	pprofAddr      string
+	healthAddr     string
+	alertLoss      float64
+	alertJitter    time.Duration
+	debugPcap      string
+	rtcpInterval   time.Duration
+	handshake      bool
+	formatExt      time.Duration
+	metadataPort   int
+	title          string
+	operator       string
+	stun           string
+	turn           string
+	turnAlways     bool
+	ice            bool
+	dscp           dscp.Class
+	bind           netip.Addr
+	iface          string
+	keepalive      time.Duration
+	spool          string
+	plugins        plugin.Specs
+	reportInterval time.Duration
+	sendQueue      int
+	maxBandwidth   rtpstream.Bandwidth
+	backups        []string
+	failoverAfter  time.Duration
+	tuning         rtpstream.Tuning
+	source         string
+	backend        string // -backend, another name for -source
+	pulseServer    string
+	startPulse     bool
+	pinVolume      bool
+	encoding       string
+	transport      string
+	sampleRate     int
+	channels       int
+
+	settings []config.Setting // Where each option came from
+}
+
+// ParseConfig parses the client's command-line flags and arguments, without
+// the program name. It returns flag.ErrHelp after printing the help for -h.
+func ParseConfig(args []string) (*Config, error) {
+	cfg := &Config{}
+	fs := flag.NewFlagSet("audio-capture-client", flag.ContinueOnError)
+	fs.BoolVar(&cfg.daemon, "daemon", false, "run until stopped, capturing what the API on -api-addr is asked to instead of the input given; the flags below are the defaults of its sessions")
+	fs.StringVar(&cfg.apiAddr, "api-addr", "", "serve the session API, for pausing, redirecting or stopping the capture, on this address, e.g. 127.0.0.1:8090 (default: disabled, "+defaultAPIAddr+" with -daemon)")
+	fs.StringVar(&cfg.grpcAddr, "grpc-addr", "", "serve the Control gRPC service, shared with the server, for the session API's operations on this address, e.g. 127.0.0.1:9091 (default: disabled)")
+	fs.StringVar(&cfg.pprofAddr, "pprof-addr", "", "serve net/http/pprof profiles on this address, e.g. 127.0.0.1:6060 (default: disabled)")
+	fs.TextVar(&cfg.LogLevel, "log-level", slog.LevelInfo, "least severe messages logged: debug, info, warn or error")
+	fs.StringVar(&cfg.LogFormat, "log-format", logText, "log format: text (key=value lines) or json (one JSON object per line)")
+	fs.StringVar(&cfg.healthAddr, "health-addr", "", "serve /healthz and /readyz probes on this address, e.g. :8081 (default: disabled)")
+	fs.Float64Var(&cfg.alertLoss, "alert-loss", 5, "warn when a receiver reports more packet loss than this percentage over RTCP")
+	fs.DurationVar(&cfg.alertJitter, "alert-jitter", 30*time.Millisecond, "warn when a receiver reports more jitter than this over RTCP")
+	fs.StringVar(&cfg.debugPcap, "debug-pcap", "", "capture the RTP sent and the RTCP received to this pcap file, for Wireshark (default: disabled)")
+	fs.DurationVar(&cfg.rtcpInterval, "rtcp-interval", 5*time.Second, "send RTCP sender reports this often, for measuring the latency to receivers that answer them (0 = never)")
+	fs.Var(&cfg.plugins, "plugin", "run the captured audio through this program before streaming it, as name=command args, e.g. 'denoise=/usr/local/bin/denoiser -strength 0.5'; see the README for the protocol (repeatable, in order)")
+	fs.BoolVar(&cfg.handshake, "handshake", false, "offer the stream's format to the server in RTCP before the audio, and log whether it accepts it")
+	fs.DurationVar(&cfg.formatExt, "format-ext", 0, "attach the stream's format to a packet this often in an RTP header extension, starting with the first, so the server records it right whenever it joins, e.g. 1s (0 = never)")
+	fs.IntVar(&cfg.metadataPort, "metadata-port", 0, "tell the server the session's -title, -operator, input and start time over TCP on this port of the destination's host, which it takes with -metadata-addr, before the first packet (0 = never)")
+	fs.StringVar(&cfg.title, "title", "", "title of the session for -metadata-port, e.g. 'Morning show'")
+	fs.StringVar(&cfg.operator, "operator", "", "operator of the session for -metadata-port, e.g. alice")
+	fs.StringVar(&cfg.stun, "stun", "", "learn the address the NAT in front of the client gives the stream's port from this STUN server, e.g. stun.l.google.com:19302, and send from that port, for a server behind a NAT to -punch towards (UDP only; default: none)")
+	fs.StringVar(&cfg.turn, "turn", "", "relay the stream through this TURN server, e.g. turn.example.com:3478, when the receiver doesn't answer STUN directly (UDP only; credentials from TURN_USERNAME and TURN_PASSWORD; default: none)")
+	fs.BoolVar(&cfg.turnAlways, "turn-always", false, "relay through -turn without trying the receiver directly first")
+	fs.Var(&cfg.dscp, "dscp", "mark the stream's packets with this DSCP, e.g. EF, AF41 or 46, for networks that prioritize by it (default: unmarked)")
+	fs.TextVar(&cfg.bind, "bind", netip.Addr{}, "send the stream from this local address, e.g. 192.0.2.10, so a multihomed host uses the network it is on (default: any)")
+	fs.StringVar(&cfg.iface, "interface", "", "send the stream out of this network interface, e.g. eth1, whatever the routing table says (Linux only; default: any)")
+	fs.BoolVar(&cfg.ice, "ice", false, "take the URL of a server running with -ice and -stats-addr as the destination, e.g. http://192.0.2.7:8080, and pick the best of the paths to its RTP port with ICE-lite (UDP only; token from ICE_TOKEN)")
+	fs.DurationVar(&cfg.keepalive, "keepalive", 0, "send a STUN keepalive to the receiver this often, paused or not, and log when it stops answering and comes back, e.g. 5s (UDP only; 0 = never)")
+	fs.StringVar(&cfg.spool, "spool", "", "keep the audio captured while the receiver is down, as told by -keepalive, in WAV files in this directory (default: dropped)")
+	fs.Func("backup", "fail over to these destinations, in order, e.g. 192.0.2.8:6001,192.0.2.9:6001, when the one sent to keeps failing or stops reporting, and switch back once the destination given answers STUN again (default: none)", func(s string) error {
+		cfg.backups = nil
+		for _, d := range strings.Split(s, ",") {
+			if d = strings.TrimSpace(d); d != "" {
+				cfg.backups = append(cfg.backups, d)
+			}
+		}
+		return nil
+	})
+	fs.DurationVar(&cfg.failoverAfter, "failover-after", 5*time.Second, "how long sending keeps failing, or the receiver stays silent, before failing over to a -backup")
+	fs.DurationVar(&cfg.reportInterval, "report-interval", 30*time.Second, "how often to log the sent bitrate and packet rate (0 = never)")
+	fs.IntVar(&cfg.sendQueue, "send-queue", 10, "20 ms reads of audio queued while sending is blocked; the oldest are dropped beyond that")
+	fs.Var(&cfg.maxBandwidth, "max-bandwidth", "send the audio at most at this rate, headers included, e.g. 2mbps or 500kbps, holding what goes over back and dropping the oldest queued audio if it can't keep up (default: unlimited)")
+	fs.IntVar(&cfg.tuning.Priority, "rt-priority", 0, "run the capture and send threads at this real-time priority, 1-99, on Linux (0 = normal scheduling)")
+	fs.StringVar(&cfg.tuning.Policy, "rt-policy", "fifo", "real-time scheduling policy for -rt-priority: fifo or rr")
+	cpuAffinity := fs.String("cpu-affinity", "", "pin the capture and send threads to these CPUs on Linux, e.g. 2 or 2-3 (default: any)")
+	fs.StringVar(&cfg.source, "source", "browser", "where the audio comes from: "+strings.Join(capture.Sources(), ", "))
+	fs.StringVar(&cfg.backend, "backend", "", "another name for -source, e.g. -backend=fake")
+	fs.StringVar(&cfg.pulseServer, "pulse-server", "", "create the browser's sink on and record the pulse source from this PulseAudio server instead of the local one, e.g. tcp:192.0.2.7:4713 for one on another machine or in a container (default: the one PULSE_SERVER names, else the local daemon)")
+	fs.BoolVar(&cfg.startPulse, "start-pulse", false, "start a sound server of the client's own when none is running, pulseaudio or else PipeWire, and stop it on exit, for the browser and pulse sources on headless hosts and in containers")
+	fs.BoolVar(&cfg.pinVolume, "pin-volume", false, "keep the page's streams on the browser's sink at 100% and unmuted, setting them again whenever the page turns them down (the sink itself always is)")
+	fs.StringVar(&cfg.encoding, "encoding", "l16", "RTP payload encoding: "+strings.Join(rtpstream.Encoders(), ", ")+" (opus needs ffmpeg, -rate=48000 and 1 or 2 -channels)")
+	fs.StringVar(&cfg.transport, "transport", "udp", "how packets are carried: "+strings.Join(rtpstream.Transports(), ", ")+" (whip publishes to the WHIP endpoint the destination is the URL of, e.g. http://192.0.2.7:8080/whip, with -encoding=opus; token from WHIP_TOKEN)")
+	fs.IntVar(&cfg.sampleRate, "rate", 48000, "sample rate to capture and send in Hz")
+	fs.IntVar(&cfg.channels, "channels", 1, "channels to capture and send (1 for mono, 2 for stereo)")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s [flags] <input> <destination_host:port>\n", fs.Name())
+		fmt.Fprintf(fs.Output(), "       %s -daemon [-api-addr host:port] [flags]\n", fs.Name())
+		fmt.Fprintf(fs.Output(), "\nThe input is the URL of the page to play for -source=browser, a device for pulse and alsa, a file for file and a frequency for tone.\n")
+		fmt.Fprintf(fs.Output(), "The destination is a host:port, or the -mdns name of a server on the local network, or auto for whichever answers first, or a URL: a server's with -ice, a WHIP endpoint's with -transport=whip.\n")
+		fmt.Fprintf(fs.Output(), "\nExample: %s 'https://www.youtube.com/watch?v=dQw4w9WgXcQ' 127.0.0.1:5004\n\n", fs.Name())
+		fs.PrintDefaults()
+	}
+	fs.String("config", "", "read the options not given as flags or "+config.EnvPrefix+"* variables from this file of name = value lines (default: $"+config.EnvName("config")+", or none)")
+	var err error
+	if cfg.settings, err = config.Parse(fs, args); err != nil {
+		return nil, err
+	}
+	switch {
+	case cfg.daemon && fs.NArg() != 0:
+		fs.Usage()
+		return nil, errors.New("-daemon takes no input or destination, sessions get theirs from the API")
+	case cfg.daemon && cfg.debugPcap != "":
+		return nil, errors.New("-debug-pcap can't be combined with -daemon")
+	case !cfg.daemon && fs.NArg() != 2:
+		fs.Usage()
+		return nil, errors.New("an input and a destination are required")
+	}
+	if cfg.backend != "" {
+		for _, s := range cfg.settings {
+			if s.Name == "source" && s.Source != "default" && s.Value != cfg.backend {
+				return nil, fmt.Errorf("-backend=%s and -source=%s disagree: -backend is another name for -source, give one", cfg.backend, s.Value)
+			}
+		}
+		cfg.source = cfg.backend
+	}
+	if cfg.daemon && cfg.apiAddr == "" {
+		cfg.apiAddr = defaultAPIAddr
+	}
+	if cfg.LogFormat != logText && cfg.LogFormat != logJSON {
+		return nil, fmt.Errorf("unknown log format %q (use %s or %s)", cfg.LogFormat, logText, logJSON)
+	}
+	if cfg.sendQueue < 1 {
+		return nil, errors.New("-send-queue must be at least 1")
+	}
+	if cfg.tuning.Priority < 0 || cfg.tuning.Priority > 99 {
+		return nil, errors.New("-rt-priority must be between 0 and 99")
+	}
+	if cfg.tuning.Policy != "fifo" && cfg.tuning.Policy != "rr" {
+		return nil, errors.New("-rt-policy must be fifo or rr")
+	}
+	if *cpuAffinity != "" {
+		if cfg.tuning.CPUs, err = rtpstream.ParseCPUList(*cpuAffinity); err != nil {
+			return nil, fmt.Errorf("-cpu-affinity: %w", err)
+		}
+	}
+	if cfg.sampleRate < 1 || cfg.channels < 1 {
+		return nil, errors.New("-rate and -channels must be at least 1")
+	}
+	for _, p := range cfg.plugins {
+		if _, err := exec.LookPath(p.Command[0]); err != nil {
+			return nil, fmt.Errorf("plugin %s: %w", p.Name, err)
+		}
+	}
+	if cfg.turnAlways && cfg.turn == "" {
+		return nil, errors.New("-turn-always needs -turn")
+	}
+	if cfg.iface != "" {
+		if _, err := net.InterfaceByName(cfg.iface); err != nil {
+			return nil, fmt.Errorf("-interface: %w", err)
+		}
+	}
+	if cfg.spool != "" {
+		if cfg.keepalive == 0 {
+			return nil, errors.New("-spool needs -keepalive, which tells when the receiver is down")
+		}
+		if info, err := os.Stat(cfg.spool); err != nil || !info.IsDir() {
+			return nil, fmt.Errorf("-spool %s is not a directory", cfg.spool)
+		}
+	}
+	if cfg.failoverAfter < 2*time.Second {
+		return nil, errors.New("-failover-after must be at least 2s")
+	}
+	if cfg.keepalive < 0 {
+		return nil, errors.New("-keepalive can't be negative")
+	}
+	if cfg.formatExt < 0 {
+		return nil, errors.New("-format-ext can't be negative")
+	}
+	if cfg.metadataPort < 0 || cfg.metadataPort > 65535 {
+		return nil, fmt.Errorf("invalid -metadata-port %d", cfg.metadataPort)
+	}
+	if cfg.startPulse && cfg.pulseServer != "" {
+		return nil, errors.New("-start-pulse starts a local sound server, which -pulse-server would bypass")
+	}
+	if cfg.pulseServer != "" {
+		if err := capture.ValidatePulseServer(cfg.pulseServer); err != nil {
+			return nil, fmt.Errorf("-pulse-server: %w", err)
+		}
+	}
+	if cfg.ice && !cfg.daemon && !strings.HasPrefix(fs.Arg(1), "http://") && !strings.HasPrefix(fs.Arg(1), "https://") {
+		return nil, errors.New("-ice takes the server's URL as the destination, e.g. http://192.0.2.7:8080")
+	}
+	if cfg.transport == "whip" {
+		if cfg.encoding != "opus" {
+			return nil, errors.New("-transport=whip carries Opus only: add -encoding=opus")
+		}
+		if !cfg.daemon && !strings.HasPrefix(fs.Arg(1), "http://") && !strings.HasPrefix(fs.Arg(1), "https://") {
+			return nil, errors.New("-transport=whip takes the WHIP endpoint's URL as the destination, e.g. http://192.0.2.7:8080/whip")
+		}
+	}
+	cfg.Input, cfg.Destination = fs.Arg(0), fs.Arg(1)
+	return cfg, nil
+}
+
+// Settings returns the value of every option and whether it came from a
+// flag, the environment, the config file or the default.
+func (cfg *Config) Settings() []config.Setting { return cfg.settings }
+
+// Run captures and streams until ctx is done or the audio ends, and returns
+// once the capture and everything else it started stopped. It logs to the
+// default logger, and returns an error when the capture or the stream can't
+// be started.
+func Run(ctx context.Context, cfg *Config) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	if cfg.pprofAddr != "" {
+		go servePprof(ctx, cfg.pprofAddr)
+	}
+	if cfg.startPulse {
+		sound, err := capture.StartSoundDaemon(ctx, slog.With("component", "pulse"))
+		if err != nil {
+			return fmt.Errorf("starting a sound server failed: %w", err)
+		}
+		defer sound.Close()
+	}
+	if cfg.daemon {
+		if cfg.healthAddr != "" {
+			go serveHealth(ctx, cfg.healthAddr, func() error { return nil })
+		}
+		return runDaemon(ctx, cfg)
+	}
+	stats := rtpstream.NewStats(cfg.alertLoss, cfg.alertJitter)
+	stats.Publish()
+	var pcapFile *pcap.Writer
+	if cfg.debugPcap != "" {
+		var err error
+		if pcapFile, err = pcap.Create(cfg.debugPcap); err != nil {
+			return fmt.Errorf("creating the packet capture failed: %w", err)
+		}
+		slog.Info("🦈 Capturing packets", "file", cfg.debugPcap)
+		defer func() {
+			if err := pcapFile.Close(); err != nil {
+				slog.Error("Writing the packet capture failed", "file", cfg.debugPcap, "err", err)
+			}
+		}()
+	}
+	if cfg.healthAddr != "" {
+		go serveHealth(ctx, cfg.healthAddr, func() error { return streaming(stats) })
+	}
+
+	// Capture from the source, by default playing the page into a
+	// PulseAudio sink of its own and recording the sink, and stream the
+	// recording to the destination
+	id := newSessionID()
+	sess, err := startSession(ctx, cfg, id, cfg.params(), stats, pcapFile, slog.With("session", id))
+	if err != nil {
+		return err
+	}
+	if cfg.apiAddr != "" || cfg.grpcAddr != "" {
+		api := newSessionAPI(ctx, cfg, false)
+		api.add(sess)
+		if cfg.apiAddr != "" {
+			go func() {
+				if err := api.serve(cfg.apiAddr); err != nil {
+					api.log.Error("Session API failed", "err", err)
+				}
+			}()
+		}
+		if cfg.grpcAddr != "" {
+			go func() {
+				if err := api.serveGRPC(cfg.grpcAddr); err != nil {
+					api.log.Error("gRPC server failed", "err", err)
+				}
+			}()
+		}
+	}
+
+	// Wait for shutdown, the end of the audio or a stop through the API,
+	// and clean up
+	select {
+	case <-ctx.Done():
+		slog.Info("🛑 Received shutdown signal, cleaning up")
+	case <-sess.stream.Done():
+		if ctx.Err() == nil && sess.ctx.Err() != nil {
+			slog.Info("🛑 Stopped through the API, cleaning up")
+		} else {
+			slog.Info("🛑 The audio ended, cleaning up")
+		}
+	}
+	sess.stop()
+	return nil
+}
+
+// streaming says why a stream isn't ready, when no packet was sent for
+// readyWindow.
+func streaming(stats *rtpstream.Stats) error {
+	last := stats.LastSent()
+	if last.IsZero() {
+		return errors.New("not streaming yet")
+	}
+	if idle := time.Since(last); idle > readyWindow {
+		return fmt.Errorf("no packets sent for %s", idle.Round(time.Second))
+	}
+	return nil
+}
+
+// serveHealth serves probes for orchestrators on addr: /healthz answers as
+// long as the client runs, /readyz only while ready returns nil, e.g. while
+// it is capturing and sending audio. It stops when ctx is done.
+func serveHealth(ctx context.Context, addr string, ready func() error) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "ok")
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		if err := ready(); err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprintln(w, "ok")
+	})
+	log := slog.With("component", "health")
+	log.Info("🩺 Serving health probes", "url", "http://"+addr+"/readyz")
+	if err := serveHTTP(ctx, addr, mux); err != nil {
+		log.Error("Health server failed", "err", err)
+	}
+}
+
+// servePprof serves the net/http/pprof profiles under /debug/pprof/ on addr,
+// for profiling a long-running client, and the expvar counters on /debug/vars,
+// until ctx is done.
+func servePprof(ctx context.Context, addr string) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.Handle("/debug/vars", expvar.Handler())
+	log := slog.With("component", "pprof")
+	log.Info("🩺 Serving pprof profiles", "url", "http://"+addr+"/debug/pprof/")
+	if err := serveHTTP(ctx, addr, mux); err != nil {
+		log.Error("pprof server failed", "err", err)
+	}
+}
+
+// serveHTTP serves handler on addr until ctx is done.
+func serveHTTP(ctx context.Context, addr string, handler http.Handler) error {
+	hs := &http.Server{Addr: addr, Handler: handler}
+	go func() {
+		<-ctx.Done()
+		hs.Close()
+	}()
+	if err := hs.ListenAndServe(); err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,131 @@
+package client
+
+import (
+	"context"
+	"crypto/subtle"
+	"net"
+	"os"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/fcerini/audio-capture-shared/audiopb"
+)
+
+// controlService implements the Control gRPC service the client shares with
+// the server, over the sessions of the session API.
+type controlService struct {
+	audiopb.UnimplementedControlServer
+	api *sessionAPI
+}
+
+// serveGRPC serves the Control service on addr until the API's context is
+// done. With API_TOKEN set, calls need it as a bearer token in the
+// authorization metadata, as on the server.
+func (a *sessionAPI) serveGRPC(addr string) error {
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	g := grpc.NewServer(grpc.UnaryInterceptor(func(ctx context.Context, req any, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		if err := grpcAuthorize(ctx); err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}))
+	audiopb.RegisterControlServer(g, &controlService{api: a})
+	a.log.Info("📡 Serving the gRPC API", "addr", addr)
+	go func() {
+		<-a.ctx.Done()
+		g.Stop()
+	}()
+	return g.Serve(lis)
+}
+
+func grpcAuthorize(ctx context.Context) error {
+	token := os.Getenv("API_TOKEN")
+	if token == "" {
+		return nil
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	for _, v := range md.Get("authorization") {
+		if got, ok := strings.CutPrefix(v, "Bearer "); ok && subtle.ConstantTimeCompare([]byte(got), []byte(token)) == 1 {
+			return nil
+		}
+	}
+	return status.Error(codes.Unauthenticated, "missing or wrong API token")
+}
+
+func (c *controlService) CreateSession(_ context.Context, req *audiopb.CreateSessionRequest) (*audiopb.ControlSession, error) {
+	if !c.api.daemon {
+		return nil, status.Error(codes.Unimplemented, "sessions can only be started with -daemon")
+	}
+	p := SessionParams{
+		Source:      req.Source,
+		Input:       req.Input,
+		Destination: req.Destination,
+		Encoding:    req.Encoding,
+		Transport:   req.Transport,
+		SampleRate:  int(req.SampleRate),
+		Channels:    int(req.Channels),
+	}
+	if err := c.api.validate(c.api.cfg.withDefaults(p)); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	s, err := c.api.start(p)
+	if err != nil {
+		return nil, status.Error(codes.Unavailable, err.Error())
+	}
+	return s.control(), nil
+}
+
+func (c *controlService) GetStats(_ context.Context, req *audiopb.GetStatsRequest) (*audiopb.GetStatsResponse, error) {
+	resp := &audiopb.GetStatsResponse{}
+	if req.Session != "" {
+		s := c.api.get(req.Session)
+		if s == nil {
+			return nil, status.Errorf(codes.NotFound, "no session %q", req.Session)
+		}
+		resp.Sessions = append(resp.Sessions, s.control())
+		return resp, nil
+	}
+	for _, s := range c.api.all() {
+		resp.Sessions = append(resp.Sessions, s.control())
+	}
+	return resp, nil
+}
+
+func (c *controlService) FinalizeRecording(_ context.Context, req *audiopb.FinalizeRecordingRequest) (*audiopb.ControlSession, error) {
+	s := c.api.get(req.Session)
+	if s == nil {
+		return nil, status.Errorf(codes.NotFound, "no session %q", req.Session)
+	}
+	s.stop()
+	c.api.log.Info("🛑 Stopped session", "session", s.id)
+	return s.control(), nil
+}
+
+// control describes the session for the Control service.
+func (s *session) control() *audiopb.ControlSession {
+	st := s.status()
+	counters := st.Counters
+	stats := &audiopb.SessionStats{
+		Packets: uint64(counters.PacketsSent),
+		Bytes:   uint64(counters.BytesSent),
+		Dropped: uint64(counters.ReadsDropped),
+	}
+	if lost, loss, jitter, ok := s.stats.Reception(); ok {
+		stats.PacketsLost, stats.LossPercent, stats.JitterMs = int64(lost), loss, jitter
+	}
+	return &audiopb.ControlSession{
+		Id:    st.ID,
+		State: st.State,
+		Peer:  st.Destination,
+		Start: timestamppb.New(st.Started),
+		Stats: stats,
+	}
+}
@@ -0,0 +1,24 @@
+package client
+
+import (
+	"log/slog"
+	"os"
+)
+
+// Formats of the log, chosen with -log-format.
+const (
+	logText = "text" // key=value lines
+	logJSON = "json" // One JSON object per line, for log collectors
+)
+
+// SetupLogging makes the default slog logger, which the client logs
+// through, write to stderr at cfg.LogLevel in cfg.LogFormat. The records of
+// a session carry its session ID, and those of its stream the destination.
+func SetupLogging(cfg *Config) {
+	opts := &slog.HandlerOptions{Level: cfg.LogLevel}
+	var h slog.Handler = slog.NewTextHandler(os.Stderr, opts)
+	if cfg.LogFormat == logJSON {
+		h = slog.NewJSONHandler(os.Stderr, opts)
+	}
+	slog.SetDefault(slog.New(h))
+}
@@ -0,0 +1,77 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/fcerini/audio-capture-client/pkg/rtpstream"
+)
+
+const metadataTimeout = 2 * time.Second // To connect to the server and for its answer
+
+// sessionMetadata is what the client tells the server of a session with
+// -metadata-port, a line of JSON whose answer is one too; see the server's
+// -metadata-addr.
+type sessionMetadata struct {
+	SSRC     uint32    `json:"ssrc"`             // Of the stream the metadata is of
+	Source   string    `json:"source,omitempty"` // The input, e.g. the URL of the page played
+	Title    string    `json:"title,omitempty"`
+	Operator string    `json:"operator,omitempty"`
+	Start    time.Time `json:"start"`
+}
+
+// metadataAnswer is the server's answer.
+type metadataAnswer struct {
+	OK      bool   `json:"ok"`
+	Session string `json:"session,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// sendMetadata tells md to the server stream is sent to, on port of its host,
+// and logs its answer. A server that doesn't take it is warned of, and the
+// stream goes on without.
+func sendMetadata(ctx context.Context, stream *rtpstream.Stream, port int, md sessionMetadata, log *slog.Logger) {
+	host, _, err := net.SplitHostPort(stream.Destination())
+	if err != nil {
+		log.Warn("Telling the server the session's metadata failed", "err", err)
+		return
+	}
+	addr := net.JoinHostPort(host, strconv.Itoa(port))
+	if err := tellMetadata(ctx, addr, md); err != nil {
+		log.Warn("Telling the server the session's metadata failed", "addr", addr, "err", err)
+		return
+	}
+	log.Info("🏷️  Told the server the session's metadata", "addr", addr, "title", md.Title, "operator", md.Operator)
+}
+
+// tellMetadata sends md to addr and waits for the answer.
+func tellMetadata(ctx context.Context, addr string, md sessionMetadata) error {
+	d := net.Dialer{Timeout: metadataTimeout}
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(metadataTimeout))
+	if err := json.NewEncoder(conn).Encode(md); err != nil {
+		return err
+	}
+	line, err := bufio.NewReader(conn).ReadBytes('\n')
+	if err != nil {
+		return err
+	}
+	var answer metadataAnswer
+	if err := json.Unmarshal(line, &answer); err != nil {
+		return err
+	}
+	if !answer.OK {
+		return errors.New(answer.Error)
+	}
+	return nil
+}
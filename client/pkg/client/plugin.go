@@ -0,0 +1,50 @@
+package client
+
+import (
+	"io"
+
+	"github.com/fcerini/audio-capture-shared/pkg/plugin"
+)
+
+// pluginReader runs the audio of a source through -plugin processes, 20 ms
+// at a time.
+type pluginReader struct {
+	audio   io.Reader
+	plugins *plugin.Chain
+	buf     []byte
+	out     []byte // Processed and not read yet
+}
+
+func newPluginReader(audio io.Reader, plugins *plugin.Chain, sampleRate, channels int) *pluginReader {
+	return &pluginReader{audio: audio, plugins: plugins, buf: make([]byte, sampleRate/50*channels*2)}
+}
+
+func (r *pluginReader) Read(p []byte) (int, error) {
+	if len(r.out) == 0 {
+		n, err := io.ReadFull(r.audio, r.buf)
+		n -= n % 2 // The audio ended in the middle of a sample
+		if n == 0 {
+			if err == io.ErrUnexpectedEOF {
+				err = io.EOF
+			}
+			return 0, err
+		}
+		// The plugins take little-endian samples, the sources give
+		// big-endian ones
+		pcm := r.buf[:n]
+		swapBytes(pcm)
+		r.plugins.Process(pcm)
+		swapBytes(pcm)
+		r.out = pcm
+	}
+	n := copy(p, r.out)
+	r.out = r.out[n:]
+	return n, nil
+}
+
+// swapBytes turns 16-bit samples from one byte order to the other.
+func swapBytes(pcm []byte) {
+	for i := 0; i+1 < len(pcm); i += 2 {
+		pcm[i], pcm[i+1] = pcm[i+1], pcm[i]
+	}
+}
@@ -0,0 +1,206 @@
+package client
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/fcerini/audio-capture-client/pkg/capture"
+	"github.com/fcerini/audio-capture-client/pkg/rtpstream"
+	"github.com/fcerini/audio-capture-shared/pkg/pcap"
+	"github.com/fcerini/audio-capture-shared/pkg/plugin"
+)
+
+// SessionParams are what a session captures and where it streams it to. The
+// fields left empty take the client's flags.
+type SessionParams struct {
+	Source      string   `json:"source,omitempty"`
+	Input       string   `json:"input"`       // Of the source, e.g. the URL of the page to play
+	Destination string   `json:"destination"` // host:port of the receiver
+	Encoding    string   `json:"encoding,omitempty"`
+	Transport   string   `json:"transport,omitempty"`
+	SampleRate  int      `json:"rate,omitempty"`
+	Channels    int      `json:"channels,omitempty"`
+	Backups     []string `json:"backups,omitempty"` // Destinations to fail over to, in order
+	Title       string   `json:"title,omitempty"`   // Told to the server with -metadata-port
+	Operator    string   `json:"operator,omitempty"`
+}
+
+// params are the session of the input and destination given on the command
+// line.
+func (cfg *Config) params() SessionParams {
+	return SessionParams{Input: cfg.Input, Destination: cfg.Destination}
+}
+
+// withDefaults fills the fields of p left empty from the flags.
+func (cfg *Config) withDefaults(p SessionParams) SessionParams {
+	if p.Source == "" {
+		p.Source = cfg.source
+	}
+	if p.Encoding == "" {
+		p.Encoding = cfg.encoding
+	}
+	if p.Transport == "" {
+		p.Transport = cfg.transport
+	}
+	if p.SampleRate == 0 {
+		p.SampleRate = cfg.sampleRate
+	}
+	if p.Channels == 0 {
+		p.Channels = cfg.channels
+	}
+	if p.Backups == nil {
+		p.Backups = cfg.backups
+	}
+	if p.Title == "" {
+		p.Title = cfg.title
+	}
+	if p.Operator == "" {
+		p.Operator = cfg.operator
+	}
+	return p
+}
+
+// session is a capture streaming to a destination, until its context is
+// canceled or the audio ends.
+type session struct {
+	id      string
+	params  SessionParams
+	started time.Time
+	stats   *rtpstream.Stats
+	src     capture.Source
+	plugins *plugin.Chain // nil without -plugin
+	stream  *rtpstream.Stream
+	ctx     context.Context // Canceled once stopped
+	cancel  context.CancelFunc
+
+	stopOnce sync.Once
+}
+
+// startSession opens the capture of p and starts streaming it as session id,
+// on a context of its own derived from ctx.
+func startSession(ctx context.Context, cfg *Config, id string, p SessionParams, stats *rtpstream.Stats, pcapFile *pcap.Writer, log *slog.Logger) (*session, error) {
+	p = cfg.withDefaults(p)
+	if p.Input == "" || p.Destination == "" {
+		return nil, errors.New("an input and a destination are required")
+	}
+	if p.SampleRate < 1 || p.Channels < 1 {
+		return nil, errors.New("the rate and the channels must be at least 1")
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	src, err := capture.Open(ctx, p.Source, capture.Options{Input: p.Input, SampleRate: p.SampleRate, Channels: p.Channels, PulseServer: cfg.pulseServer, PinVolume: cfg.pinVolume, Log: log})
+	if err != nil {
+		cancel()
+		return nil, fmt.Errorf("starting the capture failed: %w", err)
+	}
+
+	plugins, err := plugin.StartChain(cfg.plugins, plugin.Format{SampleRate: p.SampleRate, Channels: p.Channels, BitDepth: 16}, log.With("component", "plugin"))
+	if err != nil {
+		cancel()
+		src.Close()
+		return nil, err
+	}
+	audio := src.Audio()
+	if plugins != nil {
+		audio = newPluginReader(audio, plugins, p.SampleRate, p.Channels)
+	}
+
+	streamLog := log.With("component", "stream", "destination", p.Destination)
+	streamLog.Info("📡 Streaming audio", "source", p.Source, "encoding", p.Encoding, "transport", p.Transport, "rate", p.SampleRate, "channels", p.Channels)
+	stream, err := rtpstream.Dial(ctx, rtpstream.Config{
+		Destination:    p.Destination,
+		SampleRate:     p.SampleRate,
+		Channels:       p.Channels,
+		Encoding:       p.Encoding,
+		Transport:      p.Transport,
+		SendQueue:      cfg.sendQueue,
+		MaxBandwidth:   cfg.maxBandwidth,
+		Backups:        p.Backups,
+		FailoverAfter:  cfg.failoverAfter,
+		RTCPInterval:   cfg.rtcpInterval,
+		Handshake:      cfg.handshake,
+		FormatInterval: cfg.formatExt,
+		STUN:           cfg.stun,
+		TURN:           cfg.turn,
+		TURNUsername:   os.Getenv("TURN_USERNAME"),
+		TURNPassword:   os.Getenv("TURN_PASSWORD"),
+		TURNAlways:     cfg.turnAlways,
+		ICE:            cfg.ice,
+		DSCP:           cfg.dscp,
+		Bind:           cfg.bind,
+		Interface:      cfg.iface,
+		Keepalive:      cfg.keepalive,
+		Spool:          cfg.spool,
+		ICEToken:       os.Getenv("ICE_TOKEN"),
+		WHIPToken:      os.Getenv("WHIP_TOKEN"),
+		ReportInterval: cfg.reportInterval,
+		Tuning:         cfg.tuning,
+		Stats:          stats,
+		Pcap:           pcapFile,
+		Log:            streamLog,
+	})
+	if err != nil {
+		cancel()
+		src.Close()
+		plugins.Close()
+		return nil, fmt.Errorf("starting streaming failed: %w", err)
+	}
+	started := time.Now()
+	if cfg.metadataPort > 0 {
+		// Before the first packet, so the server names the first file by it
+		sendMetadata(ctx, stream, cfg.metadataPort, sessionMetadata{
+			SSRC: stream.SSRC(), Source: p.Input, Title: p.Title, Operator: p.Operator, Start: started,
+		}, streamLog)
+	}
+	stream.Start(audio)
+	return &session{
+		id:      id,
+		params:  p,
+		started: started,
+		stats:   stats,
+		src:     src,
+		plugins: plugins,
+		stream:  stream,
+		ctx:     ctx,
+		cancel:  cancel,
+	}, nil
+}
+
+// stop stops the capture and returns once everything read was sent and the
+// source is closed. It can be called more than once, and from more than one
+// goroutine.
+func (s *session) stop() {
+	s.stopOnce.Do(func() {
+		s.cancel()
+		<-s.stream.Done()
+		s.src.Close()
+		s.plugins.Close()
+	})
+}
+
+// ended reports whether the stream stopped, when asked to or because the
+// audio ended.
+func (s *session) ended() bool {
+	select {
+	case <-s.stream.Done():
+		return true
+	default:
+		return false
+	}
+}
+
+// newSessionID returns a random ID for a session, like the server's.
+func newSessionID() string {
+	b := make([]byte, 4)
+	if _, err := rand.Read(b); err != nil {
+		// Extremely unlikely; fall back to something that is still unique enough.
+		return fmt.Sprintf("%08x", time.Now().UnixNano()&0xffffffff)
+	}
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,79 @@
+package rtpstream
+
+import (
+	"io"
+	"log/slog"
+	"net"
+
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+// batchSender sends the packets of one read together: on Linux as one
+// segmented buffer with UDP GSO, or with a single sendmmsg call where the
+// route can't segment, and one write per packet elsewhere. The messages are
+// reused for every batch.
+type batchSender struct {
+	conn interface {
+		WriteBatch(ms []ipv4.Message, flags int) (int, error)
+	}
+	msgs []ipv4.Message
+	gso  *gsoSender // nil without UDP GSO
+	log  *slog.Logger
+}
+
+// newBatchSender sends on conn, which is connected, so the messages need no
+// address. It has messages for size packets at first, and more when a batch
+// needs them.
+func newBatchSender(conn *net.UDPConn, size int, log *slog.Logger) *batchSender {
+	b := &batchSender{gso: newGSOSender(conn), log: log}
+	b.grow(size)
+	if conn.RemoteAddr().(*net.UDPAddr).IP.To4() != nil {
+		b.conn = ipv4.NewPacketConn(conn)
+	} else {
+		b.conn = ipv6.NewPacketConn(conn)
+	}
+	return b
+}
+
+// send sends packets and returns how many of them went out before an error.
+func (b *batchSender) send(packets [][]byte) (int, error) {
+	if b.gso != nil && len(packets) > 1 {
+		ok, err := b.gso.send(packets)
+		switch {
+		case ok && err == nil:
+			return len(packets), nil
+		case ok && gsoUnsupported(err):
+			b.log.Info("📦 UDP segmentation offload unavailable, sending packets with sendmmsg", "err", err)
+			b.gso = nil
+		case ok:
+			return 0, err
+		}
+	}
+	b.grow(len(packets))
+	msgs := b.msgs[:len(packets)]
+	for i, p := range packets {
+		msgs[i].Buffers[0] = p
+	}
+	// sendmmsg stops at the first message that fails, and reports the error
+	// by itself on the next call if it had sent any
+	sent := 0
+	for sent < len(msgs) {
+		n, err := b.conn.WriteBatch(msgs[sent:], 0)
+		if err != nil {
+			return sent, err
+		}
+		if n <= 0 {
+			return sent, io.ErrShortWrite
+		}
+		sent += n
+	}
+	return sent, nil
+}
+
+// grow makes sure there are messages for n packets.
+func (b *batchSender) grow(n int) {
+	for len(b.msgs) < n {
+		b.msgs = append(b.msgs, ipv4.Message{Buffers: make([][]byte, 1)})
+	}
+}
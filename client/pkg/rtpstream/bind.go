@@ -0,0 +1,75 @@
+package rtpstream
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"strings"
+	"syscall"
+)
+
+// bindKey is the key of the context value that binds the stream's sockets to
+// cfg.Bind and cfg.Interface, set by dial for every socket it opens.
+type bindKey struct{}
+
+// binding is what a stream's sockets are bound to.
+type binding struct {
+	addr   netip.Addr // The source address; any when invalid
+	device string     // The interface; any when empty
+}
+
+func withBinding(ctx context.Context, cfg Config) context.Context {
+	if !cfg.Bind.IsValid() && cfg.Interface == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, bindKey{}, binding{addr: cfg.Bind, device: cfg.Interface})
+}
+
+// dialer returns a dialer for network bound as ctx asks: to the binding, and
+// to the port of localAddrKey.
+func dialer(ctx context.Context, network string) net.Dialer {
+	var d net.Dialer
+	b, _ := ctx.Value(bindKey{}).(binding)
+	port := 0
+	if local, ok := ctx.Value(localAddrKey{}).(*net.UDPAddr); ok {
+		port = local.Port
+	}
+	if b.addr.IsValid() || port != 0 {
+		ip := net.IP(b.addr.AsSlice())
+		if strings.HasPrefix(network, "tcp") {
+			d.LocalAddr = &net.TCPAddr{IP: ip, Port: port}
+		} else {
+			d.LocalAddr = &net.UDPAddr{IP: ip, Port: port}
+		}
+	}
+	if b.device != "" {
+		d.Control = bindControl(b.device)
+	}
+	return d
+}
+
+// listenPacket opens a UDP socket of network on a port of its own, bound as
+// ctx asks.
+func listenPacket(ctx context.Context, network string) (net.PacketConn, error) {
+	var lc net.ListenConfig
+	b, _ := ctx.Value(bindKey{}).(binding)
+	if b.device != "" {
+		lc.Control = bindControl(b.device)
+	}
+	host := ""
+	if b.addr.IsValid() {
+		host = b.addr.String()
+	}
+	return lc.ListenPacket(ctx, network, net.JoinHostPort(host, "0"))
+}
+
+// bindControl returns the control function binding a socket to device.
+func bindControl(device string) func(network, address string, c syscall.RawConn) error {
+	return func(network, address string, c syscall.RawConn) error {
+		var err error
+		if cerr := c.Control(func(fd uintptr) { err = bindDevice(fd, device) }); cerr != nil {
+			return cerr
+		}
+		return err
+	}
+}
@@ -0,0 +1,17 @@
+package rtpstream
+
+import (
+	"fmt"
+
+	"golang.org/x/sys/unix"
+)
+
+// bindDevice binds the socket fd to the interface device, so its packets
+// leave through it whatever the routing table says. It takes CAP_NET_RAW
+// before Linux 5.7.
+func bindDevice(fd uintptr, device string) error {
+	if err := unix.SetsockoptString(int(fd), unix.SOL_SOCKET, unix.SO_BINDTODEVICE, device); err != nil {
+		return fmt.Errorf("binding to interface %s failed: %w", device, err)
+	}
+	return nil
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+
+package rtpstream
+
+import "errors"
+
+// bindDevice is only supported on Linux.
+func bindDevice(fd uintptr, device string) error {
+	return errors.New("-interface is only supported on Linux; -bind one of its addresses instead")
+}
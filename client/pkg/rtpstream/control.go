@@ -0,0 +1,101 @@
+package rtpstream
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+)
+
+// Pause stops sending the audio, which is still read and thrown away, until
+// Resume. The RTP timestamps go on counting, so receivers see the pause as a
+// gap rather than the audio after it arriving early.
+func (s *Stream) Pause() { s.paused.Store(true) }
+
+// Resume sends the audio again after Pause.
+func (s *Stream) Resume() { s.paused.Store(false) }
+
+// Paused reports whether the stream is paused.
+func (s *Stream) Paused() bool { return s.paused.Load() }
+
+// SetGain amplifies, or attenuates when negative, the audio sent by db
+// decibels from the next read on, clipping what gets too loud. 0 sends it as
+// captured.
+func (s *Stream) SetGain(db float64) { s.gain.Store(math.Float64bits(db)) }
+
+// Gain returns the gain set with SetGain, in decibels.
+func (s *Stream) Gain() float64 { return math.Float64frombits(s.gain.Load()) }
+
+// Destination returns the host:port the stream is sent to.
+func (s *Stream) Destination() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.destination
+}
+
+// SSRC returns the SSRC of the stream's packets, which a receiver tells it
+// by.
+func (s *Stream) SSRC() uint32 { return s.ssrc }
+
+// Redirect sends the stream to destination from the next packet on, over a
+// new connection of the same transport, and closes the old one. The SSRC and
+// the timestamps go on, so a receiver that follows the stream sees no jump.
+// The stream stays on its destination when the new one can't be dialed. With
+// Handshake, the new receiver is offered the format too, and with
+// FormatInterval the next packet carries it. With Backups,
+// destination becomes the primary.
+func (s *Stream) Redirect(destination string) error {
+	from := s.Destination()
+	if err := s.redirect(destination); err != nil {
+		return err
+	}
+	s.failover.setPrimary(destination)
+	s.cfg.Log.Info("🔀 Redirected the stream", "from", from, "to", destination)
+	return nil
+}
+
+// redirect sends the stream to destination, see Redirect.
+func (s *Stream) redirect(destination string) error {
+	conn, err := dial(s.ctx, s.cfg, destination)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	select {
+	case <-s.done:
+		s.mu.Unlock()
+		conn.Close()
+		return errors.New("the stream ended")
+	default:
+	}
+	old := s.conn
+	s.conn, s.destination = conn, destination
+	s.mu.Unlock()
+	old.Close()
+	// The old receiver's reports would only time out
+	s.cfg.Stats.receivers.forget()
+	s.live.reset()
+	go s.cfg.Stats.receivers.read(s.ctx, conn, s.ssrc, &s.cfg.Stats.send, s.cfg.Pcap, s.cfg.Log)
+	if s.cfg.Handshake {
+		s.sendOffer()
+		go s.repeatOffer()
+	}
+	s.formatDue.Store(true)
+	return nil
+}
+
+// transport returns the connection packets are sent on.
+func (s *Stream) transport() Transport {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.conn
+}
+
+// applyGain multiplies the big-endian 16-bit samples of pcm by factor,
+// clipping them to the range of 16 bits.
+func applyGain(pcm []byte, factor float64) {
+	for i := 0; i+1 < len(pcm); i += 2 {
+		v := float64(int16(binary.BigEndian.Uint16(pcm[i:]))) * factor
+		v = math.Max(math.MinInt16, math.Min(math.MaxInt16, math.Round(v)))
+		binary.BigEndian.PutUint16(pcm[i:], uint16(int16(v)))
+	}
+}
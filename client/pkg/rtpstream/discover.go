@@ -0,0 +1,48 @@
+package rtpstream
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fcerini/audio-capture-shared/pkg/mdns"
+)
+
+const discoverTimeout = 3 * time.Second // For a server to answer over mDNS
+
+// isServiceName reports whether destination names a server advertised over
+// mDNS, or is auto for any: it has no port, and isn't a URL.
+func isServiceName(destination string) bool {
+	return destination != "" && !strings.Contains(destination, ":")
+}
+
+// discover finds the server named destination, or any for auto, on the
+// local network over mDNS and returns its host:port, warning when it
+// expects another format than cfg's.
+func discover(ctx context.Context, cfg Config, destination string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, discoverTimeout)
+	defer cancel()
+	svc, err := mdns.Lookup(ctx, destination)
+	if err != nil {
+		return "", err
+	}
+	addr, _ := svc.AddrPort() // Lookup only returns services with addresses
+	cfg.Log.Info("🔎 Found the recording server over mDNS", "name", svc.Instance, "host", svc.Host+".local", "addr", addr)
+	for _, kv := range svc.Text {
+		key, value, _ := strings.Cut(kv, "=")
+		var want int
+		switch key {
+		case "rate":
+			want = cfg.SampleRate
+		case "channels":
+			want = cfg.Channels
+		default:
+			continue
+		}
+		if n, err := strconv.Atoi(value); err == nil && n != want {
+			cfg.Log.Warn("The server expects another format", key, n, "stream", want)
+		}
+	}
+	return addr.String(), nil
+}
@@ -0,0 +1,142 @@
+package rtpstream
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// Encoder turns PCM into the payload of RTP packets.
+type Encoder interface {
+	// PayloadType is the RTP payload type of the encoding.
+	PayloadType() uint8
+	// Check returns an error when the encoding can't carry audio of this
+	// format.
+	Check(sampleRate, channels int) error
+	// Encode appends pcm, big-endian 16-bit, encoded to dst.
+	Encode(dst, pcm []byte) []byte
+}
+
+var (
+	encodersMu sync.Mutex
+	encoders   = map[string]func() Encoder{
+		"l16":  func() Encoder { return l16{} },
+		"pcmu": func() Encoder { return g711{payloadType: 0, encode: linearToULaw} },
+		"pcma": func() Encoder { return g711{payloadType: 8, encode: linearToALaw} },
+		"opus": func() Encoder { return &opusEncoder{} },
+	}
+)
+
+// RegisterEncoder makes an encoding available to NewEncoder under name,
+// replacing any encoding of that name.
+func RegisterEncoder(name string, newEncoder func() Encoder) {
+	encodersMu.Lock()
+	defer encodersMu.Unlock()
+	encoders[name] = newEncoder
+}
+
+// Encoders returns the names of the registered encodings, sorted.
+func Encoders() []string {
+	encodersMu.Lock()
+	defer encodersMu.Unlock()
+	names := make([]string, 0, len(encoders))
+	for name := range encoders {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// NewEncoder returns an encoder for the encoding registered under name:
+// l16, linear PCM as is, pcmu and pcma, G.711 µ-law and A-law for
+// narrowband receivers such as phones, or opus, 48 kHz audio encoded by
+// ffmpeg for receivers short of bandwidth or WHIP.
+//
+// An encoder may also have a PayloadSize(pcmSize int) int method, telling
+// the payload of a read without encoding one, a Close method, called when
+// the stream ends, and an Err method, ending the stream once it returns an
+// error.
+func NewEncoder(name string) (Encoder, error) {
+	encodersMu.Lock()
+	newEncoder, ok := encoders[name]
+	encodersMu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown encoding %q", name)
+	}
+	return newEncoder(), nil
+}
+
+// payloadSize returns the payload enc makes of a read of pcmSize bytes.
+func payloadSize(enc Encoder, pcmSize int) int {
+	if p, ok := enc.(interface{ PayloadSize(pcmSize int) int }); ok {
+		return p.PayloadSize(pcmSize)
+	}
+	return len(enc.Encode(nil, make([]byte, pcmSize)))
+}
+
+// l16 sends the PCM as it is: L16 is big-endian 16-bit PCM (RFC 3551), at
+// any rate, on the dynamic payload type 96.
+type l16 struct{}
+
+func (l16) PayloadType() uint8                   { return payloadTypeL16 }
+func (l16) Check(sampleRate, channels int) error { return nil }
+func (l16) Encode(dst, pcm []byte) []byte        { return append(dst, pcm...) }
+
+// g711 encodes each sample to a byte. G.711 is 8 kHz mono only.
+type g711 struct {
+	payloadType uint8
+	encode      func(int16) byte
+}
+
+func (g g711) PayloadType() uint8 { return g.payloadType }
+
+func (g g711) Check(sampleRate, channels int) error {
+	if sampleRate != 8000 || channels != 1 {
+		return fmt.Errorf("G.711 carries 8000 Hz mono audio, not %d Hz, %d channels", sampleRate, channels)
+	}
+	return nil
+}
+
+func (g g711) Encode(dst, pcm []byte) []byte {
+	for i := 0; i+1 < len(pcm); i += 2 {
+		dst = append(dst, g.encode(int16(uint16(pcm[i])<<8|uint16(pcm[i+1]))))
+	}
+	return dst
+}
+
+// linearToULaw encodes a sample with the µ-law of G.711.
+func linearToULaw(s int16) byte {
+	const bias, clip = 0x84, 32635
+	v := int(s)
+	sign := 0
+	if v < 0 {
+		v, sign = -v, 0x80
+	}
+	v = min(v, clip) + bias
+	exponent := 7
+	for mask := 0x4000; v&mask == 0 && exponent > 0; mask >>= 1 {
+		exponent--
+	}
+	mantissa := (v >> (exponent + 3)) & 0x0f
+	return ^byte(sign | exponent<<4 | mantissa)
+}
+
+// linearToALaw encodes a sample with the A-law of G.711.
+func linearToALaw(s int16) byte {
+	v := int(s) >> 3 // A-law encodes 13 bits
+	sign := 0x80
+	if v < 0 {
+		v, sign = -v-1, 0
+	}
+	var b int
+	if v < 32 {
+		b = v >> 1
+	} else {
+		exponent := 1
+		for v>>(exponent+4) > 1 {
+			exponent++
+		}
+		b = exponent<<4 | (v>>exponent)&0x0f
+	}
+	return byte(sign|b) ^ 0x55
+}
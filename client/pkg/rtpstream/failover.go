@@ -0,0 +1,166 @@
+package rtpstream
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/fcerini/audio-capture-shared/pkg/stun"
+)
+
+const (
+	failoverCheck = time.Second // How often the destination's health is checked
+	recoverProbes = 3           // Answers in a row from the primary before switching back to it
+)
+
+// failover holds the destinations a stream with Backups moves between: the
+// primary, then the backups, in order.
+type failover struct {
+	mu           sync.Mutex
+	destinations []string // guarded by mu
+	active       int      // Of destinations, the one sent to; guarded by mu
+}
+
+// current returns the destination sent to and whether it is the primary.
+func (f *failover) current() (string, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.destinations[f.active], f.active == 0
+}
+
+// setPrimary makes destination, redirected to from outside, the primary.
+func (f *failover) setPrimary(destination string) {
+	if f == nil {
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.destinations[0], f.active = destination, 0
+}
+
+// watchFailover checks the destination every failoverCheck until the stream
+// ends. It fails over to the next destination of the list, wrapping around
+// to the primary, once for FailoverAfter every send failed, or the receiver
+// stopped sending RTCP reports after it did, or it is down as told by the
+// keepalives. While on a backup, it sends a STUN Binding request to the
+// primary every check, and switches back once recoverProbes in a row are
+// answered: that needs a primary that answers STUN, like the server.
+func (s *Stream) watchFailover() {
+	f, rs, stats, log := s.failover, &s.cfg.Stats.receivers, &s.cfg.Stats.send, s.cfg.Log
+	after := s.cfg.FailoverAfter
+	ticker := time.NewTicker(failoverCheck)
+	defer ticker.Stop()
+	var (
+		since    = time.Now() // Of the watch of the destination, or of the latest check paused
+		failing  time.Time    // Since when every check saw sends fail; zero while they work
+		failures = stats.failures.Load()
+		probe    Transport // To the primary, while on a backup
+		answers  int       // In a row
+	)
+	closeProbe := func() {
+		if probe != nil {
+			probe.Close()
+			probe, answers = nil, 0
+		}
+	}
+	defer closeProbe()
+	switched := func() {
+		since, failing, failures = time.Now(), time.Time{}, stats.failures.Load()
+		closeProbe()
+	}
+
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+		}
+		now := time.Now()
+		n := stats.failures.Load()
+		switch {
+		case n == failures:
+			failing = time.Time{}
+		case failing.IsZero():
+			failing = now
+		}
+		failures = n
+		if s.Paused() {
+			// Nothing is sent, so nothing fails and nothing is reported
+			since, failing = now, time.Time{}
+		}
+
+		reason := ""
+		if last := rs.lastReport(); !last.IsZero() && now.Sub(last) >= after && now.Sub(since) >= after {
+			reason = "the receiver stopped sending RTCP reports"
+		}
+		switch {
+		case !failing.IsZero() && now.Sub(failing) >= after:
+			reason = "sending keeps failing"
+		case s.ReceiverState() == ReceiverDown:
+			reason = "the receiver stopped answering keepalives"
+		}
+		if reason != "" {
+			if s.failOver(reason) {
+				switched()
+			}
+			continue
+		}
+
+		from, onPrimary := f.current()
+		if onPrimary {
+			continue
+		}
+		f.mu.Lock()
+		primary := f.destinations[0]
+		f.mu.Unlock()
+		if probe == nil {
+			var err error
+			if probe, err = dial(s.ctx, s.cfg, primary); err != nil {
+				probe = nil
+				continue
+			}
+		}
+		ctx, cancel := context.WithTimeout(s.ctx, failoverCheck/2)
+		req, id := stun.Request()
+		_, err := stun.Transact(ctx, probe, req, id)
+		cancel()
+		if err != nil {
+			answers = 0
+			continue
+		}
+		if answers++; answers < recoverProbes {
+			continue
+		}
+		if err := s.redirect(primary); err != nil {
+			log.Warn("Switching back to the primary destination failed", "destination", primary, "err", err)
+			continue
+		}
+		f.mu.Lock()
+		f.active = 0
+		f.mu.Unlock()
+		log.Info("🔙 The primary destination is back, switched back to it", "from", from, "to", primary)
+		switched()
+	}
+}
+
+// failOver redirects the stream to the first destination after the current
+// one that can be dialed, reporting whether it could.
+func (s *Stream) failOver(reason string) bool {
+	f := s.failover
+	f.mu.Lock()
+	destinations, active := append([]string(nil), f.destinations...), f.active
+	f.mu.Unlock()
+	for i := 1; i < len(destinations); i++ {
+		next := (active + i) % len(destinations)
+		if err := s.redirect(destinations[next]); err != nil {
+			s.cfg.Log.Warn("Failing over to a destination failed", "destination", destinations[next], "err", err)
+			continue
+		}
+		f.mu.Lock()
+		f.active = next
+		f.mu.Unlock()
+		s.cfg.Log.Warn("🔀 Failed over to the next destination", "from", destinations[active], "to", destinations[next], "reason", reason)
+		return true
+	}
+	return false
+}
@@ -0,0 +1,15 @@
+package rtpstream
+
+import "github.com/fcerini/audio-capture-shared/pkg/formatext"
+
+// formatExtension returns the data of the stream's format extension, see
+// package formatext, attached with FormatInterval.
+func (s *Stream) formatExtension() []byte {
+	return formatext.Format{Encoding: s.cfg.Encoding, SampleRate: s.cfg.SampleRate, Channels: s.cfg.Channels}.Marshal()
+}
+
+// attach adds ext, as the format extension, to the first packet of the next
+// read.
+func (p *packetizer) attach(ext []byte) {
+	p.ext = ext
+}
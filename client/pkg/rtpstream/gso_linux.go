@@ -0,0 +1,62 @@
+package rtpstream
+
+import (
+	"errors"
+	"net"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// gsoSender sends the packets of a read as a single buffer that the kernel,
+// or the network card, splits into the packets again (UDP generic
+// segmentation offload, Linux 4.18 and later). The whole read then takes one
+// trip through the network stack instead of one per packet.
+type gsoSender struct {
+	conn *net.UDPConn
+	buf  []byte
+	oob  []byte // A UDP_SEGMENT control message with the packet size
+}
+
+// newGSOSender returns nil when the kernel doesn't support UDP_SEGMENT.
+func newGSOSender(conn *net.UDPConn) *gsoSender {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return nil
+	}
+	var serr error
+	if err := raw.Control(func(fd uintptr) {
+		_, serr = unix.GetsockoptInt(int(fd), unix.IPPROTO_UDP, unix.UDP_SEGMENT)
+	}); err != nil || serr != nil {
+		return nil
+	}
+	g := &gsoSender{conn: conn, oob: make([]byte, unix.CmsgSpace(2))}
+	h := (*unix.Cmsghdr)(unsafe.Pointer(&g.oob[0]))
+	h.Level = unix.SOL_UDP
+	h.Type = unix.UDP_SEGMENT
+	h.SetLen(unix.CmsgLen(2))
+	return g
+}
+
+// send sends packets as one buffer. Every packet but the last must have the
+// size of the first, and the last may be shorter; ok is false, and nothing is
+// sent, when they don't.
+func (g *gsoSender) send(packets [][]byte) (ok bool, err error) {
+	size := len(packets[0])
+	g.buf = g.buf[:0]
+	for i, p := range packets {
+		if len(p) > size || (len(p) < size && i < len(packets)-1) {
+			return false, nil
+		}
+		g.buf = append(g.buf, p...)
+	}
+	*(*uint16)(unsafe.Pointer(&g.oob[unix.CmsgLen(0)])) = uint16(size)
+	_, _, err = g.conn.WriteMsgUDP(g.buf, g.oob, nil)
+	return true, err
+}
+
+// gsoUnsupported reports whether err means the route can't segment, e.g.
+// because the network card doesn't offload checksums.
+func gsoUnsupported(err error) bool {
+	return errors.Is(err, unix.EIO) || errors.Is(err, unix.EINVAL) || errors.Is(err, unix.EOPNOTSUPP)
+}
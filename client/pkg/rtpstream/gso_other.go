@@ -0,0 +1,15 @@
+//go:build !linux
+
+package rtpstream
+
+import "net"
+
+// gsoSender is only supported on Linux; elsewhere batches are sent packet by
+// packet.
+type gsoSender struct{}
+
+func newGSOSender(conn *net.UDPConn) *gsoSender { return nil }
+
+func (g *gsoSender) send(packets [][]byte) (ok bool, err error) { return false, nil }
+
+func gsoUnsupported(err error) bool { return false }
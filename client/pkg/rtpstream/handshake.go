@@ -0,0 +1,91 @@
+package rtpstream
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/fcerini/audio-capture-shared/pkg/handshake"
+)
+
+// handshakeAttempts is how many offers of the stream's format (see package
+// handshake) are sent, a second apart, before giving up on an answer.
+const handshakeAttempts = 5
+
+// offer returns the data of the stream's handshake.
+func (s *Stream) offer() string {
+	enc := strings.ToUpper(s.cfg.Encoding)
+	data := fmt.Sprintf("v=%d;sw=audio-capture-client;enc=%s;rate=%d;ch=%d", handshake.Version, enc, s.cfg.SampleRate, s.cfg.Channels)
+	if enc == "L16" {
+		data += fmt.Sprintf(";bits=%d", bitDepth)
+	}
+	return data
+}
+
+// sendOffer sends the handshake on the stream's transport.
+func (s *Stream) sendOffer() {
+	pkt := handshake.Marshal(s.ssrc, handshake.Offer, s.offer())
+	conn := s.transport()
+	if _, err := conn.Write(pkt); err != nil {
+		s.cfg.Log.Debug("Sending the handshake failed", "err", err)
+		return
+	}
+	s.cfg.Pcap.WriteUDP(addrPort(conn.LocalAddr()), addrPort(conn.RemoteAddr()), pkt, time.Now())
+}
+
+// repeatOffer sends the handshake again every second until it is answered,
+// the stream ends or it was sent handshakeAttempts times, as the first may
+// be lost. Receivers that don't know the handshake never answer.
+func (s *Stream) repeatOffer() {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for i := 1; i < handshakeAttempts; i++ {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+		}
+		if s.cfg.Stats.receivers.answered() {
+			return
+		}
+		s.sendOffer()
+	}
+	select {
+	case <-s.done:
+	case <-time.After(time.Second):
+		if !s.cfg.Stats.receivers.answered() {
+			s.cfg.Log.Warn("🤝 The receiver didn't answer the handshake, it may not support it", "attempts", handshakeAttempts)
+		}
+	}
+}
+
+// handshakeAnswered logs the receiver's answer to the handshake, the pairs
+// of the offer with its result and, unless accepted, the reason.
+func (rs *receiverStats) handshakeAnswered(answer map[string]string, log *slog.Logger) {
+	rs.mu.Lock()
+	prev := rs.answer
+	rs.answer = answer["result"]
+	rs.mu.Unlock()
+	if prev == answer["result"] {
+		return // An answer to an offer sent again
+	}
+	log = log.With("receiver_software", answer["sw"], "receiver_encoding", answer["enc"], "receiver_rate", answer["rate"], "receiver_channels", answer["ch"])
+	switch answer["result"] {
+	case "accepted":
+		log.Info("🤝 The receiver accepted the stream's format")
+	case "adapted":
+		log.Info("🤝 The receiver records the stream in its format", "reason", answer["reason"])
+	case "rejected":
+		log.Error("❌ The receiver rejected the stream's format and drops it", "reason", answer["reason"])
+	default:
+		log.Warn("🤝 The receiver answered the handshake with an unknown result", "result", answer["result"])
+	}
+}
+
+// answered reports whether the receiver answered the handshake.
+func (rs *receiverStats) answered() bool {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	return rs.answer != ""
+}
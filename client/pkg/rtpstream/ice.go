@@ -0,0 +1,255 @@
+package rtpstream
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+	"time"
+
+	"github.com/fcerini/audio-capture-shared/pkg/ice"
+	"github.com/fcerini/audio-capture-shared/pkg/stun"
+)
+
+const (
+	iceTimeout = 3 * time.Second        // For the checks to be answered
+	iceSettle  = 200 * time.Millisecond // Waited after the first answer, for one from a better candidate
+)
+
+// iceRetransmits are when the unanswered checks are sent again, after the
+// first ones.
+var iceRetransmits = []time.Duration{500 * time.Millisecond, 1500 * time.Millisecond}
+
+// isICEURL reports whether destination is the URL of a server to negotiate
+// the path with rather than a host:port.
+func isICEURL(destination string) bool {
+	return strings.HasPrefix(destination, "http://") || strings.HasPrefix(destination, "https://")
+}
+
+// dialICE negotiates the path to the server at url with ICE-lite (see
+// package ice): it posts the candidates of a port to the server's /ice,
+// sends a check from that port to each of the server's candidates and
+// streams from it to the best one answering. With cfg.STUN the port's
+// reflexive address is a candidate too; with cfg.TURN the stream is relayed
+// to the best candidate when none answers, and with cfg.TURNAlways without
+// checking.
+func dialICE(ctx context.Context, cfg Config, url string) (Transport, error) {
+	pc, err := listenPacket(ctx, cfg.Transport)
+	if err != nil {
+		return nil, err
+	}
+	defer pc.Close()
+	port := pc.LocalAddr().(*net.UDPAddr).Port
+
+	local := ice.NewDescription()
+	for i, addr := range hostCandidates(cfg, port) {
+		local.Add(ice.Host, addr, i+1)
+	}
+	if cfg.STUN != "" {
+		if reflexive, err := stun.Discover(ctx, pc, cfg.Transport, cfg.STUN); err != nil {
+			cfg.Log.Warn("Learning the reflexive address failed, offering the host candidates alone", "stun", cfg.STUN, "err", err)
+		} else {
+			local.Add(ice.Srflx, reflexive, 1)
+		}
+	}
+	remote, err := offerICE(ctx, cfg, url, local)
+	if err != nil {
+		return nil, err
+	}
+	var candidates []ice.Candidate
+	for _, c := range remote.Candidates {
+		if c.Addr.IsValid() && (cfg.Transport != "udp4" || c.Addr.Addr().Is4()) && (cfg.Transport != "udp6" || c.Addr.Addr().Is6()) {
+			candidates = append(candidates, c)
+		}
+	}
+	if len(candidates) == 0 {
+		return nil, fmt.Errorf("the server offered no %s candidates", cfg.Transport)
+	}
+
+	if cfg.TURN != "" && cfg.TURNAlways {
+		pc.Close()
+		return dialTURN(ctx, cfg, relayCandidate(cfg, candidates), cfg.Log)
+	}
+	picked, err := checkCandidates(ctx, pc, local, remote, candidates)
+	pc.Close()
+	if err != nil {
+		if cfg.TURN == "" {
+			return nil, fmt.Errorf("no ICE candidate of the server answered: %w", err)
+		}
+		cfg.Log.Warn("🔁 No ICE candidate of the server answered, relaying through TURN", "err", err)
+		return dialTURN(ctx, cfg, relayCandidate(cfg, candidates), cfg.Log)
+	}
+	cfg.Log.Info("🧊 ICE picked a path", "addr", picked.Addr, "type", picked.Type, "local_port", port, "candidates", len(candidates))
+	return DialTransport(context.WithValue(ctx, localAddrKey{}, &net.UDPAddr{Port: port}), cfg.Transport, picked.Addr.String(), cfg.Log)
+}
+
+// hostCandidates returns the addresses of the interfaces at port, as
+// ice.HostAddrs does, but only cfg.Bind or those of cfg.Interface when set.
+func hostCandidates(cfg Config, port int) []netip.AddrPort {
+	if cfg.Bind.IsValid() {
+		return []netip.AddrPort{netip.AddrPortFrom(cfg.Bind, uint16(port))}
+	}
+	all := ice.HostAddrs(cfg.Transport, port)
+	if cfg.Interface == "" {
+		return all
+	}
+	ifi, err := net.InterfaceByName(cfg.Interface)
+	if err != nil {
+		return nil
+	}
+	addrs, err := ifi.Addrs()
+	if err != nil {
+		return nil
+	}
+	var out []netip.AddrPort
+	for _, c := range all {
+		for _, a := range addrs {
+			if prefix, err := netip.ParsePrefix(a.String()); err == nil && prefix.Addr() == c.Addr() {
+				out = append(out, c)
+			}
+		}
+	}
+	return out
+}
+
+// offerICE posts the client's description to the server's /ice and returns
+// the server's, authenticating with cfg.ICEToken if set.
+func offerICE(ctx context.Context, cfg Config, url string, local ice.Description) (ice.Description, error) {
+	body, err := json.Marshal(local)
+	if err != nil {
+		return ice.Description{}, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(url, "/")+"/ice", bytes.NewReader(body))
+	if err != nil {
+		return ice.Description{}, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if cfg.ICEToken != "" {
+		req.Header.Set("Authorization", "Bearer "+cfg.ICEToken)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return ice.Description{}, fmt.Errorf("offering ICE candidates failed: %w", err)
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return ice.Description{}, errors.New("offering ICE candidates failed: the server doesn't run with -ice")
+	case http.StatusUnauthorized:
+		return ice.Description{}, errors.New("offering ICE candidates failed: set ICE_TOKEN to the server's API_TOKEN")
+	default:
+		return ice.Description{}, fmt.Errorf("offering ICE candidates failed: %s", resp.Status)
+	}
+	var remote ice.Description
+	if err := json.NewDecoder(resp.Body).Decode(&remote); err != nil {
+		return ice.Description{}, fmt.Errorf("invalid ICE answer: %w", err)
+	}
+	return remote, nil
+}
+
+// checkCandidates sends a check from pc to each candidate, again while
+// unanswered, and returns the one of the highest priority among those that
+// answered by iceSettle after the first.
+func checkCandidates(ctx context.Context, pc net.PacketConn, local, remote ice.Description, candidates []ice.Candidate) (ice.Candidate, error) {
+	var tiebreaker [8]byte
+	rand.Read(tiebreaker[:])
+	checks := make(map[stun.TxID]ice.Candidate, len(candidates))
+	requests := make(map[stun.TxID][]byte, len(candidates))
+	for _, c := range candidates {
+		id := stun.NewID()
+		checks[id] = c
+		requests[id] = ice.Check(id, local, remote, binary.BigEndian.Uint64(tiebreaker[:]))
+	}
+	send := func() {
+		for id, req := range requests {
+			pc.WriteTo(req, net.UDPAddrFromAddrPort(checks[id].Addr)) // A candidate of an unreachable family fails, others may not
+		}
+	}
+
+	start := time.Now()
+	deadline := start.Add(iceTimeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	send()
+	retransmits := iceRetransmits
+	var best *ice.Candidate
+	buf := make([]byte, 1500)
+	for {
+		wake := deadline
+		if len(retransmits) > 0 && start.Add(retransmits[0]).Before(wake) {
+			wake = start.Add(retransmits[0])
+		}
+		pc.SetReadDeadline(wake)
+		n, _, err := pc.ReadFrom(buf)
+		if err != nil {
+			var ne net.Error
+			if !errors.As(err, &ne) || !ne.Timeout() {
+				return ice.Candidate{}, err
+			}
+			if !time.Now().Before(deadline) {
+				if best != nil {
+					return *best, nil
+				}
+				if ctx.Err() != nil {
+					return ice.Candidate{}, ctx.Err()
+				}
+				return ice.Candidate{}, errors.New("timed out")
+			}
+			if len(retransmits) > 0 && !time.Now().Before(start.Add(retransmits[0])) {
+				retransmits = retransmits[1:]
+				send()
+			}
+			continue
+		}
+		b := buf[:n]
+		if !stun.IsMessage(b) || !stun.IsSuccess(b) || !stun.Verify(b, []byte(remote.Pwd)) {
+			continue
+		}
+		c, ok := checks[stun.ID(b)]
+		if !ok {
+			continue
+		}
+		delete(requests, stun.ID(b))
+		if best == nil {
+			deadline = minTime(deadline, time.Now().Add(iceSettle))
+		}
+		if best == nil || c.Priority > best.Priority {
+			best = &c
+		}
+		if len(requests) == 0 {
+			return *best, nil
+		}
+	}
+}
+
+func minTime(a, b time.Time) time.Time {
+	if b.Before(a) {
+		return b
+	}
+	return a
+}
+
+// relayCandidate returns the host:port of the candidate of the highest
+// priority the TURN server can relay to, IPv4 unless cfg.Transport is udp6.
+func relayCandidate(cfg Config, candidates []ice.Candidate) string {
+	var best netip.AddrPort
+	var priority uint32
+	for _, c := range candidates {
+		if c.Addr.Addr().Is6() == (cfg.Transport == "udp6") && (!best.IsValid() || c.Priority > priority) {
+			best, priority = c.Addr, c.Priority
+		}
+	}
+	if !best.IsValid() {
+		best = candidates[0].Addr
+	}
+	return best.String()
+}
@@ -0,0 +1,245 @@
+package rtpstream
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/fcerini/audio-capture-shared/pkg/stun"
+)
+
+const keepaliveMisses = 3 // Keepalive intervals without a word from the receiver before it is down
+
+// Receiver states, as ReceiverState returns them
+const (
+	ReceiverUnknown = "unknown" // Not heard from yet
+	ReceiverUp      = "up"
+	ReceiverDown    = "down"
+)
+
+// liveness tells whether the receiver is there from its answers to the
+// keepalives, STUN Binding requests sent every Keepalive whether audio is
+// sent or not, and from its RTCP reports. A receiver that was heard from
+// and then isn't for keepaliveMisses intervals is down; one never heard from
+// can't be told apart from one that doesn't answer STUN, and stays unknown.
+type liveness struct {
+	interval time.Duration
+	log      *slog.Logger
+	spool    *spool // nil without Spool
+
+	mu      sync.Mutex
+	state   string
+	since   time.Time    // Of the state, or of the receiver for unknown
+	heardAt time.Time    // The latest answer or report
+	ids     [4]stun.TxID // Of the latest keepalives, whose answers count
+	next    int
+	warned  bool // That the receiver doesn't answer
+}
+
+func newLiveness(cfg Config) *liveness {
+	l := &liveness{interval: cfg.Keepalive, log: cfg.Log, state: ReceiverUnknown, since: time.Now()}
+	if cfg.Spool != "" {
+		l.spool = &spool{dir: cfg.Spool, rate: cfg.SampleRate, channels: cfg.Channels, log: cfg.Log}
+	}
+	return l
+}
+
+// keepalive sends a keepalive on the stream's transport every interval,
+// and checks whether the receiver went down, until the stream ends.
+func (s *Stream) keepalive() {
+	l := s.live
+	ticker := time.NewTicker(l.interval)
+	defer ticker.Stop()
+	defer l.spool.stop()
+	for {
+		req, id := stun.Request()
+		l.mu.Lock()
+		l.ids[l.next] = id
+		l.next = (l.next + 1) % len(l.ids)
+		l.mu.Unlock()
+		s.transport().Write(req) // A failure shows as a missing answer
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+		}
+		l.check()
+	}
+}
+
+// heard takes a packet from the receiver: an answer to a keepalive or an
+// RTCP packet.
+func (l *liveness) heard(b []byte) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if stun.IsMessage(b) {
+		id := stun.ID(b)
+		if !stun.IsSuccess(b) || id != l.ids[0] && id != l.ids[1] && id != l.ids[2] && id != l.ids[3] {
+			return
+		}
+	}
+	now := time.Now()
+	l.heardAt = now
+	switch l.state {
+	case ReceiverUnknown:
+		l.log.Info("💓 Receiver is alive")
+	case ReceiverDown:
+		l.log.Info("💓 Receiver is back", "down_for", now.Sub(l.since).Round(time.Second).String())
+		l.spool.stop()
+	default:
+		return
+	}
+	l.state, l.since = ReceiverUp, now
+}
+
+// check marks the receiver down when it stopped answering.
+func (l *liveness) check() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	silent := keepaliveMisses * l.interval
+	switch {
+	case l.state == ReceiverUp && time.Since(l.heardAt) > silent:
+		l.state, l.since = ReceiverDown, time.Now()
+		l.log.Warn("💔 Receiver stopped answering, it may be down", "last_heard", l.heardAt.Format(time.RFC3339))
+		l.spool.start()
+	case l.state == ReceiverUnknown && !l.warned && time.Since(l.since) > silent:
+		l.warned = true
+		l.log.Info("The receiver doesn't answer keepalives or send reports, so whether it is down can't be told")
+	}
+}
+
+// reset forgets the receiver, for a new one.
+func (l *liveness) reset() {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.state == ReceiverDown {
+		l.spool.stop()
+	}
+	l.state, l.since, l.warned = ReceiverUnknown, time.Now(), false
+}
+
+// keep spools pcm while the receiver is down, with Spool.
+func (l *liveness) keep(pcm []byte) {
+	if l != nil {
+		l.spool.write(pcm)
+	}
+}
+
+// ReceiverState returns whether the receiver is up or down, or unknown, as
+// the keepalives tell; "" without Keepalive.
+func (s *Stream) ReceiverState() string {
+	if s.live == nil {
+		return ""
+	}
+	s.live.mu.Lock()
+	defer s.live.mu.Unlock()
+	return s.live.state
+}
+
+// spool keeps the audio captured while the receiver is down in a WAV file
+// of Spool, one per outage, so none of it is lost.
+type spool struct {
+	dir            string
+	rate, channels int
+	log            *slog.Logger
+
+	mu      sync.Mutex
+	f       *os.File // nil while the receiver is up
+	w       *bufio.Writer
+	written int64 // Bytes of samples
+	buf     []byte
+}
+
+const wavHeaderSize = 44
+
+// start opens a new file.
+func (sp *spool) start() {
+	if sp == nil {
+		return
+	}
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	name := filepath.Join(sp.dir, "down-"+time.Now().Format("20060102-150405")+".wav")
+	f, err := os.Create(name)
+	if err != nil {
+		sp.log.Warn("Spooling the audio failed", "err", err)
+		return
+	}
+	sp.f, sp.w, sp.written = f, bufio.NewWriter(f), 0
+	sp.w.Write(make([]byte, wavHeaderSize)) // Written at the end, with the sizes
+	sp.log.Info("💾 Keeping the audio while the receiver is down", "file", name)
+}
+
+// write adds big-endian 16-bit samples to the file, if one is open.
+func (sp *spool) write(pcm []byte) {
+	if sp == nil {
+		return
+	}
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	if sp.f == nil {
+		return
+	}
+	// WAV is little-endian
+	sp.buf = append(sp.buf[:0], pcm...)
+	for i := 0; i+1 < len(sp.buf); i += 2 {
+		sp.buf[i], sp.buf[i+1] = sp.buf[i+1], sp.buf[i]
+	}
+	n, _ := sp.w.Write(sp.buf)
+	sp.written += int64(n)
+}
+
+// stop completes and closes the file, if one is open.
+func (sp *spool) stop() {
+	if sp == nil {
+		return
+	}
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	if sp.f == nil {
+		return
+	}
+	err := sp.w.Flush()
+	if err == nil {
+		_, err = sp.f.WriteAt(wavHeader(sp.rate, sp.channels, sp.written), 0)
+	}
+	if cerr := sp.f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		sp.log.Warn("Writing the spooled audio failed", "file", sp.f.Name(), "err", err)
+	} else {
+		seconds := float64(sp.written) / float64(sp.rate*sp.channels*bitDepth/8)
+		sp.log.Info("💾 Kept the audio of the outage", "file", sp.f.Name(), "seconds", fmt.Sprintf("%.1f", seconds))
+	}
+	sp.f, sp.w = nil, nil
+}
+
+// wavHeader returns the header of a 16-bit PCM WAV file with size bytes of
+// samples.
+func wavHeader(rate, channels int, size int64) []byte {
+	h := make([]byte, 0, wavHeaderSize)
+	h = append(h, "RIFF"...)
+	h = binary.LittleEndian.AppendUint32(h, uint32(36+size))
+	h = append(h, "WAVEfmt "...)
+	h = binary.LittleEndian.AppendUint32(h, 16)
+	h = binary.LittleEndian.AppendUint16(h, 1) // PCM
+	h = binary.LittleEndian.AppendUint16(h, uint16(channels))
+	h = binary.LittleEndian.AppendUint32(h, uint32(rate))
+	h = binary.LittleEndian.AppendUint32(h, uint32(rate*channels*bitDepth/8))
+	h = binary.LittleEndian.AppendUint16(h, uint16(channels*bitDepth/8))
+	h = binary.LittleEndian.AppendUint16(h, bitDepth)
+	h = append(h, "data"...)
+	return binary.LittleEndian.AppendUint32(h, uint32(size))
+}
@@ -0,0 +1,119 @@
+package rtpstream
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	rtpHeaderSize = 12
+	udpOverhead   = 28                     // IPv4 and UDP headers, counted against the limit for every packet
+	limitBurst    = 100 * time.Millisecond // Of sending at the limit the bucket holds
+)
+
+// Bandwidth is a rate in bits per second, such as the -max-bandwidth flag.
+// It reads a number and an optional unit, k, m or g for 10^3, 10^6 or 10^9,
+// followed by bps, bit/s or nothing: 2mbps, 500kbit/s, 64k or 128000.
+type Bandwidth int64
+
+var bandwidthUnits = []struct {
+	suffix string
+	factor float64
+}{{"g", 1e9}, {"m", 1e6}, {"k", 1e3}}
+
+func (b *Bandwidth) String() string {
+	if b == nil || *b == 0 {
+		return "0"
+	}
+	v := int64(*b)
+	for _, u := range bandwidthUnits {
+		if f := int64(u.factor); v%f == 0 {
+			return strconv.FormatInt(v/f, 10) + u.suffix + "bps"
+		}
+	}
+	return strconv.FormatInt(v, 10) + "bps"
+}
+
+func (b *Bandwidth) Set(s string) error {
+	v := strings.ToLower(strings.TrimSpace(s))
+	for _, unit := range []string{"bps", "bit/s", "bit"} {
+		if n, ok := strings.CutSuffix(v, unit); ok {
+			v = n
+			break
+		}
+	}
+	factor := 1.0
+	for _, u := range bandwidthUnits {
+		if n, ok := strings.CutSuffix(v, u.suffix); ok {
+			v, factor = n, u.factor
+			break
+		}
+	}
+	n, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+	if err != nil || n < 0 {
+		return fmt.Errorf("invalid bandwidth %q", s)
+	}
+	*b = Bandwidth(n * factor)
+	return nil
+}
+
+// limiter is a token bucket holding the stream's sends to a bandwidth, with
+// bursts of up to limitBurst of it. What it can't send at once is held back,
+// and the send queue drops the oldest reads when it can't keep up.
+type limiter struct {
+	rate  float64 // Bytes per second
+	burst float64
+
+	mu     sync.Mutex
+	tokens float64 // Bytes that may be sent now; negative when in debt
+	last   time.Time
+}
+
+// newLimiter returns nil for an unlimited bandwidth.
+func newLimiter(bw Bandwidth) *limiter {
+	if bw <= 0 {
+		return nil
+	}
+	rate := float64(bw) / 8
+	burst := max(rate*limitBurst.Seconds(), mtu+udpOverhead)
+	return &limiter{rate: rate, burst: burst, tokens: burst, last: time.Now()}
+}
+
+// wait takes the bytes of packets from the bucket, blocking until they may
+// be sent. It returns false if ctx is done first.
+func (l *limiter) wait(ctx context.Context, packets [][]byte) bool {
+	if l == nil {
+		return true
+	}
+	l.mu.Lock()
+	now := time.Now()
+	l.tokens = min(l.burst, l.tokens+now.Sub(l.last).Seconds()*l.rate)
+	l.last = now
+	for _, p := range packets {
+		l.tokens -= float64(len(p) + udpOverhead)
+	}
+	debt := l.tokens
+	l.mu.Unlock()
+	if debt >= 0 {
+		return true
+	}
+	timer := time.NewTimer(time.Duration(-debt / l.rate * float64(time.Second)))
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// streamBandwidth is roughly what a stream sends, in bits per second, with
+// payload bytes of every 20 ms read split into packets of at most mtu.
+func streamBandwidth(payload int) Bandwidth {
+	packets := (payload + mtu - rtpHeaderSize - 1) / (mtu - rtpHeaderSize)
+	return Bandwidth((payload + packets*(rtpHeaderSize+udpOverhead)) * 50 * 8)
+}
@@ -0,0 +1,108 @@
+package rtpstream
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"syscall"
+
+	"github.com/fcerini/audio-capture-shared/pkg/dscp"
+	"github.com/fcerini/audio-capture-shared/pkg/stun"
+)
+
+// localAddrKey is the key of the context value whose port the UDP transports
+// bind to, when dial has them send from the port whose reflexive address it
+// learned.
+type localAddrKey struct{}
+
+// dial connects the stream's transport to destination, see dialPath, after
+// finding it over mDNS if it names a server (see discover), from cfg.Bind
+// and cfg.Interface, and marks its packets with cfg.DSCP. A transport that
+// doesn't implement syscall.Conn isn't marked.
+func dial(ctx context.Context, cfg Config, destination string) (Transport, error) {
+	ctx = withBinding(ctx, cfg)
+	if cfg.WHIPToken != "" {
+		ctx = context.WithValue(ctx, whipTokenKey{}, cfg.WHIPToken)
+	}
+	if isServiceName(destination) {
+		addr, err := discover(ctx, cfg, destination)
+		if err != nil {
+			return nil, err
+		}
+		destination = addr
+	}
+	conn, err := dialPath(ctx, cfg, destination)
+	if err != nil || cfg.DSCP == 0 {
+		return conn, err
+	}
+	sc, ok := conn.(syscall.Conn)
+	if !ok {
+		cfg.Log.Warn("The transport can't mark its packets with -dscp", "transport", cfg.Transport)
+		return conn, nil
+	}
+	if err := dscp.Mark(sc, cfg.DSCP); err != nil {
+		cfg.Log.Warn("Marking the stream with -dscp failed", "dscp", cfg.DSCP.String(), "err", err)
+	}
+	return conn, nil
+}
+
+// dialPath connects the stream's transport to destination. With cfg.TURN, the
+// stream is relayed through the TURN server unless the receiver answers a
+// STUN request sent to it directly within directProbe, as the server does;
+// with cfg.TURNAlways it always is. With cfg.ICE, a destination that is a
+// server's URL is negotiated with it, see dialICE.
+func dialPath(ctx context.Context, cfg Config, destination string) (Transport, error) {
+	negotiate := cfg.ICE && isICEURL(destination)
+	if cfg.STUN != "" || cfg.TURN != "" || negotiate {
+		switch cfg.Transport {
+		case "udp", "udp4", "udp6":
+		default:
+			return nil, fmt.Errorf("STUN, TURN and ICE need a UDP transport, not %s", cfg.Transport)
+		}
+	}
+	if negotiate {
+		return dialICE(ctx, cfg, destination)
+	}
+	if cfg.TURN != "" && cfg.TURNAlways {
+		return dialTURN(ctx, cfg, destination, cfg.Log)
+	}
+	conn, err := dialDirect(ctx, cfg, destination)
+	if err != nil || cfg.TURN == "" {
+		return conn, err
+	}
+	probe, cancel := context.WithTimeout(ctx, directProbe)
+	req, id := stun.Request()
+	_, err = stun.Transact(probe, conn, req, id)
+	cancel()
+	if err == nil {
+		return conn, nil
+	}
+	conn.Close()
+	cfg.Log.Warn("🔁 The receiver didn't answer directly, relaying through TURN", "err", err)
+	return dialTURN(ctx, cfg, destination, cfg.Log)
+}
+
+// dialDirect connects the stream's transport to destination. With cfg.STUN
+// it first asks the STUN server for the reflexive address of a port, the
+// one the NAT in front of the client gives it, logs it and sends from that
+// port, so a receiver behind a NAT of its own can punch a hole towards it.
+// That takes a NAT which maps a port to the same address whatever it sends
+// to, as most home routers do.
+func dialDirect(ctx context.Context, cfg Config, destination string) (Transport, error) {
+	if cfg.STUN == "" {
+		return DialTransport(ctx, cfg.Transport, destination, cfg.Log)
+	}
+	pc, err := listenPacket(ctx, cfg.Transport)
+	if err != nil {
+		return nil, err
+	}
+	reflexive, err := stun.Discover(ctx, pc, cfg.Transport, cfg.STUN)
+	port := pc.LocalAddr().(*net.UDPAddr).Port
+	pc.Close()
+	if err != nil {
+		return nil, fmt.Errorf("learning the reflexive address failed: %w", err)
+	}
+	cfg.Log.Info("🌐 Reachable through the NAT", "reflexive_addr", reflexive, "local_port", port, "stun", cfg.STUN)
+	// Bound to the port alone, the socket takes the destination's family
+	return DialTransport(context.WithValue(ctx, localAddrKey{}, &net.UDPAddr{Port: port}), cfg.Transport, destination, cfg.Log)
+}
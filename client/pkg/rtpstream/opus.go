@@ -0,0 +1,187 @@
+package rtpstream
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os/exec"
+	"strconv"
+	"sync"
+
+	"github.com/fcerini/audio-capture-shared/pkg/ogg"
+)
+
+const (
+	payloadTypeOpus = 111 // Dynamic payload type for Opus, as browsers offer it
+	opusRate        = 48000
+	opusBitrate     = "96k"          // As the server encodes its live audio
+	opusPayload     = 96000 / 8 / 50 // Bytes of a 20 ms packet at opusBitrate
+	opusQueue       = 5              // Reads queued for ffmpeg, and packets queued from it
+)
+
+// opusEncoder encodes the PCM to Opus through ffmpeg, as the server does,
+// a 20 ms packet per read. ffmpeg starts on the first Encode. It holds a
+// few reads back, so each packet goes out with the timestamp of the read
+// it comes out with, the same delay later throughout, and the first reads
+// send nothing.
+type opusEncoder struct {
+	channels int
+
+	once    sync.Once // Starts ffmpeg
+	cmd     *exec.Cmd
+	in      chan []byte // PCM to the goroutine feeding ffmpeg
+	packets chan []byte // From the Ogg stream ffmpeg writes
+	closed  sync.Once   // Closes in
+
+	mu     sync.Mutex
+	stderr bytes.Buffer
+	failed error
+}
+
+func (*opusEncoder) PayloadType() uint8 { return payloadTypeOpus }
+
+// Check takes 48 kHz audio, the RTP clock of Opus (RFC 7587), in mono or
+// stereo, and needs ffmpeg on the PATH.
+func (o *opusEncoder) Check(sampleRate, channels int) error {
+	if sampleRate != opusRate || channels < 1 || channels > 2 {
+		return fmt.Errorf("Opus carries 48000 Hz mono or stereo audio, not %d Hz, %d channels", sampleRate, channels)
+	}
+	if _, err := exec.LookPath("ffmpeg"); err != nil {
+		return fmt.Errorf("encoding Opus needs ffmpeg with libopus: %w", err)
+	}
+	o.channels = channels
+	return nil
+}
+
+// PayloadSize is the size of a packet at opusBitrate, whatever the read.
+func (*opusEncoder) PayloadSize(pcmSize int) int { return opusPayload }
+
+// Encode hands pcm to ffmpeg and appends the oldest packet it encoded since,
+// if any, without waiting for either. When ffmpeg falls behind, the reads it
+// can't take and the oldest packets are dropped.
+func (o *opusEncoder) Encode(dst, pcm []byte) []byte {
+	o.once.Do(o.start)
+	if o.Err() != nil {
+		return dst
+	}
+	select {
+	case o.in <- bytes.Clone(pcm):
+	default:
+	}
+	select {
+	case packet, ok := <-o.packets:
+		if ok {
+			dst = append(dst, packet...)
+		}
+	default:
+	}
+	return dst
+}
+
+// Err explains why ffmpeg stopped, once it did.
+func (o *opusEncoder) Err() error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.failed != nil && o.stderr.Len() > 0 {
+		return fmt.Errorf("%v: %s", o.failed, bytes.TrimSpace(o.stderr.Bytes()))
+	}
+	return o.failed
+}
+
+// Close stops ffmpeg.
+func (o *opusEncoder) Close() error {
+	o.once.Do(func() {}) // Never start it after
+	if o.cmd == nil {
+		return nil
+	}
+	o.closed.Do(func() { close(o.in) })
+	o.cmd.Process.Kill()
+	o.cmd.Wait()
+	return nil
+}
+
+func (o *opusEncoder) start() {
+	o.cmd = exec.Command("ffmpeg", "-hide_banner", "-loglevel", "error", "-nostdin",
+		"-f", "s16be", "-ar", strconv.Itoa(opusRate), "-ac", strconv.Itoa(o.channels), "-i", "pipe:0",
+		"-c:a", "libopus", "-b:a", opusBitrate, "-frame_duration", "20", "-page_duration", "20000", "-flush_packets", "1", "-f", "ogg", "pipe:1") // A page per packet, for latency
+	o.cmd.Stderr = &lockedWriter{mu: &o.mu, w: &o.stderr}
+	o.in = make(chan []byte, opusQueue)
+	o.packets = make(chan []byte, opusQueue)
+	stdin, err := o.cmd.StdinPipe()
+	if err != nil {
+		o.cmd = nil
+		o.stopped(err)
+		return
+	}
+	stdout, err := o.cmd.StdoutPipe()
+	if err != nil {
+		o.cmd = nil
+		o.stopped(err)
+		return
+	}
+	if err := o.cmd.Start(); err != nil {
+		o.cmd = nil
+		o.stopped(fmt.Errorf("failed to start ffmpeg: %w", err))
+		return
+	}
+	go o.feed(stdin)
+	go o.demux(stdout)
+}
+
+func (o *opusEncoder) feed(stdin io.WriteCloser) {
+	defer stdin.Close()
+	for pcm := range o.in {
+		if _, err := stdin.Write(pcm); err != nil {
+			for range o.in {
+			}
+			return
+		}
+	}
+}
+
+// demux splits ffmpeg's Ogg output into packets, skipping the OpusHead and
+// OpusTags headers, dropping the oldest queued when Encode falls behind.
+func (o *opusEncoder) demux(r io.Reader) {
+	defer close(o.packets)
+	pages := ogg.NewReader(r)
+	for n := 1; ; n++ {
+		packet, err := pages.Packet()
+		if err != nil {
+			o.stopped(err)
+			return
+		}
+		if n <= 2 || len(packet) == 0 {
+			continue
+		}
+		select {
+		case o.packets <- packet:
+		default: // Encode fell behind, so the oldest goes
+			select {
+			case <-o.packets:
+			default:
+			}
+			o.packets <- packet
+		}
+	}
+}
+
+func (o *opusEncoder) stopped(err error) {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		err = fmt.Errorf("ffmpeg exited")
+	}
+	o.mu.Lock()
+	o.failed = err
+	o.mu.Unlock()
+}
+
+// lockedWriter serializes writes to w.
+type lockedWriter struct {
+	mu *sync.Mutex
+	w  io.Writer
+}
+
+func (l *lockedWriter) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.w.Write(p)
+}
@@ -0,0 +1,60 @@
+package rtpstream
+
+// capturedRead is one read of parec's output and the RTP timestamp of its
+// first frame.
+type capturedRead struct {
+	pcm       []byte
+	timestamp uint32
+}
+
+// sendQueue hands reads of parec's output from the goroutine reading them to
+// the one packetizing and sending them. When sending falls behind and the
+// queue is full, the oldest queued read is dropped to make room, so a socket
+// that blocks for a moment delays the audio by at most the queue instead of
+// leaving parec unread until its capture buffer overruns. Reads keep the
+// timestamps they were captured at, so receivers see a dropped read as a
+// jump in time rather than audio played early.
+//
+// The buffers are allocated up front and passed back and forth: size for
+// queued reads and one being read into. The channel has room for all of
+// them, so pushing never blocks.
+type sendQueue struct {
+	reads chan capturedRead
+	free  chan []byte
+}
+
+func newSendQueue(size, bufferSize int) *sendQueue {
+	q := &sendQueue{
+		reads: make(chan capturedRead, size+1),
+		free:  make(chan []byte, size+1),
+	}
+	for i := 0; i < size+1; i++ {
+		q.free <- make([]byte, bufferSize)
+	}
+	return q
+}
+
+// buffer returns a buffer to read into: a free one or, when every buffer is
+// queued or being sent, the one of the oldest queued read, which is dropped.
+func (q *sendQueue) buffer() (buf []byte, dropped bool) {
+	select {
+	case buf = <-q.free:
+		return buf, false
+	default:
+	}
+	select {
+	case buf = <-q.free:
+		return buf, false
+	case read := <-q.reads:
+		return read.pcm, true
+	}
+}
+
+// push queues a read taken from buffer for sending. It doesn't block.
+func (q *sendQueue) push(read capturedRead) { q.reads <- read }
+
+// release returns the buffer of a read that was sent.
+func (q *sendQueue) release(buf []byte) { q.free <- buf }
+
+// close ends the stream once the queued reads are sent.
+func (q *sendQueue) close() { close(q.reads) }
@@ -0,0 +1,338 @@
+package rtpstream
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"expvar"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"sort"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/fcerini/audio-capture-shared/pkg/handshake"
+	"github.com/fcerini/audio-capture-shared/pkg/pcap"
+)
+
+const (
+	latencySamples = 100        // Latest measurements the percentiles are taken over
+	ntpEpochOffset = 2208988800 // Seconds from 1900, the NTP epoch, to 1970
+)
+
+// latencyBuckets are the upper bounds of the latency histogram, in ms.
+var latencyBuckets = []float64{1, 2, 5, 10, 20, 50, 100, 200, 500, 1000, 2000}
+
+// receiverStats holds what the receivers of the stream report about it in
+// RTCP receiver (or sender) reports, and warns when a receiver's loss or
+// jitter exceeds the -alert limits or it stops reporting.
+type receiverStats struct {
+	maxLoss   float64 // -alert-loss
+	maxJitter time.Duration
+	clockRate float64 // Of the stream, for converting jitter to time
+
+	mu      sync.Mutex
+	reports map[uint32]*receiverReport // By the SSRC of the receiver
+	answer  string                     // The result of the handshake, once answered
+	live    *liveness                  // Told of every packet from the receiver, with Keepalive
+
+	latency latencyHistogram
+}
+
+// receiverReport is the latest report of one receiver.
+type receiverReport struct {
+	LossPercent float64   `json:"loss_percent"` // Since its previous report
+	Lost        int32     `json:"packets_lost"` // In total
+	JitterMS    float64   `json:"jitter_ms"`
+	RTTMS       float64   `json:"rtt_ms,omitempty"` // Round trip, if the receiver echoes our sender reports
+	Time        time.Time `json:"time"`
+
+	degraded    bool // Loss or jitter are over the limits
+	unreachable bool // No report for reportTimeout
+}
+
+// publishReceiverVars publishes the reports of the receiverStats and the
+// latency histogram, for Publish.
+func publishReceiverVars() {
+	expvar.Publish("receivers", statsVar(func(s *Stats) any {
+		rs := &s.receivers
+		rs.mu.Lock()
+		defer rs.mu.Unlock()
+		out := make(map[string]receiverReport, len(rs.reports))
+		for id, r := range rs.reports {
+			out[fmt.Sprintf("%08x", id)] = *r
+		}
+		return out
+	}))
+	expvar.Publish("latency_ms", statsVar(func(s *Stats) any { return s.receivers.latency.vars() }))
+}
+
+// read handles the RTCP packets arriving on conn until it is closed,
+// checking now and then for receivers that stopped reporting. It closes conn
+// when ctx is done.
+func (rs *receiverStats) read(ctx context.Context, conn Transport, ssrc uint32, stats *sendStats, capture *pcap.Writer, log *slog.Logger) {
+	buf := make([]byte, mtu)
+	for {
+		if ctx.Err() != nil {
+			conn.Close()
+			return
+		}
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		n, err := conn.Read(buf)
+		if errors.Is(err, net.ErrClosed) || errors.Is(err, io.EOF) {
+			return
+		}
+		if err != nil {
+			// An ICMP port unreachable is reported to whichever of this
+			// read and the next send comes first
+			if errors.Is(err, syscall.ECONNREFUSED) {
+				stats.failed(1, err, log)
+			}
+			rs.checkTimeouts(log)
+			continue
+		}
+		capture.WriteUDP(addrPort(conn.RemoteAddr()), addrPort(conn.LocalAddr()), buf[:n], time.Now())
+		rs.live.heard(buf[:n])
+		rs.handle(buf[:n], ssrc, log)
+	}
+}
+
+// handle processes a compound RTCP packet, keeping the report blocks about
+// ssrc.
+func (rs *receiverStats) handle(b []byte, ssrc uint32, log *slog.Logger) {
+	for len(b) >= 8 && b[0]>>6 == 2 {
+		count := int(b[0] & 0x1f)
+		size := (int(binary.BigEndian.Uint16(b[2:])) + 1) * 4
+		if size > len(b) {
+			return
+		}
+		pkt := b[:size]
+		b = b[size:]
+
+		var blocks []byte
+		switch pkt[1] {
+		case 200: // Sender report, with 20 bytes of sender info
+			blocks = pkt[min(28, len(pkt)):]
+		case 201: // Receiver report
+			blocks = pkt[8:]
+		case 204: // APP, the handshake's answer
+			if subtype, answer, ok := handshake.Unmarshal(pkt); ok && subtype == handshake.Answer {
+				rs.handshakeAnswered(answer, log)
+			}
+			continue
+		default:
+			continue
+		}
+		reporter := binary.BigEndian.Uint32(pkt[4:])
+		for i := 0; i < count && len(blocks) >= 24; i, blocks = i+1, blocks[24:] {
+			if binary.BigEndian.Uint32(blocks) != ssrc {
+				continue
+			}
+			lost := int32(binary.BigEndian.Uint32(blocks[4:])<<8) >> 8 // 24-bit signed
+			now := time.Now()
+			report := receiverReport{
+				LossPercent: float64(blocks[4]) / 256 * 100,
+				Lost:        lost,
+				JitterMS:    float64(binary.BigEndian.Uint32(blocks[12:])) / rs.clockRate * 1000,
+				Time:        now,
+			}
+			// The round trip is the time since the sender report the block
+			// refers to, less what the receiver held it for (RFC 3550
+			// section 6.4.1), in 1/65536 s
+			if lsr := binary.BigEndian.Uint32(blocks[16:]); lsr != 0 {
+				rtt := ntpMiddle(now) - lsr - binary.BigEndian.Uint32(blocks[20:])
+				if rtt < 1<<31 {
+					report.RTTMS = float64(rtt) / 65536 * 1000
+					rs.latency.add(report.RTTMS / 2)
+				}
+			}
+			rs.update(reporter, report, log)
+		}
+	}
+}
+
+// update stores a receiver's report, logging when it crosses the limits.
+func (rs *receiverStats) update(id uint32, r receiverReport, log *slog.Logger) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	log = log.With("receiver", fmt.Sprintf("%08x", id))
+	prev, known := rs.reports[id]
+	r.degraded = r.LossPercent > rs.maxLoss || r.JitterMS > float64(rs.maxJitter)/float64(time.Millisecond)
+	switch {
+	case !known:
+		log.Info("📬 Receiving RTCP reports")
+	case prev.unreachable:
+		log.Info("📬 Receiver is reporting again")
+	}
+	if r.degraded && (!known || !prev.degraded) {
+		log.Warn("Receiver reports a degraded stream", "loss_percent", fmt.Sprintf("%.1f", r.LossPercent), "packets_lost", r.Lost, "jitter_ms", fmt.Sprintf("%.1f", r.JitterMS))
+	} else if !r.degraded && known && prev.degraded {
+		log.Info("✅ Receiver reports a healthy stream again", "loss_percent", fmt.Sprintf("%.1f", r.LossPercent), "jitter_ms", fmt.Sprintf("%.1f", r.JitterMS))
+	}
+	rs.reports[id] = &r
+}
+
+// sendReports sends an RTCP sender report every interval once packets are
+// being sent, until done is closed, on the transport conn returns at the
+// time. Receivers echo its time in their reports, which gives the round trip
+// to them.
+func sendReports(conn func() Transport, ssrc uint32, clockRate int, stats *sendStats, capture *pcap.Writer, interval time.Duration, done <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case now := <-ticker.C:
+			last := stats.lastSent.Load()
+			if last == 0 {
+				continue
+			}
+			// The RTP time of now, extrapolated from the latest packet
+			ts := stats.lastTS.Load() + uint32(now.Sub(time.Unix(0, last)).Seconds()*float64(clockRate))
+			packets, bytes := stats.packets.Load(), stats.bytes.Load()
+			pkt := marshalSR(ssrc, now, ts, uint32(packets), uint32(bytes-12*packets))
+			c := conn()
+			if _, err := c.Write(pkt); err == nil {
+				capture.WriteUDP(addrPort(c.LocalAddr()), addrPort(c.RemoteAddr()), pkt, now)
+			}
+		}
+	}
+}
+
+// marshalSR encodes a compound RTCP packet: a sender report without report
+// blocks and the SDES CNAME.
+func marshalSR(ssrc uint32, now time.Time, rtpTime, packets, octets uint32) []byte {
+	b := make([]byte, 28, 64)
+	b[0] = 2 << 6 // Version 2, no report blocks
+	b[1] = 200
+	binary.BigEndian.PutUint16(b[2:], 6) // Length in 32-bit words, minus one
+	binary.BigEndian.PutUint32(b[4:], ssrc)
+	binary.BigEndian.PutUint32(b[8:], uint32(now.Unix()+ntpEpochOffset))
+	binary.BigEndian.PutUint32(b[12:], uint32(uint64(now.Nanosecond())<<32/1e9))
+	binary.BigEndian.PutUint32(b[16:], rtpTime)
+	binary.BigEndian.PutUint32(b[20:], packets)
+	binary.BigEndian.PutUint32(b[24:], octets)
+
+	host, _ := os.Hostname()
+	cname := "audio-capture-client@" + host
+	cname = cname[:min(len(cname), 255)]
+	chunk := 4 + 2 + len(cname) + 1
+	chunk += (4 - chunk%4) % 4
+	sdes := make([]byte, 4+chunk)
+	sdes[0] = 2<<6 | 1
+	sdes[1] = 202
+	binary.BigEndian.PutUint16(sdes[2:], uint16(len(sdes)/4-1))
+	binary.BigEndian.PutUint32(sdes[4:], ssrc)
+	sdes[8] = 1 // CNAME
+	sdes[9] = byte(len(cname))
+	copy(sdes[10:], cname)
+	return append(b, sdes...)
+}
+
+// ntpMiddle returns the middle 32 bits of the NTP time of t, as used in
+// RTCP's LSR field.
+func ntpMiddle(t time.Time) uint32 {
+	secs := uint32(t.Unix() + ntpEpochOffset)
+	frac := uint32(uint64(t.Nanosecond()) << 32 / 1e9)
+	return secs<<16 | frac>>16
+}
+
+// latencyHistogram collects network latency measurements: half the round
+// trips reported over RTCP.
+type latencyHistogram struct {
+	mu     sync.Mutex
+	counts [12]int64 // Per bucket of latencyBuckets, and one for slower ones
+	count  int64
+	sumMS  float64
+	recent []float64 // The latest latencySamples, a ring from next
+	next   int
+}
+
+func (h *latencyHistogram) add(ms float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.counts[sort.SearchFloat64s(latencyBuckets, ms)]++
+	h.count++
+	h.sumMS += ms
+	if len(h.recent) < latencySamples {
+		h.recent = append(h.recent, ms)
+	} else {
+		h.recent[h.next] = ms
+		h.next = (h.next + 1) % latencySamples
+	}
+}
+
+// percentiles returns the median and 95th percentile of the latest
+// measurements, or false without any.
+func (h *latencyHistogram) percentiles() (p50, p95 float64, ok bool) {
+	h.mu.Lock()
+	sorted := append([]float64(nil), h.recent...)
+	h.mu.Unlock()
+	if len(sorted) == 0 {
+		return 0, 0, false
+	}
+	sort.Float64s(sorted)
+	rank := func(p float64) float64 { return sorted[int(p*float64(len(sorted)-1)+0.5)] }
+	return rank(0.5), rank(0.95), true
+}
+
+// vars returns the histogram for /debug/vars, with cumulative bucket counts
+// as in Prometheus.
+func (h *latencyHistogram) vars() any {
+	type bucket struct {
+		LE    string `json:"le"`
+		Count int64  `json:"count"`
+	}
+	p50, p95, _ := h.percentiles()
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	buckets := make([]bucket, 0, len(h.counts))
+	var total int64
+	for i, n := range h.counts {
+		total += n
+		le := "+Inf"
+		if i < len(latencyBuckets) {
+			le = fmt.Sprint(latencyBuckets[i])
+		}
+		buckets = append(buckets, bucket{le, total})
+	}
+	return map[string]any{"count": h.count, "sum": h.sumMS, "buckets": buckets, "p50": p50, "p95": p95}
+}
+
+// forget drops the reports received so far, of receivers no longer sent to.
+func (rs *receiverStats) forget() {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	clear(rs.reports)
+	rs.answer = ""
+}
+
+// lastReport returns when the latest report arrived, zero if none did.
+func (rs *receiverStats) lastReport() time.Time {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	var last time.Time
+	for _, r := range rs.reports {
+		if r.Time.After(last) {
+			last = r.Time
+		}
+	}
+	return last
+}
+
+// checkTimeouts warns of receivers that stopped reporting.
+func (rs *receiverStats) checkTimeouts(log *slog.Logger) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	for id, r := range rs.reports {
+		if !r.unreachable && time.Since(r.Time) > reportTimeout {
+			r.unreachable = true
+			log.Warn("Receiver stopped sending RTCP reports, it may be unreachable", "receiver", fmt.Sprintf("%08x", id), "last_report", r.Time.Format(time.RFC3339))
+		}
+	}
+}
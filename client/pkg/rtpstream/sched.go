@@ -0,0 +1,69 @@
+package rtpstream
+
+import (
+	"fmt"
+	"log/slog"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// maxCPU is the highest CPU number ParseCPUList accepts, the size of the
+// kernel's default CPU set.
+const maxCPU = 1023
+
+// Tuning is the scheduling the threads capturing and sending audio ask
+// for, with -rt-priority, -rt-policy and -cpu-affinity in the client.
+type Tuning struct {
+	Policy   string // "fifo" or "rr"
+	Priority int    // Real-time priority from 1 to 99; 0 keeps the normal scheduler
+	CPUs     []int  // CPUs the threads may run on; empty for any
+}
+
+// tune locks the calling goroutine to its OS thread and applies t to that
+// thread, warning when it can't, e.g. without CAP_SYS_NICE. The goroutine
+// must keep the thread locked: when it returns, the runtime ends the thread
+// instead of running other goroutines on it at real-time priority.
+func (t Tuning) tune(thread string, log *slog.Logger) {
+	if t.Priority == 0 && len(t.CPUs) == 0 {
+		return
+	}
+	runtime.LockOSThread()
+	if err := t.apply(); err != nil {
+		log.Warn("Tuning the thread's scheduling failed", "thread", thread, "err", err)
+		return
+	}
+	attrs := []any{"thread", thread}
+	if t.Priority > 0 {
+		attrs = append(attrs, "policy", t.Policy, "priority", t.Priority)
+	}
+	if len(t.CPUs) > 0 {
+		attrs = append(attrs, "cpus", fmt.Sprint(t.CPUs))
+	}
+	log.Info("⏱️  Tuned thread scheduling", attrs...)
+}
+
+// ParseCPUList parses a list of CPUs in the kernel's format, e.g. "0,2-3".
+func ParseCPUList(s string) ([]int, error) {
+	var cpus []int
+	for _, part := range strings.Split(s, ",") {
+		first, last, isRange := strings.Cut(strings.TrimSpace(part), "-")
+		lo, err := strconv.Atoi(first)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CPU %q", part)
+		}
+		hi := lo
+		if isRange {
+			if hi, err = strconv.Atoi(last); err != nil || hi < lo {
+				return nil, fmt.Errorf("invalid CPU range %q", part)
+			}
+		}
+		if lo < 0 || hi > maxCPU {
+			return nil, fmt.Errorf("CPU %q out of range 0-%d", part, maxCPU)
+		}
+		for cpu := lo; cpu <= hi; cpu++ {
+			cpus = append(cpus, cpu)
+		}
+	}
+	return cpus, nil
+}
@@ -0,0 +1,32 @@
+package rtpstream
+
+import (
+	"fmt"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// apply sets the CPU affinity and scheduling policy of the calling thread.
+func (t Tuning) apply() error {
+	if len(t.CPUs) > 0 {
+		var set unix.CPUSet
+		for _, cpu := range t.CPUs {
+			set.Set(cpu)
+		}
+		if err := unix.SchedSetaffinity(0, &set); err != nil {
+			return fmt.Errorf("setting the CPU affinity: %w", err)
+		}
+	}
+	if t.Priority > 0 {
+		policy := unix.SCHED_FIFO
+		if t.Policy == "rr" {
+			policy = unix.SCHED_RR
+		}
+		attr := unix.SchedAttr{Size: unix.SizeofSchedAttr, Policy: uint32(policy), Priority: uint32(t.Priority)}
+		if err := unix.SchedSetAttr(0, &attr, 0); err != nil {
+			return fmt.Errorf("setting SCHED_%s priority %d, which needs CAP_SYS_NICE or an rtprio limit: %w", strings.ToUpper(t.Policy), t.Priority, err)
+		}
+	}
+	return nil
+}
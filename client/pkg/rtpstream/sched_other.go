@@ -0,0 +1,10 @@
+//go:build !linux
+
+package rtpstream
+
+import "errors"
+
+// apply is only supported on Linux.
+func (t Tuning) apply() error {
+	return errors.New("-rt-priority and -cpu-affinity are only supported on Linux")
+}
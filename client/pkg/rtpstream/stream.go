@@ -0,0 +1,592 @@
+// Package rtpstream sends 16-bit PCM audio as an RTP stream, with RTCP
+// sender reports and the receivers' reports read back. The payload is
+// encoded by an Encoder and the packets are carried by a Transport, L16
+// over UDP by default; both are chosen by name in the Config, and more can
+// be registered.
+//
+//	stream, err := rtpstream.Dial(ctx, rtpstream.Config{Destination: "127.0.0.1:6001"})
+//	if err != nil {
+//		return err
+//	}
+//	stream.Start(audio) // Big-endian 16-bit PCM, 48 kHz mono by default
+//	<-stream.Done()
+package rtpstream
+
+import (
+	"bufio"
+	"context"
+	"expvar"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"math/rand"
+	"net"
+	"net/netip"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pion/rtp"
+
+	"github.com/fcerini/audio-capture-shared/pkg/dscp"
+	"github.com/fcerini/audio-capture-shared/pkg/formatext"
+	"github.com/fcerini/audio-capture-shared/pkg/pcap"
+)
+
+const (
+	payloadTypeL16 = 96   // Dynamic payload type for L16
+	bitDepth       = 16   // L16
+	mtu            = 1500 // Maximum Transmission Unit for RTP packets
+
+	sendWarnInterval = 5 * time.Second  // How often failing sends are reported
+	underrunRatio    = 0.9              // Capturing less audio than this share of real time is an underrun
+	reportTimeout    = 20 * time.Second // A receiver that sent RTCP reports and stops for this long is unreachable
+)
+
+// Config configures a stream. Only Destination is required.
+type Config struct {
+	Destination string     // host:port of the receiver, a server's URL with ICE or a WHIP endpoint's with whip, or the mDNS name of one
+	SampleRate  int        // Of the audio and the RTP clock; 48000 by default
+	Channels    int        // 1 by default
+	Encoding    string     // See NewEncoder; l16 by default
+	Transport   string     // See DialTransport; udp by default
+	Handshake   bool       // Offer the format to the receiver in RTCP, see the README
+	DSCP        dscp.Class // Of the packets sent; 0 leaves them unmarked
+	Bind        netip.Addr // The source address of the stream; any when invalid
+	Interface   string     // The interface the stream leaves through, on Linux; any when empty
+	STUN        string     // host:port of a STUN server to learn the stream's reflexive address from, over UDP
+
+	// Attach the format to a packet this often in an RTP header extension,
+	// so a receiver knows it whenever it joins; see the README. 0 never does.
+	FormatInterval time.Duration
+
+	// A TURN server to relay the stream through over UDP, when the receiver
+	// doesn't answer STUN directly or always
+	TURN                       string // host:port
+	TURNUsername, TURNPassword string // Long-term credentials
+	TURNAlways                 bool
+
+	// Negotiate the path with ICE-lite when the destination is a server's
+	// URL, such as http://192.0.2.7:8080, posting to its /ice with ICEToken
+	ICE      bool
+	ICEToken string
+
+	// The bearer token of the WHIP endpoint the destination is the URL of,
+	// with the whip transport
+	WHIPToken string
+
+	// Send a STUN Binding request this often, paused or not, over UDP, and
+	// tell from the answers and the RTCP reports when the receiver goes down
+	// and comes back; 0 sends none. With Spool, a directory, the audio
+	// captured while it is down is kept there in WAV files.
+	Keepalive time.Duration
+	Spool     string
+
+	// Destinations to fail over to, in order, when the one sent to fails for
+	// FailoverAfter, 5 s by default; see watchFailover
+	Backups       []string
+	FailoverAfter time.Duration
+
+	SendQueue      int           // 20 ms reads queued while sending is blocked; 10 by default
+	MaxBandwidth   Bandwidth     // The audio sent at most, headers included; 0 is unlimited
+	RTCPInterval   time.Duration // How often to send sender reports; 0 sends none
+	ReportInterval time.Duration // How often to log the bitrate; 0 never does
+
+	Tuning Tuning       // Of the capture and send threads
+	Stats  *Stats       // Where the stream counts; a new one with the default alert limits if nil
+	Pcap   *pcap.Writer // Records the packets sent and the reports received, if not nil
+	Log    *slog.Logger
+}
+
+// Stream is an RTP stream to one receiver, from Dial.
+type Stream struct {
+	ctx  context.Context // Of Dial
+	cfg  Config
+	enc  Encoder
+	ssrc uint32
+	done chan struct{}
+
+	mu          sync.RWMutex
+	conn        Transport // Held for reading while sending; guarded by mu
+	destination string    // Of conn; guarded by mu
+
+	paused    atomic.Bool
+	formatDue atomic.Bool   // Attach the format to the next packet, with FormatInterval
+	gain      atomic.Uint64 // In dB, as float64 bits
+	live      *liveness     // nil without Keepalive
+	failover  *failover     // nil without Backups
+}
+
+// Dial connects to cfg.Destination, or the first of cfg.Backups that can be
+// dialed when it can't be, and starts reading the RTCP reports the
+// receiver sends back. Audio is sent once Start is called. The packet
+// capture only records streams over UDP.
+//
+// The stream stops when ctx is done: the read being sent goes out, Done is
+// closed and the connection is closed.
+func Dial(ctx context.Context, cfg Config) (*Stream, error) {
+	if cfg.SampleRate == 0 {
+		cfg.SampleRate = 48000
+	}
+	if cfg.Channels == 0 {
+		cfg.Channels = 1
+	}
+	if cfg.SendQueue == 0 {
+		cfg.SendQueue = 10
+	}
+	if cfg.Stats == nil {
+		cfg.Stats = NewStats(5, 30*time.Millisecond)
+	}
+	if cfg.Encoding == "" {
+		cfg.Encoding = "l16"
+	}
+	if cfg.Transport == "" {
+		cfg.Transport = "udp"
+	}
+	if cfg.Log == nil {
+		cfg.Log = slog.Default()
+	}
+	if cfg.FailoverAfter == 0 {
+		cfg.FailoverAfter = 5 * time.Second
+	}
+	if cfg.Transport == "whip" && cfg.Encoding != "opus" {
+		return nil, fmt.Errorf("the whip transport carries opus, not %s", cfg.Encoding)
+	}
+	enc, err := NewEncoder(cfg.Encoding)
+	if err != nil {
+		return nil, err
+	}
+	if err := enc.Check(cfg.SampleRate, cfg.Channels); err != nil {
+		return nil, err
+	}
+
+	// The backups are tried in turn when the primary can't be dialed
+	destination, active := cfg.Destination, 0
+	conn, err := dial(ctx, cfg, destination)
+	for ; err != nil && active < len(cfg.Backups); active++ {
+		cfg.Log.Warn("🔀 Dialing the destination failed, trying the next one", "destination", destination, "err", err)
+		destination = cfg.Backups[active]
+		conn, err = dial(ctx, cfg, destination)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := conn.LocalAddr().(*net.UDPAddr); !ok && cfg.Pcap != nil {
+		cfg.Log.Warn("The packet capture only records UDP, leaving it empty", "transport", cfg.Transport)
+		cfg.Pcap = nil
+	}
+	ssrc := rand.Uint32()
+	if t, ok := conn.(interface{ SSRC() uint32 }); ok {
+		ssrc = t.SSRC()
+	}
+	s := &Stream{ctx: ctx, cfg: cfg, conn: conn, destination: destination, enc: enc, ssrc: ssrc, done: make(chan struct{})}
+	if len(cfg.Backups) > 0 {
+		s.failover = &failover{destinations: append([]string{cfg.Destination}, cfg.Backups...), active: active}
+	}
+	if cfg.Keepalive > 0 {
+		if _, ok := conn.LocalAddr().(*net.UDPAddr); ok {
+			s.live = newLiveness(cfg)
+			cfg.Stats.receivers.live = s.live
+		} else {
+			cfg.Log.Warn("Keepalives are only sent over UDP, leaving them off", "transport", cfg.Transport)
+		}
+	}
+
+	// Receivers that support it send RTCP reports back on the same port
+	cfg.Stats.receivers.clockRate = float64(cfg.SampleRate)
+	go cfg.Stats.receivers.read(ctx, conn, s.ssrc, &cfg.Stats.send, cfg.Pcap, cfg.Log)
+	return s, nil
+}
+
+// Start sends the audio read from audio, big-endian 16-bit PCM in the
+// configured format, until it ends or the context of Dial is done. Done is
+// closed once the audio read is sent. A read that blocks when the context is
+// done is left to return on its own.
+func (s *Stream) Start(audio io.Reader) {
+	cfg, stats, log := s.cfg, &s.cfg.Stats.send, s.cfg.Log
+	bufferSize := (cfg.SampleRate / 50) * cfg.Channels * (bitDepth / 8)
+	payload := make([]byte, 0, payloadSize(s.enc, bufferSize))
+	packetizer := newPacketizer(s.ssrc, s.enc.PayloadType(), cap(payload), packetSize(s.transport()))
+	limit := newLimiter(cfg.MaxBandwidth)
+	if need := streamBandwidth(cap(payload)); limit != nil && need > cfg.MaxBandwidth {
+		log.Warn("The stream needs more than -max-bandwidth, so audio will be dropped; pick a smaller encoding, rate or channels",
+			"needs", need.String(), "max_bandwidth", cfg.MaxBandwidth.String(), "encoding", cfg.Encoding)
+	}
+
+	// Report what is sent until the stream ends
+	if cfg.ReportInterval > 0 {
+		go stats.report(log, &cfg.Stats.receivers.latency, cfg.SampleRate, cfg.ReportInterval, s.done)
+	}
+	if cfg.RTCPInterval > 0 {
+		go sendReports(s.transport, s.ssrc, cfg.SampleRate, stats, cfg.Pcap, cfg.RTCPInterval, s.done)
+	}
+	// The offer goes ahead of the audio, so the receiver knows its format
+	// from the first packet
+	if cfg.Handshake {
+		s.sendOffer()
+		go s.repeatOffer()
+	}
+	if s.live != nil {
+		go s.keepalive()
+	}
+	if s.failover != nil {
+		go s.watchFailover()
+	}
+
+	// Read the audio on one goroutine and packetize and send it on another,
+	// so a socket that blocks doesn't hold up the capture. Both run on
+	// threads of their own with a Tuning.
+	samples := uint32(cfg.SampleRate / 50)
+	queue := newSendQueue(cfg.SendQueue, bufferSize)
+	go func() {
+		defer queue.close()
+		cfg.Tuning.tune("capture", log)
+		reader := bufio.NewReaderSize(audio, bufferSize)
+		timestamp := rand.Uint32() // A random start, as RFC 3550 asks
+		var (
+			drops       int       // Since the last warning
+			lastWarning time.Time // Drops are reported at most every sendWarnInterval
+		)
+
+		for s.ctx.Err() == nil {
+			pcmData, dropped := queue.buffer()
+			if dropped {
+				stats.dropped.Add(1)
+				drops++
+				if time.Since(lastWarning) >= sendWarnInterval {
+					log.Warn("Sending fell behind, dropping the oldest queued audio", "dropped_ms", drops*20, "queue", cfg.SendQueue)
+					drops, lastWarning = 0, time.Now()
+				}
+			}
+			n, err := io.ReadFull(reader, pcmData)
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				log.Info("👂 Audio stream ended")
+				return
+			}
+			if err != nil {
+				log.Error("Reading the audio failed", "err", err)
+				return
+			}
+			stats.frames.Add(int64(n / (cfg.Channels * bitDepth / 8)))
+			if s.paused.Load() {
+				// The time paused still passes for the receiver
+				queue.release(pcmData)
+				timestamp += samples
+				continue
+			}
+			if db := s.Gain(); db != 0 {
+				applyGain(pcmData, math.Pow(10, db/20))
+			}
+			s.live.keep(pcmData)
+			queue.push(capturedRead{pcm: pcmData, timestamp: timestamp})
+			timestamp += samples
+		}
+	}()
+
+	go func() {
+		defer func() {
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			s.conn.Close()
+		}()
+		defer close(s.done)
+		if c, ok := s.enc.(io.Closer); ok {
+			defer c.Close()
+		}
+		cfg.Tuning.tune("send", log)
+		var formatAt time.Time // When the format was last attached
+		format := s.formatExtension()
+		for {
+			var read capturedRead
+			select {
+			case <-s.ctx.Done():
+				return
+			case r, ok := <-queue.reads:
+				if !ok {
+					return
+				}
+				read = r
+			}
+			payload = s.enc.Encode(payload[:0], read.pcm)
+			if len(payload) == 0 {
+				// An encoder such as opus holds the first reads back
+				queue.release(read.pcm)
+				if e, ok := s.enc.(interface{ Err() error }); ok && e.Err() != nil {
+					log.Error("Encoding the audio failed", "encoding", cfg.Encoding, "err", e.Err())
+					return
+				}
+				continue
+			}
+			if cfg.FormatInterval > 0 && (s.formatDue.Swap(false) || time.Since(formatAt) >= cfg.FormatInterval) {
+				packetizer.attach(format)
+				formatAt = time.Now()
+			}
+			packets, err := packetizer.packetize(payload, read.timestamp)
+			if err != nil {
+				log.Error("Marshalling RTP packet failed", "err", err)
+				queue.release(read.pcm)
+				continue
+			}
+			if !limit.wait(s.ctx, packets) {
+				queue.release(read.pcm)
+				return
+			}
+			s.mu.RLock()
+			sent, err := s.conn.Send(packets)
+			local, remote := addrPort(s.conn.LocalAddr()), addrPort(s.conn.RemoteAddr())
+			s.mu.RUnlock()
+			now := time.Now()
+			for _, data := range packets[:sent] {
+				cfg.Pcap.WriteUDP(local, remote, data, now)
+				stats.packets.Add(1)
+				stats.bytes.Add(int64(len(data)))
+			}
+			if sent > 0 {
+				stats.lastTS.Store(read.timestamp)
+				stats.lastSent.Store(now.UnixNano())
+			}
+			if err != nil {
+				stats.failed(len(packets)-sent, err, log)
+			}
+			queue.release(read.pcm)
+		}
+	}()
+}
+
+// Done is closed when the stream ended and everything read was sent.
+func (s *Stream) Done() <-chan struct{} { return s.done }
+
+// Stats counts what a stream captured and sent, and holds what its
+// receivers report about it. A Stats is for one stream.
+type Stats struct {
+	send      sendStats
+	receivers receiverStats
+}
+
+// NewStats warns of receivers reporting more than alertLoss percent loss
+// or more than alertJitter jitter.
+func NewStats(alertLoss float64, alertJitter time.Duration) *Stats {
+	return &Stats{receivers: receiverStats{maxLoss: alertLoss, maxJitter: alertJitter, reports: make(map[uint32]*receiverReport)}}
+}
+
+var (
+	statsOnce      sync.Once
+	statsPublished atomic.Pointer[Stats] // The Stats Publish was last called for
+)
+
+// Publish makes the counters and the receivers' reports available with
+// expvar on /debug/vars. The variables are published once per process and
+// follow the Stats Publish was last called for, so a program can stream
+// again, e.g. with client.Run.
+func (s *Stats) Publish() {
+	statsPublished.Store(s)
+	statsOnce.Do(func() {
+		publishSendVars()
+		publishReceiverVars()
+	})
+}
+
+// statsVar is a variable of the Stats Publish was last called for.
+func statsVar(f func(s *Stats) any) expvar.Func {
+	return func() any { return f(statsPublished.Load()) }
+}
+
+// LastSent returns when the latest packet was sent, or the zero time before
+// the first.
+func (s *Stats) LastSent() time.Time {
+	last := s.send.lastSent.Load()
+	if last == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, last)
+}
+
+// Counters are the totals of a Stats, as in /debug/vars.
+type Counters struct {
+	FramesCaptured int64 `json:"frames_captured"`
+	PacketsSent    int64 `json:"packets_sent"`
+	BytesSent      int64 `json:"bytes_sent"`
+	SendFailures   int64 `json:"send_failures"`
+	ReadsDropped   int64 `json:"reads_dropped"`
+}
+
+// Counters returns the totals so far, for a program reporting on streams it
+// doesn't Publish.
+func (s *Stats) Counters() Counters {
+	return Counters{
+		FramesCaptured: s.send.frames.Load(),
+		PacketsSent:    s.send.packets.Load(),
+		BytesSent:      s.send.bytes.Load(),
+		SendFailures:   s.send.failures.Load(),
+		ReadsDropped:   s.send.dropped.Load(),
+	}
+}
+
+// Reception returns the loss and jitter of the latest report of the receiver
+// reporting the most loss, or false before any report.
+func (s *Stats) Reception() (lost int32, lossPercent, jitterMS float64, ok bool) {
+	rs := &s.receivers
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	for _, r := range rs.reports {
+		if !ok || r.LossPercent > lossPercent {
+			lost, lossPercent, jitterMS, ok = r.Lost, r.LossPercent, r.JitterMS, true
+		}
+	}
+	return lost, lossPercent, jitterMS, ok
+}
+
+// sendStats counts what the client captured and sent, for the periodic
+// report.
+type sendStats struct {
+	frames   atomic.Int64  // Audio frames read
+	packets  atomic.Int64  // RTP packets sent
+	bytes    atomic.Int64  // Of the packets sent, headers included
+	failures atomic.Int64  // Packets that couldn't be sent
+	dropped  atomic.Int64  // Reads dropped because sending fell behind
+	lastTS   atomic.Uint32 // RTP timestamp of the latest packet, for sender reports
+
+	lastSent atomic.Int64 // When the latest packet was sent, in Unix nanoseconds
+
+	mu          sync.Mutex
+	unreported  int       // Failures since the last warning; guarded by mu
+	lastWarning time.Time // Failures are reported at most every sendWarnInterval; guarded by mu
+}
+
+// failed counts n packets that couldn't be sent, warning with err at most
+// every sendWarnInterval.
+func (s *sendStats) failed(n int, err error, log *slog.Logger) {
+	s.failures.Add(int64(n))
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.unreported += n
+	if time.Since(s.lastWarning) >= sendWarnInterval {
+		log.Warn("Sending RTP packets failed", "failed", s.unreported, "err", err)
+		s.unreported, s.lastWarning = 0, time.Now()
+	}
+}
+
+// publishSendVars publishes the counters of the sendStats, for Publish. The
+// goroutines have a name of their own, apart from the server's, which runs
+// in the same process in the audio-capture tool.
+func publishSendVars() {
+	expvar.Publish("frames_captured", statsVar(func(s *Stats) any { return s.send.frames.Load() }))
+	expvar.Publish("packets_sent", statsVar(func(s *Stats) any { return s.send.packets.Load() }))
+	expvar.Publish("bytes_sent", statsVar(func(s *Stats) any { return s.send.bytes.Load() }))
+	expvar.Publish("send_failures", statsVar(func(s *Stats) any { return s.send.failures.Load() }))
+	expvar.Publish("reads_dropped", statsVar(func(s *Stats) any { return s.send.dropped.Load() }))
+	expvar.Publish("client_goroutines", expvar.Func(func() any { return runtime.NumGoroutine() }))
+}
+
+// report logs the bitrate and packet rate every interval until done is
+// closed, and warns when the audio arrived noticeably slower than the time
+// that passed, which leaves gaps in the stream.
+func (s *sendStats) report(log *slog.Logger, latency *latencyHistogram, sampleRate int, interval time.Duration, done <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	var lastFrames, lastPackets, lastBytes int64
+	last := time.Now()
+	for {
+		select {
+		case <-done:
+			return
+		case now := <-ticker.C:
+			frames, packets, bytes := s.frames.Load(), s.packets.Load(), s.bytes.Load()
+			secs := now.Sub(last).Seconds()
+			realtime := float64(frames-lastFrames) / float64(sampleRate) / secs
+			attrs := []any{
+				"kbps", fmt.Sprintf("%.1f", float64(bytes-lastBytes)*8/1000/secs),
+				"pps", fmt.Sprintf("%.1f", float64(packets-lastPackets)/secs),
+				"total_bytes", bytes,
+			}
+			if p50, p95, ok := latency.percentiles(); ok {
+				attrs = append(attrs, "latency_p50_ms", fmt.Sprintf("%.1f", p50), "latency_p95_ms", fmt.Sprintf("%.1f", p95))
+			}
+			log.Info("📈 Sent audio", attrs...)
+			if realtime < underrunRatio {
+				log.Warn("Capture underrun: audio arrives slower than real time",
+					"realtime_percent", fmt.Sprintf("%.0f", realtime*100),
+					"interval", interval)
+			}
+			lastFrames, lastPackets, lastBytes, last = frames, packets, bytes, now
+		}
+	}
+}
+
+// packetizer splits a read's payload into RTP packets of at most size bytes.
+// Each packet is marshalled straight into a buffer of its own, reused for
+// the same packet of the next read, where pion's packetizer allocates every
+// packet, its payload list and the bytes sent.
+type packetizer struct {
+	size    int
+	header  rtp.Header
+	ext     []byte // The format extension of the next read's first packet, see attach
+	bufs    [][]byte
+	packets [][]byte
+}
+
+// newPacketizer starts the stream at a random sequence number, as RFC 3550
+// asks. It splits payloads of up to readSize bytes into packets of size.
+func newPacketizer(ssrc uint32, payloadType uint8, readSize, size int) *packetizer {
+	p := &packetizer{
+		size: size,
+		header: rtp.Header{
+			Version:        2,
+			PayloadType:    payloadType,
+			SSRC:           ssrc,
+			SequenceNumber: uint16(rand.Uint32()),
+		},
+	}
+	payloadSize := size - p.header.MarshalSize()
+	for range (readSize + payloadSize - 1) / payloadSize {
+		p.bufs = append(p.bufs, make([]byte, size))
+	}
+	return p
+}
+
+// packetize returns the packets of one read's payload, captured at
+// timestamp. The last packet of the read has the marker bit set. The
+// packets are only valid until the next call.
+func (p *packetizer) packetize(payload []byte, timestamp uint32) ([][]byte, error) {
+	p.header.Timestamp = timestamp
+	p.packets = p.packets[:0]
+	for i := 0; len(payload) > 0; i++ {
+		if i == 0 && p.ext != nil {
+			if err := p.header.SetExtension(formatext.ID, p.ext); err != nil {
+				return nil, err
+			}
+			p.ext = nil
+		} else if p.header.Extension {
+			p.header.Extension, p.header.Extensions = false, p.header.Extensions[:0]
+		}
+		headerSize := p.header.MarshalSize()
+		chunkSize := min(len(payload), p.size-headerSize)
+		p.header.Marker = chunkSize == len(payload)
+		// The extension may take a packet more
+		if i == len(p.bufs) {
+			p.bufs = append(p.bufs, make([]byte, p.size))
+		}
+		buf := p.bufs[i]
+		if _, err := p.header.MarshalTo(buf); err != nil {
+			return nil, err
+		}
+		copy(buf[headerSize:], payload[:chunkSize])
+		p.packets = append(p.packets, buf[:headerSize+chunkSize])
+		p.header.SequenceNumber++
+		payload = payload[chunkSize:]
+	}
+	return p.packets, nil
+}
+
+// addrPort returns the address and port of a UDP or TCP address.
+func addrPort(a net.Addr) netip.AddrPort {
+	switch a := a.(type) {
+	case *net.UDPAddr:
+		return a.AddrPort()
+	case *net.TCPAddr:
+		return a.AddrPort()
+	}
+	return netip.AddrPort{}
+}
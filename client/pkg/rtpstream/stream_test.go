@@ -0,0 +1,291 @@
+package rtpstream
+
+import (
+	"context"
+	"expvar"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pion/webrtc/v4"
+)
+
+// discardTransport takes the packets sent and drops them, counting them,
+// and tells sent of every read sent. No reports ever arrive.
+type discardTransport struct {
+	addr      net.UDPAddr
+	packets   atomic.Int64
+	sent      chan struct{}
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func newDiscardTransport() *discardTransport {
+	return &discardTransport{sent: make(chan struct{}, 1), closed: make(chan struct{})}
+}
+
+func (t *discardTransport) Send(packets [][]byte) (int, error) {
+	t.packets.Add(int64(len(packets)))
+	t.sent <- struct{}{}
+	return len(packets), nil
+}
+
+func (t *discardTransport) Write(packet []byte) (int, error) {
+	t.packets.Add(1)
+	return len(packet), nil
+}
+
+func (t *discardTransport) Read(buf []byte) (int, error) {
+	<-t.closed
+	return 0, net.ErrClosed
+}
+
+func (t *discardTransport) SetReadDeadline(time.Time) error { return nil }
+func (t *discardTransport) LocalAddr() net.Addr             { return &t.addr }
+func (t *discardTransport) RemoteAddr() net.Addr            { return &t.addr }
+
+func (t *discardTransport) Close() error {
+	t.closeOnce.Do(func() { close(t.closed) })
+	return nil
+}
+
+// pacedSilence reads reads of silence, each once the one before was sent,
+// so the send queue never drops one.
+type pacedSilence struct {
+	reads int
+	sent  <-chan struct{}
+	first bool
+}
+
+func (r *pacedSilence) Read(p []byte) (int, error) {
+	if !r.first {
+		<-r.sent
+	}
+	r.first = false
+	if r.reads == 0 {
+		return 0, io.EOF
+	}
+	r.reads--
+	clear(p)
+	return len(p), nil
+}
+
+// BenchmarkSend measures the send loop of a stream, from reading the audio
+// to sending its packets, per 20 ms read of 48 kHz mono L16: 1920 bytes in
+// two packets. The loop reuses its buffers, so what it allocates is the
+// stream's own setup spread over the reads.
+func BenchmarkSend(b *testing.B) {
+	conn := newDiscardTransport()
+	RegisterTransport("discard", func(ctx context.Context, destination string, log *slog.Logger) (Transport, error) {
+		return conn, nil
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stream, err := Dial(ctx, Config{
+		Destination: "127.0.0.1:9",
+		Transport:   "discard",
+		Log:         slog.New(slog.NewTextHandler(io.Discard, nil)),
+	})
+	if err != nil {
+		b.Fatal(err)
+	}
+	const read = 48000 / 50 * bitDepth / 8
+
+	b.ReportAllocs()
+	b.SetBytes(read)
+	b.ResetTimer()
+	stream.Start(&pacedSilence{reads: b.N, sent: conn.sent, first: true})
+	<-stream.Done()
+	b.StopTimer()
+	if got, want := conn.packets.Load(), int64(2*b.N); got != want {
+		b.Fatalf("sent %d packets, want %d", got, want)
+	}
+}
+
+// BenchmarkPacketize measures splitting the payload of a 20 ms read of
+// 48 kHz mono L16 into its two packets.
+func BenchmarkPacketize(b *testing.B) {
+	payload := make([]byte, 48000/50*bitDepth/8)
+	p := newPacketizer(1, payloadTypeL16, len(payload), mtu)
+	var timestamp uint32
+
+	b.ReportAllocs()
+	b.SetBytes(int64(len(payload)))
+	for range b.N {
+		packets, err := p.packetize(payload, timestamp)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if len(packets) != 2 {
+			b.Fatalf("got %d packets, want 2", len(packets))
+		}
+		timestamp += 48000 / 50
+	}
+}
+
+// TestPublish publishes two Stats in turn, as two runs of client.Run do:
+// the second doesn't panic, and the variables follow it.
+func TestPublish(t *testing.T) {
+	first, second := NewStats(5, time.Second), NewStats(5, time.Second)
+	first.send.packets.Store(1)
+	second.send.packets.Store(2)
+	first.Publish()
+	second.Publish()
+	if got := expvar.Get("packets_sent").String(); got != "2" {
+		t.Errorf("packets_sent = %s, want the second Stats' 2", got)
+	}
+	if expvar.Get("receivers") == nil || expvar.Get("client_goroutines") == nil {
+		t.Error("the receivers' reports or the goroutines aren't published")
+	}
+}
+
+// whipEndpoint answers WHIP offers as the server does, taking the bearer
+// token "secret", and hands the first track published to tracks. DELETEs
+// are counted in ended.
+func whipEndpoint(t *testing.T, tracks chan<- *webrtc.TrackRemote, ended *atomic.Int64) *httptest.Server {
+	m := &webrtc.MediaEngine{}
+	if err := m.RegisterDefaultCodecs(); err != nil {
+		t.Fatal(err)
+	}
+	api := webrtc.NewAPI(webrtc.WithMediaEngine(m))
+	var (
+		mu  sync.Mutex
+		pcs []*webrtc.PeerConnection
+	)
+	t.Cleanup(func() {
+		mu.Lock()
+		defer mu.Unlock()
+		for _, pc := range pcs {
+			pc.Close()
+		}
+	})
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if r.Method == http.MethodDelete {
+			ended.Add(1)
+			return
+		}
+		offer, _ := io.ReadAll(r.Body)
+		pc, err := api.NewPeerConnection(webrtc.Configuration{})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		mu.Lock()
+		pcs = append(pcs, pc)
+		mu.Unlock()
+		pc.OnTrack(func(track *webrtc.TrackRemote, _ *webrtc.RTPReceiver) { tracks <- track })
+		if err := pc.SetRemoteDescription(webrtc.SessionDescription{Type: webrtc.SDPTypeOffer, SDP: string(offer)}); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		answer, err := pc.CreateAnswer(nil)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		gathered := webrtc.GatheringCompletePromise(pc)
+		pc.SetLocalDescription(answer)
+		<-gathered
+		w.Header().Set("Location", "/whip/1")
+		w.WriteHeader(http.StatusCreated)
+		io.WriteString(w, pc.LocalDescription().SDP)
+	}))
+}
+
+// TestWHIP publishes packets over the whip transport to an endpoint, which
+// receives them as Opus, with the SSRC the transport reports, and is asked
+// to end the session on Close. A wrong token is refused.
+func TestWHIP(t *testing.T) {
+	tracks := make(chan *webrtc.TrackRemote, 1)
+	var ended atomic.Int64
+	endpoint := whipEndpoint(t, tracks, &ended)
+	defer endpoint.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	if _, err := dial(ctx, Config{Transport: "whip", WHIPToken: "wrong", Log: log}, endpoint.URL+"/whip"); err == nil || !strings.Contains(err.Error(), "WHIP_TOKEN") {
+		t.Fatalf("dialing with the wrong token: %v, want an error naming WHIP_TOKEN", err)
+	}
+	conn, err := dial(ctx, Config{Transport: "whip", WHIPToken: "secret", Log: log}, endpoint.URL+"/whip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ssrc := conn.(interface{ SSRC() uint32 }).SSRC()
+
+	// Packets are sent until the track arrives, as pion only reports it
+	// once media flows
+	p := newPacketizer(1, payloadTypeOpus, 3, packetSize(conn))
+	var track *webrtc.TrackRemote
+	for track == nil {
+		packets, err := p.packetize([]byte{0xfc, 0xff, 0xfe}, 0) // A 20 ms CELT frame of silence
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := conn.Send(packets); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case track = <-tracks:
+		case <-time.After(20 * time.Millisecond):
+		case <-ctx.Done():
+			t.Fatal("the endpoint received no track")
+		}
+	}
+	if track.Codec().MimeType != webrtc.MimeTypeOpus || uint32(track.SSRC()) != ssrc {
+		t.Errorf("received %s with SSRC %08x, want Opus with %08x", track.Codec().MimeType, track.SSRC(), ssrc)
+	}
+	packet, _, err := track.ReadRTP()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(packet.Payload) != "\xfc\xff\xfe" {
+		t.Errorf("received the payload %x, want fcfffe", packet.Payload)
+	}
+
+	conn.Close()
+	if ended.Load() != 1 {
+		t.Errorf("the endpoint was asked to end %d sessions, want 1", ended.Load())
+	}
+}
+
+// TestOpusEncoder encodes a second of silence with ffmpeg, when installed:
+// the packets come out of the 50 reads, each a 20 ms Opus frame.
+func TestOpusEncoder(t *testing.T) {
+	enc, err := NewEncoder("opus")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := enc.Check(48000, 1); err != nil {
+		t.Skip(err)
+	}
+	defer enc.(io.Closer).Close()
+	pcm := make([]byte, 48000/50*bitDepth/8)
+	var packets int
+	for range 50 {
+		if payload := enc.Encode(nil, pcm); len(payload) > 0 {
+			if config := payload[0] >> 3; config != 3 && config != 15 && config != 19 && config != 23 && config != 27 && config != 31 {
+				t.Fatalf("a packet isn't a 20 ms frame: TOC %08b", payload[0])
+			}
+			packets++
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if err := enc.(interface{ Err() error }).Err(); err != nil {
+		t.Fatal(err)
+	}
+	if packets < 40 {
+		t.Errorf("encoded %d packets of 50 reads", packets)
+	}
+}
@@ -0,0 +1,299 @@
+package rtpstream
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/fcerini/audio-capture-shared/pkg/srt"
+)
+
+const srtHandshakeTimeout = 5 * time.Second
+
+// Transport carries the RTP and RTCP packets of a stream to one receiver,
+// and the receiver's RTCP packets back.
+type Transport interface {
+	// Send sends the packets of one read and returns how many of them went
+	// out before an error.
+	Send(packets [][]byte) (int, error)
+	// Write sends a single packet, such as a sender report.
+	Write(packet []byte) (int, error)
+	// Read reads a packet from the receiver. It fails with an error whose
+	// Timeout method reports true once the deadline passed.
+	Read(buf []byte) (int, error)
+	SetReadDeadline(t time.Time) error
+	LocalAddr() net.Addr
+	RemoteAddr() net.Addr
+	Close() error
+}
+
+// Dialer connects a transport to destination, a host:port, giving up when
+// ctx is done.
+type Dialer func(ctx context.Context, destination string, log *slog.Logger) (Transport, error)
+
+var (
+	transportsMu sync.Mutex
+	transports   = map[string]Dialer{
+		"udp":  udpDialer("udp"),
+		"udp4": udpDialer("udp4"),
+		"udp6": udpDialer("udp6"),
+		"tcp":  tcpDialer("tcp"),
+		"tcp4": tcpDialer("tcp4"),
+		"tcp6": tcpDialer("tcp6"),
+		"srt":  srtDialer("udp"),
+		"srt4": srtDialer("udp4"),
+		"srt6": srtDialer("udp6"),
+		"whip": dialWHIP,
+	}
+)
+
+// RegisterTransport makes a transport available to DialTransport under name,
+// replacing any transport of that name.
+func RegisterTransport(name string, dial Dialer) {
+	transportsMu.Lock()
+	defer transportsMu.Unlock()
+	transports[name] = dial
+}
+
+// Transports returns the names of the registered transports, sorted.
+func Transports() []string {
+	transportsMu.Lock()
+	defer transportsMu.Unlock()
+	names := make([]string, 0, len(transports))
+	for name := range transports {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// DialTransport connects the transport registered under name: udp, with
+// the packets of a read batched as described for the client, or tcp, with
+// every packet framed by its length as in RFC 4571, or srt, every packet a
+// message of an SRT connection, which sends the packets lost on the way
+// again. Each takes the first address a host name resolves to; udp4, udp6,
+// tcp4, tcp6, srt4 and srt6 only take its IPv4 or IPv6 ones. whip takes
+// the URL of a WHIP endpoint instead, and carries opus only.
+func DialTransport(ctx context.Context, name, destination string, log *slog.Logger) (Transport, error) {
+	transportsMu.Lock()
+	dial, ok := transports[name]
+	transportsMu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown transport %q", name)
+	}
+	return dial(ctx, destination, log)
+}
+
+// packetSize returns the largest RTP packet t carries: mtu, or less for a
+// transport with a MaxPacket method. A transport may also have an SSRC
+// method, returning the SSRC it sends the packets with whatever theirs.
+func packetSize(t Transport) int {
+	if m, ok := t.(interface{ MaxPacket() int }); ok {
+		return min(m.MaxPacket(), mtu)
+	}
+	return mtu
+}
+
+// udpTransport sends over a connected UDP socket.
+type udpTransport struct {
+	*net.UDPConn
+	batch *batchSender
+}
+
+func udpDialer(network string) Dialer {
+	return func(ctx context.Context, destination string, log *slog.Logger) (Transport, error) {
+		if err := checkHostPort(destination); err != nil {
+			return nil, err
+		}
+		d := dialer(ctx, network)
+		c, err := d.DialContext(ctx, network, destination)
+		if err != nil {
+			return nil, fmt.Errorf("failed to dial UDP: %w", err)
+		}
+		conn := c.(*net.UDPConn)
+		// Reads hold up to 4 packets at first; the sender grows for more
+		return &udpTransport{UDPConn: conn, batch: newBatchSender(conn, 4, log)}, nil
+	}
+}
+
+func (t *udpTransport) Send(packets [][]byte) (int, error) { return t.batch.send(packets) }
+
+// tcpTransport frames packets with a 16-bit length (RFC 4571) on a TCP
+// connection. Packets from the receiver are read on a goroutine of their
+// own, so a read deadline never splits a frame.
+type tcpTransport struct {
+	*net.TCPConn
+	buf []byte // The frames of a Send
+	in  *inbox
+}
+
+// inbox holds the packets a transport's goroutine received, for Read with a
+// deadline. read and setDeadline must be called from one goroutine.
+type inbox struct {
+	received chan []byte // Packets from the receiver
+	err      error       // Why received was closed
+	deadline time.Time   // Of read
+}
+
+func newInbox() *inbox { return &inbox{received: make(chan []byte, 16)} }
+
+// put hands a packet to read, dropping it when nobody reads fast enough, as
+// RTCP can be lost.
+func (in *inbox) put(packet []byte) {
+	select {
+	case in.received <- packet:
+	default:
+	}
+}
+
+// close makes read fail with err once the packets received are read.
+func (in *inbox) close(err error) {
+	in.err = err
+	close(in.received)
+}
+
+func (in *inbox) read(buf []byte) (int, error) {
+	var timeout <-chan time.Time
+	if !in.deadline.IsZero() {
+		timer := time.NewTimer(time.Until(in.deadline))
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	select {
+	case packet, ok := <-in.received:
+		if !ok {
+			return 0, in.err
+		}
+		return copy(buf, packet), nil
+	case <-timeout:
+		return 0, os.ErrDeadlineExceeded
+	}
+}
+
+func tcpDialer(network string) Dialer {
+	return func(ctx context.Context, destination string, log *slog.Logger) (Transport, error) {
+		if err := checkHostPort(destination); err != nil {
+			return nil, err
+		}
+		d := dialer(ctx, network)
+		conn, err := d.DialContext(ctx, network, destination)
+		if err != nil {
+			return nil, fmt.Errorf("failed to dial TCP: %w", err)
+		}
+		tc := conn.(*net.TCPConn)
+		tc.SetNoDelay(true)
+		t := &tcpTransport{TCPConn: tc, in: newInbox()}
+		go t.receive()
+		return t, nil
+	}
+}
+
+// checkHostPort checks that destination is a host:port, telling an IPv6
+// address given without the brackets it needs, such as 2001:db8::1:6001,
+// which can't be told from an address without a port.
+func checkHostPort(destination string) error {
+	if _, _, err := net.SplitHostPort(destination); err != nil {
+		if strings.Count(destination, ":") > 1 && !strings.HasPrefix(destination, "[") {
+			return fmt.Errorf("invalid destination %q: write an IPv6 address in brackets, e.g. [2001:db8::1]:6001", destination)
+		}
+		return fmt.Errorf("invalid destination %q: %w", destination, err)
+	}
+	return nil
+}
+
+func (t *tcpTransport) Send(packets [][]byte) (int, error) {
+	t.buf = t.buf[:0]
+	for _, p := range packets {
+		t.buf = binary.BigEndian.AppendUint16(t.buf, uint16(len(p)))
+		t.buf = append(t.buf, p...)
+	}
+	if _, err := t.TCPConn.Write(t.buf); err != nil {
+		return 0, err
+	}
+	return len(packets), nil
+}
+
+func (t *tcpTransport) Write(packet []byte) (int, error) {
+	if _, err := t.Send([][]byte{packet}); err != nil {
+		return 0, err
+	}
+	return len(packet), nil
+}
+
+// receive reads frames until the connection fails.
+func (t *tcpTransport) receive() {
+	r := bufio.NewReader(t.TCPConn)
+	var size [2]byte
+	for {
+		if _, err := io.ReadFull(r, size[:]); err != nil {
+			t.in.close(err)
+			return
+		}
+		packet := make([]byte, binary.BigEndian.Uint16(size[:]))
+		if _, err := io.ReadFull(r, packet); err != nil {
+			t.in.close(err)
+			return
+		}
+		t.in.put(packet)
+	}
+}
+
+func (t *tcpTransport) Read(buf []byte) (int, error) { return t.in.read(buf) }
+
+func (t *tcpTransport) SetReadDeadline(d time.Time) error {
+	t.in.deadline = d
+	return nil
+}
+
+// srtTransport sends every packet as a message of an SRT connection, on a
+// connected UDP socket, which its DSCP marking applies to.
+type srtTransport struct {
+	*srt.Conn
+	udp *net.UDPConn
+}
+
+func srtDialer(network string) Dialer {
+	return func(ctx context.Context, destination string, log *slog.Logger) (Transport, error) {
+		if err := checkHostPort(destination); err != nil {
+			return nil, err
+		}
+		d := dialer(ctx, network)
+		c, err := d.DialContext(ctx, network, destination)
+		if err != nil {
+			return nil, fmt.Errorf("failed to dial UDP: %w", err)
+		}
+		// The receiver gets a few seconds to answer, not the whole of ctx
+		hctx, cancel := context.WithTimeout(ctx, srtHandshakeTimeout)
+		defer cancel()
+		conn, err := srt.Client(hctx, c, srt.Config{})
+		if err != nil {
+			c.Close()
+			return nil, fmt.Errorf("failed to connect over SRT: %w", err)
+		}
+		return &srtTransport{Conn: conn, udp: c.(*net.UDPConn)}, nil
+	}
+}
+
+func (t *srtTransport) Send(packets [][]byte) (int, error) {
+	for i, p := range packets {
+		if _, err := t.Conn.Write(p); err != nil {
+			return i, err
+		}
+	}
+	return len(packets), nil
+}
+
+// MaxPacket is the largest SRT message, which the packets are split to fit.
+func (t *srtTransport) MaxPacket() int { return srt.MaxMessage }
+
+func (t *srtTransport) SyscallConn() (syscall.RawConn, error) { return t.udp.SyscallConn() }
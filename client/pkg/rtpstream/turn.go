@@ -0,0 +1,308 @@
+package rtpstream
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/fcerini/audio-capture-shared/pkg/stun"
+)
+
+// TURN (RFC 8656) relays the stream through a server on a reachable port:
+// the client allocates a relayed address there, binds a channel to the
+// receiver and sends its packets on the channel, with a 4-byte header, and
+// the relay sends them on from the relayed address. The receiver's answers
+// to that address come back on the channel.
+const (
+	turnAllocate       = 0x0003 // Request methods
+	turnRefresh        = 0x0004
+	turnBind           = 0x0009
+	turnDataIndication = 0x0017
+
+	attrChannelNumber      = 0x000c
+	attrLifetime           = 0x000d
+	attrXORPeerAddress     = 0x0012
+	attrData               = 0x0013
+	attrXORRelayedAddress  = 0x0016
+	attrRequestedFamily    = 0x0017
+	attrRequestedTransport = 0x0019
+
+	turnChannel  = 0x4000           // The channel of the receiver, the first one
+	turnLifetime = 600              // Seconds the allocation is asked to last
+	turnRenew    = 4 * time.Minute  // How often the allocation and the channel are refreshed; channels expire after 10 minutes, their permissions after 5
+	turnTimeout  = 10 * time.Second // For the answer to a request, resent meanwhile
+	directProbe  = 3 * time.Second  // How long the receiver has to answer STUN directly before the relay is used
+)
+
+// turnTransport sends over a channel of a TURN allocation.
+type turnTransport struct {
+	conn  *net.UDPConn // To the TURN server
+	peer  *net.UDPAddr // The receiver
+	buf   []byte       // A packet with its channel header
+	in    *inbox
+	done  chan struct{}
+	close sync.Once
+	log   *slog.Logger
+
+	username, password string
+
+	mu      sync.Mutex
+	realm   string // From the server's challenge
+	nonce   string
+	key     []byte                    // Of the long-term credentials, nil before the challenge
+	waiting map[stun.TxID]chan []byte // Responses to the requests in flight
+}
+
+// dialTURN relays a stream to destination through the TURN server of
+// cfg.TURN, authenticating with long-term credentials.
+func dialTURN(ctx context.Context, cfg Config, destination string, log *slog.Logger) (Transport, error) {
+	if err := checkHostPort(destination); err != nil {
+		return nil, err
+	}
+	// The relayed address is IPv4 unless IPv6 is asked for
+	peerNetwork := "udp4"
+	if cfg.Transport == "udp6" {
+		peerNetwork = "udp6"
+	}
+	peer, err := net.ResolveUDPAddr(peerNetwork, destination)
+	if err != nil {
+		return nil, err
+	}
+	d := dialer(ctx, cfg.Transport)
+	c, err := d.DialContext(ctx, cfg.Transport, cfg.TURN)
+	if err != nil {
+		return nil, fmt.Errorf("failed to dial the TURN server: %w", err)
+	}
+	t := &turnTransport{
+		conn: c.(*net.UDPConn), peer: peer, in: newInbox(), done: make(chan struct{}), log: log,
+		username: cfg.TURNUsername, password: cfg.TURNPassword, waiting: make(map[stun.TxID]chan []byte),
+	}
+	go t.receive()
+
+	attrs := []stun.Attr{
+		{Type: attrRequestedTransport, Value: []byte{17, 0, 0, 0}}, // UDP
+		{Type: attrLifetime, Value: binary.BigEndian.AppendUint32(nil, turnLifetime)},
+	}
+	if peerNetwork == "udp6" {
+		attrs = append(attrs, stun.Attr{Type: attrRequestedFamily, Value: []byte{2, 0, 0, 0}})
+	}
+	resp, err := t.request(ctx, turnAllocate, attrs)
+	if err != nil {
+		t.conn.Close()
+		return nil, fmt.Errorf("allocating a relayed address failed: %w", err)
+	}
+	relay, ok := stun.ParseXORAddr(stun.Attrs(resp)[attrXORRelayedAddress], stun.ID(resp))
+	if !ok {
+		t.conn.Close()
+		return nil, errors.New("the TURN server allocated no relayed address")
+	}
+	if err := t.bind(ctx); err != nil {
+		t.Close()
+		return nil, fmt.Errorf("binding a channel to the receiver failed: %w", err)
+	}
+	log.Info("🔁 Relaying the stream through TURN", "turn", cfg.TURN, "relayed_addr", relay)
+	go t.renew()
+	return t, nil
+}
+
+// bind binds the channel to the receiver, which also permits its packets.
+func (t *turnTransport) bind(ctx context.Context) error {
+	_, err := t.request(ctx, turnBind, []stun.Attr{
+		{Type: attrChannelNumber, Value: []byte{turnChannel >> 8, turnChannel & 0xff, 0, 0}},
+		{Type: attrXORPeerAddress}, // Filled in by request
+	})
+	return err
+}
+
+// renew refreshes the allocation and the channel until the transport is
+// closed.
+func (t *turnTransport) renew() {
+	ticker := time.NewTicker(turnRenew)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-t.done:
+			return
+		case <-ticker.C:
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), turnTimeout)
+		_, err := t.request(ctx, turnRefresh, []stun.Attr{{Type: attrLifetime, Value: binary.BigEndian.AppendUint32(nil, turnLifetime)}})
+		if err == nil {
+			err = t.bind(ctx)
+		}
+		cancel()
+		if err != nil {
+			t.log.Warn("Refreshing the TURN allocation failed", "err", err)
+		}
+	}
+}
+
+// request sends a request of method with attrs and returns the successful
+// response. The first request is challenged for credentials, which it is
+// sent again with, as is one whose nonce went stale. An XOR-PEER-ADDRESS
+// gets the receiver's address, XORed with the transaction ID.
+func (t *turnTransport) request(ctx context.Context, method uint16, attrs []stun.Attr) ([]byte, error) {
+	for try := 0; ; try++ {
+		id := stun.NewID()
+		t.mu.Lock()
+		key := t.key
+		all := append([]stun.Attr(nil), attrs...)
+		for i, a := range all {
+			if a.Type == attrXORPeerAddress {
+				all[i].Value = stun.XORAddr(t.peer.AddrPort(), id)
+			}
+		}
+		if key != nil {
+			all = append(all,
+				stun.Attr{Type: stun.AttrUsername, Value: []byte(t.username)},
+				stun.Attr{Type: stun.AttrRealm, Value: []byte(t.realm)},
+				stun.Attr{Type: stun.AttrNonce, Value: []byte(t.nonce)})
+		}
+		answer := make(chan []byte, 1)
+		t.waiting[id] = answer
+		t.mu.Unlock()
+
+		resp, err := stun.Transact(ctx, &turnAnswers{t: t, answer: answer}, stun.Build(method, id, all, key), id)
+		t.mu.Lock()
+		delete(t.waiting, id)
+		t.mu.Unlock()
+		if err != nil {
+			return nil, err
+		}
+		if stun.IsSuccess(resp) {
+			return resp, nil
+		}
+		code := stun.ErrorCode(resp)
+		if try > 1 || !(code == stun.CodeUnauthorized && key == nil || code == stun.CodeStaleNonce) {
+			return nil, stun.Error(resp)
+		}
+		got := stun.Attrs(resp)
+		t.mu.Lock()
+		if realm, ok := got[stun.AttrRealm]; ok {
+			t.realm = string(realm)
+		}
+		t.nonce = string(got[stun.AttrNonce])
+		t.key = stun.LongTermKey(t.username, t.realm, t.password)
+		t.mu.Unlock()
+	}
+}
+
+// turnAnswers is the stun.Conn of a request: it sends on the socket, and
+// reads the answer that receive hands it.
+type turnAnswers struct {
+	t        *turnTransport
+	answer   chan []byte
+	deadline time.Time
+}
+
+func (a *turnAnswers) Write(b []byte) (int, error) { return a.t.conn.Write(b) }
+
+func (a *turnAnswers) Read(b []byte) (int, error) {
+	timer := time.NewTimer(time.Until(a.deadline))
+	defer timer.Stop()
+	select {
+	case resp := <-a.answer:
+		return copy(b, resp), nil
+	case <-a.t.done:
+		return 0, net.ErrClosed
+	case <-timer.C:
+		return 0, os.ErrDeadlineExceeded
+	}
+}
+
+func (a *turnAnswers) SetReadDeadline(t time.Time) error {
+	a.deadline = t
+	return nil
+}
+
+// receive reads what the TURN server sends until the socket is closed:
+// the receiver's packets on the channel or in Data indications, and the
+// responses to requests.
+func (t *turnTransport) receive() {
+	buf := make([]byte, mtu+64)
+	for {
+		n, err := t.conn.Read(buf)
+		if err != nil {
+			if errors.Is(err, syscall.ECONNREFUSED) {
+				continue // The server was unreachable for a moment
+			}
+			t.in.close(err)
+			return
+		}
+		b := buf[:n]
+		switch {
+		case n >= 4 && binary.BigEndian.Uint16(b) == turnChannel:
+			if size := int(binary.BigEndian.Uint16(b[2:])); 4+size <= n {
+				t.in.put(append([]byte(nil), b[4:4+size]...))
+			}
+		case !stun.IsMessage(b):
+		case stun.Type(b) == turnDataIndication:
+			if data, ok := stun.Attrs(b)[attrData]; ok {
+				t.in.put(append([]byte(nil), data...))
+			}
+		default:
+			t.mu.Lock()
+			answer, ok := t.waiting[stun.ID(b)]
+			t.mu.Unlock()
+			if ok {
+				select {
+				case answer <- append([]byte(nil), b...):
+				default: // Answered twice
+				}
+			}
+		}
+	}
+}
+
+func (t *turnTransport) Send(packets [][]byte) (int, error) {
+	for i, p := range packets {
+		t.buf = binary.BigEndian.AppendUint16(t.buf[:0], turnChannel)
+		t.buf = binary.BigEndian.AppendUint16(t.buf, uint16(len(p)))
+		t.buf = append(t.buf, p...)
+		if _, err := t.conn.Write(t.buf); err != nil {
+			return i, err
+		}
+	}
+	return len(packets), nil
+}
+
+func (t *turnTransport) Write(packet []byte) (int, error) {
+	if _, err := t.Send([][]byte{packet}); err != nil {
+		return 0, err
+	}
+	return len(packet), nil
+}
+
+func (t *turnTransport) Read(buf []byte) (int, error) { return t.in.read(buf) }
+
+func (t *turnTransport) SetReadDeadline(d time.Time) error {
+	t.in.deadline = d
+	return nil
+}
+
+// SyscallConn is that of the socket to the TURN server.
+func (t *turnTransport) SyscallConn() (syscall.RawConn, error) { return t.conn.SyscallConn() }
+
+func (t *turnTransport) LocalAddr() net.Addr  { return t.conn.LocalAddr() }
+func (t *turnTransport) RemoteAddr() net.Addr { return t.peer }
+
+// Close releases the allocation, not waiting long for the answer, and
+// closes the socket.
+func (t *turnTransport) Close() error {
+	var err error
+	t.close.Do(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		t.request(ctx, turnRefresh, []stun.Attr{{Type: attrLifetime, Value: make([]byte, 4)}})
+		cancel()
+		close(t.done)
+		err = t.conn.Close()
+	})
+	return err
+}
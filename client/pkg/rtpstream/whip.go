@@ -0,0 +1,266 @@
+package rtpstream
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pion/interceptor"
+	"github.com/pion/webrtc/v4"
+)
+
+const (
+	whipGatherTime  = 5 * time.Second  // For the client's ICE candidates, sent all in the offer
+	whipConnectTime = 10 * time.Second // For the endpoint to connect after answering
+	whipMaxPacket   = 1200             // Of the RTP packets, leaving room for SRTP and a tunnel on the path
+)
+
+// whipTokenKey is the key of the context value dial gives the WHIP transport
+// the bearer token of its endpoint in, from Config.WHIPToken.
+type whipTokenKey struct{}
+
+// whipTransport publishes the stream to a WHIP endpoint (RFC 9725), such as
+// the server's /whip with -whip: the transport posts an offer of one
+// send-only Opus track and sends the packets on the peer connection the
+// answer sets up, over DTLS-SRTP between the ICE candidates of both ends.
+// The DTLS, SRTP, NACKs and sender reports of WebRTC are left to pion, so
+// Write drops the stream's own RTCP, and Read returns the endpoint's.
+type whipTransport struct {
+	pc      *webrtc.PeerConnection
+	track   *webrtc.TrackLocalStaticRTP
+	sender  *webrtc.RTPSender
+	session string // The session's URL, which DELETE ends it at
+	token   string
+	in      *inbox
+	once    sync.Once // Closes the session
+	log     *slog.Logger
+}
+
+// whipAddr is the address of each end of a WHIP transport, which isn't a
+// UDP one: the packets are encrypted, and a STUN keepalive couldn't be sent.
+type whipAddr string
+
+func (whipAddr) Network() string  { return "whip" }
+func (a whipAddr) String() string { return string(a) }
+
+// dialWHIP posts the offer to the endpoint at destination, an http or https
+// URL, and waits for the answer and for the peer connection to connect.
+func dialWHIP(ctx context.Context, destination string, log *slog.Logger) (Transport, error) {
+	if !isICEURL(destination) {
+		return nil, fmt.Errorf("invalid destination %q: WHIP takes the endpoint's URL, e.g. http://192.0.2.7:8080/whip", destination)
+	}
+	endpoint, err := url.Parse(destination)
+	if err != nil {
+		return nil, fmt.Errorf("invalid destination %q: %w", destination, err)
+	}
+	m := &webrtc.MediaEngine{}
+	if err := m.RegisterCodec(webrtc.RTPCodecParameters{
+		RTPCodecCapability: webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeOpus, ClockRate: opusRate, Channels: 2, SDPFmtpLine: "minptime=10;useinbandfec=1"},
+		PayloadType:        payloadTypeOpus,
+	}, webrtc.RTPCodecTypeAudio); err != nil {
+		return nil, err
+	}
+	// NACKs, sender reports and congestion feedback, as endpoints expect
+	ir := &interceptor.Registry{}
+	if err := webrtc.RegisterDefaultInterceptors(m, ir); err != nil {
+		return nil, err
+	}
+	pc, err := webrtc.NewAPI(webrtc.WithMediaEngine(m), webrtc.WithInterceptorRegistry(ir)).NewPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		return nil, err
+	}
+	t := &whipTransport{pc: pc, in: newInbox(), log: log}
+	t.token, _ = ctx.Value(whipTokenKey{}).(string)
+	if err := t.connect(ctx, endpoint); err != nil {
+		pc.Close()
+		return nil, err
+	}
+	go t.receive()
+	return t, nil
+}
+
+// connect negotiates the session with the endpoint.
+func (t *whipTransport) connect(ctx context.Context, endpoint *url.URL) error {
+	var err error
+	t.track, err = webrtc.NewTrackLocalStaticRTP(webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeOpus, ClockRate: opusRate, Channels: 2}, "audio", "audio-capture")
+	if err != nil {
+		return err
+	}
+	tr, err := t.pc.AddTransceiverFromTrack(t.track, webrtc.RTPTransceiverInit{Direction: webrtc.RTPTransceiverDirectionSendonly})
+	if err != nil {
+		return err
+	}
+	t.sender = tr.Sender()
+	connected := make(chan webrtc.PeerConnectionState, 1)
+	t.pc.OnConnectionStateChange(func(state webrtc.PeerConnectionState) {
+		switch state {
+		case webrtc.PeerConnectionStateConnected, webrtc.PeerConnectionStateFailed, webrtc.PeerConnectionStateClosed:
+			select {
+			case connected <- state:
+			default:
+			}
+		}
+	})
+
+	// Trickle ICE isn't used: the offer carries all of the candidates
+	offer, err := t.pc.CreateOffer(nil)
+	if err != nil {
+		return err
+	}
+	gathered := webrtc.GatheringCompletePromise(t.pc)
+	if err := t.pc.SetLocalDescription(offer); err != nil {
+		return err
+	}
+	select {
+	case <-gathered:
+	case <-time.After(whipGatherTime):
+		t.log.Warn("Gathering the ICE candidates timed out, offering those found")
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	answer, location, err := t.post(ctx, endpoint, t.pc.LocalDescription().SDP)
+	if err != nil {
+		return err
+	}
+	t.session = endpoint.ResolveReference(location).String()
+	if err := t.pc.SetRemoteDescription(webrtc.SessionDescription{Type: webrtc.SDPTypeAnswer, SDP: answer}); err != nil {
+		t.end()
+		return fmt.Errorf("invalid WHIP answer: %w", err)
+	}
+	select {
+	case state := <-connected:
+		if state != webrtc.PeerConnectionStateConnected {
+			t.end()
+			return fmt.Errorf("the WHIP connection %s", state)
+		}
+	case <-time.After(whipConnectTime):
+		t.end()
+		return errors.New("the WHIP endpoint didn't connect")
+	case <-ctx.Done():
+		t.end()
+		return ctx.Err()
+	}
+	if pair, err := t.sender.Transport().ICETransport().GetSelectedCandidatePair(); err == nil && pair != nil {
+		t.log.Info("🌐 Publishing over WHIP", "session_url", t.session, "local", pair.Local.Address, "remote", pair.Remote.Address)
+	}
+	return nil
+}
+
+// post sends the offer and returns the answer and the session's URL,
+// relative to the endpoint's.
+func (t *whipTransport) post(ctx context.Context, endpoint *url.URL, offer string) (string, *url.URL, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint.String(), strings.NewReader(offer))
+	if err != nil {
+		return "", nil, err
+	}
+	req.Header.Set("Content-Type", "application/sdp")
+	t.authorize(req)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", nil, fmt.Errorf("posting the WHIP offer failed: %w", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if err != nil {
+		return "", nil, fmt.Errorf("reading the WHIP answer failed: %w", err)
+	}
+	switch {
+	case resp.StatusCode == http.StatusUnauthorized:
+		return "", nil, errors.New("posting the WHIP offer failed: set WHIP_TOKEN to the server's API_TOKEN")
+	case resp.StatusCode != http.StatusCreated:
+		return "", nil, fmt.Errorf("posting the WHIP offer failed: %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	location, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil || location.String() == "" {
+		return "", nil, errors.New("the WHIP answer has no session URL in Location")
+	}
+	return string(body), location, nil
+}
+
+func (t *whipTransport) authorize(req *http.Request) {
+	if t.token != "" {
+		req.Header.Set("Authorization", "Bearer "+t.token)
+	}
+}
+
+// end asks the endpoint to end the session, and closes the peer connection.
+func (t *whipTransport) end() {
+	t.once.Do(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		if req, err := http.NewRequestWithContext(ctx, http.MethodDelete, t.session, nil); err == nil {
+			t.authorize(req)
+			if resp, err := http.DefaultClient.Do(req); err != nil {
+				t.log.Warn("Ending the WHIP session failed", "session_url", t.session, "err", err)
+			} else {
+				resp.Body.Close()
+			}
+		}
+		t.pc.Close()
+	})
+}
+
+// receive reads the endpoint's RTCP until the peer connection closes.
+func (t *whipTransport) receive() {
+	buf := make([]byte, mtu)
+	for {
+		n, _, err := t.sender.Read(buf)
+		if err != nil {
+			t.in.close(net.ErrClosed)
+			return
+		}
+		t.in.put(append([]byte(nil), buf[:n]...))
+	}
+}
+
+// Send writes the packets to the track, which gives them the SSRC and
+// payload type negotiated.
+func (t *whipTransport) Send(packets [][]byte) (int, error) {
+	for i, p := range packets {
+		if _, err := t.track.Write(p); err != nil {
+			return i, err
+		}
+	}
+	return len(packets), nil
+}
+
+// Write drops the packet: pion sends the RTCP of the peer connection, and
+// the endpoint takes no other.
+func (t *whipTransport) Write(packet []byte) (int, error) { return len(packet), nil }
+
+func (t *whipTransport) Read(buf []byte) (int, error) { return t.in.read(buf) }
+
+func (t *whipTransport) SetReadDeadline(d time.Time) error {
+	t.in.deadline = d
+	return nil
+}
+
+func (t *whipTransport) LocalAddr() net.Addr  { return whipAddr("whip") }
+func (t *whipTransport) RemoteAddr() net.Addr { return whipAddr(t.session) }
+
+// Close ends the session.
+func (t *whipTransport) Close() error {
+	t.end()
+	return nil
+}
+
+// MaxPacket keeps the packets clear of the SRTP overhead.
+func (t *whipTransport) MaxPacket() int { return whipMaxPacket }
+
+// SSRC is the SSRC pion sends the track with, which the endpoint's reports
+// are about.
+func (t *whipTransport) SSRC() uint32 {
+	if encodings := t.sender.GetParameters().Encodings; len(encodings) > 0 {
+		return uint32(encodings[0].SSRC)
+	}
+	return 0
+}
@@ -0,0 +1,282 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"math/rand"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/fcerini/audio-capture-client/pkg/capture"
+	"github.com/fcerini/audio-capture-client/pkg/rtpstream"
+)
+
+// runBench sends many synthetic RTP streams of fake audio to a receiver at
+// once, over a network as lossy and jittery as asked, for testing how many
+// streams a server keeps up with:
+//
+//	audio-capture bench -streams=200 -target=10.0.0.7:6001 -loss=1 -jitter=15ms
+func runBench(_ globals, args []string) int {
+	fs := flag.NewFlagSet("bench", flag.ContinueOnError)
+	streams := fs.Int("streams", 10, "concurrent streams to send")
+	target := fs.String("target", "", "host:port of the receiver (required)")
+	duration := fs.Duration("duration", 0, "stop after this long (0 = on SIGINT or SIGTERM)")
+	rampUp := fs.Duration("ramp-up", 0, "spread the start of the streams over this long")
+	loss := fs.Float64("loss", 0, "drop this percentage of the packets, at random")
+	jitter := fs.Duration("jitter", 0, "delay every packet by up to this long, at random, which reorders packets when longer than 20 ms")
+	rate := fs.Int("rate", 48000, "sample rate of the streams in Hz")
+	channels := fs.Int("channels", 1, "channels of the streams")
+	encoding := fs.String("encoding", "l16", "RTP payload encoding of the streams")
+	rtcpInterval := fs.Duration("rtcp-interval", 5*time.Second, "send RTCP sender reports on every stream this often (0 = never)")
+	reportInterval := fs.Duration("report-interval", 5*time.Second, "how often to log what was sent")
+	vars := fs.String("vars", "", "the server's /debug/vars URL, e.g. http://127.0.0.1:6060/debug/vars, to report at the end what it received, lost and dropped (default: only report what was sent)")
+	maxLoss := fs.Float64("max-loss", -1, "with -vars, fail when the server lost more than this percentage of the packets not dropped on purpose, e.g. 1 (negative = never)")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: audio-capture bench -target host:port [-streams 10] [flags]\n\nEvery stream sends its own ramp of fake audio, see the client's -source=fake.\n\n")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return 0
+		}
+		return 2
+	}
+	switch {
+	case *target == "":
+		fs.Usage()
+		fmt.Fprintf(os.Stderr, "❌ -target is required\n")
+		return 2
+	case *streams < 1:
+		fmt.Fprintf(os.Stderr, "❌ -streams must be at least 1\n")
+		return 2
+	case *loss < 0 || *loss > 100:
+		fmt.Fprintf(os.Stderr, "❌ -loss must be a percentage\n")
+		return 2
+	case *reportInterval <= 0:
+		fmt.Fprintf(os.Stderr, "❌ -report-interval must be positive\n")
+		return 2
+	case *maxLoss >= 0 && *vars == "":
+		fmt.Fprintf(os.Stderr, "❌ -max-loss needs -vars\n")
+		return 2
+	}
+	var before serverVars
+	if *vars != "" {
+		var err error
+		if before, err = readVars(*vars); err != nil {
+			fmt.Fprintf(os.Stderr, "❌ Reading the server's counters failed: %v\n", err)
+			return 1
+		}
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	if *duration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *duration)
+		defer cancel()
+	}
+
+	// The streams go through a transport that impairs udp as asked
+	var lost atomic.Int64
+	rtpstream.RegisterTransport("bench", func(ctx context.Context, destination string, log *slog.Logger) (rtpstream.Transport, error) {
+		conn, err := rtpstream.DialTransport(ctx, "udp", destination, log)
+		if err != nil {
+			return nil, err
+		}
+		return &impairedTransport{Transport: conn, loss: *loss / 100, jitter: *jitter, lost: &lost}, nil
+	})
+
+	log := slog.With("component", "bench")
+	// The streams only log what goes wrong
+	streamLog := slog.New(minLevel{slog.Default().Handler(), slog.LevelWarn}).With("component", "bench")
+	log.Info("🏋️ Starting streams", "streams", *streams, "target", *target, "loss_percent", *loss, "jitter", *jitter)
+	var (
+		wg      sync.WaitGroup
+		stats   = make([]*rtpstream.Stats, *streams)
+		running atomic.Int64
+		failed  atomic.Int64
+	)
+	for i := range *streams {
+		stats[i] = rtpstream.NewStats(100, time.Hour) // Impaired on purpose: no alerts
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if *rampUp > 0 {
+				select {
+				case <-time.After(*rampUp * time.Duration(i) / time.Duration(*streams)):
+				case <-ctx.Done():
+					return
+				}
+			}
+			if err := benchStream(ctx, *target, *rate, *channels, *encoding, *rtcpInterval, stats[i], &running, streamLog.With("stream", i)); err != nil {
+				failed.Add(1)
+				streamLog.Error("Starting a stream failed", "stream", i, "err", err)
+			}
+		}()
+	}
+
+	totals := func() (sent, bytes int64) {
+		for _, st := range stats {
+			c := st.Counters()
+			sent, bytes = sent+c.PacketsSent, bytes+c.BytesSent
+		}
+		return sent, bytes
+	}
+	start := time.Now()
+	ticker := time.NewTicker(*reportInterval)
+	defer ticker.Stop()
+	var lastSent, lastBytes int64
+	last := start
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	for waiting := true; waiting; {
+		select {
+		case <-done:
+			waiting = false
+		case now := <-ticker.C:
+			sent, bytes := totals()
+			secs := now.Sub(last).Seconds()
+			log.Info("📊 Sending", "streams", running.Load(), "packets_per_sec", fmt.Sprintf("%.0f", float64(sent-lastSent)/secs),
+				"kbit_per_sec", fmt.Sprintf("%.0f", float64(bytes-lastBytes)*8/1000/secs), "dropped", lost.Load())
+			lastSent, lastBytes, last = sent, bytes, now
+		}
+	}
+
+	sent, bytes := totals()
+	log.Info("✅ Bench complete", "streams", *streams, "failed", failed.Load(), "seconds", fmt.Sprintf("%.1f", time.Since(start).Seconds()),
+		"packets", sent, "bytes", bytes, "dropped", lost.Load())
+	status := 0
+	if failed.Load() > 0 {
+		status = 1
+	}
+	if *vars != "" {
+		// Let the server drain its socket and queues
+		time.Sleep(time.Second)
+		after, err := readVars(*vars)
+		if err != nil {
+			log.Error("Reading the server's counters failed", "err", err)
+			return 1
+		}
+		received := after.PacketsReceived - before.PacketsReceived
+		expected := sent - lost.Load()
+		lossPercent := 100 * (1 - float64(received)/float64(max(1, expected)))
+		result := []any{"packets_expected", expected, "packets_received", received, "loss_percent", fmt.Sprintf("%.2f", lossPercent),
+			"dropped_kernel", after.PacketsDroppedKernel - before.PacketsDroppedKernel, "buffers_dropped", after.BuffersDropped - before.BuffersDropped}
+		if *maxLoss >= 0 && lossPercent > *maxLoss {
+			log.Error("❌ The server lost more than -max-loss", append(result, "max_loss_percent", *maxLoss)...)
+			return 1
+		}
+		log.Info("📊 Server received", result...)
+	}
+	return status
+}
+
+// serverVars are the counters read from the server's /debug/vars.
+type serverVars struct {
+	PacketsReceived      int64 `json:"packets_received"`
+	BuffersDropped       int64 `json:"buffers_dropped"`
+	PacketsDroppedKernel int64 `json:"packets_dropped_kernel"`
+}
+
+func readVars(url string) (serverVars, error) {
+	var v serverVars
+	resp, err := http.Get(url)
+	if err != nil {
+		return v, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return v, fmt.Errorf("%s: %s", url, resp.Status)
+	}
+	return v, json.NewDecoder(resp.Body).Decode(&v)
+}
+
+// benchStream sends a ramp of fake audio to target until ctx is done,
+// counted in running while it streams.
+func benchStream(ctx context.Context, target string, rate, channels int, encoding string, rtcpInterval time.Duration, stats *rtpstream.Stats, running *atomic.Int64, log *slog.Logger) error {
+	src, err := capture.Open(ctx, "fake", capture.Options{Input: "ramp", SampleRate: rate, Channels: channels, Log: log})
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	stream, err := rtpstream.Dial(ctx, rtpstream.Config{
+		Destination:  target,
+		SampleRate:   rate,
+		Channels:     channels,
+		Encoding:     encoding,
+		Transport:    "bench",
+		RTCPInterval: rtcpInterval,
+		Stats:        stats,
+		Log:          log,
+	})
+	if err != nil {
+		return err
+	}
+	stream.Start(src.Audio())
+	running.Add(1)
+	<-stream.Done()
+	running.Add(-1)
+	return nil
+}
+
+// impairedTransport drops and delays the packets of a transport, like a bad
+// network. The packets it drops count as sent in the stream's Stats, and in
+// lost.
+type impairedTransport struct {
+	rtpstream.Transport
+	loss   float64 // Of the packets, 0 to 1
+	jitter time.Duration
+	lost   *atomic.Int64
+}
+
+func (t *impairedTransport) Send(packets [][]byte) (int, error) {
+	if t.loss == 0 && t.jitter == 0 {
+		return t.Transport.Send(packets)
+	}
+	for i, p := range packets {
+		switch {
+		case t.loss > 0 && rand.Float64() < t.loss:
+			t.lost.Add(1)
+		case t.jitter > 0:
+			data := append([]byte(nil), p...)
+			time.AfterFunc(time.Duration(rand.Int63n(int64(t.jitter))), func() {
+				t.Transport.Write(data) // Fails once the stream closed the connection
+			})
+		default:
+			if _, err := t.Transport.Write(p); err != nil && !errors.Is(err, os.ErrDeadlineExceeded) {
+				return i, err
+			}
+		}
+	}
+	return len(packets), nil
+}
+
+// minLevel is a handler that drops records below level.
+type minLevel struct {
+	slog.Handler
+	level slog.Level
+}
+
+func (h minLevel) Enabled(ctx context.Context, l slog.Level) bool {
+	return l >= h.level && h.Handler.Enabled(ctx, l)
+}
+
+func (h minLevel) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return minLevel{h.Handler.WithAttrs(attrs), h.level}
+}
+
+func (h minLevel) WithGroup(name string) slog.Handler {
+	return minLevel{h.Handler.WithGroup(name), h.level}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/fcerini/audio-capture-client/pkg/client"
+	"github.com/fcerini/audio-capture-server/pkg/recorder"
+	"github.com/fcerini/audio-capture-shared/pkg/config"
+)
+
+// runConfig prints the options capture or serve would run with, merged
+// from their flags, the environment and the config file, and where each
+// came from:
+//
+//	audio-capture config print-effective serve -config server.conf
+func runConfig(g globals, args []string) int {
+	if len(args) < 2 || args[0] != "print-effective" {
+		fmt.Fprintf(os.Stderr, "Usage: audio-capture config print-effective <capture|serve> [flags] [args]\n")
+		return 2
+	}
+	var (
+		settings []config.Setting
+		err      error
+	)
+	switch command, rest := args[1], args[2:]; command {
+	case "capture":
+		var cfg *client.Config
+		if cfg, err = client.ParseConfig(logFlags(g, rest)); err == nil {
+			settings = cfg.Settings()
+		}
+	case "serve":
+		var cfg *recorder.Config
+		if cfg, err = recorder.ParseConfig(logFlags(g, rest)); err == nil {
+			settings = cfg.Settings()
+		}
+	default:
+		fmt.Fprintf(os.Stderr, "❌ config print-effective is for capture or serve, not %q\n", command)
+		return 2
+	}
+	if err != nil {
+		if err == flag.ErrHelp {
+			return 0
+		}
+		fmt.Fprintf(os.Stderr, "❌ %v\n", err)
+		return 2
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "OPTION\tVALUE\tSOURCE")
+	for _, s := range settings {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", s.Name, s.Value, s.Source)
+	}
+	w.Flush()
+	return 0
+}
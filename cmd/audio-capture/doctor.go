@@ -0,0 +1,91 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// recommendedRmemMax is the net.core.rmem_max the server's -rcvbuf needs to
+// absorb bursts of many streams, see its README.
+const recommendedRmemMax = 16 << 20
+
+// runDoctor checks the host for the programs, services and settings
+// capturing and recording rely on, and exits with 1 when one is missing that
+// the defaults need.
+func runDoctor(_ globals, args []string) int {
+	fs := flag.NewFlagSet("doctor", flag.ContinueOnError)
+	port := fs.Int("port", 6001, "UDP port the server is going to listen on")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: audio-capture doctor [-port 6001]\n")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return 0
+		}
+		return 2
+	}
+
+	failed := false
+	check := func(ok bool, required bool, good, bad string) {
+		switch {
+		case ok:
+			fmt.Printf("✅ %s\n", good)
+		case required:
+			fmt.Printf("❌ %s\n", bad)
+			failed = true
+		default:
+			fmt.Printf("⚠️ %s\n", bad)
+		}
+	}
+
+	// Programs: the browser source needs pactl, parec and firefox
+	for _, tool := range []struct {
+		name, use string
+		required  bool
+	}{
+		{"pactl", "the browser source", true},
+		{"parec", "the browser and pulse sources", true},
+		{"firefox", "the browser source", true},
+		{"arecord", "the alsa source", false},
+		{"ffmpeg", "Opus and FLAC output and -normalize on the server", false},
+	} {
+		path, err := exec.LookPath(tool.name)
+		check(err == nil, tool.required, fmt.Sprintf("%s found at %s", tool.name, path),
+			fmt.Sprintf("%s not found in PATH, needed for %s", tool.name, tool.use))
+	}
+
+	// The sound server
+	out, err := exec.Command("pactl", "info").Output()
+	server := ""
+	for _, line := range strings.Split(string(out), "\n") {
+		if name, ok := strings.CutPrefix(line, "Server Name: "); ok {
+			server = name
+		}
+	}
+	check(err == nil, true, fmt.Sprintf("PulseAudio is running (%s)", server), "PulseAudio isn't running or pactl can't reach it")
+
+	// The receive buffer the kernel allows the server
+	if data, err := os.ReadFile("/proc/sys/net/core/rmem_max"); err == nil {
+		rmem, _ := strconv.Atoi(strings.TrimSpace(string(data)))
+		check(rmem >= recommendedRmemMax, false, fmt.Sprintf("net.core.rmem_max is %d bytes", rmem),
+			fmt.Sprintf("net.core.rmem_max is %d bytes, which caps -rcvbuf; raise it to %d for many streams: sysctl -w net.core.rmem_max=%d", rmem, recommendedRmemMax, recommendedRmemMax))
+	}
+
+	// The server's port
+	conn, err := net.ListenPacket("udp", fmt.Sprintf(":%d", *port))
+	if err == nil {
+		conn.Close()
+	}
+	check(err == nil, false, fmt.Sprintf("UDP port %d is free", *port), fmt.Sprintf("UDP port %d can't be used: %v", *port, err))
+
+	if failed {
+		return 1
+	}
+	return 0
+}
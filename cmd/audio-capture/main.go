@@ -0,0 +1,173 @@
+// Command audio-capture is the client and the server in one tool, with
+// subcommands for capturing, recording and the chores around them. The
+// global flags before the subcommand set up logging and metrics the same
+// way for all of them:
+//
+//	audio-capture [-log-level info] [-log-format text] [-metrics-addr addr] <command> [flags] [args]
+//
+// Every option can come from AUDIO_CAPTURE_* environment variables as well,
+// and those of capture and serve from their -config file; see package
+// config of the server.
+package main
+
+import (
+	"context"
+	"expvar"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/pprof"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/fcerini/audio-capture-client/pkg/client"
+	"github.com/fcerini/audio-capture-server/pkg/recorder"
+	"github.com/fcerini/audio-capture-shared/pkg/config"
+)
+
+// globals are the flags before the subcommand.
+type globals struct {
+	logLevel  slog.Level
+	logFormat string
+	given     []string // The log flags given as flags, passed on to the subcommands that take them
+}
+
+// A command is a subcommand of the tool. run gets the arguments after the
+// command's name and returns the exit code.
+type command struct {
+	name, summary string
+	run           func(g globals, args []string) int
+}
+
+var commands = []command{
+	{"capture", "capture audio and stream it as RTP, like audio-capture-client", runCapture},
+	{"serve", "record RTP streams, like audio-capture-server", runServe},
+	{"probe", "list the devices the pulse and alsa sources can record", runProbe},
+	{"replay", "send the packets of an rtpdump archive again", func(_ globals, args []string) int { return recorder.Replay(args) }},
+	{"convert", "decode an rtpdump archive into a recording", func(_ globals, args []string) int { return recorder.Convert(args) }},
+	{"repair", "fix the headers of WAV files left by a crash", func(_ globals, args []string) int { return recorder.RepairCommand(args) }},
+	{"verify", "check recordings against their checksums", func(_ globals, args []string) int { return recorder.Verify(args) }},
+	{"bench", "send many synthetic RTP streams to a server, to test its capacity", runBench},
+	{"selftest", "stream a tone to a recorder in this process and check it arrives intact", runSelftest},
+	{"doctor", "check the host for what capturing and recording need", runDoctor},
+	{"config", "show the options capture or serve would run with, and where each came from", runConfig},
+}
+
+func main() {
+	var g globals
+	fs := flag.NewFlagSet("audio-capture", flag.ContinueOnError)
+	fs.TextVar(&g.logLevel, "log-level", slog.LevelInfo, "least severe messages logged: debug, info, warn or error")
+	fs.StringVar(&g.logFormat, "log-format", "text", "log format: text (readable lines) or json (one JSON object per line)")
+	metricsAddr := fs.String("metrics-addr", "", "serve the expvar counters on /debug/vars and pprof profiles on this address, e.g. 127.0.0.1:6060 (default: disabled)")
+	fs.Usage = func() {
+		out := fs.Output()
+		fmt.Fprintf(out, "Usage: audio-capture [flags] <command> [args]\n\nCommands:\n")
+		for _, c := range commands {
+			fmt.Fprintf(out, "  %-8s %s\n", c.name, c.summary)
+		}
+		fmt.Fprintf(out, "\nRun 'audio-capture <command> -h' for the flags of a command.\n\nFlags:\n")
+		fs.PrintDefaults()
+	}
+	settings, err := config.Parse(fs, os.Args[1:])
+	if err != nil {
+		if err == flag.ErrHelp {
+			os.Exit(0)
+		}
+		fmt.Fprintf(os.Stderr, "❌ %v\n", err)
+		os.Exit(2)
+	}
+	for _, s := range settings {
+		if s.Source == "flag" && (s.Name == "log-level" || s.Name == "log-format") {
+			g.given = append(g.given, "-"+s.Name+"="+s.Value)
+		}
+	}
+	if g.logFormat != "text" && g.logFormat != "json" {
+		fmt.Fprintf(os.Stderr, "❌ unknown log format %q (use text or json)\n", g.logFormat)
+		os.Exit(2)
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	name := fs.Arg(0)
+	for _, c := range commands {
+		if c.name == name {
+			// The usage of the recorder's subcommands starts with os.Args[0]
+			os.Args[0] = "audio-capture"
+			// All subcommands log through the recorder's handler
+			recorder.SetLogging(g.logLevel, g.logFormat)
+			if *metricsAddr != "" {
+				go serveMetrics(*metricsAddr)
+			}
+			os.Exit(c.run(g, fs.Args()[1:]))
+		}
+	}
+	fmt.Fprintf(os.Stderr, "❌ unknown command %q\n\n", name)
+	fs.Usage()
+	os.Exit(2)
+}
+
+// logFlags passes the global log flags given on the command line on to the
+// flags of a subcommand, where flags of its own given later win. Those from
+// the environment reach the subcommand through its own.
+func logFlags(g globals, args []string) []string {
+	return append(append([]string{}, g.given...), args...)
+}
+
+func runCapture(g globals, args []string) int {
+	cfg, err := client.ParseConfig(logFlags(g, args))
+	if err != nil {
+		if err == flag.ErrHelp {
+			return 0
+		}
+		fmt.Fprintf(os.Stderr, "❌ %v\n", err)
+		return 2
+	}
+	recorder.SetLogging(cfg.LogLevel, cfg.LogFormat)
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	if err := client.Run(ctx, cfg); err != nil {
+		slog.Error("Can't stream", "component", "main", "err", err)
+		return 1
+	}
+	slog.Info("✅ Cleanup complete, exiting", "component", "main")
+	return 0
+}
+
+func runServe(g globals, args []string) int {
+	cfg, err := recorder.ParseConfig(logFlags(g, args))
+	if err != nil {
+		if err == flag.ErrHelp {
+			return 0
+		}
+		fmt.Fprintf(os.Stderr, "❌ %v\n", err)
+		return 2
+	}
+	recorder.SetupLogging(cfg)
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	if err := recorder.ListenAndRecord(ctx, cfg); err != nil {
+		slog.Error("Can't start", "component", "main", "err", err)
+		return 2
+	}
+	return 0
+}
+
+// serveMetrics serves the expvar counters of the client or the server, and
+// the pprof profiles, whichever subcommand runs.
+func serveMetrics(addr string) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.Handle("/debug/vars", expvar.Handler())
+	log := slog.With("component", "metrics")
+	log.Info("🩺 Serving metrics", "url", "http://"+addr+"/debug/vars")
+	if err := http.ListenAndServe(addr, mux); err != nil {
+		log.Error("Metrics server failed", "err", err)
+	}
+}
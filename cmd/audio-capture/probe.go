@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/fcerini/audio-capture-client/pkg/capture"
+)
+
+// runProbe lists the devices the capture subcommand can record with
+// -source=pulse or -source=alsa, with their formats and the input to give it
+// for each.
+func runProbe(_ globals, args []string) int {
+	fs := flag.NewFlagSet("probe", flag.ContinueOnError)
+	kinds := fs.String("kind", "", "only list these kinds, comma-separated: source, monitor, sink or capture (default: all)")
+	asJSON := fs.Bool("json", false, "print the devices as a JSON array, for scripts")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: audio-capture probe [-kind source,monitor] [-json]\n")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return 0
+		}
+		return 2
+	}
+	devices, err := capture.Devices()
+	if err != nil {
+		fmt.Printf("❌ %v\n", err)
+		return 1
+	}
+	if *kinds != "" {
+		wanted := strings.Split(*kinds, ",")
+		devices = slices.DeleteFunc(devices, func(d capture.Device) bool { return !slices.Contains(wanted, d.Kind) })
+	}
+	if *asJSON {
+		type device struct {
+			Source      string `json:"source"`
+			Input       string `json:"input"`
+			Kind        string `json:"kind"`
+			Description string `json:"description"`
+			Format      string `json:"format,omitempty"`
+			State       string `json:"state,omitempty"`
+		}
+		out := make([]device, 0, len(devices))
+		for _, d := range devices {
+			out = append(out, device{d.Source, d.Name, d.Kind, d.Description, d.Format, d.State})
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(out)
+		return 0
+	}
+	if server, err := capture.SoundServer(); err == nil {
+		fmt.Printf("🔊 %s\n", server)
+	}
+	if len(devices) == 0 {
+		fmt.Println("⚠️ No devices to record found")
+		return 0
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "SOURCE\tINPUT\tKIND\tFORMAT\tSTATE\tDESCRIPTION")
+	for _, d := range devices {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", d.Source, d.Name, d.Kind, dash(d.Format), dash(d.State), d.Description)
+	}
+	w.Flush()
+	return 0
+}
+
+// dash stands in for an empty column.
+func dash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/fcerini/audio-capture-client/pkg/capture"
+	"github.com/fcerini/audio-capture-client/pkg/rtpstream"
+	"github.com/fcerini/audio-capture-server/pkg/recorder"
+)
+
+// runSelftest records, in this process, a known tone streamed to it over
+// localhost, and checks the recorder decoded the very samples that were
+// sent: capturing, packetizing, sending, receiving and decoding all in one.
+// It exits with 1 when the audio doesn't match.
+func runSelftest(g globals, args []string) int {
+	fs := flag.NewFlagSet("selftest", flag.ContinueOnError)
+	duration := fs.Duration("duration", 2*time.Second, "how long a tone to stream")
+	rate := fs.Int("rate", 48000, "sample rate of the tone in Hz")
+	channels := fs.Int("channels", 2, "channels of the tone")
+	verbose := fs.Bool("v", false, "log what the client and the server do, as capture and serve do")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: audio-capture selftest [-duration 2s] [flags]\n")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return 0
+		}
+		return 2
+	}
+	if *duration <= 0 || *rate <= 0 || *channels < 1 {
+		fmt.Fprintf(os.Stderr, "❌ -duration, -rate and -channels must be positive\n")
+		return 2
+	}
+	if !*verbose {
+		recorder.SetLogging(slog.LevelWarn, g.logFormat)
+	}
+
+	sent, received, err := loopback(*duration, *rate, *channels)
+	if err != nil {
+		fmt.Printf("❌ %v\n", err)
+		return 1
+	}
+	frames := len(sent) / *channels
+	fmt.Printf("✅ Streamed %d frames of a 1 kHz tone to the recorder over localhost\n", frames)
+	if len(received) != len(sent) {
+		fmt.Printf("❌ The recorder decoded %d frames of the %d sent\n", len(received) / *channels, frames)
+		return 1
+	}
+	for i := range sent {
+		if received[i] != sent[i] {
+			fmt.Printf("❌ The recorder decoded %d instead of %d at frame %d, channel %d\n", received[i], sent[i], i / *channels, i%*channels)
+			return 1
+		}
+	}
+	fmt.Printf("✅ The recorder decoded every sample as sent\n")
+	return 0
+}
+
+// loopback streams the fake sine source for duration to a recorder started
+// on a free port, and returns the samples the source generated and those
+// the recorder decoded.
+func loopback(duration time.Duration, rate, channels int) (sent, received []int, err error) {
+	port, err := freeUDPPort()
+	if err != nil {
+		return nil, nil, err
+	}
+	dir, err := os.MkdirTemp("", "audio-capture-selftest-")
+	if err != nil {
+		return nil, nil, err
+	}
+	defer os.RemoveAll(dir)
+	cfg, err := recorder.NewConfig(recorder.WithPort(port), recorder.WithOutDir(dir),
+		recorder.WithStreamFormat(rate, 16, channels))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var mu sync.Mutex
+	r := &recorder.Recorder{
+		Config: cfg,
+		OnAudioFrame: func(_ recorder.Stream, f recorder.AudioFrame) {
+			mu.Lock()
+			defer mu.Unlock()
+			if f.Offset == int64(len(received)/channels) {
+				received = append(received, f.Samples...)
+			}
+		},
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	serverErr := make(chan error, 1)
+	go func() { serverErr <- r.ListenAndRecord(ctx) }()
+	// Give the recorder time to listen
+	time.Sleep(200 * time.Millisecond)
+
+	log := slog.With("component", "selftest")
+	input := "sine:" + duration.String()
+	src, err := capture.Open(ctx, "fake", capture.Options{Input: input, SampleRate: rate, Channels: channels, Log: log})
+	if err != nil {
+		return nil, nil, err
+	}
+	defer src.Close()
+	// The same pattern again is what the source sends
+	ref, err := capture.Open(ctx, "fake", capture.Options{Input: input, SampleRate: rate, Channels: channels, Log: slog.New(minLevel{log.Handler(), slog.LevelWarn})})
+	if err != nil {
+		return nil, nil, err
+	}
+	defer ref.Close()
+	refDone := make(chan error, 1)
+	go func() {
+		pcm, err := io.ReadAll(ref.Audio())
+		for i := 0; i+1 < len(pcm); i += 2 {
+			sent = append(sent, int(int16(binary.BigEndian.Uint16(pcm[i:]))))
+		}
+		refDone <- err
+	}()
+
+	stream, err := rtpstream.Dial(ctx, rtpstream.Config{
+		Destination: net.JoinHostPort("127.0.0.1", strconv.Itoa(port)),
+		SampleRate:  rate,
+		Channels:    channels,
+		Log:         log,
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	stream.Start(src.Audio())
+	select {
+	case <-stream.Done():
+	case err := <-serverErr:
+		return nil, nil, fmt.Errorf("the recorder failed: %w", err)
+	}
+	if err := <-refDone; err != nil {
+		return nil, nil, err
+	}
+	// Let the last packets arrive, then finalize the recording, which hands
+	// the hook all the audio
+	time.Sleep(200 * time.Millisecond)
+	cancel()
+	if err := <-serverErr; err != nil {
+		return nil, nil, fmt.Errorf("the recorder failed: %w", err)
+	}
+	if len(received) == 0 {
+		return nil, nil, errors.New("the recorder received no audio")
+	}
+	return sent, received, nil
+}
+
+// freeUDPPort returns a UDP port nothing listens on right now.
+func freeUDPPort() (int, error) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+	return conn.LocalAddr().(*net.UDPAddr).Port, nil
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fcerini/audio-capture-client/pkg/capture"
+	"github.com/fcerini/audio-capture-client/pkg/client"
+	"github.com/fcerini/audio-capture-client/pkg/rtpstream"
+	"github.com/fcerini/audio-capture-server/pkg/recorder"
+)
+
+// rampFrames is how many frames the sessions stream: a second of the fake
+// source's ramp, 48 kHz mono, as both ends default to.
+const rampFrames = 48000
+
+// startRecorder runs a server on a free port of localhost, recording with
+// -template tmpl into a temporary directory, and returns its port and the
+// directory. Stopping it, and finalizing its recordings, is the returned
+// function.
+func startRecorder(t *testing.T, tmpl string) (port int, dir string, stop func()) {
+	t.Helper()
+	port, err := freeUDPPort()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir = t.TempDir()
+	cfg, err := recorder.NewConfig(recorder.WithListen("127.0.0.1"), recorder.WithPort(port), recorder.WithOutDir(dir), recorder.WithTemplate(tmpl))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	serverErr := make(chan error, 1)
+	go func() { serverErr <- recorder.ListenAndRecord(ctx, cfg) }()
+	// Give the recorder time to listen
+	time.Sleep(200 * time.Millisecond)
+
+	var once sync.Once
+	stop = func() {
+		once.Do(func() {
+			// Let the last packets arrive first
+			time.Sleep(200 * time.Millisecond)
+			cancel()
+			if err := <-serverErr; err != nil {
+				t.Errorf("the recorder failed: %v", err)
+			}
+		})
+	}
+	t.Cleanup(stop)
+	return port, dir, stop
+}
+
+// checkRamp fails unless the WAV file at path is 16-bit 48 kHz mono holding
+// the fake source's ramp, frame n being n, for rampFrames frames.
+func checkRamp(t *testing.T, path string) {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) < 12 || string(data[:4]) != "RIFF" || string(data[8:12]) != "WAVE" {
+		t.Fatalf("%s isn't a WAV file", path)
+	}
+	if size := binary.LittleEndian.Uint32(data[4:]); int(size) != len(data)-8 {
+		t.Errorf("the RIFF chunk is %d bytes, the file %d", size, len(data))
+	}
+	var pcm []byte
+	for chunks := data[12:]; len(chunks) >= 8; {
+		id, size := string(chunks[:4]), int(binary.LittleEndian.Uint32(chunks[4:]))
+		if size > len(chunks)-8 {
+			t.Fatalf("the %q chunk is %d bytes, %d are left", id, size, len(chunks)-8)
+		}
+		body := chunks[8 : 8+size]
+		switch id {
+		case "fmt ":
+			format, channels := binary.LittleEndian.Uint16(body), binary.LittleEndian.Uint16(body[2:])
+			rate, bits := binary.LittleEndian.Uint32(body[4:]), binary.LittleEndian.Uint16(body[14:])
+			if format != 1 || channels != 1 || rate != 48000 || bits != 16 {
+				t.Errorf("the format is %d, %d channels at %d Hz, %d bits; want PCM, mono at 48000 Hz, 16 bits", format, channels, rate, bits)
+			}
+		case "data":
+			pcm = body
+		}
+		chunks = chunks[8+size+size%2:]
+	}
+	if len(pcm) != rampFrames*2 {
+		t.Fatalf("recorded %d frames, want %d", len(pcm)/2, rampFrames)
+	}
+	for i := range rampFrames {
+		if got := binary.LittleEndian.Uint16(pcm[2*i:]); got != uint16(i) {
+			t.Fatalf("frame %d is %d, want %d", i, got, uint16(i))
+		}
+	}
+}
+
+// recordings lists the names of the WAV files in dir, without the sidecars
+// and manifest written along.
+func recordings(t *testing.T, dir string) []string {
+	t.Helper()
+	paths, err := filepath.Glob(filepath.Join(dir, "*.wav"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, p := range paths {
+		names = append(names, filepath.Base(p))
+	}
+	return names
+}
+
+// streamRamp streams the fake source's ramp from n clients at once, each
+// from a port of its own, to the recorder on port, until the audio ends.
+func streamRamp(t *testing.T, port, n int) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	log := slog.With("component", "test")
+	var wg sync.WaitGroup
+	for range n {
+		src, err := capture.Open(ctx, "fake", capture.Options{Input: "ramp:1s", SampleRate: 48000, Channels: 1, Log: log})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer src.Close()
+		stream, err := rtpstream.Dial(ctx, rtpstream.Config{
+			Destination: net.JoinHostPort("127.0.0.1", strconv.Itoa(port)),
+			SampleRate:  48000,
+			Channels:    1,
+			Log:         log,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		stream.Start(src.Audio())
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-stream.Done()
+		}()
+	}
+	wg.Wait()
+	if ctx.Err() != nil {
+		t.Fatal("the streams didn't stop when the audio ended")
+	}
+}
+
+// TestSession streams the fake source's ramp to a server recording to the
+// template {ip}_{port}, and checks the WAV file it wrote. The template has
+// no extension of its own, so the dots of the IP must not be taken for one:
+// the file is 127.0.0.1_<port>.wav.
+func TestSession(t *testing.T) {
+	port, dir, stop := startRecorder(t, "{ip}_{port}")
+	streamRamp(t, port, 1)
+	stop()
+
+	names := recordings(t, dir)
+	if len(names) != 1 || !regexp.MustCompile(`^127\.0\.0\.1_[0-9]+\.wav$`).MatchString(names[0]) {
+		t.Fatalf("recorded %q, want one 127.0.0.1_<port>.wav", names)
+	}
+	checkRamp(t, filepath.Join(dir, names[0]))
+}
+
+// TestSessionsSharingName streams the ramp from two clients at once to a
+// server whose template names both recordings 127.0.0.1.wav. Neither may
+// replace the other: the second is 127.0.0.1-1.wav.
+func TestSessionsSharingName(t *testing.T) {
+	port, dir, stop := startRecorder(t, "{ip}.wav")
+	streamRamp(t, port, 2)
+	stop()
+
+	names := recordings(t, dir)
+	if len(names) != 2 || names[0] != "127.0.0.1-1.wav" || names[1] != "127.0.0.1.wav" {
+		t.Fatalf("recorded %q, want 127.0.0.1.wav and 127.0.0.1-1.wav", names)
+	}
+	for _, name := range names {
+		checkRamp(t, filepath.Join(dir, name))
+	}
+}
+
+// TestClientRun runs the client twice in a row, as the capture subcommand
+// does once, next to a recorder in the same process, the second time naming
+// the source with -backend: both publish their variables, and both sessions
+// are recorded.
+func TestClientRun(t *testing.T) {
+	port, dir, stop := startRecorder(t, "{session}.wav")
+	for _, flag := range []string{"-source", "-backend"} {
+		cfg, err := client.ParseConfig([]string{flag, "fake", "-report-interval", "0",
+			"ramp:1s", net.JoinHostPort("127.0.0.1", strconv.Itoa(port))})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		err = client.Run(ctx, cfg)
+		timedOut := ctx.Err() != nil
+		cancel()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if timedOut {
+			t.Fatal("the client didn't stop when the audio ended")
+		}
+	}
+	stop()
+
+	names := recordings(t, dir)
+	if len(names) != 2 {
+		t.Fatalf("recorded %q, want two sessions", names)
+	}
+	for _, name := range names {
+		checkRamp(t, filepath.Join(dir, name))
+	}
+}
@@ -0,0 +1,131 @@
+package recorder
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// accessWarnings bounds how many senders are remembered for logging a
+// rejection once; a scan from many addresses then logs again now and then,
+// instead of growing the set forever.
+const accessWarnings = 10000
+
+// cidrList is the -allow-cidr flag: the networks streams are accepted from.
+// Bare IPs are single addresses.
+type cidrList []netip.Prefix
+
+func (l *cidrList) String() string {
+	if l == nil {
+		return ""
+	}
+	var parts []string
+	for _, p := range *l {
+		parts = append(parts, p.String())
+	}
+	return strings.Join(parts, ",")
+}
+
+func (l *cidrList) Set(s string) error {
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		p, err := netip.ParsePrefix(part)
+		if err != nil {
+			ip, ipErr := netip.ParseAddr(part)
+			if ipErr != nil {
+				return fmt.Errorf("invalid network %q (use e.g. 10.0.0.0/8 or 192.0.2.7)", part)
+			}
+			p = netip.PrefixFrom(ip, ip.BitLen())
+		}
+		*l = append(*l, p.Masked())
+	}
+	return nil
+}
+
+// allows reports whether ip is in one of the networks; an empty list allows
+// everything.
+func (l cidrList) allows(ip netip.Addr) bool {
+	if len(l) == 0 {
+		return true
+	}
+	ip = ip.Unmap()
+	for _, p := range l {
+		if p.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// accessControl drops packets from senders outside -allow-cidr and new
+// streams beyond -max-clients and -max-clients-per-ip, so a scanner can't
+// fill the disk with junk recordings.
+type accessControl struct {
+	cfg    *Config
+	denied atomic.Int64 // Packets dropped
+
+	mu     sync.Mutex
+	warned map[string]bool // Senders a rejection was logged for
+}
+
+func newAccessControl(cfg *Config) *accessControl {
+	return &accessControl{cfg: cfg, warned: make(map[string]bool)}
+}
+
+// allowed reports whether packets from addr are accepted by -allow-cidr.
+func (a *accessControl) allowed(addr netip.Addr) bool {
+	if a.cfg.allowCIDR.allows(addr) {
+		return true
+	}
+	a.deny(addr.String(), "not in -allow-cidr")
+	return false
+}
+
+// limit returns why a new stream from addr is refused under the client
+// limits, or "" if it may start; clients holds the active ones. The caller
+// holds the server's clientsMutex.
+func (a *accessControl) limit(addr string, clients *clientTable) string {
+	if a.cfg.maxClients > 0 && clients.len() >= a.cfg.maxClients {
+		return fmt.Sprintf("-max-clients %d reached", a.cfg.maxClients)
+	}
+	if a.cfg.maxPerIP > 0 {
+		host, _, _ := net.SplitHostPort(addr)
+		n := 0
+		clients.each(func(other string, _ *Client) bool {
+			if h, _, _ := net.SplitHostPort(other); h == host {
+				n++
+			}
+			return true
+		})
+		if n >= a.cfg.maxPerIP {
+			return fmt.Sprintf("-max-clients-per-ip %d reached for %s", a.cfg.maxPerIP, host)
+		}
+	}
+	return ""
+}
+
+// deny counts a dropped packet, logging the first from each sender.
+func (a *accessControl) deny(sender, reason string) {
+	a.denied.Add(1)
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.warned[sender] {
+		return
+	}
+	if len(a.warned) >= accessWarnings {
+		a.warned = make(map[string]bool)
+	}
+	a.warned[sender] = true
+	ingestLog.Warn("🚫 Dropping packets", "sender", sender, "reason", reason)
+}
+
+// accepted forgets a sender that was refused before, so that a later
+// rejection is logged again.
+func (a *accessControl) accepted(sender string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	delete(a.warned, sender)
+}
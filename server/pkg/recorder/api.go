@@ -0,0 +1,164 @@
+package recorder
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// apiSession is an active session as returned by the REST API.
+type apiSession struct {
+	clientStats
+	SSRC     uint32  `json:"ssrc"`
+	Duration float64 `json:"duration_seconds"` // Since the session started
+}
+
+// handleAPI serves the REST API for managing sessions and recordings. Every
+// request needs the token from API_TOKEN, checked by requireToken:
+//
+//	GET    /api/sessions              active sessions
+//	GET    /api/sessions/<id>         one session
+//	POST   /api/sessions/<id>/stop    finalize a session
+//	GET    /api/recordings            recordings on disk, like GET /files
+//	GET    /api/recordings/<name>     download a recording or companion file
+//	DELETE /api/recordings/<name>     delete a recording and its companions
+func (s *server) handleAPI(w http.ResponseWriter, r *http.Request) {
+	if apiToken() == "" {
+		http.Error(w, "API disabled: start the server with API_TOKEN set", http.StatusForbidden)
+		return
+	}
+
+	resource, rest, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/api/"), "/")
+	switch resource {
+	case "sessions":
+		s.apiSessions(w, r, rest)
+	case "recordings":
+		s.apiRecordings(w, r, rest)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (s *server) apiSessions(w http.ResponseWriter, r *http.Request, rest string) {
+	id, action, _ := strings.Cut(rest, "/")
+	switch {
+	case id == "" && r.Method == http.MethodGet:
+		writeJSON(w, http.StatusOK, s.sessions(""))
+	case id != "" && action == "" && r.Method == http.MethodGet:
+		list := s.sessions(id)
+		if len(list) == 0 {
+			http.Error(w, "no such session", http.StatusNotFound)
+			return
+		}
+		writeJSON(w, http.StatusOK, list[0])
+	case id != "" && action == "stop" && r.Method == http.MethodPost:
+		st, ok := s.stopSession(id)
+		if !ok {
+			http.Error(w, "no such session", http.StatusNotFound)
+			return
+		}
+		writeJSON(w, http.StatusOK, st)
+	case action != "" && action != "stop":
+		http.NotFound(w, r)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// sessions returns the active sessions, or only the one with the given ID.
+func (s *server) sessions(id string) []apiSession {
+	st := s.stats()
+	list := []apiSession{}
+	for _, cs := range st.Clients {
+		c, _ := s.clients.get(cs.Addr)
+		if c == nil || (id != "" && c.session != id) {
+			continue
+		}
+		list = append(list, apiSession{clientStats: cs, SSRC: c.ssrc, Duration: time.Since(c.start).Seconds()})
+	}
+	return list
+}
+
+// stopSession finalizes the recording of a session. If the stream keeps
+// sending, its next packet starts a new session.
+func (s *server) stopSession(id string) (apiSession, bool) {
+	list := s.sessions(id)
+	if len(list) == 0 {
+		return apiSession{}, false
+	}
+	s.clientsMutex.Lock()
+	c, _ := s.clients.get(list[0].Addr)
+	if c == nil || c.session != id {
+		s.clientsMutex.Unlock()
+		return apiSession{}, false // Ended meanwhile
+	}
+	s.clients.delete(c.addr)
+	s.clientsMutex.Unlock()
+
+	c.log.Info("⏹️  Stopping session on request")
+	c.close()
+	return list[0], true
+}
+
+func (s *server) apiRecordings(w http.ResponseWriter, r *http.Request, name string) {
+	switch {
+	case (r.Method == http.MethodGet || r.Method == http.MethodHead) && name == "":
+		s.listFiles(w, r, "/api/recordings/")
+	case r.Method == http.MethodGet || r.Method == http.MethodHead:
+		s.serveFile(w, r, name)
+	case r.Method == http.MethodDelete && name != "":
+		p, ok := s.outDirPath(name)
+		if !ok || !isRecordingFile(p) {
+			http.NotFound(w, r)
+			return
+		}
+		if s.activeFiles()[p] {
+			http.Error(w, "recording is still being written; stop its session first", http.StatusConflict)
+			return
+		}
+		if err := s.deleteRecording(p); err != nil {
+			if os.IsNotExist(err) {
+				http.NotFound(w, r)
+				return
+			}
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// deleteRecording removes a finished recording and its companion files.
+func (s *server) deleteRecording(path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil {
+		return err
+	}
+	freed := info.Size()
+	for _, companion := range companionFiles(path) {
+		if info, err := os.Stat(companion); err == nil && os.Remove(companion) == nil {
+			freed += info.Size()
+		}
+	}
+	s.disk.add(-freed)
+	removeEmptyDirs(filepath.Dir(path), s.cfg.outDir)
+	s.cat.fileRemoved(path)
+	httpLog.Info("🗑️  Deleted recording on request", "file", path, "bytes", freed)
+	return nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		httpLog.Error("Encoding response failed", "err", err)
+	}
+}
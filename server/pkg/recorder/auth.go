@@ -0,0 +1,133 @@
+package recorder
+
+import (
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// tokenCookie carries the API token for browsers, set by opening the
+// dashboard as /?token=<token>.
+const tokenCookie = "audio_capture_token"
+
+// apiToken returns the token every HTTP and gRPC request must present, or ""
+// if API_TOKEN is not set.
+func apiToken() string {
+	return os.Getenv("API_TOKEN")
+}
+
+// validToken compares a presented token with API_TOKEN in constant time.
+func validToken(got string) bool {
+	token := apiToken()
+	return token != "" && subtle.ConstantTimeCompare([]byte(got), []byte(token)) == 1
+}
+
+// requireToken guards all HTTP endpoints but the probes with API_TOKEN, when
+// it is set. A request presents it as a bearer token or in the cookie;
+// GET /?token= sets the cookie, so the dashboard and its WebSocket work in a
+// browser. The CORS preflights of WHIP, which browsers send without it, pass.
+func requireToken(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		preflight := r.Method == http.MethodOptions && (r.URL.Path == whipPath || strings.HasPrefix(r.URL.Path, whipPath+"/"))
+		if apiToken() == "" || r.URL.Path == healthPath || r.URL.Path == readyPath || preflight {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if t := r.URL.Query().Get("token"); t != "" && r.URL.Path == "/" && validToken(t) {
+			http.SetCookie(w, &http.Cookie{
+				Name:     tokenCookie,
+				Value:    t,
+				Path:     "/",
+				HttpOnly: true,
+				Secure:   r.TLS != nil,
+				SameSite: http.SameSiteStrictMode,
+			})
+			http.Redirect(w, r, "/", http.StatusSeeOther) // Keep the token out of the address bar
+			return
+		}
+		if got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok && validToken(got) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if c, err := r.Cookie(tokenCookie); err == nil && validToken(c.Value) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Set("WWW-Authenticate", `Bearer realm="audio-capture"`)
+		http.Error(w, "unauthorized: send the API token as a bearer token, or open /?token=<token>", http.StatusUnauthorized)
+	})
+}
+
+// newTLSConfig sets up TLS for the HTTP and gRPC servers from -tls-cert and
+// -tls-key, requiring client certificates signed by -tls-client-ca if set.
+// The certificate is reloaded when its file changes, so it can be renewed
+// without a restart. It returns nil without -tls-cert.
+func newTLSConfig(cfg *Config) (*tls.Config, error) {
+	if cfg.tlsCert == "" {
+		return nil, nil
+	}
+	kp := &keyPair{certFile: cfg.tlsCert, keyFile: cfg.tlsKey}
+	if _, err := kp.get(); err != nil {
+		return nil, err
+	}
+	tc := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) { return kp.get() },
+	}
+	if cfg.tlsClientCA != "" {
+		pem, err := os.ReadFile(cfg.tlsClientCA)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read -tls-client-ca: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates in -tls-client-ca %s", cfg.tlsClientCA)
+		}
+		tc.ClientCAs = pool
+		tc.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return tc, nil
+}
+
+// keyPair is a certificate loaded from files, reloaded when they change.
+type keyPair struct {
+	certFile, keyFile string
+
+	mu       sync.Mutex
+	cert     *tls.Certificate
+	modified time.Time // Of the newer file when loaded
+}
+
+func (k *keyPair) get() (*tls.Certificate, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	var modified time.Time
+	for _, f := range []string{k.certFile, k.keyFile} {
+		if info, err := os.Stat(f); err == nil && info.ModTime().After(modified) {
+			modified = info.ModTime()
+		}
+	}
+	if k.cert != nil && !modified.After(k.modified) {
+		return k.cert, nil
+	}
+	cert, err := tls.LoadX509KeyPair(k.certFile, k.keyFile)
+	if err != nil {
+		if k.cert != nil {
+			// Probably caught halfway through a renewal; try again next time
+			httpLog.Warn("Reloading the TLS certificate failed, keeping the old one", "err", err)
+			return k.cert, nil
+		}
+		return nil, fmt.Errorf("failed to load the TLS certificate: %w", err)
+	}
+	if k.cert != nil {
+		httpLog.Info("🔐 Reloaded the TLS certificate", "file", k.certFile)
+	}
+	k.cert, k.modified = &cert, modified
+	return k.cert, nil
+}
@@ -0,0 +1,418 @@
+package recorder
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	_ "modernc.org/sqlite" // Pure Go driver, no cgo needed
+)
+
+var catalogLog = logger("catalog")
+
+// catalogTime is how times are stored in the catalog: fixed width UTC, so
+// the text sorts and compares chronologically.
+const catalogTime = "2006-01-02T15:04:05.000Z"
+
+const catalogSchema = `
+CREATE TABLE IF NOT EXISTS sessions (
+	id      TEXT PRIMARY KEY,
+	addr    TEXT NOT NULL,
+	ssrc    INTEGER NOT NULL,
+	start   TEXT NOT NULL,
+	end     TEXT,
+	dropped INTEGER NOT NULL DEFAULT 0
+);
+CREATE TABLE IF NOT EXISTS files (
+	path        TEXT PRIMARY KEY,
+	session     TEXT NOT NULL REFERENCES sessions(id),
+	part        INTEGER NOT NULL,
+	addr        TEXT NOT NULL,
+	start       TEXT NOT NULL,
+	end         TEXT NOT NULL,
+	duration    REAL NOT NULL,
+	bytes       INTEGER NOT NULL,
+	format      TEXT NOT NULL,
+	codec       TEXT NOT NULL,
+	sample_rate INTEGER NOT NULL,
+	bit_depth   INTEGER NOT NULL,
+	channels    INTEGER NOT NULL
+);
+CREATE INDEX IF NOT EXISTS files_start ON files(start);
+CREATE INDEX IF NOT EXISTS files_session ON files(session);
+CREATE INDEX IF NOT EXISTS sessions_start ON sessions(start);
+`
+
+// catalogColumns are columns added to the files table after its first
+// version, created on existing catalogs when they are opened.
+var catalogColumns = []struct{ name, def string }{
+	{"fingerprint", "BLOB"}, // Raw Chromaprint fingerprint, see encodeFingerprint
+	{"acoustid", "TEXT"},
+	{"duplicate_of", "TEXT"},
+}
+
+// catalog is a SQLite index of every recording session and file, kept up to
+// date as sessions start and files are finalized or deleted. A nil catalog
+// is valid and records nothing, so callers don't need to check whether
+// -catalog is set.
+type catalog struct {
+	db *sql.DB
+}
+
+// openCatalog opens or creates the catalog database at path.
+func openCatalog(path string) (*catalog, error) {
+	db, err := sql.Open("sqlite", path+"?_pragma=journal_mode(WAL)&_pragma=busy_timeout(5000)")
+	if err != nil {
+		return nil, err
+	}
+	// SQLite allows a single writer; one connection avoids lock errors
+	db.SetMaxOpenConns(1)
+	if _, err := db.Exec(catalogSchema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create catalog schema in %s: %w", path, err)
+	}
+	if err := migrateCatalog(db); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to update catalog schema in %s: %w", path, err)
+	}
+	return &catalog{db: db}, nil
+}
+
+// migrateCatalog adds the catalogColumns missing from the files table.
+func migrateCatalog(db *sql.DB) error {
+	rows, err := db.Query(`SELECT name FROM pragma_table_info('files')`)
+	if err != nil {
+		return err
+	}
+	have := map[string]bool{}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			rows.Close()
+			return err
+		}
+		have[name] = true
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for _, col := range catalogColumns {
+		if !have[col.name] {
+			if _, err := db.Exec(`ALTER TABLE files ADD COLUMN ` + col.name + ` ` + col.def); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (c *catalog) close() error {
+	if c == nil {
+		return nil
+	}
+	return c.db.Close()
+}
+
+// exec runs a write, logging failures: a catalog problem must never stop a
+// recording.
+func (c *catalog) exec(query string, args ...any) {
+	if c == nil {
+		return
+	}
+	if _, err := c.db.Exec(query, args...); err != nil {
+		catalogLog.Warn("Catalog update failed", "err", err)
+	}
+}
+
+// sessionStarted records a new session.
+func (c *catalog) sessionStarted(cl *Client) {
+	c.exec(`INSERT OR REPLACE INTO sessions (id, addr, ssrc, start) VALUES (?, ?, ?, ?)`,
+		cl.session, cl.addr, cl.ssrc, cl.start.UTC().Format(catalogTime))
+}
+
+// sessionEnded records the end of a session and its final statistics.
+func (c *catalog) sessionEnded(cl *Client) {
+	c.exec(`UPDATE sessions SET end = ?, dropped = ? WHERE id = ?`,
+		time.Now().UTC().Format(catalogTime), cl.dropped.Load(), cl.session)
+}
+
+// fileFinished records a finalized file.
+func (c *catalog) fileFinished(ff finishedFile) {
+	var raw []byte
+	var acoustid, duplicateOf sql.NullString
+	if f := ff.Fingerprint; f != nil {
+		raw = encodeFingerprint(f.raw)
+		if f.AcoustID != nil {
+			acoustid = sql.NullString{String: f.AcoustID.ID, Valid: true}
+		}
+		duplicateOf = sql.NullString{String: f.DuplicateOf, Valid: f.DuplicateOf != ""}
+	}
+	c.exec(`INSERT OR REPLACE INTO files
+		(path, session, part, addr, start, end, duration, bytes, format, codec, sample_rate, bit_depth, channels,
+		fingerprint, acoustid, duplicate_of)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		ff.Path, ff.Session, ff.Part, ff.Addr, ff.Start.UTC().Format(catalogTime), ff.End.UTC().Format(catalogTime),
+		ff.Duration, ff.Bytes, ff.Format, ff.Codec, ff.SampleRate, ff.BitDepth, ff.Channels,
+		raw, acoustid, duplicateOf)
+}
+
+// duplicateOf returns the earliest other recording in the catalog with the
+// same audio as a fingerprint: the same AcoustID track, or a fingerprint at
+// least fingerprintMatch similar over a similar duration. It returns "" if
+// there is none.
+func (c *catalog) duplicateOf(path string, f *fingerprint) string {
+	if c == nil {
+		return ""
+	}
+	acoustid := ""
+	if f.AcoustID != nil {
+		acoustid = f.AcoustID.ID
+	}
+	// fpcalc only looks at the first two minutes, so long files are
+	// compared by those
+	rows, err := c.db.Query(`SELECT path, fingerprint, acoustid FROM files
+		WHERE path != ? AND fingerprint IS NOT NULL AND (acoustid = ? OR duration BETWEEN ? AND ?)
+		ORDER BY start`, path, acoustid, f.Duration*0.9-2, f.Duration*1.1+2)
+	if err != nil {
+		catalogLog.Warn("Catalog query failed", "err", err)
+		return ""
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var other string
+		var raw []byte
+		var id sql.NullString
+		if err := rows.Scan(&other, &raw, &id); err != nil {
+			catalogLog.Warn("Catalog query failed", "err", err)
+			return ""
+		}
+		if (acoustid != "" && id.String == acoustid) || fingerprintSimilarity(f.raw, decodeFingerprint(raw)) >= fingerprintMatch {
+			return other
+		}
+	}
+	return ""
+}
+
+// fileRemoved drops a file that was deleted from disk.
+func (c *catalog) fileRemoved(path string) {
+	c.exec(`DELETE FROM files WHERE path = ?`, path)
+}
+
+// catalogFile is a row of the files table as returned by the API.
+type catalogFile struct {
+	Path        string  `json:"file"`
+	Session     string  `json:"session"`
+	Part        int     `json:"part"`
+	Addr        string  `json:"addr"`
+	Start       string  `json:"start"`
+	End         string  `json:"end"`
+	Duration    float64 `json:"duration_seconds"`
+	Bytes       int64   `json:"bytes"`
+	Format      string  `json:"format"`
+	Codec       string  `json:"codec"`
+	SampleRate  int     `json:"sample_rate"`
+	BitDepth    int     `json:"bit_depth"`
+	Channels    int     `json:"channels"`
+	AcoustID    *string `json:"acoustid,omitempty"`
+	DuplicateOf *string `json:"duplicate_of,omitempty"`
+}
+
+// catalogSession is a row of the sessions table with totals over its files.
+type catalogSession struct {
+	ID       string  `json:"session"`
+	Addr     string  `json:"addr"`
+	SSRC     uint32  `json:"ssrc"`
+	Start    string  `json:"start"`
+	End      *string `json:"end"` // null while the session is live
+	Dropped  int64   `json:"dropped"`
+	Files    int     `json:"files"`
+	Duration float64 `json:"duration_seconds"`
+	Bytes    int64   `json:"bytes"`
+}
+
+// catalogFilter is built from the query string of the catalog endpoints:
+//
+//	from, to      start time range, as RFC 3339 or a date (2006-01-02)
+//	addr, session exact match
+//	min_duration, max_duration  in seconds or as a duration such as 10m
+//	duplicates    false to leave out recordings that duplicate an earlier one
+//	limit         maximum number of rows (default 100)
+type catalogFilter struct {
+	where  []string
+	args   []any
+	having []string // Conditions on session totals
+	hargs  []any
+	limit  int
+}
+
+func parseCatalogFilter(q map[string][]string, table string) (*catalogFilter, error) {
+	f := &catalogFilter{limit: 100}
+	get := func(name string) string {
+		if v := q[name]; len(v) > 0 {
+			return v[0]
+		}
+		return ""
+	}
+
+	for _, p := range []struct{ param, op string }{{"from", ">="}, {"to", "<"}} {
+		v := get(p.param)
+		if v == "" {
+			continue
+		}
+		t, err := parseCatalogTime(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", p.param, err)
+		}
+		f.where = append(f.where, table+".start "+p.op+" ?")
+		f.args = append(f.args, t.UTC().Format(catalogTime))
+	}
+	if v := get("addr"); v != "" {
+		f.where = append(f.where, table+".addr = ?")
+		f.args = append(f.args, v)
+	}
+	if v := get("session"); v != "" {
+		col := "session"
+		if table == "sessions" {
+			col = "id"
+		}
+		f.where = append(f.where, table+"."+col+" = ?")
+		f.args = append(f.args, v)
+	}
+	for _, p := range []struct{ param, op string }{{"min_duration", ">="}, {"max_duration", "<="}} {
+		v := get(p.param)
+		if v == "" {
+			continue
+		}
+		secs, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			d, derr := time.ParseDuration(v)
+			if derr != nil {
+				return nil, fmt.Errorf("invalid %s %q", p.param, v)
+			}
+			secs = d.Seconds()
+		}
+		// For sessions, the duration is the total over all their files
+		if table == "files" {
+			f.where = append(f.where, "files.duration "+p.op+" ?")
+			f.args = append(f.args, secs)
+		} else {
+			f.having = append(f.having, "COALESCE(SUM(files.duration), 0) "+p.op+" ?")
+			f.hargs = append(f.hargs, secs)
+		}
+	}
+	if v := get("duplicates"); v != "" && table == "files" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid duplicates %q", v)
+		}
+		if !b {
+			f.where = append(f.where, "files.duplicate_of IS NULL")
+		}
+	}
+	if v := get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("invalid limit %q", v)
+		}
+		f.limit = n
+	}
+	return f, nil
+}
+
+// parseCatalogTime accepts RFC 3339 timestamps and plain dates, which are
+// taken as local midnight.
+func parseCatalogTime(s string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	return time.ParseInLocation("2006-01-02", s, time.Local)
+}
+
+func clause(keyword string, conds []string) string {
+	if len(conds) == 0 {
+		return ""
+	}
+	return " " + keyword + " " + strings.Join(conds, " AND ")
+}
+
+func (c *catalog) files(f *catalogFilter) ([]catalogFile, error) {
+	rows, err := c.db.Query(`SELECT path, session, part, addr, start, end, duration, bytes, format, codec, sample_rate, bit_depth, channels,
+		acoustid, duplicate_of
+		FROM files`+clause("WHERE", f.where)+` ORDER BY start DESC LIMIT ?`, append(f.args, f.limit)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []catalogFile{}
+	for rows.Next() {
+		var r catalogFile
+		if err := rows.Scan(&r.Path, &r.Session, &r.Part, &r.Addr, &r.Start, &r.End, &r.Duration, &r.Bytes,
+			&r.Format, &r.Codec, &r.SampleRate, &r.BitDepth, &r.Channels, &r.AcoustID, &r.DuplicateOf); err != nil {
+			return nil, err
+		}
+		out = append(out, r)
+	}
+	return out, rows.Err()
+}
+
+func (c *catalog) sessions(f *catalogFilter) ([]catalogSession, error) {
+	query := `SELECT sessions.id, sessions.addr, sessions.ssrc, sessions.start, sessions.end, sessions.dropped,
+		COUNT(files.path), COALESCE(SUM(files.duration), 0), COALESCE(SUM(files.bytes), 0)
+		FROM sessions LEFT JOIN files ON files.session = sessions.id` +
+		clause("WHERE", f.where) + " GROUP BY sessions.id" + clause("HAVING", f.having) +
+		" ORDER BY sessions.start DESC LIMIT ?"
+	args := append(append(f.args, f.hargs...), f.limit)
+	rows, err := c.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []catalogSession{}
+	for rows.Next() {
+		var r catalogSession
+		var end sql.NullString
+		if err := rows.Scan(&r.ID, &r.Addr, &r.SSRC, &r.Start, &end, &r.Dropped, &r.Files, &r.Duration, &r.Bytes); err != nil {
+			return nil, err
+		}
+		if end.Valid {
+			r.End = &end.String
+		}
+		out = append(out, r)
+	}
+	return out, rows.Err()
+}
+
+// handleCatalog registers the catalog query endpoints, GET /recordings and
+// GET /sessions, on mux.
+func (c *catalog) handleCatalog(mux *http.ServeMux) {
+	serve := func(table string, query func(*catalogFilter) (any, error)) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodGet {
+				http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+				return
+			}
+			f, err := parseCatalogFilter(r.URL.Query(), table)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			rows, err := query(f)
+			if err != nil {
+				catalogLog.Error("Querying catalog failed", "err", err)
+				http.Error(w, "catalog query failed", http.StatusInternalServerError)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			if err := json.NewEncoder(w).Encode(rows); err != nil {
+				catalogLog.Error("Encoding catalog results failed", "err", err)
+			}
+		}
+	}
+	mux.HandleFunc("/recordings", serve("files", func(f *catalogFilter) (any, error) { return c.files(f) }))
+	mux.HandleFunc("/sessions", serve("sessions", func(f *catalogFilter) (any, error) { return c.sessions(f) }))
+}
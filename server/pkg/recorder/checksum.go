@@ -0,0 +1,134 @@
+package recorder
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// manifestName is the file under the output directory that lists the SHA-256
+// of every finalized recording, in the format of sha256sum, so the archive can
+// also be checked with "sha256sum -c manifest.sha256".
+const manifestName = "manifest.sha256"
+
+// fileSHA256 returns the hex SHA-256 of the file at path.
+func fileSHA256(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// manifest appends checksums to the rolling manifest of an output directory.
+type manifest struct {
+	root string
+	mu   sync.Mutex // Serializes appends from concurrent finalizations
+}
+
+// add appends the checksum of one file, recorded by its path relative to the
+// output directory.
+func (m *manifest) add(path, sum string) error {
+	rel, err := filepath.Rel(m.root, path)
+	if err != nil {
+		rel = path
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	f, err := os.OpenFile(filepath.Join(m.root, manifestName), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(f, "%s  %s\n", sum, filepath.ToSlash(rel)); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Verify implements the "verify" subcommand, which re-checks every file in
+// the manifest of an output directory against its recorded checksum.
+func Verify(args []string) int {
+	fs := flag.NewFlagSet("verify", flag.ContinueOnError)
+	outDir := fs.String("out-dir", ".", "output directory of the recordings to verify")
+	ignoreMissing := fs.Bool("ignore-missing", false, "don't fail on files that are listed but no longer exist, e.g. after retention or upload cleanup")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s verify [-out-dir dir] [-ignore-missing]\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return 0
+		}
+		return 2
+	}
+
+	f, err := os.Open(filepath.Join(*outDir, manifestName))
+	if err != nil {
+		fmt.Printf("❌ %v\n", err)
+		return 2
+	}
+	defer f.Close()
+
+	// Later lines win, as a path may have been recorded again after being
+	// repaired or overwritten
+	sums := map[string]string{}
+	var order []string
+	sc := bufio.NewScanner(f)
+	for line := 1; sc.Scan(); line++ {
+		sum, rel, ok := strings.Cut(sc.Text(), "  ")
+		if !ok || len(sum) != sha256.Size*2 {
+			fmt.Printf("❌ %s line %d: malformed entry\n", manifestName, line)
+			return 2
+		}
+		if _, seen := sums[rel]; !seen {
+			order = append(order, rel)
+		}
+		sums[rel] = sum
+	}
+	if err := sc.Err(); err != nil {
+		fmt.Printf("❌ %v\n", err)
+		return 2
+	}
+
+	var ok, failed, missing int
+	for _, rel := range order {
+		path := filepath.Join(*outDir, filepath.FromSlash(rel))
+		sum, err := fileSHA256(path)
+		switch {
+		case os.IsNotExist(err):
+			missing++
+			if !*ignoreMissing {
+				fmt.Printf("❓ %s: missing\n", path)
+			}
+		case err != nil:
+			failed++
+			fmt.Printf("❌ %s: %v\n", path, err)
+		case sum != sums[rel]:
+			failed++
+			fmt.Printf("❌ %s: checksum mismatch\n", path)
+		default:
+			ok++
+			fmt.Printf("✅ %s: OK\n", path)
+		}
+	}
+
+	fmt.Printf("%d OK, %d failed, %d missing\n", ok, failed, missing)
+	if failed > 0 || (missing > 0 && !*ignoreMissing) {
+		return 1
+	}
+	return 0
+}
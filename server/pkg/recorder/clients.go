@@ -0,0 +1,98 @@
+package recorder
+
+import (
+	"hash/maphash"
+	"sync"
+	"sync/atomic"
+)
+
+const clientShards = 64 // Of the client table; a power of two
+
+// clientTable holds the sessions being recorded by address, sharded by a
+// hash of the address so that looking up the sessions of different streams,
+// as the read loops, RTCP and STUN do, never waits on one lock. It is changed
+// only with the server's clientsMutex held, which keeps what is decided from
+// several of its entries, such as -max-clients, consistent; a lookup takes
+// only the lock of its shard, for reading.
+type clientTable struct {
+	seed   maphash.Seed
+	shards [clientShards]clientShard
+	n      atomic.Int64
+}
+
+type clientShard struct {
+	mu      sync.RWMutex
+	clients map[string]*Client // guarded by mu
+}
+
+func newClientTable() *clientTable {
+	t := &clientTable{seed: maphash.MakeSeed()}
+	for i := range t.shards {
+		t.shards[i].clients = make(map[string]*Client)
+	}
+	return t
+}
+
+func (t *clientTable) shard(addr string) *clientShard {
+	return &t.shards[maphash.String(t.seed, addr)&(clientShards-1)]
+}
+
+// get returns the session of addr.
+func (t *clientTable) get(addr string) (*Client, bool) {
+	sh := t.shard(addr)
+	sh.mu.RLock()
+	defer sh.mu.RUnlock()
+	c, ok := sh.clients[addr]
+	return c, ok
+}
+
+// set makes c the session of addr. The caller holds clientsMutex.
+func (t *clientTable) set(addr string, c *Client) {
+	sh := t.shard(addr)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	if _, ok := sh.clients[addr]; !ok {
+		t.n.Add(1)
+	}
+	sh.clients[addr] = c
+}
+
+// delete forgets the session of addr. The caller holds clientsMutex.
+func (t *clientTable) delete(addr string) {
+	sh := t.shard(addr)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	if _, ok := sh.clients[addr]; ok {
+		t.n.Add(-1)
+		delete(sh.clients, addr)
+	}
+}
+
+// len returns how many sessions there are.
+func (t *clientTable) len() int { return int(t.n.Load()) }
+
+// list returns the sessions.
+func (t *clientTable) list() []*Client {
+	clients := make([]*Client, 0, t.len())
+	t.each(func(_ string, c *Client) bool {
+		clients = append(clients, c)
+		return true
+	})
+	return clients
+}
+
+// each calls fn for every session, shard by shard, until it returns false.
+// fn must not change the table: the caller holds clientsMutex to do so.
+func (t *clientTable) each(fn func(addr string, c *Client) bool) {
+	for i := range t.shards {
+		sh := &t.shards[i]
+		sh.mu.RLock()
+		for addr, c := range sh.clients {
+			if !fn(addr, c) {
+				sh.mu.RUnlock()
+				return
+			}
+		}
+		sh.mu.RUnlock()
+	}
+}
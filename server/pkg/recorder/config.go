@@ -0,0 +1,548 @@
+package recorder
+
+import (
+	"crypto/tls"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/netip"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fcerini/audio-capture-shared/pkg/config"
+	"github.com/fcerini/audio-capture-shared/pkg/dscp"
+	"github.com/fcerini/audio-capture-shared/pkg/plugin"
+)
+
+// Config holds the server settings, from ParseConfig or NewConfig.
+type Config struct {
+	port       int
+	listen     string // IP address the RTP port is bound to ("" = all, dual-stack)
+	sampleRate int    // Must match the client's sample rate
+	bitDepth   int    // Must match the client's bit depth (16 for L16, 24 for L24)
+	channels   int    // Must match the client's channel count (1 for mono, 2 for stereo)
+
+	payloadTypes payloadTypes  // -payload-type, over the defaults
+	payloads     *payloadTable // How each payload type is decoded, from the above
+
+	reorderWindow duration // How long packets wait for the ones missing before them (0 = recorded as they arrive)
+	latePackets   string   // What is done with packets arriving after that: lateDrop or latePatch
+
+	outDir       string   // Directory all recordings are written under
+	fileTemplate string   // Filename template relative to outDir, see expandTemplate
+	maxFileSize  byteSize // Rotate to a new file before exceeding this size (0 = WAV limit only)
+	format       string   // Output container: formatWAV, formatMKA, formatWebM, formatFLAC or a registered format
+	codec        string   // Codec stored in the container: codecPCM, codecOpus or codecFLAC
+	bitrate      string   // Opus bitrate passed to ffmpeg, e.g. 96k
+	queueSize    int      // Decoded buffers queued per client before dropping
+	idleTimeout  duration // Finalize a client's recording after this long without packets (0 = never)
+
+	allowCIDR  cidrList // Networks packets are accepted from (empty = any)
+	maxClients int      // Most concurrent streams (0 = unlimited)
+	maxPerIP   int      // Most concurrent streams from one IP (0 = unlimited)
+	maxOpen    int      // Most sessions with a file open before idle ones are finalized (0 = unlimited)
+
+	headerInterval duration // How often to rewrite WAV header sizes while recording (0 = only on close)
+	bwf            bool     // Write Broadcast WAV bext metadata
+	bwfOriginator  string   // Originator field of the bext chunk
+
+	splitSilence     duration // Start a new file after this much silence (0 = never)
+	silenceThreshold float64  // Peak level in dBFS below which audio counts as silence
+	trimSilence      duration // Leave out silences longer than this (0 = never)
+	vadThreshold     float64  // RMS level in dBFS below which audio never counts as voice
+
+	retain      duration // Delete finished recordings older than this (0 = keep forever)
+	retainCount int      // Keep at most this many finished recordings (0 = unlimited)
+	maxDisk     byteSize // Quota for all recordings under outDir (0 = unlimited)
+	quotaPolicy string   // What to do when maxDisk is exceeded: quotaReject or quotaDeleteOldest
+
+	statsAddr string // Address of the HTTP stats endpoint (empty = disabled)
+	tui       bool   // Show the terminal monitor instead of the log
+	grpcAddr  string // Address of the gRPC API (empty = disabled)
+	pprofAddr string // Address of the pprof profiles (empty = disabled)
+	mqtt      string // MQTT broker events are published to, mqtt:// or mqtts:// (empty = disabled)
+	mqttTopic string // Topic template with {event}, {session} and {addr}
+	catalog   string // Path of the SQLite catalog of recordings (empty = disabled)
+	play      string // Streams to play on the server's speakers: a session, an address or playAll
+	playSink  string // PulseAudio sink for -play (empty = default)
+
+	logLevel  slog.Level // Least severe messages logged
+	logFormat string     // logText or logJSON
+	logStats  duration   // How often to log a summary of every stream (0 = never)
+
+	rtcpInterval duration // How often to send RTCP receiver reports to senders (0 = never)
+	rtcpPort     int      // Port RTCP is also taken on (0 = -port + 1, -1 = none)
+	handshake    string   // What to do with a stream offered in another format: handshakeReject or handshakeAdapt
+	stunServer   string   // STUN server the RTP port's reflexive address is learned from (empty = none)
+	punch        addrList // Senders' reflexive addresses packets are sent to, for hole punching
+	ice          bool     // Offer the RTP port's candidates to clients on POST /ice
+	mdns         string   // Name the RTP port is advertised by over mDNS (empty = not advertised)
+	tcp          bool     // Also accept RTP over TCP on the RTP port
+	srt          string   // Address to accept RTP over SRT on ("" = disabled)
+	whip         bool     // Take WebRTC publishers on POST /whip
+	raw          rawPorts // Ports taking raw PCM
+	metadataAddr string   // Address to take clients' session metadata on over TCP ("" = disabled)
+	profiles     profiles // Ports of their own stream and output formats
+
+	plugins   plugin.Specs // Processing stages every session's audio goes through, in order
+	debugPcap string       // File the packets received and sent are captured to (empty = disabled)
+	rcvBuf    byteSize     // SO_RCVBUF of the UDP socket (0 = the system default)
+	dscp      dscp.Class   // DS field of the packets the RTP port sends (0 = unmarked)
+	readers   int          // Sockets sharing the RTP port with SO_REUSEPORT, each with a read loop
+	rtpdump   bool         // Store the raw packets of every file in rtpdump format
+
+	tlsCert     string      // Certificate file for HTTPS and gRPC (empty = plain text)
+	tlsKey      string      // Its private key
+	tlsClientCA string      // CA file client certificates must be signed by (empty = not required)
+	tls         *tls.Config // Loaded from the above, nil without -tls-cert
+
+	otlpEndpoint string // OpenTelemetry collector traces are exported to (empty = disabled)
+
+	mix     string   // Streams downmixed into one recording: playAll or a list of addresses/IPs (empty = no mix)
+	mixGain gainList // Initial per-stream gain in dB, see controls
+
+	multitrack int // Tracks of the multitrack recording, one per concurrent stream (0 = disabled)
+
+	transcribe         string // Speech-to-text backend: transcribeWhisper or transcribeAPI (empty = disabled)
+	transcribeLanguage string // Spoken language, e.g. en (empty = detect)
+	whisperBin         string // whisper.cpp command-line tool
+	whisperModel       string // whisper.cpp model file
+	transcribeURL      string // OpenAI-compatible transcription endpoint
+	transcribeModel    string // Model name sent to the endpoint
+
+	dtmf      bool      // Detect DTMF digits
+	dtmfPT    int       // RTP payload type of telephone-events
+	dtmfHooks dtmfHooks // Commands run on DTMF sequences
+
+	t140      bool // Record T.140 real-time text sent with the streams
+	t140PT    int  // RTP payload type of T.140 text
+	t140RedPT int  // RTP payload type of redundant T.140 text (RFC 2198)
+
+	normalize lufs     // Integrated loudness finished recordings are normalized to (0 = disabled)
+	waveform  duration // Resolution of the waveform peaks written next to recordings (0 = disabled)
+
+	fingerprint bool   // Compute Chromaprint fingerprints of finished recordings
+	acoustid    bool   // Look fingerprints up on AcoustID
+	acoustidURL string // AcoustID lookup endpoint
+	dedupe      bool   // Delete recordings that duplicate an earlier one in the catalog
+
+	onClose string // Shell command run after each file is finalized
+
+	upload         string // Object storage destination, s3://bucket/prefix or gs://bucket/prefix
+	uploadEndpoint string // S3 API endpoint (empty = derived from the destination)
+	uploadRegion   string // Signing region (empty = AWS_REGION or a default)
+	uploadRetries  int    // Retries after a failed upload
+	uploadDelete   bool   // Delete local files once uploaded
+
+	settings []config.Setting // Where each option came from
+}
+
+// Output formats and codecs.
+const (
+	formatWAV  = "wav"
+	formatMKA  = "mka"
+	formatWebM = "webm"
+	formatFLAC = "flac"
+
+	codecPCM  = "pcm"
+	codecOpus = "opus" // Encoded by ffmpeg
+	codecFLAC = "flac" // Encoded by ffmpeg
+)
+
+// builtinFormat reports whether format is one of the server's own.
+func builtinFormat(format string) bool {
+	switch format {
+	case formatWAV, formatMKA, formatWebM, formatFLAC:
+		return true
+	}
+	return false
+}
+
+// ParseConfig parses the command-line flags into a Config and validates them.
+func ParseConfig(args []string) (*Config, error) {
+	cfg := &Config{}
+	fs := cfg.flagSet()
+	var err error
+	if cfg.settings, err = config.Parse(fs, args); err != nil {
+		return nil, err
+	}
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// flagSet returns the server's flags, setting cfg to their defaults.
+func (cfg *Config) flagSet() *flag.FlagSet {
+	fs := flag.NewFlagSet("audio-capture-server", flag.ContinueOnError)
+	fs.IntVar(&cfg.port, "port", 6001, "UDP port to listen on for RTP audio")
+	fs.StringVar(&cfg.listen, "listen", "", "IP address to listen on for RTP audio, e.g. 192.0.2.7 or [2001:db8::7]; 0.0.0.0 for every IPv4 address only, :: for every IPv6 address only (default: every address of both)")
+	fs.IntVar(&cfg.sampleRate, "rate", 48000, "sample rate of the incoming streams in Hz")
+	fs.IntVar(&cfg.bitDepth, "bits", 16, "bit depth of the incoming streams (16 or 24)")
+	fs.IntVar(&cfg.channels, "channels", 1, "channel count of the incoming streams (1 for mono, 2 for stereo)")
+	fs.Var(&cfg.reorderWindow, "reorder-window", "put every stream's packets back in order before recording them, holding those that arrive ahead of a missing one this long for it, e.g. 200ms, and filling the gaps of the ones that never come with silence (0 = record them as they arrive, as before)")
+	fs.StringVar(&cfg.latePackets, "late-packets", lateDrop, "what to do with a packet that arrives after -reorder-window gave up on it: drop it, or patch it into the silence it was replaced with, in place in the file (wav only)")
+	fs.Var(&cfg.payloadTypes, "payload-type", "decode the RTP packets of this payload type as pt=encoding[/rate[/channels]], e.g. 97=L24/96000/2 or 3=PCMU, with L16, L24, PCMU or PCMA (repeatable; default: 0=PCMU, 8=PCMA, 10 and 11 L16 at 44100 Hz, and 96 to 127 at -rate, -channels and -bits)")
+	fs.Var(&cfg.allowCIDR, "allow-cidr", "only accept packets from these networks or IPs, e.g. 10.0.0.0/8,192.0.2.7 (repeatable; default: any)")
+	fs.IntVar(&cfg.maxClients, "max-clients", 0, "refuse new streams while this many are being recorded (0 = unlimited)")
+	fs.IntVar(&cfg.maxPerIP, "max-clients-per-ip", 0, "refuse new streams from an IP while this many from it are being recorded (0 = unlimited)")
+	fs.IntVar(&cfg.maxOpen, "max-open-files", 0, "keep at most this many recordings open: a new stream finalizes the one idle the longest, or is refused while all are active (0 = unlimited)")
+	fs.StringVar(&cfg.outDir, "out-dir", ".", "directory to write recordings to")
+	fs.StringVar(&cfg.fileTemplate, "template", "{addr}_{start}.wav", "filename template for recordings, relative to -out-dir (placeholders: {addr} {ip} {port} {session} {ssrc} {start} {date} {time} {part} {title} {operator})")
+	fs.Var(&cfg.maxFileSize, "max-file-size", "rotate recordings into a new file before they exceed this size, e.g. 2GB (default: the 4 GiB WAV limit)")
+	fs.StringVar(&cfg.format, "format", formatWAV, "output container: wav, mka (Matroska), webm, flac or a registered format")
+	fs.StringVar(&cfg.codec, "codec", "", "codec stored in the container: pcm or opus (default: pcm for wav/mka, opus for webm, flac for flac)")
+	fs.StringVar(&cfg.bitrate, "bitrate", "96k", "Opus bitrate")
+	fs.IntVar(&cfg.queueSize, "queue-size", 500, "decoded audio buffers queued per client while its file is encoded and written; more are dropped (500 packets is about 10 s)")
+	cfg.idleTimeout = duration(30 * time.Second)
+	fs.Var(&cfg.idleTimeout, "idle-timeout", "finalize a client's recording after this long without packets; a later resume starts a new file (0 = never)")
+	cfg.headerInterval = duration(5 * time.Second)
+	fs.Var(&cfg.headerInterval, "header-interval", "how often to update the WAV header and flush to disk while recording, so files survive a crash (0 = only on close)")
+	fs.BoolVar(&cfg.bwf, "bwf", false, "write Broadcast WAV (BWF) bext metadata: origination date/time, originator and coding history")
+	fs.StringVar(&cfg.bwfOriginator, "bwf-originator", "audio-capture-server", "originator stored in the bext chunk (max 32 characters)")
+	fs.Var(&cfg.splitSilence, "split-silence", "start a new file after the stream has been silent this long, e.g. 30s (default: never)")
+	fs.Float64Var(&cfg.silenceThreshold, "silence-threshold", -50, "peak level in dBFS below which audio counts as silence for -split-silence")
+	fs.Var(&cfg.trimSilence, "trim-silence", "leave silences longer than this out of recordings, marking each gap with a cue, e.g. 5s (default: never)")
+	fs.Float64Var(&cfg.vadThreshold, "vad-threshold", -40, "RMS level in dBFS below which audio never counts as voice for -trim-silence")
+	fs.Var(&cfg.retain, "retain", "delete finished recordings older than this, e.g. 30d or 12h (default: keep forever)")
+	fs.IntVar(&cfg.retainCount, "retain-count", 0, "keep at most this many finished recordings, deleting the oldest first (0 = unlimited)")
+	fs.Var(&cfg.maxDisk, "max-disk", "quota for the total size of all recordings, e.g. 100GB (default: unlimited)")
+	fs.StringVar(&cfg.quotaPolicy, "quota-policy", quotaReject, "what to do when -max-disk is exceeded: reject (refuse new streams) or delete-oldest")
+	fs.StringVar(&cfg.onClose, "on-close", "", "shell command run after each file is finalized; {file}, {meta}, {session} and {addr} are replaced by quoted values, e.g. 'upload.sh {file} {meta}'")
+	fs.StringVar(&cfg.upload, "upload", "", "upload finished recordings and their metadata to object storage, e.g. s3://bucket/prefix or gs://bucket/prefix (credentials from AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY)")
+	fs.StringVar(&cfg.uploadEndpoint, "upload-endpoint", "", "S3-compatible endpoint for -upload, e.g. http://minio:9000 (default: AWS S3, or Google Cloud Storage for gs://)")
+	fs.StringVar(&cfg.uploadRegion, "upload-region", "", "region for -upload (default: $AWS_REGION, or us-east-1)")
+	fs.IntVar(&cfg.uploadRetries, "upload-retries", 5, "how often to retry a failed upload, with exponential backoff")
+	fs.BoolVar(&cfg.uploadDelete, "upload-delete", false, "delete local recordings and sidecars once they have been uploaded")
+	fs.StringVar(&cfg.grpcAddr, "grpc-addr", "", "serve the gRPC API for subscribing to live audio and controlling sessions on this address, e.g. 127.0.0.1:9090 (default: disabled)")
+	fs.TextVar(&cfg.logLevel, "log-level", slog.LevelInfo, "least severe messages logged: debug, info, warn or error")
+	fs.StringVar(&cfg.logFormat, "log-format", logText, "log format: text (readable lines) or json (one JSON object per line)")
+	fs.BoolVar(&cfg.rtpdump, "rtpdump", false, "also store the raw RTP packets of every recording next to it in rtpdump format, for the replay subcommand")
+	fs.Var(&cfg.rcvBuf, "rcvbuf", "receive buffer of the UDP socket, e.g. 8MB, for bursts the read loop can't keep up with; capped by net.core.rmem_max (default: the system default)")
+	fs.Var(&cfg.dscp, "dscp", "mark the RTCP reports and other packets the RTP port sends with this DSCP, e.g. EF, AF41 or 46, for networks that prioritize by it (default: unmarked)")
+	fs.IntVar(&cfg.readers, "readers", 1, "UDP sockets sharing the RTP port with SO_REUSEPORT, each read by a goroutine of its own, for more streams than one read loop keeps up with (0 = one per CPU; Linux only above 1)")
+	fs.StringVar(&cfg.debugPcap, "debug-pcap", "", "capture the packets received and sent to this pcap file, for Wireshark (default: disabled)")
+	fs.IntVar(&cfg.rtcpPort, "rtcp-port", 0, "also take the RTCP of senders that send it to a port of its own, such as their goodbyes (BYE), on this UDP port (default: -port + 1; -1 = only on the RTP port)")
+	fs.Var(&cfg.rtcpInterval, "rtcp-interval", "send every sender an RTCP receiver report with its loss and jitter this often, on the RTP port (rtcp-mux), e.g. 5s (0 = never)")
+	fs.StringVar(&cfg.stunServer, "stun", "", "learn the address the NAT in front of the server gives the RTP port from this STUN server, e.g. stun.l.google.com:19302, and keep it mapped (default: none)")
+	fs.Var(&cfg.punch, "punch", "send a packet now and then from the RTP port to these senders' addresses, as their STUN servers report them, so the NAT in front of the server lets their streams in (repeatable)")
+	fs.StringVar(&cfg.mdns, "mdns", "", "advertise the RTP port on the local network over mDNS as a _rtpaudio._udp service of this name, e.g. studio, for clients streaming to it by name or to auto (default: not advertised)")
+	fs.BoolVar(&cfg.tcp, "tcp", false, "also accept RTP over TCP on the RTP port, every packet framed by its length as in RFC 4571, for clients streaming with -transport=tcp from behind firewalls that block UDP")
+	fs.StringVar(&cfg.srt, "srt", "", "also accept RTP over SRT on this UDP address, e.g. :9000, from hardware encoders and clients streaming with -transport=srt over lossy links; the packets lost on the way are sent again (default: disabled)")
+	fs.BoolVar(&cfg.whip, "whip", false, "take WebRTC publishers, such as browsers and OBS, on POST /whip of the -stats-addr server (WHIP), recording their Opus audio decoded by ffmpeg like any stream")
+	fs.StringVar(&cfg.metadataAddr, "metadata-addr", "", "take the metadata of clients' sessions, such as their title, operator and source, over TCP on this address, e.g. :6010, storing it in the sidecars and filling {title} and {operator} in -template (default: disabled)")
+	fs.Var(&cfg.profiles, "profile", "also take RTP on this UDP port, decoding and recording its streams in a profile of their own, e.g. 'udp://:6002?encoding=pcmu&format=flac&dir=phones' or 'udp://:6003?encoding=opus' (repeatable; encoding l16, l24, pcmu, pcma or opus, with rate and channels, and format, codec and dir under -out-dir; default: the RTP port's)")
+	fs.Var(&cfg.raw, "raw", "also take raw PCM, for senders that can't speak RTP such as pacat piped to nc, on this port, in its own format, e.g. 'udp://:7000?format=s16le&rate=44100&channels=2' or tcp://:7001 (repeatable; format s16le, s16be, s24le or s24be, default s16le at -rate and -channels)")
+	fs.BoolVar(&cfg.ice, "ice", false, "offer the RTP port's addresses to clients on POST /ice of the -stats-addr server and answer their ICE checks, so they pick the best path themselves (ICE-lite)")
+	fs.StringVar(&cfg.handshake, "handshake", handshakeReject, "what to do with a stream whose sender announces another format than -rate, -channels and -bits in a handshake: reject (drop its packets) or adapt (record it in its own format, outside -mix and -multitrack)")
+	fs.Var(&cfg.logStats, "log-stats", "log the packet rate, bitrate, loss, jitter and file size of every stream this often, e.g. 1m (0 = never)")
+	fs.StringVar(&cfg.pprofAddr, "pprof-addr", "", "serve net/http/pprof profiles on this address, e.g. 127.0.0.1:6060 (default: disabled)")
+	fs.StringVar(&cfg.mqtt, "mqtt", "", "publish stream events and levels to this MQTT broker, e.g. mqtt://localhost:1883 or mqtts://broker:8883 (credentials from MQTT_USERNAME and MQTT_PASSWORD)")
+	fs.StringVar(&cfg.mqttTopic, "mqtt-topic", "audio-capture/{session}/{event}", "topic for -mqtt events; {event}, {session} and {addr} are replaced")
+	fs.StringVar(&cfg.tlsCert, "tls-cert", "", "serve the dashboard, REST API and gRPC API over TLS with this PEM certificate, reloaded when the file changes (default: plain text)")
+	fs.StringVar(&cfg.tlsKey, "tls-key", "", "PEM private key of -tls-cert")
+	fs.StringVar(&cfg.tlsClientCA, "tls-client-ca", "", "require TLS client certificates signed by a CA in this PEM file (mutual TLS)")
+	fs.StringVar(&cfg.otlpEndpoint, "otlp-endpoint", os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT"), "export traces of sessions, file writes, uploads and hooks to this OpenTelemetry collector over OTLP/HTTP, e.g. http://localhost:4318 (default: $OTEL_EXPORTER_OTLP_ENDPOINT, or disabled)")
+	fs.BoolVar(&cfg.tui, "tui", false, "show a live table of the streams in the terminal, with keys to mute, solo and finalize them, and the log below it")
+	fs.StringVar(&cfg.catalog, "catalog", "", "index sessions and recordings in this SQLite database, queryable on the -stats-addr server, e.g. recordings.db (default: disabled)")
+	fs.StringVar(&cfg.play, "play", "", "play streams live on the server's audio output through pacat while recording: a session ID, a client address or \"all\" (can be changed on POST /play)")
+	fs.StringVar(&cfg.playSink, "play-sink", "", "PulseAudio sink for -play (default: the default sink)")
+	fs.StringVar(&cfg.mix, "mix", "", "also record a downmix of streams into a single file: \"all\" or a comma-separated list of client addresses or IPs (default: no mix)")
+	fs.Var(&cfg.mixGain, "mix-gain", "initial per-stream gain in dB for the mix and live playback, e.g. 10.0.0.5=-6,10.0.0.6=3 (can be changed on POST /controls)")
+	fs.IntVar(&cfg.multitrack, "multitrack", 0, "also record up to this many concurrent streams into one multichannel file, one track of -channels channels per stream, aligned by RTP timestamps (default: disabled)")
+	fs.StringVar(&cfg.transcribe, "transcribe", "", "write .srt and .txt transcripts of finished recordings with \"whisper\" (local whisper.cpp) or \"api\" (OpenAI-compatible HTTP API) (default: disabled)")
+	fs.StringVar(&cfg.transcribeLanguage, "transcribe-language", "", "spoken language for -transcribe, e.g. en (default: detect)")
+	fs.StringVar(&cfg.whisperBin, "whisper-bin", "whisper-cli", "whisper.cpp command-line tool for -transcribe=whisper")
+	fs.StringVar(&cfg.whisperModel, "whisper-model", "", "whisper.cpp model file for -transcribe=whisper, e.g. models/ggml-base.en.bin")
+	fs.StringVar(&cfg.transcribeURL, "transcribe-url", "https://api.openai.com/v1/audio/transcriptions", "transcription endpoint for -transcribe=api (API key from TRANSCRIBE_API_KEY)")
+	fs.StringVar(&cfg.transcribeModel, "transcribe-model", "whisper-1", "model name for -transcribe=api")
+	fs.BoolVar(&cfg.dtmf, "dtmf", false, "detect DTMF digits, from RFC 4733 telephone-event packets or else in the audio, and log them in the sidecar")
+	fs.IntVar(&cfg.dtmfPT, "dtmf-pt", 101, "RTP payload type of telephone-event packets for -dtmf")
+	fs.Var(&cfg.plugins, "plugin", "run every session's audio through this program before recording it, as name=command args, e.g. 'denoise=/usr/local/bin/denoiser -strength 0.5'; see the README for the protocol (repeatable, in order)")
+	fs.Var(&cfg.dtmfHooks, "dtmf-hook", "run a shell command when a stream sends a DTMF sequence, e.g. '*1#=curl ... {addr}'; {digits}, {session}, {addr} and {file} are replaced (repeatable)")
+	fs.BoolVar(&cfg.t140, "t140", false, "record T.140 real-time text (RFC 4103) sent from the address or IP of a stream, as subtitles next to its recordings")
+	fs.IntVar(&cfg.t140PT, "t140-pt", 98, "RTP payload type of T.140 text for -t140")
+	fs.IntVar(&cfg.t140RedPT, "t140-red-pt", 100, "RTP payload type of redundant T.140 text for -t140")
+	fs.Var(&cfg.normalize, "normalize", "normalize the loudness of finished recordings to this EBU R128 integrated loudness with ffmpeg, e.g. -16LUFS (default: disabled)")
+	fs.Var(&cfg.waveform, "waveform", "write waveform peaks of each recording at this resolution next to it, for previews, e.g. 100ms (default: disabled)")
+	fs.BoolVar(&cfg.fingerprint, "fingerprint", false, "compute a Chromaprint fingerprint of each finished recording with fpcalc, stored in the sidecar and used to find duplicates in the -catalog")
+	fs.BoolVar(&cfg.acoustid, "acoustid", false, "identify fingerprinted recordings on AcoustID (API key from ACOUSTID_API_KEY)")
+	fs.StringVar(&cfg.acoustidURL, "acoustid-url", "https://api.acoustid.org/v2/lookup", "AcoustID lookup endpoint for -acoustid")
+	fs.BoolVar(&cfg.dedupe, "dedupe", false, "delete finished recordings whose audio duplicates an earlier recording in the -catalog")
+	fs.StringVar(&cfg.statsAddr, "stats-addr", "", "serve JSON statistics over HTTP on this address, e.g. 127.0.0.1:8080 (default: disabled)")
+	fs.String("config", "", "read the options not given as flags or "+config.EnvPrefix+"* variables from this file of name = value lines (default: $"+config.EnvName("config")+", or none)")
+	return fs
+}
+
+// validate checks the settings, filling in those derived from others, such
+// as the payload table and the TLS configuration.
+func (cfg *Config) validate() error {
+	if cfg.port <= 0 || cfg.port > 65535 {
+		return fmt.Errorf("invalid port %d", cfg.port)
+	}
+	if cfg.listen != "" {
+		cfg.listen = strings.TrimSuffix(strings.TrimPrefix(cfg.listen, "["), "]")
+		if _, err := netip.ParseAddr(cfg.listen); err != nil {
+			return fmt.Errorf("invalid -listen %q (use an IP address, e.g. 0.0.0.0, :: or 192.0.2.7)", cfg.listen)
+		}
+	}
+	if cfg.whip && cfg.statsAddr == "" {
+		return fmt.Errorf("-whip needs -stats-addr, where publishers post their offers")
+	}
+	if cfg.ice && cfg.statsAddr == "" {
+		return fmt.Errorf("-ice needs -stats-addr, where clients post their ICE candidates")
+	}
+	if cfg.sampleRate <= 0 {
+		return fmt.Errorf("invalid sample rate %d", cfg.sampleRate)
+	}
+	if cfg.bitDepth != 16 && cfg.bitDepth != 24 {
+		return fmt.Errorf("unsupported bit depth %d (use 16 or 24)", cfg.bitDepth)
+	}
+	if cfg.channels < 1 || cfg.channels > 8 {
+		return fmt.Errorf("unsupported channel count %d (use 1 to 8)", cfg.channels)
+	}
+	cfg.payloads = cfg.payloadTypes.table(cfg)
+	if cfg.fileTemplate == "" || strings.HasSuffix(cfg.fileTemplate, "/") {
+		return fmt.Errorf("invalid filename template %q", cfg.fileTemplate)
+	}
+	if cfg.idleTimeout > 0 && time.Duration(cfg.idleTimeout) < 100*time.Millisecond {
+		return fmt.Errorf("idle timeout %s is too short", cfg.idleTimeout.String())
+	}
+	if err := validateReorder(cfg); err != nil {
+		return err
+	}
+	if cfg.rtcpPort < -1 || cfg.rtcpPort > 65535 || cfg.rtcpPort > 0 && cfg.rtcpPort == cfg.port {
+		return fmt.Errorf("invalid -rtcp-port %d", cfg.rtcpPort)
+	}
+	if cfg.rtcpInterval > 0 && time.Duration(cfg.rtcpInterval) < time.Second {
+		return fmt.Errorf("-rtcp-interval %s is too short", cfg.rtcpInterval.String())
+	}
+	if cfg.metadataAddr != "" {
+		if _, _, err := net.SplitHostPort(cfg.metadataAddr); err != nil {
+			return fmt.Errorf("invalid -metadata-addr %q: %w", cfg.metadataAddr, err)
+		}
+	}
+	if cfg.srt != "" {
+		if _, _, err := net.SplitHostPort(cfg.srt); err != nil {
+			return fmt.Errorf("invalid -srt address %q: %w", cfg.srt, err)
+		}
+	}
+	if cfg.handshake != handshakeReject && cfg.handshake != handshakeAdapt {
+		return fmt.Errorf("unknown -handshake %q (use reject or adapt)", cfg.handshake)
+	}
+	for _, p := range cfg.plugins {
+		if _, err := exec.LookPath(p.Command[0]); err != nil {
+			return fmt.Errorf("plugin %s: %w", p.Name, err)
+		}
+	}
+	if cfg.readers < 0 {
+		return fmt.Errorf("invalid -readers %d", cfg.readers)
+	}
+	if cfg.readers == 0 {
+		cfg.readers = runtime.NumCPU()
+	}
+	if cfg.logStats > 0 && time.Duration(cfg.logStats) < time.Second {
+		return fmt.Errorf("-log-stats %s is too short", cfg.logStats.String())
+	}
+	if cfg.maxClients < 0 || cfg.maxPerIP < 0 || cfg.maxOpen < 0 {
+		return fmt.Errorf("invalid client limit")
+	}
+	if cfg.queueSize < 1 {
+		return fmt.Errorf("invalid queue size %d", cfg.queueSize)
+	}
+	if err := validateOutput(cfg); err != nil {
+		return err
+	}
+	if len(cfg.bwfOriginator) > 32 {
+		return fmt.Errorf("BWF originator %q is longer than 32 characters", cfg.bwfOriginator)
+	}
+	if cfg.silenceThreshold >= 0 {
+		return fmt.Errorf("silence threshold must be below 0 dBFS, got %g", cfg.silenceThreshold)
+	}
+	if cfg.trimSilence > 0 && cfg.splitSilence > 0 {
+		return fmt.Errorf("-trim-silence and -split-silence can't be combined")
+	}
+	if cfg.vadThreshold >= 0 {
+		return fmt.Errorf("voice threshold must be below 0 dBFS, got %g", cfg.vadThreshold)
+	}
+	if cfg.logFormat != logText && cfg.logFormat != logJSON {
+		return fmt.Errorf("unknown log format %q (use %s or %s)", cfg.logFormat, logText, logJSON)
+	}
+	if cfg.quotaPolicy != quotaReject && cfg.quotaPolicy != quotaDeleteOldest {
+		return fmt.Errorf("unknown quota policy %q (use %s or %s)", cfg.quotaPolicy, quotaReject, quotaDeleteOldest)
+	}
+	if cfg.uploadRetries < 0 {
+		return fmt.Errorf("invalid upload retry count %d", cfg.uploadRetries)
+	}
+	if cfg.uploadDelete && cfg.upload == "" {
+		return fmt.Errorf("-upload-delete requires -upload")
+	}
+	if cfg.multitrack < 0 || cfg.multitrack*cfg.channels > 64 {
+		return fmt.Errorf("invalid multitrack track count %d (at most 64 channels in total)", cfg.multitrack)
+	}
+	if cfg.multitrack > 0 && cfg.codec != codecPCM {
+		return fmt.Errorf("-multitrack requires pcm output")
+	}
+	switch cfg.transcribe {
+	case "", transcribeAPI:
+	case transcribeWhisper:
+		if cfg.whisperModel == "" {
+			return fmt.Errorf("-transcribe=whisper requires -whisper-model")
+		}
+	default:
+		return fmt.Errorf("unknown transcription backend %q (use whisper or api)", cfg.transcribe)
+	}
+	if cfg.dtmfPT < 0 || cfg.dtmfPT > 127 {
+		return fmt.Errorf("invalid DTMF payload type %d", cfg.dtmfPT)
+	}
+	for _, pt := range []int{cfg.t140PT, cfg.t140RedPT} {
+		if pt < 0 || pt > 127 {
+			return fmt.Errorf("invalid T.140 payload type %d", pt)
+		}
+	}
+	if len(cfg.dtmfHooks) > 0 && !cfg.dtmf {
+		return fmt.Errorf("-dtmf-hook requires -dtmf")
+	}
+	if (cfg.acoustid || cfg.dedupe) && !cfg.fingerprint {
+		return fmt.Errorf("-acoustid and -dedupe require -fingerprint")
+	}
+	if cfg.acoustid && os.Getenv("ACOUSTID_API_KEY") == "" {
+		return fmt.Errorf("-acoustid requires an API key in ACOUSTID_API_KEY")
+	}
+	if cfg.dedupe && cfg.catalog == "" {
+		return fmt.Errorf("-dedupe requires -catalog")
+	}
+	if cfg.retainCount < 0 {
+		return fmt.Errorf("invalid retain count %d", cfg.retainCount)
+	}
+	if (cfg.tlsCert == "") != (cfg.tlsKey == "") {
+		return fmt.Errorf("-tls-cert and -tls-key must be given together")
+	}
+	if cfg.tlsClientCA != "" && cfg.tlsCert == "" {
+		return fmt.Errorf("-tls-client-ca requires -tls-cert")
+	}
+	var err error
+	if cfg.tls, err = newTLSConfig(cfg); err != nil {
+		return err
+	}
+	return cfg.profiles.resolve(cfg)
+}
+
+// validateOutput checks -format and -codec, defaulting the codec to the
+// format's.
+func validateOutput(cfg *Config) error {
+	if cfg.codec == "" {
+		cfg.codec = codecPCM
+		switch cfg.format {
+		case formatWebM:
+			cfg.codec = codecOpus
+		case formatFLAC:
+			cfg.codec = codecFLAC
+		}
+	}
+	_, registered := registeredFormat(cfg.format)
+	registered = registered && !builtinFormat(cfg.format)
+	switch {
+	case !builtinFormat(cfg.format) && !registered:
+		return fmt.Errorf("unknown output format %q (use %s)", cfg.format, strings.Join(append([]string{formatWAV, formatMKA, formatWebM, formatFLAC}, registeredFormats()...), ", "))
+	case cfg.codec != codecPCM && cfg.codec != codecOpus && cfg.codec != codecFLAC:
+		return fmt.Errorf("unknown codec %q (use pcm or opus)", cfg.codec)
+	case (cfg.format == formatWAV || registered) && cfg.codec != codecPCM:
+		return fmt.Errorf("%s output only supports pcm", cfg.format)
+	case cfg.format == formatWebM && cfg.codec != codecOpus:
+		return fmt.Errorf("webm output only supports opus")
+	case cfg.format == formatFLAC && cfg.codec != codecFLAC:
+		return fmt.Errorf("flac output only supports flac")
+	case cfg.codec == codecFLAC && cfg.format != formatFLAC:
+		return fmt.Errorf("the flac codec requires flac output")
+	case cfg.normalize != 0 && registered:
+		return fmt.Errorf("-normalize requires wav, mka, webm or flac output")
+	case cfg.bwf && cfg.format != formatWAV:
+		return fmt.Errorf("-bwf requires wav output")
+	}
+	return nil
+}
+
+// Settings returns the value of every option and whether it came from a
+// flag, the environment, the config file or the default.
+func (cfg *Config) Settings() []config.Setting { return cfg.settings }
+
+// byteSize is a flag value holding a size in bytes, written with an optional
+// unit such as "500MB" or "2GiB". Decimal units (KB, MB, GB, TB) are powers
+// of 1000 and binary units (KiB, MiB, GiB, TiB) powers of 1024.
+type byteSize int64
+
+var byteUnits = []struct {
+	suffix string
+	factor int64
+}{
+	{"KiB", 1 << 10}, {"MiB", 1 << 20}, {"GiB", 1 << 30}, {"TiB", 1 << 40},
+	{"KB", 1e3}, {"MB", 1e6}, {"GB", 1e9}, {"TB", 1e12},
+	{"K", 1e3}, {"M", 1e6}, {"G", 1e9}, {"T", 1e12},
+	{"B", 1},
+}
+
+func (b *byteSize) String() string {
+	if b == nil || *b == 0 {
+		return "0"
+	}
+	return strconv.FormatInt(int64(*b), 10)
+}
+
+func (b *byteSize) Set(s string) error {
+	s = strings.TrimSpace(s)
+	factor := int64(1)
+	for _, u := range byteUnits {
+		if strings.HasSuffix(strings.ToUpper(s), strings.ToUpper(u.suffix)) {
+			s = strings.TrimSpace(s[:len(s)-len(u.suffix)])
+			factor = u.factor
+			break
+		}
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil || v < 0 {
+		return fmt.Errorf("invalid size %q", s)
+	}
+	*b = byteSize(v * float64(factor))
+	return nil
+}
+
+// duration is a flag value like time.Duration that also accepts days and
+// weeks, e.g. "30d" or "2w".
+type duration time.Duration
+
+func (d *duration) String() string {
+	if d == nil || *d == 0 {
+		return "0"
+	}
+	v := time.Duration(*d)
+	if v%(24*time.Hour) == 0 {
+		return fmt.Sprintf("%dd", v/(24*time.Hour))
+	}
+	return v.String()
+}
+
+func (d *duration) Set(s string) error {
+	s = strings.TrimSpace(s)
+	for suffix, unit := range map[string]time.Duration{"d": 24 * time.Hour, "w": 7 * 24 * time.Hour} {
+		if n, ok := strings.CutSuffix(s, suffix); ok {
+			v, err := strconv.ParseFloat(n, 64)
+			if err != nil || v < 0 {
+				return fmt.Errorf("invalid duration %q", s)
+			}
+			*d = duration(v * float64(unit))
+			return nil
+		}
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil || v < 0 {
+		return fmt.Errorf("invalid duration %q", s)
+	}
+	*d = duration(v)
+	return nil
+}
@@ -0,0 +1,29 @@
+package recorder
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestNewConfig(t *testing.T) {
+	parsed, err := ParseConfig([]string{"-port", "7000", "-out-dir", "recordings", "-format", "mka", "-idle-timeout", "1m"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	made, err := NewConfig(WithPort(7000), WithOutDir("recordings"), WithOutput("mka", ""), WithIdleTimeout(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The options and the flags set the same, and the rest has the same
+	// defaults
+	made.settings = parsed.settings
+	if !reflect.DeepEqual(made, parsed) {
+		t.Errorf("NewConfig made\n%+v, ParseConfig\n%+v", made, parsed)
+	}
+
+	// Options are checked as the flags are
+	if _, err := NewConfig(WithStreamFormat(48000, 20, 2)); err == nil {
+		t.Error("NewConfig took a bit depth of 20")
+	}
+}
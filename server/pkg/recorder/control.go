@@ -0,0 +1,61 @@
+package recorder
+
+import (
+	"context"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/fcerini/audio-capture-shared/audiopb"
+)
+
+// controlService implements the Control gRPC service of audiopb, shared
+// with the client, over the sessions of the REST API.
+type controlService struct {
+	audiopb.UnimplementedControlServer
+	srv *server
+}
+
+func (c *controlService) CreateSession(context.Context, *audiopb.CreateSessionRequest) (*audiopb.ControlSession, error) {
+	return nil, status.Error(codes.Unimplemented, "the server records the sessions its senders start")
+}
+
+func (c *controlService) GetStats(_ context.Context, req *audiopb.GetStatsRequest) (*audiopb.GetStatsResponse, error) {
+	list := c.srv.sessions(req.Session)
+	if req.Session != "" && len(list) == 0 {
+		return nil, status.Errorf(codes.NotFound, "no session %q", req.Session)
+	}
+	resp := &audiopb.GetStatsResponse{}
+	for _, st := range list {
+		resp.Sessions = append(resp.Sessions, controlSession(st, "recording"))
+	}
+	return resp, nil
+}
+
+func (c *controlService) FinalizeRecording(_ context.Context, req *audiopb.FinalizeRecordingRequest) (*audiopb.ControlSession, error) {
+	st, ok := c.srv.stopSession(req.Session)
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "no session %q", req.Session)
+	}
+	grpcLog.Info("⏹️  Finalized session on request", "session", req.Session)
+	return controlSession(st, "ended"), nil
+}
+
+func controlSession(st apiSession, state string) *audiopb.ControlSession {
+	return &audiopb.ControlSession{
+		Id:    st.Session,
+		State: state,
+		Peer:  st.Addr,
+		Start: timestamppb.New(st.Start),
+		File:  st.File,
+		Stats: &audiopb.SessionStats{
+			Packets:     uint64(st.Network.Received),
+			Bytes:       uint64(st.Network.Bytes),
+			Dropped:     uint64(st.Dropped),
+			PacketsLost: st.Network.Lost,
+			LossPercent: st.Network.LossPercent,
+			JitterMs:    st.Network.JitterMS,
+		},
+	}
+}
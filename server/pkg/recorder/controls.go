@@ -0,0 +1,144 @@
+package recorder
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+)
+
+// streamControl holds the operator settings for one stream, or for every
+// stream from one IP.
+type streamControl struct {
+	GainDB float64 `json:"gain_db"`
+	Mute   bool    `json:"mute"`
+	Solo   bool    `json:"solo"`
+}
+
+// controls are the runtime gain, mute and solo settings applied to streams
+// in live playback and in the mix. They are keyed by client address or IP;
+// an address takes precedence over its IP. While any stream is soloed, only
+// soloed streams are heard.
+type controls struct {
+	mu      sync.Mutex
+	streams map[string]*streamControl
+}
+
+// newControls starts out with the gains given by -mix-gain.
+func newControls(gains gainList) *controls {
+	c := &controls{streams: make(map[string]*streamControl)}
+	for key, db := range gains {
+		c.streams[key] = &streamControl{GainDB: db}
+	}
+	return c
+}
+
+// factor returns the linear gain to apply to the stream from addr.
+func (c *controls) factor(addr string) float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	sc := c.streams[addr]
+	if sc == nil {
+		host, _, _ := net.SplitHostPort(addr)
+		sc = c.streams[host]
+	}
+	soloing := false
+	for _, s := range c.streams {
+		soloing = soloing || s.Solo
+	}
+	switch {
+	case sc == nil && soloing:
+		return 0
+	case sc == nil:
+		return 1
+	case sc.Mute, soloing && !sc.Solo:
+		return 0
+	}
+	return math.Pow(10, sc.GainDB/20)
+}
+
+// scaleSamples applies a linear gain, clipping to the sample range. It
+// returns a copy, which the caller may keep after samples are reused.
+func scaleSamples(samples []int, factor float64, bitDepth int) []int {
+	if factor == 1 {
+		return slices.Clone(samples)
+	}
+	limit := float64(int(1)<<(bitDepth-1) - 1)
+	out := make([]int, len(samples))
+	for i, s := range samples {
+		out[i] = int(math.Round(max(-limit-1, min(limit, float64(s)*factor))))
+	}
+	return out
+}
+
+// handleControls lists the stream controls on GET /controls and changes one
+// on POST /controls?stream=<addr|ip>, with any of gain (dB), mute and solo.
+func (c *controls) handleControls(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		if err := c.update(r); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(c.streams)
+}
+
+func (c *controls) update(r *http.Request) error {
+	key := r.FormValue("stream")
+	if key == "" {
+		return fmt.Errorf("missing stream")
+	}
+
+	// Parse everything before changing anything
+	sc := c.get(key)
+	if v := r.FormValue("gain"); v != "" {
+		db, err := strconv.ParseFloat(v, 64)
+		if err != nil || math.IsNaN(db) || math.IsInf(db, 0) {
+			return fmt.Errorf("invalid gain %q", v)
+		}
+		sc.GainDB = db
+	}
+	for name, dst := range map[string]*bool{"mute": &sc.Mute, "solo": &sc.Solo} {
+		if v := r.FormValue(name); v != "" {
+			b, err := strconv.ParseBool(v)
+			if err != nil {
+				return fmt.Errorf("invalid %s %q", name, v)
+			}
+			*dst = b
+		}
+	}
+
+	c.set(key, sc)
+	return nil
+}
+
+// get returns the settings for an address or IP, defaulting to unity gain.
+func (c *controls) get(key string) streamControl {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if cur := c.streams[key]; cur != nil {
+		return *cur
+	}
+	return streamControl{}
+}
+
+func (c *controls) set(key string, sc streamControl) {
+	c.mu.Lock()
+	c.streams[key] = &sc
+	c.mu.Unlock()
+	httpLog.Info("🎚️  Stream controls changed", "stream", key, "gain_db", sc.GainDB, "mute", sc.Mute, "solo", sc.Solo)
+}
@@ -0,0 +1,152 @@
+package recorder
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pion/rtp"
+)
+
+// Convert implements the "convert" subcommand, which decodes the packets of
+// an rtpdump file into a recording again, e.g. after a file was lost or to
+// try other settings. Packets are put back in sequence order, duplicates
+// dropped and the audio of lost packets filled with silence.
+func Convert(args []string) int {
+	fs := flag.NewFlagSet("convert", flag.ContinueOnError)
+	sampleRate := fs.Int("rate", 48000, "sample rate of the recorded stream in Hz")
+	bitDepth := fs.Int("bits", 16, "bit depth of the recorded stream (16 or 24)")
+	channels := fs.Int("channels", 1, "channel count of the recorded stream")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s convert [-rate 48000] [-bits 16] [-channels 1] <file.rtpdump> <file.wav|.mka|.flac>\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return 0
+		}
+		return 2
+	}
+	if fs.NArg() != 2 || *sampleRate <= 0 || (*bitDepth != 16 && *bitDepth != 24) || *channels < 1 {
+		fs.Usage()
+		return 2
+	}
+	in, out := fs.Arg(0), fs.Arg(1)
+
+	source, packets, err := readRTPDump(in)
+	if err != nil {
+		fmt.Printf("❌ %s: %v\n", in, err)
+		return 1
+	}
+	info, err := os.Stat(in)
+	if err != nil {
+		fmt.Printf("❌ %v\n", err)
+		return 1
+	}
+	meta := mkaMeta{title: "RTP stream from " + source, start: info.ModTime().Add(-packetsDuration(packets))}
+	var w segmentWriter
+	switch strings.ToLower(filepath.Ext(out)) {
+	case "." + formatWAV:
+		w, err = newWAVWriter(out, *sampleRate, *bitDepth, *channels, nil)
+	case "." + formatMKA:
+		w, err = newMKAWriter(out, *sampleRate, *bitDepth, *channels, meta)
+	case "." + formatFLAC:
+		w, err = newFFmpegWriter(out, "flac", []string{"-c:a", "flac"}, *sampleRate, *bitDepth, *channels, meta)
+	default:
+		fmt.Printf("❌ %s: convert writes .wav, .mka or .flac files\n", out)
+		return 2
+	}
+	if err != nil {
+		fmt.Printf("❌ %v\n", err)
+		return 1
+	}
+
+	decoded, frames, gaps, err := convertPackets(packets, w, *bitDepth, *channels)
+	if cerr := w.close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		fmt.Printf("❌ %s: %v\n", out, err)
+		return 1
+	}
+	fmt.Printf("✅ Converted %d packets from %s to %s: %.1f s of audio, %d gaps filled with silence\n",
+		decoded, in, out, float64(frames)/float64(*sampleRate), gaps)
+	return 0
+}
+
+// packetsDuration returns the span of the packets' arrival.
+func packetsDuration(packets []rtpdumpPacket) (d time.Duration) {
+	if len(packets) > 0 {
+		d = packets[len(packets)-1].offset
+	}
+	return d
+}
+
+// convertPackets writes the audio of the packets to w in sequence order.
+// Only packets of the payload type of the first are audio; the others, such
+// as DTMF events, are skipped.
+func convertPackets(packets []rtpdumpPacket, w segmentWriter, bitDepth, channels int) (decoded int, frames int64, gaps int, err error) {
+	type audioPacket struct {
+		seq       uint64 // Extended over wraparounds
+		timestamp uint32
+		payload   []byte
+	}
+	var (
+		audio       []audioPacket
+		payloadType uint8
+		lastSeq     uint64
+	)
+	for _, p := range packets {
+		var pkt rtp.Packet
+		if err := pkt.Unmarshal(p.data); err != nil {
+			continue
+		}
+		if len(audio) == 0 {
+			// Start high, so sequence numbers before the first don't go below 0
+			payloadType, lastSeq = pkt.PayloadType, 1<<32|uint64(pkt.SequenceNumber)
+		} else if pkt.PayloadType != payloadType {
+			continue
+		}
+		// The sequence number closest to the previous packet's
+		seq := lastSeq + uint64(int16(pkt.SequenceNumber-uint16(lastSeq)))
+		lastSeq = seq
+		audio = append(audio, audioPacket{seq: seq, timestamp: pkt.Timestamp, payload: pkt.Payload})
+	}
+	sort.SliceStable(audio, func(i, j int) bool { return audio[i].seq < audio[j].seq })
+
+	frameSize := bitDepth / 8 * channels
+	var (
+		samples  []int
+		next     uint32 // Timestamp the next packet should have
+		previous uint64
+	)
+	for i, p := range audio {
+		if i > 0 && p.seq == previous {
+			continue // Duplicate
+		}
+		previous = p.seq
+		// Packets lost in between leave their audio as silence
+		if i > 0 && p.seq > audio[i-1].seq+1 {
+			if missing := int32(p.timestamp - next); missing > 0 {
+				if err := w.write(make([]int, int(missing)*channels)); err != nil {
+					return decoded, frames, gaps, err
+				}
+				frames += int64(missing)
+				gaps++
+			}
+		}
+		samples = decodePCM(samples, p.payload, bitDepth, channels)
+		if err := w.write(samples); err != nil {
+			return decoded, frames, gaps, err
+		}
+		n := len(p.payload) / frameSize
+		frames += int64(n)
+		next = p.timestamp + uint32(n)
+		decoded++
+	}
+	return decoded, frames, gaps, nil
+}
@@ -0,0 +1,28 @@
+package recorder
+
+import (
+	"context"
+	"expvar"
+	"net/http"
+	"net/http/pprof"
+)
+
+// servePprof serves the net/http/pprof profiles under /debug/pprof/ on addr,
+// for CPU profiles and goroutine dumps of a long-running server, and the
+// expvar counters on /debug/vars. It is a
+// listener of its own, so it can stay on localhost while the dashboard is
+// exposed, and needs API_TOKEN like the other endpoints if it is set. It
+// stops when ctx is done.
+func servePprof(ctx context.Context, addr string) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.Handle("/debug/vars", expvar.Handler())
+	httpLog.Info("🩺 Serving pprof profiles", "url", "http://"+addr+"/debug/pprof/")
+	if err := serveHTTP(ctx, &http.Server{Addr: addr, Handler: requireToken(mux)}); err != nil {
+		httpLog.Error("pprof server failed", "err", err)
+	}
+}
@@ -0,0 +1,331 @@
+package recorder
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"os/exec"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// dtmfDigits are the DTMF digits by RFC 4733 event code.
+const dtmfDigits = "0123456789*#ABCD"
+
+const (
+	dtmfBlock      = 25600 * time.Microsecond // Analysis window of the in-band detector
+	dtmfMinPower   = 0.8                      // Share of a window's energy the tone pair must hold
+	dtmfMinTone    = 0.12                     // Share each tone must hold, allowing for about 8 dB of twist
+	dtmfMinLevel   = -45                      // dBFS below which windows are ignored
+	dtmfHistoryLen = 32                       // Digits kept for matching -dtmf-hook sequences
+)
+
+// DTMF tone frequencies in Hz, the row tone followed by the column tone.
+var (
+	dtmfRows = [4]float64{697, 770, 852, 941}
+	dtmfCols = [4]float64{1209, 1336, 1477, 1633}
+	dtmfKeys = [4][4]byte{{'1', '2', '3', 'A'}, {'4', '5', '6', 'B'}, {'7', '8', '9', 'C'}, {'*', '0', '#', 'D'}}
+)
+
+// Where a DTMF digit was detected.
+const (
+	dtmfRFC4733 = "rfc4733" // telephone-event RTP packets
+	dtmfInBand  = "inband"  // Tones in the audio
+)
+
+// dtmfEvent is a DTMF digit received on a stream.
+type dtmfEvent struct {
+	Digit    string    `json:"digit"`
+	At       float64   `json:"at_seconds"` // Position in the file where the digit started
+	Time     time.Time `json:"time"`
+	Duration float64   `json:"duration_seconds"`
+	Source   string    `json:"source"`
+
+	frame int64 // Frames of the stream's audio that preceded its end
+}
+
+// dtmfState tracks the DTMF digits of one stream. telephone-event packets
+// are handled on the packet path and queued in pending with the amount of
+// audio that came before them; the writer goroutine takes them once it has
+// stored that audio, so it can place them in the file. Once a stream has sent
+// a telephone-event, in-band detection is turned off for it, so digits aren't
+// reported twice.
+type dtmfState struct {
+	cfg    *Config
+	inband *dtmfDetector
+
+	// Packet path only
+	lastTS    uint32    // RTP timestamp of the current event
+	lastEvent dtmfEvent // Current event as of its latest packet
+	inEvent   bool
+	reported  bool
+	rfcActive bool
+
+	mu      sync.Mutex
+	pending []dtmfEvent
+	rfcSeen bool
+	history string // Latest digits, for -dtmf-hook
+}
+
+func newDTMFState(cfg *Config) *dtmfState {
+	return &dtmfState{cfg: cfg, inband: newDTMFDetector(cfg)}
+}
+
+// telephoneEvent handles an RFC 4733 packet. All packets of an event share
+// its RTP timestamp and the end packet is usually sent three times, so each
+// event is reported once, when it ends, or when the next one starts without
+// its end having arrived.
+func (d *dtmfState) telephoneEvent(timestamp uint32, payload []byte, frame int64) {
+	if len(payload) < 4 {
+		return
+	}
+	event, end := payload[0], payload[1]&0x80 != 0
+	dur := time.Duration(binary.BigEndian.Uint16(payload[2:4])) * time.Second / time.Duration(d.cfg.sampleRate)
+	if int(event) >= len(dtmfDigits) {
+		return // Not a DTMF digit, e.g. a flash or a fax tone
+	}
+
+	if !d.rfcActive {
+		d.rfcActive = true
+		d.mu.Lock()
+		d.rfcSeen = true
+		d.mu.Unlock()
+	}
+	if !d.inEvent || timestamp != d.lastTS {
+		if d.inEvent && !d.reported {
+			d.queue(d.lastEvent) // Its end packets were lost
+		}
+		d.lastTS, d.inEvent, d.reported = timestamp, true, false
+	}
+	d.lastEvent = dtmfEvent{Digit: string(dtmfDigits[event]), Time: time.Now().Add(-dur), Duration: dur.Seconds(), Source: dtmfRFC4733, frame: frame}
+	if end && !d.reported {
+		d.reported = true
+		d.queue(d.lastEvent)
+	}
+}
+
+func (d *dtmfState) queue(ev dtmfEvent) {
+	d.mu.Lock()
+	d.pending = append(d.pending, ev)
+	d.mu.Unlock()
+}
+
+// feed runs the in-band detector on audio, which starts at the given frame
+// of the stream, unless the stream sends telephone-events. It returns the
+// digits that ended before that frame, or all of them with flush, and the
+// -dtmf-hook sequences they complete.
+func (d *dtmfState) feed(samples []int, frame int64, flush bool) ([]dtmfEvent, []string) {
+	d.mu.Lock()
+	rfc := d.rfcSeen
+	d.mu.Unlock()
+	if !rfc && samples != nil {
+		if ev := d.inband.feed(samples, frame); ev != nil {
+			d.queue(*ev)
+		}
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var ready, matched []string
+	var events []dtmfEvent
+	kept := d.pending[:0]
+	for _, ev := range d.pending {
+		if ev.frame > frame && !flush {
+			kept = append(kept, ev)
+			continue
+		}
+		events = append(events, ev)
+		ready = append(ready, ev.Digit)
+	}
+	d.pending = kept
+
+	for _, digit := range ready {
+		d.history += digit
+		if len(d.history) > dtmfHistoryLen {
+			d.history = d.history[len(d.history)-dtmfHistoryLen:]
+		}
+		for _, seq := range d.cfg.dtmfHooks.sequences() {
+			if strings.HasSuffix(d.history, seq) {
+				matched = append(matched, seq)
+				d.history = "" // A digit completes at most one sequence
+				break
+			}
+		}
+	}
+	return events, matched
+}
+
+// runDTMFHook runs the -dtmf-hook command for a sequence sent by a stream.
+// {digits}, {session}, {addr} and {file} are replaced with shell quoted
+// values.
+func runDTMFHook(command, digits string, c *Client, file string) {
+	cmdline := strings.NewReplacer(
+		"{digits}", shellQuote(digits),
+		"{session}", shellQuote(c.session),
+		"{addr}", shellQuote(c.addr),
+		"{file}", shellQuote(file),
+	).Replace(command)
+
+	sp := c.span.child("dtmf.hook")
+	sp.set("digits", digits)
+	out, err := exec.Command("sh", "-c", cmdline).CombinedOutput()
+	sp.fail(err)
+	sp.finish()
+	if len(out) > 0 {
+		c.log.Info("☎️  DTMF hook output", "digits", digits, "output", string(out))
+	}
+	if err != nil {
+		c.log.Warn("DTMF hook failed", "digits", digits, "err", err)
+	}
+}
+
+// dtmfDetector finds DTMF tones in audio with the Goertzel algorithm. A digit
+// must be present in two consecutive windows, about 50 ms, and is reported
+// once it stops.
+type dtmfDetector struct {
+	channels  int
+	block     int // Frames per window
+	fullScale float64
+	coeffs    [8]float64 // Goertzel coefficients of the row and column tones
+
+	buf     []float64 // Mono samples of the current window
+	candid  byte      // Digit of the previous window
+	current byte      // Confirmed digit still sounding
+	blocks  int       // Windows the current digit has lasted
+	started time.Time
+}
+
+func newDTMFDetector(cfg *Config) *dtmfDetector {
+	d := &dtmfDetector{
+		channels:  cfg.channels,
+		block:     int(int64(cfg.sampleRate) * int64(dtmfBlock) / int64(time.Second)),
+		fullScale: float64(int(1) << (cfg.bitDepth - 1)),
+	}
+	for i, f := range append(dtmfRows[:], dtmfCols[:]...) {
+		d.coeffs[i] = 2 * math.Cos(2*math.Pi*f/float64(cfg.sampleRate))
+	}
+	return d
+}
+
+// feed analyzes interleaved samples, starting at the given frame of the
+// stream, and returns a digit that just ended.
+func (d *dtmfDetector) feed(samples []int, frame int64) *dtmfEvent {
+	var done *dtmfEvent
+	for i := 0; i+d.channels <= len(samples); i += d.channels {
+		var sum float64
+		for _, v := range samples[i : i+d.channels] {
+			sum += float64(v)
+		}
+		d.buf = append(d.buf, sum/float64(d.channels)/d.fullScale)
+		if len(d.buf) < d.block {
+			continue
+		}
+		if ev := d.window(d.detect()); ev != nil {
+			ev.frame = frame + int64(i/d.channels)
+			done = ev
+		}
+		d.buf = d.buf[:0]
+	}
+	return done
+}
+
+// detect returns the digit in the current window, or 0.
+func (d *dtmfDetector) detect() byte {
+	var energy float64
+	for _, x := range d.buf {
+		energy += x * x
+	}
+	n := float64(len(d.buf))
+	if energy == 0 || 10*math.Log10(energy/n) < dtmfMinLevel {
+		return 0
+	}
+
+	// Share of the window's energy at each frequency; a lone sinusoid at
+	// exactly that frequency has a share of 1
+	var share [8]float64
+	for k, coeff := range d.coeffs {
+		var s1, s2 float64
+		for _, x := range d.buf {
+			s1, s2 = x+coeff*s1-s2, s1
+		}
+		share[k] = 2 * (s1*s1 + s2*s2 - coeff*s1*s2) / (n * energy)
+	}
+	row, col := 0, 4
+	for k := 1; k < 4; k++ {
+		if share[k] > share[row] {
+			row = k
+		}
+		if share[4+k] > share[col] {
+			col = 4 + k
+		}
+	}
+	if share[row] < dtmfMinTone || share[col] < dtmfMinTone || share[row]+share[col] < dtmfMinPower {
+		return 0
+	}
+	return dtmfKeys[row][col-4]
+}
+
+// window advances the digit state by one analysis window.
+func (d *dtmfDetector) window(digit byte) *dtmfEvent {
+	var done *dtmfEvent
+	if d.current != 0 && digit != d.current {
+		dur := time.Duration(d.blocks) * dtmfBlock
+		done = &dtmfEvent{Digit: string(d.current), Time: d.started, Duration: dur.Seconds(), Source: dtmfInBand}
+		d.current = 0
+	}
+	switch {
+	case digit == d.current && digit != 0:
+		d.blocks++
+	case digit != 0 && digit == d.candid:
+		d.current, d.blocks = digit, 2
+		d.started = time.Now().Add(-2 * dtmfBlock)
+	}
+	d.candid = digit
+	return done
+}
+
+// dtmfHooks maps DTMF sequences to the shell commands run when a stream
+// sends them, given as -dtmf-hook SEQUENCE=COMMAND.
+type dtmfHooks map[string]string
+
+func (h *dtmfHooks) String() string {
+	if h == nil {
+		return ""
+	}
+	var parts []string
+	for _, seq := range h.sequences() {
+		parts = append(parts, seq+"="+(*h)[seq])
+	}
+	return strings.Join(parts, ",")
+}
+
+func (h *dtmfHooks) Set(s string) error {
+	seq, cmd, ok := strings.Cut(s, "=")
+	seq = strings.ToUpper(strings.TrimSpace(seq))
+	if !ok || seq == "" || cmd == "" || strings.Trim(seq, dtmfDigits) != "" || len(seq) > dtmfHistoryLen {
+		return fmt.Errorf("invalid DTMF hook %q (use e.g. *123#=command)", s)
+	}
+	if *h == nil {
+		*h = dtmfHooks{}
+	}
+	(*h)[seq] = cmd
+	return nil
+}
+
+// sequences returns the sequences, longest first, so the most specific one
+// matches when a sequence ends with another.
+func (h dtmfHooks) sequences() []string {
+	seqs := make([]string, 0, len(h))
+	for seq := range h {
+		seqs = append(seqs, seq)
+	}
+	sort.Slice(seqs, func(i, j int) bool {
+		if len(seqs[i]) != len(seqs[j]) {
+			return len(seqs[i]) > len(seqs[j])
+		}
+		return seqs[i] < seqs[j]
+	})
+	return seqs
+}
@@ -0,0 +1,96 @@
+package recorder
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"time"
+)
+
+// ffmpegWriter encodes interleaved PCM samples to Opus or FLAC by piping them
+// through an ffmpeg process, which muxes the result into a Matroska, WebM or
+// FLAC file.
+type ffmpegWriter struct {
+	cmd      *exec.Cmd
+	stdin    io.WriteCloser
+	path     string
+	bitDepth int
+	buf      []byte
+
+	written int64     // PCM bytes handed to ffmpeg
+	sized   time.Time // Last time outSize was refreshed
+	outSize int64     // Size of the output file as last seen on disk
+}
+
+// newFFmpegWriter starts ffmpeg encoding to path with the codec arguments,
+// e.g. "-c:a libopus", in the given container ("matroska", "webm" or
+// "flac").
+func newFFmpegWriter(path, container string, codec []string, sampleRate, bitDepth, channels int, meta mkaMeta) (*ffmpegWriter, error) {
+	inputFormat := fmt.Sprintf("s%dle", bitDepth)
+	args := []string{"-hide_banner", "-loglevel", "error", "-nostdin",
+		"-f", inputFormat, "-ar", fmt.Sprint(sampleRate), "-ac", fmt.Sprint(channels), "-i", "pipe:0"}
+	args = append(args, codec...)
+	args = append(args,
+		"-metadata", "title="+meta.title,
+		"-metadata", "creation_time="+meta.start.UTC().Format(time.RFC3339),
+		"-f", container, "-y", path)
+	cmd := exec.Command("ffmpeg", args...)
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get stdin pipe for ffmpeg: %w", err)
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get stderr pipe for ffmpeg: %w", err)
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start ffmpeg: %w", err)
+	}
+
+	// Goroutine to log any errors from ffmpeg
+	go func() {
+		scanner := bufio.NewScanner(stderr)
+		for scanner.Scan() {
+			recordingLog.Warn("ffmpeg", "file", path, "stderr", scanner.Text())
+		}
+	}()
+
+	return &ffmpegWriter{cmd: cmd, stdin: stdin, path: path, bitDepth: bitDepth}, nil
+}
+
+// size returns the size of the encoded file. ffmpeg buffers internally, so
+// the value is refreshed from disk at most once a second.
+func (w *ffmpegWriter) size() int64 {
+	if time.Since(w.sized) >= time.Second {
+		w.sized = time.Now()
+		if info, err := os.Stat(w.path); err == nil {
+			w.outSize = info.Size()
+		}
+	}
+	return w.outSize
+}
+
+func (w *ffmpegWriter) write(samples []int) error {
+	w.buf = appendPCMLE(w.buf[:0], samples, w.bitDepth)
+	n, err := w.stdin.Write(w.buf)
+	w.written += int64(n)
+	if err != nil {
+		return fmt.Errorf("failed to write to ffmpeg: %w", err)
+	}
+	return nil
+}
+
+// syncHeader is a no-op: ffmpeg writes the file as a stream.
+func (w *ffmpegWriter) syncHeader() error { return nil }
+
+// close ends the input and waits for ffmpeg to finish writing the file.
+func (w *ffmpegWriter) close() error {
+	w.stdin.Close()
+	if err := w.cmd.Wait(); err != nil {
+		return fmt.Errorf("ffmpeg failed: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,119 @@
+package recorder
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// fileEntry is a recording on disk as listed on GET /files.
+type fileEntry struct {
+	Name     string    `json:"name"` // Path relative to the output directory, with forward slashes
+	URL      string    `json:"url"`  // Download link
+	Bytes    int64     `json:"bytes"`
+	Modified time.Time `json:"modified"`
+	Active   bool      `json:"active"`          // Still being written
+	Peaks    string    `json:"peaks,omitempty"` // Link to the waveform peaks, with -waveform
+}
+
+// handleFiles lists the recordings under the output directory, newest
+// first, on GET /files (at most ?limit=, default 100), and serves a file
+// from it on GET /files/<name>[?format=mp3|flac|opus].
+func (s *server) handleFiles(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if name := strings.TrimPrefix(r.URL.Path, "/files"); name != "" && name != "/" {
+		s.serveFile(w, r, name)
+		return
+	}
+	s.listFiles(w, r, "/files/")
+}
+
+// handleDownload serves GET /recordings/<name>/download[?format=mp3|flac|opus],
+// the recording <name> under the output directory as GET /files/<name>
+// serves it, converted on the fly with ?format=.
+func (s *server) handleDownload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	name, ok := strings.CutSuffix(strings.TrimPrefix(r.URL.Path, "/recordings/"), "/download")
+	if !ok || name == "" {
+		http.NotFound(w, r)
+		return
+	}
+	s.serveFile(w, r, name)
+}
+
+// listFiles writes the recording list, linking each file under urlPrefix.
+func (s *server) listFiles(w http.ResponseWriter, r *http.Request, urlPrefix string) {
+	limit := 100
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			http.Error(w, fmt.Sprintf("invalid limit %q", v), http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+	recs, err := listRecordings(s.cfg.outDir, s.activeFiles())
+	if err != nil {
+		httpLog.Error("Listing recordings failed", "err", err)
+	}
+	entries := []fileEntry{}
+	for _, rec := range recs[:min(limit, len(recs))] {
+		rel, err := filepath.Rel(s.cfg.outDir, rec.path)
+		if err != nil {
+			continue
+		}
+		rel = filepath.ToSlash(rel)
+		e := fileEntry{Name: rel, URL: urlPrefix + rel, Bytes: rec.size, Modified: rec.modTime, Active: rec.active}
+		if _, err := os.Stat(peaksPath(rec.path)); err == nil {
+			e.Peaks = urlPrefix + filepath.ToSlash(peaksPath(rel))
+		}
+		entries = append(entries, e)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(entries); err != nil {
+		httpLog.Error("Encoding file list failed", "err", err)
+	}
+}
+
+// serveFile sends a file from the output directory, refusing anything
+// outside it. With ?format=, a recording is converted on the fly.
+func (s *server) serveFile(w http.ResponseWriter, r *http.Request, name string) {
+	p, ok := s.outDirPath(name)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	info, err := os.Stat(p)
+	if err != nil || !info.Mode().IsRegular() {
+		http.NotFound(w, r)
+		return
+	}
+	if format := r.URL.Query().Get("format"); format != "" {
+		s.serveTranscoded(w, r, p, format)
+		return
+	}
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filepath.Base(p)))
+	http.ServeFile(w, r, p)
+}
+
+// outDirPath maps a slash separated name relative to the output directory
+// to a file path, reporting false for names that would leave it.
+func (s *server) outDirPath(name string) (string, bool) {
+	clean := path.Clean("/" + name)
+	if clean == "/" || strings.Contains(clean, "\x00") {
+		return "", false
+	}
+	return filepath.Join(s.cfg.outDir, filepath.FromSlash(clean[1:])), true
+}
@@ -0,0 +1,40 @@
+package recorder
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestDownload fetches a recording from /recordings/<name>/download, as is
+// or with a format it can't be converted to, and paths that aren't
+// downloads.
+func TestDownload(t *testing.T) {
+	s := newTestServer(t)
+	if err := os.MkdirAll(filepath.Join(s.cfg.outDir, "day"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(s.cfg.outDir, "day", "a.wav"), []byte("RIFF"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		path string
+		code int
+		body string
+	}{
+		{"/recordings/day/a.wav/download", http.StatusOK, "RIFF"},
+		{"/recordings/day/a.wav/download?format=aiff", http.StatusBadRequest, "unsupported format \"aiff\": use mp3, flac or opus\n"},
+		{"/recordings/day/b.wav/download", http.StatusNotFound, ""},
+		{"/recordings/day/a.wav", http.StatusNotFound, ""},
+		{"/recordings//download", http.StatusNotFound, ""},
+		{"/recordings/../../etc/passwd/download", http.StatusNotFound, ""},
+	} {
+		rec := httptest.NewRecorder()
+		s.handleDownload(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+		if rec.Code != tt.code || tt.body != "" && rec.Body.String() != tt.body {
+			t.Errorf("GET %s: %d %q, want %d %q", tt.path, rec.Code, rec.Body.String(), tt.code, tt.body)
+		}
+	}
+}
@@ -0,0 +1,265 @@
+package recorder
+
+import (
+	"encoding/json"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var finalizeLog = logger("finalize")
+
+// finishedFile describes a recording file that has just been finalized. It is
+// written next to the recording as a JSON metadata sidecar.
+type finishedFile struct {
+	Path        string       `json:"file"`
+	Addr        string       `json:"addr"`
+	SSRC        uint32       `json:"ssrc"`
+	Session     string       `json:"session"`
+	Part        int          `json:"part"`
+	Start       time.Time    `json:"start"`
+	End         time.Time    `json:"end"`
+	Duration    float64      `json:"duration_seconds"`
+	Bytes       int64        `json:"bytes"`
+	Format      string       `json:"format"`
+	Codec       string       `json:"codec"`
+	SampleRate  int          `json:"sample_rate"`
+	BitDepth    int          `json:"bit_depth"`
+	Channels    int          `json:"channels"`
+	SHA256      string       `json:"sha256,omitempty"`
+	Tracks      []string     `json:"tracks,omitempty"`  // Latest source of each track of a multitrack recording
+	Gaps        []gap        `json:"gaps,omitempty"`    // Silences left out by -trim-silence
+	DTMF        []dtmfEvent  `json:"dtmf,omitempty"`    // Digits received while recording, with -dtmf
+	RTT         string       `json:"rtt,omitempty"`     // Subtitles of the real-time text received, with -t140
+	Peaks       string       `json:"peaks,omitempty"`   // Waveform peaks, with -waveform
+	RTPDump     string       `json:"rtpdump,omitempty"` // Raw RTP packets, with -rtpdump
+	Loudness    *loudness    `json:"loudness,omitempty"`
+	Fingerprint *fingerprint `json:"fingerprint,omitempty"`
+	Metadata    *sessionMeta `json:"metadata,omitempty"` // Told by the client, with -metadata-addr
+
+	span *span // Of the recording of the file, which its post-recording steps are traced in
+}
+
+// log returns the logger for messages about the file.
+func (ff *finishedFile) log() *slog.Logger {
+	return finalizeLog.With("session", ff.Session, "addr", ff.Addr, "file", ff.Path)
+}
+
+// sidecarPath returns the path of the metadata sidecar for a recording.
+func sidecarPath(recording string) string {
+	return recording + ".json"
+}
+
+// finalizer runs the post-recording steps for every finalized file: it
+// checksums it, writes the metadata sidecar and the manifest entry,
+// transcribes it, runs the -on-close hook and uploads the file and its
+// companions.
+type finalizer struct {
+	cfg      *Config
+	disk     *diskUsage
+	up       *uploader // nil unless -upload is set
+	cat      *catalog
+	mqtt     *mqttPublisher
+	manifest *manifest
+	tr       *transcriber   // nil unless -transcribe is set
+	fp       *fingerprinter // nil unless -fingerprint is set
+	pending  sync.WaitGroup // Files whose post-recording steps are still running
+	active   atomic.Int64   // The number of them, for /debug/vars
+}
+
+// finalized is called by a client's writer goroutine once a file is closed.
+// The steps run in the background so recording carries on.
+func (f *finalizer) finalized(ff finishedFile) {
+	f.pending.Add(1)
+	f.active.Add(1)
+	go func() {
+		defer f.pending.Done()
+		defer f.active.Add(-1)
+		sp := ff.span.child("finalize")
+		sp.set("file", ff.Path)
+		defer sp.finish()
+
+		// Normalize first, so the checksum and everything after it see the
+		// final file
+		if f.cfg.normalize != 0 {
+			f.normalize(&ff, sp.child("normalize"))
+		}
+		if f.fp != nil && !f.identify(&ff, sp.child("fingerprint")) {
+			return
+		}
+
+		checksum := sp.child("checksum")
+		sum, err := fileSHA256(ff.Path)
+		checksum.fail(err)
+		checksum.finish()
+		if err != nil {
+			ff.log().Warn("Checksumming failed", "err", err)
+		} else {
+			ff.SHA256 = sum
+			if err := f.manifest.add(ff.Path, sum); err != nil {
+				ff.log().Warn("Adding to the manifest failed", "err", err)
+			}
+		}
+
+		meta := sidecarPath(ff.Path)
+		if err := writeSidecar(meta, ff); err != nil {
+			ff.log().Warn("Writing metadata failed", "err", err)
+		}
+		f.cat.fileFinished(ff)
+		f.mqtt.fileFinished(ff)
+		if f.tr != nil {
+			tr := sp.child("transcribe")
+			tr.fail(f.tr.transcribe(&ff))
+			tr.finish()
+		}
+
+		// The hook runs first, so it still finds the file when it is
+		// deleted after uploading
+		if f.cfg.onClose != "" {
+			hook := sp.child("hook")
+			hook.fail(runHook(f.cfg.onClose, ff, meta))
+			hook.finish()
+		}
+		if f.up != nil {
+			f.upload(ff.Path, sp.child("upload"))
+		}
+	}()
+}
+
+// normalize rewrites the recording at its target loudness, keeping the
+// original if anything goes wrong.
+func (f *finalizer) normalize(ff *finishedFile, sp *span) {
+	defer sp.finish()
+	l, err := normalizeLoudness(f.cfg, ff)
+	if err != nil {
+		sp.fail(err)
+		ff.log().Warn("Normalizing failed", "err", err)
+		return
+	}
+	ff.Loudness = l
+	if info, err := os.Stat(ff.Path); err == nil {
+		f.disk.add(info.Size() - ff.Bytes)
+		ff.Bytes = info.Size()
+	}
+	ff.log().Info("🔊 Normalized", "input_lufs", l.InputI, "output_lufs", l.OutputI)
+}
+
+// identify fingerprints the recording and reports whether to keep it: with
+// -dedupe, a duplicate of an earlier recording is deleted instead.
+func (f *finalizer) identify(ff *finishedFile, sp *span) bool {
+	defer sp.finish()
+	fp, err := f.fp.fingerprint(ff.Path)
+	if err != nil {
+		sp.fail(err)
+		ff.log().Warn("Fingerprinting failed", "err", err)
+		return true
+	}
+	ff.Fingerprint = fp
+	if m := fp.AcoustID; m != nil && len(m.Recordings) > 0 {
+		ff.log().Info("🎵 Identified", "title", m.Recordings[0].Title, "score", m.Score)
+	}
+	if fp.DuplicateOf == "" {
+		return true
+	}
+	sp.set("duplicate_of", fp.DuplicateOf)
+	if !f.cfg.dedupe {
+		ff.log().Info("👯 Duplicate recording", "duplicate_of", fp.DuplicateOf)
+		return true
+	}
+	if err := os.Remove(ff.Path); err != nil {
+		ff.log().Warn("Removing duplicate failed", "err", err)
+		return true
+	}
+	f.disk.add(-ff.Bytes)
+	for _, path := range companionFiles(ff.Path) {
+		os.Remove(path)
+	}
+	removeEmptyDirs(filepath.Dir(ff.Path), f.cfg.outDir)
+	ff.log().Info("👯 Removed duplicate recording", "duplicate_of", fp.DuplicateOf)
+	return false
+}
+
+// upload copies the recording and its companion files to the bucket and,
+// with -upload-delete, removes the local copies once all of them made it.
+func (f *finalizer) upload(recording string, sp *span) {
+	defer sp.finish()
+	files := []string{recording}
+	for _, path := range companionFiles(recording) {
+		if _, err := os.Stat(path); err == nil {
+			files = append(files, path)
+		}
+	}
+
+	sp.set("destination", f.up.destination())
+	start := time.Now()
+	for _, path := range files {
+		if err := f.up.upload(path, sp.child("upload.file")); err != nil {
+			sp.fail(err)
+			finalizeLog.Error("Upload failed", "file", path, "err", err)
+			return
+		}
+	}
+	finalizeLog.Info("☁️  Uploaded", "file", recording, "destination", f.up.destination(), "took", time.Since(start))
+
+	if !f.cfg.uploadDelete {
+		return
+	}
+	for _, path := range files {
+		info, err := os.Stat(path)
+		if err == nil {
+			err = os.Remove(path)
+		}
+		if err != nil {
+			finalizeLog.Warn("Removing uploaded file failed", "file", path, "err", err)
+			continue
+		}
+		f.disk.add(-info.Size())
+	}
+	f.cat.fileRemoved(recording)
+}
+
+// wait blocks until the post-recording steps of all files have finished.
+func (f *finalizer) wait() {
+	f.pending.Wait()
+}
+
+func writeSidecar(path string, ff finishedFile) error {
+	data, err := json.MarshalIndent(ff, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0o644)
+}
+
+// runHook runs the -on-close command through sh, with the placeholders
+// {file}, {meta}, {session} and {addr} replaced by shell-quoted values.
+func runHook(command string, ff finishedFile, meta string) error {
+	cmdline := strings.NewReplacer(
+		"{file}", shellQuote(ff.Path),
+		"{meta}", shellQuote(meta),
+		"{session}", shellQuote(ff.Session),
+		"{addr}", shellQuote(ff.Addr),
+	).Replace(command)
+
+	start := time.Now()
+	out, err := exec.Command("sh", "-c", cmdline).CombinedOutput()
+	if len(out) > 0 {
+		ff.log().Info("🪝 on-close output", "output", string(out))
+	}
+	if err != nil {
+		ff.log().Warn("on-close hook failed", "took", time.Since(start), "err", err)
+		return err
+	}
+	ff.log().Info("🪝 on-close hook finished", "took", time.Since(start))
+	return nil
+}
+
+// shellQuote quotes s for safe use as a single sh argument.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
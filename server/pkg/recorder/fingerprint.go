@@ -0,0 +1,203 @@
+package recorder
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/bits"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"strconv"
+	"time"
+)
+
+const (
+	fingerprintMatch     = 0.8 // Fraction of identical fingerprint bits above which two files are duplicates
+	fingerprintMaxOffset = 40  // Fingerprint items (about 5s) two files may be shifted by
+)
+
+// fingerprint identifies the audio content of a recording.
+type fingerprint struct {
+	Chromaprint string         `json:"chromaprint"` // Compressed fingerprint, as used by AcoustID
+	Duration    float64        `json:"duration_seconds"`
+	AcoustID    *acoustidMatch `json:"acoustid,omitempty"`     // Best AcoustID match, with -acoustid
+	DuplicateOf string         `json:"duplicate_of,omitempty"` // Earlier recording with the same audio, from the catalog
+
+	raw []uint32 // Uncompressed fingerprint, for comparing recordings
+}
+
+// acoustidMatch is an AcoustID track and the recordings linked to it.
+type acoustidMatch struct {
+	ID         string              `json:"id"`
+	Score      float64             `json:"score"`
+	Recordings []acoustidRecording `json:"recordings,omitempty"`
+}
+
+type acoustidRecording struct {
+	ID      string   `json:"id"` // MusicBrainz recording ID
+	Title   string   `json:"title,omitempty"`
+	Artists []string `json:"artists,omitempty"`
+}
+
+// fingerprinter computes Chromaprint fingerprints of finished recordings
+// with fpcalc, looks them up on AcoustID with -acoustid and finds earlier
+// recordings of the same audio in the catalog.
+type fingerprinter struct {
+	cfg    *Config
+	cat    *catalog
+	client *http.Client
+}
+
+func newFingerprinter(cfg *Config, cat *catalog) *fingerprinter {
+	return &fingerprinter{cfg: cfg, cat: cat, client: &http.Client{Timeout: 30 * time.Second}}
+}
+
+// fingerprint computes the fingerprint of a recording, logging lookup
+// failures but returning any that leave it without a fingerprint.
+func (fp *fingerprinter) fingerprint(recording string) (*fingerprint, error) {
+	// fpcalc prints either the compressed or the raw fingerprint
+	var compressed struct {
+		Duration    float64 `json:"duration"`
+		Fingerprint string  `json:"fingerprint"`
+	}
+	var raw struct {
+		Fingerprint []int64 `json:"fingerprint"`
+	}
+	if err := fpcalc(recording, &compressed); err != nil {
+		return nil, err
+	}
+	if err := fpcalc(recording, &raw, "-raw"); err != nil {
+		return nil, err
+	}
+	f := &fingerprint{Chromaprint: compressed.Fingerprint, Duration: compressed.Duration}
+	for _, v := range raw.Fingerprint {
+		f.raw = append(f.raw, uint32(v))
+	}
+
+	if fp.cfg.acoustid {
+		m, err := fp.lookup(f)
+		if err != nil {
+			finalizeLog.Warn("AcoustID lookup failed", "file", recording, "err", err)
+		}
+		f.AcoustID = m
+	}
+	f.DuplicateOf = fp.cat.duplicateOf(recording, f)
+	return f, nil
+}
+
+// fpcalc runs Chromaprint's fpcalc tool on a file and decodes its JSON
+// output into v.
+func fpcalc(path string, v any, args ...string) error {
+	out, err := exec.Command("fpcalc", append(append([]string{"-json"}, args...), path)...).Output()
+	if err != nil {
+		if ee, ok := err.(*exec.ExitError); ok && len(ee.Stderr) > 0 {
+			return fmt.Errorf("fpcalc: %v: %s", err, lastLine(ee.Stderr))
+		}
+		return fmt.Errorf("fpcalc: %w", err)
+	}
+	if err := json.Unmarshal(out, v); err != nil {
+		return fmt.Errorf("parsing fpcalc output: %w", err)
+	}
+	return nil
+}
+
+// lookup asks AcoustID for the track matching a fingerprint. It returns nil
+// if there is no match.
+func (fp *fingerprinter) lookup(f *fingerprint) (*acoustidMatch, error) {
+	form := url.Values{
+		"client":      {os.Getenv("ACOUSTID_API_KEY")},
+		"meta":        {"recordings"},
+		"duration":    {strconv.Itoa(int(f.Duration))},
+		"fingerprint": {f.Chromaprint},
+	}
+	resp, err := fp.client.PostForm(fp.cfg.acoustidURL, form)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	var result struct {
+		Status string `json:"status"`
+		Error  struct {
+			Message string `json:"message"`
+		} `json:"error"`
+		Results []struct {
+			ID         string  `json:"id"`
+			Score      float64 `json:"score"`
+			Recordings []struct {
+				ID      string `json:"id"`
+				Title   string `json:"title"`
+				Artists []struct {
+					Name string `json:"name"`
+				} `json:"artists"`
+			} `json:"recordings"`
+		} `json:"results"`
+	}
+	if err := json.Unmarshal(body, &result); err != nil {
+		return nil, fmt.Errorf("HTTP %d: invalid response", resp.StatusCode)
+	}
+	if result.Status != "ok" {
+		return nil, fmt.Errorf("HTTP %d: %s", resp.StatusCode, result.Error.Message)
+	}
+
+	// Results come best first
+	if len(result.Results) == 0 {
+		return nil, nil
+	}
+	best := result.Results[0]
+	m := &acoustidMatch{ID: best.ID, Score: best.Score}
+	for _, r := range best.Recordings {
+		rec := acoustidRecording{ID: r.ID, Title: r.Title}
+		for _, a := range r.Artists {
+			rec.Artists = append(rec.Artists, a.Name)
+		}
+		m.Recordings = append(m.Recordings, rec)
+	}
+	return m, nil
+}
+
+// fingerprintSimilarity returns the fraction of identical bits between two
+// raw fingerprints at the best alignment within fingerprintMaxOffset, or 0
+// if they overlap by less than half the shorter one.
+func fingerprintSimilarity(a, b []uint32) float64 {
+	minOverlap := max(1, min(len(a), len(b))/2)
+	best := 0.0
+	for offset := -fingerprintMaxOffset; offset <= fingerprintMaxOffset; offset++ {
+		// a[i] lines up with b[i+offset]
+		start, end := max(0, -offset), min(len(a), len(b)-offset)
+		if end-start < minOverlap {
+			continue
+		}
+		diff := 0
+		for i := start; i < end; i++ {
+			diff += bits.OnesCount32(a[i] ^ b[i+offset])
+		}
+		best = max(best, 1-float64(diff)/float64(32*(end-start)))
+	}
+	return best
+}
+
+// encodeFingerprint and decodeFingerprint store raw fingerprints in the
+// catalog as little-endian uint32s.
+func encodeFingerprint(raw []uint32) []byte {
+	b := make([]byte, 4*len(raw))
+	for i, v := range raw {
+		binary.LittleEndian.PutUint32(b[4*i:], v)
+	}
+	return b
+}
+
+func decodeFingerprint(b []byte) []uint32 {
+	raw := make([]uint32, len(b)/4)
+	for i := range raw {
+		raw[i] = binary.LittleEndian.Uint32(b[4*i:])
+	}
+	return raw
+}
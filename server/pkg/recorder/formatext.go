@@ -0,0 +1,51 @@
+package recorder
+
+import (
+	"github.com/pion/rtp"
+
+	"github.com/fcerini/audio-capture-shared/pkg/formatext"
+)
+
+// parseFormatExtension returns the format the data of the client's
+// -format-ext (see package formatext) describes, or false when it isn't one
+// the server decodes.
+func parseFormatExtension(data []byte) (payloadFormat, bool) {
+	ext, ok := formatext.Unmarshal(data)
+	if !ok {
+		return payloadFormat{}, false
+	}
+	switch ext.Encoding {
+	case encodingL16, encodingL24, encodingPCMU, encodingPCMA:
+	default:
+		return payloadFormat{}, false
+	}
+	if ext.SampleRate > 384000 || ext.Channels > 8 {
+		return payloadFormat{}, false
+	}
+	return payloadFormat{encoding: ext.Encoding, sampleRate: ext.SampleRate, channels: ext.Channels}, true
+}
+
+// signaledFormat takes the format extension of a packet from src, if it has
+// one: the packets of its payload type are decoded in the format it describes
+// from then on, in place of the one of the payload type.
+func (s *server) signaledFormat(packet *rtp.Packet, src *source) {
+	if !packet.Extension || src.linear {
+		return
+	}
+	data := packet.GetExtension(formatext.ID)
+	if data == nil {
+		return
+	}
+	f, ok := parseFormatExtension(data)
+	if !ok {
+		if !src.badFormat {
+			src.badFormat = true
+			ingestLog.Warn("Ignoring a format extension the server can't decode", "addr", src.name, "format", string(data))
+		}
+		return
+	}
+	if src.signaled == nil || *src.signaled != f {
+		ingestLog.Info("📐 Stream signaled its format", "addr", src.name, "pt", packet.PayloadType, "format", f.String())
+	}
+	src.signaled, src.signaledPT = &f, packet.PayloadType
+}
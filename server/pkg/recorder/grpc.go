@@ -0,0 +1,247 @@
+package recorder
+
+import (
+	"context"
+	"net"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/fcerini/audio-capture-shared/audiopb"
+)
+
+var grpcLog = logger("grpc")
+
+// grpcService implements the AudioCapture gRPC service of audiopb, giving
+// programs access to the live audio of the sessions.
+type grpcService struct {
+	audiopb.UnimplementedAudioCaptureServer
+	srv *server
+}
+
+// serveGRPC serves the gRPC API, the AudioCapture and Control services, on
+// addr, over TLS with -tls-cert. With API_TOKEN set, calls need it as a
+// bearer token in the authorization metadata, as on the REST API. It stops when ctx is done, ending the
+// subscriptions still open.
+func (s *server) serveGRPC(ctx context.Context, addr string) {
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		grpcLog.Error("gRPC server failed", "err", err)
+		return
+	}
+	opts := []grpc.ServerOption{
+		grpc.UnaryInterceptor(func(ctx context.Context, req any, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+			if err := grpcAuthorize(ctx); err != nil {
+				return nil, err
+			}
+			return handler(ctx, req)
+		}),
+		grpc.StreamInterceptor(func(srv any, ss grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+			if err := grpcAuthorize(ss.Context()); err != nil {
+				return err
+			}
+			return handler(srv, ss)
+		}),
+	}
+	scheme := "plain text"
+	if s.cfg.tls != nil {
+		opts = append(opts, grpc.Creds(credentials.NewTLS(s.cfg.tls)))
+		scheme = "TLS"
+	}
+	g := grpc.NewServer(opts...)
+	audiopb.RegisterAudioCaptureServer(g, &grpcService{srv: s})
+	audiopb.RegisterControlServer(g, &controlService{srv: s})
+	grpcLog.Info("📡 Serving the gRPC API", "addr", addr, "transport", scheme)
+	go func() {
+		<-ctx.Done()
+		g.Stop()
+	}()
+	if err := g.Serve(lis); err != nil {
+		grpcLog.Error("gRPC server failed", "err", err)
+	}
+}
+
+func grpcAuthorize(ctx context.Context) error {
+	if apiToken() == "" {
+		return nil
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	for _, v := range md.Get("authorization") {
+		if got, ok := strings.CutPrefix(v, "Bearer "); ok && validToken(got) {
+			return nil
+		}
+	}
+	return status.Error(codes.Unauthenticated, "missing or wrong API token")
+}
+
+func (g *grpcService) ListSessions(context.Context, *audiopb.ListSessionsRequest) (*audiopb.ListSessionsResponse, error) {
+	resp := &audiopb.ListSessionsResponse{}
+	for _, st := range g.srv.sessions("") {
+		resp.Sessions = append(resp.Sessions, &audiopb.Session{
+			Id:    st.Session,
+			Addr:  st.Addr,
+			Ssrc:  st.SSRC,
+			Start: timestamppb.New(st.Start),
+			File:  st.File,
+		})
+	}
+	return resp, nil
+}
+
+func (g *grpcService) Subscribe(req *audiopb.SubscribeRequest, stream audiopb.AudioCapture_SubscribeServer) error {
+	cfg := g.srv.cfg
+	encoding := req.Encoding
+	if encoding == audiopb.Encoding_ENCODING_UNSPECIFIED {
+		encoding = audiopb.Encoding_ENCODING_PCM
+	}
+	if encoding != audiopb.Encoding_ENCODING_PCM && encoding != audiopb.Encoding_ENCODING_OPUS {
+		return status.Errorf(codes.InvalidArgument, "unknown encoding %v", req.Encoding)
+	}
+
+	c := g.srv.findSession(req.Session)
+	if c == nil {
+		return status.Errorf(codes.NotFound, "no session %q", req.Session)
+	}
+	sub := c.live.add()
+	if sub == nil {
+		return status.Errorf(codes.NotFound, "session %q has ended", req.Session)
+	}
+	defer c.live.remove(sub)
+
+	started := &audiopb.Started{
+		Session:    &audiopb.Session{Id: c.session, Addr: c.addr, Ssrc: c.ssrc, Start: timestamppb.New(c.start), File: c.fileName()},
+		Encoding:   encoding,
+		SampleRate: uint32(cfg.sampleRate),
+		Channels:   uint32(cfg.channels),
+		BitDepth:   uint32(cfg.bitDepth),
+	}
+	var enc *opusStream
+	if encoding == audiopb.Encoding_ENCODING_OPUS {
+		var err error
+		if enc, err = newOpusStream(cfg); err != nil {
+			return status.Errorf(codes.Unavailable, "can't encode Opus: %v", err)
+		}
+		defer enc.close()
+		started.SampleRate, started.BitDepth = opusRate, 0
+	}
+	if err := stream.Send(&audiopb.SessionEvent{Event: &audiopb.SessionEvent_Started{Started: started}}); err != nil {
+		return err
+	}
+	grpcLog.Info("📡 Subscribed", "peer", grpcPeer(stream.Context()), "session", c.session, "addr", c.addr)
+
+	levels := time.NewTicker(time.Second)
+	defer levels.Stop()
+	var (
+		buf        []byte
+		encDropped uint64    // Frames the Opus encoder had no room for
+		lastLevel  time.Time // Of the latest level sent
+	)
+	opusFrame := func(pkt opusPacket) *audiopb.SessionEvent {
+		return &audiopb.SessionEvent{Event: &audiopb.SessionEvent_Audio{Audio: &audiopb.AudioFrame{
+			Time:         timestamppb.New(pkt.time),
+			RtpTimestamp: pkt.timestamp,
+			Samples:      uint32(pkt.samples),
+			Data:         pkt.data,
+			Dropped:      c.live.droppedBy(sub) + encDropped,
+		}}}
+	}
+	for {
+		var ev *audiopb.SessionEvent
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case <-levels.C:
+			l := c.meter.current()
+			if l == nil || l.Time.Equal(lastLevel) {
+				continue
+			}
+			lastLevel = l.Time
+			ev = &audiopb.SessionEvent{Event: &audiopb.SessionEvent_Level{Level: &audiopb.Level{
+				Time:           timestamppb.New(l.Time),
+				PeakDbfs:       l.Peak,
+				RmsDbfs:        l.RMS,
+				ClippedSamples: uint32(l.Clipped),
+				State:          l.State,
+			}}}
+		case pkt, ok := <-enc.packets():
+			if !ok {
+				return status.Errorf(codes.Internal, "Opus encoder stopped: %v", enc.err())
+			}
+			ev = opusFrame(pkt)
+		case le, ok := <-sub.events:
+			switch {
+			case !ok:
+				// The session ended: send what is still being encoded
+				if enc != nil {
+					enc.finish()
+					for pkt := range enc.packets() {
+						if err := stream.Send(opusFrame(pkt)); err != nil {
+							return err
+						}
+					}
+				}
+				return stream.Send(&audiopb.SessionEvent{Event: &audiopb.SessionEvent_Ended{Ended: &audiopb.Ended{}}})
+			case le.dtmf != nil:
+				ev = &audiopb.SessionEvent{Event: &audiopb.SessionEvent_Dtmf{Dtmf: &audiopb.DtmfDigit{
+					Digit:           le.dtmf.Digit,
+					Time:            timestamppb.New(le.dtmf.Time),
+					DurationSeconds: le.dtmf.Duration,
+					Source:          le.dtmf.Source,
+				}}}
+			case le.text != nil:
+				ev = &audiopb.SessionEvent{Event: &audiopb.SessionEvent_Text{Text: &audiopb.TextLine{
+					Start: timestamppb.New(le.text.Start),
+					End:   timestamppb.New(le.text.End),
+					Text:  le.text.Text,
+				}}}
+			case enc != nil:
+				if !enc.encode(le.audio) {
+					encDropped += uint64(len(le.audio.samples) / cfg.channels)
+				}
+				continue
+			default:
+				// Sending marshals the message, so buf can be reused
+				buf = appendPCMLE(buf[:0], le.audio.samples, cfg.bitDepth)
+				ev = &audiopb.SessionEvent{Event: &audiopb.SessionEvent_Audio{Audio: &audiopb.AudioFrame{
+					Time:         timestamppb.New(le.audio.time),
+					RtpTimestamp: le.audio.timestamp,
+					Samples:      uint32(len(le.audio.samples) / cfg.channels),
+					Data:         buf,
+					Dropped:      c.live.droppedBy(sub),
+				}}}
+			}
+		}
+		if err := stream.Send(ev); err != nil {
+			return err
+		}
+	}
+}
+
+// findSession returns the client of a session, by ID or sender address.
+func (s *server) findSession(key string) *Client {
+	if c, ok := s.clients.get(key); ok {
+		return c
+	}
+	var found *Client
+	s.clients.each(func(_ string, c *Client) bool {
+		if c.session == key {
+			found = c
+		}
+		return found == nil
+	})
+	return found
+}
+
+func grpcPeer(ctx context.Context) string {
+	if p, ok := peer.FromContext(ctx); ok {
+		return p.Addr.String()
+	}
+	return "A client"
+}
@@ -0,0 +1,164 @@
+package recorder
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fcerini/audio-capture-shared/pkg/handshake"
+)
+
+// maxOffers is how many senders' offers of their format (see package
+// handshake) are kept; more start over.
+const maxOffers = 4096
+
+// How the server handles a sender that offers another format, -handshake.
+const (
+	handshakeReject = "reject"
+	handshakeAdapt  = "adapt"
+)
+
+// offer is the format a sender announced in its handshake.
+type offer struct {
+	version    int
+	software   string
+	encoding   string
+	sampleRate int
+	channels   int
+	bitDepth   int
+	declared   bool // By the server, for a -raw port, rather than the sender
+}
+
+// parseOffer decodes the pairs of a handshake offer.
+func parseOffer(pairs map[string]string) (offer, error) {
+	o := offer{version: 1}
+	var err error
+	for key, value := range pairs {
+		switch key {
+		case "v":
+			o.version, err = strconv.Atoi(value)
+		case "sw":
+			o.software = value
+		case "enc":
+			o.encoding = strings.ToUpper(value)
+		case "rate":
+			o.sampleRate, err = strconv.Atoi(value)
+		case "ch":
+			o.channels, err = strconv.Atoi(value)
+		case "bits":
+			o.bitDepth, err = strconv.Atoi(value)
+		}
+		if err != nil {
+			return offer{}, fmt.Errorf("invalid %s in handshake", key)
+		}
+	}
+	if o.encoding == "" || o.sampleRate <= 0 || o.channels <= 0 {
+		return offer{}, errors.New("incomplete handshake")
+	}
+	if o.bitDepth == 0 {
+		o.bitDepth = 16
+	}
+	return o, nil
+}
+
+// judge decides how to record a stream of the offered format: with cfg,
+// the server's, accepted; with a copy in the offered format, adapted, under
+// -handshake=adapt or for a declared format; or not at all, rejected for
+// reason.
+func (o offer) judge(cfg *Config) (result, reason string, adapted *Config) {
+	switch {
+	case o.version != handshake.Version:
+		return "rejected", fmt.Sprintf("handshake version %d is not %d", o.version, handshake.Version), nil
+	case o.encoding != "L16" && o.encoding != "L24":
+		return "rejected", fmt.Sprintf("encoding %s can't be recorded, only L16 and L24", o.encoding), nil
+	case o.encoding == "L16" && o.bitDepth != 16, o.encoding == "L24" && o.bitDepth != 24:
+		return "rejected", fmt.Sprintf("%d bits don't match encoding %s", o.bitDepth, o.encoding), nil
+	case o.channels > 8:
+		return "rejected", fmt.Sprintf("%d channels are more than 8", o.channels), nil
+	case o.sampleRate == cfg.sampleRate && o.channels == cfg.channels && o.bitDepth == cfg.bitDepth:
+		return "accepted", "", cfg
+	}
+	reason = fmt.Sprintf("%d Hz, %d channels and %d bits instead of -rate %d, -channels %d and -bits %d",
+		o.sampleRate, o.channels, o.bitDepth, cfg.sampleRate, cfg.channels, cfg.bitDepth)
+	if cfg.handshake != handshakeAdapt && !o.declared {
+		return "rejected", reason, nil
+	}
+	own := *cfg
+	own.sampleRate, own.channels, own.bitDepth = o.sampleRate, o.channels, o.bitDepth
+	return "adapted", reason, &own
+}
+
+// handleOffer takes the handshake of the sender at addr, keeping its offer
+// for its next session, and answers it.
+func (s *server) handleOffer(addr string, pairs map[string]string) {
+	o, err := parseOffer(pairs)
+	if err != nil {
+		ingestLog.Warn("Ignoring a handshake", "addr", addr, "err", err)
+		return
+	}
+	result, reason, _ := o.judge(s.cfg)
+
+	s.clientsMutex.Lock()
+	prev, known := s.offers[addr]
+	if !known && len(s.offers) >= maxOffers {
+		s.offers = make(map[string]offer)
+	}
+	s.offers[addr] = o
+	c, _ := s.clients.get(addr)
+	s.clientsMutex.Unlock()
+
+	if !known || prev != o {
+		log := ingestLog.With("addr", addr, "software", o.software, "encoding", o.encoding, "rate", o.sampleRate, "channels", o.channels, "bits", o.bitDepth)
+		switch {
+		case c != nil && (c.cfg.sampleRate != o.sampleRate || c.cfg.channels != o.channels || c.cfg.bitDepth != o.bitDepth):
+			// Its first packets came before the handshake, which applies
+			// from its next session on
+			c.log.Warn("🤝 Handshake arrived after the stream started, in another format than it is recorded in", "result", result, "reason", reason)
+		case result == "rejected":
+			log.Warn("🤝 Rejecting the stream's format", "reason", reason)
+		case result == "adapted":
+			log.Info("🤝 Recording the stream in its own format", "reason", reason)
+		default:
+			log.Info("🤝 Handshake")
+		}
+	}
+	s.answerOffer(addr, result, reason)
+}
+
+// answerOffer sends the sender at addr the server's format and the result
+// of its handshake.
+func (s *server) answerOffer(addr, result, reason string) {
+	to, err := netip.ParseAddrPort(addr)
+	if err != nil {
+		return
+	}
+	data := fmt.Sprintf("v=%d;sw=audio-capture-server;enc=L%d;rate=%d;ch=%d;bits=%d;result=%s",
+		handshake.Version, s.cfg.bitDepth, s.cfg.sampleRate, s.cfg.channels, s.cfg.bitDepth, result)
+	if reason != "" {
+		data += ";reason=" + handshake.Value(reason)
+	}
+	pkt := handshake.Marshal(0, handshake.Answer, data)
+	if err := s.reply(pkt, to); err != nil {
+		ingestLog.Debug("Answering a handshake failed", "addr", addr, "err", err)
+		return
+	}
+	s.pcap.WriteUDP(s.localAddr, to, pkt, time.Now())
+}
+
+// offered returns the configuration to record the stream from addr with,
+// after its handshake, or nil and the reason to refuse it. The caller holds
+// clientsMutex.
+func (s *server) offered(addr string) (*Config, string) {
+	o, ok := s.offers[addr]
+	if !ok {
+		return s.cfg, ""
+	}
+	_, reason, cfg := o.judge(s.cfg)
+	if cfg == nil {
+		return nil, "handshake: " + reason
+	}
+	return cfg, ""
+}
@@ -0,0 +1,65 @@
+package recorder
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+)
+
+// Probe endpoints for orchestrators and load balancers. They are served
+// without the API token, as probes can't send one, and reveal nothing but
+// the state of the checks.
+const (
+	healthPath = "/healthz"
+	readyPath  = "/readyz"
+)
+
+// handleHealth serves /healthz: the process is up and serving HTTP.
+func handleHealth(w http.ResponseWriter, r *http.Request) {
+	writeProbe(w, map[string]string{"http": "ok"})
+}
+
+// handleReady serves /readyz: the server can record, that is the UDP read
+// loop is running and new files can be written to the output directory.
+func (s *server) handleReady(w http.ResponseWriter, r *http.Request) {
+	checks := map[string]string{"listener": "ok", "disk": "ok"}
+	if int(s.receiving.Load()) < len(s.listeners) {
+		checks["listener"] = "not receiving"
+	}
+	if s.quotaExceeded() {
+		checks["disk"] = "quota exceeded"
+	} else if err := checkWritable(s.cfg.outDir); err != nil {
+		checks["disk"] = err.Error()
+	}
+	writeProbe(w, checks)
+}
+
+// checkWritable creates and removes a file in dir.
+func checkWritable(dir string) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(dir, ".readyz-*")
+	if err != nil {
+		return err
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
+
+// writeProbe responds 200 if every check is "ok" and 503 otherwise, with the
+// checks as JSON.
+func writeProbe(w http.ResponseWriter, checks map[string]string) {
+	status := "ok"
+	for _, c := range checks {
+		if c != "ok" {
+			status = "unavailable"
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if status != "ok" {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(map[string]any{"status": status, "checks": checks})
+}
@@ -0,0 +1,100 @@
+package recorder
+
+import (
+	"context"
+	"time"
+)
+
+// Recorder records streams like ListenAndRecord and hands them to a program
+// through its hooks as well, e.g. to transcribe the audio as it arrives. Every
+// session the server records is reported, the -mix and -multitrack
+// recordings included. Hooks left nil aren't called.
+//
+//	cfg, err := recorder.NewConfig(recorder.WithPort(6001), recorder.WithOutDir("recordings"))
+//	if err != nil {
+//		return err
+//	}
+//	r := &recorder.Recorder{
+//		Config: cfg,
+//		OnAudioFrame: func(s recorder.Stream, f recorder.AudioFrame) {
+//			stt.Feed(s.Session, f.Samples)
+//		},
+//	}
+//	return r.ListenAndRecord(ctx)
+type Recorder struct {
+	// Config is what the streams are recorded with, from NewConfig or
+	// ParseConfig. Nil is the defaults of NewConfig.
+	Config *Config
+
+	// OnStreamStart is called when a session starts, before its audio.
+	OnStreamStart func(s Stream)
+	// OnAudioFrame is called with each buffer of the session's decoded
+	// audio, in order, on the goroutine writing its file. A hook slower than
+	// real time holds up that session's file like a slow disk, until its
+	// queue fills and audio is dropped.
+	OnAudioFrame func(s Stream, f AudioFrame)
+	// OnStreamEnd is called once the session ended and its last file is
+	// closed.
+	OnStreamEnd func(s Stream)
+}
+
+// Stream describes a session of a stream being recorded.
+type Stream struct {
+	Session    string // Random ID of the session, as in the file names and the APIs
+	Addr       string // Of the sender, or mix or multitrack
+	SSRC       uint32
+	SampleRate int
+	BitDepth   int
+	Channels   int
+	Start      time.Time // When the first packet arrived
+}
+
+// AudioFrame is a buffer of a session's decoded audio.
+type AudioFrame struct {
+	Samples []int // Interleaved, of the stream's bit depth; only valid during the call
+	Offset  int64 // Frames of the session before these
+}
+
+// ListenAndRecord is like the package's ListenAndRecord with r.Config,
+// calling r's hooks.
+func (r *Recorder) ListenAndRecord(ctx context.Context) error {
+	cfg := r.Config
+	if cfg == nil {
+		var err error
+		if cfg, err = NewConfig(); err != nil {
+			return err
+		}
+	}
+	return listenAndRecord(ctx, cfg, r)
+}
+
+// stream describes a client's session for the hooks.
+func (c *Client) stream() Stream {
+	return Stream{
+		Session:    c.session,
+		Addr:       c.addr,
+		SSRC:       c.ssrc,
+		SampleRate: c.cfg.sampleRate,
+		BitDepth:   c.cfg.bitDepth,
+		Channels:   c.cfg.channels,
+		Start:      c.start,
+	}
+}
+
+func (r *Recorder) streamStarted(c *Client) {
+	if r != nil && r.OnStreamStart != nil {
+		r.OnStreamStart(c.stream())
+	}
+}
+
+func (r *Recorder) audioTaken(c *Client, samples []int, offset int64) {
+	if r != nil && r.OnAudioFrame != nil {
+		r.OnAudioFrame(c.stream(), AudioFrame{Samples: samples, Offset: offset})
+	}
+}
+
+func (r *Recorder) streamEnded(c *Client) {
+	if r != nil && r.OnStreamEnd != nil {
+		r.OnStreamEnd(c.stream())
+	}
+}
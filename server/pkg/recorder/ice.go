@@ -0,0 +1,168 @@
+package recorder
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/netip"
+	"sync"
+	"time"
+
+	"github.com/fcerini/audio-capture-shared/pkg/ice"
+	"github.com/fcerini/audio-capture-shared/pkg/stun"
+)
+
+var iceLog = logger("ice")
+
+const (
+	iceSessionTTL  = time.Minute            // How long the credentials of POST /ice answer checks
+	iceMaxSessions = 1024                   // Live sessions at most; the oldest goes to make room
+	icePunches     = 3                      // Packets sent to each client candidate, so a NAT in front of the server lets its checks in
+	icePunchGap    = 500 * time.Millisecond // Between them
+)
+
+// iceAgent is the server's side of ICE-lite (see package ice): POST /ice
+// takes a client's description and answers with the RTP port's candidates
+// and new credentials, which its checks are answered with until they
+// expire.
+type iceAgent struct {
+	s *server
+
+	mu       sync.Mutex
+	sessions map[string]iceSession // By the server's ufrag
+}
+
+type iceSession struct {
+	local   ice.Description
+	expires time.Time
+}
+
+func newICEAgent(s *server) *iceAgent {
+	return &iceAgent{s: s, sessions: make(map[string]iceSession)}
+}
+
+// handleICE answers POST /ice, with the client's description as JSON, with
+// the server's.
+func (a *iceAgent) handleICE(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var remote ice.Description
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10)).Decode(&remote); err != nil {
+		http.Error(w, "invalid description: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if remote.Ufrag == "" || remote.Pwd == "" {
+		http.Error(w, "invalid description: missing ufrag or pwd", http.StatusBadRequest)
+		return
+	}
+
+	local := a.candidates(r)
+	a.mu.Lock()
+	now := time.Now()
+	for ufrag, session := range a.sessions {
+		if now.After(session.expires) {
+			delete(a.sessions, ufrag)
+		}
+	}
+	for len(a.sessions) >= iceMaxSessions {
+		var oldest string
+		for ufrag, session := range a.sessions {
+			if oldest == "" || session.expires.Before(a.sessions[oldest].expires) {
+				oldest = ufrag
+			}
+		}
+		delete(a.sessions, oldest)
+	}
+	a.sessions[local.Ufrag] = iceSession{local: local, expires: now.Add(iceSessionTTL)}
+	a.mu.Unlock()
+
+	iceLog.Info("🧊 Offered ICE candidates", "client", r.RemoteAddr, "candidates", len(local.Candidates), "client_candidates", len(remote.Candidates))
+	go a.punch(remote)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(local)
+}
+
+// candidates returns a new description of the RTP port: the address the
+// request came in at first, then those of -listen or of the interfaces, and
+// the reflexive address of -stun, if learned, last.
+func (a *iceAgent) candidates(r *http.Request) ice.Description {
+	d := ice.NewDescription()
+	d.Lite = true
+	port := int(a.s.localAddr.Port())
+	network, _ := listenNetwork(a.s.cfg.listen, port)
+	seen := map[netip.AddrPort]bool{}
+	add := func(typ string, addr netip.AddrPort) {
+		addr = netip.AddrPortFrom(addr.Addr().Unmap(), addr.Port())
+		if seen[addr] || network == "udp4" && !addr.Addr().Is4() || network == "udp6" && !addr.Addr().Is6() {
+			return
+		}
+		seen[addr] = true
+		d.Add(typ, addr, len(seen))
+	}
+
+	if local, ok := r.Context().Value(http.LocalAddrContextKey).(net.Addr); ok {
+		if ap, err := netip.ParseAddrPort(local.String()); err == nil {
+			add(ice.Host, netip.AddrPortFrom(ap.Addr().WithZone(""), uint16(port)))
+		}
+	}
+	if ip, err := netip.ParseAddr(a.s.cfg.listen); err == nil && !ip.IsUnspecified() {
+		add(ice.Host, netip.AddrPortFrom(ip, uint16(port)))
+	} else {
+		for _, addr := range ice.HostAddrs(network, port) {
+			add(ice.Host, addr)
+		}
+	}
+	if n := a.s.nat; n != nil {
+		n.mu.Lock()
+		reflexive := n.reflexive
+		n.mu.Unlock()
+		if reflexive.IsValid() {
+			add(ice.Srflx, reflexive)
+		}
+	}
+	return d
+}
+
+// punch sends a STUN request, which needs no answer, from the RTP port to
+// each of the client's candidates a few times.
+func (a *iceAgent) punch(remote ice.Description) {
+	for i := 0; i < icePunches; i++ {
+		for _, c := range remote.Candidates {
+			if !c.Addr.IsValid() || !a.s.access.allowed(c.Addr.Addr()) {
+				continue
+			}
+			req, _ := stun.Request()
+			if _, err := a.s.listener.WriteToUDPAddrPort(req, c.Addr); err != nil {
+				iceLog.Debug("Punching towards an ICE candidate failed", "addr", c.Addr, "err", err)
+			}
+		}
+		time.Sleep(icePunchGap)
+	}
+}
+
+// answer answers the connectivity check req from addr, reporting whether
+// it is one of a live session's to answer.
+func (a *iceAgent) answer(req []byte, addr netip.AddrPort) bool {
+	ufrag, ok := ice.Ufrag(req)
+	if !ok {
+		return false
+	}
+	a.mu.Lock()
+	session, ok := a.sessions[ufrag]
+	a.mu.Unlock()
+	if !ok || time.Now().After(session.expires) {
+		iceLog.Debug("Dropped an ICE check of unknown credentials", "addr", addr)
+		return true
+	}
+	resp, err := ice.Answer(req, addr, session.local)
+	if err != nil {
+		iceLog.Debug("Dropped an ICE check", "addr", addr, "err", err)
+		return true
+	}
+	if _, err := a.s.listener.WriteToUDPAddrPort(resp, addr); err != nil {
+		iceLog.Debug("Answering an ICE check failed", "addr", addr, "err", err)
+	}
+	return true
+}
@@ -0,0 +1,162 @@
+package recorder
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+var janitorLog = logger("janitor")
+
+const janitorInterval = time.Minute
+
+// recording is a recording file found on disk.
+type recording struct {
+	path    string
+	size    int64
+	modTime time.Time
+	active  bool // Still being written by a connected client
+}
+
+// isRecordingFile reports whether path looks like a file written by the server.
+func isRecordingFile(path string) bool {
+	ext := strings.TrimPrefix(filepath.Ext(path), ".")
+	if _, registered := registeredFormat(ext); registered {
+		return true
+	}
+	return builtinFormat(ext)
+}
+
+// listRecordings walks the output directory and returns every recording,
+// newest first. Files listed in active are marked as still being written.
+func listRecordings(root string, active map[string]bool) ([]recording, error) {
+	var recs []recording
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			// A directory may disappear while we walk it; just skip it.
+			return nil
+		}
+		if d.IsDir() || !isRecordingFile(path) {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		recs = append(recs, recording{path: path, size: info.Size(), modTime: info.ModTime(), active: active[path]})
+		return nil
+	})
+	sort.Slice(recs, func(i, j int) bool { return recs[i].modTime.After(recs[j].modTime) })
+	return recs, err
+}
+
+// janitor periodically deletes finished recordings that fall outside the
+// retention policy or the disk quota, and keeps the disk usage total accurate.
+type janitor struct {
+	cfg    *Config
+	disk   *diskUsage
+	cat    *catalog
+	active func() map[string]bool // Returns the paths of files currently being written
+}
+
+// run sweeps once immediately and then every janitorInterval, or as soon as
+// the disk quota is exceeded, until ctx is done.
+func (j *janitor) run(ctx context.Context) {
+	ticker := time.NewTicker(janitorInterval)
+	defer ticker.Stop()
+	for {
+		j.sweep()
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-j.disk.full:
+		}
+	}
+}
+
+// sweep removes recordings older than -retain and any beyond the newest
+// -retain-count, then enforces -max-disk under the delete-oldest policy.
+func (j *janitor) sweep() {
+	recs, err := listRecordings(j.cfg.outDir, j.active())
+	if err != nil {
+		janitorLog.Warn("Scanning recordings failed", "dir", j.cfg.outDir, "err", err)
+	}
+
+	var used int64
+	for _, r := range recs {
+		used += r.size
+	}
+
+	now := time.Now()
+	var kept []recording // Finished recordings that survived retention, newest first
+	finished := 0
+	for _, r := range recs {
+		if r.active {
+			continue
+		}
+		finished++
+		age := now.Sub(r.modTime)
+		var reason string
+		switch {
+		case j.cfg.retainCount > 0 && finished > j.cfg.retainCount:
+			reason = fmt.Sprintf("more than %d recordings", j.cfg.retainCount)
+		case j.cfg.retain > 0 && age > time.Duration(j.cfg.retain):
+			reason = fmt.Sprintf("older than %s", j.cfg.retain.String())
+		default:
+			kept = append(kept, r)
+			continue
+		}
+		if j.remove(r, reason) {
+			used -= r.size
+		}
+	}
+
+	if j.disk.limit > 0 && used > j.disk.limit && j.cfg.quotaPolicy == quotaDeleteOldest {
+		for i := len(kept) - 1; i >= 0 && used > j.disk.limit; i-- {
+			if j.remove(kept[i], fmt.Sprintf("disk usage over %d bytes", j.disk.limit)) {
+				used -= kept[i].size
+			}
+		}
+	}
+
+	j.disk.set(used)
+	if j.disk.limit == 0 || used < j.disk.limit {
+		j.disk.resetRejected()
+	}
+}
+
+// remove deletes a finished recording and reports whether it succeeded.
+func (j *janitor) remove(r recording, reason string) bool {
+	if err := os.Remove(r.path); err != nil {
+		janitorLog.Warn("Removing recording failed", "file", r.path, "err", err)
+		return false
+	}
+	// The metadata sidecar and transcripts go with the recording
+	for _, path := range companionFiles(r.path) {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			janitorLog.Warn("Removing companion file failed", "file", path, "err", err)
+		}
+	}
+	age := time.Since(r.modTime).Round(time.Second)
+	janitorLog.Info("🧹 Removed recording", "file", r.path, "bytes", r.size, "age", age, "reason", reason)
+	removeEmptyDirs(filepath.Dir(r.path), j.cfg.outDir)
+	j.cat.fileRemoved(r.path)
+	return true
+}
+
+// removeEmptyDirs deletes dir and its parents up to, but excluding, root as
+// long as they are empty, so templated layouts don't leave empty folders behind.
+func removeEmptyDirs(dir, root string) {
+	root = filepath.Clean(root)
+	for dir = filepath.Clean(dir); dir != root && len(dir) > len(root); dir = filepath.Dir(dir) {
+		if os.Remove(dir) != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package recorder
+
+import (
+	"slices"
+	"sync"
+	"time"
+)
+
+// liveQueue is how many events a live subscriber may fall behind before
+// they are dropped (about 5 s of 20 ms packets).
+const liveQueue = 256
+
+// liveEvent is something that happened on a stream, for live subscribers:
+// audio, a DTMF digit or a line of real-time text.
+type liveEvent struct {
+	audio liveAudio
+	dtmf  *dtmfEvent
+	text  *rttCue
+}
+
+// liveAudio is the audio of one RTP packet.
+type liveAudio struct {
+	time      time.Time // Arrival
+	timestamp uint32    // RTP timestamp
+	samples   []int     // Interleaved; shared, never modified
+}
+
+// liveSub is one subscriber of a stream. Its channel is closed when the
+// session ends.
+type liveSub struct {
+	events  chan liveEvent
+	dropped uint64 // Audio frames dropped because it fell behind, guarded by subscribers.mu
+}
+
+// subscribers are the live subscribers of a client. The zero value has none.
+type subscribers struct {
+	mu     sync.Mutex
+	subs   map[*liveSub]bool
+	closed bool
+}
+
+// add subscribes to the stream, returning nil if its session has ended.
+func (s *subscribers) add() *liveSub {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return nil
+	}
+	if s.subs == nil {
+		s.subs = make(map[*liveSub]bool)
+	}
+	sub := &liveSub{events: make(chan liveEvent, liveQueue)}
+	s.subs[sub] = true
+	return sub
+}
+
+func (s *subscribers) remove(sub *liveSub) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.subs, sub)
+}
+
+// droppedBy returns how many audio frames sub has missed.
+func (s *subscribers) droppedBy(sub *liveSub) uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return sub.dropped
+}
+
+// publish hands ev to every subscriber without blocking. A subscriber that
+// has fallen too far behind misses it. Audio is copied for the subscribers,
+// as the packet's samples are reused once written.
+func (s *subscribers) publish(ev liveEvent, channels int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.subs) > 0 && ev.audio.samples != nil {
+		ev.audio.samples = slices.Clone(ev.audio.samples)
+	}
+	for sub := range s.subs {
+		select {
+		case sub.events <- ev:
+		default:
+			sub.dropped += uint64(len(ev.audio.samples) / channels)
+		}
+	}
+}
+
+// close ends the stream for all subscribers.
+func (s *subscribers) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	for sub := range s.subs {
+		close(sub.events)
+		delete(s.subs, sub)
+	}
+}
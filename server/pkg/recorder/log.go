@@ -0,0 +1,269 @@
+package recorder
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+	"unicode"
+)
+
+// Formats of the log, chosen with -log-format.
+const (
+	logText = "text" // Readable lines, see consoleHandler
+	logJSON = "json" // One JSON object per line, see jsonHandler
+)
+
+// Logging goes through log/slog. Every component logs with a logger of its
+// own from logger, which tags its records with the component's name.
+var (
+	logLevel   = new(slog.LevelVar) // -log-level
+	logHandler slog.Handler         // Where all loggers write, set by SetupLogging
+
+	mainLog = logger("main")
+)
+
+func init() {
+	logHandler = newConsoleHandler(logLevel)
+}
+
+// SetupLogging applies the logging options.
+func SetupLogging(cfg *Config) {
+	SetLogging(cfg.logLevel, cfg.logFormat)
+}
+
+// SetLogging logs messages of level and above in format, "text" or "json",
+// and makes the default slog logger log the same way, so programs embedding
+// the recorder can share its log.
+func SetLogging(level slog.Level, format string) {
+	logLevel.Set(level)
+	if format == logJSON {
+		logHandler = newJSONHandler(logLevel)
+	} else {
+		logHandler = newConsoleHandler(logLevel)
+	}
+	slog.SetDefault(slog.New(scopedHandler{}))
+}
+
+// logger returns the logger of a component.
+func logger(component string) *slog.Logger {
+	return slog.New(scopedHandler{}).With("component", component)
+}
+
+// scopedHandler hands records to whatever logHandler is when they are
+// logged, so package-level loggers created before SetupLogging follow it.
+type scopedHandler struct {
+	scope []func(slog.Handler) slog.Handler // With and WithGroup calls, in order
+}
+
+func (h scopedHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return logHandler.Enabled(ctx, level)
+}
+
+func (h scopedHandler) Handle(ctx context.Context, r slog.Record) error {
+	target := logHandler
+	for _, f := range h.scope {
+		target = f(target)
+	}
+	return target.Handle(ctx, r)
+}
+
+func (h scopedHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return h.with(func(t slog.Handler) slog.Handler { return t.WithAttrs(attrs) })
+}
+
+func (h scopedHandler) WithGroup(name string) slog.Handler {
+	return h.with(func(t slog.Handler) slog.Handler { return t.WithGroup(name) })
+}
+
+func (h scopedHandler) with(f func(slog.Handler) slog.Handler) slog.Handler {
+	return scopedHandler{scope: append(h.scope[:len(h.scope):len(h.scope)], f)}
+}
+
+// consoleHandler writes records as readable lines to stdout:
+//
+//	2024/05/01 12:00:00 INFO  recording: 📝 Recording addr=10.0.0.5:5004 file=rec.wav
+//
+// Stdout is looked up for every record, so the terminal monitor can capture
+// the log.
+type consoleHandler struct {
+	level  slog.Leveler
+	mu     *sync.Mutex
+	prefix string // Component of the logger
+	attrs  []byte // Formatted attributes added with With
+	group  string // Prefix of attribute keys, from WithGroup
+}
+
+func newConsoleHandler(level slog.Leveler) *consoleHandler {
+	return &consoleHandler{level: level, mu: new(sync.Mutex)}
+}
+
+func (h *consoleHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level.Level()
+}
+
+func (h *consoleHandler) Handle(_ context.Context, r slog.Record) error {
+	var buf bytes.Buffer
+	t := r.Time
+	if t.IsZero() {
+		t = time.Now()
+	}
+	buf.WriteString(t.Format("2006/01/02 15:04:05 "))
+	fmt.Fprintf(&buf, "%-5s ", r.Level.String())
+	if h.prefix != "" {
+		buf.WriteString(h.prefix + ": ")
+	}
+	buf.WriteString(r.Message)
+	buf.Write(h.attrs)
+	r.Attrs(func(a slog.Attr) bool {
+		appendAttr(&buf, h.group, a)
+		return true
+	})
+	buf.WriteByte('\n')
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	_, err := os.Stdout.Write(buf.Bytes())
+	return err
+}
+
+func (h *consoleHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	c := *h
+	var buf bytes.Buffer
+	buf.Write(h.attrs)
+	for _, a := range attrs {
+		if a.Key == "component" && h.group == "" {
+			c.prefix = a.Value.String()
+			continue
+		}
+		appendAttr(&buf, h.group, a)
+	}
+	c.attrs = buf.Bytes()
+	return &c
+}
+
+func (h *consoleHandler) WithGroup(name string) slog.Handler {
+	c := *h
+	c.group += name + "."
+	return &c
+}
+
+// appendAttr writes " key=value", quoting values that need it.
+func appendAttr(buf *bytes.Buffer, group string, a slog.Attr) {
+	a.Value = a.Value.Resolve()
+	if a.Equal(slog.Attr{}) {
+		return
+	}
+	if a.Value.Kind() == slog.KindGroup {
+		for _, ga := range a.Value.Group() {
+			appendAttr(buf, group+a.Key+".", ga)
+		}
+		return
+	}
+	var s string
+	switch a.Value.Kind() {
+	case slog.KindDuration:
+		s = a.Value.Duration().Round(time.Millisecond).String()
+	case slog.KindTime:
+		s = a.Value.Time().Format(time.RFC3339)
+	default:
+		s = a.Value.String()
+	}
+	buf.WriteString(" " + group + a.Key + "=")
+	if s == "" || needsQuoting(s) {
+		s = strconv.Quote(s)
+	}
+	buf.WriteString(s)
+}
+
+func needsQuoting(s string) bool {
+	for _, r := range s {
+		if unicode.IsSpace(r) || r == '"' || r == '=' || !unicode.IsPrint(r) {
+			return true
+		}
+	}
+	return false
+}
+
+// jsonHandler writes records as JSON objects, one per line, to stdout:
+//
+//	{"time":"2024-05-01T12:00:00.000Z","level":"WARN","msg":"Upload failed, retrying","component":"finalize","file":"rec.wav","err":"HTTP 503: ","error_class":"http_status"}
+//
+// Records with an err attribute also get its error_class, so failures can
+// be counted without matching messages. Durations are in nanoseconds.
+type jsonHandler struct {
+	slog.Handler
+}
+
+func newJSONHandler(level slog.Leveler) *jsonHandler {
+	return &jsonHandler{slog.NewJSONHandler(stdout{}, &slog.HandlerOptions{Level: level})}
+}
+
+func (h *jsonHandler) Handle(ctx context.Context, r slog.Record) error {
+	var class string
+	r.Attrs(func(a slog.Attr) bool {
+		if err, ok := a.Value.Any().(error); ok && a.Key == "err" {
+			class = errorClass(err)
+			return false
+		}
+		return true
+	})
+	if class != "" {
+		r = r.Clone()
+		r.AddAttrs(slog.String("error_class", class))
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h *jsonHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &jsonHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h *jsonHandler) WithGroup(name string) slog.Handler {
+	return &jsonHandler{h.Handler.WithGroup(name)}
+}
+
+// stdout writes to whatever os.Stdout is at the time, for the terminal
+// monitor.
+type stdout struct{}
+
+func (stdout) Write(p []byte) (int, error) { return os.Stdout.Write(p) }
+
+// errorClass sorts an error into a broad class for the error_class field.
+func errorClass(err error) string {
+	var (
+		netErr    net.Error
+		statusErr *statusError
+		exitErr   *exec.ExitError
+	)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		return "not_found"
+	case errors.Is(err, fs.ErrPermission):
+		return "permission"
+	case errors.Is(err, syscall.ENOSPC):
+		return "disk_full"
+	case errors.Is(err, context.Canceled):
+		return "canceled"
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return "timeout"
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return "connection_refused"
+	case errors.As(err, &netErr):
+		return "network"
+	case errors.As(err, &statusErr):
+		return "http_status"
+	case errors.As(err, &exitErr):
+		return "exit_status"
+	}
+	return "other"
+}
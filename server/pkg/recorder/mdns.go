@@ -0,0 +1,28 @@
+package recorder
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/fcerini/audio-capture-shared/pkg/mdns"
+)
+
+// advertise advertises the RTP port over mDNS as the -mdns instance until
+// ctx is done, with the stream format expected in the TXT record, so
+// clients on the local network find the server by name (see package mdns).
+func (s *server) advertise(ctx context.Context) {
+	svc := mdns.Service{
+		Instance: s.cfg.mdns,
+		Host:     mdns.LocalHost(),
+		Port:     int(s.localAddr.Port()),
+		Text: []string{
+			fmt.Sprintf("rate=%d", s.cfg.sampleRate),
+			fmt.Sprintf("channels=%d", s.cfg.channels),
+			fmt.Sprintf("encoding=L%d", s.cfg.bitDepth),
+		},
+	}
+	mainLog.Info("📣 Advertising the server over mDNS", "name", svc.Instance, "service", mdns.ServiceType, "host", svc.Host+".local")
+	if err := mdns.Advertise(ctx, svc); err != nil {
+		mainLog.Warn("Advertising over mDNS failed", "err", err)
+	}
+}
@@ -0,0 +1,214 @@
+package recorder
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+var metadataLog = logger("metadata")
+
+const (
+	maxMetadataLine  = 16 << 10 // Bytes of a message
+	maxMetadataField = 256      // Bytes of each of its strings
+	maxMetadataConns = 256
+)
+
+// sessionMeta is what a client tells of its session on -metadata-addr,
+// stored in the sidecars of its recordings and used in their names.
+type sessionMeta struct {
+	Source   string     `json:"source,omitempty"` // What was captured, e.g. the URL of the page played
+	Title    string     `json:"title,omitempty"`
+	Operator string     `json:"operator,omitempty"`
+	Start    *time.Time `json:"start,omitempty"` // When the client started capturing
+}
+
+// metadataMessage is a line of the metadata channel: the metadata of the
+// stream of ssrc from the IP the connection comes from.
+type metadataMessage struct {
+	SSRC *uint32 `json:"ssrc"`
+	sessionMeta
+}
+
+// metadataAnswer is the server's answer to a message, a line too.
+type metadataAnswer struct {
+	OK      bool   `json:"ok"`
+	Session string `json:"session,omitempty"` // Being recorded, when the stream has started
+	Error   string `json:"error,omitempty"`
+}
+
+// sessionMetas are the metadata told of the streams to come and being
+// recorded.
+type sessionMetas map[metadataKey]*sessionMeta
+
+// metadataKey is the stream metadata was told for.
+type metadataKey struct {
+	ip   netip.Addr
+	ssrc uint32
+}
+
+// metadataIngest takes metadata on -metadata-addr: a client connects over
+// TCP and sends its session's metadata as a line of JSON, or more to update
+// it, each answered with a line.
+type metadataIngest struct {
+	s  *server
+	ln net.Listener
+
+	mu    sync.Mutex
+	conns map[net.Conn]struct{} // guarded by mu
+	wg    sync.WaitGroup        // The connections' read loops
+}
+
+func listenMetadata(s *server, addr string) (*metadataIngest, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return &metadataIngest{s: s, ln: ln, conns: make(map[net.Conn]struct{})}, nil
+}
+
+// serve accepts connections until the listener is closed, then closes them
+// and waits for their read loops.
+func (m *metadataIngest) serve() {
+	defer m.wg.Wait()
+	defer m.closeAll()
+	for {
+		conn, err := m.ln.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			metadataLog.Warn("Accepting a metadata connection failed", "err", err)
+			time.Sleep(100 * time.Millisecond)
+			continue
+		}
+		addr := conn.RemoteAddr().(*net.TCPAddr).AddrPort()
+		ip := addr.Addr().Unmap()
+		if !m.s.access.allowed(ip) || !m.add(conn) {
+			conn.Close()
+			continue
+		}
+		m.wg.Add(1)
+		go func() {
+			defer m.wg.Done()
+			defer m.remove(conn)
+			m.read(conn, ip)
+		}()
+	}
+}
+
+// read answers the messages of a connection until it is closed.
+func (m *metadataIngest) read(conn net.Conn, ip netip.Addr) {
+	scanner := bufio.NewScanner(conn)
+	scanner.Buffer(make([]byte, 0, 4096), maxMetadataLine)
+	enc := json.NewEncoder(conn)
+	for scanner.Scan() {
+		var answer metadataAnswer
+		var msg metadataMessage
+		if err := json.Unmarshal(scanner.Bytes(), &msg); err != nil {
+			answer.Error = "invalid message: " + err.Error()
+		} else if err := msg.validate(); err != nil {
+			answer.Error = err.Error()
+		} else {
+			answer.OK = true
+			answer.Session = m.s.setMetadata(metadataKey{ip: ip, ssrc: *msg.SSRC}, msg.sessionMeta)
+		}
+		if answer.Error != "" {
+			metadataLog.Warn("Refused session metadata", "ip", ip, "err", answer.Error)
+		}
+		if err := enc.Encode(answer); err != nil {
+			return
+		}
+	}
+}
+
+// validate checks a message's fields.
+func (msg *metadataMessage) validate() error {
+	if msg.SSRC == nil {
+		return errors.New("ssrc is required")
+	}
+	for name, v := range map[string]string{"source": msg.Source, "title": msg.Title, "operator": msg.Operator} {
+		if len(v) > maxMetadataField || !utf8.ValidString(v) {
+			return fmt.Errorf("%s must be valid UTF-8 of at most %d bytes", name, maxMetadataField)
+		}
+	}
+	return nil
+}
+
+// add tracks a connection, reporting false when there are too many.
+func (m *metadataIngest) add(conn net.Conn) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(m.conns) >= maxMetadataConns {
+		metadataLog.Warn("🚫 Refused a metadata connection, too many are open", "addr", conn.RemoteAddr().String(), "max", maxMetadataConns)
+		return false
+	}
+	m.conns[conn] = struct{}{}
+	return true
+}
+
+func (m *metadataIngest) remove(conn net.Conn) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.conns, conn)
+	conn.Close()
+}
+
+// closeAll closes every connection, ending their read loops.
+func (m *metadataIngest) closeAll() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for conn := range m.conns {
+		conn.Close()
+	}
+}
+
+// setMetadata records md for the stream of key, for the session it starts
+// and, if it is being recorded, the current one, whose ID it returns.
+func (s *server) setMetadata(key metadataKey, md sessionMeta) string {
+	s.clientsMutex.Lock()
+	defer s.clientsMutex.Unlock()
+	if _, known := s.metadata[key]; !known && len(s.metadata) >= maxOffers {
+		s.metadata = make(sessionMetas)
+	}
+	s.metadata[key] = &md
+	var client *Client
+	s.clients.each(func(_ string, c *Client) bool {
+		if addr, err := netip.ParseAddrPort(c.addr); c.ssrc == key.ssrc && err == nil && addr.Addr() == key.ip {
+			client = c
+		}
+		return client == nil
+	})
+	if client != nil {
+		client.setMetadata(&md)
+		return client.session
+	}
+	metadataLog.Info("🏷️  Got session metadata for a stream to come", "ip", key.ip, "ssrc", key.ssrc, "title", md.Title)
+	return ""
+}
+
+// metadataFor returns the metadata told for the stream of ssrc from addr, or
+// nil. The caller holds clientsMutex.
+func (s *server) metadataFor(addr string, ssrc uint32) *sessionMeta {
+	a, err := netip.ParseAddrPort(addr)
+	if err != nil {
+		return nil
+	}
+	return s.metadata[metadataKey{ip: a.Addr(), ssrc: ssrc}]
+}
+
+// setMetadata sets the session's metadata, which the files it goes on to
+// open are named by and the sidecars of the current one and those after it
+// store.
+func (c *Client) setMetadata(md *sessionMeta) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.meta = md
+	c.log.Info("🏷️  Got session metadata", "title", md.Title, "operator", md.Operator, "source", md.Source)
+}
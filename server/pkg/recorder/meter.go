@@ -0,0 +1,124 @@
+package recorder
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+const (
+	meterFloor = -100            // dBFS reported for digital silence
+	meterStale = 2 * time.Second // A stream without a measurement for this long is idle
+)
+
+// Stream states reported by the level meter.
+const (
+	levelLive     = "live"
+	levelSilent   = "silent"   // Peak below -silence-threshold
+	levelClipping = "clipping" // Samples at full scale
+	levelIdle     = "idle"     // No audio for meterStale
+)
+
+// level is the measurement of one second of a stream.
+type level struct {
+	Time    time.Time `json:"time"` // End of the measured second
+	Peak    float64   `json:"peak_dbfs"`
+	RMS     float64   `json:"rms_dbfs"`
+	Clipped int       `json:"clipped_samples"`
+	State   string    `json:"state"`
+}
+
+// levelMeter measures the peak and RMS level of a stream over each second of
+// audio, across all channels.
+type levelMeter struct {
+	fullScale  float64
+	window     int     // Samples per measurement, one second of all channels
+	silentPeak float64 // dBFS
+
+	// Written by the client's writer goroutine only
+	n       int
+	peak    int
+	sum     float64
+	clipped int
+
+	mu   sync.Mutex
+	last *level
+}
+
+func newLevelMeter(cfg *Config) *levelMeter {
+	return &levelMeter{
+		fullScale:  float64(int(1) << (cfg.bitDepth - 1)),
+		window:     cfg.sampleRate * cfg.channels,
+		silentPeak: cfg.silenceThreshold,
+	}
+}
+
+// feed adds samples to the current measurement, publishing it once a second
+// of audio is complete.
+func (m *levelMeter) feed(samples []int) {
+	maxSample := int(m.fullScale) - 1
+	for len(samples) > 0 {
+		chunk := samples[:min(len(samples), m.window-m.n)]
+		samples = samples[len(chunk):]
+		// Summed as integers, which a packet's worth of samples can't overflow
+		var sum int64
+		peak, clipped := m.peak, 0
+		for _, v := range chunk {
+			if v < 0 {
+				v = -v
+			}
+			peak = max(peak, v)
+			sum += int64(v) * int64(v)
+			if v >= maxSample {
+				clipped++
+			}
+		}
+		m.peak, m.clipped, m.sum = peak, m.clipped+clipped, m.sum+float64(sum)
+		if m.n += len(chunk); m.n == m.window {
+			m.publish()
+		}
+	}
+}
+
+func (m *levelMeter) publish() {
+	l := &level{
+		Time:    time.Now(),
+		Peak:    m.dBFS(float64(m.peak)),
+		RMS:     m.dBFS(math.Sqrt(m.sum / float64(m.n))),
+		Clipped: m.clipped,
+		State:   levelLive,
+	}
+	switch {
+	case l.Clipped > 0:
+		l.State = levelClipping
+	case l.Peak < m.silentPeak:
+		l.State = levelSilent
+	}
+	m.n, m.peak, m.sum, m.clipped = 0, 0, 0, 0
+
+	m.mu.Lock()
+	m.last = l
+	m.mu.Unlock()
+}
+
+func (m *levelMeter) dBFS(amplitude float64) float64 {
+	if amplitude == 0 {
+		return meterFloor
+	}
+	return max(meterFloor, math.Round(200*math.Log10(amplitude/m.fullScale))/10)
+}
+
+// current returns the latest measurement, marked idle once it is stale, or
+// nil before the first second of audio.
+func (m *levelMeter) current() *level {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.last == nil {
+		return nil
+	}
+	l := *m.last
+	if time.Since(l.Time) > meterStale {
+		l.State = levelIdle
+	}
+	return &l
+}
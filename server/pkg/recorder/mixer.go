@@ -0,0 +1,222 @@
+package recorder
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var mixLog = logger("mix")
+
+const (
+	mixAddr     = "mix"                  // Address the mixed recording is filed under
+	mixTick     = 20 * time.Millisecond  // How often the mixer produces output
+	mixPrime    = 60 * time.Millisecond  // Audio buffered per source before it is mixed in
+	mixMaxDelay = 500 * time.Millisecond // Buffered audio beyond this is dropped to catch up
+	mixIdle     = time.Second            // Sources silent this long are dropped
+)
+
+// mixSource buffers the audio of one stream between the UDP read loop and the
+// mixer clock, smoothing out network jitter.
+type mixSource struct {
+	buf      []int // Interleaved samples not yet mixed
+	primed   bool  // Enough audio was buffered to start mixing
+	lastFeed time.Time
+}
+
+// mixer downmixes the selected streams into a single recording at the
+// configured sample rate. Each source is delayed by a small jitter buffer and
+// scaled by its stream controls; the mix is clipped to the sample range. The
+// result is recorded like any other stream, under the address "mix".
+type mixer struct {
+	srv *server
+	cfg *Config // Settings of the mix recording
+
+	mu      sync.Mutex
+	sources map[string]*mixSource
+	out     *Client // Created with the first mixed audio
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+func newMixer(srv *server) *mixer {
+	cfg := *srv.cfg
+	cfg.dtmf = false // Digits are detected on the source streams
+	return &mixer{
+		srv:     srv,
+		cfg:     &cfg,
+		sources: make(map[string]*mixSource),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+}
+
+// includes reports whether the stream from addr is part of the mix.
+func (m *mixer) includes(addr string) bool {
+	sel := m.srv.cfg.mix
+	if sel == playAll {
+		return true
+	}
+	host, _, _ := net.SplitHostPort(addr)
+	for _, s := range strings.Split(sel, ",") {
+		if s = strings.TrimSpace(s); s == addr || s == host {
+			return true
+		}
+	}
+	return false
+}
+
+// feed adds decoded samples from a stream to its buffer.
+func (m *mixer) feed(addr string, samples []int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	src := m.sources[addr]
+	if src == nil {
+		src = &mixSource{}
+		m.sources[addr] = src
+		mixLog.Info("🎛️  Mixing in", "addr", addr)
+	}
+	src.buf = append(src.buf, samples...)
+	src.lastFeed = time.Now()
+
+	if max := m.frames(mixMaxDelay) * m.srv.cfg.channels; len(src.buf) > max {
+		// The source's clock runs faster than ours; drop the oldest audio
+		drop := len(src.buf) - m.frames(mixPrime)*m.srv.cfg.channels
+		src.buf = append(src.buf[:0], src.buf[drop:]...)
+	}
+}
+
+func (m *mixer) frames(d time.Duration) int {
+	return int(d * time.Duration(m.srv.cfg.sampleRate) / time.Second)
+}
+
+// run produces mixed audio in real time until stop is closed.
+func (m *mixer) run() {
+	defer close(m.done)
+	ticker := time.NewTicker(mixTick)
+	defer ticker.Stop()
+
+	start := time.Now()
+	var produced int64 // Frames produced since start
+	for {
+		select {
+		case <-m.stop:
+			return
+		case <-ticker.C:
+			due := int64(time.Since(start) * time.Duration(m.srv.cfg.sampleRate) / time.Second)
+			if samples := m.mix(int(due - produced)); samples != nil {
+				m.record(samples)
+			}
+			produced = due
+		}
+	}
+}
+
+// mix takes n frames from every source and sums them. It returns nil while
+// no source is active.
+func (m *mixer) mix(n int) []int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	ch := m.srv.cfg.channels
+	var sum []float64
+	for addr, src := range m.sources {
+		if time.Since(src.lastFeed) > mixIdle {
+			delete(m.sources, addr)
+			mixLog.Info("🎛️  Left the mix", "addr", addr)
+			continue
+		}
+		if !src.primed {
+			if len(src.buf) < m.frames(mixPrime)*ch {
+				continue
+			}
+			src.primed = true
+		}
+		if sum == nil {
+			sum = make([]float64, n*ch)
+		}
+
+		g := m.srv.controls.factor(addr)
+		take := min(len(src.buf), n*ch)
+		for i, s := range src.buf[:take] {
+			sum[i] += float64(s) * g
+		}
+		src.buf = append(src.buf[:0], src.buf[take:]...)
+		if take < n*ch {
+			// Ran dry; rebuild the jitter buffer before mixing it in again
+			src.primed = false
+		}
+	}
+	if sum == nil {
+		return nil
+	}
+
+	limit := float64(int(1)<<(m.srv.cfg.bitDepth-1) - 1)
+	out := make([]int, len(sum))
+	for i, v := range sum {
+		out[i] = int(math.Round(max(-limit-1, min(limit, v))))
+	}
+	return out
+}
+
+// record hands mixed audio to the mix recording, starting it if needed.
+func (m *mixer) record(samples []int) {
+	if m.out == nil {
+		out, err := newClientConfig(m.srv, m.cfg, mixAddr, 0, nil)
+		if err != nil {
+			mixLog.Error("Creating mix recording failed", "err", err)
+			return
+		}
+		out.log.Info("📝 Recording mix", "file", out.fileName())
+		m.out = out
+	}
+	m.out.touch()
+	m.out.write(samples)
+}
+
+// close stops the mixer and finalizes the mix recording.
+func (m *mixer) close() {
+	close(m.stop)
+	<-m.done
+	if m.out != nil {
+		m.out.close()
+	}
+}
+
+// gainList is a flag value holding per-source gains in dB, written as
+// "10.0.0.5=-6,10.0.0.6:40000=3". Keys are client addresses or IPs.
+type gainList map[string]float64
+
+func (g *gainList) String() string {
+	if g == nil || len(*g) == 0 {
+		return ""
+	}
+	var parts []string
+	for k, v := range *g {
+		parts = append(parts, fmt.Sprintf("%s=%g", k, v))
+	}
+	return strings.Join(parts, ",")
+}
+
+func (g *gainList) Set(s string) error {
+	if *g == nil {
+		*g = gainList{}
+	}
+	for _, part := range strings.Split(s, ",") {
+		key, val, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok || key == "" {
+			return fmt.Errorf("invalid gain %q (use addr=dB)", part)
+		}
+		db, err := strconv.ParseFloat(strings.TrimSuffix(strings.TrimSpace(val), "dB"), 64)
+		if err != nil {
+			return fmt.Errorf("invalid gain %q (use addr=dB)", part)
+		}
+		(*g)[key] = db
+	}
+	return nil
+}
@@ -0,0 +1,213 @@
+package recorder
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"os"
+	"time"
+)
+
+// Matroska element IDs used by mkaWriter.
+const (
+	ebmlHeaderID         = 0x1A45DFA3
+	ebmlVersionID        = 0x4286
+	ebmlReadVersionID    = 0x42F7
+	ebmlMaxIDLengthID    = 0x42F2
+	ebmlMaxSizeLengthID  = 0x42F3
+	docTypeID            = 0x4282
+	docTypeVersionID     = 0x4287
+	docTypeReadVersionID = 0x4285
+	segmentID            = 0x18538067
+	infoID               = 0x1549A966
+	timecodeScaleID      = 0x2AD7B1
+	muxingAppID          = 0x4D80
+	writingAppID         = 0x5741
+	titleID              = 0x7BA9
+	dateUTCID            = 0x4461
+	tracksID             = 0x1654AE6B
+	trackEntryID         = 0xAE
+	trackNumberID        = 0xD7
+	trackUIDID           = 0x73C5
+	trackTypeID          = 0x83
+	codecIDID            = 0x86
+	audioID              = 0xE1
+	samplingFrequencyID  = 0xB5
+	channelsID           = 0x9F
+	bitDepthID           = 0x6264
+	clusterID            = 0x1F43B675
+	clusterTimecodeID    = 0xE7
+	simpleBlockID        = 0xA3
+)
+
+const (
+	ebmlUnknownSize = 0x01FFFFFFFFFFFFFF // 8-byte "unknown" size for live elements
+	clusterDuration = time.Second        // Start a new cluster this often
+)
+
+// ebmlElement encodes an element with the given ID and body.
+func ebmlElement(id uint32, body []byte) []byte {
+	out := ebmlID(id)
+	out = append(out, ebmlSize(uint64(len(body)))...)
+	return append(out, body...)
+}
+
+// ebmlID encodes an element ID, which already carries its length marker.
+func ebmlID(id uint32) []byte {
+	switch {
+	case id > 0xFFFFFF:
+		return []byte{byte(id >> 24), byte(id >> 16), byte(id >> 8), byte(id)}
+	case id > 0xFFFF:
+		return []byte{byte(id >> 16), byte(id >> 8), byte(id)}
+	case id > 0xFF:
+		return []byte{byte(id >> 8), byte(id)}
+	}
+	return []byte{byte(id)}
+}
+
+// ebmlSize encodes an element size as an 8-byte variable length integer.
+func ebmlSize(n uint64) []byte {
+	return binary.BigEndian.AppendUint64(nil, n|1<<56)
+}
+
+func ebmlUint(id uint32, v uint64) []byte {
+	return ebmlElement(id, binary.BigEndian.AppendUint64(nil, v))
+}
+
+func ebmlFloat(id uint32, v float64) []byte {
+	return ebmlElement(id, binary.BigEndian.AppendUint64(nil, math.Float64bits(v)))
+}
+
+func ebmlString(id uint32, s string) []byte {
+	return ebmlElement(id, []byte(s))
+}
+
+func concat(parts ...[]byte) []byte {
+	var out []byte
+	for _, p := range parts {
+		out = append(out, p...)
+	}
+	return out
+}
+
+// mkaWriter writes interleaved PCM samples to a Matroska (.mka) file. The
+// segment and clusters use unknown sizes, so the file is valid at any point
+// while it's being written and needs no header rewrites, and is not bound by
+// WAV's 4 GiB limit.
+type mkaWriter struct {
+	file       *bufferedFile
+	bitDepth   int
+	channels   int
+	sampleRate int
+
+	written      int64
+	frames       int64 // Frames written so far, used for block timecodes
+	clusterStart int64 // Frame at which the current cluster started, -1 before the first
+	buf          []byte
+}
+
+// mkaMeta is the metadata embedded in a Matroska recording.
+type mkaMeta struct {
+	title string
+	start time.Time
+}
+
+// newMKAWriter creates the file at path, which must not exist yet, writes
+// the Matroska header and returns a writer for the audio blocks.
+func newMKAWriter(path string, sampleRate, bitDepth, channels int, meta mkaMeta) (*mkaWriter, error) {
+	header := ebmlElement(ebmlHeaderID, concat(
+		ebmlUint(ebmlVersionID, 1),
+		ebmlUint(ebmlReadVersionID, 1),
+		ebmlUint(ebmlMaxIDLengthID, 4),
+		ebmlUint(ebmlMaxSizeLengthID, 8),
+		ebmlString(docTypeID, "matroska"),
+		ebmlUint(docTypeVersionID, 4),
+		ebmlUint(docTypeReadVersionID, 2),
+	))
+
+	// DateUTC counts nanoseconds since the Matroska epoch, 2001-01-01
+	epoch := time.Date(2001, 1, 1, 0, 0, 0, 0, time.UTC)
+	info := ebmlElement(infoID, concat(
+		ebmlUint(timecodeScaleID, uint64(time.Millisecond)),
+		ebmlString(muxingAppID, "audio-capture-server"),
+		ebmlString(writingAppID, "audio-capture-server"),
+		ebmlString(titleID, meta.title),
+		ebmlUint(dateUTCID, uint64(meta.start.Sub(epoch))),
+	))
+
+	tracks := ebmlElement(tracksID, ebmlElement(trackEntryID, concat(
+		ebmlUint(trackNumberID, 1),
+		ebmlUint(trackUIDID, 1),
+		ebmlUint(trackTypeID, 2), // Audio
+		ebmlString(codecIDID, "A_PCM/INT/LIT"),
+		ebmlElement(audioID, concat(
+			ebmlFloat(samplingFrequencyID, float64(sampleRate)),
+			ebmlUint(channelsID, uint64(channels)),
+			ebmlUint(bitDepthID, uint64(bitDepth)),
+		)),
+	)))
+
+	out := concat(header, ebmlID(segmentID), binary.BigEndian.AppendUint64(nil, ebmlUnknownSize), info, tracks)
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := f.Write(out); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to write Matroska header: %w", err)
+	}
+	return &mkaWriter{
+		file:         newBufferedFile(f),
+		bitDepth:     bitDepth,
+		channels:     channels,
+		sampleRate:   sampleRate,
+		written:      int64(len(out)),
+		clusterStart: -1,
+	}, nil
+}
+
+func (w *mkaWriter) size() int64 { return w.written }
+
+// write appends the samples as one SimpleBlock, starting a new cluster when
+// the current one has grown past clusterDuration.
+func (w *mkaWriter) write(samples []int) error {
+	w.buf = w.buf[:0]
+	if w.clusterStart < 0 || (w.frames-w.clusterStart)*int64(time.Second) >= int64(clusterDuration)*int64(w.sampleRate) {
+		w.clusterStart = w.frames
+		w.buf = append(w.buf, ebmlID(clusterID)...)
+		w.buf = binary.BigEndian.AppendUint64(w.buf, ebmlUnknownSize)
+		w.buf = append(w.buf, ebmlUint(clusterTimecodeID, uint64(w.framesToMillis(w.frames)))...)
+	}
+
+	blockSize := 4 + len(samples)*w.bitDepth/8 // Track number, timecode and flags precede the audio
+	w.buf = append(w.buf, ebmlID(simpleBlockID)...)
+	w.buf = append(w.buf, ebmlSize(uint64(blockSize))...)
+	w.buf = append(w.buf, 0x81) // Track 1
+	w.buf = binary.BigEndian.AppendUint16(w.buf, uint16(w.framesToMillis(w.frames)-w.framesToMillis(w.clusterStart)))
+	w.buf = append(w.buf, 0x80) // Keyframe
+	w.buf = appendPCMLE(w.buf, samples, w.bitDepth)
+
+	n, err := w.file.Write(w.buf)
+	w.written += int64(n)
+	w.frames += int64(len(samples) / w.channels)
+	return err
+}
+
+func (w *mkaWriter) framesToMillis(frames int64) int64 {
+	return frames * 1000 / int64(w.sampleRate)
+}
+
+// flush writes the buffered blocks to the file.
+func (w *mkaWriter) flush() error { return w.file.Flush() }
+
+// syncHeader flushes the file to disk. Matroska needs no header rewrites.
+func (w *mkaWriter) syncHeader() error { return w.file.Sync() }
+
+// close flushes the file to disk and closes it.
+func (w *mkaWriter) close() error {
+	defer w.file.Close()
+	if err := w.file.Sync(); err != nil {
+		return err
+	}
+	return w.file.Close()
+}
@@ -0,0 +1,355 @@
+package recorder
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+var mqttLog = logger("mqtt")
+
+const (
+	mqttKeepAlive = 30 * time.Second
+	mqttQueue     = 1000 // Messages held while the broker is unreachable
+	mqttRetryMax  = time.Minute
+)
+
+// MQTT events, the {event} of -mqtt-topic.
+const (
+	mqttStarted  = "started"  // A session started
+	mqttEnded    = "ended"    // A session ended
+	mqttFile     = "file"     // A file was finalized, with its metadata
+	mqttLevel    = "level"    // Once a second, the level of the last second
+	mqttActivity = "activity" // The stream state changed, e.g. from live to silent; retained
+)
+
+type mqttMessage struct {
+	topic   string
+	payload []byte
+	retain  bool
+}
+
+// mqttPublisher publishes stream events to an MQTT broker with QoS 0,
+// reconnecting in the background. Messages are queued while the broker is
+// unreachable and dropped once the queue is full, so a broker outage never
+// holds up recording. A nil publisher publishes nothing.
+type mqttPublisher struct {
+	cfg      *Config
+	addr     string
+	tls      bool
+	clientID string
+	user     string
+	password string
+
+	queue   chan mqttMessage
+	stop    chan struct{}
+	done    chan struct{}
+	dropped int // Guarded by mu
+	mu      sync.Mutex
+}
+
+// newMQTTPublisher checks the -mqtt URL; credentials come from
+// MQTT_USERNAME and MQTT_PASSWORD.
+func newMQTTPublisher(cfg *Config) (*mqttPublisher, error) {
+	u, err := url.Parse(cfg.mqtt)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("invalid -mqtt %q: use mqtt://host[:port] or mqtts://host[:port]", cfg.mqtt)
+	}
+	p := &mqttPublisher{
+		cfg:      cfg,
+		addr:     u.Host,
+		clientID: fmt.Sprintf("audio-capture-server-%s", newSessionID()),
+		user:     os.Getenv("MQTT_USERNAME"),
+		password: os.Getenv("MQTT_PASSWORD"),
+		queue:    make(chan mqttMessage, mqttQueue),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	switch u.Scheme {
+	case "mqtt", "tcp":
+		if u.Port() == "" {
+			p.addr = net.JoinHostPort(u.Host, "1883")
+		}
+	case "mqtts", "ssl", "tls":
+		p.tls = true
+		if u.Port() == "" {
+			p.addr = net.JoinHostPort(u.Host, "8883")
+		}
+	default:
+		return nil, fmt.Errorf("invalid -mqtt %q: use mqtt://host[:port] or mqtts://host[:port]", cfg.mqtt)
+	}
+	return p, nil
+}
+
+// topic expands -mqtt-topic for an event of a session.
+func (p *mqttPublisher) topic(event, session, addr string) string {
+	return strings.NewReplacer("{event}", event, "{session}", session, "{addr}", addr).Replace(p.cfg.mqttTopic)
+}
+
+// publish queues an event with a JSON payload.
+func (p *mqttPublisher) publish(event, session, addr string, v any, retain bool) {
+	if p == nil {
+		return
+	}
+	payload, err := json.Marshal(v)
+	if err != nil {
+		mqttLog.Error("Encoding event failed", "event", event, "err", err)
+		return
+	}
+	select {
+	case p.queue <- mqttMessage{topic: p.topic(event, session, addr), payload: payload, retain: retain}:
+	default:
+		p.mu.Lock()
+		p.dropped++
+		n := p.dropped
+		p.mu.Unlock()
+		if n == 1 || n%100 == 0 {
+			mqttLog.Warn("Queue is full, dropping messages", "dropped", n)
+		}
+	}
+}
+
+func (p *mqttPublisher) sessionStarted(c *Client) {
+	p.publish(mqttStarted, c.session, c.addr, map[string]any{
+		"addr": c.addr, "session": c.session, "ssrc": c.ssrc, "start": c.start, "file": c.fileName(),
+	}, false)
+}
+
+func (p *mqttPublisher) sessionEnded(c *Client) {
+	if p == nil {
+		return
+	}
+	p.publish(mqttEnded, c.session, c.addr, map[string]any{
+		"addr": c.addr, "session": c.session, "ssrc": c.ssrc, "start": c.start, "end": time.Now(),
+	}, false)
+	// An empty retained message clears the stream's state on the broker
+	p.queueRaw(mqttMessage{topic: p.topic(mqttActivity, c.session, c.addr), retain: true})
+}
+
+func (p *mqttPublisher) fileFinished(ff finishedFile) {
+	p.publish(mqttFile, ff.Session, ff.Addr, ff, false)
+}
+
+func (p *mqttPublisher) queueRaw(m mqttMessage) {
+	select {
+	case p.queue <- m:
+	default:
+	}
+}
+
+// watchLevels publishes the level of every stream once a second, and its
+// state whenever it changes, until the publisher is closed.
+func (p *mqttPublisher) watchLevels(levels func() []streamLevel) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	lastTime := make(map[string]time.Time)
+	lastState := make(map[string]string)
+	for {
+		select {
+		case <-p.stop:
+			return
+		case <-ticker.C:
+		}
+		seen := make(map[string]bool)
+		for _, l := range levels() {
+			seen[l.Session] = true
+			if !l.Time.Equal(lastTime[l.Session]) {
+				lastTime[l.Session] = l.Time
+				p.publish(mqttLevel, l.Session, l.Addr, l, false)
+			}
+			if l.State != lastState[l.Session] {
+				lastState[l.Session] = l.State
+				p.publish(mqttActivity, l.Session, l.Addr, map[string]any{"addr": l.Addr, "session": l.Session, "state": l.State, "time": time.Now()}, true)
+			}
+		}
+		for session := range lastTime {
+			if !seen[session] {
+				delete(lastTime, session)
+				delete(lastState, session)
+			}
+		}
+	}
+}
+
+// run sends queued messages, connecting with exponential backoff, until
+// the publisher is closed.
+func (p *mqttPublisher) run() {
+	defer close(p.done)
+	backoff := time.Second
+	var pending *mqttMessage // Taken from the queue when the connection broke
+	for {
+		conn, err := p.connect()
+		if err != nil {
+			mqttLog.Warn("Connecting to the broker failed, retrying", "broker", p.addr, "backoff", backoff, "err", err)
+			select {
+			case <-p.stop:
+				return
+			case <-time.After(backoff):
+			}
+			backoff = min(2*backoff, mqttRetryMax)
+			continue
+		}
+		mqttLog.Info("📨 Connected to the broker", "broker", p.addr)
+		backoff = time.Second
+		pending, err = p.send(conn, pending)
+		conn.Close()
+		if err == nil {
+			return // Closed
+		}
+		mqttLog.Warn("Lost the broker", "broker", p.addr, "err", err)
+	}
+}
+
+// send publishes messages on conn until it fails, returning the message it
+// could not send, or until the publisher is closed and the queue drained.
+func (p *mqttPublisher) send(conn net.Conn, pending *mqttMessage) (*mqttMessage, error) {
+	w := bufio.NewWriter(conn)
+	write := func(packet []byte) error {
+		conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+		if _, err := w.Write(packet); err != nil {
+			return err
+		}
+		return w.Flush()
+	}
+	// Detect a dead connection while idle; the broker sends nothing else at QoS 0
+	broken := make(chan error, 1)
+	go func() {
+		_, err := io.Copy(io.Discard, conn)
+		if err == nil {
+			err = io.EOF
+		}
+		broken <- err
+	}()
+
+	if pending != nil {
+		if err := write(mqttPublishPacket(*pending)); err != nil {
+			return pending, err
+		}
+	}
+	ping := time.NewTicker(mqttKeepAlive / 2)
+	defer ping.Stop()
+	for {
+		select {
+		case m := <-p.queue:
+			if err := write(mqttPublishPacket(m)); err != nil {
+				return &m, err
+			}
+		case <-ping.C:
+			if err := write([]byte{0xc0, 0}); err != nil { // PINGREQ
+				return nil, err
+			}
+		case err := <-broken:
+			return nil, err
+		case <-p.stop:
+			for {
+				select {
+				case m := <-p.queue:
+					if err := write(mqttPublishPacket(m)); err != nil {
+						return nil, nil
+					}
+				default:
+					write([]byte{0xe0, 0}) // DISCONNECT
+					return nil, nil
+				}
+			}
+		}
+	}
+}
+
+// connect opens a session with the broker.
+func (p *mqttPublisher) connect() (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: 10 * time.Second}
+	var (
+		conn net.Conn
+		err  error
+	)
+	if p.tls {
+		host, _, _ := net.SplitHostPort(p.addr)
+		conn, err = tls.DialWithDialer(dialer, "tcp", p.addr, &tls.Config{ServerName: host})
+	} else {
+		conn, err = dialer.Dial("tcp", p.addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// CONNECT with a clean session (MQTT 3.1.1)
+	flags := byte(0x02)
+	payload := mqttString(p.clientID)
+	if p.user != "" {
+		flags |= 0x80
+		payload = append(payload, mqttString(p.user)...)
+		if p.password != "" {
+			flags |= 0x40
+			payload = append(payload, mqttString(p.password)...)
+		}
+	}
+	body := append(mqttString("MQTT"), 4, flags, byte(mqttKeepAlive/time.Second>>8), byte(mqttKeepAlive/time.Second))
+	body = append(body, payload...)
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+	if _, err := conn.Write(mqttPacket(0x10, body)); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	var ack [4]byte
+	if _, err := io.ReadFull(conn, ack[:]); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("no CONNACK: %w", err)
+	}
+	if ack[0] != 0x20 || ack[3] != 0 {
+		conn.Close()
+		return nil, fmt.Errorf("connection refused (code %d)", ack[3])
+	}
+	conn.SetDeadline(time.Time{})
+	return conn, nil
+}
+
+// close sends what is queued and disconnects, waiting at most a few
+// seconds for an unreachable broker.
+func (p *mqttPublisher) close() {
+	if p == nil {
+		return
+	}
+	close(p.stop)
+	select {
+	case <-p.done:
+	case <-time.After(5 * time.Second):
+	}
+}
+
+func mqttPublishPacket(m mqttMessage) []byte {
+	header := byte(0x30) // PUBLISH, QoS 0
+	if m.retain {
+		header |= 0x01
+	}
+	return mqttPacket(header, append(mqttString(m.topic), m.payload...))
+}
+
+// mqttPacket prefixes body with a fixed header and its remaining length.
+func mqttPacket(header byte, body []byte) []byte {
+	packet := []byte{header}
+	n := len(body)
+	for {
+		b := byte(n % 128)
+		if n /= 128; n > 0 {
+			b |= 0x80
+		}
+		packet = append(packet, b)
+		if n == 0 {
+			break
+		}
+	}
+	return append(packet, body...)
+}
+
+func mqttString(s string) []byte {
+	return append([]byte{byte(len(s) >> 8), byte(len(s))}, s...)
+}
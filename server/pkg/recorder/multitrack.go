@@ -0,0 +1,236 @@
+package recorder
+
+import (
+	"sync"
+	"time"
+)
+
+var multitrackLog = logger("multitrack")
+
+const (
+	multitrackAddr    = "multitrack"           // Address the multitrack recording is filed under
+	multitrackLatency = 500 * time.Millisecond // How long to wait for late or reordered packets
+	multitrackResync  = 2 * time.Second        // Timestamp jumps beyond this re-anchor a track
+)
+
+// mtTrack places the packets of one stream on the multitrack timeline.
+type mtTrack struct {
+	addr     string
+	index    int   // 0-based track number
+	base     int64 // Timeline frame of RTP timestamp extension 0
+	lastTS   uint32
+	ext      int64 // RTP timestamp extended past 32-bit wraparound
+	lastFeed time.Time
+}
+
+// multitrack records every concurrent stream into one file with a track of
+// cfg.channels channels per stream. Each stream is placed on a shared
+// timeline by its RTP timestamps, anchored at the arrival of its first packet,
+// so its audio stays sample-accurate across jitter, reordering and packet
+// loss (which becomes silence). Output lags the wall clock by
+// multitrackLatency to give late packets a chance.
+type multitrack struct {
+	srv *server
+	cfg *Config // srv.cfg with the channel count of the whole file
+
+	mu      sync.Mutex
+	tracks  []*mtTrack // By track number, nil if free
+	names   []string   // Latest source of each track, kept after it leaves
+	byAddr  map[string]*mtTrack
+	start   time.Time
+	flushed int64 // Timeline frame up to which audio was written
+	window  []int // Interleaved frames from flushed on, not yet written
+	late    int64 // Packets that arrived after their audio was written
+	out     *Client
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+func newMultitrack(srv *server) *multitrack {
+	cfg := *srv.cfg
+	cfg.channels = srv.cfg.channels * srv.cfg.multitrack
+	cfg.dtmf = false // Digits are detected on the source streams
+	return &multitrack{
+		srv:    srv,
+		cfg:    &cfg,
+		tracks: make([]*mtTrack, srv.cfg.multitrack),
+		names:  make([]string, srv.cfg.multitrack),
+		byAddr: make(map[string]*mtTrack),
+		start:  time.Now(),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+}
+
+// now returns the current position of the timeline in frames.
+func (m *multitrack) now() int64 {
+	return int64(time.Since(m.start) * time.Duration(m.cfg.sampleRate) / time.Second)
+}
+
+// feed places the samples of one RTP packet on the timeline.
+func (m *multitrack) feed(addr string, timestamp uint32, samples []int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	t := m.byAddr[addr]
+	if t == nil {
+		if t = m.assign(addr, timestamp); t == nil {
+			return
+		}
+	} else {
+		t.ext += int64(int32(timestamp - t.lastTS))
+		t.lastTS = timestamp
+	}
+	t.lastFeed = time.Now()
+
+	// A stream that restarted its timestamps, or whose clock drifted too
+	// far from ours, is re-anchored at the current time
+	now := m.now()
+	resync := int64(multitrackResync * time.Duration(m.cfg.sampleRate) / time.Second)
+	if pos := t.base + t.ext; pos > now+resync || pos < now-resync {
+		t.base = now - t.ext
+		multitrackLog.Info("🎚️  Resynchronized track", "track", t.index+1, "addr", addr)
+	}
+
+	ch := m.srv.cfg.channels
+	outCh := m.cfg.channels
+	pos := t.base + t.ext
+	frames := int64(len(samples) / ch)
+	if pos+frames <= m.flushed {
+		m.late++
+		if m.late == 1 || m.late%100 == 0 {
+			multitrackLog.Warn("Packets arrived too late to be recorded", "late", m.late)
+		}
+		return
+	}
+
+	// Grow the window to cover the packet, then copy it into the track's
+	// channels; audio before flushed was already written and is skipped
+	if end := int((pos + frames - m.flushed) * int64(outCh)); end > len(m.window) {
+		m.window = append(m.window, make([]int, end-len(m.window))...)
+	}
+	for f := int64(0); f < frames; f++ {
+		if pos+f < m.flushed {
+			continue
+		}
+		dst := int(pos+f-m.flushed)*outCh + t.index*ch
+		copy(m.window[dst:dst+ch], samples[int(f)*ch:int(f+1)*ch])
+	}
+}
+
+// assign gives a new stream the first free track, returning nil if all are
+// taken.
+func (m *multitrack) assign(addr string, timestamp uint32) *mtTrack {
+	for i, t := range m.tracks {
+		if t != nil {
+			continue
+		}
+		t = &mtTrack{addr: addr, index: i, base: m.now(), lastTS: timestamp}
+		m.tracks[i] = t
+		m.names[i] = addr
+		m.byAddr[addr] = t
+		multitrackLog.Info("🎚️  Stream on track", "addr", addr, "track", i+1)
+		return t
+	}
+	if _, seen := m.byAddr[addr]; !seen {
+		multitrackLog.Warn("All tracks in use, not recording stream", "tracks", len(m.tracks), "addr", addr)
+		m.byAddr[addr] = nil // Remember it was told, until a track frees up
+	}
+	return nil
+}
+
+// run writes the timeline up to multitrackLatency ago every mixTick, until
+// stop is closed.
+func (m *multitrack) run() {
+	defer close(m.done)
+	ticker := time.NewTicker(mixTick)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-m.stop:
+			return
+		case <-ticker.C:
+			if samples := m.take(m.now() - int64(multitrackLatency*time.Duration(m.cfg.sampleRate)/time.Second)); samples != nil {
+				m.record(samples)
+			}
+		}
+	}
+}
+
+// take removes the frames before due from the window. It returns nil while
+// no track is in use, so idle periods aren't recorded.
+func (m *multitrack) take(due int64) []int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if due <= m.flushed {
+		return nil
+	}
+
+	// Free the tracks of streams that went away
+	active := false
+	for i, t := range m.tracks {
+		if t == nil {
+			continue
+		}
+		if time.Since(t.lastFeed) > time.Duration(m.srv.cfg.idleTimeout) && m.srv.cfg.idleTimeout > 0 {
+			multitrackLog.Info("🎚️  Stream left track", "addr", t.addr, "track", i+1)
+			m.tracks[i] = nil
+			for addr, t := range m.byAddr {
+				if t == nil || t.index == i {
+					delete(m.byAddr, addr)
+				}
+			}
+			continue
+		}
+		active = true
+	}
+
+	n := int(due-m.flushed) * m.cfg.channels
+	m.flushed = due
+	if n > len(m.window) {
+		m.window = append(m.window, make([]int, n-len(m.window))...)
+	}
+	samples := append([]int(nil), m.window[:n]...)
+	m.window = append(m.window[:0], m.window[n:]...)
+	if !active {
+		return nil
+	}
+	return samples
+}
+
+// trackNames returns the latest source address of each track, "" for tracks
+// never used.
+func (m *multitrack) trackNames() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]string(nil), m.names...)
+}
+
+// record hands timeline audio to the multitrack recording, starting it if
+// needed.
+func (m *multitrack) record(samples []int) {
+	if m.out == nil {
+		out, err := newClientConfig(m.srv, m.cfg, multitrackAddr, 0, nil)
+		if err != nil {
+			multitrackLog.Error("Creating multitrack recording failed", "err", err)
+			return
+		}
+		out.mu.Lock()
+		out.trackNames = m.trackNames
+		out.mu.Unlock()
+		out.log.Info("📝 Recording multitrack", "tracks", len(m.tracks), "file", out.fileName())
+		m.out = out
+	}
+	m.out.touch()
+	m.out.write(samples)
+}
+
+// close stops the multitrack recorder and finalizes its recording.
+func (m *multitrack) close() {
+	close(m.stop)
+	<-m.done
+	if m.out != nil {
+		m.out.close()
+	}
+}
@@ -0,0 +1,166 @@
+package recorder
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"path/filepath"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// templateVars are the values available to the output filename template.
+type templateVars struct {
+	addr    string // Remote address as host:port
+	session string
+	ssrc    uint32
+	start   time.Time // When the file was opened
+	part    int       // 1-based segment index within the session
+
+	title, operator string // Told by the client on -metadata-addr, empty if not
+}
+
+// expandTemplate fills in the placeholders of a filename template such as
+// "{date}/{session}/{addr}_{start}.wav". Supported placeholders are:
+//
+//	{addr}     remote address, e.g. 10.0.0.5_40000 or 2001-db8--5_40000
+//	{ip}       remote IP address, with the colons of IPv6 as dashes
+//	{port}     remote UDP port
+//	{session}  random session ID
+//	{ssrc}     RTP SSRC in hex
+//	{start}    time the file was opened, as a Unix timestamp
+//	{date}     date the file was opened (2006-01-02)
+//	{time}     time of day the file was opened (150405)
+//	{part}     segment index, starting at 1
+//	{title}    title the client told on -metadata-addr, empty if none
+//	{operator} operator the client told on -metadata-addr, empty if none
+//
+// When a session is rotated into several files and the template has no {part}
+// placeholder, "_partN" is inserted before the extension to keep names unique.
+//
+// Substituted values are sanitized so they can never introduce extra path
+// components; only slashes written in the template itself create directories.
+func expandTemplate(tmpl string, v templateVars) string {
+	if v.part > 1 && !strings.Contains(tmpl, "{part}") {
+		ext := filepath.Ext(tmpl)
+		if !audioExt(ext) {
+			ext = ""
+		}
+		tmpl = strings.TrimSuffix(tmpl, ext) + "_part{part}" + ext
+	}
+
+	addr := sanitizeFileName(v.addr)
+	host, port, err := net.SplitHostPort(v.addr)
+	if err != nil {
+		host = v.addr
+	} else {
+		host = fileNameIP(host)
+		addr = sanitizeFileName(host) + "_" + sanitizeFileName(port)
+	}
+	r := strings.NewReplacer(
+		"{addr}", addr,
+		"{ip}", sanitizeFileName(host),
+		"{port}", sanitizeFileName(port),
+		"{session}", sanitizeFileName(v.session),
+		"{ssrc}", fmt.Sprintf("%08x", v.ssrc),
+		"{start}", fmt.Sprintf("%d", v.start.Unix()),
+		"{date}", v.start.Format("2006-01-02"),
+		"{time}", v.start.Format("150405"),
+		"{part}", fmt.Sprintf("%d", v.part),
+		"{title}", sanitizeMetadata(v.title),
+		"{operator}", sanitizeMetadata(v.operator),
+	)
+	return r.Replace(tmpl)
+}
+
+const maxNameTries = 1000 // Numbered names tried for a file whose name is taken
+
+// withFormat gives name, expanded from tmpl, the extension of the output
+// format. The extension is the template's, not the name's, whose substituted
+// values may have dots of their own, as an IP does: one of an audio format,
+// as in {session}.wav, is replaced, and any other is kept with the format's
+// appended, so {ip}_{port} is 10.0.0.5_40000.wav.
+func withFormat(tmpl, name, format string) string {
+	ext := filepath.Ext(tmpl)
+	if audioExt(ext) && strings.HasSuffix(name, ext) {
+		name = strings.TrimSuffix(name, ext)
+	}
+	return name + "." + format
+}
+
+// audioExt reports whether ext, as in .wav, is the extension of an output
+// format, built-in or registered, or of Matroska video.
+func audioExt(ext string) bool {
+	format := strings.ToLower(strings.TrimPrefix(ext, "."))
+	switch format {
+	case formatWAV, formatMKA, formatWebM, formatFLAC, "mkv":
+		return true
+	}
+	_, registered := registeredFormat(format)
+	return registered
+}
+
+// numberedName returns the nth name to try for a file at path: path itself,
+// then path with -1, -2... before its extension.
+func numberedName(path string, n int) string {
+	if n == 0 {
+		return path
+	}
+	ext := filepath.Ext(path)
+	return fmt.Sprintf("%s-%d%s", strings.TrimSuffix(path, ext), n, ext)
+}
+
+// sanitizeFileName replaces characters that are not safe in a file name.
+func sanitizeFileName(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case ':', '/', '\\', '[', ']', '%':
+			return '_'
+		}
+		return r
+	}, s)
+}
+
+// sanitizeMetadata makes what a client told of its session safe in a file
+// name: sanitized, without control characters, at most 64 bytes, and never
+// a name of its own like . or .., which would climb out of the directory.
+func sanitizeMetadata(s string) string {
+	s = strings.Map(func(r rune) rune {
+		if r < ' ' || r == 0x7f {
+			return -1
+		}
+		return r
+	}, sanitizeFileName(s))
+	for len(s) > 64 {
+		_, size := utf8.DecodeLastRuneInString(s)
+		s = s[:len(s)-size]
+	}
+	if strings.Trim(s, ".") == "" && s != "" {
+		return strings.Repeat("_", len(s))
+	}
+	return s
+}
+
+// fileNameIP writes an IPv6 address with dashes for its colons, and for the
+// % of its zone, as in 2001-db8--5 or fe80--1-eth0, so it stays readable
+// where sanitizeFileName would run it into the port. One starting with :: is
+// written from 0::, as a name starting with a dash reads as an option to
+// most commands: ::1 is 0--1.
+func fileNameIP(ip string) string {
+	if strings.HasPrefix(ip, ":") {
+		ip = "0" + ip
+	}
+	return strings.NewReplacer(":", "-", "%", "-").Replace(ip)
+}
+
+// newSessionID returns a short random identifier for a recording session.
+func newSessionID() string {
+	b := make([]byte, 4)
+	if _, err := rand.Read(b); err != nil {
+		// Extremely unlikely; fall back to something that is still unique enough.
+		return fmt.Sprintf("%08x", time.Now().UnixNano()&0xffffffff)
+	}
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,176 @@
+package recorder
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fcerini/audio-capture-shared/pkg/stun"
+)
+
+var natLog = logger("nat")
+
+const (
+	stunInterval  = 25 * time.Second // How often the STUN server is asked again, shorter than most NATs keep an idle mapping
+	stunRetry     = time.Second      // How soon an unanswered request is sent again
+	stunAttempts  = 4                // Requests sent a stunRetry apart before waiting a stunInterval
+	punchInterval = 5 * time.Second  // How often a packet goes to each -punch address
+)
+
+// addrList is the -punch flag: host:port addresses, comma-separated.
+type addrList []string
+
+func (l *addrList) String() string {
+	if l == nil {
+		return ""
+	}
+	return strings.Join(*l, ",")
+}
+
+func (l *addrList) Set(s string) error {
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if _, _, err := net.SplitHostPort(part); err != nil {
+			return fmt.Errorf("invalid address %q (use host:port, e.g. 198.51.100.4:40000)", part)
+		}
+		*l = append(*l, part)
+	}
+	return nil
+}
+
+// natTraversal keeps the RTP port reachable through a NAT in front of the
+// server: it learns the port's reflexive address from the STUN server of
+// -stun, asking again to keep the NAT's mapping open, and sends a packet to
+// every -punch address now and then, so the NAT lets in what the senders
+// behind them send to the reflexive address (hole punching). The senders
+// have to send from the reflexive addresses -punch names, as the client does
+// with its own -stun.
+type natTraversal struct {
+	s *server
+
+	mu        sync.Mutex
+	pending   stun.TxID      // Of the latest request to the STUN server
+	answered  bool           // Whether it was answered
+	reflexive netip.AddrPort // From the latest answer
+}
+
+// keepMapped asks the STUN server for the RTP port's reflexive address
+// until ctx is done.
+func (n *natTraversal) keepMapped(ctx context.Context) {
+	misses := 0
+	for {
+		n.mu.Lock()
+		answered := n.answered
+		n.mu.Unlock()
+		wait := stunInterval
+		switch {
+		case answered:
+			misses = 0
+		case misses < stunAttempts:
+			wait = stunRetry
+		case misses == stunAttempts:
+			natLog.Warn("🌐 The STUN server doesn't answer, asking it again later", "stun", n.s.cfg.stunServer, "attempts", misses)
+		}
+		if n.request() {
+			misses++
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+// request sends a new request to the STUN server, reporting whether it went
+// out.
+func (n *natTraversal) request() bool {
+	addr, err := net.ResolveUDPAddr("udp", n.s.cfg.stunServer)
+	if err != nil {
+		natLog.Warn("Resolving the STUN server failed", "stun", n.s.cfg.stunServer, "err", err)
+		return false
+	}
+	req, id := stun.Request()
+	n.mu.Lock()
+	n.pending, n.answered = id, false
+	n.mu.Unlock()
+	if _, err := n.s.listener.WriteToUDP(req, addr); err != nil {
+		natLog.Warn("Sending to the STUN server failed", "stun", n.s.cfg.stunServer, "err", err)
+		return false
+	}
+	return true
+}
+
+// punch sends a STUN request, which needs no answer, to every -punch
+// address until ctx is done.
+func (n *natTraversal) punch(ctx context.Context) {
+	ticker := time.NewTicker(punchInterval)
+	defer ticker.Stop()
+	for {
+		for _, target := range n.s.cfg.punch {
+			addr, err := net.ResolveUDPAddr("udp", target)
+			if err != nil {
+				natLog.Debug("Resolving a -punch address failed", "addr", target, "err", err)
+				continue
+			}
+			req, _ := stun.Request()
+			if _, err := n.s.listener.WriteToUDP(req, addr); err != nil {
+				natLog.Debug("Punching failed", "addr", target, "err", err)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// handleSTUN answers a Binding request from an allowed sender with its
+// reflexive address, as a STUN server would, so senders can learn theirs
+// from the server itself, or as an ICE check with -ice, and takes the STUN
+// server's answers. A request from a sender keeps its session from idling.
+func (s *server) handleSTUN(b []byte, addr netip.AddrPort) {
+	if stun.IsRequest(b) {
+		if !s.access.allowed(addr.Addr()) {
+			return
+		}
+		if s.ice != nil && s.ice.answer(b, addr) {
+			return
+		}
+		// A sender's keepalives hold its session open while it is paused
+		if client, ok := s.clients.get(addr.String()); ok {
+			client.touch()
+		}
+		if err := s.reply(stun.Response(b, addr), addr); err != nil {
+			natLog.Debug("Answering a STUN request failed", "addr", addr, "err", err)
+		}
+		return
+	}
+	n := s.nat
+	if n == nil {
+		return
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.answered || stun.ID(b) != n.pending {
+		return // Not ours, or answered twice
+	}
+	n.answered = true
+	reflexive, err := stun.Parse(b)
+	if err != nil {
+		natLog.Warn("The STUN server answered with an error", "stun", s.cfg.stunServer, "err", err)
+		return
+	}
+	switch {
+	case !n.reflexive.IsValid():
+		mainLog.Info("🌐 Reachable through the NAT", "reflexive_addr", reflexive, "stun", s.cfg.stunServer)
+	case reflexive != n.reflexive:
+		natLog.Warn("🌐 The NAT mapped the RTP port to a new address", "reflexive_addr", reflexive, "previous", n.reflexive)
+	}
+	n.reflexive = reflexive
+}
@@ -0,0 +1,96 @@
+package recorder
+
+import (
+	"sync"
+	"time"
+)
+
+// networkStats are the RTP reception statistics of a stream, as defined for
+// RTCP receiver reports (RFC 3550).
+type networkStats struct {
+	Received    int64   `json:"packets_received"`
+	Bytes       int64   `json:"bytes_received"` // Whole packets, headers included
+	Lost        int64   `json:"packets_lost"`
+	LossPercent float64 `json:"loss_percent"`
+	JitterMS    float64 `json:"jitter_ms"` // Interarrival jitter
+}
+
+// rtpReceiver tracks sequence numbers and arrival times of a stream's
+// packets, following RFC 3550 appendices A.1 and A.8.
+type rtpReceiver struct {
+	clockRate float64
+
+	mu          sync.Mutex
+	started     bool
+	baseSeq     uint32
+	maxSeq      uint16
+	cycles      uint32 // Sequence number wraparounds, shifted by 16
+	received    int64
+	bytes       int64
+	prevTS      uint32
+	prevArrival time.Time
+	jitter      float64 // In timestamp units
+
+	expectedPrior int64 // At the previous RTCP report
+	receivedPrior int64
+	lastSR        uint32    // Middle 32 bits of the NTP time of the sender's latest report
+	lastSRAt      time.Time // When it arrived
+}
+
+func newRTPReceiver(clockRate int) *rtpReceiver {
+	return &rtpReceiver{clockRate: float64(clockRate)}
+}
+
+// packet records the arrival of a packet of size bytes. Only audio packets
+// count towards the jitter: the packets of a telephone-event all carry its
+// start time.
+func (r *rtpReceiver) packet(seq uint16, timestamp uint32, size int, arrival time.Time, audio bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if !r.started {
+		r.started = true
+		r.baseSeq, r.maxSeq = uint32(seq), seq
+	} else if delta := seq - r.maxSeq; delta < 1<<15 {
+		// In order, possibly with a gap
+		if seq < r.maxSeq {
+			r.cycles += 1 << 16
+		}
+		r.maxSeq = seq
+	}
+	r.received++
+	r.bytes += int64(size)
+	if !audio {
+		return
+	}
+
+	// The difference in transit time to the previous packet, in timestamp
+	// units; the signed difference of timestamps survives their wraparound
+	if !r.prevArrival.IsZero() {
+		d := arrival.Sub(r.prevArrival).Seconds()*r.clockRate - float64(int32(timestamp-r.prevTS))
+		if d < 0 {
+			d = -d
+		}
+		r.jitter += (d - r.jitter) / 16
+	}
+	r.prevTS, r.prevArrival = timestamp, arrival
+}
+
+func (r *rtpReceiver) stats() networkStats {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.started {
+		return networkStats{}
+	}
+	expected := int64(r.cycles+uint32(r.maxSeq)) - int64(r.baseSeq) + 1
+	st := networkStats{
+		Received: r.received,
+		Bytes:    r.bytes,
+		Lost:     max(0, expected-r.received), // Duplicates can outnumber losses
+		JitterMS: r.jitter / r.clockRate * 1000,
+	}
+	if expected > 0 {
+		st.LossPercent = float64(st.Lost) / float64(expected) * 100
+	}
+	return st
+}
@@ -0,0 +1,148 @@
+package recorder
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// EBU R128 parameters used besides the -normalize target.
+const (
+	normalizeTruePeak = -1.5 // dBTP
+	normalizeLRA      = 11   // LU
+)
+
+// loudness is the result of normalizing a file, stored in its sidecar.
+type loudness struct {
+	InputI    float64 `json:"input_i"`   // Integrated loudness before, in LUFS
+	InputTP   float64 `json:"input_tp"`  // True peak before, in dBTP
+	InputLRA  float64 `json:"input_lra"` // Loudness range before, in LU
+	TargetI   float64 `json:"target_i"`  // Integrated loudness aimed for, in LUFS
+	OutputI   float64 `json:"output_i"`  // Integrated loudness after, in LUFS
+	OutputTP  float64 `json:"output_tp"` // True peak after, in dBTP
+	Normalize string  `json:"normalize"` // ffmpeg's normalization type: dynamic or linear
+}
+
+// loudnormStats is the JSON printed by ffmpeg's loudnorm filter. All values
+// are strings.
+type loudnormStats struct {
+	InputI            string `json:"input_i"`
+	InputTP           string `json:"input_tp"`
+	InputLRA          string `json:"input_lra"`
+	InputThresh       string `json:"input_thresh"`
+	OutputI           string `json:"output_i"`
+	OutputTP          string `json:"output_tp"`
+	NormalizationType string `json:"normalization_type"`
+	TargetOffset      string `json:"target_offset"`
+}
+
+// lufs is a flag value holding a loudness target such as "-16LUFS".
+type lufs float64
+
+func (l *lufs) String() string {
+	if l == nil || *l == 0 {
+		return ""
+	}
+	return strconv.FormatFloat(float64(*l), 'g', -1, 64) + "LUFS"
+}
+
+func (l *lufs) Set(s string) error {
+	v, err := strconv.ParseFloat(strings.TrimSuffix(strings.ToUpper(strings.TrimSpace(s)), "LUFS"), 64)
+	if err != nil || v < -70 || v > -5 {
+		return fmt.Errorf("invalid loudness target %q (use e.g. -16LUFS, between -70 and -5)", s)
+	}
+	*l = lufs(v)
+	return nil
+}
+
+// normalizeLoudness rewrites a finished recording in place with a two-pass
+// EBU R128 loudness normalization by ffmpeg: the first pass measures the
+// file, the second applies the correction linearly where possible, writing
+// it in the recording's own format.
+func normalizeLoudness(cfg *Config, ff *finishedFile) (*loudness, error) {
+	path := ff.Path
+	target := float64(cfg.normalize)
+	filter := fmt.Sprintf("loudnorm=I=%g:TP=%g:LRA=%g", target, normalizeTruePeak, float64(normalizeLRA))
+
+	measured, err := runLoudnorm(path, filter+":print_format=json", "-f", "null", "-")
+	if err != nil {
+		return nil, fmt.Errorf("measuring: %w", err)
+	}
+
+	// loudnorm resamples to 192 kHz internally, so ask for the original rate
+	// and codec back
+	tmp := path + ".normalizing"
+	args := []string{"-ar", strconv.Itoa(ff.SampleRate), "-map_metadata", "0"}
+	switch {
+	case ff.Codec == codecOpus:
+		args = append(args, "-c:a", "libopus", "-b:a", cfg.bitrate)
+	case ff.Codec == codecFLAC:
+		args = append(args, "-c:a", "flac")
+	default:
+		args = append(args, "-c:a", fmt.Sprintf("pcm_s%dle", ff.BitDepth))
+	}
+	if cfg.bwf && ff.Format == formatWAV {
+		args = append(args, "-write_bext", "1")
+	}
+	args = append(args, "-f", map[string]string{formatWAV: "wav", formatMKA: "matroska", formatWebM: "webm", formatFLAC: "flac"}[ff.Format], "-y", tmp)
+	applied, err := runLoudnorm(path, fmt.Sprintf("%s:measured_I=%s:measured_TP=%s:measured_LRA=%s:measured_thresh=%s:offset=%s:linear=true:print_format=json",
+		filter, measured.InputI, measured.InputTP, measured.InputLRA, measured.InputThresh, measured.TargetOffset), args...)
+	if err != nil {
+		os.Remove(tmp)
+		return nil, fmt.Errorf("normalizing: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return nil, err
+	}
+
+	num := func(s string) float64 {
+		v, _ := strconv.ParseFloat(s, 64)
+		return v
+	}
+	return &loudness{
+		InputI:    num(measured.InputI),
+		InputTP:   num(measured.InputTP),
+		InputLRA:  num(measured.InputLRA),
+		TargetI:   target,
+		OutputI:   num(applied.OutputI),
+		OutputTP:  num(applied.OutputTP),
+		Normalize: strings.ToLower(applied.NormalizationType),
+	}, nil
+}
+
+// runLoudnorm runs ffmpeg with a loudnorm filter on path and parses the
+// statistics it prints at the end of its output.
+func runLoudnorm(path, filter string, outArgs ...string) (*loudnormStats, error) {
+	args := append([]string{"-hide_banner", "-nostats", "-i", path, "-af", filter}, outArgs...)
+	out, err := exec.Command("ffmpeg", args...).CombinedOutput()
+	if err != nil {
+		if msg := lastLine(out); msg != "" {
+			return nil, fmt.Errorf("ffmpeg: %v: %s", err, msg)
+		}
+		return nil, fmt.Errorf("ffmpeg: %w", err)
+	}
+	start, end := bytes.LastIndexByte(out, '{'), bytes.LastIndexByte(out, '}')
+	if start < 0 || end < start {
+		return nil, fmt.Errorf("no loudnorm statistics in ffmpeg output")
+	}
+	var st loudnormStats
+	if err := json.Unmarshal(out[start:end+1], &st); err != nil {
+		return nil, fmt.Errorf("parsing loudnorm statistics: %w", err)
+	}
+	if st.InputI == "" || st.InputI == "-inf" {
+		return nil, fmt.Errorf("file is silent")
+	}
+	return &st, nil
+}
+
+// lastLine returns the last non-empty line of command output, which for
+// ffmpeg usually holds the error.
+func lastLine(out []byte) string {
+	lines := strings.Split(strings.TrimSpace(string(out)), "\n")
+	return strings.TrimSpace(lines[len(lines)-1])
+}
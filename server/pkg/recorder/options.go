@@ -0,0 +1,109 @@
+package recorder
+
+import (
+	"log/slog"
+	"time"
+)
+
+// An Option changes a setting of the Config NewConfig returns, for programs
+// that configure the recorder in code rather than with flags. Each sets what
+// the flag it names does.
+type Option func(*Config)
+
+// NewConfig returns the defaults of the server's flags with opts applied,
+// validated as ParseConfig validates flags:
+//
+//	cfg, err := recorder.NewConfig(
+//		recorder.WithPort(6001),
+//		recorder.WithOutDir("recordings"),
+//		recorder.WithOutput("mka", ""),
+//	)
+//
+// Flags and the AUDIO_CAPTURE_* environment aren't read.
+func NewConfig(opts ...Option) (*Config, error) {
+	cfg := &Config{}
+	cfg.flagSet()
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// WithPort sets the UDP port RTP is taken on, as -port does.
+func WithPort(port int) Option {
+	return func(cfg *Config) { cfg.port = port }
+}
+
+// WithListen sets the IP address the RTP port is bound to, as -listen does.
+func WithListen(ip string) Option {
+	return func(cfg *Config) { cfg.listen = ip }
+}
+
+// WithStreamFormat sets the sample rate, bit depth and channel count of the
+// incoming streams, as -rate, -bits and -channels do.
+func WithStreamFormat(sampleRate, bitDepth, channels int) Option {
+	return func(cfg *Config) {
+		cfg.sampleRate, cfg.bitDepth, cfg.channels = sampleRate, bitDepth, channels
+	}
+}
+
+// WithOutDir sets the directory recordings are written under, as -out-dir
+// does.
+func WithOutDir(dir string) Option {
+	return func(cfg *Config) { cfg.outDir = dir }
+}
+
+// WithTemplate sets the filename template of recordings, as -template does.
+func WithTemplate(tmpl string) Option {
+	return func(cfg *Config) { cfg.fileTemplate = tmpl }
+}
+
+// WithOutput sets the output container and the codec stored in it, as
+// -format and -codec do. An empty codec is the format's default.
+func WithOutput(format, codec string) Option {
+	return func(cfg *Config) { cfg.format, cfg.codec = format, codec }
+}
+
+// WithIdleTimeout sets how long a stream may send nothing before its
+// recording is finalized, as -idle-timeout does.
+func WithIdleTimeout(d time.Duration) Option {
+	return func(cfg *Config) { cfg.idleTimeout = duration(d) }
+}
+
+// WithReorderWindow sets how long packets wait for those missing before
+// them, as -reorder-window does.
+func WithReorderWindow(d time.Duration) Option {
+	return func(cfg *Config) { cfg.reorderWindow = duration(d) }
+}
+
+// WithClientLimits sets the most concurrent streams in all and from one IP,
+// as -max-clients and -max-clients-per-ip do.
+func WithClientLimits(total, perIP int) Option {
+	return func(cfg *Config) { cfg.maxClients, cfg.maxPerIP = total, perIP }
+}
+
+// WithQueueSize sets the decoded buffers queued per stream, as -queue-size
+// does.
+func WithQueueSize(n int) Option {
+	return func(cfg *Config) { cfg.queueSize = n }
+}
+
+// WithStatsAddr sets the address of the HTTP stats, API and dashboard
+// server, as -stats-addr does.
+func WithStatsAddr(addr string) Option {
+	return func(cfg *Config) { cfg.statsAddr = addr }
+}
+
+// WithGRPCAddr sets the address of the gRPC API, as -grpc-addr does.
+func WithGRPCAddr(addr string) Option {
+	return func(cfg *Config) { cfg.grpcAddr = addr }
+}
+
+// WithLogging sets the level and the format, text or json, SetupLogging
+// applies, as -log-level and -log-format do.
+func WithLogging(level slog.Level, format string) Option {
+	return func(cfg *Config) { cfg.logLevel, cfg.logFormat = level, format }
+}
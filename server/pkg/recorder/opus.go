@@ -0,0 +1,215 @@
+package recorder
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os/exec"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/fcerini/audio-capture-shared/pkg/ogg"
+)
+
+// opusRate is the sample rate Opus packets are timed in.
+const opusRate = 48000
+
+// opusPacket is an encoded Opus packet with the time of its first sample.
+type opusPacket struct {
+	time      time.Time
+	timestamp uint32 // RTP timestamp, in units of the stream's sample rate
+	samples   int    // At opusRate
+	data      []byte
+}
+
+// opusStream encodes live audio to Opus packets through ffmpeg, for one
+// subscriber. Packets are timed from the first audio fed, counting the
+// samples they hold.
+type opusStream struct {
+	cfg   *Config
+	cmd   *exec.Cmd
+	stdin io.WriteCloser
+	in    chan liveAudio // To the goroutine feeding ffmpeg
+	out   chan opusPacket
+	done  chan struct{} // Closed by close
+	once  sync.Once     // Closes in
+
+	mu        sync.Mutex
+	first     liveAudio // Of the first audio fed
+	haveFirst bool
+	stderr    bytes.Buffer
+	failed    error
+}
+
+func newOpusStream(cfg *Config) (*opusStream, error) {
+	cmd := exec.Command("ffmpeg", "-hide_banner", "-loglevel", "error", "-nostdin",
+		"-f", "s"+strconv.Itoa(cfg.bitDepth)+"le", "-ar", strconv.Itoa(cfg.sampleRate), "-ac", strconv.Itoa(cfg.channels), "-i", "pipe:0",
+		"-c:a", "libopus", "-b:a", cfg.bitrate, "-frame_duration", "20", "-page_duration", "20000", "-flush_packets", "1", "-f", "ogg", "pipe:1") // A page per packet, for latency
+	o := &opusStream{
+		cfg:  cfg,
+		cmd:  cmd,
+		in:   make(chan liveAudio, liveQueue),
+		out:  make(chan opusPacket, liveQueue),
+		done: make(chan struct{}),
+	}
+	cmd.Stderr = &lockedWriter{mu: &o.mu, w: &o.stderr}
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start ffmpeg: %w", err)
+	}
+	o.stdin = stdin
+	go o.feed()
+	go o.demux(stdout)
+	return o, nil
+}
+
+// encode queues audio for ffmpeg without blocking, reporting whether it fit.
+func (o *opusStream) encode(a liveAudio) bool {
+	o.mu.Lock()
+	if !o.haveFirst {
+		o.first, o.haveFirst = a, true
+	}
+	o.mu.Unlock()
+	select {
+	case o.in <- a:
+		return true
+	default:
+		return false
+	}
+}
+
+// finish makes ffmpeg encode what it has and exit, closing packets.
+func (o *opusStream) finish() {
+	o.once.Do(func() { close(o.in) })
+}
+
+func (o *opusStream) feed() {
+	defer o.stdin.Close()
+	var buf []byte
+	for a := range o.in {
+		buf = appendPCMLE(buf[:0], a.samples, o.cfg.bitDepth)
+		if _, err := o.stdin.Write(buf); err != nil {
+			for range o.in {
+			}
+			return
+		}
+	}
+}
+
+// packets returns the encoded packets, closed once ffmpeg exits. It is nil,
+// blocking forever, on a nil stream.
+func (o *opusStream) packets() <-chan opusPacket {
+	if o == nil {
+		return nil
+	}
+	return o.out
+}
+
+// err explains why ffmpeg stopped early.
+func (o *opusStream) err() error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.stderr.Len() > 0 {
+		return fmt.Errorf("%v: %s", o.failed, lastLine(o.stderr.Bytes()))
+	}
+	return o.failed
+}
+
+// close stops ffmpeg.
+func (o *opusStream) close() {
+	o.finish()
+	close(o.done)
+	o.cmd.Process.Kill()
+	o.cmd.Wait()
+}
+
+// demux splits ffmpeg's Ogg output into packets, skipping the OpusHead and
+// OpusTags headers.
+func (o *opusStream) demux(r io.Reader) {
+	defer close(o.out)
+	pages := ogg.NewReader(r)
+	var (
+		n   int   // Packets seen, including the headers
+		pos int64 // Samples at opusRate before the next packet
+	)
+	for {
+		packet, err := pages.Packet()
+		if err != nil {
+			o.stopped(err)
+			return
+		}
+		if n++; n <= 2 || len(packet) == 0 {
+			continue
+		}
+		o.mu.Lock()
+		first := o.first
+		o.mu.Unlock()
+		samples := opusSamples(packet)
+		pkt := opusPacket{
+			time:      first.time.Add(time.Duration(pos) * time.Second / opusRate),
+			timestamp: first.timestamp + uint32(pos*int64(o.cfg.sampleRate)/opusRate),
+			samples:   samples,
+			data:      packet,
+		}
+		select {
+		case o.out <- pkt:
+		case <-o.done:
+			return
+		}
+		pos += int64(samples)
+	}
+}
+
+func (o *opusStream) stopped(err error) {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		err = fmt.Errorf("ffmpeg exited")
+	}
+	o.mu.Lock()
+	o.failed = err
+	o.mu.Unlock()
+}
+
+// opusSamples returns the duration of an Opus packet at 48 kHz, from its
+// TOC byte (RFC 6716 section 3.1).
+func opusSamples(packet []byte) int {
+	config := int(packet[0] >> 3)
+	var frame int // In units of 2.5 ms, 120 samples
+	switch {
+	case config < 12: // SILK: 10, 20, 40 or 60 ms
+		frame = []int{4, 8, 16, 24}[config%4]
+	case config < 16: // Hybrid: 10 or 20 ms
+		frame = []int{4, 8}[config%2]
+	default: // CELT: 2.5, 5, 10 or 20 ms
+		frame = []int{1, 2, 4, 8}[config%4]
+	}
+	frames := 1
+	switch packet[0] & 3 {
+	case 1, 2:
+		frames = 2
+	case 3:
+		if len(packet) > 1 {
+			frames = int(packet[1] & 0x3f)
+		}
+	}
+	return frames * frame * 120
+}
+
+// lockedWriter serializes writes to w.
+type lockedWriter struct {
+	mu *sync.Mutex
+	w  io.Writer
+}
+
+func (l *lockedWriter) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.w.Write(p)
+}
@@ -0,0 +1,252 @@
+package recorder
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Encodings a payload type can be decoded from.
+const (
+	encodingL16  = "L16"
+	encodingL24  = "L24"
+	encodingPCMU = "PCMU" // G.711 µ-law
+	encodingPCMA = "PCMA" // G.711 A-law
+)
+
+// payloadFormat is how the packets of an RTP payload type are decoded. The
+// zero value is linear PCM in the format the stream is recorded in, -rate,
+// -channels and -bits or those of its handshake, as the dynamic payload
+// types carry by default; the others have a format of their own, which the
+// stream's session is recorded in when it starts with one.
+type payloadFormat struct {
+	encoding   string // L16, L24, PCMU or PCMA; empty for the stream's
+	sampleRate int
+	channels   int
+}
+
+// streamFormat is the format of the dynamic payload types, the stream's.
+var streamFormat = &payloadFormat{}
+
+// staticPayloadTypes are the payload types RFC 3551 assigns to the audio
+// the server decodes.
+var staticPayloadTypes = map[uint8]payloadFormat{
+	0:  {encoding: encodingPCMU, sampleRate: 8000, channels: 1},
+	8:  {encoding: encodingPCMA, sampleRate: 8000, channels: 1},
+	10: {encoding: encodingL16, sampleRate: 44100, channels: 2},
+	11: {encoding: encodingL16, sampleRate: 44100, channels: 1},
+}
+
+// bitDepth returns the bit depth of the decoded samples; G.711 is decoded to
+// 16 bits.
+func (f *payloadFormat) bitDepth() int {
+	if f.encoding == encodingL24 {
+		return 24
+	}
+	return 16
+}
+
+// fits reports whether packets of the format can be recorded in a session
+// of cfg.
+func (f *payloadFormat) fits(cfg *Config) bool {
+	return f.encoding == "" || f.sampleRate == cfg.sampleRate && f.channels == cfg.channels && f.bitDepth() == cfg.bitDepth
+}
+
+// adapt returns the configuration to record a stream starting with packets
+// of the format with: cfg, the server's or the one its handshake asked for,
+// when they fit it, or else a copy in the format.
+func (f *payloadFormat) adapt(cfg *Config) *Config {
+	if f.fits(cfg) {
+		return cfg
+	}
+	own := *cfg
+	own.sampleRate, own.channels, own.bitDepth = f.sampleRate, f.channels, f.bitDepth()
+	return &own
+}
+
+// decode converts a payload into interleaved samples of cfg, which the
+// format fits, into dst when it has room for them.
+func (f *payloadFormat) decode(dst []int, payload []byte, cfg *Config) []int {
+	switch f.encoding {
+	case encodingPCMU:
+		return decodeG711(dst, payload, &uLawTable, cfg.channels)
+	case encodingPCMA:
+		return decodeG711(dst, payload, &aLawTable, cfg.channels)
+	}
+	return decodePCM(dst, payload, cfg.bitDepth, cfg.channels)
+}
+
+// String describes the format as it is given to -payload-type, e.g.
+// PCMU/8000/1.
+func (f payloadFormat) String() string {
+	if f.encoding == "" {
+		return "stream"
+	}
+	return fmt.Sprintf("%s/%d/%d", f.encoding, f.sampleRate, f.channels)
+}
+
+// payloadTypes is the -payload-type flag, repeatable or comma-separated:
+// 97=L24/96000/2,3=PCMU. The rate and channels are 8000 Hz mono for G.711,
+// and those of -rate and -channels for L16 and L24, unless given.
+type payloadTypes map[uint8]payloadFormat
+
+func (p *payloadTypes) String() string {
+	if p == nil || len(*p) == 0 {
+		return ""
+	}
+	pts := make([]int, 0, len(*p))
+	for pt := range *p {
+		pts = append(pts, int(pt))
+	}
+	sort.Ints(pts)
+	parts := make([]string, len(pts))
+	for i, pt := range pts {
+		f := (*p)[uint8(pt)]
+		parts[i] = strconv.Itoa(pt) + "=" + f.encoding
+		if f.sampleRate > 0 {
+			parts[i] += "/" + strconv.Itoa(f.sampleRate)
+		}
+		if f.channels > 0 {
+			parts[i] += "/" + strconv.Itoa(f.channels)
+		}
+	}
+	return strings.Join(parts, ",")
+}
+
+func (p *payloadTypes) Set(s string) error {
+	if *p == nil {
+		*p = payloadTypes{}
+	}
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		key, val, ok := strings.Cut(part, "=")
+		pt, err := strconv.Atoi(key)
+		if !ok || err != nil || pt < 0 || pt > 127 {
+			return fmt.Errorf("invalid payload type %q (use pt=encoding[/rate[/channels]], e.g. 97=L24/96000/2)", part)
+		}
+		fields := strings.Split(val, "/")
+		f := payloadFormat{encoding: strings.ToUpper(fields[0])}
+		switch f.encoding {
+		case encodingL16, encodingL24, encodingPCMU, encodingPCMA:
+		default:
+			return fmt.Errorf("unknown encoding %q for payload type %d (use L16, L24, PCMU or PCMA)", fields[0], pt)
+		}
+		if len(fields) > 3 {
+			return fmt.Errorf("invalid payload type %q (use pt=encoding[/rate[/channels]], e.g. 97=L24/96000/2)", part)
+		}
+		for i, dst := range []*int{&f.sampleRate, &f.channels} {
+			if len(fields) > i+1 {
+				if *dst, err = strconv.Atoi(fields[i+1]); err != nil || *dst <= 0 {
+					return fmt.Errorf("invalid payload type %q (use pt=encoding[/rate[/channels]], e.g. 97=L24/96000/2)", part)
+				}
+			}
+		}
+		if f.channels > 8 {
+			return fmt.Errorf("unsupported channel count %d for payload type %d (use 1 to 8)", f.channels, pt)
+		}
+		(*p)[uint8(pt)] = f
+	}
+	return nil
+}
+
+// payloadTable is how the packets of each payload type are decoded, nil for
+// the types that aren't.
+type payloadTable [128]*payloadFormat
+
+// table returns how each payload type is decoded: the static types of RFC
+// 3551 the server knows, and the dynamic ones, 96 to 127, in the stream's
+// format, unless -payload-type maps them otherwise, with the defaults of cfg
+// filled in.
+func (p payloadTypes) table(cfg *Config) *payloadTable {
+	var t payloadTable
+	for pt := 96; pt < len(t); pt++ {
+		t[pt] = streamFormat
+	}
+	for pt, f := range staticPayloadTypes {
+		t[pt] = &f
+	}
+	for pt, f := range p {
+		switch {
+		case f.sampleRate == 0 && (f.encoding == encodingPCMU || f.encoding == encodingPCMA):
+			f.sampleRate = 8000
+		case f.sampleRate == 0:
+			f.sampleRate = cfg.sampleRate
+		}
+		switch {
+		case f.channels == 0 && (f.encoding == encodingPCMU || f.encoding == encodingPCMA):
+			f.channels = 1
+		case f.channels == 0:
+			f.channels = cfg.channels
+		}
+		t[pt] = &f
+	}
+	return &t
+}
+
+// payloadFormat returns how the packets of payload type pt from src are
+// decoded, or nil when they aren't. The packets -raw and WHIP make carry the
+// stream's format whatever their type, and those of the type a format
+// extension came with the format it signaled.
+func (s *server) payloadFormat(pt uint8, src *source) *payloadFormat {
+	if src.linear {
+		return streamFormat
+	}
+	if src.signaled != nil && pt == src.signaledPT {
+		return src.signaled
+	}
+	return src.config(s.cfg).payloads[pt&0x7f]
+}
+
+// The samples of each G.711 byte.
+var (
+	uLawTable = g711Table(uLawToLinear)
+	aLawTable = g711Table(aLawToLinear)
+)
+
+func g711Table(decode func(byte) int16) (t [256]int16) {
+	for i := range t {
+		t[i] = decode(byte(i))
+	}
+	return t
+}
+
+// decodeG711 converts a G.711 payload, a byte per sample, into interleaved
+// 16-bit samples through table, dropping the bytes short of a whole frame,
+// into dst when it has room for them.
+func decodeG711(dst []int, payload []byte, table *[256]int16, channels int) []int {
+	numSamples := len(payload) / channels * channels
+	samples := dst[:0]
+	if cap(samples) < numSamples {
+		samples = make([]int, numSamples)
+	}
+	samples = samples[:numSamples]
+	for i := range samples {
+		samples[i] = int(table[payload[i]])
+	}
+	return samples
+}
+
+// uLawToLinear decodes a sample of the µ-law of G.711.
+func uLawToLinear(b byte) int16 {
+	const bias = 0x84
+	b = ^b
+	v := (int(b&0x0f)<<3 + bias) << (b & 0x70 >> 4)
+	if b&0x80 != 0 {
+		return int16(bias - v)
+	}
+	return int16(v - bias)
+}
+
+// aLawToLinear decodes a sample of the A-law of G.711.
+func aLawToLinear(b byte) int16 {
+	b ^= 0x55
+	v := int(b&0x0f)<<4 + 8
+	if exponent := b & 0x70 >> 4; exponent > 0 {
+		v = (v + 0x100) << (exponent - 1)
+	}
+	if b&0x80 == 0 {
+		return int16(-v)
+	}
+	return int16(v)
+}
@@ -0,0 +1,50 @@
+package recorder
+
+// decodePCM converts a big-endian linear PCM payload (L16 or L24, RFC 3551)
+// into interleaved samples. Channels are already interleaved frame by frame on
+// the wire, which is also the WAV layout, so samples keep their order. Any
+// trailing bytes that do not make up a whole frame are dropped so the channels
+// never get shifted against each other. The samples are decoded into dst
+// when it has room for them.
+func decodePCM(dst []int, payload []byte, bitDepth, channels int) []int {
+	bytesPerSample := bitDepth / 8
+	frameSize := bytesPerSample * channels
+	numSamples := (len(payload) / frameSize) * channels
+
+	samples := dst[:0]
+	if cap(samples) < numSamples {
+		samples = make([]int, numSamples)
+	}
+	samples = samples[:numSamples]
+	// A loop per depth keeps the switch out of the work per sample
+	switch bitDepth {
+	case 16:
+		payload = payload[:numSamples*2]
+		for i := range samples {
+			b := payload[i*2 : i*2+2]
+			samples[i] = int(int16(uint16(b[0])<<8 | uint16(b[1])))
+		}
+	case 24:
+		payload = payload[:numSamples*3]
+		for i := range samples {
+			b := payload[i*3 : i*3+3]
+			// Sign-extend the 24-bit value through the top byte of an int32
+			samples[i] = int(int32(uint32(b[0])<<24|uint32(b[1])<<16|uint32(b[2])<<8) >> 8)
+		}
+	}
+	return samples
+}
+
+// appendPCMLE appends interleaved samples to buf as little-endian PCM, the
+// layout used by WAV and Matroska A_PCM/INT/LIT.
+func appendPCMLE(buf []byte, samples []int, bitDepth int) []byte {
+	for _, v := range samples {
+		switch bitDepth {
+		case 16:
+			buf = append(buf, byte(v), byte(v>>8))
+		case 24:
+			buf = append(buf, byte(v), byte(v>>8), byte(v>>16))
+		}
+	}
+	return buf
+}
@@ -0,0 +1,125 @@
+package recorder
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os/exec"
+	"strconv"
+)
+
+// playAll selects every stream for live playback.
+const playAll = "all"
+
+// player plays one stream on the server's default PulseAudio sink (or
+// -play-sink) through pacat. It has its own small buffer, so a stalled audio
+// device never holds up recording; audio that doesn't fit is skipped.
+type player struct {
+	cmd     *exec.Cmd
+	stdin   io.WriteCloser
+	samples chan []int
+	done    chan struct{}
+}
+
+// newPlayer starts pacat for a stream with the given name.
+func newPlayer(cfg *Config, name string) (*player, error) {
+	args := []string{
+		"--playback", "--raw",
+		"--format=s" + strconv.Itoa(cfg.bitDepth) + "le",
+		"--rate=" + strconv.Itoa(cfg.sampleRate),
+		"--channels=" + strconv.Itoa(cfg.channels),
+		"--latency-msec=100",
+		"--client-name=audio-capture-server",
+		"--stream-name=" + name,
+	}
+	if cfg.playSink != "" {
+		args = append(args, "--device="+cfg.playSink)
+	}
+	cmd := exec.Command("pacat", args...)
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start pacat: %w", err)
+	}
+
+	p := &player{cmd: cmd, stdin: stdin, samples: make(chan []int, 50), done: make(chan struct{})}
+	go p.run(cfg.bitDepth)
+	return p, nil
+}
+
+func (p *player) run(bitDepth int) {
+	defer close(p.done)
+	var buf []byte
+	for samples := range p.samples {
+		buf = appendPCMLE(buf[:0], samples, bitDepth)
+		if _, err := p.stdin.Write(buf); err != nil {
+			// pacat went away; drain until stopped
+			for range p.samples {
+			}
+			return
+		}
+	}
+}
+
+// play queues samples for playback without blocking.
+func (p *player) play(samples []int) {
+	select {
+	case p.samples <- samples:
+	default:
+	}
+}
+
+// stop ends playback and waits for pacat to exit. Closing stdin first also
+// unblocks a write to a pacat that stopped reading.
+func (p *player) stop() {
+	close(p.samples)
+	p.stdin.Close()
+	<-p.done
+	p.cmd.Wait()
+}
+
+// playing reports whether a client is selected by the -play target, which
+// is "all", a session ID or a client address.
+func playing(target string, c *Client) bool {
+	return target == playAll || (target != "" && (target == c.session || target == c.addr))
+}
+
+// setPlayTarget changes which streams are played and starts or stops the
+// players of the connected clients to match.
+func (s *server) setPlayTarget(target string) {
+	s.clientsMutex.Lock()
+	defer s.clientsMutex.Unlock()
+	s.playTarget = target
+	s.clients.each(func(_ string, c *Client) bool {
+		c.setPlaying(playing(target, c))
+		return true
+	})
+}
+
+// handlePlay serves the current -play target on GET /play and changes it on
+// POST /play?target=<session|addr|all>; an empty target stops playback.
+func (s *server) handlePlay(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		target := r.FormValue("target")
+		s.setPlayTarget(target)
+		if target == "" {
+			httpLog.Info("🔈 Live playback stopped")
+		} else {
+			httpLog.Info("🔊 Live playback", "target", target)
+		}
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	s.clientsMutex.Lock()
+	target := s.playTarget
+	s.clientsMutex.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"target": target})
+}
@@ -0,0 +1,37 @@
+package recorder
+
+import (
+	"github.com/fcerini/audio-capture-shared/pkg/plugin"
+)
+
+// startPlugins starts the session's own -plugin processes. A session whose
+// plugins fail to start is recorded without them.
+func (c *Client) startPlugins() {
+	chain, err := plugin.StartChain(c.cfg.plugins, plugin.Format{SampleRate: c.cfg.sampleRate, Channels: c.cfg.channels, BitDepth: c.cfg.bitDepth}, c.log)
+	if err != nil {
+		c.log.Error("Starting the plugins failed, recording without them", "err", err)
+		return
+	}
+	c.plugins = chain
+}
+
+// process runs samples through the session's plugins, in place, on the
+// writer goroutine.
+func (c *Client) process(samples []int) {
+	if c.plugins == nil {
+		return
+	}
+	c.pluginPCM = appendPCMLE(c.pluginPCM[:0], samples, c.cfg.bitDepth)
+	c.plugins.Process(c.pluginPCM)
+	b := c.pluginPCM
+	switch c.cfg.bitDepth {
+	case 16:
+		for i := range samples {
+			samples[i] = int(int16(uint16(b[i*2]) | uint16(b[i*2+1])<<8))
+		}
+	case 24:
+		for i := range samples {
+			samples[i] = int(int32(uint32(b[i*3])<<8|uint32(b[i*3+1])<<16|uint32(b[i*3+2])<<24) >> 8)
+		}
+	}
+}
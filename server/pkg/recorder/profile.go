@@ -0,0 +1,322 @@
+package recorder
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pion/rtp"
+
+	"github.com/fcerini/audio-capture-shared/pkg/stun"
+)
+
+// encodingOpus is the encoding of a -profile port whose streams are Opus,
+// decoded by ffmpeg as WHIP publishers' are.
+const encodingOpus = "OPUS"
+
+const maxOpusSenders = 64 // Per -profile port, each an ffmpeg decoding its stream
+
+// profile is one -profile port: a UDP port of its own whose streams are
+// decoded and recorded as it says rather than as the RTP port's flags do.
+type profile struct {
+	addr       string
+	encoding   string // L16, L24, PCMU, PCMA or OPUS
+	sampleRate int
+	channels   int
+	format     string // Of the recordings; the server's when empty
+	codec      string
+	dir        string // Under -out-dir
+	cfg        *Config
+}
+
+// profiles is the -profile flag, repeatable:
+// udp://:6002?encoding=pcmu&format=flac&dir=phones. The rate and channels
+// are 8000 Hz mono for G.711, 48000 Hz stereo for Opus and those of -rate
+// and -channels for L16 and L24, and the output that of -format and -codec,
+// unless given.
+type profiles struct {
+	list  []profile
+	specs []string
+}
+
+func (p *profiles) String() string {
+	if p == nil {
+		return ""
+	}
+	return strings.Join(p.specs, " ")
+}
+
+func (p *profiles) Set(s string) error {
+	u, err := url.Parse(s)
+	if err != nil || u.Scheme != "udp" || u.Host == "" {
+		return fmt.Errorf("invalid -profile %q (use udp:// and host:port, e.g. udp://:6002?encoding=pcmu&format=flac&dir=phones)", s)
+	}
+	if _, _, err := net.SplitHostPort(u.Host); err != nil {
+		return fmt.Errorf("invalid -profile address %q: %w", u.Host, err)
+	}
+	q := u.Query()
+	pr := profile{addr: u.Host, encoding: encodingL16, format: q.Get("format"), codec: q.Get("codec"), dir: q.Get("dir")}
+	if e := q.Get("encoding"); e != "" {
+		pr.encoding = strings.ToUpper(e)
+	}
+	switch pr.encoding {
+	case encodingL16, encodingL24, encodingPCMU, encodingPCMA, encodingOpus:
+	default:
+		return fmt.Errorf("unknown encoding %q in -profile %q (use l16, l24, pcmu, pcma or opus)", pr.encoding, s)
+	}
+	for key, dst := range map[string]*int{"rate": &pr.sampleRate, "channels": &pr.channels} {
+		if v := q.Get(key); v != "" {
+			if *dst, err = strconv.Atoi(v); err != nil || *dst <= 0 {
+				return fmt.Errorf("invalid %s %q in -profile %q", key, v, s)
+			}
+		}
+	}
+	if pr.channels > 8 {
+		return fmt.Errorf("unsupported channel count %d in -profile %q (use 1 to 8)", pr.channels, s)
+	}
+	if pr.dir != "" && !filepath.IsLocal(pr.dir) {
+		return fmt.Errorf("invalid dir %q in -profile %q (use a directory under -out-dir)", pr.dir, s)
+	}
+	p.list = append(p.list, pr)
+	p.specs = append(p.specs, s)
+	return nil
+}
+
+// resolve works out the configuration of each port's streams from cfg, the
+// server's, which the ports' options override.
+func (p *profiles) resolve(cfg *Config) error {
+	for i := range p.list {
+		pr := &p.list[i]
+		own := *cfg
+		own.sampleRate, own.channels, own.bitDepth = cfg.sampleRate, cfg.channels, 16
+		switch pr.encoding {
+		case encodingPCMU, encodingPCMA:
+			own.sampleRate, own.channels = 8000, 1
+		case encodingOpus:
+			own.sampleRate, own.channels = opusRate, 2
+		case encodingL24:
+			own.bitDepth = 24
+		}
+		if pr.sampleRate > 0 {
+			own.sampleRate = pr.sampleRate
+		}
+		if pr.channels > 0 {
+			own.channels = pr.channels
+		}
+		if pr.format != "" {
+			own.format, own.codec = pr.format, ""
+		}
+		if pr.codec != "" {
+			own.codec = pr.codec
+		}
+		if pr.dir != "" {
+			own.outDir = filepath.Join(cfg.outDir, pr.dir)
+		}
+		if err := validateOutput(&own); err != nil {
+			return fmt.Errorf("-profile %s: %w", pr.addr, err)
+		}
+		if err := validateReorder(&own); err != nil {
+			return fmt.Errorf("-profile %s: %w", pr.addr, err)
+		}
+		// The dynamic payload types carry the port's encoding, unless
+		// -payload-type maps them otherwise
+		own.payloads = cfg.payloadTypes.table(&own)
+		if pr.encoding == encodingPCMU || pr.encoding == encodingPCMA {
+			f := &payloadFormat{encoding: pr.encoding, sampleRate: own.sampleRate, channels: own.channels}
+			for pt := 96; pt < len(own.payloads); pt++ {
+				if _, mapped := cfg.payloadTypes[uint8(pt)]; !mapped {
+					own.payloads[pt] = f
+				}
+			}
+		}
+		pr.cfg = &own
+	}
+	return nil
+}
+
+// String describes the port's streams as they are logged, e.g. PCMU 8000 Hz
+// 1 ch.
+func (pr *profile) String() string {
+	return fmt.Sprintf("%s %d Hz %d ch", pr.encoding, pr.cfg.sampleRate, pr.cfg.channels)
+}
+
+// profileIngest reads a -profile port. Its packets go through the same
+// pipeline as the RTP port's, decoded and recorded with the port's
+// configuration; those of an Opus port are decoded by an ffmpeg per sender
+// first, into 20 ms L16 packets. The reports and answers sent to its senders
+// come from it.
+type profileIngest struct {
+	s    *server
+	p    *profile
+	conn *net.UDPConn
+
+	mu      sync.Mutex
+	senders map[netip.AddrPort]time.Time // When each was last heard from; guarded by mu
+	opus    map[netip.AddrPort]*opusSender
+}
+
+// opusSender is the decoding of the stream of one address on an Opus port.
+type opusSender struct {
+	ssrc     uint32
+	dec      *opusDecoder
+	done     chan struct{} // Closed once the decoded audio has been handled
+	lastSeen time.Time
+}
+
+func listenProfile(s *server, p *profile) (*profileIngest, error) {
+	pc, err := net.ListenPacket("udp", p.addr)
+	if err != nil {
+		return nil, err
+	}
+	return &profileIngest{
+		s: s, p: p, conn: pc.(*net.UDPConn),
+		senders: make(map[netip.AddrPort]time.Time),
+		opus:    make(map[netip.AddrPort]*opusSender),
+	}, nil
+}
+
+// serve reads the port until it is closed, then lets the Opus decoders
+// finish.
+func (r *profileIngest) serve() {
+	defer r.closeOpus(time.Time{})
+	buf := make([]byte, 1<<16)
+	known := sources{}
+	sweep := time.Now()
+	for {
+		n, addr, err := r.conn.ReadFromUDPAddrPort(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			continue
+		}
+		addr = netip.AddrPortFrom(addr.Addr().Unmap(), addr.Port())
+		src := known.get(addr)
+		src.cfg = r.p.cfg
+		r.seen(addr)
+		b := buf[:n]
+		if r.p.encoding != encodingOpus || isRTCP(b) || stun.IsMessage(b) {
+			r.s.handlePacket(b, addr, src)
+			continue
+		}
+		if !r.s.access.allowed(addr.Addr()) {
+			continue
+		}
+		r.decodeOpus(b, addr)
+		if now := time.Now(); now.Sub(sweep) >= rawForget {
+			r.closeOpus(now.Add(-rawForget))
+			sweep = now
+		}
+	}
+}
+
+// decodeOpus feeds an Opus packet to the decoder of its sender, starting one
+// for a new stream.
+func (r *profileIngest) decodeOpus(b []byte, addr netip.AddrPort) {
+	var pkt rtp.Packet
+	if err := pkt.Unmarshal(b); err != nil {
+		packetsMalformed.Add(1)
+		return
+	}
+	sender := r.opus[addr]
+	if sender != nil && sender.ssrc != pkt.SSRC {
+		// The sender started over
+		r.closeSender(addr, sender)
+		sender = nil
+	}
+	if sender == nil {
+		if len(r.opus) >= maxOpusSenders {
+			r.closeOpus(time.Now().Add(-rawForget))
+			if len(r.opus) >= maxOpusSenders {
+				ingestLog.Warn("🚫 Refused an Opus sender, too many are being decoded", "addr", addr, "port", r.p.addr, "max", maxOpusSenders)
+				return
+			}
+		}
+		dec, err := newOpusDecoder(r.p.cfg, 0, ingestLog)
+		if err != nil {
+			ingestLog.Error("Decoding an Opus stream failed", "addr", addr, "err", err)
+			return
+		}
+		sender = &opusSender{ssrc: pkt.SSRC, dec: dec, done: make(chan struct{})}
+		r.opus[addr] = sender
+		src := &source{name: addr.String(), linear: true, cfg: r.p.cfg}
+		go func() {
+			defer close(sender.done)
+			dec.packets(pkt.SSRC, func(b []byte) { r.s.handlePacket(b, addr, src) })
+		}()
+	}
+	sender.lastSeen = time.Now()
+	if err := sender.dec.write(&pkt); err != nil {
+		ingestLog.Error("Decoding an Opus stream failed", "addr", addr, "err", err)
+		r.closeSender(addr, sender)
+	}
+}
+
+// closeOpus ends the decoders of the senders last heard from before, all of
+// them for the zero time.
+func (r *profileIngest) closeOpus(before time.Time) {
+	for addr, sender := range r.opus {
+		if before.IsZero() || sender.lastSeen.Before(before) {
+			r.closeSender(addr, sender)
+		}
+	}
+}
+
+// closeSender lets the decoder of a sender finish and forgets it.
+func (r *profileIngest) closeSender(addr netip.AddrPort, sender *opusSender) {
+	sender.dec.close()
+	<-sender.done
+	delete(r.opus, addr)
+}
+
+// seen records that addr sent to the port, so what is sent back to it is.
+func (r *profileIngest) seen(addr netip.AddrPort) {
+	now := time.Now()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, known := r.senders[addr]; !known && len(r.senders) >= maxSources {
+		for a, at := range r.senders {
+			if now.Sub(at) >= rawForget {
+				delete(r.senders, a)
+			}
+		}
+		if len(r.senders) >= maxSources {
+			clear(r.senders)
+		}
+	}
+	r.senders[addr] = now
+}
+
+// write sends pkt to addr from the port if addr is one of its senders,
+// reporting whether it was.
+func (r *profileIngest) write(pkt []byte, addr netip.AddrPort) (bool, error) {
+	r.mu.Lock()
+	_, ok := r.senders[addr]
+	r.mu.Unlock()
+	if !ok {
+		return false, nil
+	}
+	_, err := r.conn.WriteToUDPAddrPort(pkt, addr)
+	return true, err
+}
+
+// profileIngests are the -profile ports.
+type profileIngests []*profileIngest
+
+// write sends pkt to addr from the port it streams to, if that is one of the
+// -profile ports, reporting whether it was.
+func (ps profileIngests) write(pkt []byte, addr netip.AddrPort) (bool, error) {
+	for _, r := range ps {
+		if ok, err := r.write(pkt, addr); ok {
+			return true, err
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,69 @@
+package recorder
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// Policies applied when the recordings exceed the -max-disk quota.
+const (
+	quotaReject       = "reject"        // Stop accepting new streams until space is freed
+	quotaDeleteOldest = "delete-oldest" // Delete the oldest finished recordings to make room
+)
+
+// diskUsage tracks how many bytes the recordings under the output directory
+// take up. Writes are added as they happen and the janitor resets the total
+// from a full scan, so the value never drifts for long.
+type diskUsage struct {
+	limit int64 // -max-disk in bytes, 0 = unlimited
+	used  atomic.Int64
+	full  chan struct{} // Signalled when usage crosses the limit
+
+	mu       sync.Mutex
+	rejected map[string]bool // Addresses already told they were rejected
+}
+
+func newDiskUsage(limit int64) *diskUsage {
+	return &diskUsage{limit: limit, full: make(chan struct{}, 1)}
+}
+
+func (d *diskUsage) set(n int64) { d.used.Store(n) }
+func (d *diskUsage) get() int64  { return d.used.Load() }
+
+// add records n newly written bytes and wakes the janitor if this takes the
+// usage over the limit.
+func (d *diskUsage) add(n int64) {
+	used := d.used.Add(n)
+	if d.limit > 0 && used >= d.limit && used-n < d.limit {
+		select {
+		case d.full <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// rejectOnce logs that a new stream from addr was refused, once per address
+// while the quota stays exceeded.
+func (d *diskUsage) rejectOnce(addr string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.rejected == nil {
+		d.rejected = make(map[string]bool)
+	}
+	if !d.rejected[addr] {
+		d.rejected[addr] = true
+		ingestLog.Warn("⛔ Disk quota exceeded, rejecting new stream", "addr", addr)
+	}
+}
+
+// resetRejected forgets the rejected addresses once usage is back under quota.
+func (d *diskUsage) resetRejected() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.rejected = nil
+}
+
+// quotaExceeded reports whether new streams must be refused under the reject policy.
+func (s *server) quotaExceeded() bool {
+	return s.disk.limit > 0 && s.cfg.quotaPolicy == quotaReject && s.disk.get() >= s.disk.limit
+}
@@ -0,0 +1,116 @@
+package recorder
+
+import (
+	"log/slog"
+	"math"
+	"time"
+)
+
+const (
+	rateWindow    = 5 * time.Second // Audio measured before judging a stream's format
+	rateTolerance = 0.05            // Relative difference to the configuration that is reported
+)
+
+// commonRates are the sample rates a measured clock is rounded to.
+var commonRates = []int{8000, 11025, 16000, 22050, 24000, 32000, 44100, 48000, 88200, 96000}
+
+// rateCheck compares a stream with the configured format, so that a sender
+// using another sample rate, channel count or bit depth is reported instead
+// of silently making recordings that play too fast or too slow. Over windows
+// of rateWindow it measures how many frames the packets carry per tick of
+// their timestamps, which is 1 when channels and bit depth match, and how
+// fast the timestamps advance against the arrival times, which is the
+// sample rate. Both are unaffected by packet loss. It is only used by the
+// UDP read loop the stream arrives on.
+type rateCheck struct {
+	cfg *Config
+	log *slog.Logger
+
+	started     bool
+	prevSeq     uint16
+	prevArrival time.Time
+	runTS       uint32 // Timestamp of the latest packets, which may share one
+	runFrames   int64  // Frames of the packets with runTS
+	windowStart time.Time
+	ticks       int64 // Timestamp units since windowStart
+	pairFrames  int64 // Frames of in-order packets followed by the next timestamp
+	pairTicks   int64 // How far the timestamps advanced over those
+
+	warnedFormat, warnedRate bool
+}
+
+func newRateCheck(cfg *Config, log *slog.Logger) *rateCheck {
+	return &rateCheck{cfg: cfg, log: log}
+}
+
+// packet records an audio packet carrying frames.
+func (r *rateCheck) packet(seq uint16, timestamp uint32, frames int, arrival time.Time) {
+	if r.warnedFormat && r.warnedRate {
+		return
+	}
+	// Pauses in the stream and the first packet start a new window
+	if !r.started || arrival.Sub(r.prevArrival) > time.Second {
+		r.started = true
+		r.windowStart = arrival
+		r.ticks, r.pairFrames, r.pairTicks = 0, 0, 0
+		r.prevSeq, r.prevArrival = seq, arrival
+		r.runTS, r.runFrames = timestamp, int64(frames)
+		return
+	}
+
+	d := int64(int32(timestamp - r.runTS))
+	inOrder := seq == r.prevSeq+1
+	r.prevSeq, r.prevArrival = seq, arrival
+	switch {
+	case d < 0:
+		return // Reordered
+	case d == 0 && inOrder:
+		r.runFrames += int64(frames) // A frame split over several packets
+		return
+	case inOrder:
+		r.pairFrames += r.runFrames
+		r.pairTicks += d
+	}
+	r.ticks += d
+	r.runTS, r.runFrames = timestamp, int64(frames)
+
+	elapsed := arrival.Sub(r.windowStart)
+	if elapsed < rateWindow || r.pairTicks == 0 {
+		return
+	}
+	r.judge(float64(r.pairFrames)/float64(r.pairTicks), float64(r.ticks)/elapsed.Seconds())
+	r.windowStart = arrival
+	r.ticks, r.pairFrames, r.pairTicks = 0, 0, 0
+}
+
+// judge reports a stream whose frames per timestamp tick or clock are off.
+func (r *rateCheck) judge(framesPerTick, clock float64) {
+	if math.Abs(framesPerTick-1) > rateTolerance {
+		if !r.warnedFormat {
+			r.warnedFormat = true
+			// With the right bit depth, this many channels would fit
+			channels := math.Round(float64(r.cfg.channels) * framesPerTick)
+			r.log.Warn("🐿️  Stream doesn't match -channels or -bits: packets carry a different amount of audio than their timestamps advance, so the recording is garbled",
+				"frames_per_tick", math.Round(framesPerTick*100)/100,
+				"channels", r.cfg.channels, "bit_depth", r.cfg.bitDepth,
+				"likely_channels", max(1, int(channels)))
+		}
+		return // The clock can't be trusted either
+	}
+	if math.Abs(clock/float64(r.cfg.sampleRate)-1) > rateTolerance && !r.warnedRate {
+		r.warnedRate = true
+		r.log.Warn("🐿️  Stream doesn't match -rate: its clock runs at another sample rate, so the recording plays too fast or too slow",
+			"measured_hz", nearestRate(clock), "rate", r.cfg.sampleRate)
+	}
+}
+
+// nearestRate rounds a measured clock to the closest common sample rate.
+func nearestRate(hz float64) int {
+	best := commonRates[0]
+	for _, rate := range commonRates {
+		if math.Abs(float64(rate)-hz) < math.Abs(float64(best)-hz) {
+			best = rate
+		}
+	}
+	return best
+}
@@ -0,0 +1,373 @@
+package recorder
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pion/rtp"
+
+	"github.com/fcerini/audio-capture-shared/pkg/handshake"
+)
+
+const (
+	maxRawSenders = 1024        // Per port; senders silent for rawForget go to make room for more
+	rawForget     = time.Minute // Of silence, after which a UDP sender's stream starts over
+)
+
+// rawPort is one -raw listener: a UDP or TCP address taking raw PCM of a
+// declared format.
+type rawPort struct {
+	network string // udp or tcp
+	addr    string
+	format  rawFormat
+}
+
+// rawFormat is the sample format of a -raw port.
+type rawFormat struct {
+	name       string // s16le, s16be, s24le or s24be
+	sampleRate int
+	channels   int
+	bitDepth   int
+	little     bool // Little-endian, as pacat and most sound cards send by default
+}
+
+// rawPorts is the -raw flag, repeatable:
+// udp://:7000?format=s16le&rate=48000&channels=2. The format is s16le, and
+// the rate and channels those of -rate and -channels, unless given.
+type rawPorts struct {
+	ports []rawPort
+	specs []string
+}
+
+func (p *rawPorts) String() string {
+	if p == nil {
+		return ""
+	}
+	return strings.Join(p.specs, " ")
+}
+
+func (p *rawPorts) Set(s string) error {
+	u, err := url.Parse(s)
+	if err != nil || u.Scheme != "udp" && u.Scheme != "tcp" || u.Host == "" {
+		return fmt.Errorf("invalid -raw %q (use udp:// or tcp:// and host:port, e.g. udp://:7000?format=s16le&rate=48000&channels=2)", s)
+	}
+	if _, _, err := net.SplitHostPort(u.Host); err != nil {
+		return fmt.Errorf("invalid -raw address %q: %w", u.Host, err)
+	}
+	port := rawPort{network: u.Scheme, addr: u.Host, format: rawFormat{name: "s16le"}}
+	q := u.Query()
+	if f := q.Get("format"); f != "" {
+		port.format.name = strings.ToLower(f)
+	}
+	for key, dst := range map[string]*int{"rate": &port.format.sampleRate, "channels": &port.format.channels} {
+		if v := q.Get(key); v != "" {
+			if *dst, err = strconv.Atoi(v); err != nil || *dst <= 0 {
+				return fmt.Errorf("invalid %s %q in -raw %q", key, v, s)
+			}
+		}
+	}
+	switch port.format.name {
+	case "s16le", "s16be":
+		port.format.bitDepth = 16
+	case "s24le", "s24be":
+		port.format.bitDepth = 24
+	default:
+		return fmt.Errorf("unknown format %q in -raw %q (use s16le, s16be, s24le or s24be)", port.format.name, s)
+	}
+	port.format.little = strings.HasSuffix(port.format.name, "le")
+	if port.format.channels > 8 {
+		return fmt.Errorf("unsupported channel count %d in -raw %q (use 1 to 8)", port.format.channels, s)
+	}
+	p.ports = append(p.ports, port)
+	p.specs = append(p.specs, s)
+	return nil
+}
+
+// resolved returns the ports with the defaults of cfg filled in.
+func (p *rawPorts) resolved(cfg *Config) []rawPort {
+	ports := make([]rawPort, len(p.ports))
+	for i, port := range p.ports {
+		if port.format.sampleRate == 0 {
+			port.format.sampleRate = cfg.sampleRate
+		}
+		if port.format.channels == 0 {
+			port.format.channels = cfg.channels
+		}
+		ports[i] = port
+	}
+	return ports
+}
+
+// String describes the format as it is logged, e.g. s16le 48000 Hz 2 ch.
+func (f rawFormat) String() string {
+	return fmt.Sprintf("%s %d Hz %d ch", f.name, f.sampleRate, f.channels)
+}
+
+// rawIngest takes raw PCM, for senders that can't speak RTP, such as
+// pacat piped to nc, on a -raw port: datagrams over UDP, or a byte stream
+// over TCP. The audio of each sender is cut into 20 ms RTP packets, as if
+// a sender at its address had sent them after a handshake declaring the
+// port's format, and goes through the same pipeline; nothing is sent back.
+type rawIngest struct {
+	s    *server
+	port rawPort
+	pc   net.PacketConn // For UDP
+	ln   net.Listener   // For TCP
+
+	mu      sync.Mutex
+	senders map[netip.AddrPort]*rawSender // guarded by mu
+	wg      sync.WaitGroup                // The TCP connections' read loops
+}
+
+// rawSender is the stream of one address.
+type rawSender struct {
+	src      *source
+	pk       *rawPacketizer
+	conn     net.Conn // Of a TCP sender
+	lastSeen time.Time
+}
+
+func listenRaw(s *server, port rawPort) (*rawIngest, error) {
+	r := &rawIngest{s: s, port: port, senders: make(map[netip.AddrPort]*rawSender)}
+	var err error
+	if port.network == "tcp" {
+		r.ln, err = net.Listen("tcp", port.addr)
+	} else {
+		r.pc, err = net.ListenPacket("udp", port.addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// localAddr returns the address the port listens on.
+func (r *rawIngest) localAddr() net.Addr {
+	if r.ln != nil {
+		return r.ln.Addr()
+	}
+	return r.pc.LocalAddr()
+}
+
+// serve reads the port until it is closed.
+func (r *rawIngest) serve() {
+	if r.ln != nil {
+		r.serveTCP()
+		return
+	}
+	buf := make([]byte, 1<<16)
+	for {
+		n, from, err := r.pc.ReadFrom(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			continue
+		}
+		addr := from.(*net.UDPAddr).AddrPort()
+		addr = netip.AddrPortFrom(addr.Addr().Unmap(), addr.Port())
+		if !r.s.access.allowed(addr.Addr()) {
+			continue
+		}
+		if sender := r.sender(addr, nil); sender != nil {
+			sender.pk.feed(buf[:n], func(pkt []byte) { r.s.handlePacket(pkt, addr, sender.src) })
+		}
+	}
+}
+
+// serveTCP accepts connections until the listener is closed, then closes
+// them and waits for their read loops.
+func (r *rawIngest) serveTCP() {
+	defer r.wg.Wait()
+	defer r.closeAll()
+	for {
+		conn, err := r.ln.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			ingestLog.Warn("Accepting a raw PCM connection failed", "err", err)
+			time.Sleep(100 * time.Millisecond)
+			continue
+		}
+		addr := conn.RemoteAddr().(*net.TCPAddr).AddrPort()
+		addr = netip.AddrPortFrom(addr.Addr().Unmap(), addr.Port())
+		if !r.s.access.allowed(addr.Addr()) {
+			conn.Close()
+			continue
+		}
+		sender := r.sender(addr, conn)
+		if sender == nil {
+			conn.Close()
+			continue
+		}
+		r.wg.Add(1)
+		go func() {
+			defer r.wg.Done()
+			r.read(sender, addr)
+		}()
+	}
+}
+
+// read handles the byte stream of one connection until it is closed.
+func (r *rawIngest) read(sender *rawSender, addr netip.AddrPort) {
+	defer func() {
+		r.mu.Lock()
+		delete(r.senders, addr)
+		r.mu.Unlock()
+		sender.conn.Close()
+	}()
+	buf := make([]byte, 32<<10)
+	for {
+		n, err := sender.conn.Read(buf)
+		sender.pk.feed(buf[:n], func(pkt []byte) { r.s.handlePacket(pkt, addr, sender.src) })
+		switch {
+		case errors.Is(err, io.EOF):
+			ingestLog.Info("🔌 Raw PCM sender disconnected", "addr", addr)
+			return
+		case errors.Is(err, net.ErrClosed):
+			return
+		case err != nil:
+			ingestLog.Warn("🔌 Raw PCM connection lost", "addr", addr, "err", err)
+			return
+		}
+	}
+}
+
+// sender returns the stream of addr, starting one, with the port's format
+// declared for it, for a new one. It returns nil when there are too many.
+func (r *rawIngest) sender(addr netip.AddrPort, conn net.Conn) *rawSender {
+	now := time.Now()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if sender, ok := r.senders[addr]; ok && now.Sub(sender.lastSeen) < rawForget {
+		sender.lastSeen = now
+		return sender
+	}
+	if len(r.senders) >= maxRawSenders {
+		for a, sender := range r.senders {
+			if sender.conn == nil && now.Sub(sender.lastSeen) >= rawForget {
+				delete(r.senders, a)
+			}
+		}
+		if len(r.senders) >= maxRawSenders {
+			ingestLog.Warn("🚫 Refused a raw PCM sender, too many are connected", "addr", addr, "port", r.port.addr, "max", maxRawSenders)
+			return nil
+		}
+	}
+	sender := &rawSender{src: &source{name: addr.String(), linear: true}, pk: newRawPacketizer(r.port.format), conn: conn, lastSeen: now}
+	r.senders[addr] = sender
+	r.s.declare(sender.src.name, r.port.format)
+	ingestLog.Info("🔌 Raw PCM sender connected", "addr", addr, "network", r.port.network, "port", r.port.addr, "format", r.port.format.String())
+	return sender
+}
+
+// has reports whether addr is one of the port's senders, which nothing is
+// sent back to.
+func (r *rawIngest) has(addr netip.AddrPort) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	_, ok := r.senders[addr]
+	return ok
+}
+
+// close stops taking audio, ending the TCP connections.
+func (r *rawIngest) close() {
+	if r.ln != nil {
+		r.ln.Close()
+	} else {
+		r.pc.Close()
+	}
+}
+
+// closeAll closes every TCP connection, ending their read loops.
+func (r *rawIngest) closeAll() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, sender := range r.senders {
+		if sender.conn != nil {
+			sender.conn.Close()
+		}
+	}
+}
+
+// rawIngests are the -raw ports.
+type rawIngests []*rawIngest
+
+// has reports whether addr is a sender of any of the ports.
+func (rs rawIngests) has(addr netip.AddrPort) bool {
+	for _, r := range rs {
+		if r.has(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// declare records format as the one the sender at addr offered, as a
+// handshake would, so its sessions are recorded in it.
+func (s *server) declare(addr string, f rawFormat) {
+	o := offer{
+		version: handshake.Version, software: "raw", encoding: "L" + strconv.Itoa(f.bitDepth),
+		sampleRate: f.sampleRate, channels: f.channels, bitDepth: f.bitDepth, declared: true,
+	}
+	s.clientsMutex.Lock()
+	defer s.clientsMutex.Unlock()
+	if _, known := s.offers[addr]; !known && len(s.offers) >= maxOffers {
+		s.offers = make(map[string]offer)
+	}
+	s.offers[addr] = o
+}
+
+// rawPacketizer cuts raw PCM into 20 ms RTP packets of big-endian samples.
+type rawPacketizer struct {
+	f       rawFormat
+	header  rtp.Header
+	frame   int    // Bytes of 20 ms
+	pending []byte // Audio short of a packet
+	buf     []byte
+}
+
+func newRawPacketizer(f rawFormat) *rawPacketizer {
+	frame := f.sampleRate / 50 * f.channels * f.bitDepth / 8
+	return &rawPacketizer{
+		f:      f,
+		header: rtp.Header{Version: 2, PayloadType: l16PayloadType, SSRC: rand.Uint32(), SequenceNumber: uint16(rand.Uint32()), Timestamp: rand.Uint32()},
+		frame:  frame,
+		buf:    make([]byte, 12+frame),
+	}
+}
+
+// feed takes audio, handing every 20 ms of it to handle as a packet, which
+// is only valid until handle returns.
+func (p *rawPacketizer) feed(b []byte, handle func([]byte)) {
+	p.pending = append(p.pending, b...)
+	width := p.f.bitDepth / 8
+	for len(p.pending) >= p.frame {
+		n, err := p.header.MarshalTo(p.buf)
+		if err != nil {
+			return
+		}
+		payload := p.buf[n : n+p.frame]
+		copy(payload, p.pending[:p.frame])
+		if p.f.little {
+			for i := 0; i+width <= len(payload); i += width {
+				s := payload[i : i+width]
+				s[0], s[width-1] = s[width-1], s[0]
+			}
+		}
+		handle(p.buf[:n+p.frame])
+		p.header.SequenceNumber++
+		p.header.Timestamp += uint32(p.f.sampleRate / 50)
+		p.pending = p.pending[:copy(p.pending, p.pending[p.frame:])]
+	}
+}
@@ -0,0 +1,363 @@
+// Package recorder receives RTP audio streams over UDP and records each of
+// them to a file, with everything the audio-capture-server command offers:
+// the stats and control APIs, the dashboard, uploads, mixing and so on.
+//
+//	cfg, err := recorder.NewConfig(recorder.WithPort(6001), recorder.WithOutDir("recordings"))
+//	if err != nil {
+//		return err
+//	}
+//	recorder.SetupLogging(cfg)
+//	return recorder.ListenAndRecord(ctx, cfg)
+//
+// ParseConfig builds the Config from the command's flags instead.
+package recorder
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/fcerini/audio-capture-shared/pkg/dscp"
+	"github.com/fcerini/audio-capture-shared/pkg/pcap"
+)
+
+// ListenAndRecord listens on the configured port and records the streams
+// arriving there until ctx is canceled, then stops everything it started,
+// finalizes the recordings and returns. It returns an error only when the
+// server can't start.
+func ListenAndRecord(ctx context.Context, cfg *Config) error {
+	return listenAndRecord(ctx, cfg, nil)
+}
+
+// listenAndRecord records, calling the hooks of r if it isn't nil.
+func listenAndRecord(ctx context.Context, cfg *Config, r *Recorder) (err error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var up *uploader
+	if cfg.upload != "" {
+		if up, err = newUploader(cfg); err != nil {
+			return err
+		}
+	}
+
+	var cat *catalog
+	if cfg.catalog != "" {
+		if cat, err = openCatalog(cfg.catalog); err != nil {
+			return err
+		}
+		defer cat.close()
+	}
+
+	var mq *mqttPublisher
+	if cfg.mqtt != "" {
+		if mq, err = newMQTTPublisher(cfg); err != nil {
+			return err
+		}
+	}
+
+	var tr *tracer
+	if cfg.otlpEndpoint != "" {
+		if tr, err = newTracer(cfg); err != nil {
+			return err
+		}
+	}
+
+	var pc *pcap.Writer
+	if cfg.debugPcap != "" {
+		if pc, err = pcap.Create(cfg.debugPcap); err != nil {
+			return err
+		}
+	}
+
+	// Create the UDP listeners
+	listeners, err := listenRTP(cfg.listen, cfg.port, cfg.readers)
+	if err != nil {
+		pc.Close()
+		return err
+	}
+	for _, l := range listeners {
+		defer l.Close()
+		if cfg.rcvBuf > 0 {
+			setReceiveBuffer(l, int(cfg.rcvBuf))
+		}
+		if cfg.dscp != 0 {
+			if err := dscp.Mark(l, cfg.dscp); err != nil {
+				mainLog.Warn("Marking the RTCP sent with -dscp failed", "dscp", cfg.dscp.String(), "err", err)
+			}
+		}
+	}
+
+	mainLog.Info("🎧 Listening for RTP audio", "addr", listenAddr(cfg.listen, cfg.port), "readers", len(listeners))
+	if cfg.dscp != 0 {
+		mainLog.Info("🚦 Marking the RTCP sent for QoS", "dscp", cfg.dscp.String())
+	}
+	mainLog.Info("🎚️  Stream format", "encoding", fmt.Sprintf("L%d", cfg.bitDepth), "rate", cfg.sampleRate, "channels", cfg.channels)
+	mainLog.Info("🔊 Saving incoming audio streams", "dir", cfg.outDir, "format", cfg.format, "codec", cfg.codec)
+	if len(cfg.allowCIDR) > 0 {
+		mainLog.Info("🛡️  Accepting packets only from the allowed networks", "allow", cfg.allowCIDR.String())
+	}
+	if up != nil {
+		mainLog.Info("☁️  Uploading finished recordings", "destination", up.destination())
+	}
+
+	srv := newServer(cfg, listeners, up, cat, mq, tr, pc)
+	// The -profile ports are taken first, as the RTCP port is only if free
+	for i := range cfg.profiles.list {
+		p := &cfg.profiles.list[i]
+		r, err := listenProfile(srv, p)
+		if err != nil {
+			pc.Close()
+			return fmt.Errorf("listening for RTP on -profile %s failed: %w", p.addr, err)
+		}
+		defer r.conn.Close()
+		srv.profiles = append(srv.profiles, r)
+		mainLog.Info("🗂️  Taking RTP in a profile of its own", "addr", r.conn.LocalAddr().String(), "format", p.String(), "dir", p.cfg.outDir, "output", p.cfg.format, "codec", p.cfg.codec)
+	}
+	if port := int(srv.localAddr.Port()) + 1; cfg.rtcpPort >= 0 && (cfg.rtcpPort > 0 || port <= 65535) {
+		if cfg.rtcpPort > 0 {
+			port = cfg.rtcpPort
+		}
+		// The port after the RTP port is only taken if it is free, unless
+		// asked for
+		conn, err := listenRTCP(cfg.listen, port)
+		switch {
+		case err != nil && cfg.rtcpPort > 0:
+			pc.Close()
+			return fmt.Errorf("listening for RTCP failed: %w", err)
+		case err != nil:
+			mainLog.Warn("Not taking RTCP on the port after the RTP port, which is in use", "port", port, "err", err)
+		default:
+			defer conn.Close()
+			srv.rtcp = conn
+			mainLog.Info("📮 Taking RTCP on a port of its own", "addr", listenAddr(cfg.listen, port))
+		}
+	}
+	if cfg.tcp {
+		ln, err := listenTCP(cfg.listen, int(srv.localAddr.Port()))
+		if err != nil {
+			pc.Close()
+			return fmt.Errorf("listening for RTP over TCP failed: %w", err)
+		}
+		defer ln.Close()
+		srv.tcp = newTCPIngest(srv, ln)
+		mainLog.Info("🔌 Accepting RTP over TCP", "addr", listenAddr(cfg.listen, int(srv.localAddr.Port())))
+	}
+	if cfg.srt != "" {
+		if srv.srt, err = listenSRT(srv, cfg.srt); err != nil {
+			pc.Close()
+			return fmt.Errorf("listening for SRT failed: %w", err)
+		}
+		defer srv.srt.ln.Close()
+		mainLog.Info("📡 Accepting RTP over SRT", "addr", srv.srt.ln.Addr().String())
+	}
+	for _, port := range cfg.raw.resolved(cfg) {
+		r, err := listenRaw(srv, port)
+		if err != nil {
+			pc.Close()
+			return fmt.Errorf("listening for raw PCM on %s failed: %w", port.addr, err)
+		}
+		defer r.close()
+		srv.raw = append(srv.raw, r)
+		mainLog.Info("🔌 Taking raw PCM", "network", port.network, "addr", r.localAddr().String(), "format", port.format.String())
+	}
+	if cfg.metadataAddr != "" {
+		if srv.meta, err = listenMetadata(srv, cfg.metadataAddr); err != nil {
+			pc.Close()
+			return fmt.Errorf("listening for session metadata failed: %w", err)
+		}
+		defer srv.meta.ln.Close()
+		mainLog.Info("🏷️  Taking session metadata over TCP", "addr", srv.meta.ln.Addr().String())
+	}
+	if cfg.whip {
+		if srv.whip, err = newWHIPIngest(srv); err != nil {
+			pc.Close()
+			return fmt.Errorf("setting up WHIP failed: %w", err)
+		}
+		mainLog.Info("🌐 Taking WebRTC publishers over WHIP", "path", whipPath)
+	}
+	srv.hooks = r
+	srv.publishVars()
+	if mq != nil {
+		mainLog.Info("📨 Publishing events to MQTT", "broker", cfg.mqtt)
+		go mq.run()
+		go mq.watchLevels(srv.levels)
+	}
+	if tr != nil {
+		mainLog.Info("🔭 Exporting traces", "endpoint", tr.endpoint)
+		go tr.run()
+	}
+	if pc != nil {
+		mainLog.Info("🦈 Capturing packets", "file", cfg.debugPcap)
+	}
+
+	// Everything below runs until ctx is done, and is waited for on the way
+	// out
+	var wg sync.WaitGroup
+	goUntilDone := func(f func(ctx context.Context)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			f(ctx)
+		}()
+	}
+
+	// Start the janitor if a retention policy or disk quota was configured
+	if cfg.retain > 0 || cfg.retainCount > 0 || cfg.maxDisk > 0 {
+		if cfg.retain > 0 || cfg.retainCount > 0 {
+			mainLog.Info("🧹 Retention policy", "max_age", cfg.retain.String(), "max_count", cfg.retainCount)
+		}
+		if cfg.maxDisk > 0 {
+			mainLog.Info("💽 Disk quota", "bytes", int64(cfg.maxDisk), "policy", cfg.quotaPolicy)
+		}
+		j := &janitor{cfg: cfg, disk: srv.disk, cat: cat, active: srv.activeFiles}
+		goUntilDone(j.run)
+	}
+
+	if cfg.statsAddr != "" {
+		goUntilDone(func(ctx context.Context) { srv.serveStats(ctx, cfg.statsAddr) })
+	}
+	if cfg.grpcAddr != "" {
+		goUntilDone(func(ctx context.Context) { srv.serveGRPC(ctx, cfg.grpcAddr) })
+	}
+	if cfg.mdns != "" {
+		goUntilDone(srv.advertise)
+	}
+	if cfg.pprofAddr != "" {
+		goUntilDone(func(ctx context.Context) { servePprof(ctx, cfg.pprofAddr) })
+	}
+
+	if cfg.idleTimeout > 0 {
+		goUntilDone(srv.reapIdle)
+	}
+	if cfg.logStats > 0 {
+		goUntilDone(srv.logStats)
+	}
+	if cfg.rtcpInterval > 0 {
+		goUntilDone(srv.sendReports)
+	}
+	goUntilDone(srv.watchKernelDrops)
+	if cfg.stunServer != "" {
+		goUntilDone(srv.nat.keepMapped)
+	}
+	if len(cfg.punch) > 0 {
+		mainLog.Info("🕳️  Punching holes towards senders behind NATs", "punch", cfg.punch.String())
+		goUntilDone(srv.nat.punch)
+	}
+
+	if srv.mixer != nil {
+		mainLog.Info("🎛️  Mixing streams into one recording", "mix", cfg.mix)
+		go srv.mixer.run()
+	}
+
+	if srv.multitrack != nil {
+		mainLog.Info("🎚️  Recording streams into one multitrack file", "tracks", cfg.multitrack)
+		go srv.multitrack.run()
+	}
+
+	// Start a goroutine per socket to handle incoming packets
+	var reading sync.WaitGroup
+	for _, l := range listeners {
+		reading.Add(1)
+		go func() {
+			defer reading.Done()
+			srv.serve(l)
+		}()
+	}
+	if srv.tcp != nil {
+		reading.Add(1)
+		go func() {
+			defer reading.Done()
+			srv.tcp.serve()
+		}()
+	}
+	if srv.rtcp != nil {
+		reading.Add(1)
+		go func() {
+			defer reading.Done()
+			srv.serveRTCP()
+		}()
+	}
+	for _, r := range srv.raw {
+		reading.Add(1)
+		go func() {
+			defer reading.Done()
+			r.serve()
+		}()
+	}
+	if srv.meta != nil {
+		reading.Add(1)
+		go func() {
+			defer reading.Done()
+			srv.meta.serve()
+		}()
+	}
+	for _, r := range srv.profiles {
+		reading.Add(1)
+		go func() {
+			defer reading.Done()
+			r.serve()
+		}()
+	}
+	if srv.srt != nil {
+		reading.Add(1)
+		go func() {
+			defer reading.Done()
+			srv.srt.serve()
+		}()
+	}
+
+	var monitor *tui
+	if cfg.tui {
+		if monitor, err = startTUI(srv, cancel); err != nil {
+			mainLog.Warn("Terminal monitor unavailable, logging instead", "err", err)
+		}
+	}
+
+	// Wait for shutdown
+	<-ctx.Done()
+	if monitor != nil {
+		monitor.close()
+	}
+	mainLog.Info("🛑 Shutting down server")
+
+	// Close the listeners to stop the reader goroutines, so no packet
+	// arrives while the recordings are closed
+	for _, l := range listeners {
+		l.Close()
+	}
+	if srv.rtcp != nil {
+		srv.rtcp.Close()
+	}
+	if srv.tcp != nil {
+		srv.tcp.ln.Close()
+	}
+	if srv.srt != nil {
+		srv.srt.ln.Close()
+	}
+	if srv.whip != nil {
+		srv.whip.close()
+	}
+	for _, r := range srv.raw {
+		r.close()
+	}
+	for _, r := range srv.profiles {
+		r.conn.Close()
+	}
+	if srv.meta != nil {
+		srv.meta.ln.Close()
+	}
+	reading.Wait()
+	wg.Wait()
+
+	mainLog.Info("💾 Closing all recordings")
+	srv.closeAll()
+	srv.fin.wait()
+	mq.close()
+	tr.close()
+	if err := pc.Close(); err != nil {
+		mainLog.Error("Writing the packet capture failed", "file", cfg.debugPcap, "err", err)
+	}
+	mainLog.Info("✅ Cleanup complete")
+	return nil
+}
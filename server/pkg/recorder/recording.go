@@ -0,0 +1,722 @@
+package recorder
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"math"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/fcerini/audio-capture-shared/pkg/plugin"
+)
+
+var recordingLog = logger("recording")
+
+// segmentWriter writes the decoded audio of one file segment in the
+// configured output format.
+type segmentWriter interface {
+	write(samples []int) error
+	size() int64       // Current size of the file in bytes
+	syncHeader() error // Make what was written so far survive a crash
+	close() error      // Finalize and close the file
+}
+
+// cueWriter is implemented by segment writers that can embed markers in the
+// file.
+type cueWriter interface {
+	addCue(frame int64, label string)
+}
+
+// flushWriter is implemented by segment writers that buffer what is written.
+type flushWriter interface {
+	flush() error
+}
+
+// Client holds the state for a single connected client, including the writer for its current file.
+type Client struct {
+	cfg     *Config
+	addr    string
+	ssrc    uint32
+	session string    // Short random ID identifying this recording session
+	start   time.Time // When the first packet of the session arrived
+
+	lastSeen atomic.Int64 // Arrival of the latest packet, in Unix nanoseconds
+	srv      *server      // For disk accounting and post-recording steps
+	log      *slog.Logger // With the session and address
+
+	// Decoded audio is handed from the UDP read loop to a per-client writer
+	// goroutine through queue, so slow encoders or disks never block ingestion.
+	queueMu   sync.Mutex // Guards sending on queue against it being closed
+	queue     chan []int
+	queueDone chan struct{} // Closed once the writer goroutine has drained the queue
+	dropped   atomic.Int64  // Buffers dropped because the queue was full
+	free      chan []int    // Buffers the writer is done with, for the read loop to decode into
+
+	meter     *levelMeter    // Fed with the audio the writer goroutine takes
+	plugins   *plugin.Chain  // -plugin, of the session; nil without
+	pluginPCM []byte         // The audio handed to the plugins, on the writer goroutine
+	rtp       *rtpReceiver   // Packet loss and jitter
+	rate      *rateCheck     // Format mismatches, used by the read loop
+	otherPT   atomic.Bool    // Warned of packets of a payload type the session can't be recorded in
+	reorder   *reorderBuffer // Only set with -reorder-window
+	dtmf      *dtmfState     // Only set with -dtmf
+	text      *textStream    // Only set with -t140
+	queued    int64          // Frames handed to the writer, on the packet path
+	live      subscribers    // Live audio and events, for the gRPC API
+
+	playMu sync.Mutex
+	player *player // Live playback, nil unless selected by -play
+
+	// mu guards the current segment, which is swapped out on rotation while
+	// other goroutines may be reading its name or size. Between segments,
+	// while waiting for audio after a long silence, out is nil.
+	mu       sync.Mutex
+	part     int // 1-based index of the current file segment
+	out      segmentWriter
+	path     string           // Path of the current file
+	opened   time.Time        // When the current file was opened
+	written  int64            // PCM bytes written to the current file
+	silence  *silenceDetector // Only set when splitting on silence
+	vad      *voiceDetector   // Only set when trimming silence
+	gaps     []gap            // Silences left out of the current file
+	wave     *waveform        // Peaks of the current file, only set with -waveform
+	digits   []dtmfEvent      // DTMF digits received since the last file was closed
+	stored   int64            // Frames taken from the queue, for placing DTMF digits
+	taken    int64            // Frames written, for writing late packets in place
+	segStart int64            // Of them, where the current file starts
+	patches  []pendingPatch   // Late packets waiting for the audio they go over to be written
+	meta     *sessionMeta     // Told by the client on -metadata-addr, nil if not
+	closed   atomic.Bool      // Set under queueMu, read without it by the read loops that know the session
+
+	headerSynced time.Time // Last time the file was synced to disk
+
+	dump atomic.Pointer[rtpdumpWriter] // Packets of the current file, only with -rtpdump
+
+	span      *span         // Trace of the session, nil without -otlp-endpoint
+	segSpan   *span         // Of the current file
+	writeTime time.Duration // Spent writing the current file, for its span
+
+	trackNames func() []string // Sources of each track, for multitrack recordings
+}
+
+// newClient starts a recording session for a new client, creates its first
+// file, laid out according to the configured output directory and filename
+// template, and starts its writer goroutine.
+func newClient(srv *server, addr string, ssrc uint32) (*Client, error) {
+	return newClientConfig(srv, srv.cfg, addr, ssrc, nil)
+}
+
+// newClientConfig is like newClient but records with its own settings, for
+// streams the server produces itself such as the multitrack recording, and
+// the metadata its client told, if any.
+func newClientConfig(srv *server, cfg *Config, addr string, ssrc uint32, meta *sessionMeta) (*Client, error) {
+	c := &Client{
+		cfg:       cfg,
+		addr:      addr,
+		ssrc:      ssrc,
+		session:   newSessionID(),
+		start:     time.Now(),
+		srv:       srv,
+		queue:     make(chan []int, cfg.queueSize),
+		queueDone: make(chan struct{}),
+		free:      make(chan []int, cfg.queueSize+1),
+		meter:     newLevelMeter(cfg),
+		rtp:       newRTPReceiver(cfg.sampleRate),
+		meta:      meta,
+	}
+	c.log = recordingLog.With("session", c.session, "addr", addr)
+	c.rate = newRateCheck(cfg, c.log)
+	c.touch()
+	if cfg.splitSilence > 0 {
+		c.silence = newSilenceDetector(cfg.silenceThreshold, cfg.bitDepth, cfg.channels, cfg.sampleRate)
+	}
+	if cfg.dtmf {
+		c.dtmf = newDTMFState(cfg)
+	}
+	if cfg.t140 {
+		c.text = newTextStream(cfg, addr)
+		c.text.onLine = func(cue rttCue) { c.live.publish(liveEvent{text: &cue}, cfg.channels) }
+	}
+	if cfg.trimSilence > 0 {
+		c.vad = newVoiceDetector(cfg.vadThreshold, time.Duration(cfg.trimSilence), cfg.bitDepth, cfg.channels, cfg.sampleRate)
+	}
+	c.startPlugins()
+	c.span = srv.tracer.start("session")
+	c.span.set("session", c.session)
+	c.span.set("addr", addr)
+	c.span.set("ssrc", ssrc)
+	setup := c.span.child("session.setup")
+	if err := c.openSegment(); err != nil {
+		setup.fail(err)
+		setup.finish()
+		c.plugins.Close()
+		c.span.fail(err)
+		c.span.finish()
+		return nil, err
+	}
+	setup.finish()
+	srv.disk.add(c.out.size())
+	srv.cat.sessionStarted(c)
+	srv.mqtt.sessionStarted(c)
+	srv.hooks.streamStarted(c)
+	go c.run()
+	return c, nil
+}
+
+// openSegment creates the next file of the session.
+func (c *Client) openSegment() error {
+	c.part++
+	now := time.Now()
+	vars := templateVars{
+		addr:    c.addr,
+		session: c.session,
+		ssrc:    c.ssrc,
+		start:   now,
+		part:    c.part,
+	}
+	if c.meta != nil {
+		vars.title, vars.operator = c.meta.Title, c.meta.Operator
+	}
+	name := filepath.Join(c.cfg.outDir, withFormat(c.cfg.fileTemplate, expandTemplate(c.cfg.fileTemplate, vars), c.cfg.format))
+
+	seg := c.span.child("segment")
+	seg.set("part", c.part)
+	opening := seg.child("segment.open")
+	defer opening.finish()
+	fail := func(err error) error {
+		opening.fail(err)
+		seg.fail(err)
+		seg.finish()
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+		return fail(fmt.Errorf("failed to create output directory: %w", err))
+	}
+
+	title := fmt.Sprintf("RTP stream from %s, session %s part %d", c.addr, c.session, c.part)
+	if vars.title != "" {
+		title = vars.title
+	}
+	// A file that is there already, e.g. another session's of a template
+	// that doesn't tell them apart, is never replaced: the name gets a number
+	var (
+		out      segmentWriter
+		fileName string
+		err      error
+	)
+	for n := 0; ; n++ {
+		if n > maxNameTries {
+			return fail(fmt.Errorf("%s and %d numbered names after it exist already", name, maxNameTries))
+		}
+		fileName = numberedName(name, n)
+		if _, statErr := os.Lstat(fileName); statErr == nil {
+			continue
+		}
+		if out, err = c.createSegment(fileName, title, now); !errors.Is(err, fs.ErrExist) {
+			break
+		}
+	}
+	if fileName != name {
+		c.log.Warn("Recording under another name, a file of that name exists already", "file", name, "instead", fileName)
+	}
+	seg.set("file", fileName)
+	if err != nil {
+		return fail(err)
+	}
+	c.segSpan = seg
+	c.writeTime = 0
+	c.out = out
+	c.path = fileName
+	c.written = 0
+	c.segStart = c.taken
+	c.gaps = nil
+	if c.cfg.waveform > 0 {
+		c.wave = newWaveform(c.cfg)
+	}
+	c.opened = now
+	c.headerSynced = now
+	// Mixes and multitracks have no packets of their own
+	if source, err := netip.ParseAddrPort(c.addr); c.cfg.rtpdump && err == nil {
+		d, err := newRTPDumpWriter(rtpdumpPath(fileName), source, now)
+		if err != nil {
+			c.log.Warn("Creating rtpdump file failed", "file", fileName, "err", err)
+		}
+		c.dump.Store(d)
+	}
+	return nil
+}
+
+// createSegment creates the file of a segment at fileName, which must not
+// exist yet, in the configured output format.
+func (c *Client) createSegment(fileName, title string, now time.Time) (segmentWriter, error) {
+	open, registered := registeredFormat(c.cfg.format)
+	switch {
+	case c.cfg.codec == codecOpus:
+		container := "matroska"
+		if c.cfg.format == formatWebM {
+			container = "webm"
+		}
+		return newFFmpegWriter(fileName, container, []string{"-c:a", "libopus", "-b:a", c.cfg.bitrate}, c.cfg.sampleRate, c.cfg.bitDepth, c.cfg.channels, mkaMeta{title: title, start: now})
+	case c.cfg.codec == codecFLAC:
+		return newFFmpegWriter(fileName, "flac", []string{"-c:a", "flac"}, c.cfg.sampleRate, c.cfg.bitDepth, c.cfg.channels, mkaMeta{title: title, start: now})
+	case registered && !builtinFormat(c.cfg.format):
+		sink, err := open(Segment{Path: fileName, SampleRate: c.cfg.sampleRate, BitDepth: c.cfg.bitDepth, Channels: c.cfg.channels, Title: title, Start: now})
+		if err != nil {
+			return nil, err
+		}
+		return sinkWriter{sink}, nil
+	case c.cfg.format == formatMKA:
+		return newMKAWriter(fileName, c.cfg.sampleRate, c.cfg.bitDepth, c.cfg.channels, mkaMeta{title: title, start: now})
+	default:
+		// Write the WAV header, with BWF metadata if requested
+		var bext *bextChunk
+		if c.cfg.bwf {
+			bext = &bextChunk{
+				description:   title,
+				originator:    c.cfg.bwfOriginator,
+				originatorRef: fmt.Sprintf("%s-%08x-%d", c.session, c.ssrc, c.part),
+				origination:   now,
+				sampleRate:    c.cfg.sampleRate,
+				codingHistory: codingHistory(c.cfg.sampleRate, c.cfg.bitDepth, c.cfg.channels),
+			}
+		}
+		return newWAVWriter(fileName, c.cfg.sampleRate, c.cfg.bitDepth, c.cfg.channels, bext)
+	}
+}
+
+// closeSegment finalizes the current file, closes it and starts the
+// post-recording steps for it.
+func (c *Client) closeSegment() error {
+	out := c.out
+	c.out = nil
+	seg := c.segSpan
+	c.segSpan = nil
+	closing := seg.child("segment.close")
+	err := out.close()
+	closing.fail(err)
+	closing.finish()
+	seg.set("bytes", out.size())
+	seg.set("write_ms", c.writeTime)
+	seg.fail(err)
+	seg.finish()
+	if err != nil {
+		return fmt.Errorf("failed to finalize %s: %w", c.path, err)
+	}
+
+	var rtt string
+	if c.text != nil {
+		if cues := c.text.take(); len(cues) > 0 {
+			rtt = rttPath(c.path)
+			if err := writeRTT(rtt, c.opened, cues); err != nil {
+				c.log.Warn("Writing real-time text failed", "file", c.path, "err", err)
+				rtt = ""
+			}
+		}
+	}
+
+	var dump string
+	if d := c.dump.Swap(nil); d != nil {
+		dump = rtpdumpPath(c.path)
+		if err := d.close(); err != nil {
+			c.log.Warn("Writing rtpdump file failed", "file", c.path, "err", err)
+		}
+	}
+
+	var peaks string
+	if c.wave != nil {
+		peaks = peaksPath(c.path)
+		if err := c.wave.write(peaks); err != nil {
+			c.log.Warn("Writing waveform failed", "file", c.path, "err", err)
+			peaks = ""
+		}
+	}
+
+	frameSize := int64(c.cfg.bitDepth / 8 * c.cfg.channels)
+	c.srv.fin.finalized(finishedFile{
+		Path:       c.path,
+		Addr:       c.addr,
+		SSRC:       c.ssrc,
+		Session:    c.session,
+		Part:       c.part,
+		Start:      c.opened,
+		End:        time.Now(),
+		Duration:   float64(c.written/frameSize) / float64(c.cfg.sampleRate),
+		Bytes:      out.size(),
+		Format:     c.cfg.format,
+		Codec:      c.cfg.codec,
+		SampleRate: c.cfg.sampleRate,
+		BitDepth:   c.cfg.bitDepth,
+		Channels:   c.cfg.channels,
+		Tracks:     c.tracks(),
+		Gaps:       c.gaps,
+		DTMF:       c.digits,
+		RTT:        rtt,
+		Peaks:      peaks,
+		RTPDump:    dump,
+		Metadata:   c.meta,
+		span:       seg,
+	})
+	c.digits = nil
+	return nil
+}
+
+// tracks returns which source each track of a multitrack recording holds.
+func (c *Client) tracks() []string {
+	if c.trackNames == nil {
+		return nil
+	}
+	return c.trackNames()
+}
+
+// maxFileSize returns the size a single file may grow to. Only WAV has a hard
+// limit of its own.
+func (c *Client) maxFileSize() int64 {
+	limit := int64(math.MaxInt64)
+	if c.cfg.format == formatWAV {
+		limit = wavMaxSize
+	}
+	if c.cfg.maxFileSize > 0 && int64(c.cfg.maxFileSize) < limit {
+		return int64(c.cfg.maxFileSize)
+	}
+	return limit
+}
+
+// touch marks the client as having just sent a packet.
+func (c *Client) touch() {
+	c.lastSeen.Store(time.Now().UnixNano())
+}
+
+// idleFor returns how long ago the client last sent a packet.
+func (c *Client) idleFor() time.Duration {
+	return time.Since(time.Unix(0, c.lastSeen.Load()))
+}
+
+// fileName returns the path of the file currently being written, or "" while
+// between segments.
+func (c *Client) fileName() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.out == nil {
+		return ""
+	}
+	return c.path
+}
+
+// segment returns the current part number and its size in bytes.
+func (c *Client) segment() (part int, size int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.out == nil {
+		return c.part, 0
+	}
+	return c.part, c.out.size()
+}
+
+// buffer returns a buffer to decode a packet into: one the writer is done
+// with, or nil when there is none and decoding has to allocate.
+func (c *Client) buffer() []int {
+	select {
+	case buf := <-c.free:
+		return buf
+	default:
+		return nil
+	}
+}
+
+// recycle hands back a buffer that was written or dropped, for another
+// packet to be decoded into.
+func (c *Client) recycle(samples []int) {
+	select {
+	case c.free <- samples:
+	default:
+	}
+}
+
+// write queues decoded samples for the writer goroutine without blocking. If
+// the writer has fallen so far behind that the queue is full, the samples are
+// dropped. The samples belong to the client from then on.
+func (c *Client) write(samples []int) {
+	c.queueMu.Lock()
+	defer c.queueMu.Unlock()
+
+	// A packet may race with the session being closed for inactivity; the
+	// next one will start a new session.
+	if c.closed.Load() {
+		return
+	}
+	c.playMu.Lock()
+	if c.player != nil {
+		c.player.play(scaleSamples(samples, c.srv.controls.factor(c.addr), c.cfg.bitDepth))
+	}
+	c.playMu.Unlock()
+
+	select {
+	case c.queue <- samples:
+		c.queued += int64(len(samples) / c.cfg.channels)
+	default:
+		c.recycle(samples)
+		buffersDropped.Add(1)
+		if n := c.dropped.Add(1); n == 1 || n%100 == 0 {
+			c.log.Warn("Write queue is full, dropping buffers", "dropped", n)
+		}
+	}
+}
+
+// setPlaying starts or stops live playback of the stream.
+func (c *Client) setPlaying(on bool) {
+	c.playMu.Lock()
+	defer c.playMu.Unlock()
+	switch {
+	case on && c.player == nil:
+		p, err := newPlayer(c.cfg, c.addr)
+		if err != nil {
+			c.log.Warn("Live playback failed", "err", err)
+			return
+		}
+		c.player = p
+	case !on && c.player != nil:
+		c.player.stop()
+		c.player = nil
+	}
+}
+
+// run is the writer goroutine: it encodes and stores queued samples until the
+// queue is closed, and flushes the file's buffer every flushInterval.
+func (c *Client) run() {
+	defer close(c.queueDone)
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+	var taken int64 // Frames taken from the queue, for the hooks
+	for {
+		select {
+		case samples, ok := <-c.queue:
+			if !ok {
+				return
+			}
+			c.process(samples)
+			c.meter.feed(samples)
+			c.srv.hooks.audioTaken(c, samples, taken)
+			taken += int64(len(samples) / c.cfg.channels)
+			if err := c.store(samples); err != nil {
+				c.log.Error("Writing recording failed", "err", err)
+			}
+			c.recycle(samples)
+		case <-ticker.C:
+			if err := c.flush(); err != nil {
+				c.log.Error("Writing recording failed", "err", err)
+			}
+		}
+	}
+}
+
+// flush writes the audio the current file buffers.
+func (c *Client) flush() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	fw, ok := c.out.(flushWriter)
+	if !ok {
+		return nil
+	}
+	began := time.Now()
+	err := fw.flush()
+	took := time.Since(began)
+	c.writeTime += took
+	if took >= slowWrite {
+		c.segSpan.event("slow write", "duration_ms", took)
+	}
+	if err != nil {
+		c.segSpan.fail(err)
+	}
+	return err
+}
+
+// store appends samples to the recording, first rotating to a new file if the
+// current one would otherwise grow past the size limit. When splitting on
+// silence, the current file is finalized once the stream has been silent long
+// enough, and the next non-silent buffer starts a new one.
+func (c *Client) store(samples []int) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.dtmf != nil {
+		c.detectDTMF(samples, false)
+	}
+	if c.silence != nil {
+		silent := c.silence.feed(samples)
+		if c.out == nil && silent {
+			return nil
+		}
+		if c.out != nil && c.silence.silentFor() >= time.Duration(c.cfg.splitSilence) {
+			closed := c.path
+			if err := c.closeSegment(); err != nil {
+				return err
+			}
+			c.log.Info("🔇 Stream silent, closed file", "silent", c.silence.silentFor().Round(100*time.Millisecond), "file", closed)
+			return nil
+		}
+	}
+
+	// When trimming silence, long silences are held back and the audio
+	// resumes with a cue marking the gap
+	var skipped *gap
+	if c.vad != nil {
+		if samples, skipped = c.vad.feed(samples); samples == nil {
+			return nil
+		}
+	}
+
+	// Start the next segment if audio resumed after a silence split, or if
+	// opening it failed earlier
+	if c.out == nil {
+		if err := c.openSegment(); err != nil {
+			return err
+		}
+		c.log.Info("📝 Recording", "file", c.path)
+	}
+
+	size := int64(len(samples) * c.cfg.bitDepth / 8)
+	if c.written > 0 && c.out.size()+size > c.maxFileSize() {
+		if err := c.rotate(); err != nil {
+			return err
+		}
+	}
+	if skipped != nil {
+		frameSize := int64(c.cfg.bitDepth / 8 * c.cfg.channels)
+		frame := c.written / frameSize
+		skipped.At = float64(frame) / float64(c.cfg.sampleRate)
+		c.gaps = append(c.gaps, *skipped)
+		if cw, ok := c.out.(cueWriter); ok {
+			cw.addCue(frame, fmt.Sprintf("Skipped %.1fs of silence", skipped.Skipped))
+		}
+	}
+	began := time.Now()
+	if err := c.out.write(samples); err != nil {
+		c.segSpan.fail(err)
+		return err
+	}
+	took := time.Since(began)
+	c.writeTime += took
+	if took >= slowWrite {
+		c.segSpan.event("slow write", "bytes", size, "duration_ms", took)
+	}
+	if c.wave != nil {
+		c.wave.feed(samples)
+	}
+	c.written += size
+	c.srv.disk.add(size)
+	c.taken += int64(len(samples) / c.cfg.channels)
+	if len(c.patches) > 0 {
+		c.applyPatches()
+	}
+	if c.cfg.headerInterval > 0 && time.Since(c.headerSynced) >= time.Duration(c.cfg.headerInterval) {
+		c.headerSynced = time.Now()
+		err := c.out.syncHeader()
+		if took := time.Since(c.headerSynced); took >= slowWrite {
+			c.segSpan.event("slow sync", "duration_ms", took)
+		}
+		if err != nil {
+			c.segSpan.fail(err)
+			return fmt.Errorf("failed to sync %s: %w", c.path, err)
+		}
+	}
+	return nil
+}
+
+// detectDTMF records the DTMF digits that ended before samples, placing
+// them in the file, and runs the hooks of the sequences they complete. With
+// flush, it records all pending digits.
+func (c *Client) detectDTMF(samples []int, flush bool) {
+	frameSize := int64(c.cfg.bitDepth / 8 * c.cfg.channels)
+	events, sequences := c.dtmf.feed(samples, c.stored, flush)
+	for _, ev := range events {
+		// The digit ended this many frames of audio ago. Audio left out of
+		// the file since, such as trimmed silence, makes this an estimate.
+		var frame int64
+		if c.out != nil {
+			frame = max(0, c.written/frameSize-max(0, c.stored-ev.frame))
+		}
+		ev.At = max(0, float64(frame)/float64(c.cfg.sampleRate)-ev.Duration)
+		c.log.Info("☎️  DTMF", "digit", ev.Digit)
+		if cw, ok := c.out.(cueWriter); ok {
+			cw.addCue(int64(ev.At*float64(c.cfg.sampleRate)), "DTMF "+ev.Digit)
+		}
+		c.digits = append(c.digits, ev)
+		c.live.publish(liveEvent{dtmf: &ev}, c.cfg.channels)
+	}
+	c.stored += int64(len(samples) / c.cfg.channels)
+	for _, seq := range sequences {
+		c.log.Info("☎️  DTMF sequence, running its hook", "digits", seq)
+		go runDTMFHook(c.cfg.dtmfHooks[seq], seq, c, c.path)
+	}
+}
+
+// rotate finalizes the current file and continues the session in a new one.
+func (c *Client) rotate() error {
+	closed := c.path
+	if err := c.closeSegment(); err != nil {
+		return err
+	}
+	if err := c.openSegment(); err != nil {
+		return err
+	}
+	c.log.Info("🔁 Rotated", "closed", closed, "file", c.path)
+	return nil
+}
+
+// close waits for the queued audio to be written and finalizes the current
+// file of the session.
+func (c *Client) close() {
+	if c.reorder != nil {
+		c.reorder.flush()
+	}
+	c.queueMu.Lock()
+	if c.closed.Load() {
+		c.queueMu.Unlock()
+		return
+	}
+	c.closed.Store(true)
+	close(c.queue)
+	c.queueMu.Unlock()
+	c.setPlaying(false)
+	<-c.queueDone
+	c.plugins.Close()
+	// Read loops may remember the session until its stream sends again, so
+	// it lets go of its buffers now
+	for drained := false; !drained; {
+		select {
+		case <-c.free:
+		default:
+			drained = true
+		}
+	}
+	defer c.span.finish()
+	defer c.srv.cat.sessionEnded(c)
+	defer c.srv.mqtt.sessionEnded(c)
+	defer c.srv.hooks.streamEnded(c)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	defer c.live.close()
+	if c.dtmf != nil {
+		c.detectDTMF(nil, true) // Digits that arrived after the last audio
+	}
+	if c.text != nil {
+		c.text.flush()
+	}
+	if c.out == nil {
+		return
+	}
+	name := c.path
+	if err := c.closeSegment(); err != nil {
+		c.log.Error("Closing file failed", "err", err)
+		return
+	}
+	c.log.Info("Closed file", "file", name)
+}
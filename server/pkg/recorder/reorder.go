@@ -0,0 +1,261 @@
+package recorder
+
+import (
+	"expvar"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// What is done with a packet that arrives after -reorder-window gave up on
+// it, -late-packets.
+const (
+	lateDrop  = "drop"
+	latePatch = "patch"
+)
+
+const (
+	maxReorderWindow = 5 * time.Second
+	maxHeld          = 1024 // Packets a session holds back; past that its gaps are given up on at once
+	maxGapFill       = 10   // Seconds of silence a gap is filled with at most
+	maxGaps          = 64   // Gaps a session remembers, to tell late packets from duplicates
+	seqResync        = 4096 // Jump in sequence numbers taken for a restarted stream
+)
+
+var (
+	packetsLate    = expvar.NewInt("packets_late")    // Arrived after -reorder-window gave up on them
+	packetsPatched = expvar.NewInt("packets_patched") // Of those, written into their file in place
+)
+
+// reorderBuffer puts a session's packets back in sequence order for
+// -reorder-window: a packet that arrives ahead of one missing is held back
+// until the missing one arrives or the window has passed since, when the
+// missing packets are given up on and their time filled with silence. It
+// is used by the session's read loop and, to flush it, by the goroutine
+// closing the session.
+type reorderBuffer struct {
+	c      *Client
+	window time.Duration
+	emit   func(timestamp uint32, samples []int) // Hands the audio on, in order
+
+	mu      sync.Mutex
+	started bool
+	next    uint16 // Sequence number due next
+	nextTS  uint32 // Timestamp due next, where a gap's silence starts
+	held    []heldPacket
+	gaps    []filledGap // The latest ones
+}
+
+// heldPacket is the decoded audio of a packet held back until the ones
+// before it arrive.
+type heldPacket struct {
+	seq       uint16
+	timestamp uint32
+	samples   []int
+	arrival   time.Time
+}
+
+// filledGap is the silence a gap was filled with, which the packets arriving
+// late for it can still be written over with -late-packets=patch.
+type filledGap struct {
+	timestamp uint32 // Of the first frame of silence
+	frames    int64
+	queued    int64 // Frames handed to the writer before it, so where it lies in the recording
+}
+
+func newReorderBuffer(c *Client, window time.Duration, emit func(uint32, []int)) *reorderBuffer {
+	return &reorderBuffer{c: c, window: window, emit: emit}
+}
+
+// push takes the audio of a packet arriving at arrival, handing on what is
+// in order.
+func (r *reorderBuffer) push(seq uint16, timestamp uint32, samples []int, arrival time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	d := int16(seq - r.next)
+	if r.started && (d > seqResync || d < -seqResync) {
+		// The sender started over
+		r.drain(true)
+		r.started = false
+	}
+	if !r.started {
+		r.started, r.next, r.nextTS = true, seq, timestamp
+		d = 0
+	}
+	switch {
+	case d < 0:
+		r.late(timestamp, samples)
+		return
+	case d == 0:
+		r.release(seq, timestamp, samples)
+	default:
+		i := sort.Search(len(r.held), func(i int) bool { return int16(r.held[i].seq-r.next) >= d })
+		if i < len(r.held) && r.held[i].seq == seq {
+			r.c.recycle(samples) // Duplicate
+			return
+		}
+		r.held = append(r.held, heldPacket{})
+		copy(r.held[i+1:], r.held[i:])
+		r.held[i] = heldPacket{seq: seq, timestamp: timestamp, samples: samples, arrival: arrival}
+	}
+	r.drain(false)
+	// Give up on the missing packets once one held back has waited long
+	// enough for them, or too many are
+	for len(r.held) > 0 && (len(r.held) > maxHeld || arrival.Sub(r.oldest()) >= r.window) {
+		r.skip()
+		r.drain(false)
+	}
+}
+
+// flush hands on everything held back, as the session ends.
+func (r *reorderBuffer) flush() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.drain(true)
+}
+
+// release hands on the audio of the packet due next.
+func (r *reorderBuffer) release(seq uint16, timestamp uint32, samples []int) {
+	r.emit(timestamp, samples)
+	r.next = seq + 1
+	r.nextTS = timestamp + uint32(len(samples)/r.c.cfg.channels)
+}
+
+// drain hands on the held packets that are in order now, and with all the
+// others too, after the gaps before them.
+func (r *reorderBuffer) drain(all bool) {
+	for len(r.held) > 0 && (all || r.held[0].seq == r.next) {
+		if r.held[0].seq != r.next {
+			r.skip()
+			continue
+		}
+		p := r.held[0]
+		r.held = r.held[:copy(r.held, r.held[1:])]
+		r.release(p.seq, p.timestamp, p.samples)
+	}
+}
+
+// skip gives up on the packets missing before the first one held back,
+// filling their time with silence.
+func (r *reorderBuffer) skip() {
+	p := r.held[0]
+	cfg := r.c.cfg
+	if frames := int64(int32(p.timestamp - r.nextTS)); frames > 0 && frames <= int64(maxGapFill*cfg.sampleRate) {
+		if len(r.gaps) >= maxGaps {
+			r.gaps = r.gaps[:copy(r.gaps, r.gaps[1:])]
+		}
+		r.gaps = append(r.gaps, filledGap{timestamp: r.nextTS, frames: frames, queued: r.c.queued})
+		silence := r.c.buffer()[:0]
+		if n := int(frames) * cfg.channels; cap(silence) >= n {
+			silence = silence[:n]
+			clear(silence)
+		} else {
+			silence = make([]int, n)
+		}
+		r.emit(r.nextTS, silence)
+	}
+	r.next, r.nextTS = p.seq, p.timestamp
+}
+
+// oldest returns the arrival of the packet held back the longest.
+func (r *reorderBuffer) oldest() time.Time {
+	oldest := r.held[0].arrival
+	for _, p := range r.held[1:] {
+		if p.arrival.Before(oldest) {
+			oldest = p.arrival
+		}
+	}
+	return oldest
+}
+
+// late takes the audio of a packet that arrived after its slot: one given
+// up on is written over the silence it was replaced with under
+// -late-packets=patch, and dropped otherwise, as duplicates are.
+func (r *reorderBuffer) late(timestamp uint32, samples []int) {
+	frames := int64(len(samples) / r.c.cfg.channels)
+	for _, g := range r.gaps {
+		if offset := int64(int32(timestamp - g.timestamp)); offset >= 0 && offset+frames <= g.frames {
+			packetsLate.Add(1)
+			if r.c.cfg.latePackets == latePatch {
+				r.c.patch(g.queued+offset, samples)
+				return
+			}
+			break
+		}
+	}
+	r.c.recycle(samples)
+}
+
+// pendingPatch is the audio of a late packet waiting for the silence it goes
+// over to be written.
+type pendingPatch struct {
+	frame   int64 // Of the frames taken from the queue
+	samples []int
+}
+
+// patch writes the audio of a late packet over the silence at frame of the
+// frames handed to the writer, now if it has written them or else once it
+// has. Audio whose file was finalized already is dropped.
+func (c *Client) patch(frame int64, samples []int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if frame+int64(len(samples)/c.cfg.channels) > c.taken {
+		c.patches = append(c.patches, pendingPatch{frame: frame, samples: samples})
+		return
+	}
+	c.applyPatch(pendingPatch{frame: frame, samples: samples})
+}
+
+// applyPatches applies the patches whose silence has been written. The
+// caller holds mu.
+func (c *Client) applyPatches() {
+	var pending []pendingPatch
+	for _, p := range c.patches {
+		if p.frame+int64(len(p.samples)/c.cfg.channels) > c.taken {
+			pending = append(pending, p)
+			continue
+		}
+		c.applyPatch(p)
+	}
+	c.patches = pending
+}
+
+// applyPatch writes a late packet's audio into the current file, if it is
+// the one its silence went to. The caller holds mu.
+func (c *Client) applyPatch(p pendingPatch) {
+	defer c.recycle(p.samples)
+	w, ok := c.out.(patchWriter)
+	if !ok || p.frame < c.segStart {
+		return
+	}
+	frameSize := int64(c.cfg.bitDepth / 8 * c.cfg.channels)
+	if err := w.patch((p.frame-c.segStart)*frameSize, p.samples); err != nil {
+		c.log.Warn("Writing a late packet into the recording failed", "err", err)
+		return
+	}
+	packetsPatched.Add(1)
+}
+
+// patchWriter is a segmentWriter whose audio can be written over in place.
+type patchWriter interface {
+	// patch writes samples over the audio offset bytes into it.
+	patch(offset int64, samples []int) error
+}
+
+// validateReorder checks -reorder-window and -late-packets.
+func validateReorder(cfg *Config) error {
+	switch {
+	case cfg.reorderWindow < 0 || time.Duration(cfg.reorderWindow) > maxReorderWindow:
+		return fmt.Errorf("invalid -reorder-window %s (use 0 to %s)", cfg.reorderWindow.String(), maxReorderWindow)
+	case cfg.latePackets != lateDrop && cfg.latePackets != latePatch:
+		return fmt.Errorf("unknown -late-packets %q (use %s or %s)", cfg.latePackets, lateDrop, latePatch)
+	case cfg.latePackets == latePatch && cfg.reorderWindow == 0:
+		return fmt.Errorf("-late-packets=patch requires -reorder-window")
+	case cfg.latePackets == latePatch && cfg.format != formatWAV:
+		return fmt.Errorf("-late-packets=patch requires wav output")
+	case cfg.latePackets == latePatch && (cfg.trimSilence > 0 || cfg.splitSilence > 0 || len(cfg.plugins) > 0):
+		return fmt.Errorf("-late-packets=patch can't be combined with -trim-silence, -split-silence or -plugin, which move the audio in the file")
+	}
+	return nil
+}
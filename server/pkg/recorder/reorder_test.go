@@ -0,0 +1,136 @@
+package recorder
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// TestReorder pushes packets of 10 frames through a reorder buffer with a
+// window of 100 ms, around the wrap of the sequence numbers from 65535 to
+// 0 and of the timestamps, and checks what it hands on: each packet by its
+// sequence number, or the silence a gap was filled with, then what became
+// of the packets arriving late.
+func TestReorder(t *testing.T) {
+	const (
+		frames    = 10
+		firstSeq  = 65533
+		firstTS   = 1<<32 - 25 // Wraps between 65535 and 0
+		window    = 100 * time.Millisecond
+		afterward = window + 10*time.Millisecond
+	)
+	type arrival struct {
+		seq uint16
+		at  time.Duration // Since the first packet
+	}
+	for _, tt := range []struct {
+		name    string
+		mode    string // -late-packets, drop by default
+		packets []arrival
+		flush   bool
+		restart bool // The timestamps jump where the sender starts over
+		want    []string
+		late    int     // Packets taken for late
+		patches []int64 // Frames of the recording patched, with -late-packets=patch
+	}{
+		{
+			name:    "in order across the wrap",
+			packets: []arrival{{65533, 0}, {65534, 0}, {65535, 0}, {0, 0}, {1, 0}},
+			want:    []string{"65533", "65534", "65535", "0", "1"},
+		},
+		{
+			name:    "reordered across the wrap",
+			packets: []arrival{{65533, 0}, {0, 0}, {65535, 0}, {65534, 0}, {1, 0}},
+			want:    []string{"65533", "65534", "65535", "0", "1"},
+		},
+		{
+			name:    "duplicates across the wrap",
+			packets: []arrival{{65533, 0}, {65535, 0}, {65535, 0}, {65534, 0}, {0, 0}, {65535, 0}},
+			want:    []string{"65533", "65534", "65535", "0"},
+		},
+		{
+			name:    "a gap across the wrap given up on",
+			packets: []arrival{{65533, 0}, {65534, 0}, {0, 0}, {1, afterward}},
+			want:    []string{"65533", "65534", "silence 10", "0", "1"},
+		},
+		{
+			name:    "a late packet dropped",
+			mode:    lateDrop,
+			packets: []arrival{{65533, 0}, {65534, 0}, {0, 0}, {1, afterward}, {65535, afterward}},
+			want:    []string{"65533", "65534", "silence 10", "0", "1"},
+			late:    1,
+		},
+		{
+			name:    "a late packet patched over its silence",
+			mode:    latePatch,
+			packets: []arrival{{65533, 0}, {65535, 0}, {1, afterward}, {0, afterward}, {65534, afterward}},
+			want:    []string{"65533", "silence 10", "65535", "0", "1"},
+			late:    1, // 0 was still due when it arrived
+			patches: []int64{10},
+		},
+		{
+			name:    "a duplicate of a packet handed on isn't late",
+			mode:    latePatch,
+			packets: []arrival{{65533, 0}, {65534, 0}, {0, 0}, {1, afterward}, {0, afterward}},
+			want:    []string{"65533", "65534", "silence 10", "0", "1"},
+		},
+		{
+			name:    "held packets flushed as the session ends",
+			packets: []arrival{{65533, 0}, {65535, 0}, {1, 0}},
+			flush:   true,
+			want:    []string{"65533", "silence 10", "65535", "silence 10", "1"},
+		},
+		{
+			name:    "a sender starting over",
+			packets: []arrival{{65533, 0}, {65535, 0}, {30000, 0}, {30001, 0}},
+			restart: true,
+			want:    []string{"65533", "silence 10", "65535", "30000", "30001"},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			mode := tt.mode
+			if mode == "" {
+				mode = lateDrop
+			}
+			c := &Client{cfg: &Config{sampleRate: 8000, channels: 1, latePackets: mode}}
+			var got []string
+			r := newReorderBuffer(c, window, func(timestamp uint32, samples []int) {
+				if want := uint32(firstTS) + uint32(c.queued); timestamp != want && !tt.restart {
+					t.Errorf("handed on audio at %d after %d frames, want %d", timestamp, c.queued, want)
+				}
+				if samples[0] == 0 {
+					got = append(got, fmt.Sprintf("silence %d", len(samples)))
+				} else {
+					got = append(got, fmt.Sprint(samples[0]-1))
+				}
+				c.queued += int64(len(samples))
+			})
+			start := time.Now()
+			lateBefore := packetsLate.Value()
+			for _, p := range tt.packets {
+				samples := make([]int, frames)
+				for i := range samples {
+					samples[i] = int(p.seq) + 1
+				}
+				r.push(p.seq, uint32(firstTS)+uint32(p.seq-firstSeq)*frames, samples, start.Add(p.at))
+			}
+			if tt.flush {
+				r.flush()
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("handed on %q, want %q", got, tt.want)
+			}
+			if n := int(packetsLate.Value() - lateBefore); n != tt.late {
+				t.Errorf("%d packets were late, want %d", n, tt.late)
+			}
+			var patches []int64
+			for _, p := range c.patches {
+				patches = append(patches, p.frame)
+			}
+			if !reflect.DeepEqual(patches, tt.patches) {
+				t.Errorf("patched frames %v, want %v", patches, tt.patches)
+			}
+		})
+	}
+}
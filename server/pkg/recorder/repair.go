@@ -0,0 +1,186 @@
+package recorder
+
+import (
+	"encoding/binary"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// Repair fixes the headers of the WAV file at path, left behind by a crash
+// or a killed server, as described for repairWAV. A file that needs no fix
+// is left alone.
+func Repair(path string) error {
+	_, err := repairWAV(path, false)
+	return err
+}
+
+// RepairCommand implements the "repair" subcommand, which runs Repair on
+// the files given and returns the exit status.
+func RepairCommand(args []string) int {
+	fs := flag.NewFlagSet("repair", flag.ContinueOnError)
+	dryRun := fs.Bool("dry-run", false, "only report what would be fixed")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s repair [-dry-run] <file.wav>...\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return 0
+		}
+		return 2
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return 2
+	}
+
+	status := 0
+	for _, path := range fs.Args() {
+		fixed, err := repairWAV(path, *dryRun)
+		switch {
+		case err != nil:
+			fmt.Printf("❌ %s: %v\n", path, err)
+			status = 1
+		case fixed == "":
+			fmt.Printf("✅ %s: OK\n", path)
+		case *dryRun:
+			fmt.Printf("🔧 %s: would fix %s\n", path, fixed)
+		default:
+			fmt.Printf("🔧 %s: fixed %s\n", path, fixed)
+		}
+	}
+	return status
+}
+
+// repairWAV makes the RIFF and data chunk sizes of a WAV file match its
+// contents. A data chunk that is the last chunk in the file is extended to
+// the end of the file, minus any trailing partial frame, which recovers audio
+// written after the last header update. It returns a description of what was
+// fixed, or "" if the file was already consistent.
+func repairWAV(path string, dryRun bool) (string, error) {
+	flags := os.O_RDWR
+	if dryRun {
+		flags = os.O_RDONLY
+	}
+	f, err := os.OpenFile(path, flags, 0)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+	fileSize := info.Size()
+
+	var riff [12]byte
+	if _, err := io.ReadFull(f, riff[:]); err != nil {
+		return "", errors.New("too short to be a WAV file")
+	}
+	if string(riff[0:4]) != "RIFF" || string(riff[8:12]) != "WAVE" {
+		return "", errors.New("not a RIFF/WAVE file")
+	}
+
+	// Walk the chunks up to the data chunk, remembering the frame size from fmt
+	blockAlign := 0
+	offset := int64(12)
+	var dataSizePos, dataStart, dataSize int64 = -1, 0, 0
+	for offset+8 <= fileSize {
+		var hdr [8]byte
+		if _, err := f.ReadAt(hdr[:], offset); err != nil {
+			return "", err
+		}
+		id, size := string(hdr[0:4]), int64(binary.LittleEndian.Uint32(hdr[4:8]))
+		if id == "fmt " {
+			var fmtChunk [16]byte
+			if _, err := f.ReadAt(fmtChunk[:], offset+8); err != nil {
+				return "", fmt.Errorf("truncated fmt chunk: %w", err)
+			}
+			blockAlign = int(binary.LittleEndian.Uint16(fmtChunk[12:14]))
+		}
+		if id == "data" {
+			dataSizePos, dataStart, dataSize = offset+4, offset+8, size
+			break
+		}
+		offset += 8 + size + size%2 // Chunks are padded to an even size
+	}
+	if blockAlign == 0 {
+		return "", errors.New("no fmt chunk found")
+	}
+	if dataSizePos < 0 {
+		return "", errors.New("no data chunk found")
+	}
+
+	// Trust the declared data size only if another chunk follows it (e.g. the
+	// LIST metadata written on a clean close); otherwise the data runs to EOF.
+	newFileSize := fileSize
+	dataEnd := dataStart + dataSize
+	if dataEnd > fileSize || !chunkFollows(f, dataEnd+dataSize%2, fileSize) {
+		dataSize = fileSize - dataStart
+		dataSize -= dataSize % int64(blockAlign)
+		newFileSize = dataStart + dataSize // Drop a trailing partial frame
+	}
+
+	var fixes []string
+	var b [4]byte
+	if _, err := f.ReadAt(b[:], 4); err != nil {
+		return "", err
+	}
+	if int64(binary.LittleEndian.Uint32(b[:])) != newFileSize-8 {
+		fixes = append(fixes, fmt.Sprintf("RIFF size (%d -> %d)", binary.LittleEndian.Uint32(b[:]), newFileSize-8))
+	}
+	if _, err := f.ReadAt(b[:], dataSizePos); err != nil {
+		return "", err
+	}
+	if int64(binary.LittleEndian.Uint32(b[:])) != dataSize {
+		fixes = append(fixes, fmt.Sprintf("data size (%d -> %d)", binary.LittleEndian.Uint32(b[:]), dataSize))
+	}
+	if newFileSize != fileSize {
+		fixes = append(fixes, fmt.Sprintf("trailing partial frame (%d bytes)", fileSize-newFileSize))
+	}
+	if len(fixes) == 0 {
+		return "", nil
+	}
+	desc := strings.Join(fixes, ", ")
+	if dryRun {
+		return desc, nil
+	}
+
+	if newFileSize != fileSize {
+		if err := f.Truncate(newFileSize); err != nil {
+			return "", err
+		}
+	}
+	binary.LittleEndian.PutUint32(b[:], uint32(newFileSize-8))
+	if _, err := f.WriteAt(b[:], 4); err != nil {
+		return "", err
+	}
+	binary.LittleEndian.PutUint32(b[:], uint32(dataSize))
+	if _, err := f.WriteAt(b[:], dataSizePos); err != nil {
+		return "", err
+	}
+	return desc, f.Sync()
+}
+
+// chunkFollows reports whether a plausible RIFF chunk header starts at offset
+// and fits within the file.
+func chunkFollows(f *os.File, offset, fileSize int64) bool {
+	if offset+8 > fileSize {
+		return false
+	}
+	var hdr [8]byte
+	if _, err := f.ReadAt(hdr[:], offset); err != nil {
+		return false
+	}
+	for _, c := range hdr[0:4] {
+		if c < 0x20 || c > 0x7e {
+			return false
+		}
+	}
+	return offset+8+int64(binary.LittleEndian.Uint32(hdr[4:8])) <= fileSize
+}
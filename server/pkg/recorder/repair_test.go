@@ -0,0 +1,201 @@
+package recorder
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// testWAV lays out a WAV file with a fmt chunk of channels at 16 bits, a
+// data chunk declaring dataSize and holding data, then the chunks of tail,
+// the RIFF chunk declaring riffSize.
+func testWAV(channels int, riffSize, dataSize uint32, data []byte, tail ...[]byte) []byte {
+	var b []byte
+	b = append(b, "RIFF"...)
+	b = binary.LittleEndian.AppendUint32(b, riffSize)
+	b = append(b, "WAVEfmt "...)
+	b = binary.LittleEndian.AppendUint32(b, 16)
+	b = binary.LittleEndian.AppendUint16(b, 1) // PCM
+	b = binary.LittleEndian.AppendUint16(b, uint16(channels))
+	b = binary.LittleEndian.AppendUint32(b, 48000)
+	b = binary.LittleEndian.AppendUint32(b, uint32(48000*channels*2))
+	b = binary.LittleEndian.AppendUint16(b, uint16(channels*2)) // Block align
+	b = binary.LittleEndian.AppendUint16(b, 16)
+	b = append(b, "data"...)
+	b = binary.LittleEndian.AppendUint32(b, dataSize)
+	b = append(b, data...)
+	for _, chunk := range tail {
+		b = append(b, chunk...)
+	}
+	return b
+}
+
+// testChunk lays out a chunk of id holding body, padded to an even size.
+func testChunk(id string, body []byte) []byte {
+	b := append([]byte(id), binary.LittleEndian.AppendUint32(nil, uint32(len(body)))...)
+	b = append(b, body...)
+	if len(body)%2 == 1 {
+		b = append(b, 0)
+	}
+	return b
+}
+
+func TestRepairWAV(t *testing.T) {
+	const header = 44 // Of testWAV, up to the data
+	data := bytes.Repeat([]byte{1, 2, 3, 4}, 1000)
+	list := testChunk("LIST", []byte("INFOISFT\x04\x00\x00\x00test"))
+	for _, tt := range []struct {
+		name     string
+		file     []byte
+		fixed    string // What repairWAV says it fixed
+		dataSize int    // Declared once fixed
+		size     int    // Of the file once fixed
+	}{
+		{
+			name:     "closed cleanly",
+			file:     testWAV(2, uint32(header-8+len(data)+len(list)), uint32(len(data)), data, list),
+			dataSize: len(data), size: header + len(data) + len(list),
+		},
+		{
+			name:     "killed before the first header update",
+			file:     testWAV(2, 0, 0, data),
+			fixed:    "RIFF size (0 -> 4036), data size (0 -> 4000)",
+			dataSize: len(data), size: header + len(data),
+		},
+		{
+			name:     "killed after an update, with audio written since",
+			file:     testWAV(2, header-8+1000, 1000, data),
+			fixed:    "RIFF size (1036 -> 4036), data size (1000 -> 4000)",
+			dataSize: len(data), size: header + len(data),
+		},
+		{
+			name:     "declaring more than the file holds",
+			file:     testWAV(2, 1<<20, 1<<20, data, list),
+			fixed:    "RIFF size (1048576 -> " + strconv.Itoa(header-8+len(data)+len(list)) + "), data size (1048576 -> " + strconv.Itoa(len(data)+len(list)) + ")",
+			dataSize: len(data) + len(list), size: header + len(data) + len(list),
+		},
+		{
+			name:     "ending in a partial stereo frame",
+			file:     testWAV(2, 0, 0, append(data, 5, 6, 7)),
+			fixed:    "RIFF size (0 -> 4036), data size (0 -> 4000), trailing partial frame (3 bytes)",
+			dataSize: len(data), size: header + len(data),
+		},
+		{
+			name:     "ending in an odd byte",
+			file:     testWAV(1, header-8+1001, 1001, data[:1001]),
+			fixed:    "RIFF size (1037 -> 1036), data size (1001 -> 1000), trailing partial frame (1 bytes)",
+			dataSize: 1000, size: header + 1000,
+		},
+		{
+			name:     "of odd-length data padded before the next chunk",
+			file:     testWAV(1, uint32(header-8+1002+len(list)), 1001, append(data[:1001], 0), list),
+			dataSize: 1001, size: header + 1002 + len(list),
+		},
+		{
+			name:     "with garbage after the declared data",
+			file:     testWAV(1, header-8+1000, 1000, append(data[:1000], 0xff, 0xfe, 0x01, 0x02, 0xff, 0xfe, 0x01, 0x02, 0xff, 0xfe)),
+			fixed:    "RIFF size (1036 -> 1046), data size (1000 -> 1010)",
+			dataSize: 1010, size: header + 1010,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "a.wav")
+			if err := os.WriteFile(path, tt.file, 0o644); err != nil {
+				t.Fatal(err)
+			}
+			// A dry run says what would be fixed and leaves the file alone
+			fixed, err := repairWAV(path, true)
+			if err != nil || fixed != tt.fixed {
+				t.Fatalf("dry run: %q, %v; want %q", fixed, err, tt.fixed)
+			}
+			if b, _ := os.ReadFile(path); !bytes.Equal(b, tt.file) {
+				t.Fatal("the dry run changed the file")
+			}
+
+			if fixed, err = repairWAV(path, false); err != nil || fixed != tt.fixed {
+				t.Fatalf("got %q, %v; want %q", fixed, err, tt.fixed)
+			}
+			b, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(b) != tt.size {
+				t.Errorf("the file is %d bytes, want %d", len(b), tt.size)
+			}
+			if riff := binary.LittleEndian.Uint32(b[4:]); int(riff) != len(b)-8 {
+				t.Errorf("the RIFF chunk declares %d bytes, the file holds %d", riff, len(b)-8)
+			}
+			if size := binary.LittleEndian.Uint32(b[header-4:]); int(size) != tt.dataSize {
+				t.Errorf("the data chunk declares %d bytes, want %d", size, tt.dataSize)
+			}
+			if !bytes.Equal(b[header:header+1000], data[:1000]) {
+				t.Error("the audio changed")
+			}
+			// Repaired, it needs nothing more
+			if fixed, err := repairWAV(path, false); err != nil || fixed != "" {
+				t.Errorf("repairing again: %q, %v", fixed, err)
+			}
+		})
+	}
+}
+
+func TestRepairWAVErrors(t *testing.T) {
+	wav := testWAV(1, 0, 0, nil)
+	for _, tt := range []struct {
+		name string
+		file []byte
+		err  string
+	}{
+		{"empty", nil, "too short"},
+		{"not RIFF", append([]byte("RIFX"), wav[4:]...), "not a RIFF/WAVE file"},
+		{"without fmt", append(append([]byte{}, wav[:12]...), testChunk("data", []byte{1, 2})...), "no fmt chunk"},
+		{"without data", append(append([]byte{}, wav[:36]...), testChunk("LIST", nil)...), "no data chunk"},
+		{"truncated fmt", wav[:28], "truncated fmt chunk"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "a.wav")
+			if err := os.WriteFile(path, tt.file, 0o644); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := repairWAV(path, false); err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("got %v, want an error with %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestChunkFollows(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		file   []byte
+		offset int64
+		want   bool
+	}{
+		{"chunk", testChunk("LIST", []byte("INFO")), 0, true},
+		{"empty chunk", testChunk("JUNK", nil), 0, true},
+		{"chunk past the end", testChunk("LIST", []byte("INFO"))[:10], 0, false},
+		{"header past the end", []byte("LIST\x00\x00"), 0, false},
+		{"offset past the end", testChunk("LIST", nil), 4, false},
+		{"unprintable ID", testChunk("LI\x00T", nil), 0, false},
+		{"audio", []byte{0x12, 0x80, 0xff, 0x7f, 0, 0, 0, 0}, 0, false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "f")
+			if err := os.WriteFile(path, tt.file, 0o644); err != nil {
+				t.Fatal(err)
+			}
+			f, err := os.Open(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+			if got := chunkFollows(f, tt.offset, int64(len(tt.file))); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
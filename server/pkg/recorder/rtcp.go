@@ -0,0 +1,274 @@
+package recorder
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"net"
+	"net/netip"
+	"os"
+	"time"
+
+	"github.com/fcerini/audio-capture-shared/pkg/handshake"
+)
+
+// RTCP packet types (RFC 3550 section 12.1).
+const (
+	rtcpSR   = 200
+	rtcpRR   = 201
+	rtcpSDES = 202
+	rtcpBYE  = 203
+)
+
+// byeLinger is how long after a BYE the late packets of its SSRC are dropped
+// rather than starting a new session.
+const byeLinger = 2 * time.Second
+
+// bye is a session its sender ended with an RTCP BYE.
+type bye struct {
+	ssrc uint32
+	at   time.Time
+}
+
+// isRTCP tells RTCP from RTP sharing a port (RFC 5761 section 4): their
+// second byte, RTP's marker bit and payload type, is 192 to 223 for RTCP.
+func isRTCP(packet []byte) bool {
+	return len(packet) >= 2 && packet[1] >= 192 && packet[1] <= 223
+}
+
+// receptionReport is one report block of an RTCP receiver report (RFC 3550
+// section 6.4.1).
+type receptionReport struct {
+	fractionLost uint8  // Since the previous report, in 256ths
+	lost         int32  // Cumulative, 24 bits when sent
+	highestSeq   uint32 // Extended with the wraparound count
+	jitter       uint32 // In timestamp units
+	lastSR       uint32 // LSR: the sender's latest report, 0 if none
+	delaySR      uint32 // DLSR: time since then, in 1/65536 s
+}
+
+// report returns the reception report of the stream since the previous one,
+// following RFC 3550 appendix A.3. It returns false before the first packet.
+func (r *rtpReceiver) report() (receptionReport, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.started {
+		return receptionReport{}, false
+	}
+	highest := r.cycles + uint32(r.maxSeq)
+	expected := int64(highest) - int64(r.baseSeq) + 1
+	rr := receptionReport{
+		lost:       int32(min(max(expected-r.received, -1<<23), 1<<23-1)),
+		highestSeq: highest,
+		jitter:     uint32(r.jitter),
+	}
+	expectedInterval := expected - r.expectedPrior
+	lostInterval := expectedInterval - (r.received - r.receivedPrior)
+	if expectedInterval > 0 && lostInterval > 0 {
+		rr.fractionLost = uint8(lostInterval << 8 / expectedInterval)
+	}
+	r.expectedPrior, r.receivedPrior = expected, r.received
+	if r.lastSR != 0 {
+		rr.lastSR = r.lastSR
+		rr.delaySR = uint32(time.Since(r.lastSRAt).Seconds() * 65536)
+	}
+	return rr, true
+}
+
+// senderReport records the arrival of an RTCP sender report, whose NTP time
+// goes back in the next receiver report so the sender can measure the round
+// trip.
+func (r *rtpReceiver) senderReport(ntpMiddle uint32, arrival time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.lastSR, r.lastSRAt = ntpMiddle, arrival
+}
+
+// handleRTCP takes the sender reports, goodbyes and handshakes from a
+// compound RTCP packet from addr, known as name. On the RTP port, with mux,
+// it is about the stream from the same address; on the RTCP port, about the
+// stream of the SSRC it names from the same IP, and handshakes are ignored.
+func (s *server) handleRTCP(name string, addr netip.AddrPort, b []byte, arrival time.Time, mux bool) {
+	for len(b) >= 8 && b[0]>>6 == 2 {
+		size := (int(binary.BigEndian.Uint16(b[2:])) + 1) * 4
+		if size > len(b) {
+			return
+		}
+		switch {
+		case b[1] == rtcpSR && size >= 28:
+			if c := s.rtcpClient(name, addr, binary.BigEndian.Uint32(b[4:]), mux); c != nil {
+				c.rtp.senderReport(binary.BigEndian.Uint32(b[10:]), arrival)
+			}
+		case b[1] == rtcpBYE:
+			s.handleBye(name, addr, b[:size], mux)
+		case mux:
+			if subtype, pairs, ok := handshake.Unmarshal(b[:size]); ok && subtype == handshake.Offer {
+				s.handleOffer(name, pairs)
+			}
+		}
+		b = b[size:]
+	}
+}
+
+// rtcpClient returns the session RTCP from addr about ssrc is for, or nil:
+// with mux, the one from the same address, whatever its SSRC, as sender
+// reports have always been taken; without, the one of ssrc from the same IP.
+func (s *server) rtcpClient(name string, addr netip.AddrPort, ssrc uint32, mux bool) *Client {
+	if mux {
+		c, _ := s.clients.get(name)
+		return c
+	}
+	var found *Client
+	s.clients.each(func(_ string, c *Client) bool {
+		if from, err := netip.ParseAddrPort(c.addr); c.ssrc == ssrc && err == nil && from.Addr() == addr.Addr() {
+			found = c
+		}
+		return found == nil
+	})
+	return found
+}
+
+// handleBye finalizes at once the sessions of the SSRCs a BYE packet (RFC
+// 3550 section 6.6) from addr says goodbye for, rather than after
+// -idle-timeout. They are finalized in the background, so the read loop
+// doesn't wait for their queued audio.
+func (s *server) handleBye(name string, addr netip.AddrPort, b []byte, mux bool) {
+	count := int(b[0] & 0x1f)
+	if len(b) < 4+4*count {
+		return
+	}
+	var reason string
+	if rest := b[4+4*count:]; len(rest) > 0 && len(rest) > int(rest[0]) {
+		reason = string(rest[1 : 1+int(rest[0])])
+	}
+	for i := range count {
+		ssrc := binary.BigEndian.Uint32(b[4+4*i:])
+		c := s.rtcpClient(name, addr, ssrc, mux)
+		if c == nil || c.ssrc != ssrc {
+			continue
+		}
+		s.clientsMutex.Lock()
+		current, _ := s.clients.get(c.addr)
+		ended := current == c
+		if ended {
+			s.clients.delete(c.addr)
+			if len(s.byes) >= maxOffers {
+				s.byes = make(map[string]bye)
+			}
+			s.byes[c.addr] = bye{ssrc: ssrc, at: time.Now()}
+			s.evicting.Add(1)
+		}
+		s.clientsMutex.Unlock()
+		if !ended {
+			continue
+		}
+		c.log.Info("👋 Sender said goodbye, finalizing recording", "reason", reason)
+		go func() {
+			defer s.evicting.Done()
+			c.close()
+		}()
+	}
+}
+
+// listenRTCP opens the RTCP port, port on host, for senders sending their
+// RTCP to a port of its own rather than the RTP port.
+func listenRTCP(host string, port int) (*net.UDPConn, error) {
+	network, addr := listenNetwork(host, port)
+	pc, err := net.ListenPacket(network, addr)
+	if err != nil {
+		return nil, err
+	}
+	return pc.(*net.UDPConn), nil
+}
+
+// serveRTCP reads the RTCP port until it is closed. Anything but RTCP is
+// ignored there.
+func (s *server) serveRTCP() {
+	buf := make([]byte, 1500)
+	for {
+		n, addr, err := s.rtcp.ReadFromUDPAddrPort(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			continue
+		}
+		arrival := time.Now()
+		addr = netip.AddrPortFrom(addr.Addr().Unmap(), addr.Port())
+		s.pcap.WriteUDP(addr, s.rtcp.LocalAddr().(*net.UDPAddr).AddrPort(), buf[:n], arrival)
+		if !s.access.allowed(addr.Addr()) || !isRTCP(buf[:n]) {
+			continue
+		}
+		s.handleRTCP(addr.String(), addr, buf[:n], arrival, false)
+	}
+}
+
+// marshalRR encodes a compound RTCP packet: a receiver report from sender
+// about source, and the SDES CNAME every compound packet has to carry.
+func marshalRR(sender, source uint32, rr receptionReport, cname string) []byte {
+	b := make([]byte, 32, 64)
+	b[0] = 2<<6 | 1 // Version 2, one report block
+	b[1] = rtcpRR
+	binary.BigEndian.PutUint16(b[2:], 7) // Length in 32-bit words, minus one
+	binary.BigEndian.PutUint32(b[4:], sender)
+	binary.BigEndian.PutUint32(b[8:], source)
+	binary.BigEndian.PutUint32(b[12:], uint32(rr.lost)&0xffffff|uint32(rr.fractionLost)<<24)
+	binary.BigEndian.PutUint32(b[16:], rr.highestSeq)
+	binary.BigEndian.PutUint32(b[20:], rr.jitter)
+	binary.BigEndian.PutUint32(b[24:], rr.lastSR)
+	binary.BigEndian.PutUint32(b[28:], rr.delaySR)
+
+	// One chunk with the CNAME item, ended by a null item and padded to
+	// a 32-bit boundary
+	cname = cname[:min(len(cname), 255)]
+	chunk := 4 + 2 + len(cname) + 1
+	chunk += (4 - chunk%4) % 4
+	sdes := make([]byte, 4+chunk)
+	sdes[0] = 2<<6 | 1
+	sdes[1] = rtcpSDES
+	binary.BigEndian.PutUint16(sdes[2:], uint16(len(sdes)/4-1))
+	binary.BigEndian.PutUint32(sdes[4:], sender)
+	sdes[8] = 1 // CNAME
+	sdes[9] = byte(len(cname))
+	copy(sdes[10:], cname)
+	return append(b, sdes...)
+}
+
+// sendReports sends an RTCP receiver report back to every sender each
+// -rtcp-interval, on the RTP port (rtcp-mux, RFC 5761), so senders can
+// monitor the loss and jitter of their streams.
+func (s *server) sendReports(ctx context.Context) {
+	var id [4]byte
+	rand.Read(id[:])
+	ssrc := binary.BigEndian.Uint32(id[:])
+	host, _ := os.Hostname()
+	cname := "audio-capture-server@" + host
+
+	ticker := time.NewTicker(time.Duration(s.cfg.rtcpInterval))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		for _, c := range s.clients.list() {
+			rr, ok := c.rtp.report()
+			if !ok {
+				continue
+			}
+			addr, err := netip.ParseAddrPort(c.addr)
+			if err != nil {
+				continue
+			}
+			pkt := marshalRR(ssrc, c.ssrc, rr, cname)
+			if err := s.reply(pkt, addr); err != nil {
+				c.log.Debug("Sending RTCP receiver report failed", "err", err)
+				continue
+			}
+			s.pcap.WriteUDP(s.localAddr, addr, pkt, time.Now())
+		}
+	}
+}
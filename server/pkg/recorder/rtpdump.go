@@ -0,0 +1,195 @@
+package recorder
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// rtpdumpPath returns where the raw RTP packets of a recording are stored
+// with -rtpdump.
+func rtpdumpPath(recording string) string {
+	return strings.TrimSuffix(recording, filepath.Ext(recording)) + ".rtpdump"
+}
+
+// rtpdumpWriter stores packets in the rtpdump format of rtptools: a text
+// line naming the source, a binary header with the start time, and every
+// packet with its arrival in milliseconds since then. rtpplay, Wireshark and
+// the replay subcommand read it. A nil writer records nothing.
+type rtpdumpWriter struct {
+	mu     sync.Mutex
+	f      *os.File
+	w      *bufio.Writer
+	start  time.Time
+	closed bool
+	err    error // First write error; later packets are dropped
+}
+
+// newRTPDumpWriter creates the file for the packets from source.
+func newRTPDumpWriter(path string, source netip.AddrPort, start time.Time) (*rtpdumpWriter, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	d := &rtpdumpWriter{f: f, w: bufio.NewWriter(f), start: start}
+	fmt.Fprintf(d.w, "#!rtpplay1.0 %s/%d\n", source.Addr().Unmap(), source.Port())
+
+	var hdr [16]byte
+	binary.BigEndian.PutUint32(hdr[0:], uint32(start.Unix()))
+	binary.BigEndian.PutUint32(hdr[4:], uint32(start.Nanosecond()/1000))
+	if ip := source.Addr().Unmap(); ip.Is4() {
+		a := ip.As4()
+		copy(hdr[8:], a[:])
+	}
+	binary.BigEndian.PutUint16(hdr[12:], source.Port())
+	d.w.Write(hdr[:])
+	return d, nil
+}
+
+// write stores an RTP packet that arrived at t.
+func (d *rtpdumpWriter) write(packet []byte, t time.Time) {
+	if d == nil {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.closed || d.err != nil {
+		return
+	}
+	var hdr [8]byte
+	binary.BigEndian.PutUint16(hdr[0:], uint16(8+len(packet)))
+	binary.BigEndian.PutUint16(hdr[2:], uint16(len(packet)))
+	binary.BigEndian.PutUint32(hdr[4:], uint32(t.Sub(d.start).Milliseconds()))
+	d.w.Write(hdr[:])
+	_, d.err = d.w.Write(packet)
+}
+
+// close flushes and closes the file.
+func (d *rtpdumpWriter) close() error {
+	if d == nil {
+		return nil
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.closed = true
+	err := d.err
+	if ferr := d.w.Flush(); err == nil {
+		err = ferr
+	}
+	if cerr := d.f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// rtpdumpPacket is a packet read back from an rtpdump file.
+type rtpdumpPacket struct {
+	offset time.Duration // Since the start of the recording
+	data   []byte
+}
+
+// readRTPDump reads the packets of an rtpdump file.
+func readRTPDump(path string) (source string, packets []rtpdumpPacket, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", nil, err
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+
+	line, err := r.ReadString('\n')
+	if err != nil || !strings.HasPrefix(line, "#!rtpplay1.0 ") {
+		return "", nil, errors.New("not an rtpdump file")
+	}
+	source = strings.TrimSpace(strings.TrimPrefix(line, "#!rtpplay1.0 "))
+	if _, err := io.ReadFull(r, make([]byte, 16)); err != nil {
+		return "", nil, errors.New("truncated rtpdump header")
+	}
+
+	for {
+		var hdr [8]byte
+		if _, err := io.ReadFull(r, hdr[:]); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				return source, packets, nil // A crash may leave a partial record
+			}
+			return "", nil, err
+		}
+		length := int(binary.BigEndian.Uint16(hdr[0:]))
+		if length < 8 {
+			return "", nil, fmt.Errorf("invalid record after %d packets", len(packets))
+		}
+		data := make([]byte, length-8)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return source, packets, nil
+		}
+		if binary.BigEndian.Uint16(hdr[2:]) == 0 {
+			continue // RTCP
+		}
+		packets = append(packets, rtpdumpPacket{
+			offset: time.Duration(binary.BigEndian.Uint32(hdr[4:])) * time.Millisecond,
+			data:   data,
+		})
+	}
+}
+
+// Replay implements the "replay" subcommand, which sends the packets of
+// an rtpdump file to a destination with their original timing.
+func Replay(args []string) int {
+	fs := flag.NewFlagSet("replay", flag.ContinueOnError)
+	speed := fs.Float64("speed", 1, "playback speed; 2 sends twice as fast")
+	loop := fs.Bool("loop", false, "start over at the end until interrupted")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s replay [-speed 1] [-loop] <file.rtpdump> <host:port>\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return 0
+		}
+		return 2
+	}
+	if fs.NArg() != 2 || *speed <= 0 {
+		fs.Usage()
+		return 2
+	}
+	path, dest := fs.Arg(0), fs.Arg(1)
+
+	source, packets, err := readRTPDump(path)
+	if err != nil {
+		fmt.Printf("❌ %s: %v\n", path, err)
+		return 1
+	}
+	conn, err := net.Dial("udp", dest)
+	if err != nil {
+		fmt.Printf("❌ %v\n", err)
+		return 1
+	}
+	defer conn.Close()
+
+	fmt.Printf("▶️  Replaying %d packets from %s, recorded from %s\n", len(packets), path, source)
+	for {
+		start := time.Now()
+		for _, p := range packets {
+			time.Sleep(time.Until(start.Add(time.Duration(float64(p.offset) / *speed))))
+			if _, err := conn.Write(p.data); err != nil {
+				fmt.Printf("❌ %v\n", err)
+				return 1
+			}
+		}
+		if !*loop {
+			break
+		}
+	}
+	fmt.Printf("✅ Sent %d packets to %s\n", len(packets), dest)
+	return 0
+}
@@ -0,0 +1,204 @@
+package recorder
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	rttPause  = 5 * time.Second // Typing pause that ends a caption
+	rttMinCue = 2 * time.Second // Shortest time a caption is shown
+	rttLost   = '\uFFFD'        // Marks text lost in transit (RFC 4103)
+)
+
+// rttPath returns where the real-time text received during a recording is
+// written, as subtitles next to it.
+func rttPath(recording string) string {
+	return strings.TrimSuffix(recording, filepath.Ext(recording)) + ".rtt.srt"
+}
+
+// rttCue is a line of real-time text.
+type rttCue struct {
+	Start, End time.Time
+	Text       string
+}
+
+// textStream assembles the T.140 real-time text (RFC 4103) sent alongside a
+// stream into lines. Packets may carry redundant copies of earlier ones
+// (RFC 2198); each generation is used once, and text lost despite them is
+// marked. It is fed on the packet path and read by the writer goroutine.
+type textStream struct {
+	cfg  *Config
+	addr string
+
+	mu       sync.Mutex
+	haveSeq  bool
+	seq      uint16 // Latest sequence number used
+	line     []rune
+	start    time.Time // When the current line was started
+	lastChar time.Time
+	cues     []rttCue
+
+	onLine func(rttCue) // Called with each finished line, if set
+}
+
+func newTextStream(cfg *Config, addr string) *textStream {
+	return &textStream{cfg: cfg, addr: addr}
+}
+
+// receive handles a T.140 or RED packet.
+func (t *textStream) receive(payloadType uint8, seq uint16, payload []byte) {
+	now := time.Now()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	blocks := [][]byte{payload}
+	if int(payloadType) == t.cfg.t140RedPT {
+		var ok bool
+		if blocks, ok = redBlocks(payload, t.cfg.t140PT); !ok {
+			return
+		}
+	}
+	// The last block is the packet's own text, each one before it belongs
+	// to the packet sent before
+	for i, block := range blocks {
+		blockSeq := seq - uint16(len(blocks)-1-i)
+		if t.haveSeq && int16(blockSeq-t.seq) <= 0 {
+			continue // Already used
+		}
+		if t.haveSeq && blockSeq-t.seq > 1 {
+			t.add(string(rttLost), now)
+		}
+		t.haveSeq, t.seq = true, blockSeq
+		t.add(string(block), now)
+	}
+}
+
+// redBlocks splits a RED payload into its blocks, oldest first, keeping only
+// those carrying T.140 text. Redundant blocks that are empty stand for
+// packets that carried no text.
+func redBlocks(payload []byte, textPT int) ([][]byte, bool) {
+	type header struct {
+		pt     int
+		length int
+	}
+	var headers []header
+	for {
+		if len(payload) == 0 {
+			return nil, false
+		}
+		if payload[0]&0x80 == 0 { // Last header: primary block
+			headers = append(headers, header{pt: int(payload[0] & 0x7F), length: -1})
+			payload = payload[1:]
+			break
+		}
+		if len(payload) < 4 {
+			return nil, false
+		}
+		headers = append(headers, header{pt: int(payload[0] & 0x7F), length: int(payload[2]&0x03)<<8 | int(payload[3])})
+		payload = payload[4:]
+	}
+
+	var blocks [][]byte
+	for _, h := range headers {
+		n := h.length
+		if n < 0 {
+			n = len(payload)
+		}
+		if n > len(payload) {
+			return nil, false
+		}
+		if h.pt == textPT {
+			blocks = append(blocks, payload[:n])
+		} else {
+			blocks = append(blocks, nil)
+		}
+		payload = payload[n:]
+	}
+	return blocks, true
+}
+
+// add applies received text to the current line. Backspaces erase the
+// previous character of the line and line separators end it.
+func (t *textStream) add(text string, now time.Time) {
+	if !utf8.ValidString(text) {
+		text = strings.ToValidUTF8(text, string(rttLost))
+	}
+	for _, r := range text {
+		switch {
+		case r == '\uFEFF', r == '\r': // Byte order mark, sent as keep-alive
+		case r == '\b':
+			if len(t.line) > 0 {
+				t.line = t.line[:len(t.line)-1]
+			}
+		case r == '\n', r == '\u2028', r == '\u2029': // Line and paragraph separators
+			t.endLine(now)
+		case unicode.IsControl(r):
+		default:
+			if len(t.line) > 0 && now.Sub(t.lastChar) > rttPause {
+				t.endLine(t.lastChar)
+			}
+			if len(t.line) == 0 {
+				t.start = now
+			}
+			t.line = append(t.line, r)
+			t.lastChar = now
+		}
+	}
+}
+
+func (t *textStream) endLine(end time.Time) {
+	text := strings.TrimSpace(string(t.line))
+	t.line = t.line[:0]
+	if text == "" {
+		return
+	}
+	cue := rttCue{Start: t.start, End: end, Text: text}
+	t.cues = append(t.cues, cue)
+	if t.onLine != nil {
+		t.onLine(cue)
+	}
+	recordingLog.Info("💬 Real-time text", "addr", t.addr, "text", text)
+}
+
+// flush ends the line being typed.
+func (t *textStream) flush() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(t.line) > 0 {
+		t.endLine(t.lastChar)
+	}
+}
+
+// take returns the finished lines.
+func (t *textStream) take() []rttCue {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	cues := t.cues
+	t.cues = nil
+	return cues
+}
+
+// writeRTT writes lines received during a recording that started at opened
+// as SRT subtitles.
+func writeRTT(path string, opened time.Time, cues []rttCue) error {
+	var b strings.Builder
+	for i, cue := range cues {
+		start := max(0, cue.Start.Sub(opened))
+		end := max(start+rttMinCue, cue.End.Sub(opened))
+		fmt.Fprintf(&b, "%d\n%s --> %s\n%s\n\n", i+1, srtTimestamp(start), srtTimestamp(end), cue.Text)
+	}
+	return os.WriteFile(path, []byte(b.String()), 0o644)
+}
+
+// srtTimestamp formats an offset as an SRT timestamp, HH:MM:SS,mmm.
+func srtTimestamp(d time.Duration) string {
+	ms := d.Milliseconds()
+	return fmt.Sprintf("%02d:%02d:%02d,%03d", ms/3600000, ms/60000%60, ms/1000%60, ms%1000)
+}
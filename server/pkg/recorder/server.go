@@ -0,0 +1,448 @@
+package recorder
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/netip"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pion/rtp"
+
+	"github.com/fcerini/audio-capture-shared/pkg/pcap"
+	"github.com/fcerini/audio-capture-shared/pkg/stun"
+)
+
+var ingestLog = logger("ingest")
+
+const (
+	readBatch = 32          // Most datagrams taken by one read
+	evictIdle = time.Second // A session must be idle this long to be finalized for -max-open-files
+)
+
+// server receives RTP audio on a UDP listener and records every client into
+// its own recordings.
+type server struct {
+	cfg      *Config
+	listener *net.UDPConn // The first of listeners, which reports are sent from
+	disk     *diskUsage
+	fin      *finalizer
+	cat      *catalog       // nil unless -catalog is set
+	mqtt     *mqttPublisher // nil unless -mqtt is set
+	tracer   *tracer        // nil unless -otlp-endpoint is set
+	mixer    *mixer         // nil unless -mix is set
+	controls *controls
+	access   *accessControl
+
+	listeners  []*net.UDPConn // The RTP port's sockets, one per read loop
+	multitrack *multitrack    // nil unless -multitrack is set
+	receiving  atomic.Int32   // UDP read loops running, for /readyz
+	pcap       *pcap.Writer   // nil unless -debug-pcap is set
+	hooks      *Recorder      // nil unless a Recorder runs the server
+	localAddr  netip.AddrPort
+
+	// The sessions by address, looked up without clientsMutex, which
+	// serializes changing them and guards the maps below
+	clients      *clientTable
+	clientsMutex sync.Mutex
+	playTarget   string           // Streams played live, see playing; guarded by clientsMutex
+	textDropped  map[string]bool  // Addresses warned about sending text without a stream; guarded by clientsMutex
+	offers       map[string]offer // Formats senders announced in a handshake; guarded by clientsMutex
+	byes         map[string]bye   // Sessions ended by their sender's BYE; guarded by clientsMutex
+	metadata     sessionMetas     // Told on -metadata-addr; guarded by clientsMutex
+	rtcp         *net.UDPConn     // The RTCP port, nil if not listened on
+	nat          *natTraversal    // nil without -stun and -punch
+	ice          *iceAgent        // nil without -ice
+	tcp          *tcpIngest       // nil without -tcp
+	srt          *srtIngest       // nil without -srt
+	whip         *whipIngest      // nil without -whip
+	raw          rawIngests       // The -raw ports
+	profiles     profileIngests   // The -profile ports
+	meta         *metadataIngest  // nil without -metadata-addr
+	evicting     sync.WaitGroup   // Sessions finalized for -max-open-files or a BYE, which closeAll waits for
+}
+
+func newServer(cfg *Config, listeners []*net.UDPConn, up *uploader, cat *catalog, mq *mqttPublisher, tr *tracer, pc *pcap.Writer) *server {
+	disk := newDiskUsage(int64(cfg.maxDisk))
+	s := &server{
+		cfg:       cfg,
+		listener:  listeners[0],
+		listeners: listeners,
+		disk:      disk,
+		fin:       &finalizer{cfg: cfg, disk: disk, up: up, cat: cat, mqtt: mq, manifest: &manifest{root: cfg.outDir}},
+		cat:       cat,
+		mqtt:      mq,
+		tracer:    tr,
+		pcap:      pc,
+		localAddr: listeners[0].LocalAddr().(*net.UDPAddr).AddrPort(),
+		controls:  newControls(cfg.mixGain),
+		access:    newAccessControl(cfg),
+		clients:   newClientTable(),
+
+		playTarget:  cfg.play,
+		textDropped: make(map[string]bool),
+		offers:      make(map[string]offer),
+		byes:        make(map[string]bye),
+		metadata:    make(sessionMetas),
+	}
+	if cfg.transcribe != "" {
+		s.fin.tr = newTranscriber(cfg)
+	}
+	if cfg.fingerprint {
+		s.fin.fp = newFingerprinter(cfg, cat)
+	}
+	if cfg.stunServer != "" || len(cfg.punch) > 0 {
+		s.nat = &natTraversal{s: s}
+	}
+	if cfg.ice {
+		s.ice = newICEAgent(s)
+	}
+	if cfg.mix != "" {
+		s.mixer = newMixer(s)
+	}
+	if cfg.multitrack > 0 {
+		s.multitrack = newMultitrack(s)
+	}
+	return s
+}
+
+// serve reads and records the packets arriving on conn until it is closed.
+// On Linux each recvmmsg call takes up to readBatch datagrams.
+func (s *server) serve(conn *net.UDPConn) {
+	s.receiving.Add(1)
+	defer s.receiving.Add(-1)
+	reader, err := newBatchReader(conn, readBatch)
+	if err != nil {
+		ingestLog.Error("Reading from UDP failed", "err", err)
+		return
+	}
+	known := sources{}
+	for {
+		datagrams, err := reader.read()
+		if err != nil {
+			// This error is expected when the listener is closed, so we can exit gracefully.
+			if strings.Contains(err.Error(), "use of closed network connection") {
+				return
+			}
+			ingestLog.Error("Reading from UDP failed", "err", err)
+			continue
+		}
+		for _, d := range datagrams {
+			s.handlePacket(d.b, d.addr, known.get(d.addr))
+		}
+	}
+}
+
+// handlePacket records a datagram from addr, which the read loop knows as
+// src. b is only valid until it returns. Packets of a stream's audio allocate
+// nothing: the samples go into a buffer the client's writer has finished with.
+func (s *server) handlePacket(b []byte, addr netip.AddrPort, src *source) {
+	name := src.name
+	arrival := time.Now()
+	s.pcap.WriteUDP(addr, s.localAddr, b, arrival)
+	// The STUN server's answers come from outside -allow-cidr
+	if stun.IsMessage(b) {
+		s.handleSTUN(b, addr)
+		return
+	}
+	if !s.access.allowed(addr.Addr()) {
+		return
+	}
+
+	if isRTCP(b) {
+		s.handleRTCP(name, addr, b, arrival, src.cfg == nil)
+		return
+	}
+	packetsReceived.Add(1)
+	bytesReceived.Add(int64(len(b)))
+	var packet rtp.Packet
+	if err := packet.Unmarshal(b); err != nil {
+		packetsMalformed.Add(1)
+		ingestLog.Warn("Malformed RTP packet", "addr", name, "err", err)
+		return
+	}
+	if ingestLog.Enabled(context.Background(), slog.LevelDebug) {
+		ingestLog.Debug("RTP packet", "addr", name, "ssrc", packet.SSRC, "pt", packet.PayloadType, "seq", packet.SequenceNumber, "ts", packet.Timestamp, "bytes", len(b))
+	}
+
+	// Real-time text goes with an audio stream and never starts one
+	if s.cfg.t140 && (int(packet.PayloadType) == s.cfg.t140PT || int(packet.PayloadType) == s.cfg.t140RedPT) {
+		if client := s.textClient(addr, name); client != nil {
+			client.text.receive(packet.PayloadType, packet.SequenceNumber, packet.Payload)
+		}
+		return
+	}
+
+	// The payload type, or the format the stream signaled for it, says how
+	// the audio is decoded and, for one of a format of its own such as G.711,
+	// which format the session is recorded in
+	s.signaledFormat(&packet, src)
+	format := s.payloadFormat(packet.PayloadType, src)
+
+	// The session is looked up in the clients map only when the stream starts
+	// or the session the read loop knows of has ended
+	client := src.client
+	if client == nil || client.closed.Load() {
+		if client = s.lookupClient(src, packet.SSRC, packet.PayloadType, format); client == nil {
+			return
+		}
+		src.client = client
+	}
+	client.touch()
+	client.dump.Load().write(b, arrival)
+
+	// Telephone-events carry DTMF digits, not audio
+	dtmf := s.cfg.dtmf && int(packet.PayloadType) == s.cfg.dtmfPT
+	audio := !dtmf && format != nil && format.fits(client.cfg)
+	client.rtp.packet(packet.SequenceNumber, packet.Timestamp, len(b), arrival, audio)
+	if dtmf {
+		client.dtmf.telephoneEvent(packet.Timestamp, packet.Payload, client.queued)
+		return
+	}
+	// Such as comfort noise, or a codec switched to mid-stream
+	if !audio {
+		if !client.otherPT.Swap(true) {
+			client.log.Warn("Dropping packets in another format than the stream is recorded in", "pt", packet.PayloadType)
+		}
+		return
+	}
+
+	// Convert the RTP payload into interleaved samples
+	samples := format.decode(client.buffer(), packet.Payload, client.cfg)
+	if len(samples) == 0 {
+		client.recycle(samples)
+		return
+	}
+	client.rate.packet(packet.SequenceNumber, packet.Timestamp, len(samples)/client.cfg.channels, arrival)
+
+	// The live stream copies the samples as they arrive; with
+	// -reorder-window, the rest of the way takes them in sequence order
+	client.live.publish(liveEvent{audio: liveAudio{time: arrival, timestamp: packet.Timestamp, samples: samples}}, client.cfg.channels)
+	if client.reorder != nil {
+		client.reorder.push(packet.SequenceNumber, packet.Timestamp, samples, arrival)
+		return
+	}
+	s.record(client, packet.Timestamp, samples)
+}
+
+// record hands the samples of a stream to the mix, the multitrack recording
+// and the session's file. The mix and multitrack copy them; the writer
+// goroutine, which they are handed to last, recycles them. Streams recorded
+// in their own format, after a handshake or by their payload type, are left
+// out of the mix and the multitrack recording, which are in the server's.
+func (s *server) record(client *Client, timestamp uint32, samples []int) {
+	if client.cfg != s.cfg {
+		client.write(samples)
+		return
+	}
+	if s.mixer != nil && s.mixer.includes(client.addr) {
+		s.mixer.feed(client.addr, samples)
+	}
+	if s.multitrack != nil {
+		s.multitrack.feed(client.addr, timestamp, samples)
+	}
+	client.write(samples)
+}
+
+// lookupClient returns the client for src, starting a new recording session
+// if this is the first packet from it, of payload type pt decoded as format.
+// It returns nil if the packet should be dropped.
+func (s *server) lookupClient(src *source, ssrc uint32, pt uint8, format *payloadFormat) *Client {
+	addr := src.name
+	// A read loop that doesn't know the session, e.g. after forgetting its
+	// sources, finds it in its shard; only starting one takes clientsMutex
+	if client, ok := s.clients.get(addr); ok {
+		return client
+	}
+	s.clientsMutex.Lock()
+	defer s.clientsMutex.Unlock()
+
+	client, ok := s.clients.get(addr)
+	if ok {
+		return client
+	}
+	// Packets that arrive late after a BYE start no session of their own
+	if b, ok := s.byes[addr]; ok {
+		if b.ssrc == ssrc && time.Since(b.at) < byeLinger {
+			return nil
+		}
+		delete(s.byes, addr)
+	}
+
+	if s.quotaExceeded() {
+		s.disk.rejectOnce(addr)
+		return nil
+	}
+	if reason := s.access.limit(addr, s.clients); reason != "" {
+		s.access.deny(addr, reason)
+		return nil
+	}
+	// The streams of a -profile port are recorded as it says, without
+	// handshakes
+	base := src.config(s.cfg)
+	cfg, reason := base, ""
+	if src.cfg == nil {
+		cfg, reason = s.offered(addr)
+	}
+	if cfg == nil {
+		s.access.deny(addr, reason)
+		return nil
+	}
+	if format == nil {
+		s.access.deny(addr, fmt.Sprintf("payload type %d isn't decoded (map it with -payload-type)", pt))
+		return nil
+	}
+	if own := format.adapt(cfg); own != cfg {
+		// Packets of a format of their own, or signaled, are recorded in it,
+		// as a format declared in a handshake would be
+		if format.fits(base) {
+			own = base
+		}
+		ingestLog.Info("Recording the stream in its own format", "addr", addr, "pt", pt, "format", format.String())
+		cfg = own
+	}
+	if s.cfg.maxOpen > 0 && s.clients.len() >= s.cfg.maxOpen && !s.evictIdlest() {
+		s.access.deny(addr, fmt.Sprintf("-max-open-files %d reached and no recording idle for %s", s.cfg.maxOpen, evictIdle))
+		return nil
+	}
+	s.access.accepted(addr)
+
+	// If the client is new, start a recording for it.
+	ingestLog.Info("✅ New client connected, creating recording", "addr", addr)
+
+	client, err := newClientConfig(s, cfg, addr, ssrc, s.metadataFor(addr, ssrc))
+	if err != nil {
+		ingestLog.Error("Creating recording failed", "addr", addr, "err", err)
+		return nil
+	}
+	client.log.Info("📝 Recording", "file", client.fileName())
+	if cfg.reorderWindow > 0 {
+		client.reorder = newReorderBuffer(client, time.Duration(cfg.reorderWindow), func(timestamp uint32, samples []int) {
+			s.record(client, timestamp, samples)
+		})
+	}
+	s.clients.set(addr, client)
+	if playing(s.playTarget, client) {
+		client.setPlaying(true)
+	}
+	return client
+}
+
+// evictIdlest finalizes the session idle the longest to make room for a new
+// one under -max-open-files, reporting whether one was idle long enough. It
+// is removed at once and finalized in the background, as finalizing waits for
+// the session's queued audio. The caller holds clientsMutex.
+func (s *server) evictIdlest() bool {
+	var idlest *Client
+	s.clients.each(func(_ string, client *Client) bool {
+		if idlest == nil || client.lastSeen.Load() < idlest.lastSeen.Load() {
+			idlest = client
+		}
+		return true
+	})
+	if idlest == nil || idlest.idleFor() < evictIdle {
+		return false
+	}
+	s.clients.delete(idlest.addr)
+	idlest.log.Info("📦 Finalizing idle recording to make room for a new stream", "idle", idlest.idleFor().Round(time.Second), "max_open_files", s.cfg.maxOpen)
+	s.evicting.Add(1)
+	go func() {
+		defer s.evicting.Done()
+		idlest.close()
+	}()
+	return true
+}
+
+// textClient returns the stream that real-time text from addr belongs to:
+// the one from the same address or, as text is often sent from a port of
+// its own, the latest one from the same IP. It returns nil if there is none.
+func (s *server) textClient(addr netip.AddrPort, name string) *Client {
+	if client, ok := s.clients.get(name); ok {
+		return client
+	}
+	s.clientsMutex.Lock()
+	defer s.clientsMutex.Unlock()
+
+	var latest *Client
+	s.clients.each(func(a string, client *Client) bool {
+		host, _, _ := net.SplitHostPort(a)
+		if host == addr.Addr().String() && (latest == nil || client.start.After(latest.start)) {
+			latest = client
+		}
+		return true
+	})
+	if latest == nil && !s.textDropped[name] {
+		s.textDropped[name] = true
+		ingestLog.Warn("Dropping real-time text: no stream from its IP", "addr", name)
+	}
+	return latest
+}
+
+// activeFiles returns the paths of the files currently being written.
+func (s *server) activeFiles() map[string]bool {
+	active := make(map[string]bool, s.clients.len())
+	s.clients.each(func(_ string, client *Client) bool {
+		active[client.fileName()] = true
+		return true
+	})
+	return active
+}
+
+// reapIdle finalizes the recordings of clients that have not sent a packet for
+// longer than the idle timeout, until ctx is done. A client that resumes
+// afterwards starts a new session with a new file.
+func (s *server) reapIdle(ctx context.Context) {
+	interval := min(time.Duration(s.cfg.idleTimeout)/2, time.Second)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		var idle []*Client
+		s.clientsMutex.Lock()
+		s.clients.each(func(_ string, client *Client) bool {
+			if client.idleFor() > time.Duration(s.cfg.idleTimeout) {
+				idle = append(idle, client)
+			}
+			return true
+		})
+		for _, client := range idle {
+			s.clients.delete(client.addr)
+		}
+		s.clientsMutex.Unlock()
+
+		// Finalize outside the map lock so slow disks don't stall packet handling
+		for _, client := range idle {
+			client.log.Info("💤 No packets, finalizing recording", "idle", client.idleFor().Round(time.Second))
+			client.close()
+		}
+	}
+}
+
+// closeAll finalizes the files of every connected client.
+func (s *server) closeAll() {
+	if s.mixer != nil {
+		s.mixer.close()
+	}
+	if s.multitrack != nil {
+		s.multitrack.close()
+	}
+
+	// Lock the map and close all open files and encoders
+	s.clientsMutex.Lock()
+	defer s.clientsMutex.Unlock()
+
+	for _, client := range s.clients.list() {
+		client.close()
+		s.clients.delete(client.addr)
+	}
+	s.evicting.Wait()
+}
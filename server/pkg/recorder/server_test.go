@@ -0,0 +1,98 @@
+package recorder
+
+import (
+	"net/netip"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pion/rtp"
+)
+
+// newTestServer makes a server listening on a free port of localhost, which
+// -port doesn't take, with the flags args, recording into a temporary
+// directory unless they say otherwise. It doesn't read the port itself. Its
+// sessions are closed when the test ends.
+func newTestServer(tb testing.TB, args ...string) *server {
+	tb.Helper()
+	cfg, err := ParseConfig(append([]string{"-listen", "127.0.0.1", "-out-dir", tb.TempDir()}, args...))
+	if err != nil {
+		tb.Fatal(err)
+	}
+	listeners, err := listenRTP(cfg.listen, 0, 1)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	s := newServer(cfg, listeners, nil, nil, nil, nil, nil)
+	tb.Cleanup(func() {
+		s.closeAll()
+		s.fin.wait()
+		for _, l := range listeners {
+			l.Close()
+		}
+	})
+	return s
+}
+
+// BenchmarkHandlePacket measures handling a 20 ms packet of 48 kHz mono
+// L16 of a stream being recorded, by the server's read loop, without the
+// socket read. It allocates nothing once the session started. The writer
+// is slower than that, so the clock stops while it catches up, rather than
+// let the queue fill and the packets be dropped.
+func BenchmarkHandlePacket(b *testing.B) {
+	s := newTestServer(b)
+	src := sources{}.get(netip.MustParseAddrPort("127.0.0.1:40000"))
+	packet := rtp.Packet{
+		Header:  rtp.Header{Version: 2, PayloadType: 96, SSRC: 1},
+		Payload: make([]byte, 48000/50*2),
+	}
+	buf := make([]byte, packet.MarshalSize())
+	next := func() []byte {
+		packet.SequenceNumber++
+		packet.Timestamp += 48000 / 50
+		n, err := packet.MarshalTo(buf)
+		if err != nil {
+			b.Fatal(err)
+		}
+		return buf[:n]
+	}
+	addr := netip.MustParseAddrPort(src.name)
+	s.handlePacket(next(), addr, src) // Starts the session
+
+	b.ReportAllocs()
+	b.SetBytes(int64(len(packet.Payload)))
+	b.ResetTimer()
+	for range b.N {
+		s.handlePacket(next(), addr, src)
+		if c := src.client; len(c.queue) > cap(c.queue)/2 {
+			b.StopTimer()
+			for len(c.queue) > 0 {
+				time.Sleep(time.Millisecond)
+			}
+			b.StartTimer()
+		}
+	}
+}
+
+// BenchmarkWAVWrite measures writing the samples of a 20 ms packet of
+// 48 kHz mono to a 16-bit WAV file.
+func BenchmarkWAVWrite(b *testing.B) {
+	w, err := newWAVWriter(filepath.Join(b.TempDir(), "bench.wav"), 48000, 16, 1, nil)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer w.close()
+	samples := make([]int, 48000/50)
+	for i := range samples {
+		samples[i] = i * 32
+	}
+
+	b.ReportAllocs()
+	b.SetBytes(int64(len(samples) * 2))
+	b.ResetTimer()
+	for range b.N {
+		if err := w.write(samples); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package recorder
+
+import (
+	"math"
+	"time"
+)
+
+// silenceDetector tracks how long a stream has stayed below a peak level
+// threshold, so recordings can be split into per-song or per-utterance files.
+type silenceDetector struct {
+	threshold  int // Peak amplitude below which samples count as silent
+	channels   int
+	sampleRate int
+	frames     int // Consecutive silent frames seen so far
+}
+
+// newSilenceDetector creates a detector for the given threshold in dBFS
+// (e.g. -50) and stream format.
+func newSilenceDetector(thresholdDBFS float64, bitDepth, channels, sampleRate int) *silenceDetector {
+	fullScale := float64(int(1) << (bitDepth - 1))
+	return &silenceDetector{
+		threshold:  int(fullScale * math.Pow(10, thresholdDBFS/20)),
+		channels:   channels,
+		sampleRate: sampleRate,
+	}
+}
+
+// feed analyzes a buffer of interleaved samples and reports whether it was
+// silent. Any sample above the threshold resets the silence run.
+func (d *silenceDetector) feed(samples []int) bool {
+	for _, v := range samples {
+		if v > d.threshold || -v > d.threshold {
+			d.frames = 0
+			return false
+		}
+	}
+	d.frames += len(samples) / d.channels
+	return true
+}
+
+// silentFor returns the length of the current silence run.
+func (d *silenceDetector) silentFor() time.Duration {
+	return time.Duration(d.frames) * time.Second / time.Duration(d.sampleRate)
+}
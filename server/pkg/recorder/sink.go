@@ -0,0 +1,80 @@
+package recorder
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Sink writes the decoded audio of one file segment in a format of its own,
+// registered with RegisterFormat. The server handles everything around it:
+// naming, rotation, the sidecar, the post-recording steps and uploads.
+type Sink interface {
+	// Write writes interleaved samples of the segment's bit depth.
+	Write(samples []int) error
+	// Size returns the size of the file so far, in bytes.
+	Size() int64
+	// Sync makes what was written so far survive a crash, as far as the
+	// format allows.
+	Sync() error
+	// Close finalizes and closes the file.
+	Close() error
+}
+
+// Segment describes the file a Sink is opened for.
+type Segment struct {
+	Path       string // With the extension of the format
+	SampleRate int
+	BitDepth   int
+	Channels   int
+	Title      string    // Describes the stream, for the file's metadata
+	Start      time.Time // When the segment starts
+}
+
+// SinkOpener opens a sink for a segment.
+type SinkOpener func(seg Segment) (Sink, error)
+
+var (
+	formatsMu sync.Mutex
+	formats   = map[string]SinkOpener{} // Registered with RegisterFormat
+)
+
+// RegisterFormat makes a format available to -format under name, which is
+// also the extension of its files. Formats are registered before the
+// Config is made, and can't replace the built-in ones: wav, mka, webm and
+// flac.
+func RegisterFormat(name string, open SinkOpener) {
+	formatsMu.Lock()
+	defer formatsMu.Unlock()
+	formats[name] = open
+}
+
+// registeredFormat returns the opener of a format registered under name.
+func registeredFormat(name string) (SinkOpener, bool) {
+	formatsMu.Lock()
+	defer formatsMu.Unlock()
+	open, ok := formats[name]
+	return open, ok
+}
+
+// registeredFormats returns the names of the registered formats, sorted.
+func registeredFormats() []string {
+	formatsMu.Lock()
+	defer formatsMu.Unlock()
+	names := make([]string, 0, len(formats))
+	for name := range formats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// sinkWriter adapts a Sink to the writers of the built-in formats.
+type sinkWriter struct {
+	Sink
+}
+
+func (w sinkWriter) write(samples []int) error { return w.Write(samples) }
+func (w sinkWriter) size() int64               { return w.Size() }
+func (w sinkWriter) syncHeader() error         { return w.Sync() }
+func (w sinkWriter) close() error              { return w.Close() }
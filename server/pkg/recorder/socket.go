@@ -0,0 +1,187 @@
+package recorder
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"strconv"
+	"time"
+)
+
+const (
+	dropCheckInterval = 5 * time.Second // How often the kernel's drop counter is read
+	datagramSize      = 1600            // Largest datagram read; MTU for RTP is usually around 1500
+	maxSources        = 4096            // Addresses a read loop remembers before it forgets them all
+)
+
+// datagram is a packet taken by a batchReader and the address it came from.
+type datagram struct {
+	b    []byte
+	addr netip.AddrPort
+}
+
+// source is what a read loop remembers of an address packets come from.
+type source struct {
+	name   string  // The address as a string, which clients are known by
+	client *Client // The latest session of its stream
+	linear bool    // Its packets are linear PCM in the stream's format whatever their payload type, as -raw and WHIP make them
+	cfg    *Config // Of the -profile port it streams to; nil for the RTP port's, the server's
+
+	// The format its format extension signaled, for packets of signaledPT
+	signaled   *payloadFormat
+	signaledPT uint8
+	badFormat  bool // An extension it sent couldn't be decoded, which is warned of once
+}
+
+// config returns the configuration of the port src streams to, cfg for the
+// RTP port.
+func (src *source) config(cfg *Config) *Config {
+	if src.cfg != nil {
+		return src.cfg
+	}
+	return cfg
+}
+
+// sources caches what a read loop knows of the addresses packets come from,
+// so the string of an address isn't formatted for every packet and a stream's
+// session is found without taking the server's clientsMutex. Each read loop
+// has its own, and with -readers a stream always arrives on the same loop.
+type sources map[netip.AddrPort]*source
+
+func (ss sources) get(addr netip.AddrPort) *source {
+	if src, ok := ss[addr]; ok {
+		return src
+	}
+	if len(ss) >= maxSources {
+		clear(ss)
+	}
+	src := &source{name: addr.String()}
+	ss[addr] = src
+	return src
+}
+
+// socketStats are the kernel's counters of the UDP socket.
+type socketStats struct {
+	Queued int64 `json:"receive_queue_bytes"` // Waiting to be read
+	Drops  int64 `json:"drops"`               // Dropped because the receive buffer was full
+}
+
+// listenRTP opens the UDP sockets of the RTP port on host (see
+// listenNetwork): one or, for -readers, that many sharing the port with
+// SO_REUSEPORT. The kernel spreads senders over them by a hash of their
+// addresses, so the packets of a stream always arrive on the same socket, in
+// order, and each socket gets a read loop of its own.
+func listenRTP(host string, port, readers int) ([]*net.UDPConn, error) {
+	network, addr := listenNetwork(host, port)
+	if readers <= 1 {
+		pc, err := net.ListenPacket(network, addr)
+		if err != nil {
+			return nil, err
+		}
+		return []*net.UDPConn{pc.(*net.UDPConn)}, nil
+	}
+	lc := net.ListenConfig{Control: reusePort}
+	var conns []*net.UDPConn
+	for i := 0; i < readers; i++ {
+		pc, err := lc.ListenPacket(context.Background(), network, addr)
+		if err != nil {
+			for _, c := range conns {
+				c.Close()
+			}
+			return nil, err
+		}
+		conns = append(conns, pc.(*net.UDPConn))
+	}
+	return conns, nil
+}
+
+// listenNetwork returns the network and address to listen on for host, an
+// IP from -listen. Without one it is every address of both families on one
+// dual-stack socket, whose IPv4 senders arrive as IPv4-mapped addresses and
+// are unmapped by the read loops. The unspecified addresses restrict it to
+// one family: 0.0.0.0 to IPv4, :: to IPv6 (with IPV6_V6ONLY).
+func listenNetwork(host string, port int) (network, addr string) {
+	addr = net.JoinHostPort(host, strconv.Itoa(port))
+	switch host {
+	case "0.0.0.0":
+		return "udp4", addr
+	case "::":
+		return "udp6", addr
+	}
+	return "udp", addr
+}
+
+// listenAddr is how the address listened on is logged, with * for every
+// address of both families.
+func listenAddr(host string, port int) string {
+	if host == "" {
+		host = "*"
+	}
+	return net.JoinHostPort(host, strconv.Itoa(port))
+}
+
+// setReceiveBuffer asks for a receive buffer of size bytes for the socket,
+// warning when the kernel grants less.
+func setReceiveBuffer(conn *net.UDPConn, size int) {
+	if err := conn.SetReadBuffer(size); err != nil {
+		mainLog.Warn("Setting the UDP receive buffer failed", "bytes", size, "err", err)
+		return
+	}
+	granted, err := receiveBuffer(conn)
+	if err != nil {
+		return
+	}
+	if granted < size {
+		mainLog.Warn("Kernel capped the UDP receive buffer, raise net.core.rmem_max to allow more", "requested", size, "granted", granted)
+		return
+	}
+	mainLog.Info("📥 UDP receive buffer", "bytes", granted)
+}
+
+// watchKernelDrops warns when the kernel drops packets because the socket's
+// receive buffer is full, which the read loop never sees, until ctx is
+// done. It returns at once where the counter can't be read.
+func (s *server) watchKernelDrops(ctx context.Context) {
+	last, err := s.socketStats()
+	if err != nil {
+		ingestLog.Debug("Kernel drop counter unavailable", "err", err)
+		return
+	}
+	packetsDroppedKernel.Set(last.Drops)
+
+	ticker := time.NewTicker(dropCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		st, err := s.socketStats()
+		if err != nil {
+			continue
+		}
+		if st.Drops > last.Drops {
+			buffer, _ := receiveBuffer(s.listener)
+			ingestLog.Warn("🕳️  Kernel dropped packets: the UDP receive buffer was full, raise -rcvbuf",
+				"dropped", st.Drops-last.Drops, "total", st.Drops, "rcvbuf", buffer)
+		}
+		packetsDroppedKernel.Set(st.Drops)
+		last = st
+	}
+}
+
+// socketStats adds up the counters of the RTP port's sockets.
+func (s *server) socketStats() (socketStats, error) {
+	var total socketStats
+	for _, conn := range s.listeners {
+		st, err := readSocketStats(conn)
+		if err != nil {
+			return socketStats{}, err
+		}
+		total.Queued += st.Queued
+		total.Drops += st.Drops
+	}
+	return total, nil
+}
@@ -0,0 +1,186 @@
+package recorder
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/netip"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// receiveBuffer returns the size of the socket's receive buffer, which the
+// kernel caps at net.core.rmem_max.
+func receiveBuffer(conn *net.UDPConn) (int, error) {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return 0, err
+	}
+	var size int
+	var serr error
+	if err := raw.Control(func(fd uintptr) {
+		size, serr = syscall.GetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_RCVBUF)
+	}); err != nil {
+		return 0, err
+	}
+	// Linux reports twice what was set, the rest being its bookkeeping
+	return size / 2, serr
+}
+
+// readSocketStats finds the socket in /proc/net/udp and /proc/net/udp6 by
+// its inode and returns the bytes waiting in its receive queue and the
+// packets the kernel dropped since the socket was opened.
+func readSocketStats(conn *net.UDPConn) (socketStats, error) {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return socketStats{}, err
+	}
+	var link string
+	var lerr error
+	if err := raw.Control(func(fd uintptr) {
+		link, lerr = os.Readlink(fmt.Sprintf("/proc/self/fd/%d", fd))
+	}); err != nil {
+		return socketStats{}, err
+	}
+	if lerr != nil {
+		return socketStats{}, lerr
+	}
+	inode := strings.TrimSuffix(strings.TrimPrefix(link, "socket:["), "]")
+
+	for _, table := range []string{"/proc/net/udp", "/proc/net/udp6"} {
+		f, err := os.Open(table)
+		if err != nil {
+			continue
+		}
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			// sl local_address rem_address st tx_queue:rx_queue tr:tm->when retrnsmt uid timeout inode ref pointer drops
+			fields := strings.Fields(scanner.Text())
+			if len(fields) < 13 || fields[9] != inode {
+				continue
+			}
+			f.Close()
+			_, rx, _ := strings.Cut(fields[4], ":")
+			queued, err := strconv.ParseInt(rx, 16, 64)
+			if err != nil {
+				return socketStats{}, fmt.Errorf("parsing %s: %w", table, err)
+			}
+			drops, err := strconv.ParseInt(fields[12], 10, 64)
+			if err != nil {
+				return socketStats{}, fmt.Errorf("parsing %s: %w", table, err)
+			}
+			return socketStats{Queued: queued, Drops: drops}, nil
+		}
+		f.Close()
+	}
+	return socketStats{}, fmt.Errorf("socket %s not in /proc/net/udp", inode)
+}
+
+// reusePort lets several sockets bind the same port, for -readers. Linux
+// spreads the packets over them by a hash of their addresses.
+func reusePort(network, address string, c syscall.RawConn) error {
+	var serr error
+	if err := c.Control(func(fd uintptr) {
+		serr = unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_REUSEPORT, 1)
+	}); err != nil {
+		return err
+	}
+	return serr
+}
+
+// mmsghdr is the kernel's struct mmsghdr: a message and the length received
+// into it.
+type mmsghdr struct {
+	hdr unix.Msghdr
+	len uint32
+}
+
+// batchReader takes up to a batch of datagrams with each recvmmsg call. Its
+// buffers, and the addresses decoded from them, are reused for every batch,
+// so reading allocates nothing.
+type batchReader struct {
+	raw   syscall.RawConn
+	bufs  [][]byte
+	names []unix.RawSockaddrInet6
+	iovs  []unix.Iovec
+	msgs  []mmsghdr
+	got   []datagram
+	recv  func(fd uintptr) bool // Made once, as a closure would escape on every read
+	n     int
+	errno syscall.Errno
+}
+
+func newBatchReader(conn *net.UDPConn, size int) (*batchReader, error) {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+	r := &batchReader{
+		raw:   raw,
+		bufs:  make([][]byte, size),
+		names: make([]unix.RawSockaddrInet6, size),
+		iovs:  make([]unix.Iovec, size),
+		msgs:  make([]mmsghdr, size),
+		got:   make([]datagram, 0, size),
+	}
+	for i := range r.msgs {
+		r.bufs[i] = make([]byte, datagramSize)
+		r.iovs[i].Base = &r.bufs[i][0]
+		r.iovs[i].SetLen(datagramSize)
+		r.msgs[i].hdr.Name = (*byte)(unsafe.Pointer(&r.names[i]))
+		r.msgs[i].hdr.Iov = &r.iovs[i]
+		r.msgs[i].hdr.SetIovlen(1)
+	}
+	r.recv = func(fd uintptr) bool {
+		for i := range r.msgs {
+			r.msgs[i].hdr.Namelen = uint32(unsafe.Sizeof(r.names[i]))
+		}
+		n, _, errno := unix.Syscall6(unix.SYS_RECVMMSG, fd, uintptr(unsafe.Pointer(&r.msgs[0])), uintptr(len(r.msgs)), 0, 0, 0)
+		if errno == unix.EAGAIN {
+			return false // Wait until the socket is readable
+		}
+		r.n, r.errno = int(n), errno
+		return true
+	}
+	return r, nil
+}
+
+// read waits for datagrams and returns those available, up to the batch
+// size. They are only valid until the next read.
+func (r *batchReader) read() ([]datagram, error) {
+	if err := r.raw.Read(r.recv); err != nil {
+		return nil, err
+	}
+	if r.errno != 0 {
+		return nil, os.NewSyscallError("recvmmsg", r.errno)
+	}
+	r.got = r.got[:0]
+	for i := range r.msgs[:r.n] {
+		r.got = append(r.got, datagram{b: r.bufs[i][:r.msgs[i].len], addr: sockaddrPort(&r.names[i])})
+	}
+	return r.got, nil
+}
+
+// sockaddrPort decodes the sender's address from a sockaddr_in or
+// sockaddr_in6, IPv4-mapped addresses as IPv4.
+func sockaddrPort(sa *unix.RawSockaddrInet6) netip.AddrPort {
+	switch sa.Family {
+	case unix.AF_INET:
+		sa4 := (*unix.RawSockaddrInet4)(unsafe.Pointer(sa))
+		return netip.AddrPortFrom(netip.AddrFrom4(sa4.Addr), ntohs(sa4.Port))
+	case unix.AF_INET6:
+		return netip.AddrPortFrom(netip.AddrFrom16(sa.Addr).Unmap(), ntohs(sa.Port))
+	}
+	return netip.AddrPort{}
+}
+
+// ntohs reads a port stored in network byte order.
+func ntohs(port uint16) uint16 {
+	b := (*[2]byte)(unsafe.Pointer(&port))
+	return uint16(b[0])<<8 | uint16(b[1])
+}
@@ -0,0 +1,45 @@
+//go:build !linux
+
+package recorder
+
+import (
+	"errors"
+	"net"
+	"net/netip"
+	"syscall"
+)
+
+var errNoSocketStats = errors.New("socket statistics are only available on Linux")
+
+func receiveBuffer(conn *net.UDPConn) (int, error) { return 0, errNoSocketStats }
+
+func readSocketStats(conn *net.UDPConn) (socketStats, error) { return socketStats{}, errNoSocketStats }
+
+// reusePort is only supported on Linux, where the kernel balances the
+// packets of a shared port over its sockets.
+func reusePort(network, address string, c syscall.RawConn) error {
+	return errors.New("-readers above 1 needs SO_REUSEPORT, which is only supported on Linux")
+}
+
+// batchReader reads one datagram at a time where recvmmsg isn't available,
+// reusing its buffer.
+type batchReader struct {
+	conn *net.UDPConn
+	buf  []byte
+	got  []datagram
+}
+
+func newBatchReader(conn *net.UDPConn, size int) (*batchReader, error) {
+	return &batchReader{conn: conn, buf: make([]byte, datagramSize), got: make([]datagram, 1)}, nil
+}
+
+// read waits for a datagram and returns it. It is only valid until the next
+// read.
+func (r *batchReader) read() ([]datagram, error) {
+	n, addr, err := r.conn.ReadFromUDPAddrPort(r.buf)
+	if err != nil {
+		return nil, err
+	}
+	r.got[0] = datagram{b: r.buf[:n], addr: netip.AddrPortFrom(addr.Addr().Unmap(), addr.Port())}
+	return r.got, nil
+}
@@ -0,0 +1,119 @@
+package recorder
+
+import (
+	"errors"
+	"net"
+	"net/netip"
+	"sync"
+
+	"github.com/fcerini/audio-capture-shared/pkg/srt"
+)
+
+const tsSyncByte = 0x47 // Starts every MPEG-TS packet
+
+// srtIngest accepts RTP over SRT on its own UDP port with -srt, for hardware
+// encoders and clients streaming with -transport=srt over lossy links: SRT
+// sends the packets lost on the way again, up to its latency. Every SRT
+// message is one RTP packet and goes through the same pipeline as a datagram
+// from the connection's address; the reports and handshake answers to the
+// sender go back on its connection.
+type srtIngest struct {
+	s  *server
+	ln *srt.Listener
+
+	mu      sync.Mutex
+	senders map[netip.AddrPort]*srt.Conn // guarded by mu
+	wg      sync.WaitGroup               // The connections' read loops
+}
+
+// listenSRT opens the SRT listener on addr, a host:port.
+func listenSRT(s *server, addr string) (*srtIngest, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	network, _ := listenNetwork(host, 0)
+	t := &srtIngest{s: s, senders: make(map[netip.AddrPort]*srt.Conn)}
+	t.ln, err = srt.Listen(network, net.JoinHostPort(host, port), srt.Config{
+		Allow: func(remote netip.AddrPort, _ string) bool { return s.access.allowed(remote.Addr().Unmap()) },
+	})
+	if err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// serve accepts connections until the listener is closed, then waits for
+// their read loops, which the listener's closing ends.
+func (t *srtIngest) serve() {
+	defer t.wg.Wait()
+	for {
+		conn, err := t.ln.Accept()
+		if err != nil {
+			return
+		}
+		addr := conn.RemoteAddr().(*net.UDPAddr).AddrPort()
+		addr = netip.AddrPortFrom(addr.Addr().Unmap(), addr.Port())
+		t.mu.Lock()
+		t.senders[addr] = conn
+		t.mu.Unlock()
+		t.wg.Add(1)
+		go t.read(conn, addr)
+	}
+}
+
+// read handles the messages of one connection until it is closed.
+func (t *srtIngest) read(conn *srt.Conn, addr netip.AddrPort) {
+	defer t.wg.Done()
+	defer func() {
+		t.mu.Lock()
+		if t.senders[addr] == conn {
+			delete(t.senders, addr)
+		}
+		t.mu.Unlock()
+		conn.Close()
+	}()
+	log := ingestLog.With("addr", addr)
+	if id := conn.StreamID(); id != "" {
+		log = log.With("stream_id", id)
+	}
+	log.Info("📡 Sender connected over SRT", "latency", conn.Latency().String())
+	src := &source{name: addr.String()}
+	buf := make([]byte, 1<<16)
+	for {
+		n, err := conn.Read(buf)
+		switch {
+		case errors.Is(err, srt.ErrClosed):
+			log.Info("📡 Sender disconnected from SRT")
+			return
+		case errors.Is(err, srt.ErrTimeout):
+			log.Warn("📡 SRT connection lost", "err", err)
+			return
+		case err != nil:
+			return
+		}
+		if n > 0 && buf[0] == tsSyncByte && n%188 == 0 {
+			log.Warn("Closing an SRT connection carrying MPEG-TS, which isn't supported; send RTP over SRT instead")
+			return
+		}
+		if n > 0 {
+			t.s.handlePacket(buf[:n], addr, src)
+		}
+	}
+}
+
+// write sends pkt to the sender connected from addr, reporting whether
+// there is one.
+func (t *srtIngest) write(pkt []byte, addr netip.AddrPort) (bool, error) {
+	if t == nil {
+		return false, nil
+	}
+	t.mu.Lock()
+	conn, ok := t.senders[addr]
+	t.mu.Unlock()
+	if !ok {
+		return false, nil
+	}
+	_, err := conn.Write(pkt)
+	return true, err
+}
@@ -0,0 +1,257 @@
+package recorder
+
+import (
+	"context"
+	"encoding/json"
+	"expvar"
+	"math"
+	"net/http"
+	"sort"
+	"time"
+)
+
+var httpLog = logger("http")
+
+// httpShutdownTimeout is how long the HTTP servers wait for running requests
+// when the server shuts down.
+const httpShutdownTimeout = 5 * time.Second
+
+type clientStats struct {
+	Addr    string       `json:"addr"`
+	Session string       `json:"session"`
+	File    string       `json:"file"`
+	Part    int          `json:"part"`
+	Bytes   int64        `json:"bytes"`
+	Dropped int64        `json:"dropped"`
+	Start   time.Time    `json:"start"`
+	Level   *level       `json:"level,omitempty"` // Latest second of audio
+	Network networkStats `json:"network"`
+}
+
+// streamLevel is the level of one stream, as sent on /levels.
+type streamLevel struct {
+	Addr    string `json:"addr"`
+	Session string `json:"session"`
+	*level
+}
+
+type diskStats struct {
+	UsedBytes  int64  `json:"used_bytes"`
+	QuotaBytes int64  `json:"quota_bytes,omitempty"`
+	Policy     string `json:"policy,omitempty"`
+}
+
+type serverStats struct {
+	Clients []clientStats `json:"clients"`
+	Disk    diskStats     `json:"disk"`
+	Denied  int64         `json:"denied_packets"` // Dropped by -allow-cidr and the client limits
+}
+
+// stats returns a snapshot of the server state.
+func (s *server) stats() serverStats {
+	st := serverStats{
+		Clients: []clientStats{},
+		Disk:    diskStats{UsedBytes: s.disk.get()},
+		Denied:  s.access.denied.Load(),
+	}
+	if s.disk.limit > 0 {
+		st.Disk.QuotaBytes = s.disk.limit
+		st.Disk.Policy = s.cfg.quotaPolicy
+	}
+
+	s.clients.each(func(addr string, c *Client) bool {
+		part, size := c.segment()
+		st.Clients = append(st.Clients, clientStats{
+			Addr:    addr,
+			Session: c.session,
+			File:    c.fileName(),
+			Part:    part,
+			Bytes:   size,
+			Dropped: c.dropped.Load(),
+			Start:   c.start,
+			Level:   c.meter.current(),
+			Network: c.rtp.stats(),
+		})
+		return true
+	})
+	return st
+}
+
+// logStats logs a summary of every stream each -log-stats, for deployments
+// that only keep the log: the packet rate and bitrate since the last summary,
+// and the loss, jitter and size of the current file.
+func (s *server) logStats(ctx context.Context) {
+	type sample struct {
+		at      time.Time
+		packets int64
+		bytes   int64
+	}
+	last := make(map[*Client]sample)
+	ticker := time.NewTicker(time.Duration(s.cfg.logStats))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		clients := s.clients.list()
+		sort.Slice(clients, func(i, j int) bool { return clients[i].addr < clients[j].addr })
+
+		now := time.Now()
+		seen := make(map[*Client]sample, len(clients))
+		for _, c := range clients {
+			net := c.rtp.stats()
+			prev, ok := last[c]
+			if !ok {
+				prev = sample{at: c.start}
+			}
+			seen[c] = sample{at: now, packets: net.Received, bytes: net.Bytes}
+			secs := now.Sub(prev.at).Seconds()
+			if secs <= 0 {
+				continue
+			}
+			_, size := c.segment()
+			c.log.Info("📊 Stream stats",
+				"pps", round1(float64(net.Received-prev.packets)/secs),
+				"kbps", round1(float64(net.Bytes-prev.bytes)*8/1000/secs),
+				"loss_percent", round1(net.LossPercent),
+				"jitter_ms", round1(net.JitterMS),
+				"file_bytes", size,
+				"dropped", c.dropped.Load())
+		}
+		last = seen // Forgets the clients that are gone
+	}
+}
+
+// round1 rounds to one decimal, which is all a summary needs.
+func round1(x float64) float64 {
+	return math.Round(x*10) / 10
+}
+
+// levels returns the latest level of every stream that has one.
+func (s *server) levels() []streamLevel {
+	out := []streamLevel{}
+	s.clients.each(func(addr string, c *Client) bool {
+		if l := c.meter.current(); l != nil {
+			out = append(out, streamLevel{Addr: addr, Session: c.session, level: l})
+		}
+		return true
+	})
+	sort.Slice(out, func(i, j int) bool { return out[i].Addr < out[j].Addr })
+	return out
+}
+
+// handleLevels serves the stream levels on GET /levels, or once a second
+// over a WebSocket when the request asks for an upgrade.
+func (s *server) handleLevels(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !isWebSocket(r) {
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(s.levels()); err != nil {
+			httpLog.Error("Encoding levels failed", "err", err)
+		}
+		return
+	}
+
+	ws, err := upgradeWebSocket(w, r)
+	if err != nil {
+		return
+	}
+	defer ws.close()
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		msg, err := json.Marshal(s.levels())
+		if err != nil {
+			httpLog.Error("Encoding levels failed", "err", err)
+			return
+		}
+		if ws.send(msg) != nil {
+			return
+		}
+		select {
+		case <-ticker.C:
+		case <-ws.closed:
+			return
+		}
+	}
+}
+
+// serveStats serves the server statistics as JSON on GET /stats, along with
+// the dashboard and the other HTTP endpoints, all behind API_TOKEN if set,
+// until ctx is done.
+func (s *server) serveStats(ctx context.Context, addr string) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/stats", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(s.stats()); err != nil {
+			httpLog.Error("Encoding stats failed", "err", err)
+		}
+	})
+
+	mux.HandleFunc("/", handleDashboard)
+	mux.HandleFunc(healthPath, handleHealth)
+	mux.HandleFunc(readyPath, s.handleReady)
+	mux.Handle("/debug/vars", expvar.Handler())
+	mux.HandleFunc("/files", s.handleFiles)
+	mux.HandleFunc("/files/", s.handleFiles)
+	mux.HandleFunc("/recordings/", s.handleDownload)
+	mux.HandleFunc("/levels", s.handleLevels)
+	mux.HandleFunc("/api/", s.handleAPI)
+	mux.HandleFunc("/play", s.handlePlay)
+	mux.HandleFunc("/controls", s.controls.handleControls)
+	if s.ice != nil {
+		mux.HandleFunc("/ice", s.ice.handleICE)
+	}
+	if s.whip != nil {
+		mux.HandleFunc(whipPath, s.whip.handleWHIP)
+		mux.HandleFunc(whipPath+"/", s.whip.handleWHIP)
+	}
+	if s.cat != nil {
+		s.cat.handleCatalog(mux)
+	}
+
+	hs := &http.Server{Addr: addr, Handler: requireToken(mux), TLSConfig: s.cfg.tls}
+	scheme := "http"
+	if s.cfg.tls != nil {
+		scheme = "https"
+	}
+	httpLog.Info("📊 Serving the dashboard and stats", "url", scheme+"://"+addr+"/")
+	if err := serveHTTP(ctx, hs); err != nil {
+		httpLog.Error("Stats server failed", "err", err)
+	}
+}
+
+// serveHTTP serves hs, over TLS if it has a TLSConfig, until ctx is done.
+// Requests still running then, such as live playback, get
+// httpShutdownTimeout to finish before their connections are closed.
+func serveHTTP(ctx context.Context, hs *http.Server) error {
+	errc := make(chan error, 1)
+	go func() {
+		if hs.TLSConfig != nil {
+			errc <- hs.ListenAndServeTLS("", "") // The certificate comes from TLSConfig
+		} else {
+			errc <- hs.ListenAndServe()
+		}
+	}()
+	select {
+	case err := <-errc:
+		return err
+	case <-ctx.Done():
+	}
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), httpShutdownTimeout)
+	defer cancel()
+	if err := hs.Shutdown(shutdownCtx); err != nil {
+		hs.Close()
+	}
+	return nil
+}
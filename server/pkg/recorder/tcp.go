@@ -0,0 +1,183 @@
+package recorder
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	maxTCPSenders   = 1024        // Connections open at once; more are refused
+	tcpWriteTimeout = time.Second // For a report to a sender over TCP, so a stalled one holds nothing up
+)
+
+// tcpIngest accepts RTP over TCP on the RTP port with -tcp, for senders
+// behind firewalls that block UDP. Every packet is framed by its length in
+// two bytes, as in RFC 4571, both ways, and goes through the same pipeline
+// as a datagram from the connection's address. The reports, handshake
+// answers and STUN responses to the sender go back on its connection.
+type tcpIngest struct {
+	s  *server
+	ln net.Listener
+
+	mu      sync.Mutex
+	senders map[netip.AddrPort]*tcpSender // guarded by mu
+	wg      sync.WaitGroup                // The connections' read loops
+}
+
+// tcpSender is one connection.
+type tcpSender struct {
+	conn net.Conn
+	wmu  sync.Mutex // Writes of frames don't interleave
+}
+
+// listenTCP opens the TCP listener of the RTP port on host, of the family
+// listenNetwork picks for it.
+func listenTCP(host string, port int) (net.Listener, error) {
+	network, addr := listenNetwork(host, port)
+	return net.Listen(strings.Replace(network, "udp", "tcp", 1), addr)
+}
+
+func newTCPIngest(s *server, ln net.Listener) *tcpIngest {
+	return &tcpIngest{s: s, ln: ln, senders: make(map[netip.AddrPort]*tcpSender)}
+}
+
+// serve accepts connections until the listener is closed, then closes them
+// and waits for their read loops.
+func (t *tcpIngest) serve() {
+	defer t.wg.Wait()
+	defer t.closeAll()
+	for {
+		conn, err := t.ln.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			ingestLog.Warn("Accepting a TCP connection failed", "err", err)
+			time.Sleep(100 * time.Millisecond) // Out of descriptors, most likely
+			continue
+		}
+		addr := conn.RemoteAddr().(*net.TCPAddr).AddrPort()
+		addr = netip.AddrPortFrom(addr.Addr().Unmap(), addr.Port())
+		if !t.s.access.allowed(addr.Addr()) {
+			conn.Close()
+			continue
+		}
+		t.mu.Lock()
+		if len(t.senders) >= maxTCPSenders {
+			t.mu.Unlock()
+			ingestLog.Warn("🚫 Refused a TCP connection, too many are open", "addr", addr, "max", maxTCPSenders)
+			conn.Close()
+			continue
+		}
+		sender := &tcpSender{conn: conn}
+		t.senders[addr] = sender
+		t.mu.Unlock()
+		t.wg.Add(1)
+		go t.read(sender, addr)
+	}
+}
+
+// read handles the packets of one connection until it is closed.
+func (t *tcpIngest) read(sender *tcpSender, addr netip.AddrPort) {
+	defer t.wg.Done()
+	defer func() {
+		t.mu.Lock()
+		delete(t.senders, addr)
+		t.mu.Unlock()
+		sender.conn.Close()
+	}()
+	if tc, ok := sender.conn.(*net.TCPConn); ok {
+		tc.SetNoDelay(true)
+	}
+	ingestLog.Info("🔌 Sender connected over TCP", "addr", addr)
+	src := &source{name: addr.String()}
+	r := bufio.NewReader(sender.conn)
+	buf := make([]byte, 1<<16)
+	for {
+		if _, err := io.ReadFull(r, buf[:2]); err != nil {
+			t.closed(addr, err)
+			return
+		}
+		n := int(binary.BigEndian.Uint16(buf))
+		if _, err := io.ReadFull(r, buf[:n]); err != nil {
+			t.closed(addr, err)
+			return
+		}
+		if n > 0 {
+			t.s.handlePacket(buf[:n], addr, src)
+		}
+	}
+}
+
+// closed logs how the connection from addr ended. Its session is finalized
+// after -idle-timeout like any other, so a sender that reconnects at once
+// goes on with a new session.
+func (t *tcpIngest) closed(addr netip.AddrPort, err error) {
+	switch {
+	case errors.Is(err, io.EOF):
+		ingestLog.Info("🔌 Sender disconnected from TCP", "addr", addr)
+	case errors.Is(err, net.ErrClosed):
+	default:
+		ingestLog.Warn("🔌 TCP connection lost", "addr", addr, "err", err)
+	}
+}
+
+// write sends pkt, framed, to the sender connected from addr, reporting
+// whether there is one.
+func (t *tcpIngest) write(pkt []byte, addr netip.AddrPort) (bool, error) {
+	if t == nil {
+		return false, nil
+	}
+	t.mu.Lock()
+	sender, ok := t.senders[addr]
+	t.mu.Unlock()
+	if !ok {
+		return false, nil
+	}
+	if len(pkt) > 0xffff {
+		return true, errors.New("packet too large for RFC 4571 framing")
+	}
+	frame := binary.BigEndian.AppendUint16(make([]byte, 0, 2+len(pkt)), uint16(len(pkt)))
+	frame = append(frame, pkt...)
+	sender.wmu.Lock()
+	defer sender.wmu.Unlock()
+	sender.conn.SetWriteDeadline(time.Now().Add(tcpWriteTimeout))
+	_, err := sender.conn.Write(frame)
+	return true, err
+}
+
+// closeAll closes every connection, ending their read loops.
+func (t *tcpIngest) closeAll() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, sender := range t.senders {
+		sender.conn.Close()
+	}
+}
+
+// reply sends pkt to addr from the RTP port: on its connection for a sender
+// over TCP or SRT, from the -profile port it streams to, or as a datagram
+// otherwise.
+func (s *server) reply(pkt []byte, addr netip.AddrPort) error {
+	if ok, err := s.tcp.write(pkt, addr); ok {
+		return err
+	}
+	if ok, err := s.srt.write(pkt, addr); ok {
+		return err
+	}
+	if ok, err := s.profiles.write(pkt, addr); ok {
+		return err
+	}
+	if s.whip.write(addr) || s.raw.has(addr) {
+		return nil
+	}
+	_, err := s.listener.WriteToUDPAddrPort(pkt, addr)
+	return err
+}
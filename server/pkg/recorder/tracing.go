@@ -0,0 +1,368 @@
+package recorder
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var tracingLog = logger("tracing")
+
+const (
+	traceQueue     = 2048            // Finished spans held until the next export
+	traceBatch     = 512             // Most spans exported in one request
+	traceInterval  = 5 * time.Second // How often spans are exported
+	slowWrite      = 100 * time.Millisecond
+	traceServiceID = "audio-capture-server"
+)
+
+// tracer exports spans of the recording pipeline to an OpenTelemetry
+// collector over OTLP/HTTP with JSON encoding. Spans are exported in batches
+// in the background, and dropped if the collector can't keep up, so tracing
+// never holds up recording. A nil tracer traces nothing.
+type tracer struct {
+	endpoint string // The /v1/traces URL
+	headers  map[string]string
+	service  string
+	client   *http.Client
+
+	queue   chan *span
+	stop    chan struct{}
+	done    chan struct{}
+	mu      sync.Mutex
+	dropped int // Guarded by mu
+	failing bool
+}
+
+// newTracer checks -otlp-endpoint. Headers, e.g. for authentication, come
+// from OTEL_EXPORTER_OTLP_HEADERS and the service name from
+// OTEL_SERVICE_NAME, as for other OpenTelemetry exporters.
+func newTracer(cfg *Config) (*tracer, error) {
+	u, err := url.Parse(cfg.otlpEndpoint)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid -otlp-endpoint %q: use e.g. http://localhost:4318", cfg.otlpEndpoint)
+	}
+	if !strings.HasSuffix(u.Path, "/v1/traces") {
+		u.Path = strings.TrimSuffix(u.Path, "/") + "/v1/traces"
+	}
+	t := &tracer{
+		endpoint: u.String(),
+		headers:  make(map[string]string),
+		service:  traceServiceID,
+		client:   &http.Client{Timeout: 10 * time.Second},
+		queue:    make(chan *span, traceQueue),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	if name := os.Getenv("OTEL_SERVICE_NAME"); name != "" {
+		t.service = name
+	}
+	for _, h := range strings.Split(os.Getenv("OTEL_EXPORTER_OTLP_HEADERS"), ",") {
+		if k, v, ok := strings.Cut(h, "="); ok {
+			v, _ = url.QueryUnescape(strings.TrimSpace(v))
+			t.headers[strings.TrimSpace(k)] = v
+		}
+	}
+	return t, nil
+}
+
+// span is one timed operation of a trace. All methods are safe on a nil
+// span, which records nothing.
+type span struct {
+	t       *tracer
+	traceID [16]byte
+	id      [8]byte
+	parent  [8]byte // Zero for the root of a trace
+	name    string
+	start   time.Time
+
+	mu     sync.Mutex
+	end    time.Time
+	attrs  map[string]any
+	events []spanEvent
+	err    error
+}
+
+type spanEvent struct {
+	time  time.Time
+	name  string
+	attrs map[string]any
+}
+
+// start begins the root span of a new trace.
+func (t *tracer) start(name string) *span {
+	if t == nil {
+		return nil
+	}
+	s := &span{t: t, name: name, start: time.Now()}
+	rand.Read(s.traceID[:])
+	rand.Read(s.id[:])
+	return s
+}
+
+// child begins a span within s.
+func (s *span) child(name string) *span {
+	if s == nil {
+		return nil
+	}
+	c := &span{t: s.t, traceID: s.traceID, parent: s.id, name: name, start: time.Now()}
+	rand.Read(c.id[:])
+	return c
+}
+
+// set adds an attribute: a string, bool, integer, float or time.Duration,
+// which is recorded in milliseconds.
+func (s *span) set(key string, value any) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.attrs == nil {
+		s.attrs = make(map[string]any)
+	}
+	s.attrs[key] = value
+}
+
+// event records that something happened during the span, with attributes
+// given as key and value pairs.
+func (s *span) event(name string, kv ...any) {
+	if s == nil {
+		return
+	}
+	ev := spanEvent{time: time.Now(), name: name, attrs: make(map[string]any)}
+	for i := 0; i+1 < len(kv); i += 2 {
+		ev.attrs[fmt.Sprint(kv[i])] = kv[i+1]
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.events = append(s.events, ev)
+}
+
+// fail marks the span as failed, if err is not nil.
+func (s *span) fail(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.err = err
+}
+
+// finish ends the span and queues it for export. Only the first call counts.
+func (s *span) finish() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	ended := !s.end.IsZero()
+	if !ended {
+		s.end = time.Now()
+	}
+	s.mu.Unlock()
+	if ended {
+		return
+	}
+	select {
+	case s.t.queue <- s:
+	default:
+		s.t.mu.Lock()
+		s.t.dropped++
+		n := s.t.dropped
+		s.t.mu.Unlock()
+		if n == 1 || n%1000 == 0 {
+			tracingLog.Warn("Queue is full, dropping spans", "dropped", n)
+		}
+	}
+}
+
+// run exports finished spans until the tracer is closed.
+func (t *tracer) run() {
+	defer close(t.done)
+	ticker := time.NewTicker(traceInterval)
+	defer ticker.Stop()
+	var batch []*span
+	for {
+		select {
+		case s := <-t.queue:
+			if batch = append(batch, s); len(batch) >= traceBatch {
+				t.export(batch)
+				batch = nil
+			}
+		case <-ticker.C:
+			if len(batch) > 0 {
+				t.export(batch)
+				batch = nil
+			}
+		case <-t.stop:
+			for {
+				select {
+				case s := <-t.queue:
+					batch = append(batch, s)
+				default:
+					for len(batch) > 0 {
+						n := min(len(batch), traceBatch)
+						t.export(batch[:n])
+						batch = batch[n:]
+					}
+					return
+				}
+			}
+		}
+	}
+}
+
+// export sends spans to the collector. A batch that fails is dropped; the
+// failure is logged once until an export succeeds again.
+func (t *tracer) export(batch []*span) {
+	spans := make([]otlpSpan, 0, len(batch))
+	for _, s := range batch {
+		spans = append(spans, s.otlp())
+	}
+	body, err := json.Marshal(map[string]any{
+		"resourceSpans": []any{map[string]any{
+			"resource": map[string]any{"attributes": otlpAttributes(map[string]any{"service.name": t.service})},
+			"scopeSpans": []any{map[string]any{
+				"scope": map[string]any{"name": traceServiceID},
+				"spans": spans,
+			}},
+		}},
+	})
+	if err != nil {
+		tracingLog.Error("Encoding spans failed", "err", err)
+		return
+	}
+	req, err := http.NewRequest(http.MethodPost, t.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range t.headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := t.client.Do(req)
+	if err == nil {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		resp.Body.Close()
+		if resp.StatusCode/100 != 2 {
+			err = &statusError{code: resp.StatusCode, body: strings.TrimSpace(string(msg))}
+		}
+	}
+	if err != nil {
+		if !t.failing {
+			tracingLog.Warn("Exporting spans failed", "spans", len(batch), "endpoint", t.endpoint, "err", err)
+		}
+		t.failing = true
+		return
+	}
+	t.failing = false
+}
+
+// close exports what is queued, waiting at most a few seconds for an
+// unreachable collector.
+func (t *tracer) close() {
+	if t == nil {
+		return
+	}
+	close(t.stop)
+	select {
+	case <-t.done:
+	case <-time.After(5 * time.Second):
+	}
+}
+
+// otlpSpan is a span in the OTLP JSON encoding, where IDs are hex and
+// 64-bit integers are strings.
+type otlpSpan struct {
+	TraceID      string          `json:"traceId"`
+	SpanID       string          `json:"spanId"`
+	ParentSpanID string          `json:"parentSpanId,omitempty"`
+	Name         string          `json:"name"`
+	Kind         int             `json:"kind"`
+	Start        string          `json:"startTimeUnixNano"`
+	End          string          `json:"endTimeUnixNano"`
+	Attributes   []otlpKeyValue  `json:"attributes,omitempty"`
+	Events       []otlpSpanEvent `json:"events,omitempty"`
+	Status       *otlpStatus     `json:"status,omitempty"`
+}
+
+type otlpSpanEvent struct {
+	Time       string         `json:"timeUnixNano"`
+	Name       string         `json:"name"`
+	Attributes []otlpKeyValue `json:"attributes,omitempty"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code"` // 2 = error
+	Message string `json:"message,omitempty"`
+}
+
+type otlpKeyValue struct {
+	Key   string         `json:"key"`
+	Value map[string]any `json:"value"`
+}
+
+func (s *span) otlp() otlpSpan {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	o := otlpSpan{
+		TraceID:    hex.EncodeToString(s.traceID[:]),
+		SpanID:     hex.EncodeToString(s.id[:]),
+		Name:       s.name,
+		Kind:       1, // Internal
+		Start:      strconv.FormatInt(s.start.UnixNano(), 10),
+		End:        strconv.FormatInt(s.end.UnixNano(), 10),
+		Attributes: otlpAttributes(s.attrs),
+	}
+	if s.parent != [8]byte{} {
+		o.ParentSpanID = hex.EncodeToString(s.parent[:])
+	}
+	for _, ev := range s.events {
+		o.Events = append(o.Events, otlpSpanEvent{
+			Time:       strconv.FormatInt(ev.time.UnixNano(), 10),
+			Name:       ev.name,
+			Attributes: otlpAttributes(ev.attrs),
+		})
+	}
+	if s.err != nil {
+		o.Status = &otlpStatus{Code: 2, Message: s.err.Error()}
+	}
+	return o
+}
+
+func otlpAttributes(attrs map[string]any) []otlpKeyValue {
+	var out []otlpKeyValue
+	for k, v := range attrs {
+		var value map[string]any
+		switch v := v.(type) {
+		case string:
+			value = map[string]any{"stringValue": v}
+		case bool:
+			value = map[string]any{"boolValue": v}
+		case int:
+			value = map[string]any{"intValue": strconv.Itoa(v)}
+		case int64:
+			value = map[string]any{"intValue": strconv.FormatInt(v, 10)}
+		case uint32:
+			value = map[string]any{"intValue": strconv.FormatUint(uint64(v), 10)}
+		case float64:
+			value = map[string]any{"doubleValue": v}
+		case time.Duration:
+			value = map[string]any{"doubleValue": float64(v) / float64(time.Millisecond)}
+		default:
+			value = map[string]any{"stringValue": fmt.Sprint(v)}
+		}
+		out = append(out, otlpKeyValue{Key: k, Value: value})
+	}
+	return out
+}
@@ -0,0 +1,83 @@
+package recorder
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// downloadFormat is a format recordings can be converted to on download.
+type downloadFormat struct {
+	ext         string
+	contentType string
+	args        []string // ffmpeg output options
+}
+
+var downloadFormats = map[string]downloadFormat{
+	"mp3":  {".mp3", "audio/mpeg", []string{"-c:a", "libmp3lame", "-q:a", "2", "-f", "mp3"}},
+	"flac": {".flac", "audio/flac", []string{"-c:a", "flac", "-f", "flac"}},
+	"opus": {".opus", "audio/ogg", []string{"-c:a", "libopus", "-f", "ogg"}},
+}
+
+// serveTranscoded streams a recording converted to the given format by
+// ffmpeg, without writing the converted copy to disk.
+func (s *server) serveTranscoded(w http.ResponseWriter, r *http.Request, path, format string) {
+	df, ok := downloadFormats[format]
+	if !ok {
+		http.Error(w, fmt.Sprintf("unsupported format %q: use mp3, flac or opus", format), http.StatusBadRequest)
+		return
+	}
+	if !isRecordingFile(path) {
+		http.Error(w, "only recordings can be converted", http.StatusBadRequest)
+		return
+	}
+
+	args := []string{"-hide_banner", "-loglevel", "error", "-nostdin", "-i", path, "-vn", "-map_metadata", "0"}
+	args = append(args, df.args...)
+	if format == "opus" {
+		args = append(args, "-b:a", s.cfg.bitrate)
+	}
+	// Stopped when the client goes away
+	cmd := exec.CommandContext(r.Context(), "ffmpeg", append(args, "pipe:1")...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := cmd.Start(); err != nil {
+		http.Error(w, fmt.Sprintf("failed to start ffmpeg: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	// Wait for the first output, so a failure can still be reported with a
+	// status code
+	buf := make([]byte, 32*1024)
+	n, readErr := io.ReadFull(stdout, buf)
+	if n == 0 {
+		err := cmd.Wait()
+		if err == nil {
+			err = readErr
+		}
+		httpLog.Warn("Converting recording failed", "file", path, "format", format, "err", err, "stderr", strings.TrimSpace(stderr.String()))
+		http.Error(w, "conversion failed", http.StatusInternalServerError)
+		return
+	}
+
+	name := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path)) + df.ext
+	w.Header().Set("Content-Type", df.contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name))
+	w.Write(buf[:n])
+	if readErr == nil {
+		io.Copy(w, stdout)
+	}
+	if err := cmd.Wait(); err != nil && r.Context().Err() == nil {
+		// Too late for an error status; the client sees a truncated file
+		httpLog.Warn("Converting recording failed", "file", path, "format", format, "err", err, "stderr", strings.TrimSpace(stderr.String()))
+	}
+}
@@ -0,0 +1,190 @@
+package recorder
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Transcription backends for -transcribe.
+const (
+	transcribeWhisper = "whisper" // Local whisper.cpp command-line tool
+	transcribeAPI     = "api"     // OpenAI-compatible /audio/transcriptions endpoint
+)
+
+// transcriptPaths returns where the subtitles and plain text transcript of a
+// recording are written: next to it, with the same base name, so media
+// players pick up the subtitles.
+func transcriptPaths(recording string) (srt, txt string) {
+	base := strings.TrimSuffix(recording, filepath.Ext(recording))
+	return base + ".srt", base + ".txt"
+}
+
+// companionFiles returns the files the server writes alongside a recording,
+// which are uploaded and deleted together with it.
+func companionFiles(recording string) []string {
+	srt, txt := transcriptPaths(recording)
+	return []string{sidecarPath(recording), srt, txt, rttPath(recording), peaksPath(recording), rtpdumpPath(recording)}
+}
+
+// transcriber turns finished recordings into .srt and .txt transcripts. Only
+// one file is transcribed at a time, as speech recognition is heavy.
+type transcriber struct {
+	cfg    *Config
+	client *http.Client
+	slot   chan struct{}
+}
+
+func newTranscriber(cfg *Config) *transcriber {
+	return &transcriber{cfg: cfg, client: &http.Client{Timeout: time.Hour}, slot: make(chan struct{}, 1)}
+}
+
+// transcribe writes the transcripts of a recording, logging failures.
+func (t *transcriber) transcribe(ff *finishedFile) error {
+	recording := ff.Path
+	t.slot <- struct{}{}
+	defer func() { <-t.slot }()
+
+	start := time.Now()
+	srt, txt := transcriptPaths(recording)
+	var subtitles []byte
+	var err error
+	if t.cfg.transcribe == transcribeWhisper {
+		subtitles, err = t.whisper(recording, ff.Format == formatWAV && ff.SampleRate == 16000)
+	} else {
+		subtitles, err = t.api(recording)
+	}
+	if err == nil {
+		err = os.WriteFile(srt, subtitles, 0o644)
+	}
+	if err == nil {
+		err = os.WriteFile(txt, srtText(subtitles), 0o644)
+	}
+	if err != nil {
+		finalizeLog.Warn("Transcribing failed", "file", recording, "err", err)
+		return err
+	}
+	finalizeLog.Info("📜 Transcribed", "file", recording, "took", time.Since(start))
+	return nil
+}
+
+// whisper runs whisper.cpp on the recording. whisper.cpp only reads 16 kHz
+// WAV, so anything else, unless wav16k says it is that, is converted with
+// ffmpeg first.
+func (t *transcriber) whisper(recording string, wav16k bool) ([]byte, error) {
+	input := recording
+	if !wav16k {
+		tmp, err := os.CreateTemp("", "transcribe-*.wav")
+		if err != nil {
+			return nil, err
+		}
+		tmp.Close()
+		defer os.Remove(tmp.Name())
+		out, err := exec.Command("ffmpeg", "-hide_banner", "-loglevel", "error", "-y",
+			"-i", recording, "-ar", "16000", "-ac", "1", "-c:a", "pcm_s16le", tmp.Name()).CombinedOutput()
+		if err != nil {
+			return nil, fmt.Errorf("ffmpeg: %v: %s", err, bytes.TrimSpace(out))
+		}
+		input = tmp.Name()
+	}
+
+	// whisper.cpp appends .srt to the -of path
+	dir, err := os.MkdirTemp("", "transcribe-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	args := []string{"-m", t.cfg.whisperModel, "-f", input, "-osrt", "-of", filepath.Join(dir, "out"), "-np"}
+	if t.cfg.transcribeLanguage != "" {
+		args = append(args, "-l", t.cfg.transcribeLanguage)
+	}
+	if out, err := exec.Command(t.cfg.whisperBin, args...).CombinedOutput(); err != nil {
+		return nil, fmt.Errorf("%s: %v: %s", t.cfg.whisperBin, err, bytes.TrimSpace(out))
+	}
+	return os.ReadFile(filepath.Join(dir, "out.srt"))
+}
+
+// api uploads the recording to an OpenAI-compatible transcription endpoint
+// and returns the subtitles it produces.
+func (t *transcriber) api(recording string) ([]byte, error) {
+	f, err := os.Open(recording)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	// Stream the multipart body instead of buffering whole recordings
+	pr, pw := io.Pipe()
+	mw := multipart.NewWriter(pw)
+	go func() {
+		fields := map[string]string{"model": t.cfg.transcribeModel, "response_format": "srt"}
+		if t.cfg.transcribeLanguage != "" {
+			fields["language"] = t.cfg.transcribeLanguage
+		}
+		for k, v := range fields {
+			if err := mw.WriteField(k, v); err != nil {
+				pw.CloseWithError(err)
+				return
+			}
+		}
+		part, err := mw.CreateFormFile("file", filepath.Base(recording))
+		if err == nil {
+			_, err = io.Copy(part, f)
+		}
+		if err == nil {
+			err = mw.Close()
+		}
+		pw.CloseWithError(err)
+	}()
+
+	req, err := http.NewRequest(http.MethodPost, t.cfg.transcribeURL, pr)
+	if err != nil {
+		pr.Close()
+		return nil, err
+	}
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	if key := os.Getenv("TRANSCRIBE_API_KEY"); key != "" {
+		req.Header.Set("Authorization", "Bearer "+key)
+	}
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("HTTP %d: %s", resp.StatusCode, bytes.TrimSpace(body))
+	}
+	return body, nil
+}
+
+// srtText extracts the plain text of SRT subtitles, one cue per line.
+func srtText(srt []byte) []byte {
+	var out bytes.Buffer
+	sc := bufio.NewScanner(bytes.NewReader(srt))
+	for expect := 0; sc.Scan(); {
+		line := strings.TrimSpace(sc.Text())
+		switch {
+		case line == "":
+			expect = 0 // Next line is a cue number
+		case expect == 0:
+			expect = 1 // Next line is the cue timing
+		case expect == 1:
+			expect = 2
+		default:
+			out.WriteString(line + "\n")
+		}
+	}
+	return out.Bytes()
+}
@@ -0,0 +1,377 @@
+package recorder
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	tuiRefresh  = 500 * time.Millisecond
+	tuiLogLines = 1000 // Log lines kept, and printed again on exit
+	tuiMeter    = 20   // Width of the level bars, spanning -60 to 0 dBFS
+)
+
+// tui is the terminal monitor of -tui: a live table of the streams with
+// keys to mute, solo and finalize them. While it runs, the log is captured
+// and shown below the table.
+type tui struct {
+	srv  *server
+	quit func()
+
+	term     *os.File // The real stdout
+	saved    string   // Terminal settings to restore, from stty -g
+	logW     *os.File // Replaces stdout
+	logsDone chan struct{}
+
+	mu   sync.Mutex
+	logs []string
+
+	// Only used by the render goroutine
+	selected string // Address of the selected stream
+	rows     []clientStats
+	rates    map[string]tuiRate
+
+	keys chan string
+	stop chan struct{}
+	done chan struct{}
+}
+
+// tuiRate measures the bitrate of a stream's recording between refreshes.
+type tuiRate struct {
+	part  int
+	bytes int64
+	at    time.Time
+	kbps  float64
+}
+
+// startTUI takes over the terminal. quit is called when the operator
+// presses q.
+func startTUI(srv *server, quit func()) (*tui, error) {
+	if fi, err := os.Stdin.Stat(); err != nil || fi.Mode()&os.ModeCharDevice == 0 {
+		return nil, fmt.Errorf("-tui needs a terminal")
+	}
+	saved, err := stty("-g")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the terminal settings: %w", err)
+	}
+	// Keys arrive unbuffered, while Ctrl+C still interrupts
+	if _, err := stty("-icanon", "-echo", "min", "1"); err != nil {
+		return nil, fmt.Errorf("failed to set up the terminal: %w", err)
+	}
+	r, w, err := os.Pipe()
+	if err != nil {
+		stty(saved)
+		return nil, err
+	}
+
+	t := &tui{
+		srv:      srv,
+		quit:     quit,
+		term:     os.Stdout,
+		saved:    saved,
+		logW:     w,
+		logsDone: make(chan struct{}),
+		rates:    make(map[string]tuiRate),
+		keys:     make(chan string, 16),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	os.Stdout = w
+	go t.captureLogs(r)
+	go t.readKeys()
+	fmt.Fprint(t.term, "\x1b[?1049h\x1b[?25l") // Alternate screen, hidden cursor
+	go t.run()
+	return t, nil
+}
+
+// close gives the terminal back and prints the captured log.
+func (t *tui) close() {
+	close(t.stop)
+	<-t.done
+	fmt.Fprint(t.term, "\x1b[?25h\x1b[?1049l")
+	stty(t.saved)
+	os.Stdout = t.term
+	t.logW.Close()
+	<-t.logsDone
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, line := range t.logs {
+		fmt.Println(line)
+	}
+}
+
+func (t *tui) captureLogs(r *os.File) {
+	defer close(t.logsDone)
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		t.mu.Lock()
+		t.logs = append(t.logs, scanner.Text())
+		if len(t.logs) > tuiLogLines {
+			t.logs = t.logs[len(t.logs)-tuiLogLines:]
+		}
+		t.mu.Unlock()
+	}
+}
+
+// readKeys forwards key presses, with arrow keys as "up" and "down". It
+// blocks on stdin until the process exits.
+func (t *tui) readKeys() {
+	buf := make([]byte, 16)
+	for {
+		n, err := os.Stdin.Read(buf)
+		if err != nil {
+			return
+		}
+		switch k := string(buf[:n]); k {
+		case "\x1b[A", "k":
+			t.keys <- "up"
+		case "\x1b[B", "j":
+			t.keys <- "down"
+		default:
+			t.keys <- k
+		}
+	}
+}
+
+func (t *tui) run() {
+	defer close(t.done)
+	ticker := time.NewTicker(tuiRefresh)
+	defer ticker.Stop()
+	t.render()
+	for {
+		select {
+		case <-t.stop:
+			return
+		case k := <-t.keys:
+			t.key(k)
+		case <-ticker.C:
+		}
+		t.render()
+	}
+}
+
+func (t *tui) key(k string) {
+	i := t.selectedIndex()
+	switch k {
+	case "up":
+		if i > 0 {
+			t.selected = t.rows[i-1].Addr
+		}
+	case "down":
+		if i >= 0 && i < len(t.rows)-1 {
+			t.selected = t.rows[i+1].Addr
+		}
+	case "m", "s":
+		if i < 0 {
+			return
+		}
+		sc := t.srv.controls.get(t.selected)
+		if k == "m" {
+			sc.Mute = !sc.Mute
+		} else {
+			sc.Solo = !sc.Solo
+		}
+		t.srv.controls.set(t.selected, sc)
+	case "f":
+		if i >= 0 {
+			go t.srv.stopSession(t.rows[i].Session)
+		}
+	case "q":
+		t.quit()
+	}
+}
+
+// selectedIndex returns the row of the selected stream, selecting the first
+// one if it is gone, or -1 without streams.
+func (t *tui) selectedIndex() int {
+	for i, cs := range t.rows {
+		if cs.Addr == t.selected {
+			return i
+		}
+	}
+	if len(t.rows) == 0 {
+		return -1
+	}
+	t.selected = t.rows[0].Addr
+	return 0
+}
+
+func (t *tui) render() {
+	st := t.srv.stats()
+	sort.Slice(st.Clients, func(i, j int) bool { return st.Clients[i].Addr < st.Clients[j].Addr })
+	t.rows = st.Clients
+	sel := t.selectedIndex()
+	now := time.Now()
+	width, height := terminalSize()
+
+	var b strings.Builder
+	b.WriteString("\x1b[H") // Lines are overwritten in place, without flicker
+	disk := fmt.Sprintf("disk %s", formatBytes(st.Disk.UsedBytes))
+	if st.Disk.QuotaBytes > 0 {
+		disk += " of " + formatBytes(st.Disk.QuotaBytes)
+	}
+	line := func(s string) {
+		b.WriteString(truncate(s, width) + "\x1b[K\r\n")
+	}
+	line(fmt.Sprintf("\x1b[1mAudio Capture Server\x1b[0m  %d stream(s), %s", len(st.Clients), disk))
+	line("")
+	line(fmt.Sprintf("  %-21s %-8s %8s  %-*s %13s %9s %14s %8s  %s", "STREAM", "SESSION", "TIME", tuiMeter, "LEVEL", "PEAK/RMS", "KBIT/S", "LOSS", "JITTER", "CTRL"))
+	seen := make(map[string]bool, len(st.Clients))
+	for i, cs := range st.Clients {
+		seen[cs.Addr] = true
+		marker := "  "
+		if i == sel {
+			marker = "\x1b[7m>\x1b[0m "
+		}
+		dbfs := "-"
+		if cs.Level != nil {
+			dbfs = fmt.Sprintf("%.1f/%.1f", cs.Level.Peak, cs.Level.RMS)
+		}
+		sc := t.srv.controls.get(cs.Addr)
+		var ctrl []string
+		if sc.Mute {
+			ctrl = append(ctrl, "muted")
+		}
+		if sc.Solo {
+			ctrl = append(ctrl, "solo")
+		}
+		if sc.GainDB != 0 {
+			ctrl = append(ctrl, fmt.Sprintf("%+.1fdB", sc.GainDB))
+		}
+		net := cs.Network
+		b.WriteString(marker)
+		b.WriteString(truncate(fmt.Sprintf("%-21s %-8s %8s  %s %13s %9.1f %14s %6.1fms  %s",
+			cs.Addr, cs.Session, formatDuration(now.Sub(cs.Start)), meterBar(cs.Level), dbfs, t.rate(cs, now),
+			fmt.Sprintf("%d (%.1f%%)", net.Lost, net.LossPercent), net.JitterMS, strings.Join(ctrl, " ")), width-2))
+		b.WriteString("\x1b[K\r\n")
+	}
+	if len(st.Clients) == 0 {
+		line("  No active streams")
+	}
+	for addr := range t.rates {
+		if !seen[addr] {
+			delete(t.rates, addr)
+		}
+	}
+
+	// The log fills the rest of the screen, above the key help
+	line("")
+	used := 5 + max(1, len(st.Clients))
+	t.mu.Lock()
+	logs := t.logs[max(0, len(t.logs)-max(0, height-used-1)):]
+	for _, l := range logs {
+		line(l)
+	}
+	t.mu.Unlock()
+	b.WriteString("\x1b[J") // Clear below
+	b.WriteString(fmt.Sprintf("\x1b[%d;1H\x1b[7m%s\x1b[0m", height, truncate(" ↑/↓ select  m mute  s solo  f finalize  q quit", width)))
+	fmt.Fprint(t.term, b.String())
+}
+
+// rate returns the bitrate of a recording, measured over at least a second.
+func (t *tui) rate(cs clientStats, now time.Time) float64 {
+	prev, ok := t.rates[cs.Addr]
+	if ok && now.Sub(prev.at) < time.Second {
+		return prev.kbps
+	}
+	cur := tuiRate{part: cs.Part, bytes: cs.Bytes, at: now, kbps: prev.kbps}
+	if ok && prev.part == cs.Part && cs.Bytes >= prev.bytes {
+		if secs := now.Sub(prev.at).Seconds(); secs > 0 {
+			cur.kbps = float64(cs.Bytes-prev.bytes) * 8 / 1000 / secs
+		}
+	}
+	t.rates[cs.Addr] = cur
+	return cur.kbps
+}
+
+// meterBar draws the RMS level solid and the peak shaded, colored by the
+// stream state.
+func meterBar(l *level) string {
+	if l == nil {
+		return strings.Repeat("·", tuiMeter)
+	}
+	cells := func(db float64) int {
+		return max(0, min(tuiMeter, int((db+60)/60*tuiMeter+0.5)))
+	}
+	rms, peak := cells(l.RMS), cells(l.Peak)
+	color := "32" // Green
+	switch l.State {
+	case levelClipping:
+		color = "31"
+	case levelSilent:
+		color = "33"
+	case levelIdle:
+		color = "90"
+	}
+	return "\x1b[" + color + "m" + strings.Repeat("█", rms) + strings.Repeat("▒", max(0, peak-rms)) +
+		"\x1b[0m" + strings.Repeat("·", tuiMeter-max(rms, peak))
+}
+
+// truncate cuts s to width runes, ignoring ANSI escape sequences.
+func truncate(s string, width int) string {
+	n, escape := 0, false
+	for i, r := range s {
+		switch {
+		case r == '\x1b':
+			escape = true
+		case escape:
+			escape = !(r >= 'A' && r <= 'Z' || r >= 'a' && r <= 'z')
+		default:
+			if n++; n > width {
+				return s[:i] + "\x1b[0m"
+			}
+		}
+	}
+	return s
+}
+
+func formatDuration(d time.Duration) string {
+	d = d.Round(time.Second)
+	h, m, s := int(d.Hours()), int(d.Minutes())%60, int(d.Seconds())%60
+	if h > 0 {
+		return fmt.Sprintf("%d:%02d:%02d", h, m, s)
+	}
+	return fmt.Sprintf("%d:%02d", m, s)
+}
+
+func formatBytes(n int64) string {
+	const units = "KMGT"
+	if n < 1000 {
+		return fmt.Sprintf("%d B", n)
+	}
+	v, i := float64(n)/1000, 0
+	for v >= 1000 && i < len(units)-1 {
+		v /= 1000
+		i++
+	}
+	return fmt.Sprintf("%.1f %cB", v, units[i])
+}
+
+// terminalSize returns the columns and rows of the terminal, defaulting to
+// 80x24.
+func terminalSize() (int, int) {
+	out, err := stty("size")
+	if f := strings.Fields(out); err == nil && len(f) == 2 {
+		rows, err1 := strconv.Atoi(f[0])
+		cols, err2 := strconv.Atoi(f[1])
+		if err1 == nil && err2 == nil && rows > 0 && cols > 0 {
+			return cols, rows
+		}
+	}
+	return 80, 24
+}
+
+// stty runs stty on the terminal of stdin.
+func stty(args ...string) (string, error) {
+	cmd := exec.Command("stty", args...)
+	cmd.Stdin = os.Stdin
+	out, err := cmd.Output()
+	return strings.TrimSpace(string(out)), err
+}
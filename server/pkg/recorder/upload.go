@@ -0,0 +1,297 @@
+package recorder
+
+import (
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	uploadMaxSize    = 5 << 30 // Largest object a single S3 PUT accepts
+	uploadConcurrent = 4       // Files uploaded at the same time
+)
+
+// uploader copies finished recordings to an S3-compatible object store. It
+// speaks the S3 REST API with Signature Version 4, which Amazon S3, Google
+// Cloud Storage (with HMAC keys) and MinIO all accept.
+type uploader struct {
+	bucket   string
+	prefix   string // Key prefix, without leading or trailing slash
+	endpoint string // Base URL, e.g. https://s3.eu-west-1.amazonaws.com
+	region   string
+	retries  int
+
+	accessKey    string
+	secretKey    string
+	sessionToken string
+
+	outDir string
+	client *http.Client
+	slots  chan struct{} // Limits concurrent uploads
+}
+
+// newUploader returns an uploader for the -upload destination, which looks
+// like s3://bucket/prefix or gs://bucket/prefix. Credentials are read from
+// AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN.
+func newUploader(cfg *Config) (*uploader, error) {
+	u, err := url.Parse(cfg.upload)
+	if err != nil || u.Host == "" || (u.Scheme != "s3" && u.Scheme != "gs") {
+		return nil, fmt.Errorf("invalid upload destination %q (use s3://bucket/prefix or gs://bucket/prefix)", cfg.upload)
+	}
+
+	up := &uploader{
+		bucket:       u.Host,
+		prefix:       strings.Trim(u.Path, "/"),
+		endpoint:     strings.TrimSuffix(cfg.uploadEndpoint, "/"),
+		region:       cfg.uploadRegion,
+		retries:      cfg.uploadRetries,
+		accessKey:    os.Getenv("AWS_ACCESS_KEY_ID"),
+		secretKey:    os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		sessionToken: os.Getenv("AWS_SESSION_TOKEN"),
+		outDir:       cfg.outDir,
+		client:       &http.Client{Timeout: 30 * time.Minute},
+		slots:        make(chan struct{}, uploadConcurrent),
+	}
+	if up.region == "" {
+		up.region = os.Getenv("AWS_REGION")
+	}
+	if up.region == "" {
+		up.region = "us-east-1"
+		if u.Scheme == "gs" {
+			up.region = "auto"
+		}
+	}
+	if up.endpoint == "" {
+		up.endpoint = "https://s3." + up.region + ".amazonaws.com"
+		if u.Scheme == "gs" {
+			up.endpoint = "https://storage.googleapis.com"
+		}
+	}
+	if up.accessKey == "" || up.secretKey == "" {
+		return nil, fmt.Errorf("-upload needs AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY to be set")
+	}
+	return up, nil
+}
+
+// destination describes where the uploader puts files, for logging.
+func (up *uploader) destination() string {
+	return fmt.Sprintf("%s/%s/%s", up.endpoint, up.bucket, up.prefix)
+}
+
+// key returns the object key for a local file: its path relative to the
+// output directory, under the configured prefix.
+func (up *uploader) key(path string) string {
+	rel, err := filepath.Rel(up.outDir, path)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		rel = filepath.Base(path)
+	}
+	rel = filepath.ToSlash(rel)
+	if up.prefix == "" {
+		return rel
+	}
+	return up.prefix + "/" + rel
+}
+
+// upload copies the file to the bucket, retrying with exponential backoff.
+// The MD5 and SHA-256 of the file are sent with the request, so the store
+// rejects any upload that arrives corrupted. The span, which may be nil, is
+// finished with the upload.
+func (up *uploader) upload(path string, sp *span) (err error) {
+	defer sp.finish()
+	defer func() { sp.fail(err) }()
+	sp.set("file", path)
+	queued := time.Now()
+	up.slots <- struct{}{}
+	defer func() { <-up.slots }()
+	sp.set("queued_ms", time.Since(queued))
+
+	md5Sum, sha256Sum, size, err := fileChecksums(path)
+	if err != nil {
+		return err
+	}
+	sp.set("bytes", size)
+	if size > uploadMaxSize {
+		return fmt.Errorf("%s is larger than the 5 GiB single upload limit", path)
+	}
+
+	backoff := time.Second
+	for attempt := 0; ; attempt++ {
+		err = up.put(path, size, md5Sum, sha256Sum)
+		sp.set("attempts", attempt+1)
+		if err == nil || attempt >= up.retries || !retryable(err) {
+			return err
+		}
+		sp.event("retry", "error", err.Error(), "backoff_ms", backoff)
+		finalizeLog.Warn("Upload failed, retrying", "file", path, "backoff", backoff, "err", err)
+		time.Sleep(backoff)
+		backoff = min(2*backoff, time.Minute)
+	}
+}
+
+// statusError is an error response from the object store.
+type statusError struct {
+	code int
+	body string
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("HTTP %d: %s", e.code, e.body)
+}
+
+// retryable reports whether a failed upload is worth trying again: network
+// errors and server-side failures are, rejected credentials or a missing
+// bucket are not.
+func retryable(err error) bool {
+	if se, ok := err.(*statusError); ok {
+		switch se.code {
+		case http.StatusUnauthorized, http.StatusForbidden, http.StatusNotFound:
+			return false
+		}
+	}
+	return true
+}
+
+func (up *uploader) put(path string, size int64, md5Sum, sha256Sum []byte) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	objectURL := up.endpoint + "/" + up.bucket + "/" + escapeKey(up.key(path))
+	req, err := http.NewRequest(http.MethodPut, objectURL, f)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+	req.Header.Set("Content-Type", contentType(path))
+	req.Header.Set("Content-MD5", base64.StdEncoding.EncodeToString(md5Sum))
+	req.Header.Set("X-Amz-Content-Sha256", hex.EncodeToString(sha256Sum))
+	if up.sessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", up.sessionToken)
+	}
+	up.sign(req, time.Now().UTC())
+
+	resp, err := up.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return &statusError{code: resp.StatusCode, body: strings.TrimSpace(string(body))}
+	}
+	return nil
+}
+
+// sign adds a Signature Version 4 Authorization header to the request. The
+// payload hash must already be set in X-Amz-Content-Sha256.
+func (up *uploader) sign(req *http.Request, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	day := now.Format("20060102")
+	req.Header.Set("X-Amz-Date", amzDate)
+
+	// Sign the host and every header set so far
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		req.Header.Get("X-Amz-Content-Sha256"),
+	}, "\n")
+	hash := sha256.Sum256([]byte(canonicalRequest))
+
+	scope := day + "/" + up.region + "/s3/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(hash[:])
+
+	key := hmacSHA256([]byte("AWS4"+up.secretKey), day)
+	key = hmacSHA256(key, up.region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		up.accessKey, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// escapeKey percent-encodes an object key the way Signature Version 4
+// expects: everything but unreserved characters, keeping the slashes.
+func escapeKey(key string) string {
+	var b strings.Builder
+	for i := 0; i < len(key); i++ {
+		c := key[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~', c == '/':
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// fileChecksums reads the file once and returns its MD5, its SHA-256 and its
+// size.
+func fileChecksums(path string) (md5Sum, sha256Sum []byte, size int64, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	defer f.Close()
+	m, s := md5.New(), sha256.New()
+	size, err = io.Copy(io.MultiWriter(m, s), f)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	return m.Sum(nil), s.Sum(nil), size, nil
+}
+
+func contentType(path string) string {
+	switch filepath.Ext(path) {
+	case ".wav":
+		return "audio/wav"
+	case ".mka":
+		return "audio/x-matroska"
+	case ".webm":
+		return "audio/webm"
+	case ".flac":
+		return "audio/flac"
+	case ".json":
+		return "application/json"
+	}
+	return "application/octet-stream"
+}
@@ -0,0 +1,119 @@
+package recorder
+
+import (
+	"math"
+	"time"
+)
+
+const (
+	vadMargin   = 10                     // dB above the noise floor that counts as voice
+	vadFloorUp  = 3                      // dB per second the noise floor may rise
+	vadPreRoll  = 300 * time.Millisecond // Audio kept from before voice resumes
+	vadMinLevel = -100                   // dBFS reported for digital silence
+)
+
+// gap is a stretch of silence left out of a recording.
+type gap struct {
+	At      float64   `json:"at_seconds"`      // Position in the file where audio was skipped
+	Time    time.Time `json:"time"`            // When the skipped stretch started
+	Skipped float64   `json:"skipped_seconds"` // Length of the skipped stretch
+}
+
+// voiceDetector is a simple energy based voice activity detector. A buffer
+// counts as voice when its RMS level is above both the threshold and, by
+// vadMargin, an adaptive estimate of the background noise. Once a stream has
+// been without voice for longer than trimAfter, its audio is held back until
+// voice returns; the last vadPreRoll of the skipped audio is then written
+// ahead of it so words aren't clipped.
+type voiceDetector struct {
+	threshold  float64 // dBFS
+	trimAfter  time.Duration
+	channels   int
+	sampleRate int
+	fullScale  float64
+
+	floor    float64 // Estimated noise floor in dBFS
+	quiet    int64   // Consecutive frames without voice
+	trimming bool
+	skipped  int64 // Frames skipped in the current gap
+	started  time.Time
+	preRoll  []int // Latest skipped samples, at most vadPreRoll
+}
+
+func newVoiceDetector(thresholdDBFS float64, trimAfter time.Duration, bitDepth, channels, sampleRate int) *voiceDetector {
+	return &voiceDetector{
+		threshold:  thresholdDBFS,
+		trimAfter:  trimAfter,
+		channels:   channels,
+		sampleRate: sampleRate,
+		fullScale:  float64(int(1) << (bitDepth - 1)),
+		floor:      thresholdDBFS,
+	}
+}
+
+// level returns the RMS level of a buffer in dBFS.
+func (d *voiceDetector) level(samples []int) float64 {
+	var sum float64
+	for _, v := range samples {
+		sum += float64(v) * float64(v)
+	}
+	rms := math.Sqrt(sum/float64(len(samples))) / d.fullScale
+	if rms == 0 {
+		return vadMinLevel
+	}
+	return max(vadMinLevel, 20*math.Log10(rms))
+}
+
+// feed analyzes a buffer and returns the samples to write: the buffer itself,
+// nil while trimming, or the pre-roll followed by the buffer when voice
+// resumes after a gap, which is then returned too.
+func (d *voiceDetector) feed(samples []int) ([]int, *gap) {
+	frames := int64(len(samples) / d.channels)
+	if frames == 0 {
+		return samples, nil
+	}
+	dur := float64(frames) / float64(d.sampleRate)
+
+	// The floor follows drops in level at once and rises slowly, so it
+	// settles on the background noise between words. It never rises above
+	// the threshold, so sustained loud audio such as music always counts.
+	lvl := d.level(samples)
+	if lvl < d.floor {
+		d.floor = lvl
+	} else {
+		d.floor = min(lvl, d.floor+vadFloorUp*dur, d.threshold)
+	}
+	voice := lvl > d.threshold && lvl > d.floor+vadMargin
+
+	if !voice {
+		d.quiet += frames
+		if !d.trimming && d.duration(d.quiet) <= d.trimAfter {
+			return samples, nil
+		}
+		if !d.trimming {
+			d.trimming = true
+			d.skipped = 0
+			d.started = time.Now()
+			d.preRoll = d.preRoll[:0]
+		}
+		d.skipped += frames
+		d.preRoll = append(d.preRoll, samples...)
+		if keep := int(int64(vadPreRoll)*int64(d.sampleRate)/int64(time.Second)) * d.channels; len(d.preRoll) > keep {
+			d.preRoll = append(d.preRoll[:0], d.preRoll[len(d.preRoll)-keep:]...)
+		}
+		return nil, nil
+	}
+
+	d.quiet = 0
+	if !d.trimming {
+		return samples, nil
+	}
+	d.trimming = false
+	preRollFrames := int64(len(d.preRoll) / d.channels)
+	g := &gap{Time: d.started, Skipped: d.duration(d.skipped - preRollFrames).Seconds()}
+	return append(append([]int(nil), d.preRoll...), samples...), g
+}
+
+func (d *voiceDetector) duration(n int64) time.Duration {
+	return time.Duration(n) * time.Second / time.Duration(d.sampleRate)
+}
@@ -0,0 +1,84 @@
+package recorder
+
+import (
+	"expvar"
+	"runtime"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// Counters of the packet path, published with expvar. They count over the
+// whole run, unlike /stats, which only covers the connected clients.
+var (
+	packetsReceived  = expvar.NewInt("packets_received")  // Accepted by the allowlist
+	bytesReceived    = expvar.NewInt("bytes_received")    // Of those packets, headers included
+	packetsMalformed = expvar.NewInt("packets_malformed") // Not RTP
+	buffersDropped   = expvar.NewInt("buffers_dropped")   // Decoded audio a full writer queue had no room for
+
+	packetsDroppedKernel = expvar.NewInt("packets_dropped_kernel") // By the kernel, with the receive buffer full; Linux only
+)
+
+// queueVars is the state of one client's writer queue in /debug/vars.
+type queueVars struct {
+	Addr     string `json:"addr"`
+	Session  string `json:"session"`
+	Depth    int    `json:"depth"`    // Buffers waiting to be written
+	Capacity int    `json:"capacity"` // -queue-size
+	Dropped  int64  `json:"dropped"`
+}
+
+var (
+	varsOnce   sync.Once
+	varsServer atomic.Pointer[server] // The server publishVars was last called for
+)
+
+// publishVars publishes the server's internal state with expvar, served as
+// JSON on /debug/vars next to the counters above and the runtime's memstats.
+// The variables are published once per process and follow the server it was
+// last called for, so a program can run the recorder again.
+func (s *server) publishVars() {
+	varsServer.Store(s)
+	varsOnce.Do(func() {
+		expvar.Publish("goroutines", expvar.Func(func() any { return runtime.NumGoroutine() }))
+		expvar.Publish("udp_socket", serverVar(func(s *server) any {
+			st, err := s.socketStats()
+			if err != nil {
+				return nil
+			}
+			return st
+		}))
+		expvar.Publish("clients", serverVar(func(s *server) any {
+			return s.clients.len()
+		}))
+		expvar.Publish("queues", serverVar(func(s *server) any {
+			out := []queueVars{}
+			s.clients.each(func(addr string, c *Client) bool {
+				out = append(out, queueVars{
+					Addr:     addr,
+					Session:  c.session,
+					Depth:    len(c.queue),
+					Capacity: cap(c.queue),
+					Dropped:  c.dropped.Load(),
+				})
+				return true
+			})
+			sort.Slice(out, func(i, j int) bool { return out[i].Addr < out[j].Addr })
+			return out
+		}))
+		expvar.Publish("packets_denied", serverVar(func(s *server) any { return s.access.denied.Load() }))
+		expvar.Publish("disk_used_bytes", serverVar(func(s *server) any { return s.disk.get() }))
+		expvar.Publish("finalizing", serverVar(func(s *server) any { return s.fin.active.Load() }))
+		expvar.Publish("uploading", serverVar(func(s *server) any {
+			if s.fin.up == nil {
+				return 0
+			}
+			return len(s.fin.up.slots)
+		}))
+	})
+}
+
+// serverVar is a variable of the server publishVars was last called for.
+func serverVar(f func(s *server) any) expvar.Func {
+	return func() any { return f(varsServer.Load()) }
+}
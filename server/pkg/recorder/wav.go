@@ -0,0 +1,255 @@
+package recorder
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"os"
+	"time"
+)
+
+const wavMaxSize = math.MaxUint32 // RIFF sizes are 32-bit, so a WAV file can't grow past 4 GiB
+
+// wavWriter writes interleaved PCM samples to a WAV file. It lays out the
+// header itself so extra chunks such as bext can precede the audio data, and
+// it can rewrite the size fields in place while the file is still growing.
+type wavWriter struct {
+	file      *bufferedFile
+	bitDepth  int
+	dataStart int64 // Offset of the first audio byte
+	dataSize  int64
+	buf       []byte // Reused scratch space for encoding samples
+	cues      []wavCue
+}
+
+// wavCue is a marker written to the cue and labl chunks when the file is
+// closed.
+type wavCue struct {
+	frame int64
+	label string
+}
+
+// newWAVWriter creates the file at path, which must not exist yet, writes the
+// WAV header and returns a writer for the audio data. If bext is not nil a Broadcast WAV bext chunk is
+// included.
+func newWAVWriter(path string, sampleRate, bitDepth, channels int, bext *bextChunk) (*wavWriter, error) {
+	blockAlign := channels * bitDepth / 8
+
+	var hdr []byte
+	hdr = append(hdr, "RIFF"...)
+	hdr = binary.LittleEndian.AppendUint32(hdr, 0) // File size, updated later
+	hdr = append(hdr, "WAVE"...)
+
+	if bext != nil {
+		chunk := bext.encode()
+		hdr = append(hdr, "bext"...)
+		hdr = binary.LittleEndian.AppendUint32(hdr, uint32(len(chunk)))
+		hdr = append(hdr, chunk...)
+		if len(chunk)%2 == 1 {
+			hdr = append(hdr, 0)
+		}
+	}
+
+	hdr = append(hdr, "fmt "...)
+	hdr = binary.LittleEndian.AppendUint32(hdr, 16)
+	hdr = binary.LittleEndian.AppendUint16(hdr, 1) // PCM
+	hdr = binary.LittleEndian.AppendUint16(hdr, uint16(channels))
+	hdr = binary.LittleEndian.AppendUint32(hdr, uint32(sampleRate))
+	hdr = binary.LittleEndian.AppendUint32(hdr, uint32(sampleRate*blockAlign)) // Bytes per second
+	hdr = binary.LittleEndian.AppendUint16(hdr, uint16(blockAlign))
+	hdr = binary.LittleEndian.AppendUint16(hdr, uint16(bitDepth))
+
+	hdr = append(hdr, "data"...)
+	hdr = binary.LittleEndian.AppendUint32(hdr, 0) // Data size, updated later
+
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := f.Write(hdr); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to write WAV header: %w", err)
+	}
+	return &wavWriter{file: newBufferedFile(f), bitDepth: bitDepth, dataStart: int64(len(hdr))}, nil
+}
+
+// size returns the current size of the file in bytes.
+func (w *wavWriter) size() int64 {
+	return w.dataStart + w.dataSize
+}
+
+// write appends interleaved samples as little-endian PCM.
+func (w *wavWriter) write(samples []int) error {
+	w.buf = appendPCMLE(w.buf[:0], samples, w.bitDepth)
+	n, err := w.file.Write(w.buf)
+	w.dataSize += int64(n)
+	return err
+}
+
+// flush writes the buffered audio to the file.
+func (w *wavWriter) flush() error { return w.file.Flush() }
+
+// patch writes samples over the audio offset bytes into the data, flushing
+// what is buffered first, as it may be what is written over.
+func (w *wavWriter) patch(offset int64, samples []int) error {
+	if err := w.file.Flush(); err != nil {
+		return err
+	}
+	w.buf = appendPCMLE(w.buf[:0], samples, w.bitDepth)
+	if offset+int64(len(w.buf)) > w.dataSize {
+		return fmt.Errorf("patch past the end of the audio")
+	}
+	_, err := w.file.WriteAt(w.buf, w.dataStart+offset)
+	return err
+}
+
+// syncHeader rewrites the RIFF and data chunk sizes to match what has been
+// written so far and flushes the file to disk, so a file left behind by a
+// crash is only missing the last few seconds instead of looking empty. The
+// audio is flushed first, so the sizes never count more than the file holds.
+// WriteAt leaves the write offset untouched.
+func (w *wavWriter) syncHeader() error {
+	if err := w.file.Flush(); err != nil {
+		return err
+	}
+	if err := w.writeSizes(w.size()); err != nil {
+		return err
+	}
+	return w.file.Sync()
+}
+
+func (w *wavWriter) writeSizes(fileSize int64) error {
+	var b [4]byte
+	binary.LittleEndian.PutUint32(b[:], uint32(fileSize-8))
+	if _, err := w.file.WriteAt(b[:], 4); err != nil {
+		return err
+	}
+	binary.LittleEndian.PutUint32(b[:], uint32(w.dataSize))
+	_, err := w.file.WriteAt(b[:], w.dataStart-4)
+	return err
+}
+
+// addCue adds a marker at the given frame, written when the file is closed.
+func (w *wavWriter) addCue(frame int64, label string) {
+	w.cues = append(w.cues, wavCue{frame: frame, label: label})
+}
+
+// close pads the data chunk to an even size, appends the cue markers,
+// finalizes the header and closes the file.
+func (w *wavWriter) close() error {
+	if err := w.finish(); err != nil {
+		w.file.Close()
+		return err
+	}
+	return w.file.Close()
+}
+
+// finish writes what close adds to the file, and syncs it.
+func (w *wavWriter) finish() error {
+	fileSize := w.size()
+	if w.dataSize%2 == 1 {
+		if _, err := w.file.Write([]byte{0}); err != nil {
+			return err
+		}
+		fileSize++
+	}
+	if len(w.cues) > 0 {
+		chunks := encodeCues(w.cues)
+		if _, err := w.file.Write(chunks); err != nil {
+			return err
+		}
+		fileSize += int64(len(chunks))
+	}
+	if err := w.file.Flush(); err != nil {
+		return err
+	}
+	if err := w.writeSizes(fileSize); err != nil {
+		return err
+	}
+	return w.file.Sync()
+}
+
+// encodeCues returns a cue chunk with the markers and a LIST adtl chunk
+// with their labels, as read by audio editors.
+func encodeCues(cues []wavCue) []byte {
+	var cue []byte
+	cue = binary.LittleEndian.AppendUint32(cue, uint32(len(cues)))
+	var adtl []byte
+	adtl = append(adtl, "adtl"...)
+	for i, c := range cues {
+		id := uint32(i + 1)
+		cue = binary.LittleEndian.AppendUint32(cue, id)
+		cue = binary.LittleEndian.AppendUint32(cue, uint32(c.frame)) // Play order position
+		cue = append(cue, "data"...)
+		cue = binary.LittleEndian.AppendUint32(cue, 0) // Chunk start
+		cue = binary.LittleEndian.AppendUint32(cue, 0) // Block start
+		cue = binary.LittleEndian.AppendUint32(cue, uint32(c.frame))
+
+		text := append([]byte(c.label), 0)
+		adtl = append(adtl, "labl"...)
+		adtl = binary.LittleEndian.AppendUint32(adtl, uint32(4+len(text)))
+		adtl = binary.LittleEndian.AppendUint32(adtl, id)
+		adtl = append(adtl, text...)
+		if len(text)%2 == 1 {
+			adtl = append(adtl, 0)
+		}
+	}
+
+	var out []byte
+	out = append(out, "cue "...)
+	out = binary.LittleEndian.AppendUint32(out, uint32(len(cue)))
+	out = append(out, cue...)
+	out = append(out, "LIST"...)
+	out = binary.LittleEndian.AppendUint32(out, uint32(len(adtl)))
+	return append(out, adtl...)
+}
+
+// bextChunk holds the Broadcast Wave Format (EBU Tech 3285) metadata written
+// at the start of a recording.
+type bextChunk struct {
+	description   string
+	originator    string
+	originatorRef string
+	origination   time.Time
+	sampleRate    int
+	codingHistory string
+}
+
+// encode serializes the chunk body in the version 1 layout.
+func (b *bextChunk) encode() []byte {
+	field := func(s string, n int) []byte {
+		out := make([]byte, n)
+		copy(out, s)
+		return out
+	}
+	midnight := time.Date(b.origination.Year(), b.origination.Month(), b.origination.Day(), 0, 0, 0, 0, b.origination.Location())
+	timeRef := uint64(b.origination.Sub(midnight).Seconds() * float64(b.sampleRate)) // Samples since midnight
+
+	var out []byte
+	out = append(out, field(b.description, 256)...)
+	out = append(out, field(b.originator, 32)...)
+	out = append(out, field(b.originatorRef, 32)...)
+	out = append(out, field(b.origination.Format("2006-01-02"), 10)...)
+	out = append(out, field(b.origination.Format("15:04:05"), 8)...)
+	out = binary.LittleEndian.AppendUint64(out, timeRef)
+	out = binary.LittleEndian.AppendUint16(out, 1) // Version
+	out = append(out, make([]byte, 64)...)         // UMID, unused
+	out = append(out, make([]byte, 190)...)        // Reserved
+	out = append(out, b.codingHistory...)
+	return out
+}
+
+// codingHistory returns the BWF coding history line describing how the
+// server received and stored the audio.
+func codingHistory(sampleRate, bitDepth, channels int) string {
+	var mode string
+	switch channels {
+	case 1:
+		mode = "mono"
+	case 2:
+		mode = "stereo"
+	default:
+		mode = fmt.Sprintf("%dch", channels)
+	}
+	return fmt.Sprintf("A=PCM,F=%d,W=%d,M=%s,T=audio-capture-server;RTP L%d\r\n", sampleRate, bitDepth, mode, bitDepth)
+}
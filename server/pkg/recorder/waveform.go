@@ -0,0 +1,87 @@
+package recorder
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// peaksPath returns where the waveform peaks of a recording are written.
+func peaksPath(recording string) string {
+	return strings.TrimSuffix(recording, filepath.Ext(recording)) + ".peaks.json"
+}
+
+// peaks is the waveform of a recording in the JSON format of BBC
+// audiowaveform, which waveform.js and peaks.js read: for every pixel of
+// samplesPerPixel frames, the minimum and maximum sample over all channels,
+// scaled to 8 bits.
+type peaks struct {
+	Version         int    `json:"version"`
+	Channels        int    `json:"channels"`
+	SampleRate      int    `json:"sample_rate"`
+	SamplesPerPixel int    `json:"samples_per_pixel"`
+	Bits            int    `json:"bits"`
+	Length          int    `json:"length"` // Pixels
+	Data            []int8 `json:"data"`   // Minimum and maximum of each pixel
+}
+
+// waveform collects the peaks of a file while it is recorded.
+type waveform struct {
+	peaks
+	shift    int // Scales samples to 8 bits
+	channels int
+
+	n        int // Samples of the current pixel, over all channels
+	min, max int
+}
+
+func newWaveform(cfg *Config) *waveform {
+	spp := max(1, int(float64(cfg.sampleRate)*time.Duration(cfg.waveform).Seconds()))
+	return &waveform{
+		peaks: peaks{
+			Version:         2,
+			Channels:        1,
+			SampleRate:      cfg.sampleRate,
+			SamplesPerPixel: spp,
+			Bits:            8,
+			Data:            []int8{},
+		},
+		shift:    cfg.bitDepth - 8,
+		channels: cfg.channels,
+	}
+}
+
+// feed adds interleaved samples written to the file.
+func (w *waveform) feed(samples []int) {
+	window := w.SamplesPerPixel * w.channels
+	for _, v := range samples {
+		if w.n == 0 {
+			w.min, w.max = v, v
+		} else {
+			w.min, w.max = min(w.min, v), max(w.max, v)
+		}
+		if w.n++; w.n == window {
+			w.pixel()
+		}
+	}
+}
+
+func (w *waveform) pixel() {
+	w.Data = append(w.Data, int8(w.min>>w.shift), int8(w.max>>w.shift))
+	w.Length++
+	w.n = 0
+}
+
+// write saves the peaks, including the last, partial pixel.
+func (w *waveform) write(path string) error {
+	if w.n > 0 {
+		w.pixel()
+	}
+	data, err := json.Marshal(w.peaks)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0o644)
+}
@@ -0,0 +1,403 @@
+package recorder
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/netip"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pion/interceptor"
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v4"
+	"github.com/pion/webrtc/v4/pkg/media/oggwriter"
+)
+
+var whipLog = logger("whip")
+
+const (
+	whipPath        = "/whip"
+	whipMaxSessions = 64               // Publishers at once, each decoded by an ffmpeg of its own
+	whipGatherTime  = 5 * time.Second  // For the server's ICE candidates, sent all in the answer
+	whipConnectTime = 30 * time.Second // For a publisher to connect after its offer is answered
+	l16PayloadType  = 96               // Of the packets a publisher's decoded audio is recorded from
+)
+
+// whipIngest takes WebRTC publishers, such as browsers and OBS, with
+// -whip: a publisher posts its SDP offer to POST /whip (WHIP, RFC 9725) and
+// gets the server's answer, with all of its ICE candidates, and the URL of
+// its session, which DELETE ends. The Opus audio it sends is decoded by
+// ffmpeg into the server's format and goes through the same pipeline as the
+// RTP packets of a stream from the publisher's address; the DTLS, SRTP,
+// NACKs and reports of WebRTC are left to pion.
+type whipIngest struct {
+	s   *server
+	api *webrtc.API
+	pc  webrtc.Configuration
+
+	mu       sync.Mutex
+	sessions map[string]*whipSession // By ID, guarded by mu
+	wg       sync.WaitGroup          // The sessions' decoding
+}
+
+// whipSession is one publisher.
+type whipSession struct {
+	id   string
+	pc   *webrtc.PeerConnection
+	from string // The address it posted from
+
+	mu   sync.Mutex
+	addr netip.AddrPort // Where its media comes from, once connected
+}
+
+func newWHIPIngest(s *server) (*whipIngest, error) {
+	m := &webrtc.MediaEngine{}
+	if err := m.RegisterCodec(webrtc.RTPCodecParameters{
+		RTPCodecCapability: webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeOpus, ClockRate: opusRate, Channels: 2, SDPFmtpLine: "minptime=10;useinbandfec=1"},
+		PayloadType:        111,
+	}, webrtc.RTPCodecTypeAudio); err != nil {
+		return nil, err
+	}
+	// NACKs, receiver reports and congestion feedback, as browsers expect
+	ir := &interceptor.Registry{}
+	if err := webrtc.RegisterDefaultInterceptors(m, ir); err != nil {
+		return nil, err
+	}
+	w := &whipIngest{
+		s:        s,
+		api:      webrtc.NewAPI(webrtc.WithMediaEngine(m), webrtc.WithInterceptorRegistry(ir)),
+		sessions: make(map[string]*whipSession),
+	}
+	if s.cfg.stunServer != "" {
+		w.pc.ICEServers = []webrtc.ICEServer{{URLs: []string{"stun:" + s.cfg.stunServer}}}
+	}
+	return w, nil
+}
+
+// handleWHIP serves POST /whip, and DELETE /whip/<id>. OPTIONS is answered
+// for browsers publishing from pages of other origins.
+func (w *whipIngest) handleWHIP(rw http.ResponseWriter, r *http.Request) {
+	rw.Header().Set("Access-Control-Allow-Origin", "*")
+	rw.Header().Set("Access-Control-Expose-Headers", "Location")
+	id, hasID := strings.CutPrefix(r.URL.Path, whipPath+"/")
+	switch {
+	case r.Method == http.MethodOptions:
+		rw.Header().Set("Access-Control-Allow-Methods", "POST, DELETE, OPTIONS")
+		rw.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type")
+		rw.Header().Set("Accept-Post", "application/sdp")
+		rw.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodPost && !hasID:
+		w.publish(rw, r)
+	case r.Method == http.MethodDelete && hasID:
+		w.mu.Lock()
+		session := w.sessions[id]
+		w.mu.Unlock()
+		if session == nil {
+			http.Error(rw, "no such session", http.StatusNotFound)
+			return
+		}
+		whipLog.Info("🌐 Publisher ended its WHIP session", "addr", session.from)
+		w.end(session)
+	default:
+		// Trickle ICE and ICE restarts (PATCH) aren't supported
+		http.Error(rw, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// publish answers a publisher's offer.
+func (w *whipIngest) publish(rw http.ResponseWriter, r *http.Request) {
+	if ct, _, _ := strings.Cut(r.Header.Get("Content-Type"), ";"); strings.TrimSpace(ct) != "application/sdp" {
+		http.Error(rw, "the offer must be application/sdp", http.StatusUnsupportedMediaType)
+		return
+	}
+	if ap, err := netip.ParseAddrPort(r.RemoteAddr); err == nil && !w.s.access.allowed(ap.Addr().Unmap()) {
+		http.Error(rw, "forbidden", http.StatusForbidden)
+		return
+	}
+	offer, err := io.ReadAll(http.MaxBytesReader(rw, r.Body, 64<<10))
+	if err != nil {
+		http.Error(rw, "invalid offer: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.mu.Lock()
+	full := len(w.sessions) >= whipMaxSessions
+	w.mu.Unlock()
+	if full {
+		whipLog.Warn("🚫 Refused a WHIP publisher, too many are connected", "addr", r.RemoteAddr, "max", whipMaxSessions)
+		http.Error(rw, "too many publishers", http.StatusServiceUnavailable)
+		return
+	}
+
+	pc, err := w.api.NewPeerConnection(w.pc)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	session := &whipSession{id: newWHIPID(), pc: pc, from: r.RemoteAddr}
+	answer, err := w.answer(r.Context(), session, string(offer))
+	if err != nil {
+		pc.Close()
+		http.Error(rw, "invalid offer: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.mu.Lock()
+	w.sessions[session.id] = session
+	w.mu.Unlock()
+	time.AfterFunc(whipConnectTime, func() {
+		if pc.ConnectionState() != webrtc.PeerConnectionStateConnected {
+			w.end(session)
+		}
+	})
+
+	whipLog.Info("🌐 Answered a WHIP offer", "addr", r.RemoteAddr, "session_url", whipPath+"/"+session.id)
+	rw.Header().Set("Content-Type", "application/sdp")
+	rw.Header().Set("Location", whipPath+"/"+session.id)
+	rw.WriteHeader(http.StatusCreated)
+	io.WriteString(rw, answer)
+}
+
+// answer sets up the session's peer connection from offer and returns the
+// answer, once the server's ICE candidates are gathered.
+func (w *whipIngest) answer(ctx context.Context, session *whipSession, offer string) (string, error) {
+	pc := session.pc
+	if _, err := pc.AddTransceiverFromKind(webrtc.RTPCodecTypeAudio, webrtc.RTPTransceiverInit{Direction: webrtc.RTPTransceiverDirectionRecvonly}); err != nil {
+		return "", err
+	}
+	pc.OnTrack(func(track *webrtc.TrackRemote, receiver *webrtc.RTPReceiver) {
+		if track.Kind() != webrtc.RTPCodecTypeAudio {
+			return
+		}
+		w.wg.Add(1)
+		go func() {
+			defer w.wg.Done()
+			w.record(session, track, receiver)
+		}()
+	})
+	pc.OnConnectionStateChange(func(state webrtc.PeerConnectionState) {
+		switch state {
+		case webrtc.PeerConnectionStateFailed:
+			whipLog.Warn("🌐 WHIP connection lost", "addr", session.from)
+			w.end(session)
+		case webrtc.PeerConnectionStateClosed:
+			w.end(session)
+		}
+	})
+	if err := pc.SetRemoteDescription(webrtc.SessionDescription{Type: webrtc.SDPTypeOffer, SDP: offer}); err != nil {
+		return "", err
+	}
+	answer, err := pc.CreateAnswer(nil)
+	if err != nil {
+		return "", err
+	}
+	gathered := webrtc.GatheringCompletePromise(pc)
+	if err := pc.SetLocalDescription(answer); err != nil {
+		return "", err
+	}
+	ctx, cancel := context.WithTimeout(ctx, whipGatherTime)
+	defer cancel()
+	select {
+	case <-gathered:
+	case <-ctx.Done(): // Answer with the candidates gathered so far
+	}
+	return pc.LocalDescription().SDP, nil
+}
+
+// record decodes a publisher's audio track and hands it to the pipeline as
+// RTP packets from its address, until the track ends.
+func (w *whipIngest) record(session *whipSession, track *webrtc.TrackRemote, receiver *webrtc.RTPReceiver) {
+	addr := session.remote(receiver)
+	src := &source{name: addr.String(), linear: true}
+	log := whipLog.With("addr", addr)
+	if !strings.EqualFold(track.Codec().MimeType, webrtc.MimeTypeOpus) {
+		log.Warn("Ignoring a WHIP track that isn't Opus", "codec", track.Codec().MimeType)
+		return
+	}
+	dec, err := newOpusDecoder(w.s.cfg, track.Codec().Channels, whipLog)
+	if err != nil {
+		log.Error("Decoding a WHIP publisher's audio failed", "err", err)
+		return
+	}
+	log.Info("🌐 Publisher connected over WHIP", "session_url", whipPath+"/"+session.id, "channels", track.Codec().Channels)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		dec.packets(uint32(track.SSRC()), func(pkt []byte) { w.s.handlePacket(pkt, addr, src) })
+	}()
+	for {
+		pkt, _, err := track.ReadRTP()
+		if err != nil {
+			break
+		}
+		if err := dec.write(pkt); err != nil {
+			log.Error("Decoding a WHIP publisher's audio failed", "err", err)
+			break
+		}
+	}
+	dec.close()
+	<-done
+	log.Info("🌐 Publisher disconnected from WHIP")
+}
+
+// remote returns the address the session's media comes from, the remote
+// candidate of the pair ICE selected, or else the one it posted from.
+func (session *whipSession) remote(receiver *webrtc.RTPReceiver) netip.AddrPort {
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	if session.addr.IsValid() {
+		return session.addr
+	}
+	if pair, err := receiver.Transport().ICETransport().GetSelectedCandidatePair(); err == nil && pair != nil {
+		if ip, err := netip.ParseAddr(pair.Remote.Address); err == nil {
+			session.addr = netip.AddrPortFrom(ip.Unmap(), pair.Remote.Port)
+		}
+	}
+	if !session.addr.IsValid() {
+		session.addr, _ = netip.ParseAddrPort(session.from)
+		session.addr = netip.AddrPortFrom(session.addr.Addr().Unmap(), session.addr.Port())
+	}
+	return session.addr
+}
+
+// end closes the session's peer connection, ending its track, and forgets
+// it.
+func (w *whipIngest) end(session *whipSession) {
+	w.mu.Lock()
+	_, ok := w.sessions[session.id]
+	delete(w.sessions, session.id)
+	w.mu.Unlock()
+	if ok {
+		go session.pc.Close() // Not from the connection's own callbacks
+	}
+}
+
+// close ends every session and waits for their audio to be decoded.
+func (w *whipIngest) close() {
+	w.mu.Lock()
+	sessions := make([]*whipSession, 0, len(w.sessions))
+	for _, session := range w.sessions {
+		sessions = append(sessions, session)
+	}
+	clear(w.sessions)
+	w.mu.Unlock()
+	for _, session := range sessions {
+		session.pc.Close()
+	}
+	w.wg.Wait()
+}
+
+// write reports whether addr is a publisher's: the server's receiver reports
+// aren't sent to it, as WebRTC has its own.
+func (w *whipIngest) write(addr netip.AddrPort) bool {
+	if w == nil {
+		return false
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for _, session := range w.sessions {
+		session.mu.Lock()
+		ok := session.addr == addr
+		session.mu.Unlock()
+		if ok {
+			return true
+		}
+	}
+	return false
+}
+
+func newWHIPID() string {
+	var b [8]byte
+	rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+// opusDecoder decodes Opus RTP packets to the format of cfg through ffmpeg,
+// fed an Ogg stream of them, logging what ffmpeg complains of to log.
+type opusDecoder struct {
+	cfg    *Config
+	cmd    *exec.Cmd
+	ogg    *oggwriter.OggWriter
+	stdout io.ReadCloser
+}
+
+func newOpusDecoder(cfg *Config, channels uint16, log *slog.Logger) (*opusDecoder, error) {
+	if channels == 0 {
+		channels = 2
+	}
+	pcm := "s" + strconv.Itoa(cfg.bitDepth) + "be" // As RTP has it
+	cmd := exec.Command("ffmpeg", "-hide_banner", "-loglevel", "error", "-nostdin",
+		"-f", "ogg", "-i", "pipe:0",
+		"-f", pcm, "-ar", strconv.Itoa(cfg.sampleRate), "-ac", strconv.Itoa(cfg.channels), "pipe:1")
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start ffmpeg: %w", err)
+	}
+	go func() {
+		scanner := bufio.NewScanner(stderr)
+		for scanner.Scan() {
+			log.Warn("ffmpeg", "stderr", scanner.Text())
+		}
+	}()
+	ogg, err := oggwriter.NewWith(stdin, opusRate, channels)
+	if err != nil {
+		stdin.Close()
+		cmd.Wait()
+		return nil, err
+	}
+	return &opusDecoder{cfg: cfg, cmd: cmd, ogg: ogg, stdout: stdout}, nil
+}
+
+func (d *opusDecoder) write(pkt *rtp.Packet) error {
+	if len(pkt.Payload) == 0 {
+		return nil // Padding, such as a probe for bandwidth
+	}
+	return d.ogg.WriteRTP(pkt)
+}
+
+// packets hands the decoded audio to handle in L16 RTP packets of 20 ms
+// with ssrc, until ffmpeg is done.
+func (d *opusDecoder) packets(ssrc uint32, handle func([]byte)) {
+	frameSamples := d.cfg.sampleRate / 50
+	frame := make([]byte, frameSamples*d.cfg.channels*d.cfg.bitDepth/8)
+	p := rtp.Packet{Header: rtp.Header{Version: 2, PayloadType: l16PayloadType, SSRC: ssrc}}
+	buf := make([]byte, 0, 12+len(frame))
+	for {
+		if _, err := io.ReadFull(d.stdout, frame); err != nil {
+			return
+		}
+		p.Payload = frame
+		b, err := p.Header.MarshalTo(buf[:cap(buf)])
+		if err != nil {
+			return
+		}
+		handle(append(buf[:b], frame...))
+		p.SequenceNumber++
+		p.Timestamp += uint32(frameSamples)
+	}
+}
+
+// close lets ffmpeg decode what it was fed and waits for it.
+func (d *opusDecoder) close() {
+	d.ogg.Close() // Closes ffmpeg's stdin
+	d.cmd.Wait()  // What went wrong is on its stderr, logged
+}
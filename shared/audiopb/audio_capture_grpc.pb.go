@@ -0,0 +1,163 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.5.1
+// - protoc             (unknown)
+// source: audio_capture.proto
+
+package audiopb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	AudioCapture_ListSessions_FullMethodName = "/audiocapture.v1.AudioCapture/ListSessions"
+	AudioCapture_Subscribe_FullMethodName    = "/audiocapture.v1.AudioCapture/Subscribe"
+)
+
+// AudioCaptureClient is the client API for AudioCapture service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type AudioCaptureClient interface {
+	ListSessions(ctx context.Context, in *ListSessionsRequest, opts ...grpc.CallOption) (*ListSessionsResponse, error)
+	Subscribe(ctx context.Context, in *SubscribeRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[SessionEvent], error)
+}
+
+type audioCaptureClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewAudioCaptureClient(cc grpc.ClientConnInterface) AudioCaptureClient {
+	return &audioCaptureClient{cc}
+}
+
+func (c *audioCaptureClient) ListSessions(ctx context.Context, in *ListSessionsRequest, opts ...grpc.CallOption) (*ListSessionsResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ListSessionsResponse)
+	err := c.cc.Invoke(ctx, AudioCapture_ListSessions_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *audioCaptureClient) Subscribe(ctx context.Context, in *SubscribeRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[SessionEvent], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &AudioCapture_ServiceDesc.Streams[0], AudioCapture_Subscribe_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[SubscribeRequest, SessionEvent]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type AudioCapture_SubscribeClient = grpc.ServerStreamingClient[SessionEvent]
+
+// AudioCaptureServer is the server API for AudioCapture service.
+// All implementations must embed UnimplementedAudioCaptureServer
+// for forward compatibility.
+type AudioCaptureServer interface {
+	ListSessions(context.Context, *ListSessionsRequest) (*ListSessionsResponse, error)
+	Subscribe(*SubscribeRequest, grpc.ServerStreamingServer[SessionEvent]) error
+	mustEmbedUnimplementedAudioCaptureServer()
+}
+
+// UnimplementedAudioCaptureServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedAudioCaptureServer struct{}
+
+func (UnimplementedAudioCaptureServer) ListSessions(context.Context, *ListSessionsRequest) (*ListSessionsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListSessions not implemented")
+}
+func (UnimplementedAudioCaptureServer) Subscribe(*SubscribeRequest, grpc.ServerStreamingServer[SessionEvent]) error {
+	return status.Errorf(codes.Unimplemented, "method Subscribe not implemented")
+}
+func (UnimplementedAudioCaptureServer) mustEmbedUnimplementedAudioCaptureServer() {}
+func (UnimplementedAudioCaptureServer) testEmbeddedByValue()                      {}
+
+// UnsafeAudioCaptureServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to AudioCaptureServer will
+// result in compilation errors.
+type UnsafeAudioCaptureServer interface {
+	mustEmbedUnimplementedAudioCaptureServer()
+}
+
+func RegisterAudioCaptureServer(s grpc.ServiceRegistrar, srv AudioCaptureServer) {
+	// If the following call pancis, it indicates UnimplementedAudioCaptureServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&AudioCapture_ServiceDesc, srv)
+}
+
+func _AudioCapture_ListSessions_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListSessionsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AudioCaptureServer).ListSessions(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: AudioCapture_ListSessions_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AudioCaptureServer).ListSessions(ctx, req.(*ListSessionsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _AudioCapture_Subscribe_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(SubscribeRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(AudioCaptureServer).Subscribe(m, &grpc.GenericServerStream[SubscribeRequest, SessionEvent]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type AudioCapture_SubscribeServer = grpc.ServerStreamingServer[SessionEvent]
+
+// AudioCapture_ServiceDesc is the grpc.ServiceDesc for AudioCapture service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var AudioCapture_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "audiocapture.v1.AudioCapture",
+	HandlerType: (*AudioCaptureServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "ListSessions",
+			Handler:    _AudioCapture_ListSessions_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Subscribe",
+			Handler:       _AudioCapture_Subscribe_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "audio_capture.proto",
+}
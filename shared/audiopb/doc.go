@@ -0,0 +1,7 @@
+// Package audiopb holds the protocol buffers and gRPC services of the
+// -grpc-addr APIs: AudioCapture, the server's live audio, generated from
+// audio_capture.proto, and Control, the sessions of the client and the
+// server, generated from control.proto.
+package audiopb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative audio_capture.proto control.proto
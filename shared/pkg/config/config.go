@@ -0,0 +1,134 @@
+// Package config fills the options of a program's command line from the
+// environment and a config file too, the same way for the client and the
+// server. A flag given on the command line wins over its environment
+// variable, which wins over the config file, which wins over the default:
+//
+//	-max-file-size=2GB                   on the command line
+//	AUDIO_CAPTURE_MAX_FILE_SIZE=2GB      in the environment
+//	max-file-size = 2GB                  in the file of -config
+//
+// The config file has an option on each line, as name = value with the name
+// of the flag, and comments from #. A value may be double-quoted, as a Go
+// string. Repeatable flags take a line for each value.
+package config
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// EnvPrefix starts the environment variables of the options.
+const EnvPrefix = "AUDIO_CAPTURE_"
+
+// Setting is the value an option ended up with.
+type Setting struct {
+	Name   string
+	Value  string
+	Source string // flag, env with the variable, file with the line, or default
+}
+
+// EnvName returns the environment variable of the flag name, e.g.
+// AUDIO_CAPTURE_MAX_FILE_SIZE for max-file-size.
+func EnvName(name string) string {
+	return EnvPrefix + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+// Parse parses args into fs, then sets the flags left out from their
+// environment variables and then from the config file named by the flag
+// "config", if fs has one and it is set, by either. It returns where every
+// flag's value came from, sorted by name.
+func Parse(fs *flag.FlagSet, args []string) ([]Setting, error) {
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	sources := make(map[string]string)
+	fs.Visit(func(f *flag.Flag) { sources[f.Name] = "flag" })
+
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		if _, ok := sources[f.Name]; ok || err != nil {
+			return
+		}
+		env := EnvName(f.Name)
+		if v, ok := os.LookupEnv(env); ok {
+			if err = fs.Set(f.Name, v); err != nil {
+				err = fmt.Errorf("invalid value %q for %s: %w", v, env, err)
+				return
+			}
+			sources[f.Name] = "env " + env
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if f := fs.Lookup("config"); f != nil && f.Value.String() != "" {
+		if err := parseFile(fs, f.Value.String(), sources); err != nil {
+			return nil, err
+		}
+	}
+
+	var settings []Setting
+	fs.VisitAll(func(f *flag.Flag) {
+		source, ok := sources[f.Name]
+		if !ok {
+			source = "default"
+		}
+		settings = append(settings, Setting{Name: f.Name, Value: f.Value.String(), Source: source})
+	})
+	return settings, nil
+}
+
+// parseFile sets the flags of the config file at path not set by the
+// command line or the environment.
+func parseFile(fs *flag.FlagSet, path string, sources map[string]string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("reading the config file failed: %w", err)
+	}
+	defer file.Close()
+
+	given := make(map[string]bool) // Set by the command line or the environment
+	for name := range sources {
+		given[name] = true
+	}
+	scanner := bufio.NewScanner(file)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		name, value, ok := strings.Cut(line, "=")
+		name, value = strings.TrimSpace(name), strings.TrimSpace(value)
+		where := fmt.Sprintf("%s:%d", path, n)
+		switch {
+		case !ok:
+			return fmt.Errorf("%s: expected name = value", where)
+		case name == "config":
+			return fmt.Errorf("%s: the config file can't name another", where)
+		case fs.Lookup(name) == nil:
+			return fmt.Errorf("%s: unknown option %q", where, name)
+		case given[name]:
+			continue
+		}
+		if strings.HasPrefix(value, `"`) {
+			if value, err = strconv.Unquote(value); err != nil {
+				return fmt.Errorf("%s: invalid quoted value", where)
+			}
+		} else if i := strings.Index(value, " #"); i >= 0 {
+			value = strings.TrimSpace(value[:i])
+		}
+		if err := fs.Set(name, value); err != nil {
+			return fmt.Errorf("%s: invalid value %q for %s: %w", where, value, name, err)
+		}
+		sources[name] = "file " + where
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("reading the config file failed: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,68 @@
+// Package dscp marks the packets of a socket with a Differentiated Services
+// code point (RFC 2474), the same way in the client and the server, so that
+// routers and switches with a QoS policy queue the audio ahead of bulk
+// traffic. EF, expedited forwarding (RFC 3246), is what voice is usually
+// marked with; networks without a policy carry the mark along untouched.
+package dscp
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// names are the code points of RFC 4594, by name.
+var names = map[string]int{
+	"CS0": 0, "CS1": 8, "CS2": 16, "CS3": 24, "CS4": 32, "CS5": 40, "CS6": 48, "CS7": 56,
+	"AF11": 10, "AF12": 12, "AF13": 14,
+	"AF21": 18, "AF22": 20, "AF23": 22,
+	"AF31": 26, "AF32": 28, "AF33": 30,
+	"AF41": 34, "AF42": 36, "AF43": 38,
+	"VA": 44, "EF": 46, "LE": 1,
+}
+
+// Class is a code point, 0 to 63, and a flag.Value taking its name, such as
+// EF or AF41, or its number. The zero Class, CS0, is the default of
+// unmarked packets.
+type Class int
+
+func (c *Class) String() string {
+	if c == nil {
+		return "CS0"
+	}
+	for name, v := range names {
+		if v == int(*c) {
+			return name
+		}
+	}
+	return strconv.Itoa(int(*c))
+}
+
+func (c *Class) Set(s string) error {
+	if v, ok := names[strings.ToUpper(s)]; ok {
+		*c = Class(v)
+		return nil
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil || v < 0 || v > 63 {
+		return fmt.Errorf("invalid DSCP %q (use a name such as EF, AF41 or CS5, or a number from 0 to 63)", s)
+	}
+	*c = Class(v)
+	return nil
+}
+
+// Mark sets the DS field of what the socket of c sends to class: the IPv4
+// TOS byte and the IPv6 traffic class, whichever the socket's family has, or
+// both for a dual-stack socket. The ECN bits are left at 0.
+func Mark(c syscall.Conn, class Class) error {
+	raw, err := c.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var serr error
+	if err := raw.Control(func(fd uintptr) { serr = mark(fd, int(class)<<2) }); err != nil {
+		return err
+	}
+	return serr
+}
@@ -0,0 +1,11 @@
+//go:build !unix
+
+package dscp
+
+import "errors"
+
+// Windows only marks packets by a QoS policy of the system, not a socket
+// option.
+func mark(fd uintptr, tos int) error {
+	return errors.New("DSCP marking isn't supported on this platform")
+}
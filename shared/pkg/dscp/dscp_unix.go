@@ -0,0 +1,14 @@
+//go:build unix
+
+package dscp
+
+import "golang.org/x/sys/unix"
+
+func mark(fd uintptr, tos int) error {
+	err4 := unix.SetsockoptInt(int(fd), unix.IPPROTO_IP, unix.IP_TOS, tos)
+	err6 := unix.SetsockoptInt(int(fd), unix.IPPROTO_IPV6, unix.IPV6_TCLASS, tos)
+	if err4 != nil && err6 != nil {
+		return err4
+	}
+	return nil
+}
@@ -0,0 +1,46 @@
+// Package formatext encodes the format extension of the client's
+// -format-ext: an RTP header extension (RFC 8285) carrying the stream's
+// format as encoding/rate/channels like an SDP rtpmap, e.g. L16/48000/2,
+// attached to a packet now and then so a receiver knows the format
+// whenever it joins. Without SDP to map it, its ID is fixed.
+package formatext
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ID is the extension's ID, in the one-byte header of RFC 8285.
+const ID = 14
+
+// Format is the format an extension carries.
+type Format struct {
+	Encoding   string // Upper case, e.g. L16
+	SampleRate int
+	Channels   int
+}
+
+// Marshal encodes the data of the extension.
+func (f Format) Marshal() []byte {
+	return []byte(fmt.Sprintf("%s/%d/%d", strings.ToUpper(f.Encoding), f.SampleRate, f.Channels))
+}
+
+// Unmarshal decodes the data of an extension, or returns false when it
+// isn't three fields, the last two positive numbers. Which encodings,
+// rates and channels are taken is the receiver's to check.
+func Unmarshal(data []byte) (Format, bool) {
+	fields := strings.Split(string(data), "/")
+	if len(fields) != 3 || fields[0] == "" {
+		return Format{}, false
+	}
+	f := Format{Encoding: strings.ToUpper(fields[0])}
+	var err error
+	if f.SampleRate, err = strconv.Atoi(fields[1]); err != nil || f.SampleRate <= 0 {
+		return Format{}, false
+	}
+	if f.Channels, err = strconv.Atoi(fields[2]); err != nil || f.Channels <= 0 {
+		return Format{}, false
+	}
+	return f, true
+}
@@ -0,0 +1,22 @@
+package formatext
+
+import "testing"
+
+func TestRoundTrip(t *testing.T) {
+	f := Format{Encoding: "l16", SampleRate: 48000, Channels: 2}
+	data := f.Marshal()
+	if string(data) != "L16/48000/2" {
+		t.Fatalf("marshalled %q, want L16/48000/2", data)
+	}
+	if got, ok := Unmarshal(data); !ok || got != (Format{Encoding: "L16", SampleRate: 48000, Channels: 2}) {
+		t.Errorf("unmarshalled %+v, %v", got, ok)
+	}
+}
+
+func TestUnmarshalInvalid(t *testing.T) {
+	for _, data := range []string{"", "L16/48000", "L16/48000/2/1", "/48000/2", "L16/x/2", "L16/48000/0", "L16/-8000/1"} {
+		if f, ok := Unmarshal([]byte(data)); ok {
+			t.Errorf("%q unmarshalled to %+v", data, f)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+// Package handshake encodes the format handshake of the client and the
+// server: an RTCP APP packet (RFC 3550 section 6.7) named ACAP, whose data
+// is a format as key=value pairs separated by semicolons, e.g.
+// v=1;sw=audio-capture-client;enc=L16;rate=48000;ch=2;bits=16. A sender
+// offers its format before its first RTP packet, and again until it is
+// answered, and the receiver answers with its own and the result: accepted,
+// adapted or rejected, with the reason unless accepted.
+package handshake
+
+import (
+	"encoding/binary"
+	"strings"
+)
+
+const (
+	Version = 1
+	Name    = "ACAP"
+
+	// APP subtypes
+	Offer  = 0
+	Answer = 1
+)
+
+const (
+	rtcpRR  = 201
+	rtcpAPP = 204
+)
+
+// Marshal encodes a compound RTCP packet of an empty receiver report from
+// ssrc, as every compound packet starts with a report, and an APP packet of
+// subtype from ssrc with data, the pairs, padded to 32 bits. A receiver
+// answers from SSRC 0.
+func Marshal(ssrc uint32, subtype uint8, data string) []byte {
+	padded := len(data) + (4-len(data)%4)%4
+	b := make([]byte, 8+12+padded)
+	b[0] = 2 << 6 // Version 2, no report blocks
+	b[1] = rtcpRR
+	binary.BigEndian.PutUint16(b[2:], 1)
+	binary.BigEndian.PutUint32(b[4:], ssrc)
+	app := b[8:]
+	app[0] = 2<<6 | subtype
+	app[1] = rtcpAPP
+	binary.BigEndian.PutUint16(app[2:], uint16(len(app)/4-1))
+	binary.BigEndian.PutUint32(app[4:], ssrc)
+	copy(app[8:12], Name)
+	copy(app[12:], data)
+	return b
+}
+
+// Unmarshal returns the subtype and the pairs of pkt, one packet of a
+// compound RTCP packet, or false when it isn't a handshake. A key given
+// twice has the last value.
+func Unmarshal(pkt []byte) (subtype uint8, pairs map[string]string, ok bool) {
+	if len(pkt) < 12 || pkt[0]>>6 != 2 || pkt[1] != rtcpAPP || string(pkt[8:12]) != Name {
+		return 0, nil, false
+	}
+	pairs = make(map[string]string)
+	for _, pair := range strings.Split(strings.TrimRight(string(pkt[12:]), "\x00"), ";") {
+		if key, value, _ := strings.Cut(pair, "="); key != "" {
+			pairs[key] = value
+		}
+	}
+	return pkt[0] & 0x1f, pairs, true
+}
+
+// Value makes s fit as the value of a pair, such as a reason, replacing its
+// semicolons with commas.
+func Value(s string) string { return strings.ReplaceAll(s, ";", ",") }
@@ -0,0 +1,38 @@
+package handshake
+
+import (
+	"encoding/hex"
+	"maps"
+	"testing"
+)
+
+// TestMarshal lays out an offer field for field: the empty receiver
+// report, then the APP packet, its data padded with zeros to 32 bits.
+func TestMarshal(t *testing.T) {
+	got := hex.EncodeToString(Marshal(0x11223344, Offer, "v=1;rate=8000"))
+	want := "80c90001" + "11223344" + // Receiver report, no blocks
+		"80cc0006" + "11223344" + "41434150" + // APP, subtype 0, ACAP
+		hex.EncodeToString([]byte("v=1;rate=8000")) + "000000"
+	if got != want {
+		t.Errorf("got  %s\nwant %s", got, want)
+	}
+}
+
+func TestUnmarshal(t *testing.T) {
+	pkt := Marshal(0, Answer, "v=1;enc=L16;result=rejected;reason="+Value("48000 Hz; 2 channels"))
+	subtype, pairs, ok := Unmarshal(pkt[8:])
+	want := map[string]string{"v": "1", "enc": "L16", "result": "rejected", "reason": "48000 Hz, 2 channels"}
+	if !ok || subtype != Answer || !maps.Equal(pairs, want) {
+		t.Errorf("got subtype %d, %v, %v; want %d, %v", subtype, pairs, ok, Answer, want)
+	}
+
+	for name, pkt := range map[string][]byte{
+		"receiver report": pkt[:8],
+		"other APP":       append(append([]byte{}, pkt[8:16]...), "ABCD"...),
+		"too short":       pkt[8:18],
+	} {
+		if _, _, ok := Unmarshal(pkt); ok {
+			t.Errorf("a %s was taken for a handshake", name)
+		}
+	}
+}
@@ -0,0 +1,153 @@
+// Package ice has what the client and the server share of a minimal ICE
+// (RFC 8445) exchange, in which the server is a lite agent and the client
+// the controlling one. Instead of being given a host:port, the client posts
+// a Description of itself to the server's POST /ice and gets the server's
+// back: the addresses, or candidates, its RTP port may be reached at, and
+// the credentials of the connectivity checks. The client sends a check, a
+// STUN Binding request signed with the server's password, from the port it
+// streams from to each candidate, and streams to the best one answering; a
+// lite agent only answers.
+package ice
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"net"
+	"net/netip"
+
+	"github.com/fcerini/audio-capture-shared/pkg/stun"
+)
+
+// Candidate types, from the most preferred
+const (
+	Host  = "host"  // An address of an interface
+	Srflx = "srflx" // A reflexive address, learned over STUN
+)
+
+// Connectivity check attributes
+const (
+	attrPriority       = 0x0024
+	attrUseCandidate   = 0x0025
+	attrIceControlling = 0x802a
+
+	// The PRIORITY of checks: that of the peer-reflexive candidate the check
+	// would reveal, preferred over a server reflexive one
+	checkPriority = 110<<24 | 65535<<8 | 255
+)
+
+// Candidate is an address an agent may be reached at.
+type Candidate struct {
+	Type     string         `json:"type"`
+	Addr     netip.AddrPort `json:"addr"`
+	Priority uint32         `json:"priority"`
+}
+
+// Description is what an agent tells the other of itself.
+type Description struct {
+	Ufrag      string      `json:"ufrag"`
+	Pwd        string      `json:"pwd"`
+	Lite       bool        `json:"ice_lite,omitempty"`
+	Candidates []Candidate `json:"candidates"`
+}
+
+// NewDescription returns a description with new credentials and no
+// candidates.
+func NewDescription() Description {
+	return Description{Ufrag: random(4), Pwd: random(12)}
+}
+
+func random(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Add adds a candidate of typ at addr, the nth of its type, with the
+// priority of RFC 8445 section 5.1.2: by type, then IPv6 over IPv4 and the
+// earlier over the later, for the one component of RTP with rtcp-mux.
+func (d *Description) Add(typ string, addr netip.AddrPort, n int) {
+	typePref := uint32(126)
+	if typ == Srflx {
+		typePref = 100
+	}
+	local := uint32(32768 - min(n, 32767))
+	if addr.Addr().Unmap().Is6() {
+		local += 32767
+	}
+	d.Candidates = append(d.Candidates, Candidate{Type: typ, Addr: addr, Priority: typePref<<24 | min(local, 65535)<<8 | 255})
+}
+
+// HostAddrs returns the addresses of the interfaces that are up, but for
+// loopback and link-local ones, at port; only those of network's family for
+// udp4 and udp6.
+func HostAddrs(network string, port int) []netip.AddrPort {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return nil
+	}
+	var out []netip.AddrPort
+	for _, a := range addrs {
+		prefix, err := netip.ParsePrefix(a.String())
+		if err != nil {
+			continue
+		}
+		ip := prefix.Addr()
+		switch {
+		case ip.IsLoopback(), ip.IsLinkLocalUnicast(), network == "udp4" && !ip.Is4(), network == "udp6" && !ip.Is6():
+			continue
+		}
+		out = append(out, netip.AddrPortFrom(ip, uint16(port)))
+	}
+	return out
+}
+
+// Check returns a connectivity check from the agent of local to that of
+// remote, with transaction id, nominating the pair it is sent on as the
+// controlling agent does when nominating aggressively.
+func Check(id stun.TxID, local, remote Description, tiebreaker uint64) []byte {
+	return stun.Build(stun.BindingRequest, id, []stun.Attr{
+		{Type: stun.AttrUsername, Value: []byte(remote.Ufrag + ":" + local.Ufrag)},
+		{Type: attrPriority, Value: binary.BigEndian.AppendUint32(nil, checkPriority)},
+		{Type: attrIceControlling, Value: binary.BigEndian.AppendUint64(nil, tiebreaker)},
+		{Type: attrUseCandidate},
+	}, []byte(remote.Pwd))
+}
+
+// Answer answers the connectivity check req from from to the agent of
+// local, signed with its password. It fails for a check to other
+// credentials or one not signed with them.
+func Answer(req []byte, from netip.AddrPort, local Description) ([]byte, error) {
+	id := stun.ID(req)
+	ufrag, _, _ := splitUsername(req)
+	if ufrag != local.Ufrag {
+		return nil, errors.New("check for other credentials")
+	}
+	if !stun.Verify(req, []byte(local.Pwd)) {
+		return nil, errors.New("check not signed with the password")
+	}
+	return stun.Build(stun.BindingResponse, id, []stun.Attr{{Type: stun.AttrXORMappedAddress, Value: stun.XORAddr(from, id)}}, []byte(local.Pwd)), nil
+}
+
+// Ufrag returns the username fragment a connectivity check is to, and
+// whether it is one.
+func Ufrag(req []byte) (string, bool) {
+	ufrag, _, ok := splitUsername(req)
+	return ufrag, ok
+}
+
+// splitUsername splits the USERNAME of a check, the ufrag of the agent it
+// is to, a colon and that of the agent it is from.
+func splitUsername(req []byte) (to, from string, ok bool) {
+	username, ok := stun.Attrs(req)[stun.AttrUsername]
+	if !ok {
+		return "", "", false
+	}
+	for i, c := range username {
+		if c == ':' {
+			return string(username[:i]), string(username[i+1:]), true
+		}
+	}
+	return "", "", false
+}
@@ -0,0 +1,229 @@
+package mdns
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"net/netip"
+	"strings"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// Auto is the instance Lookup takes for whichever server answers first.
+const Auto = "auto"
+
+// queryTimes are when the queries of Lookup are sent, from the start.
+var queryTimes = []time.Duration{0, 250 * time.Millisecond, time.Second}
+
+// Lookup asks the network for the server named instance, or the first one
+// to answer for Auto, until ctx is done. It queries as a legacy unicast
+// querier (RFC 6762 section 6.7), from a port of its own, so it works
+// alongside the system's responder.
+func Lookup(ctx context.Context, instance string) (Service, error) {
+	want := strings.ToLower(label(instance))
+	if instance == Auto {
+		want = ""
+	}
+
+	type message struct {
+		b    []byte
+		from netip.AddrPort
+	}
+	got := make(chan message, 16)
+	var conns []*net.UDPConn
+	for _, network := range []string{"udp4", "udp6"} {
+		c, err := net.ListenUDP(network, nil)
+		if err != nil {
+			continue
+		}
+		defer c.Close()
+		conns = append(conns, c)
+		go func() {
+			buf := make([]byte, mtu)
+			for {
+				n, from, err := c.ReadFromUDPAddrPort(buf)
+				if err != nil {
+					return
+				}
+				select {
+				case got <- message{append([]byte(nil), buf[:n]...), from}:
+				default:
+				}
+			}
+		}()
+	}
+	if len(conns) == 0 {
+		return Service{}, errors.New("can't open a socket for mDNS")
+	}
+
+	questions := []dnsmessage.Question{{Name: serviceName, Type: dnsmessage.TypePTR, Class: dnsmessage.ClassINET}}
+	if want != "" {
+		name, err := instanceName(instance)
+		if err != nil {
+			return Service{}, fmt.Errorf("invalid server name %q: %w", instance, err)
+		}
+		questions = append(questions, dnsmessage.Question{Name: name, Type: dnsmessage.TypeSRV, Class: dnsmessage.ClassINET})
+	}
+	query := func(questions []dnsmessage.Question) {
+		b := dnsmessage.NewBuilder(nil, dnsmessage.Header{ID: uint16(rand.Uint32())})
+		b.StartQuestions()
+		for _, q := range questions {
+			b.Question(q)
+		}
+		msg, err := b.Finish()
+		if err != nil {
+			return
+		}
+		for _, c := range conns {
+			group := group4
+			if c.LocalAddr().(*net.UDPAddr).IP.To4() == nil {
+				group = group6
+			}
+			c.WriteToUDPAddrPort(msg, group) // Fails without a route for the family
+		}
+	}
+
+	found := newCache()
+	start := time.Now()
+	next := 0
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			if want == "" {
+				return Service{}, fmt.Errorf("no recording server answered over mDNS: %w", ctx.Err())
+			}
+			return Service{}, fmt.Errorf("recording server %q didn't answer over mDNS: %w", instance, ctx.Err())
+		case <-timer.C:
+			query(questions)
+			if next++; next < len(queryTimes) {
+				timer.Reset(time.Until(start.Add(queryTimes[next])))
+			}
+		case m := <-got:
+			hosts := found.add(m.b)
+			if svc, ok := found.complete(want, m.from); ok {
+				return svc, nil
+			}
+			// Ask for the addresses of hosts the answers didn't have
+			var more []dnsmessage.Question
+			for _, host := range hosts {
+				more = append(more,
+					dnsmessage.Question{Name: host, Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET},
+					dnsmessage.Question{Name: host, Type: dnsmessage.TypeAAAA, Class: dnsmessage.ClassINET})
+			}
+			if len(more) > 0 {
+				query(more)
+			}
+		}
+	}
+}
+
+// cache is what the answers told of the services and hosts so far.
+type cache struct {
+	order []string // Instances, in the order they were found
+	srv   map[string]dnsmessage.SRVResource
+	names map[string]string // The instances as they are written
+	txt   map[string][]string
+	addrs map[string][]netip.Addr // By host name, in lower case
+	asked map[string]bool         // Hosts whose addresses were asked for
+}
+
+func newCache() *cache {
+	return &cache{
+		srv: map[string]dnsmessage.SRVResource{}, names: map[string]string{}, txt: map[string][]string{},
+		addrs: map[string][]netip.Addr{}, asked: map[string]bool{},
+	}
+}
+
+// add takes the records of the response b, returning the hosts of new
+// services whose addresses are yet unknown.
+func (c *cache) add(b []byte) []dnsmessage.Name {
+	var p dnsmessage.Parser
+	h, err := p.Start(b)
+	if err != nil || !h.Response {
+		return nil
+	}
+	if err := p.SkipAllQuestions(); err != nil {
+		return nil
+	}
+	var records []dnsmessage.Resource
+	for _, all := range []func() ([]dnsmessage.Resource, error){p.AllAnswers, p.AllAuthorities, p.AllAdditionals} {
+		rs, err := all()
+		records = append(records, rs...)
+		if err != nil {
+			break
+		}
+	}
+	for _, r := range records {
+		name := strings.ToLower(r.Header.Name.String())
+		switch body := r.Body.(type) {
+		case *dnsmessage.SRVResource:
+			instance, ok := instanceOf(r.Header.Name)
+			if !ok {
+				continue
+			}
+			if _, seen := c.srv[instance]; !seen {
+				c.order = append(c.order, instance)
+			}
+			c.srv[instance] = *body
+			if written, ok := strings.CutSuffix(r.Header.Name.String(), "."+serviceName.String()); ok {
+				c.names[instance] = written
+			} else {
+				c.names[instance] = instance
+			}
+		case *dnsmessage.TXTResource:
+			if instance, ok := instanceOf(r.Header.Name); ok {
+				c.txt[instance] = body.TXT
+			}
+		case *dnsmessage.AResource:
+			c.addAddr(name, netip.AddrFrom4(body.A))
+		case *dnsmessage.AAAAResource:
+			c.addAddr(name, netip.AddrFrom16(body.AAAA))
+		}
+	}
+	var missing []dnsmessage.Name
+	for _, instance := range c.order {
+		target := c.srv[instance].Target
+		host := strings.ToLower(target.String())
+		if len(c.addrs[host]) == 0 && !c.asked[host] {
+			c.asked[host] = true
+			missing = append(missing, target)
+		}
+	}
+	return missing
+}
+
+func (c *cache) addAddr(host string, addr netip.Addr) {
+	for _, a := range c.addrs[host] {
+		if a == addr {
+			return
+		}
+	}
+	c.addrs[host] = append(c.addrs[host], addr)
+}
+
+// complete returns the service of want, or the first found for "", once its
+// host's addresses are known.
+func (c *cache) complete(want string, from netip.AddrPort) (Service, bool) {
+	for _, instance := range c.order {
+		if want != "" && instance != want {
+			continue
+		}
+		srv := c.srv[instance]
+		host := strings.ToLower(srv.Target.String())
+		if len(c.addrs[host]) == 0 {
+			continue
+		}
+		name, _ := strings.CutSuffix(srv.Target.String(), "."+domain)
+		return Service{
+			Instance: c.names[instance], Host: name, Port: int(srv.Port),
+			Addrs: c.addrs[host], Text: c.txt[instance], From: from,
+		}, true
+	}
+	return Service{}, false
+}
@@ -0,0 +1,132 @@
+package mdns
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"os"
+
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+var osHostname = os.Hostname
+
+// groupConn is a socket on port 5353 of one family, in the mDNS group on
+// every multicast interface, which tells the interface a packet came in on
+// where the system can.
+type groupConn interface {
+	// readFrom returns the index of the interface, 0 if unknown.
+	readFrom(b []byte) (n, ifIndex int, from netip.AddrPort, err error)
+	// writeTo sends on the interface of ifIndex, or the default one for 0.
+	writeTo(b []byte, ifIndex int, to netip.AddrPort) error
+	group() netip.AddrPort
+	Close() error
+}
+
+// listenGroup opens the socket of network, udp4 or udp6, sharing the port
+// with the system's responder, such as Avahi, with SO_REUSEADDR.
+func listenGroup(network string) (groupConn, []net.Interface, error) {
+	lc := net.ListenConfig{Control: reuseAddr}
+	pc, err := lc.ListenPacket(context.Background(), network, net.JoinHostPort("", "5353"))
+	if err != nil {
+		return nil, nil, err
+	}
+	ifaces := multicastInterfaces()
+	var joined []net.Interface
+	if network == "udp4" {
+		c := ipv4.NewPacketConn(pc)
+		c.SetControlMessage(ipv4.FlagInterface, true) // Not supported everywhere
+		c.SetMulticastLoopback(true)
+		for _, ifi := range ifaces {
+			if c.JoinGroup(&ifi, &net.UDPAddr{IP: group4.Addr().AsSlice()}) == nil {
+				joined = append(joined, ifi)
+			}
+		}
+		if len(joined) == 0 {
+			pc.Close()
+			return nil, nil, errNoInterface
+		}
+		return conn4{c}, joined, nil
+	}
+	c := ipv6.NewPacketConn(pc)
+	c.SetControlMessage(ipv6.FlagInterface, true)
+	c.SetMulticastLoopback(true)
+	for _, ifi := range ifaces {
+		if c.JoinGroup(&ifi, &net.UDPAddr{IP: group6.Addr().AsSlice()}) == nil {
+			joined = append(joined, ifi)
+		}
+	}
+	if len(joined) == 0 {
+		pc.Close()
+		return nil, nil, errNoInterface
+	}
+	return conn6{c}, joined, nil
+}
+
+// multicastInterfaces returns the interfaces that are up and multicast.
+func multicastInterfaces() []net.Interface {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil
+	}
+	var out []net.Interface
+	for _, ifi := range ifaces {
+		if ifi.Flags&net.FlagUp != 0 && ifi.Flags&net.FlagMulticast != 0 {
+			out = append(out, ifi)
+		}
+	}
+	return out
+}
+
+type conn4 struct{ *ipv4.PacketConn }
+
+func (c conn4) readFrom(b []byte) (int, int, netip.AddrPort, error) {
+	n, cm, from, err := c.ReadFrom(b)
+	ifIndex := 0
+	if cm != nil {
+		ifIndex = cm.IfIndex
+	}
+	return n, ifIndex, addrPort(from), err
+}
+
+func (c conn4) writeTo(b []byte, ifIndex int, to netip.AddrPort) error {
+	var cm *ipv4.ControlMessage
+	if ifIndex != 0 {
+		cm = &ipv4.ControlMessage{IfIndex: ifIndex}
+	}
+	_, err := c.WriteTo(b, cm, net.UDPAddrFromAddrPort(to))
+	return err
+}
+
+func (conn4) group() netip.AddrPort { return group4 }
+
+type conn6 struct{ *ipv6.PacketConn }
+
+func (c conn6) readFrom(b []byte) (int, int, netip.AddrPort, error) {
+	n, cm, from, err := c.ReadFrom(b)
+	ifIndex := 0
+	if cm != nil {
+		ifIndex = cm.IfIndex
+	}
+	return n, ifIndex, addrPort(from), err
+}
+
+func (c conn6) writeTo(b []byte, ifIndex int, to netip.AddrPort) error {
+	var cm *ipv6.ControlMessage
+	if ifIndex != 0 {
+		cm = &ipv6.ControlMessage{IfIndex: ifIndex}
+	}
+	_, err := c.WriteTo(b, cm, net.UDPAddrFromAddrPort(to))
+	return err
+}
+
+func (conn6) group() netip.AddrPort { return group6 }
+
+func addrPort(a net.Addr) netip.AddrPort {
+	if u, ok := a.(*net.UDPAddr); ok {
+		ap := u.AddrPort()
+		return netip.AddrPortFrom(ap.Addr().Unmap(), ap.Port())
+	}
+	return netip.AddrPort{}
+}
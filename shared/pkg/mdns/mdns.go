@@ -0,0 +1,138 @@
+// Package mdns finds recording servers on the local network without their
+// addresses, the same way in the client and the server: the server
+// advertises its RTP port as a DNS-SD service of type _rtpaudio._udp over
+// multicast DNS (RFC 6762 and 6763), and the client browses for it. A
+// server is an instance of the service with a name of its own, such as
+// studio, found at studio._rtpaudio._udp.local. Other DNS-SD tools see it
+// too:
+//
+//	avahi-browse -rt _rtpaudio._udp
+//	dns-sd -B _rtpaudio._udp
+package mdns
+
+import (
+	"net"
+	"net/netip"
+	"strings"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// ServiceType is the DNS-SD type servers are advertised as.
+const ServiceType = "_rtpaudio._udp"
+
+const (
+	port     = 5353
+	domain   = "local."
+	ttl      = 120 // Seconds answers are cached for
+	maxTTL   = 10  // For answers to legacy unicast queries (RFC 6762 section 6.7)
+	cacheBit = 1 << 15
+	mtu      = 9000 // Largest message read
+)
+
+var (
+	group4 = netip.MustParseAddrPort("224.0.0.251:5353")
+	group6 = netip.MustParseAddrPort("[ff02::fb]:5353")
+
+	serviceName = mustName(ServiceType + "." + domain)
+	metaName    = mustName("_services._dns-sd._udp." + domain) // Lists the types of the services advertised
+)
+
+// Service is a server found on the network, or one to advertise.
+type Service struct {
+	Instance string         // The server's name, such as studio
+	Host     string         // Its host name, without .local
+	Port     int            // Of the RTP port
+	Addrs    []netip.Addr   // The host's addresses
+	Text     []string       // key=value pairs, such as rate=48000
+	From     netip.AddrPort `json:"-"` // Where it answered from, when found
+}
+
+// AddrPort returns the address to stream to: the first of the host's
+// addresses of the family of From, or the first, at Port.
+func (s Service) AddrPort() (netip.AddrPort, bool) {
+	for _, a := range s.Addrs {
+		if a.Is4() == s.From.Addr().Unmap().Is4() {
+			return netip.AddrPortFrom(a, uint16(s.Port)), true
+		}
+	}
+	if len(s.Addrs) == 0 {
+		return netip.AddrPort{}, false
+	}
+	return netip.AddrPortFrom(s.Addrs[0], uint16(s.Port)), true
+}
+
+// instanceName is the DNS name of the service instance.
+func instanceName(instance string) (dnsmessage.Name, error) {
+	return dnsmessage.NewName(label(instance) + "." + ServiceType + "." + domain)
+}
+
+// hostName is the DNS name of the host.
+func hostName(host string) (dnsmessage.Name, error) {
+	return dnsmessage.NewName(label(host) + "." + domain)
+}
+
+// label turns s into one DNS label, as a dot would start another.
+func label(s string) string {
+	return strings.NewReplacer(".", "-", "\\", "-").Replace(s)
+}
+
+// instanceOf returns the instance of the DNS name of a service instance.
+func instanceOf(name dnsmessage.Name) (string, bool) {
+	return strings.CutSuffix(strings.ToLower(name.String()), "."+strings.ToLower(serviceName.String()))
+}
+
+// LocalHost returns the name the host is advertised by: its host name up to
+// the first dot.
+func LocalHost() string {
+	host, err := osHostname()
+	if err != nil || host == "" {
+		return "audio-capture"
+	}
+	host, _, _ = strings.Cut(host, ".")
+	return host
+}
+
+func mustName(s string) dnsmessage.Name {
+	n, err := dnsmessage.NewName(s)
+	if err != nil {
+		panic(err)
+	}
+	return n
+}
+
+func sameName(a, b dnsmessage.Name) bool {
+	return strings.EqualFold(a.String(), b.String())
+}
+
+// interfaceAddrs returns the addresses of ifi, or of every interface for
+// nil, but for loopback and link-local ones unless there are no others.
+func interfaceAddrs(ifi *net.Interface) []netip.Addr {
+	var addrs []net.Addr
+	var err error
+	if ifi != nil {
+		addrs, err = ifi.Addrs()
+	} else {
+		addrs, err = net.InterfaceAddrs()
+	}
+	if err != nil {
+		return nil
+	}
+	var out, fallback []netip.Addr
+	for _, a := range addrs {
+		prefix, err := netip.ParsePrefix(a.String())
+		if err != nil {
+			continue
+		}
+		ip := prefix.Addr()
+		if ip.IsLoopback() || ip.IsLinkLocalUnicast() {
+			fallback = append(fallback, ip)
+			continue
+		}
+		out = append(out, ip)
+	}
+	if len(out) == 0 {
+		return fallback
+	}
+	return out
+}
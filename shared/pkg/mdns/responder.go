@@ -0,0 +1,204 @@
+package mdns
+
+import (
+	"context"
+	"errors"
+	"net"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+var errNoInterface = errors.New("no multicast interface")
+
+const (
+	announcements = 2           // Unsolicited responses sent at first (RFC 6762 section 8.3)
+	announceGap   = time.Second // Between them
+)
+
+// responder answers for one service.
+type responder struct {
+	svc      Service
+	instance dnsmessage.Name
+	host     dnsmessage.Name
+}
+
+// Advertise answers the mDNS queries for svc, whose Addrs are left out and
+// taken from the interface each query comes in on, on every multicast
+// interface until ctx is done. It announces svc at first and says goodbye
+// at the end, so browsers see it come and go at once. It fails only when it
+// can listen on neither IPv4 nor IPv6.
+func Advertise(ctx context.Context, svc Service) error {
+	r := &responder{svc: svc}
+	var err error
+	if r.instance, err = instanceName(svc.Instance); err != nil {
+		return err
+	}
+	if r.host, err = hostName(svc.Host); err != nil {
+		return err
+	}
+
+	type listener struct {
+		c      groupConn
+		ifaces []net.Interface
+	}
+	var listeners []listener
+	var errs []error
+	for _, network := range []string{"udp4", "udp6"} {
+		c, ifaces, err := listenGroup(network)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		defer c.Close()
+		listeners = append(listeners, listener{c, ifaces})
+		go r.serve(c)
+	}
+	if len(listeners) == 0 {
+		return errors.Join(errs...)
+	}
+
+	announce := func(ttl uint32) {
+		for _, l := range listeners {
+			for _, ifi := range l.ifaces {
+				if b, err := r.response(0, nil, &ifi, ttl, true); err == nil {
+					l.c.writeTo(b, ifi.Index, l.c.group())
+				}
+			}
+		}
+	}
+	for i := 0; i < announcements; i++ {
+		announce(ttl)
+		select {
+		case <-ctx.Done():
+		case <-time.After(announceGap):
+		}
+	}
+	<-ctx.Done()
+	announce(0) // Goodbye
+	return nil
+}
+
+// serve answers the queries arriving on c until it is closed.
+func (r *responder) serve(c groupConn) {
+	buf := make([]byte, mtu)
+	for {
+		n, ifIndex, from, err := c.readFrom(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			continue
+		}
+		var p dnsmessage.Parser
+		h, err := p.Start(buf[:n])
+		if err != nil || h.Response {
+			continue // Other responders' answers, and ours
+		}
+		questions, err := p.AllQuestions()
+		if err != nil || !r.asked(questions) {
+			continue
+		}
+		var ifi *net.Interface
+		if ifIndex != 0 {
+			ifi, _ = net.InterfaceByIndex(ifIndex)
+		}
+		legacy := from.Port() != port
+		unicast := legacy
+		for _, q := range questions {
+			if q.Class&cacheBit != 0 { // Asking for a unicast answer
+				unicast = true
+			}
+		}
+		to := c.group()
+		if unicast {
+			to = from
+		}
+		answerTTL := uint32(ttl)
+		if legacy {
+			answerTTL = maxTTL
+		}
+		// A legacy querier gets its ID and questions back
+		var id uint16
+		var echo []dnsmessage.Question
+		if legacy {
+			id, echo = h.ID, questions
+		}
+		b, err := r.response(id, echo, ifi, answerTTL, !legacy)
+		if err != nil {
+			continue
+		}
+		c.writeTo(b, ifIndex, to)
+	}
+}
+
+// asked reports whether a question is for one of the service's names.
+func (r *responder) asked(questions []dnsmessage.Question) bool {
+	for _, q := range questions {
+		switch {
+		case sameName(q.Name, serviceName), sameName(q.Name, metaName):
+			if q.Type == dnsmessage.TypePTR || q.Type == dnsmessage.TypeALL {
+				return true
+			}
+		case sameName(q.Name, r.instance), sameName(q.Name, r.host):
+			return true
+		}
+	}
+	return false
+}
+
+// response returns the service's records, with the addresses of ifi or, for
+// nil, every interface, all as answers; a browser takes what it needs. The
+// cache-flush bit of the unique ones is set for multicast answers.
+func (r *responder) response(id uint16, questions []dnsmessage.Question, ifi *net.Interface, recordTTL uint32, flush bool) ([]byte, error) {
+	b := dnsmessage.NewBuilder(make([]byte, 0, 512), dnsmessage.Header{ID: id, Response: true, Authoritative: true})
+	b.EnableCompression()
+	if err := b.StartQuestions(); err != nil {
+		return nil, err
+	}
+	for _, q := range questions {
+		if err := b.Question(q); err != nil {
+			return nil, err
+		}
+	}
+	if err := b.StartAnswers(); err != nil {
+		return nil, err
+	}
+	shared := dnsmessage.ClassINET
+	unique := dnsmessage.ClassINET
+	if flush {
+		unique |= cacheBit
+	}
+	header := func(name dnsmessage.Name, class dnsmessage.Class) dnsmessage.ResourceHeader {
+		return dnsmessage.ResourceHeader{Name: name, Class: class, TTL: recordTTL}
+	}
+
+	if err := b.PTRResource(header(serviceName, shared), dnsmessage.PTRResource{PTR: r.instance}); err != nil {
+		return nil, err
+	}
+	if err := b.PTRResource(header(metaName, shared), dnsmessage.PTRResource{PTR: serviceName}); err != nil {
+		return nil, err
+	}
+	if err := b.SRVResource(header(r.instance, unique), dnsmessage.SRVResource{Port: uint16(r.svc.Port), Target: r.host}); err != nil {
+		return nil, err
+	}
+	text := r.svc.Text
+	if len(text) == 0 {
+		text = []string{""} // A TXT record has at least one string
+	}
+	if err := b.TXTResource(header(r.instance, unique), dnsmessage.TXTResource{TXT: text}); err != nil {
+		return nil, err
+	}
+	for _, addr := range interfaceAddrs(ifi) {
+		var err error
+		if addr.Is4() {
+			err = b.AResource(header(r.host, unique), dnsmessage.AResource{A: addr.As4()})
+		} else {
+			err = b.AAAAResource(header(r.host, unique), dnsmessage.AAAAResource{AAAA: addr.WithZone("").As16()})
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return b.Finish()
+}
@@ -0,0 +1,78 @@
+// Package ogg reads the packets of an Ogg stream (RFC 3533), as ffmpeg
+// writes when it encodes Opus for the client and the server.
+package ogg
+
+import (
+	"bufio"
+	"errors"
+	"io"
+)
+
+// ErrBadPage is returned for data that isn't an Ogg page.
+var ErrBadPage = errors.New("bad Ogg page")
+
+// Reader splits an Ogg stream of one logical bitstream into its packets.
+// Checksums aren't verified: the stream comes from a pipe, not a file.
+type Reader struct {
+	r        *bufio.Reader
+	segments []byte // Lacing values of the current page not read yet
+	packet   []byte
+}
+
+// NewReader reads the pages from r.
+func NewReader(r io.Reader) *Reader {
+	return &Reader{r: bufio.NewReader(r)}
+}
+
+// Packet returns the next packet, the codec's headers included, joining
+// packets that span pages. It returns io.EOF at the end of the stream, and
+// io.ErrUnexpectedEOF if it ends within a page.
+func (o *Reader) Packet() ([]byte, error) {
+	for {
+		if len(o.segments) == 0 {
+			if err := o.page(); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		size := o.segments[0]
+		o.segments = o.segments[1:]
+		start := len(o.packet)
+		o.packet = append(o.packet, make([]byte, size)...)
+		if _, err := io.ReadFull(o.r, o.packet[start:]); err != nil {
+			return nil, unexpected(err)
+		}
+		if size == 255 {
+			continue // Continued in the next segment
+		}
+		packet := o.packet
+		o.packet = nil
+		return packet, nil
+	}
+}
+
+// page reads the header of the next page.
+func (o *Reader) page() error {
+	var hdr [27]byte
+	if _, err := io.ReadFull(o.r, hdr[:]); err != nil {
+		if err == io.EOF && len(o.packet) == 0 {
+			return io.EOF
+		}
+		return unexpected(err)
+	}
+	if string(hdr[:4]) != "OggS" {
+		return ErrBadPage
+	}
+	o.segments = make([]byte, hdr[26])
+	if _, err := io.ReadFull(o.r, o.segments); err != nil {
+		return unexpected(err)
+	}
+	return nil
+}
+
+func unexpected(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
@@ -0,0 +1,57 @@
+package ogg
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+// page lays out an Ogg page holding the given lacing values and body.
+func page(lacing, body []byte) []byte {
+	hdr := make([]byte, 27)
+	copy(hdr, "OggS")
+	hdr[26] = byte(len(lacing))
+	return append(append(hdr, lacing...), body...)
+}
+
+func TestReader(t *testing.T) {
+	long := bytes.Repeat([]byte{'x'}, 300) // 255 + 45, over two pages
+	var stream []byte
+	stream = append(stream, page([]byte{8}, []byte("OpusHead"))...)
+	stream = append(stream, page([]byte{8, 3}, []byte("OpusTagsabc"))...)
+	stream = append(stream, page([]byte{255}, long[:255])...)
+	stream = append(stream, page([]byte{45, 0}, long[255:])...)
+
+	r := NewReader(bytes.NewReader(stream))
+	for _, want := range [][]byte{[]byte("OpusHead"), []byte("OpusTags"), []byte("abc"), long, {}} {
+		got, err := r.Packet()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Fatalf("read %q, want %q", got, want)
+		}
+	}
+	if _, err := r.Packet(); err != io.EOF {
+		t.Fatalf("read past the end: %v, want EOF", err)
+	}
+}
+
+func TestReaderErrors(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		stream []byte
+		want   error
+	}{
+		{"not a page", []byte("RIFF0000WAVEfmt 00000000000000000"), ErrBadPage},
+		{"truncated header", page(nil, nil)[:20], io.ErrUnexpectedEOF},
+		{"truncated body", page([]byte{8}, []byte("Opus")), io.ErrUnexpectedEOF},
+		{"unfinished packet", page([]byte{255}, bytes.Repeat([]byte{'x'}, 255)), io.ErrUnexpectedEOF},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewReader(bytes.NewReader(tt.stream)).Packet(); err != tt.want {
+				t.Fatalf("got %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
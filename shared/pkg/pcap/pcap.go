@@ -0,0 +1,179 @@
+// Package pcap writes the packets a program sends and receives to a pcap
+// file for analysis in Wireshark, as a capture on the host would show them,
+// without needing root for tcpdump, the same way for the client and the
+// server.
+package pcap
+
+import (
+	"bufio"
+	"encoding/binary"
+	"net/netip"
+	"os"
+	"sync"
+	"time"
+)
+
+// Writer writes the packets to a pcap file. The file has raw IP packets
+// (LINKTYPE_RAW) with the IP and UDP headers made up from the addresses. All
+// methods are safe on a nil writer, which records nothing.
+type Writer struct {
+	mu   sync.Mutex
+	f    *os.File
+	w    *bufio.Writer
+	stop chan struct{}
+	done chan struct{}
+}
+
+// Create creates the file, as for -debug-pcap, and flushes it every second,
+// so it can be opened while the program runs.
+func Create(path string) (*Writer, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	p := &Writer{f: f, w: bufio.NewWriter(f), stop: make(chan struct{}), done: make(chan struct{})}
+
+	var hdr [24]byte
+	binary.LittleEndian.PutUint32(hdr[0:], 0xa1b2c3d4) // Microsecond timestamps
+	binary.LittleEndian.PutUint16(hdr[4:], 2)          // Version 2.4
+	binary.LittleEndian.PutUint16(hdr[6:], 4)
+	binary.LittleEndian.PutUint32(hdr[16:], 65535) // Snapshot length
+	binary.LittleEndian.PutUint32(hdr[20:], 101)   // LINKTYPE_RAW
+	p.w.Write(hdr[:])
+
+	go func() {
+		defer close(p.done)
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-p.stop:
+				return
+			case <-ticker.C:
+				p.mu.Lock()
+				p.w.Flush()
+				p.mu.Unlock()
+			}
+		}
+	}()
+	return p, nil
+}
+
+// WriteUDP records a UDP datagram from src to dst, sent or received at t.
+func (p *Writer) WriteUDP(src, dst netip.AddrPort, payload []byte, t time.Time) {
+	if p == nil {
+		return
+	}
+	pkt := ipUDPPacket(src, dst, payload)
+	var rec [16]byte
+	binary.LittleEndian.PutUint32(rec[0:], uint32(t.Unix()))
+	binary.LittleEndian.PutUint32(rec[4:], uint32(t.Nanosecond()/1000))
+	binary.LittleEndian.PutUint32(rec[8:], uint32(len(pkt)))
+	binary.LittleEndian.PutUint32(rec[12:], uint32(len(pkt)))
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.w.Write(rec[:])
+	p.w.Write(pkt)
+}
+
+// Close flushes and closes the file.
+func (p *Writer) Close() error {
+	if p == nil {
+		return nil
+	}
+	close(p.stop)
+	<-p.done
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if err := p.w.Flush(); err != nil {
+		p.f.Close()
+		return err
+	}
+	return p.f.Close()
+}
+
+// ipUDPPacket wraps payload in the IPv4 or IPv6 and UDP headers it would
+// have had on the wire.
+func ipUDPPacket(src, dst netip.AddrPort, payload []byte) []byte {
+	s, d := src.Addr().Unmap(), dst.Addr().Unmap()
+	if s.Is4() != d.Is4() {
+		// One end is the unspecified address of a dual-stack socket
+		s, d = matchFamily(s, d), matchFamily(d, s)
+	}
+	udpLen := 8 + len(payload)
+
+	var b []byte
+	if s.Is4() {
+		b = make([]byte, 20+udpLen)
+		b[0] = 0x45 // Version 4, 20-byte header
+		binary.BigEndian.PutUint16(b[2:], uint16(len(b)))
+		binary.BigEndian.PutUint16(b[6:], 0x4000) // Don't fragment
+		b[8] = 64                                 // TTL
+		b[9] = 17                                 // UDP
+		s4, d4 := s.As4(), d.As4()
+		copy(b[12:], s4[:])
+		copy(b[16:], d4[:])
+		binary.BigEndian.PutUint16(b[10:], ^checksum(b[:20], 0))
+	} else {
+		b = make([]byte, 40+udpLen)
+		b[0] = 6 << 4
+		binary.BigEndian.PutUint16(b[4:], uint16(udpLen))
+		b[6] = 17 // UDP
+		b[7] = 64 // Hop limit
+		s16, d16 := s.As16(), d.As16()
+		copy(b[8:], s16[:])
+		copy(b[24:], d16[:])
+	}
+
+	ipLen := len(b) - udpLen
+	udp := b[ipLen:]
+	binary.BigEndian.PutUint16(udp[0:], src.Port())
+	binary.BigEndian.PutUint16(udp[2:], dst.Port())
+	binary.BigEndian.PutUint16(udp[4:], uint16(udpLen))
+	copy(udp[8:], payload)
+
+	// The UDP checksum covers a pseudo-header of the addresses, protocol
+	// and length
+	var sum uint32
+	if s.Is4() {
+		sum = uint32(checksum(b[12:20], 0))
+	} else {
+		sum = uint32(checksum(b[8:40], 0))
+	}
+	sum += 17 + uint32(udpLen)
+	c := ^checksum(udp, sum)
+	if c == 0 {
+		c = 0xffff
+	}
+	binary.BigEndian.PutUint16(udp[6:], c)
+	return b
+}
+
+// matchFamily returns the unspecified address of other's family for an
+// unspecified a.
+func matchFamily(a, other netip.Addr) netip.Addr {
+	switch {
+	case !a.IsUnspecified():
+		return a
+	case other.Is4():
+		return netip.IPv4Unspecified()
+	default:
+		return netip.IPv6Unspecified()
+	}
+}
+
+// checksum adds b to the one's complement sum started with sum, and folds
+// it to 16 bits.
+func checksum(b []byte, sum uint32) uint16 {
+	for i := 0; i+1 < len(b); i += 2 {
+		sum += uint32(b[i])<<8 | uint32(b[i+1])
+	}
+	if len(b)%2 == 1 {
+		sum += uint32(b[len(b)-1]) << 8
+	}
+	for sum > 0xffff {
+		sum = sum>>16 + sum&0xffff
+	}
+	return uint16(sum)
+}
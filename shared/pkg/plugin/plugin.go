@@ -0,0 +1,231 @@
+// Package plugin runs processing stages, such as a denoiser, as programs of
+// their own that the audio goes through on its way, the same way in the
+// client and the server. A plugin reads frames of PCM on its standard input
+// and writes each back, processed, on its standard output:
+//
+//	length  uint32, big-endian: bytes of PCM that follow
+//	pcm     interleaved signed little-endian samples of the stream's format
+//
+// Every frame it reads, it answers with one frame of the same length before
+// the next arrives. The format is in its environment, as
+// AUDIO_CAPTURE_RATE, AUDIO_CAPTURE_CHANNELS and AUDIO_CAPTURE_BITS (16, or
+// 24 in 3 bytes), and its name as AUDIO_CAPTURE_PLUGIN. What it writes on its
+// standard error is logged. It ends when its standard input is closed.
+package plugin
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// frameTimeout is how long a plugin may take to answer a frame before it is
+// taken for hung and bypassed.
+const frameTimeout = time.Second
+
+// Spec names a plugin and the command running it, as in name=command args.
+type Spec struct {
+	Name    string
+	Command []string // The program and its arguments, split at spaces
+}
+
+// Specs is a flag.Value of plugins, repeated to chain them in order.
+type Specs []Spec
+
+func (s *Specs) String() string {
+	var out []string
+	for _, spec := range *s {
+		out = append(out, spec.Name+"="+strings.Join(spec.Command, " "))
+	}
+	return strings.Join(out, ",")
+}
+
+func (s *Specs) Set(v string) error {
+	name, command, ok := strings.Cut(v, "=")
+	name = strings.TrimSpace(name)
+	if !ok || name == "" || len(strings.Fields(command)) == 0 {
+		return errors.New("expected name=command")
+	}
+	*s = append(*s, Spec{Name: name, Command: strings.Fields(command)})
+	return nil
+}
+
+// Format is the format of the audio through a plugin.
+type Format struct {
+	SampleRate int
+	Channels   int
+	BitDepth   int // 16 or 24
+}
+
+// Plugin is a running plugin, from Start.
+type Plugin struct {
+	cmd *exec.Cmd
+	in  *os.File
+	out *os.File
+	buf []byte // The frame header
+	log *slog.Logger
+}
+
+// Start starts the plugin of spec for audio of format f.
+func Start(spec Spec, f Format, log *slog.Logger) (*Plugin, error) {
+	cmd := exec.Command(spec.Command[0], spec.Command[1:]...)
+	cmd.Env = append(os.Environ(),
+		"AUDIO_CAPTURE_PLUGIN="+spec.Name,
+		"AUDIO_CAPTURE_RATE="+strconv.Itoa(f.SampleRate),
+		"AUDIO_CAPTURE_CHANNELS="+strconv.Itoa(f.Channels),
+		"AUDIO_CAPTURE_BITS="+strconv.Itoa(f.BitDepth))
+	// Pipes of our own rather than StdinPipe and StdoutPipe, for the
+	// deadlines
+	r, in, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	out, w, err := os.Pipe()
+	if err != nil {
+		r.Close()
+		in.Close()
+		return nil, err
+	}
+	log = log.With("plugin", spec.Name)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = r, w, &lineLogger{log: log}
+	err = cmd.Start()
+	r.Close()
+	w.Close()
+	if err != nil {
+		in.Close()
+		out.Close()
+		return nil, fmt.Errorf("starting plugin %s failed: %w", spec.Name, err)
+	}
+	log.Debug("Started plugin", "command", strings.Join(spec.Command, " "), "pid", cmd.Process.Pid)
+	return &Plugin{cmd: cmd, in: in, out: out, buf: make([]byte, 4), log: log}, nil
+}
+
+// Process hands pcm, whole frames of the format, to the plugin and replaces
+// it with what the plugin answers.
+func (p *Plugin) Process(pcm []byte) error {
+	binary.BigEndian.PutUint32(p.buf, uint32(len(pcm)))
+	p.in.SetWriteDeadline(time.Now().Add(frameTimeout))
+	if _, err := p.in.Write(p.buf); err != nil {
+		return err
+	}
+	if _, err := p.in.Write(pcm); err != nil {
+		return err
+	}
+	p.out.SetReadDeadline(time.Now().Add(frameTimeout))
+	if _, err := io.ReadFull(p.out, p.buf); err != nil {
+		return fmt.Errorf("reading the answer failed: %w", err)
+	}
+	if n := binary.BigEndian.Uint32(p.buf); n != uint32(len(pcm)) {
+		return fmt.Errorf("answered %d bytes for a frame of %d", n, len(pcm))
+	}
+	if _, err := io.ReadFull(p.out, pcm); err != nil {
+		return fmt.Errorf("reading the answer failed: %w", err)
+	}
+	return nil
+}
+
+// Close closes the plugin's standard input and waits for it to exit,
+// killing it if it doesn't within a second.
+func (p *Plugin) Close() error {
+	p.in.Close()
+	done := make(chan error, 1)
+	go func() { done <- p.cmd.Wait() }()
+	defer p.out.Close()
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(time.Second):
+		p.cmd.Process.Kill()
+		return <-done
+	}
+}
+
+// Chain is plugins the audio goes through in order. A plugin that fails is
+// stopped and bypassed from then on, so the audio still flows, unprocessed
+// by it. A nil Chain processes nothing.
+type Chain struct {
+	plugins []*Plugin
+	saved   []byte // The frame before a plugin, restored when it fails
+}
+
+// StartChain starts the plugins of specs, or none of them when one fails to
+// start. It returns nil without specs.
+func StartChain(specs Specs, f Format, log *slog.Logger) (*Chain, error) {
+	if len(specs) == 0 {
+		return nil, nil
+	}
+	c := &Chain{}
+	for _, spec := range specs {
+		p, err := Start(spec, f, log)
+		if err != nil {
+			c.Close()
+			return nil, err
+		}
+		c.plugins = append(c.plugins, p)
+	}
+	return c, nil
+}
+
+// Process runs pcm, in place, through the plugins that still work.
+func (c *Chain) Process(pcm []byte) {
+	if c == nil {
+		return
+	}
+	for i, p := range c.plugins {
+		if p == nil {
+			continue
+		}
+		// A plugin may have answered part of a frame before failing
+		c.saved = append(c.saved[:0], pcm...)
+		if err := p.Process(pcm); err != nil {
+			p.log.Error("Plugin failed, passing the audio by it from now on", "err", err)
+			copy(pcm, c.saved)
+			p.cmd.Process.Kill()
+			p.Close()
+			c.plugins[i] = nil
+		}
+	}
+}
+
+// Close stops the plugins.
+func (c *Chain) Close() {
+	if c == nil {
+		return
+	}
+	for i, p := range c.plugins {
+		if p != nil {
+			if err := p.Close(); err != nil {
+				p.log.Warn("Plugin exited with an error", "err", err)
+			}
+			c.plugins[i] = nil
+		}
+	}
+}
+
+// lineLogger logs what a plugin writes on its standard error, a line at a
+// time.
+type lineLogger struct {
+	log     *slog.Logger
+	partial []byte
+}
+
+func (l *lineLogger) Write(p []byte) (int, error) {
+	l.partial = append(l.partial, p...)
+	for {
+		line, rest, ok := bytes.Cut(l.partial, []byte("\n"))
+		if !ok {
+			break
+		}
+		l.log.Info("🔌 Plugin output", "output", string(line))
+		l.partial = append(l.partial[:0], rest...)
+	}
+	return len(p), nil
+}
@@ -0,0 +1,415 @@
+package srt
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	tick          = 10 * time.Millisecond // Of ACKs and the checks of the run loop
+	keepaliveIdle = time.Second           // Without anything sent, before a keepalive
+	peerTimeout   = 5 * time.Second       // Without anything heard, before the peer is gone
+	minNAKPeriod  = 20 * time.Millisecond // Between NAKs of the same loss
+	retransmitBit = 0x04                  // In byte 4 of a data packet
+	firstMsg      = 0xc0000000            // A whole message, delivered in order
+)
+
+// Conn is an SRT connection. Reads return one message each, in order, and
+// each Write sends one.
+type Conn struct {
+	out           func([]byte) error // Sends a packet to the peer
+	local, remote net.Addr
+	localID       uint32
+	peerID        uint32
+	streamID      string
+	latency       time.Duration
+	start         time.Time
+	closed        func() // Run once the connection is done
+
+	in        chan []byte // Packets from the peer, for run
+	delivered chan []byte // Messages, for Read
+	done      chan struct{}
+	once      sync.Once
+	err       error // Why done was closed
+
+	mu       sync.Mutex
+	deadline time.Time // Of Read
+
+	// Sending
+	sendSeq  uint32
+	msgNo    uint32
+	unacked  []sent // In sequence order
+	lastSent time.Time
+
+	// Receiving
+	recvNext uint32               // The next to deliver
+	recvHigh uint32               // One past the highest received
+	buffer   map[uint32]recvd     // Received out of order
+	lost     map[uint32]time.Time // Missing, with when they were last NAKed
+	ackNo    uint32
+	acks     map[uint32]time.Time // ACKs waiting for their ACKACK
+	lastAck  uint32               // The sequence number last ACKed
+	rtt      time.Duration
+	heard    time.Time
+}
+
+type sent struct {
+	seq uint32
+	pkt []byte
+	at  time.Time
+}
+
+type recvd struct {
+	payload []byte
+	at      time.Time
+}
+
+func newConn(out func([]byte) error, local, remote net.Addr, localID, peerID, isn uint32, latency time.Duration, streamID string) *Conn {
+	now := time.Now()
+	return &Conn{
+		out: out, local: local, remote: remote, localID: localID, peerID: peerID,
+		streamID: streamID, latency: latency, start: now,
+		in: make(chan []byte, 1024), delivered: make(chan []byte, 1024), done: make(chan struct{}),
+		sendSeq: isn, recvNext: isn, recvHigh: isn, lastAck: isn,
+		buffer: map[uint32]recvd{}, lost: map[uint32]time.Time{}, acks: map[uint32]time.Time{},
+		rtt: 100 * time.Millisecond, heard: now, lastSent: now,
+	}
+}
+
+// StreamID returns the stream ID the caller sent, or "".
+func (c *Conn) StreamID() string { return c.streamID }
+
+// Latency returns the latency agreed on: the larger of both sides'.
+func (c *Conn) Latency() time.Duration { return c.latency }
+
+func (c *Conn) LocalAddr() net.Addr  { return c.local }
+func (c *Conn) RemoteAddr() net.Addr { return c.remote }
+
+// Read reads a message. It fails with ErrClosed once the peer closed the
+// connection and with ErrTimeout once it stopped answering; a deadline set
+// while Read waits applies from the next one.
+func (c *Conn) Read(b []byte) (int, error) {
+	c.mu.Lock()
+	deadline := c.deadline
+	c.mu.Unlock()
+	var timeout <-chan time.Time
+	if !deadline.IsZero() {
+		timer := time.NewTimer(time.Until(deadline))
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	select {
+	case m := <-c.delivered:
+		return copy(b, m), nil
+	case <-c.done:
+		// Messages received before the end are still read
+		select {
+		case m := <-c.delivered:
+			return copy(b, m), nil
+		default:
+			return 0, c.err
+		}
+	case <-timeout:
+		return 0, os.ErrDeadlineExceeded
+	}
+}
+
+// Write sends b as one message, kept for retransmission until the peer
+// acknowledges it or it is too late for it.
+func (c *Conn) Write(b []byte) (int, error) {
+	if len(b) > MaxMessage {
+		return 0, errors.New("srt: message larger than a packet")
+	}
+	select {
+	case <-c.done:
+		return 0, c.err
+	default:
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.msgNo = c.msgNo%msgMask + 1
+	pkt := header{seq: c.sendSeq, msg: firstMsg | c.msgNo, ts: c.timestamp(), dest: c.peerID}.marshal(make([]byte, 0, headerSize+len(b)))
+	pkt = append(pkt, b...)
+	now := time.Now()
+	c.unacked = append(c.unacked, sent{seq: c.sendSeq, pkt: pkt, at: now})
+	if len(c.unacked) > flowWindow {
+		c.unacked = c.unacked[1:]
+	}
+	c.sendSeq = seqAdd(c.sendSeq, 1)
+	c.lastSent = now
+	if err := c.out(pkt); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+func (c *Conn) SetDeadline(t time.Time) error { return c.SetReadDeadline(t) }
+
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	c.mu.Lock()
+	c.deadline = t
+	c.mu.Unlock()
+	return nil
+}
+
+// SetWriteDeadline does nothing, as writes don't wait.
+func (c *Conn) SetWriteDeadline(time.Time) error { return nil }
+
+// Close tells the peer the connection is closed and ends it.
+func (c *Conn) Close() error {
+	select {
+	case <-c.done:
+		return nil
+	default:
+	}
+	c.mu.Lock()
+	c.sendControl(ctrlShutdown, 0, nil)
+	c.mu.Unlock()
+	c.fail(net.ErrClosed)
+	return nil
+}
+
+func (c *Conn) fail(err error) {
+	c.once.Do(func() {
+		c.err = err
+		close(c.done)
+		if c.closed != nil {
+			c.closed()
+		}
+	})
+}
+
+// receive hands a packet from the peer to run, dropping it when run can't
+// keep up, as the peer sends it again.
+func (c *Conn) receive(pkt []byte) {
+	select {
+	case c.in <- pkt:
+	default:
+	}
+}
+
+// run handles the packets from the peer, and sends the ACKs, NAKs and
+// keepalives, until the connection is done.
+func (c *Conn) run() {
+	ticker := time.NewTicker(tick)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.done:
+			return
+		case pkt := <-c.in:
+			c.mu.Lock()
+			c.handle(pkt)
+			c.mu.Unlock()
+		case now := <-ticker.C:
+			c.mu.Lock()
+			err := c.check(now)
+			c.mu.Unlock()
+			if err != nil {
+				c.fail(err)
+				return
+			}
+		}
+	}
+}
+
+// handle takes a packet from the peer, with mu held.
+func (c *Conn) handle(pkt []byte) {
+	h, ok := parseHeader(pkt)
+	if !ok {
+		return
+	}
+	c.heard = time.Now()
+	if !h.control {
+		c.data(h.seq, pkt[headerSize:])
+		return
+	}
+	cif := pkt[headerSize:]
+	switch h.typ {
+	case ctrlACK:
+		if len(cif) < 4 {
+			return
+		}
+		acked := binary.BigEndian.Uint32(cif) & seqMask
+		i := 0
+		for i < len(c.unacked) && seqDiff(c.unacked[i].seq, acked) < 0 {
+			i++
+		}
+		c.unacked = c.unacked[i:]
+		if len(cif) >= 16 { // A full ACK, not a light one
+			c.sendControl(ctrlACKACK, h.info, nil)
+		}
+	case ctrlNAK:
+		c.retransmit(cif)
+	case ctrlACKACK:
+		if at, ok := c.acks[h.info]; ok {
+			delete(c.acks, h.info)
+			c.rtt = (7*c.rtt + time.Since(at)) / 8
+		}
+	case ctrlShutdown:
+		go c.fail(ErrClosed)
+	}
+}
+
+// data takes a data packet, delivering what is now in order.
+func (c *Conn) data(seq uint32, payload []byte) {
+	ahead := seqDiff(seq, c.recvNext)
+	if ahead < 0 {
+		return // Delivered already, or given up on
+	}
+	if ahead > flowWindow {
+		// The sender skipped far ahead, as after a restart: start over there
+		clear(c.buffer)
+		clear(c.lost)
+		c.recvNext, c.recvHigh = seq, seq
+	}
+	if _, dup := c.buffer[seq]; dup {
+		return
+	}
+	c.buffer[seq] = recvd{payload: append([]byte(nil), payload...), at: time.Now()}
+	delete(c.lost, seq)
+	if gap := seqDiff(seq, c.recvHigh); gap >= 0 {
+		if gap > 0 {
+			// Lost on the way, or reordered: ask for them straight away
+			for q := c.recvHigh; q != seq; q = seqAdd(q, 1) {
+				c.lost[q] = time.Now()
+			}
+			c.sendNAK([][2]uint32{{c.recvHigh, seqAdd(seq, -1)}})
+		}
+		c.recvHigh = seqAdd(seq, 1)
+	}
+	c.deliver()
+}
+
+// deliver hands the messages in order to Read, dropping them when nobody
+// reads fast enough.
+func (c *Conn) deliver() {
+	for {
+		r, ok := c.buffer[c.recvNext]
+		if !ok {
+			return
+		}
+		delete(c.buffer, c.recvNext)
+		c.recvNext = seqAdd(c.recvNext, 1)
+		select {
+		case c.delivered <- r.payload:
+		default:
+		}
+	}
+}
+
+// check sends what is due at now, with mu held, and fails once the peer is
+// gone.
+func (c *Conn) check(now time.Time) error {
+	if now.Sub(c.heard) > peerTimeout {
+		return ErrTimeout
+	}
+
+	// Give up on losses the packets after them waited the latency for
+	if len(c.buffer) > 0 { // Held back by a loss, as the rest was delivered
+		first, firstAt := c.recvNext, time.Time{}
+		for seq, r := range c.buffer {
+			if firstAt.IsZero() || seqDiff(seq, first) < 0 {
+				first, firstAt = seq, r.at
+			}
+		}
+		if now.Sub(firstAt) >= c.latency {
+			for q := c.recvNext; q != first; q = seqAdd(q, 1) {
+				delete(c.lost, q)
+			}
+			c.recvNext = first
+			c.deliver()
+		}
+	}
+
+	for n, at := range c.acks {
+		if now.Sub(at) > time.Second {
+			delete(c.acks, n) // Its ACKACK was lost
+		}
+	}
+	if c.recvNext != c.lastAck {
+		c.ackNo++
+		c.acks[c.ackNo] = now
+		rtt := uint32(c.rtt.Microseconds())
+		cif := make([]byte, 0, 28)
+		for _, v := range []uint32{c.recvNext, rtt, rtt / 2, flowWindow, 0, 0, 0} {
+			cif = binary.BigEndian.AppendUint32(cif, v)
+		}
+		c.sendControl(ctrlACK, c.ackNo, cif)
+		c.lastAck = c.recvNext
+	}
+
+	// Ask again for the losses not retransmitted within a round trip
+	var ranges [][2]uint32
+	for seq, at := range c.lost {
+		if now.Sub(at) >= max(c.rtt+c.rtt/2, minNAKPeriod) {
+			c.lost[seq] = now
+			ranges = append(ranges, [2]uint32{seq, seq})
+		}
+	}
+	if len(ranges) > 0 {
+		c.sendNAK(ranges)
+	}
+
+	// Forget what is too late to send again
+	keep := max(c.latency*5/4, time.Second)
+	i := 0
+	for i < len(c.unacked) && now.Sub(c.unacked[i].at) > keep {
+		i++
+	}
+	c.unacked = c.unacked[i:]
+
+	if now.Sub(c.lastSent) >= keepaliveIdle {
+		c.sendControl(ctrlKeepalive, 0, nil)
+	}
+	return nil
+}
+
+// retransmit sends again the packets of a NAK's loss list: sequence
+// numbers, and ranges whose first has the top bit set.
+func (c *Conn) retransmit(list []byte) {
+	for len(list) >= 4 {
+		from := binary.BigEndian.Uint32(list)
+		to := from
+		list = list[4:]
+		if from&(1<<31) != 0 {
+			if len(list) < 4 {
+				return
+			}
+			from &= seqMask
+			to = binary.BigEndian.Uint32(list) & seqMask
+			list = list[4:]
+		}
+		for _, s := range c.unacked {
+			if seqDiff(s.seq, from) >= 0 && seqDiff(s.seq, to) <= 0 {
+				s.pkt[4] |= retransmitBit
+				c.out(s.pkt)
+			}
+		}
+	}
+}
+
+func (c *Conn) sendNAK(ranges [][2]uint32) {
+	var list []byte
+	for _, r := range ranges {
+		if r[0] == r[1] {
+			list = binary.BigEndian.AppendUint32(list, r[0])
+			continue
+		}
+		list = binary.BigEndian.AppendUint32(list, r[0]|1<<31)
+		list = binary.BigEndian.AppendUint32(list, r[1])
+	}
+	c.sendControl(ctrlNAK, 0, list)
+}
+
+func (c *Conn) sendControl(typ uint16, info uint32, cif []byte) {
+	pkt := header{control: true, typ: typ, info: info, ts: c.timestamp(), dest: c.peerID}.marshal(make([]byte, 0, headerSize+len(cif)))
+	c.out(append(pkt, cif...))
+	c.lastSent = time.Now()
+}
+
+func (c *Conn) timestamp() uint32 { return uint32(time.Since(c.start).Microseconds()) }
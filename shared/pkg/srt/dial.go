@@ -0,0 +1,136 @@
+package srt
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"net/netip"
+	"os"
+	"time"
+)
+
+const handshakeRetry = 250 * time.Millisecond // Before a handshake is sent again
+
+// Dial calls the listener at the UDP address of network until ctx is done.
+func Dial(ctx context.Context, network, address string, cfg Config) (*Conn, error) {
+	var d net.Dialer
+	c, err := d.DialContext(ctx, network, address)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := Client(ctx, c, cfg)
+	if err != nil {
+		c.Close()
+	}
+	return conn, err
+}
+
+// Client calls the listener c is connected to, a UDP socket, until ctx is
+// done. The connection closes c once it is done.
+func Client(ctx context.Context, c net.Conn, cfg Config) (*Conn, error) {
+	id := rand.Uint32()
+	for id == 0 {
+		id = rand.Uint32()
+	}
+	remote := netip.AddrPort{}
+	if addr, ok := c.RemoteAddr().(*net.UDPAddr); ok {
+		remote = addr.AddrPort()
+	}
+	hs := handshake{
+		version: 4, extField: 2, isn: rand.Uint32() & seqMask, mtu: 1500, window: flowWindow,
+		typ: hsInduction, socket: id, peerIP: ipField(remote.Addr()),
+	}
+	resp, err := exchange(ctx, c, id, hs)
+	if err != nil {
+		return nil, err
+	}
+	if resp.version != 5 || resp.extField != magic {
+		return nil, errors.New("srt: the listener doesn't speak SRT 1.3 or later")
+	}
+
+	hs.version, hs.typ, hs.cookie = 5, hsConclusion, resp.cookie
+	hs.extField = hsFlagReq
+	hs.addExt(extHSReq, hsExt(cfg.latency()))
+	if cfg.StreamID != "" {
+		hs.extField |= hsFlagCfg
+		hs.addExt(extStreamID, encodeStreamID(cfg.StreamID))
+	}
+	if resp, err = exchange(ctx, c, id, hs); err != nil {
+		return nil, err
+	}
+	if resp.typ != hsConclusion {
+		return nil, fmt.Errorf("srt: the listener rejected the call: %s", rejectReason(resp.typ))
+	}
+
+	latency := max(cfg.latency(), peerLatency(resp.ext[extHSRsp]))
+	out := func(pkt []byte) error {
+		_, err := c.Write(pkt)
+		return err
+	}
+	conn := newConn(out, c.LocalAddr(), c.RemoteAddr(), id, resp.socket, hs.isn, latency, cfg.StreamID)
+	conn.closed = func() { c.Close() }
+	c.SetReadDeadline(time.Time{})
+	go func() {
+		buf := make([]byte, maxSize)
+		for {
+			n, err := c.Read(buf)
+			if err != nil {
+				if errors.Is(err, net.ErrClosed) {
+					return
+				}
+				continue // Such as an ICMP error while the listener restarts
+			}
+			if h, ok := parseHeader(buf[:n]); ok && h.dest == id {
+				conn.receive(append([]byte(nil), buf[:n]...))
+			}
+		}
+	}()
+	go conn.run()
+	return conn, nil
+}
+
+// exchange sends the handshake hs until the listener answers with one of
+// the same type, or a rejection.
+func exchange(ctx context.Context, c net.Conn, id uint32, hs handshake) (handshake, error) {
+	pkt := hs.marshal(header{control: true, typ: ctrlHandshake}.marshal(nil))
+	buf := make([]byte, maxSize)
+	for {
+		if err := ctx.Err(); err != nil {
+			return handshake{}, fmt.Errorf("srt: the listener didn't answer: %w", err)
+		}
+		if _, err := c.Write(pkt); err != nil {
+			return handshake{}, err
+		}
+		deadline := time.Now().Add(handshakeRetry)
+		if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+			deadline = d
+		}
+		c.SetReadDeadline(deadline)
+		for {
+			n, err := c.Read(buf)
+			if err != nil {
+				if errors.Is(err, net.ErrClosed) {
+					return handshake{}, err
+				}
+				if !errors.Is(err, os.ErrDeadlineExceeded) {
+					// Refused while the listener is down: wait as for an answer
+					select {
+					case <-ctx.Done():
+					case <-time.After(time.Until(deadline)):
+					}
+				}
+				break
+			}
+			h, ok := parseHeader(buf[:n])
+			if !ok || !h.control || h.typ != ctrlHandshake || h.dest != id {
+				continue
+			}
+			resp, ok := parseHandshake(buf[headerSize:n])
+			if ok && (resp.typ == hs.typ || isRejection(resp.typ)) {
+				return resp, nil
+			}
+		}
+	}
+}
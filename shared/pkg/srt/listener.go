@@ -0,0 +1,239 @@
+package srt
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"net"
+	"net/netip"
+	"sync"
+	"time"
+
+	mrand "math/rand/v2"
+)
+
+const (
+	maxConns = 1024 // Of a listener, after which calls are refused
+	backlog  = 16   // Connections waiting for Accept
+)
+
+// Config is what Listen and Dial take.
+type Config struct {
+	// Latency is how long a lost packet is waited for; the larger of both
+	// sides' is taken. DefaultLatency when 0.
+	Latency time.Duration
+	// StreamID is sent by Dial to tell the listener what the stream is.
+	StreamID string
+	// Allow, when set, decides whether a listener takes a call from remote
+	// with streamID; the calls it refuses are rejected.
+	Allow func(remote netip.AddrPort, streamID string) bool
+}
+
+func (cfg Config) latency() time.Duration {
+	if cfg.Latency <= 0 {
+		return DefaultLatency
+	}
+	return cfg.Latency
+}
+
+// Listener takes SRT calls on a UDP socket, all connections sharing it.
+type Listener struct {
+	pc       net.PacketConn
+	cfg      Config
+	secret   [32]byte // Of the cookies
+	accepted chan *Conn
+	done     chan struct{}
+	once     sync.Once
+
+	mu     sync.Mutex
+	conns  map[uint32]*Conn         // By socket ID
+	byAddr map[netip.AddrPort]*Conn // By caller
+	answer map[*Conn][]byte         // The conclusion each was answered with
+}
+
+// Listen takes calls on the UDP address of network, such as udp and
+// :9000.
+func Listen(network, address string, cfg Config) (*Listener, error) {
+	pc, err := net.ListenPacket(network, address)
+	if err != nil {
+		return nil, err
+	}
+	l := &Listener{
+		pc: pc, cfg: cfg, accepted: make(chan *Conn, backlog), done: make(chan struct{}),
+		conns: map[uint32]*Conn{}, byAddr: map[netip.AddrPort]*Conn{}, answer: map[*Conn][]byte{},
+	}
+	rand.Read(l.secret[:])
+	go l.serve()
+	return l, nil
+}
+
+// Addr returns the address the listener takes calls on.
+func (l *Listener) Addr() net.Addr { return l.pc.LocalAddr() }
+
+// Accept waits for the next connection.
+func (l *Listener) Accept() (*Conn, error) {
+	select {
+	case c := <-l.accepted:
+		return c, nil
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+// Close closes the connections and stops taking calls.
+func (l *Listener) Close() error {
+	l.once.Do(func() { close(l.done) })
+	l.mu.Lock()
+	conns := make([]*Conn, 0, len(l.conns))
+	for _, c := range l.conns {
+		conns = append(conns, c)
+	}
+	l.mu.Unlock()
+	for _, c := range conns {
+		c.Close()
+	}
+	return l.pc.Close()
+}
+
+// serve reads the socket, handing each packet to its connection, until the
+// listener is closed.
+func (l *Listener) serve() {
+	buf := make([]byte, maxSize)
+	for {
+		n, from, err := l.pc.ReadFrom(buf)
+		if err != nil {
+			select {
+			case <-l.done:
+				return
+			default:
+			}
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			continue // Such as an ICMP error for a caller gone
+		}
+		addr, ok := from.(*net.UDPAddr)
+		if !ok {
+			continue
+		}
+		remote := addr.AddrPort()
+		h, ok := parseHeader(buf[:n])
+		if !ok {
+			continue
+		}
+		if h.dest == 0 {
+			if h.control && h.typ == ctrlHandshake {
+				l.handshake(buf[headerSize:n], remote, from)
+			}
+			continue
+		}
+		l.mu.Lock()
+		c := l.conns[h.dest]
+		l.mu.Unlock()
+		if c != nil && c.remote.(*net.UDPAddr).AddrPort() == remote {
+			c.receive(append([]byte(nil), buf[:n]...))
+		}
+	}
+}
+
+// handshake answers a caller's induction or conclusion.
+func (l *Listener) handshake(b []byte, remote netip.AddrPort, from net.Addr) {
+	hs, ok := parseHandshake(b)
+	if !ok {
+		return
+	}
+	reply := func(resp handshake) []byte {
+		pkt := header{control: true, typ: ctrlHandshake, dest: hs.socket}.marshal(nil)
+		pkt = resp.marshal(pkt)
+		l.pc.WriteTo(pkt, from)
+		return pkt
+	}
+	resp := handshake{
+		version: 5, isn: hs.isn, mtu: 1500, window: flowWindow, typ: hs.typ,
+		peerIP: ipField(remote.Addr()),
+	}
+
+	switch hs.typ {
+	case hsInduction:
+		resp.extField = magic
+		resp.cookie = l.cookie(remote, time.Now())
+		reply(resp)
+		return
+	case hsConclusion:
+	default:
+		return
+	}
+	if hs.version != 5 {
+		return
+	}
+	now := time.Now()
+	if hs.cookie != l.cookie(remote, now) && hs.cookie != l.cookie(remote, now.Add(-time.Minute)) {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if c := l.byAddr[remote]; c != nil && c.peerID == hs.socket {
+		l.pc.WriteTo(l.answer[c], from) // The answer was lost
+		return
+	}
+	reject := func(code uint32) {
+		resp.typ = hsRejectBase + code
+		reply(resp)
+	}
+	_, km := hs.ext[extKMReq]
+	if hs.encrypt != 0 || km {
+		reject(rejectUnsecure)
+		return
+	}
+	if len(l.conns) >= maxConns || len(l.accepted) == cap(l.accepted) {
+		reject(rejectResource)
+		return
+	}
+	streamID := decodeStreamID(hs.ext[extStreamID])
+	if l.cfg.Allow != nil && !l.cfg.Allow(remote, streamID) {
+		reject(rejectPeer)
+		return
+	}
+
+	id := mrand.Uint32()
+	for id == 0 || l.conns[id] != nil {
+		id = mrand.Uint32()
+	}
+	latency := max(l.cfg.latency(), peerLatency(hs.ext[extHSReq]))
+	out := func(pkt []byte) error {
+		_, err := l.pc.WriteTo(pkt, from)
+		return err
+	}
+	c := newConn(out, l.pc.LocalAddr(), from, id, hs.socket, hs.isn, latency, streamID)
+	c.closed = func() {
+		l.mu.Lock()
+		delete(l.conns, id)
+		if l.byAddr[remote] == c {
+			delete(l.byAddr, remote)
+		}
+		delete(l.answer, c)
+		l.mu.Unlock()
+	}
+	if old := l.byAddr[remote]; old != nil {
+		go old.fail(ErrClosed) // The caller called again from the same port
+	}
+	resp.extField = hsFlagReq
+	resp.socket = id
+	resp.addExt(extHSRsp, hsExt(latency))
+	l.conns[id], l.byAddr[remote], l.answer[c] = c, c, reply(resp)
+	go c.run()
+	l.accepted <- c
+}
+
+// cookie is what a caller from remote must send back in its conclusion,
+// for the minute of t, so a listener keeps no state for inductions.
+func (l *Listener) cookie(remote netip.AddrPort, t time.Time) uint32 {
+	mac := hmac.New(sha256.New, l.secret[:])
+	b, _ := remote.MarshalBinary()
+	mac.Write(b)
+	mac.Write(binary.BigEndian.AppendUint64(nil, uint64(t.Unix()/60)))
+	return binary.BigEndian.Uint32(mac.Sum(nil))
+}
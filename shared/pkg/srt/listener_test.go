@@ -0,0 +1,198 @@
+package srt
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// call connects a caller to a listener on localhost with the configurations
+// given, through the address of via when it isn't empty, and returns both
+// ends. They are closed when the test ends.
+func call(t *testing.T, l *Listener, caller Config, via string) (*Conn, *Conn) {
+	t.Helper()
+	if via == "" {
+		via = l.Addr().String()
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	c, err := Dial(ctx, "udp", via, caller)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	accepted, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { accepted.Close() })
+	return c, accepted
+}
+
+func listen(t *testing.T, cfg Config) *Listener {
+	t.Helper()
+	l, err := Listen("udp", "127.0.0.1:0", cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	return l
+}
+
+// readMessages reads n messages from c, failing unless they are
+// message 0 to n-1 in order.
+func readMessages(t *testing.T, c *Conn, n int) {
+	t.Helper()
+	buf := make([]byte, MaxMessage)
+	c.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for i := range n {
+		m, err := c.Read(buf)
+		if err != nil {
+			t.Fatalf("reading message %d: %v", i, err)
+		}
+		if got, want := string(buf[:m]), fmt.Sprintf("message %d", i); got != want {
+			t.Fatalf("read %q, want %q", got, want)
+		}
+	}
+}
+
+func writeMessages(t *testing.T, c *Conn, n int) {
+	t.Helper()
+	for i := range n {
+		if _, err := fmt.Fprintf(c, "message %d", i); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestLoopback(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		allowed []string
+	)
+	l := listen(t, Config{Latency: 200 * time.Millisecond, Allow: func(remote netip.AddrPort, streamID string) bool {
+		mu.Lock()
+		defer mu.Unlock()
+		allowed = append(allowed, streamID)
+		return true
+	}})
+	caller, callee := call(t, l, Config{Latency: 50 * time.Millisecond, StreamID: "#!::r=live,m=publish"}, "")
+
+	if got := callee.StreamID(); got != "#!::r=live,m=publish" {
+		t.Errorf("the listener got the stream ID %q", got)
+	}
+	mu.Lock()
+	if len(allowed) != 1 || allowed[0] != "#!::r=live,m=publish" {
+		t.Errorf("Allow was asked about %q", allowed)
+	}
+	mu.Unlock()
+	// The larger latency wins at both ends
+	if caller.Latency() != 200*time.Millisecond || callee.Latency() != 200*time.Millisecond {
+		t.Errorf("latencies are %v and %v, want 200ms", caller.Latency(), callee.Latency())
+	}
+
+	writeMessages(t, caller, 100)
+	readMessages(t, callee, 100)
+	writeMessages(t, callee, 10)
+	readMessages(t, caller, 10)
+
+	if _, err := caller.Write(make([]byte, MaxMessage+1)); err == nil {
+		t.Error("a message larger than a packet was written")
+	}
+
+	// The listener's end hears of the caller's closing
+	caller.Close()
+	callee.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := callee.Read(make([]byte, MaxMessage)); !errors.Is(err, ErrClosed) {
+		t.Errorf("read after the caller closed: %v, want ErrClosed", err)
+	}
+}
+
+func TestLoopbackLoss(t *testing.T) {
+	l := listen(t, Config{})
+	proxy := newLossyProxy(t, l.Addr().(*net.UDPAddr).AddrPort())
+	caller, callee := call(t, l, Config{}, proxy.addr())
+
+	// Every fourth message is lost on its way, but for its retransmission.
+	// A loss is only noticed from the packets after it, as a live stream
+	// always has, so a few more follow the ones read.
+	writeMessages(t, caller, 204)
+	readMessages(t, callee, 200)
+	if proxy.dropped() == 0 {
+		t.Error("the proxy dropped nothing")
+	}
+}
+
+func TestRejection(t *testing.T) {
+	l := listen(t, Config{Allow: func(netip.AddrPort, string) bool { return false }})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, err := Dial(ctx, "udp", l.Addr().String(), Config{StreamID: "nope"})
+	if err == nil || !strings.Contains(err.Error(), "refused by the listener") {
+		t.Errorf("Dial = %v, want a refusal", err)
+	}
+}
+
+// lossyProxy forwards the datagrams between one caller and a listener,
+// dropping the first transmission of every fourth data packet the caller
+// sends.
+type lossyProxy struct {
+	pc       net.PacketConn
+	listener netip.AddrPort
+
+	mu     sync.Mutex
+	caller netip.AddrPort
+	drops  int
+}
+
+func newLossyProxy(t *testing.T, listener netip.AddrPort) *lossyProxy {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &lossyProxy{pc: pc, listener: listener}
+	t.Cleanup(func() { pc.Close() })
+	go p.run()
+	return p
+}
+
+func (p *lossyProxy) addr() string { return p.pc.LocalAddr().String() }
+
+func (p *lossyProxy) dropped() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.drops
+}
+
+func (p *lossyProxy) run() {
+	buf := make([]byte, maxSize)
+	for {
+		n, from, err := p.pc.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		src := from.(*net.UDPAddr).AddrPort()
+		p.mu.Lock()
+		to := p.listener
+		if src == p.listener {
+			to = p.caller
+		} else {
+			p.caller = src
+			data := n >= headerSize && buf[0]&0x80 == 0
+			if data && buf[4]&retransmitBit == 0 && binary.BigEndian.Uint32(buf)%4 == 0 {
+				p.drops++
+				p.mu.Unlock()
+				continue
+			}
+		}
+		p.mu.Unlock()
+		p.pc.WriteTo(buf[:n], net.UDPAddrFromAddrPort(to))
+	}
+}
@@ -0,0 +1,276 @@
+// Package srt speaks the live mode of SRT, the Secure Reliable Transport
+// (draft-sharabayko-srt), the same way in the client and the server: a
+// caller connects to a listener over UDP with the version 5 handshake, and
+// each message it writes arrives whole at the other end, in order, with the
+// packets lost on the way sent again while there is time. A packet still
+// missing after the latency, 120 ms by default, is given up on, so the
+// stream is never held up by more than that.
+//
+// Each message is one packet: messages larger than a datagram aren't split.
+// Encryption isn't supported, and calls offering it are refused.
+package srt
+
+import (
+	"encoding/binary"
+	"errors"
+	"net/netip"
+	"time"
+)
+
+// Control packet types
+const (
+	ctrlHandshake = 0x0000
+	ctrlKeepalive = 0x0001
+	ctrlACK       = 0x0002
+	ctrlNAK       = 0x0003
+	ctrlShutdown  = 0x0005
+	ctrlACKACK    = 0x0006
+)
+
+// Handshake types, and the rejections, 1000 and up
+const (
+	hsInduction  = 0x00000001
+	hsConclusion = 0xffffffff
+	hsRejectBase = 1000
+
+	rejectPeer     = 2  // Refused by the listener
+	rejectResource = 3  // Out of connections
+	rejectUnsecure = 11 // Encryption asked for, or not
+)
+
+const (
+	headerSize   = 16
+	handshakeCIF = 48
+	magic        = 0x4a17  // Extension field of the listener's induction response
+	srtVersion   = 0x10502 // 1.5.2, the version announced
+	seqMask      = 1<<31 - 1
+	msgMask      = 1<<26 - 1
+	maxSize      = 1 << 16 // Largest datagram read
+	flowWindow   = 8192
+
+	// Extensions of the conclusion handshake, and the flags of its
+	// extension field
+	extHSReq    = 1
+	extHSRsp    = 2
+	extKMReq    = 3
+	extStreamID = 5
+	hsFlagReq   = 0x1
+	hsFlagKM    = 0x2
+	hsFlagCfg   = 0x4
+
+	// Flags of HSREQ and HSRSP: timestamp-based delivery both ways, dropping
+	// packets too late, periodic NAKs and the retransmitted flag
+	srtFlags = 0x01 | 0x02 | 0x08 | 0x10 | 0x20
+)
+
+// MaxMessage is the largest message a Write sends, what a data packet
+// carries in a 1500-byte MTU.
+const MaxMessage = 1456
+
+// DefaultLatency is how long a lost packet is waited for unless the peer
+// asks for longer.
+const DefaultLatency = 120 * time.Millisecond
+
+var (
+	// ErrClosed is returned by the reads of a connection closed by the peer.
+	ErrClosed = errors.New("srt: connection closed by the peer")
+	// ErrTimeout is returned by the reads of a connection the peer stopped
+	// sending anything on, not even keepalives.
+	ErrTimeout = errors.New("srt: the peer stopped answering")
+)
+
+// header is the first 16 bytes of every packet.
+type header struct {
+	control bool
+	typ     uint16 // Of a control packet
+	info    uint32 // A control packet's type-specific information
+	seq     uint32 // Of a data packet
+	msg     uint32 // Position, order, encryption, retransmitted and message number of a data packet
+	ts      uint32 // Microseconds since the connection started
+	dest    uint32 // Socket ID of the receiver
+}
+
+func (h header) marshal(b []byte) []byte {
+	if h.control {
+		b = binary.BigEndian.AppendUint16(b, 0x8000|h.typ)
+		b = binary.BigEndian.AppendUint16(b, 0)
+		b = binary.BigEndian.AppendUint32(b, h.info)
+	} else {
+		b = binary.BigEndian.AppendUint32(b, h.seq&seqMask)
+		b = binary.BigEndian.AppendUint32(b, h.msg)
+	}
+	b = binary.BigEndian.AppendUint32(b, h.ts)
+	return binary.BigEndian.AppendUint32(b, h.dest)
+}
+
+func parseHeader(b []byte) (header, bool) {
+	if len(b) < headerSize {
+		return header{}, false
+	}
+	h := header{ts: binary.BigEndian.Uint32(b[8:]), dest: binary.BigEndian.Uint32(b[12:])}
+	if b[0]&0x80 != 0 {
+		h.control = true
+		h.typ = binary.BigEndian.Uint16(b) & 0x7fff
+		h.info = binary.BigEndian.Uint32(b[4:])
+	} else {
+		h.seq = binary.BigEndian.Uint32(b) & seqMask
+		h.msg = binary.BigEndian.Uint32(b[4:])
+	}
+	return h, true
+}
+
+// handshake is the body of a handshake packet.
+type handshake struct {
+	version  uint32
+	encrypt  uint16
+	extField uint16
+	isn      uint32 // Initial sequence number
+	mtu      uint32
+	window   uint32
+	typ      uint32
+	socket   uint32 // Of the sender
+	cookie   uint32
+	peerIP   [16]byte
+	ext      map[uint16][]byte // Extensions, by type
+	extOrder []uint16
+}
+
+func (hs *handshake) addExt(typ uint16, value []byte) {
+	if hs.ext == nil {
+		hs.ext = make(map[uint16][]byte)
+	}
+	hs.ext[typ] = value
+	hs.extOrder = append(hs.extOrder, typ)
+}
+
+func (hs handshake) marshal(b []byte) []byte {
+	b = binary.BigEndian.AppendUint32(b, hs.version)
+	b = binary.BigEndian.AppendUint16(b, hs.encrypt)
+	b = binary.BigEndian.AppendUint16(b, hs.extField)
+	for _, v := range []uint32{hs.isn, hs.mtu, hs.window, hs.typ, hs.socket, hs.cookie} {
+		b = binary.BigEndian.AppendUint32(b, v)
+	}
+	b = append(b, hs.peerIP[:]...)
+	for _, typ := range hs.extOrder {
+		v := hs.ext[typ]
+		b = binary.BigEndian.AppendUint16(b, typ)
+		b = binary.BigEndian.AppendUint16(b, uint16((len(v)+3)/4))
+		b = append(b, v...)
+		for len(v)%4 != 0 {
+			b, v = append(b, 0), append(v, 0)
+		}
+	}
+	return b
+}
+
+func parseHandshake(b []byte) (handshake, bool) {
+	if len(b) < handshakeCIF {
+		return handshake{}, false
+	}
+	hs := handshake{
+		version:  binary.BigEndian.Uint32(b),
+		encrypt:  binary.BigEndian.Uint16(b[4:]),
+		extField: binary.BigEndian.Uint16(b[6:]),
+		isn:      binary.BigEndian.Uint32(b[8:]) & seqMask,
+		mtu:      binary.BigEndian.Uint32(b[12:]),
+		window:   binary.BigEndian.Uint32(b[16:]),
+		typ:      binary.BigEndian.Uint32(b[20:]),
+		socket:   binary.BigEndian.Uint32(b[24:]),
+		cookie:   binary.BigEndian.Uint32(b[28:]),
+	}
+	copy(hs.peerIP[:], b[32:48])
+	for rest := b[handshakeCIF:]; len(rest) >= 4; {
+		typ := binary.BigEndian.Uint16(rest)
+		size := int(binary.BigEndian.Uint16(rest[2:])) * 4
+		if 4+size > len(rest) {
+			break
+		}
+		hs.addExt(typ, rest[4:4+size])
+		rest = rest[4+size:]
+	}
+	return hs, true
+}
+
+// hsExt is the body of HSREQ and HSRSP: the version, the flags, and the
+// latencies the sender asks for as receiver and as sender, in ms.
+func hsExt(latency time.Duration) []byte {
+	ms := uint32(latency.Milliseconds())
+	b := binary.BigEndian.AppendUint32(nil, srtVersion)
+	b = binary.BigEndian.AppendUint32(b, srtFlags)
+	return binary.BigEndian.AppendUint32(b, ms<<16|ms)
+}
+
+// peerLatency returns the larger of the latencies an HSREQ or HSRSP asks for.
+func peerLatency(v []byte) time.Duration {
+	if len(v) < 12 {
+		return 0
+	}
+	w := binary.BigEndian.Uint32(v[8:])
+	return time.Duration(max(w>>16, w&0xffff)) * time.Millisecond
+}
+
+// The stream ID goes in 32-bit words with their bytes reversed.
+func encodeStreamID(id string) []byte {
+	b := []byte(id)
+	for len(b)%4 != 0 {
+		b = append(b, 0)
+	}
+	for i := 0; i < len(b); i += 4 {
+		b[i], b[i+1], b[i+2], b[i+3] = b[i+3], b[i+2], b[i+1], b[i]
+	}
+	return b
+}
+
+func decodeStreamID(v []byte) string {
+	b := append([]byte(nil), v...)
+	for i := 0; i+3 < len(b); i += 4 {
+		b[i], b[i+1], b[i+2], b[i+3] = b[i+3], b[i+2], b[i+1], b[i]
+	}
+	for len(b) > 0 && b[len(b)-1] == 0 {
+		b = b[:len(b)-1]
+	}
+	return string(b)
+}
+
+// ipField is addr as libsrt has it in the peer IP field: 4 bytes for IPv4,
+// all 16 for IPv6, in 32-bit words with their bytes reversed like the
+// stream ID's.
+func ipField(addr netip.Addr) [16]byte {
+	var f [16]byte
+	if a := addr.Unmap(); a.Is4() {
+		a4 := a.As4()
+		copy(f[:], a4[:])
+	} else {
+		f = addr.As16()
+	}
+	for i := 0; i < len(f); i += 4 {
+		f[i], f[i+1], f[i+2], f[i+3] = f[i+3], f[i+2], f[i+1], f[i]
+	}
+	return f
+}
+
+// seqDiff returns a-b of two sequence numbers, which wrap around at 2^31.
+func seqDiff(a, b uint32) int32 {
+	d := (a - b) & seqMask
+	if d >= 1<<30 {
+		return int32(int64(d) - 1<<31)
+	}
+	return int32(d)
+}
+
+func seqAdd(a uint32, n int32) uint32 { return (a + uint32(n)) & seqMask }
+
+func isRejection(typ uint32) bool { return typ >= hsRejectBase && typ < hsRejectBase+1000 }
+
+// rejectReason describes a rejection of the handshake.
+func rejectReason(typ uint32) string {
+	switch typ - hsRejectBase {
+	case rejectPeer:
+		return "refused by the listener"
+	case rejectResource:
+		return "the listener has no room"
+	case rejectUnsecure:
+		return "encryption isn't supported"
+	}
+	return "rejected"
+}
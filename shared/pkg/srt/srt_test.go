@@ -0,0 +1,168 @@
+package srt
+
+import (
+	"bytes"
+	"encoding/hex"
+	"net/netip"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// The handshakes of a call, laid out field for field the way libsrt 1.5.3
+// has them on the wire: a caller on 127.0.0.1 with the stream ID
+// #!::r=live,m=publish and the default latency, and a listener on 127.0.0.1
+// taking it or refusing it. libsrt sends the words of a control packet in
+// network order, so the bytes of the peer IP and the stream ID, which it
+// copies in as they are in memory, come out reversed in each word on a
+// little-endian machine.
+var libsrtHandshakes = []struct {
+	name   string
+	packet string // Header, then the handshake, one 32-bit word per group
+	want   handshake
+	dest   uint32
+}{
+	{
+		name: "caller induction",
+		packet: "80000000 00000000 00000c81 00000000" +
+			"00000004 00000002 1a2b3c4d 000005dc 00002000 00000001 2b6a7d13 00000000" +
+			"0100007f 00000000 00000000 00000000",
+		want: handshake{
+			version: 4, extField: 2, isn: 0x1a2b3c4d, mtu: 1500, window: 8192,
+			typ: hsInduction, socket: 0x2b6a7d13,
+		},
+	},
+	{
+		name: "listener induction response",
+		packet: "80000000 00000000 00000000 2b6a7d13" +
+			"00000005 00004a17 1a2b3c4d 000005dc 00002000 00000001 2b6a7d13 5cfd7a3e" +
+			"0100007f 00000000 00000000 00000000",
+		want: handshake{
+			version: 5, extField: magic, isn: 0x1a2b3c4d, mtu: 1500, window: 8192,
+			typ: hsInduction, socket: 0x2b6a7d13, cookie: 0x5cfd7a3e,
+		},
+		dest: 0x2b6a7d13,
+	},
+	{
+		name: "caller conclusion",
+		packet: "80000000 00000000 0001a00b 00000000" +
+			"00000005 00000005 1a2b3c4d 000005dc 00002000 ffffffff 2b6a7d13 5cfd7a3e" +
+			"0100007f 00000000 00000000 00000000" +
+			"00010003 00010503 000000bf 00780078" + // HSREQ
+			"00050005 3a3a2123 696c3d72 6d2c6576 6275703d 6873696c", // SID
+		want: handshake{
+			version: 5, extField: hsFlagReq | hsFlagCfg, isn: 0x1a2b3c4d, mtu: 1500, window: 8192,
+			typ: hsConclusion, socket: 0x2b6a7d13, cookie: 0x5cfd7a3e,
+			extOrder: []uint16{extHSReq, extStreamID},
+		},
+	},
+	{
+		name: "listener conclusion response",
+		packet: "80000000 00000000 00000000 2b6a7d13" +
+			"00000005 00000001 1a2b3c4d 000005dc 00002000 ffffffff 1f3e5d7c 5cfd7a3e" +
+			"0100007f 00000000 00000000 00000000" +
+			"00020003 00010503 000000bf 00780078", // HSRSP
+		want: handshake{
+			version: 5, extField: hsFlagReq, isn: 0x1a2b3c4d, mtu: 1500, window: 8192,
+			typ: hsConclusion, socket: 0x1f3e5d7c, cookie: 0x5cfd7a3e,
+			extOrder: []uint16{extHSRsp},
+		},
+		dest: 0x2b6a7d13,
+	},
+	{
+		name: "listener rejection",
+		packet: "80000000 00000000 00000000 2b6a7d13" +
+			"00000005 00000000 1a2b3c4d 000005dc 00002000 000003ea 00000000 5cfd7a3e" +
+			"0100007f 00000000 00000000 00000000",
+		want: handshake{
+			version: 5, isn: 0x1a2b3c4d, mtu: 1500, window: 8192,
+			typ: hsRejectBase + rejectPeer, cookie: 0x5cfd7a3e,
+		},
+		dest: 0x2b6a7d13,
+	},
+}
+
+func TestHandshakeEncoding(t *testing.T) {
+	for _, tt := range libsrtHandshakes {
+		t.Run(tt.name, func(t *testing.T) {
+			pkt, err := hex.DecodeString(strings.ReplaceAll(tt.packet, " ", ""))
+			if err != nil {
+				t.Fatal(err)
+			}
+			h, ok := parseHeader(pkt)
+			if !ok || !h.control || h.typ != ctrlHandshake || h.dest != tt.dest {
+				t.Fatalf("header = %+v, want a handshake to %08x", h, tt.dest)
+			}
+			hs, ok := parseHandshake(pkt[headerSize:])
+			if !ok {
+				t.Fatal("the handshake didn't parse")
+			}
+			got := hs
+			got.peerIP, got.ext = [16]byte{}, nil
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parsed\n%+v, want\n%+v", got, tt.want)
+			}
+			if ip := ipField(netip.MustParseAddr("127.0.0.1")); hs.peerIP != ip {
+				t.Errorf("peer IP = % x, want % x", hs.peerIP, ip)
+			}
+
+			// What the handshake is marshalled to is what libsrt sent
+			out := hs.marshal(h.marshal(nil))
+			if !bytes.Equal(out, pkt) {
+				t.Errorf("marshalled to\n% x, want\n% x", out, pkt)
+			}
+		})
+	}
+}
+
+func TestHandshakeExtensions(t *testing.T) {
+	conclusion, _ := hex.DecodeString(strings.ReplaceAll(libsrtHandshakes[2].packet, " ", ""))
+	hs, _ := parseHandshake(conclusion[headerSize:])
+	if got, want := decodeStreamID(hs.ext[extStreamID]), "#!::r=live,m=publish"; got != want {
+		t.Errorf("stream ID = %q, want %q", got, want)
+	}
+	if got := peerLatency(hs.ext[extHSReq]); got != 120*time.Millisecond {
+		t.Errorf("latency = %v, want 120ms", got)
+	}
+	if got, want := encodeStreamID("#!::r=live,m=publish"), hs.ext[extStreamID]; !bytes.Equal(got, want) {
+		t.Errorf("stream ID encoded as % x, want % x", got, want)
+	}
+
+	// Ours is HSREQ as libsrt's, but for the version and the flags: libsrt
+	// also offers encryption and packet filters
+	ext := hsExt(120 * time.Millisecond)
+	if want := hs.ext[extHSReq]; !bytes.Equal(ext[8:], want[8:]) {
+		t.Errorf("latencies encoded as % x, want % x", ext[8:], want[8:])
+	}
+	if got := peerLatency(hsExt(250 * time.Millisecond)); got != 250*time.Millisecond {
+		t.Errorf("latency = %v, want 250ms", got)
+	}
+
+	// A stream ID that isn't a whole number of words is padded with zeros
+	for _, id := range []string{"a", "ab", "abc", "abcd", "abcde"} {
+		v := encodeStreamID(id)
+		if len(v)%4 != 0 {
+			t.Errorf("%q encoded in %d bytes", id, len(v))
+		}
+		if got := decodeStreamID(v); got != id {
+			t.Errorf("%q decoded as %q", id, got)
+		}
+	}
+}
+
+func TestIPField(t *testing.T) {
+	for _, tt := range []struct {
+		addr string
+		want string
+	}{
+		{"127.0.0.1", "0100007f 00000000 00000000 00000000"},
+		{"::ffff:192.0.2.7", "070200c0 00000000 00000000 00000000"},
+		{"2001:db8::7", "b80d0120 00000000 00000000 07000000"},
+	} {
+		want, _ := hex.DecodeString(strings.ReplaceAll(tt.want, " ", ""))
+		if got := ipField(netip.MustParseAddr(tt.addr)); !bytes.Equal(got[:], want) {
+			t.Errorf("ipField(%s) = % x, want % x", tt.addr, got, want)
+		}
+	}
+}
@@ -0,0 +1,222 @@
+package stun
+
+import (
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/netip"
+)
+
+const (
+	headerSize  = 20
+	magicCookie = 0x2112A442
+
+	// The class bits of a message type, between those of its method
+	classMask    = 0x0110
+	classSuccess = 0x0100
+	classError   = 0x0110
+)
+
+// Attributes
+const (
+	attrMappedAddress    = 0x0001
+	AttrUsername         = 0x0006
+	attrMessageIntegrity = 0x0008
+	AttrErrorCode        = 0x0009
+	AttrRealm            = 0x0014
+	AttrNonce            = 0x0015
+	AttrXORMappedAddress = 0x0020
+	integritySize        = 4 + sha1.Size
+)
+
+// Error codes asking a request to be sent again
+const (
+	CodeUnauthorized = 401 // With credentials, from the realm and nonce of the response
+	CodeStaleNonce   = 438 // With the new nonce of the response
+)
+
+// TxID is the transaction ID pairing a request with its response.
+type TxID [12]byte
+
+// NewID returns a random transaction ID.
+func NewID() TxID {
+	var id TxID
+	rand.Read(id[:])
+	return id
+}
+
+// Attr is an attribute of a message.
+type Attr struct {
+	Type  uint16
+	Value []byte
+}
+
+// Build encodes a message of type typ with attrs, followed by a
+// MESSAGE-INTEGRITY signed with key unless it is nil (see LongTermKey).
+func Build(typ uint16, id TxID, attrs []Attr, key []byte) []byte {
+	b := make([]byte, headerSize, 256)
+	binary.BigEndian.PutUint16(b[0:], typ)
+	binary.BigEndian.PutUint32(b[4:], magicCookie)
+	copy(b[8:], id[:])
+	for _, a := range attrs {
+		b = binary.BigEndian.AppendUint16(b, a.Type)
+		b = binary.BigEndian.AppendUint16(b, uint16(len(a.Value)))
+		b = append(b, a.Value...)
+		b = append(b, make([]byte, (4-len(a.Value)%4)%4)...) // Padded to 32 bits
+	}
+	if key != nil {
+		// The integrity covers the header with the length it ends at
+		binary.BigEndian.PutUint16(b[2:], uint16(len(b)-headerSize+integritySize))
+		mac := hmac.New(sha1.New, key)
+		mac.Write(b)
+		b = binary.BigEndian.AppendUint16(b, attrMessageIntegrity)
+		b = binary.BigEndian.AppendUint16(b, sha1.Size)
+		b = mac.Sum(b)
+	}
+	binary.BigEndian.PutUint16(b[2:], uint16(len(b)-headerSize))
+	return b
+}
+
+// Verify reports whether the MESSAGE-INTEGRITY of the message b is signed
+// with key.
+func Verify(b []byte, key []byte) bool {
+	end := headerSize + int(binary.BigEndian.Uint16(b[2:]))
+	for off := headerSize; off+4 <= min(end, len(b)); {
+		t, n := binary.BigEndian.Uint16(b[off:]), int(binary.BigEndian.Uint16(b[off+2:]))
+		if t == attrMessageIntegrity {
+			if n != sha1.Size || off+integritySize > len(b) {
+				return false
+			}
+			signed := append([]byte(nil), b[:off]...)
+			binary.BigEndian.PutUint16(signed[2:], uint16(off-headerSize+integritySize))
+			mac := hmac.New(sha1.New, key)
+			mac.Write(signed)
+			return hmac.Equal(mac.Sum(nil), b[off+4:off+integritySize])
+		}
+		off += 4 + (n+3)&^3
+	}
+	return false
+}
+
+// LongTermKey is the key of long-term credentials (RFC 8489 section
+// 9.2.2), which TURN servers ask for.
+func LongTermKey(username, realm, password string) []byte {
+	sum := md5.Sum([]byte(username + ":" + realm + ":" + password))
+	return sum[:]
+}
+
+// IsMessage reports whether b is a STUN message rather than RTP or RTCP.
+func IsMessage(b []byte) bool {
+	return len(b) >= headerSize && b[0]&0xc0 == 0 && binary.BigEndian.Uint32(b[4:]) == magicCookie
+}
+
+// Type returns the type of the message b.
+func Type(b []byte) uint16 {
+	return binary.BigEndian.Uint16(b)
+}
+
+// IsSuccess and IsError report the class of the response b.
+func IsSuccess(b []byte) bool { return Type(b)&classMask == classSuccess }
+func IsError(b []byte) bool   { return Type(b)&classMask == classError }
+
+// ID returns the transaction ID of the message b.
+func ID(b []byte) TxID {
+	var id TxID
+	copy(id[:], b[8:headerSize])
+	return id
+}
+
+// Attrs returns the attributes of the message b by type, the first of each
+// type.
+func Attrs(b []byte) map[uint16][]byte {
+	attrs := make(map[uint16][]byte)
+	rest := b[headerSize:min(len(b), headerSize+int(binary.BigEndian.Uint16(b[2:])))]
+	for len(rest) >= 4 {
+		t, n := binary.BigEndian.Uint16(rest), int(binary.BigEndian.Uint16(rest[2:]))
+		if 4+n > len(rest) {
+			break
+		}
+		if _, ok := attrs[t]; !ok {
+			attrs[t] = rest[4 : 4+n]
+		}
+		next := 4 + (n+3)&^3
+		if next > len(rest) {
+			break
+		}
+		rest = rest[next:]
+	}
+	return attrs
+}
+
+// ErrorCode returns the code of the error response b, 0 if it has none.
+func ErrorCode(b []byte) int {
+	v := Attrs(b)[AttrErrorCode]
+	if len(v) < 4 {
+		return 0
+	}
+	return int(v[2]&7)*100 + int(v[3])
+}
+
+// Error returns the error of the error response b.
+func Error(b []byte) error {
+	v := Attrs(b)[AttrErrorCode]
+	if len(v) < 4 {
+		return errors.New("STUN error without a code")
+	}
+	return fmt.Errorf("STUN error %d: %s", ErrorCode(b), v[4:])
+}
+
+// XORAddr encodes addr as the value of an XOR-MAPPED-ADDRESS, or another
+// XOR-…-ADDRESS, of transaction id.
+func XORAddr(addr netip.AddrPort, id TxID) []byte {
+	ip := addr.Addr().Unmap()
+	v := make([]byte, 4, 20)
+	v[1] = 1
+	if ip.Is6() {
+		v[1] = 2
+	}
+	binary.BigEndian.PutUint16(v[2:], addr.Port()^magicCookie>>16)
+	key := xorKey(id)
+	for i, b := range ip.AsSlice() {
+		v = append(v, b^key[i])
+	}
+	return v
+}
+
+// ParseXORAddr decodes the value of an XOR-…-ADDRESS of transaction id.
+func ParseXORAddr(v []byte, id TxID) (netip.AddrPort, bool) {
+	addr, ok := parseAddr(v)
+	if !ok {
+		return netip.AddrPort{}, false
+	}
+	key := xorKey(id)
+	raw := addr.Addr().AsSlice()
+	for i := range raw {
+		raw[i] ^= key[i]
+	}
+	ip, _ := netip.AddrFromSlice(raw)
+	return netip.AddrPortFrom(ip, addr.Port()^magicCookie>>16), true
+}
+
+// xorKey is what addresses are XORed with: the cookie, then the ID.
+func xorKey(id TxID) []byte {
+	key := binary.BigEndian.AppendUint32(nil, magicCookie)
+	return append(key, id[:]...)
+}
+
+// parseAddr decodes the value of a MAPPED-ADDRESS.
+func parseAddr(v []byte) (netip.AddrPort, bool) {
+	if len(v) < 8 {
+		return netip.AddrPort{}, false
+	}
+	raw := v[4:]
+	if family := v[1]; !(family == 1 && len(raw) == 4 || family == 2 && len(raw) == 16) {
+		return netip.AddrPort{}, false
+	}
+	ip, _ := netip.AddrFromSlice(raw)
+	return netip.AddrPortFrom(ip, binary.BigEndian.Uint16(v[2:])), true
+}
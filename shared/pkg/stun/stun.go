@@ -0,0 +1,147 @@
+// Package stun speaks the little of STUN (RFC 8489) needed to traverse a
+// NAT, the same way in the client and the server: a Binding request sent to
+// a STUN server, such as stun.l.google.com:19302, is answered with the
+// address and port it arrived from, the reflexive address the NAT gives the
+// socket that sent it. A program behind a NAT learns where others can reach
+// it, and keeps the NAT's mapping open by asking again. Build and Attrs
+// make and read other messages, such as those of TURN.
+//
+// STUN messages can share a port with RTP and RTCP, as their first byte
+// tells them apart (RFC 7983): 0 to 3 for STUN, 128 to 191 for RTP.
+package stun
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"time"
+)
+
+// Binding messages
+const (
+	BindingRequest  = 0x0001
+	BindingResponse = 0x0101
+	BindingError    = 0x0111
+)
+
+const (
+	// An unanswered request is sent again after 500 ms, then after twice as
+	// long each time, as RFC 8489 does, giving up after 7.5 s
+	firstRetransmit = 500 * time.Millisecond
+	attempts        = 4
+)
+
+// Request returns a new Binding request and its transaction ID.
+func Request() ([]byte, TxID) {
+	id := NewID()
+	return Build(BindingRequest, id, nil, nil), id
+}
+
+// IsRequest reports whether the STUN message b is a Binding request.
+func IsRequest(b []byte) bool {
+	return Type(b) == BindingRequest
+}
+
+// Response answers the Binding request req, which came from from, with
+// from as its XOR-MAPPED-ADDRESS.
+func Response(req []byte, from netip.AddrPort) []byte {
+	id := ID(req)
+	return Build(BindingResponse, id, []Attr{{AttrXORMappedAddress, XORAddr(from, id)}}, nil)
+}
+
+// Parse returns the reflexive address of the Binding response b, or the
+// error a STUN server answered with.
+func Parse(b []byte) (netip.AddrPort, error) {
+	switch typ := Type(b); typ {
+	case BindingResponse:
+	case BindingError:
+		return netip.AddrPort{}, Error(b)
+	default:
+		return netip.AddrPort{}, fmt.Errorf("unexpected STUN message type %#04x", typ)
+	}
+	attrs := Attrs(b)
+	if addr, ok := ParseXORAddr(attrs[AttrXORMappedAddress], ID(b)); ok {
+		return addr, nil
+	}
+	// Only from servers of RFC 3489, which predates the XOR
+	if addr, ok := parseAddr(attrs[attrMappedAddress]); ok {
+		return addr, nil
+	}
+	return netip.AddrPort{}, errors.New("STUN response without a mapped address")
+}
+
+// Discover asks server, a host:port resolved for network (udp, udp4 or
+// udp6), for the reflexive address of conn, which no one else reads from
+// meanwhile. The request is sent again while unanswered, four times in all,
+// unless ctx is done first.
+func Discover(ctx context.Context, conn net.PacketConn, network, server string) (netip.AddrPort, error) {
+	to, err := net.ResolveUDPAddr(network, server)
+	if err != nil {
+		return netip.AddrPort{}, err
+	}
+	req, id := Request()
+	b, err := Transact(ctx, unconnected{conn, to}, req, id)
+	if err != nil {
+		return netip.AddrPort{}, fmt.Errorf("no answer from STUN server %s: %w", server, err)
+	}
+	return Parse(b)
+}
+
+// Conn is a connected datagram socket, such as a net.Conn from dialing UDP.
+type Conn interface {
+	Write(b []byte) (int, error)
+	Read(b []byte) (int, error)
+	SetReadDeadline(t time.Time) error
+}
+
+// unconnected sends on a socket that isn't connected to to.
+type unconnected struct {
+	net.PacketConn
+	to net.Addr
+}
+
+func (c unconnected) Write(b []byte) (int, error) { return c.WriteTo(b, c.to) }
+
+func (c unconnected) Read(b []byte) (int, error) {
+	n, _, err := c.ReadFrom(b)
+	return n, err
+}
+
+// Transact sends the request req, of transaction id, on conn, which no one
+// else reads from meanwhile, and returns the response, successful or not.
+// The request is sent again while unanswered, as by Discover, unless ctx is
+// done first; what else arrives is skipped.
+func Transact(ctx context.Context, conn Conn, req []byte, id TxID) ([]byte, error) {
+	defer conn.SetReadDeadline(time.Time{})
+	buf := make([]byte, 1500)
+	wait := firstRetransmit
+	for i := 0; i < attempts; i++ {
+		if _, err := conn.Write(req); err != nil {
+			return nil, err
+		}
+		deadline := time.Now().Add(wait)
+		if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+			deadline = d
+		}
+		conn.SetReadDeadline(deadline)
+		for {
+			n, err := conn.Read(buf)
+			if err != nil {
+				if ctx.Err() != nil {
+					return nil, ctx.Err()
+				}
+				if ne, ok := err.(net.Error); !ok || !ne.Timeout() {
+					return nil, err
+				}
+				break // Sent again
+			}
+			if b := buf[:n]; IsMessage(b) && (IsSuccess(b) || IsError(b)) && ID(b) == id {
+				return b, nil
+			}
+		}
+		wait *= 2
+	}
+	return nil, errors.New("timed out")
+}